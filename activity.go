@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	activityLogin       = "user.login"
+	activityLoginFailed = "user.login-failed"
+
+	// maxActivities bounds the feed; the oldest entries are dropped first.
+	maxActivities = 10000
+	// loginGap is how long a user must have been away for their next
+	// request to count as signing in again. Failed sign-ins under one name
+	// are recorded at most once per gap.
+	loginGap = 30 * time.Minute
+)
+
+// activity is an entry in the feed of what happened on the server: plays,
+// albums added, scans, catalog edits and sign-ins. Edits have the kind of
+// their logged operation, such as album.update. User is who did it, when
+// known.
+type activity struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	User        string    `json:"user,omitempty"`
+	At          time.Time `json:"at"`
+	AlbumID     string    `json:"albumId,omitempty"`
+	TrackID     string    `json:"trackId,omitempty"`
+	OperationID string    `json:"operationId,omitempty"`
+	Summary     string    `json:"summary"`
+}
+
+// activities is the feed, in the order entries were recorded. activityMu is
+// taken after albumsMu and opsMu.
+var (
+	activityMu   sync.RWMutex
+	activities   []activity
+	lastSignIns  = map[string]time.Time{}
+	lastFailures = map[string]time.Time{}
+)
+
+func init() {
+	onTrackPlayed(func(p trackPlay) {
+		recordActivity(activity{Kind: hookTrackPlayed, User: p.User, At: p.PlayedAt, AlbumID: p.Track.AlbumID, TrackID: p.Track.ID, Summary: byArtist(p.Track.Title, p.Track.Artist)})
+	})
+	onAlbumCreated(func(a album) {
+		recordActivity(activity{Kind: hookAlbumCreated, AlbumID: a.ID, Summary: byArtist(a.Title, a.Artist)})
+	})
+	onScanComplete(func(r importResult) {
+		recordActivity(activity{Kind: hookScanComplete, Summary: fmt.Sprintf("%d tracks added, %d new albums, %d skipped", r.TracksAdded, r.AlbumsCreated, r.TracksSkipped)})
+	})
+}
+
+func byArtist(title, artist string) string {
+	if artist == "" {
+		return title
+	}
+	return title + " by " + artist
+}
+
+// recordActivity adds an entry to the feed, giving it an id and, when it
+// has none, the current time.
+func recordActivity(a activity) {
+	a.ID = newID()
+	if a.At.IsZero() {
+		a.At = time.Now()
+	}
+	a.At = a.At.UTC()
+
+	activityMu.Lock()
+	defer activityMu.Unlock()
+
+	activities = append(activities, a)
+	if len(activities) > maxActivities {
+		activities = append([]activity(nil), activities[len(activities)-maxActivities:]...)
+	}
+}
+
+// recordOperation adds a logged edit to the feed.
+func recordOperation(op *operation) {
+	a := activity{Kind: op.Kind, User: op.User, At: op.At, OperationID: op.ID}
+	switch {
+	case len(op.Albums) == 1 && len(op.Tracks) == 0:
+		v := op.Albums[0]
+		if v.After != nil {
+			a.AlbumID, a.Summary = v.After.ID, byArtist(v.After.Title, v.After.Artist)
+		} else {
+			a.AlbumID, a.Summary = v.Before.ID, byArtist(v.Before.Title, v.Before.Artist)
+		}
+	case len(op.Tracks) == 1 && len(op.Albums) == 0:
+		v := op.Tracks[0]
+		if v.After != nil {
+			a.AlbumID, a.TrackID, a.Summary = v.After.AlbumID, v.After.ID, byArtist(v.After.Title, v.After.Artist)
+		} else {
+			a.AlbumID, a.TrackID, a.Summary = v.Before.AlbumID, v.Before.ID, byArtist(v.Before.Title, v.Before.Artist)
+		}
+	default:
+		a.Summary = fmt.Sprintf("%d albums and %d tracks", len(op.Albums), len(op.Tracks))
+	}
+	recordActivity(a)
+}
+
+// recordSignIn records a successful or failed sign-in as user, unless one
+// was recorded less than loginGap ago.
+func recordSignIn(user string, ok bool, now time.Time) {
+	kind, last, summary := activityLogin, lastSignIns, "signed in"
+	if !ok {
+		kind, last, summary = activityLoginFailed, lastFailures, "wrong password"
+	}
+
+	activityMu.Lock()
+	seen, known := last[user]
+	last[user] = now
+	activityMu.Unlock()
+
+	if !known || now.Sub(seen) >= loginGap {
+		recordActivity(activity{Kind: kind, User: user, At: now, Summary: summary})
+	}
+}
+
+// findActivity returns the index of the entry with the given id, or -1.
+// Callers must hold activityMu.
+func findActivity(id string) int {
+	for i, a := range activities {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// activityFilters builds the filters of a feed request: ?kind= takes a
+// comma-separated list of kinds, each of which may end in * to match a
+// prefix, ?user=, ?albumId= and ?trackId= match exactly, and ?since= and
+// ?until= bound the time, since included and until not.
+func activityFilters(c *gin.Context) ([]func(activity) bool, error) {
+	var filters []func(activity) bool
+	if v := c.Query("kind"); v != "" {
+		kinds := strings.Split(v, ",")
+		filters = append(filters, func(a activity) bool {
+			for _, kind := range kinds {
+				kind = strings.TrimSpace(kind)
+				if prefix, ok := strings.CutSuffix(kind, "*"); ok && strings.HasPrefix(a.Kind, prefix) || kind == a.Kind {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	for _, field := range []struct {
+		param string
+		value func(activity) string
+	}{
+		{"user", func(a activity) string { return a.User }},
+		{"albumId", func(a activity) string { return a.AlbumID }},
+		{"trackId", func(a activity) string { return a.TrackID }},
+	} {
+		if v := c.Query(field.param); v != "" {
+			value := field.value
+			filters = append(filters, func(a activity) bool { return value(a) == v })
+		}
+	}
+	for _, param := range []string{"since", "until"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time", param)
+		}
+		until := param == "until"
+		filters = append(filters, func(a activity) bool { return a.At.Before(t) == until })
+	}
+	return filters, nil
+}
+
+// getActivity returns the handler listing the feed, oldest first, or
+// newest first with ?order=newest. Admins see everything; other users see
+// their own entries and those about the library as a whole. Clients
+// following the feed, such as webhook relays, pass the id of the last
+// entry they saw as ?after= and get only what was recorded since, in
+// order. An id that is no longer in the feed is refused, so gaps are
+// noticed.
+func getActivity(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.GetString(gin.AuthUserKey)
+		filters, err := activityFilters(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		newest := false
+		switch c.Query("order") {
+		case "", "oldest":
+		case "newest":
+			newest = true
+		default:
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "order must be oldest or newest"})
+			return
+		}
+		limit, offset, ok := pageParams(c)
+		if !ok {
+			return
+		}
+
+		activityMu.RLock()
+		feed := activities
+		if newest {
+			feed = make([]activity, len(activities))
+			for i, a := range activities {
+				feed[len(feed)-1-i] = a
+			}
+		}
+		position := func(id string) int {
+			i := findActivity(id)
+			if newest && i >= 0 {
+				i = len(feed) - 1 - i
+			}
+			return i
+		}
+		page, ok := keysetPage(c, feed, func(a activity) string { return a.ID }, func(a activity) bool {
+			if !cfg.Admins[user] && a.User != "" && a.User != user {
+				return false
+			}
+			for _, f := range filters {
+				if !f(a) {
+					return false
+				}
+			}
+			return true
+		}, position, limit, offset)
+		activityMu.RUnlock()
+
+		if ok {
+			respondList(c, page)
+		}
+	}
+}
+
+func dumpActivity() ([]byte, error) {
+	activityMu.RLock()
+	defer activityMu.RUnlock()
+
+	return json.MarshalIndent(activities, "", "    ")
+}
+
+func restoreActivity(data []byte) (func(), int, error) {
+	var restored []activity
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		activityMu.Lock()
+		activities = restored
+		activityMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetActivity empties the feed and the sign-ins seen until the test
+// finishes.
+func resetActivity(t *testing.T) {
+	flushHooks()
+	activityMu.Lock()
+	saved, savedSignIns, savedFailures := activities, lastSignIns, lastFailures
+	activities, lastSignIns, lastFailures = nil, map[string]time.Time{}, map[string]time.Time{}
+	activityMu.Unlock()
+	t.Cleanup(func() {
+		flushHooks()
+		activityMu.Lock()
+		activities, lastSignIns, lastFailures = saved, savedSignIns, savedFailures
+		activityMu.Unlock()
+	})
+}
+
+func activityKinds(rr *httptest.ResponseRecorder) []string {
+	var feed []activity
+	json.Unmarshal(rr.Body.Bytes(), &feed)
+	kinds := []string{}
+	for _, a := range feed {
+		kinds = append(kinds, a.Kind+" "+a.User)
+	}
+	return kinds
+}
+
+func TestSignIns(t *testing.T) {
+	resetActivity(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Check requests close together are one sign-in, and failures are
+	// recorded once per gap
+	recordSignIn("bob", true, start)
+	recordSignIn("bob", true, start.Add(10*time.Minute))
+	recordSignIn("bob", true, start.Add(50*time.Minute))
+	recordSignIn("mallory", false, start)
+	recordSignIn("mallory", false, start.Add(time.Minute))
+	var kinds []string
+	for _, a := range activities {
+		kinds = append(kinds, a.Kind+" "+a.User)
+	}
+	want := "user.login bob,user.login bob,user.login-failed mallory"
+	if strings.Join(kinds, ",") != want {
+		t.Errorf("Expected %s, but got %s", want, strings.Join(kinds, ","))
+	}
+}
+
+func TestActivity(t *testing.T) {
+	resetCatalog(t)
+	resetActivity(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "kob", Title: "Kind of Blue", Artist: "Miles Davis"}}
+	tracks = []track{{ID: "1", AlbumID: "kob", Title: "So What", Artist: "Miles Davis"}}
+
+	// Record a play, an edit, an album added and a wrong password
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1"}`), "bob")
+	flushHooks()
+	performRequest(router, "PATCH", "/albums/kob", strings.NewReader(`{"year": 1959}`), "alice")
+	performRequest(router, "POST", "/albums", strings.NewReader(`{"title": "Milestones", "artist": "Miles Davis"}`), "")
+	flushHooks()
+	req, _ := http.NewRequest("GET", "/activity", nil)
+	req.SetBasicAuth("bob", "guess")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Check admins see everything, in order
+	rr := performRequest(router, "GET", "/activity", nil, "alice")
+	want := "user.login bob,track.played bob,user.login alice,album.update alice,album.created ,user.login-failed bob"
+	if got := strings.Join(activityKinds(rr), ","); got != want {
+		t.Errorf("Expected %s, but got %s", want, got)
+	}
+	var feed []activity
+	json.Unmarshal(rr.Body.Bytes(), &feed)
+	if feed[3].AlbumID != "kob" || feed[3].OperationID == "" || feed[3].Summary != "Kind of Blue by Miles Davis" {
+		t.Errorf("Expected the edit of Kind of Blue, but got %+v", feed[3])
+	}
+
+	// Check other users see only their own entries and the library's
+	rr = performRequest(router, "GET", "/activity?order=newest", nil, "bob")
+	want = "user.login-failed bob,album.created ,track.played bob,user.login bob"
+	if got := strings.Join(activityKinds(rr), ","); got != want {
+		t.Errorf("Expected %s, but got %s", want, got)
+	}
+
+	// Check filters and following the feed from an entry
+	rr = performRequest(router, "GET", "/activity?kind=user.*,album.created&user=alice", nil, "alice")
+	if got := strings.Join(activityKinds(rr), ","); got != "user.login alice" {
+		t.Errorf("Expected alice's sign-in, but got %s", got)
+	}
+	rr = performRequest(router, "GET", "/activity?after="+feed[3].ID, nil, "alice")
+	if got := strings.Join(activityKinds(rr), ","); got != "album.created ,user.login-failed bob" {
+		t.Errorf("Expected the two later entries, but got %s", got)
+	}
+	rr = performRequest(router, "GET", "/activity?since="+feed[0].At.Add(time.Hour).Format(time.RFC3339), nil, "alice")
+	if got := activityKinds(rr); len(got) != 0 {
+		t.Errorf("Expected nothing in the future, but got %s", got)
+	}
+
+	// Check bad filters and forgotten entries are refused
+	for _, query := range []string{"since=yesterday", "order=random", "after=gone"} {
+		if rr := performRequest(router, "GET", "/activity?"+query, nil, "alice"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
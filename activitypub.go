@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apContentType = "application/activity+json"
+	apPublic      = "https://www.w3.org/ns/activitystreams#Public"
+	// apLibraryActor posts the albums added to the library. No user can
+	// take its name.
+	apLibraryActor = "library"
+	// maxAPPosts bounds the outbox kept per actor.
+	maxAPPosts = 200
+	// apListenDelay is how old a listen may be and still be published, so
+	// a client syncing plays made offline does not flood followers.
+	apListenDelay = time.Hour
+)
+
+// apFollower is a remote account following one of our actors.
+type apFollower struct {
+	Actor      string    `json:"actor"`
+	Inbox      string    `json:"inbox"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
+// apPost is a note published by one of our actors.
+type apPost struct {
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	Published time.Time `json:"published"`
+}
+
+// apKey signs everything our actors send; ActivityPub is off while it is
+// nil. apUsers holds who opted in to publishing their listens, apFollowers
+// and apOutbox are keyed by actor name; all three are guarded by albumsMu.
+var (
+	apKey       *rsa.PrivateKey
+	apUsers     = map[string]bool{}
+	apFollowers = map[string][]apFollower{}
+	apOutbox    = map[string][]apPost{}
+	apClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// loadAPKey reads the actors' RSA key from path, creating it if the file
+// does not exist. An empty path gives a key that only lasts until restart.
+func loadAPKey(path string) (*rsa.PrivateKey, error) {
+	if path != "" {
+		if raw, err := os.ReadFile(path); err == nil {
+			block, _ := pem.Decode(raw)
+			if block == nil {
+				return nil, fmt.Errorf("%s: no PEM key", path)
+			}
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			key, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: not an RSA key", path)
+			}
+			return key, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil || path == "" {
+		return key, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// apHost is the domain in our actors' handles.
+func apHost() string {
+	return strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(publicURL, "/"), "https://"), "http://")
+}
+
+func apActorURL(name string) string {
+	return strings.TrimSuffix(publicURL, "/") + "/ap/actors/" + url.PathEscape(name)
+}
+
+// apActorExists reports whether name is a published actor. Callers must
+// hold albumsMu.
+func apActorExists(name string) bool {
+	return apKey != nil && (name == apLibraryActor || apUsers[name])
+}
+
+// apPublish adds a note to an actor's outbox and sends it to the actor's
+// followers. Callers must hold albumsMu.
+func apPublish(actor, content string, at time.Time) {
+	if !apActorExists(actor) {
+		return
+	}
+	post := apPost{ID: newID(), Content: content, Published: at.UTC()}
+	outbox := append(apOutbox[actor], post)
+	if len(outbox) > maxAPPosts {
+		outbox = append([]apPost(nil), outbox[len(outbox)-maxAPPosts:]...)
+	}
+	apOutbox[actor] = outbox
+
+	inboxes := map[string]bool{}
+	for _, f := range apFollowers[actor] {
+		inboxes[f.Inbox] = true
+	}
+	activity := apCreate(actor, post)
+	for inbox := range inboxes {
+		go func(inbox string) {
+			if err := apDeliver(context.Background(), actor, inbox, activity); err != nil {
+				log.Printf("activitypub: delivering to %s: %v", inbox, err)
+			}
+		}(inbox)
+	}
+}
+
+// apPublishListen posts a listen to the user's actor when they opted in.
+// Callers must hold albumsMu.
+func apPublishListen(user, trackID string, at time.Time) {
+	if !apUsers[user] || time.Since(at) > apListenDelay {
+		return
+	}
+	i := findTrack(trackID)
+	if i < 0 {
+		return
+	}
+	t := tracks[i]
+	content := "Listened to <b>" + html.EscapeString(t.Title) + "</b>"
+	if t.Artist != "" {
+		content += " by " + html.EscapeString(t.Artist)
+	}
+	if j := findAlbum(t.AlbumID); j >= 0 {
+		content += " from <i>" + html.EscapeString(albums[j].Title) + "</i>"
+	}
+	apPublish(user, "<p>"+content+"</p>", at)
+}
+
+// apPublishAlbum posts a new album from the library actor. Callers must
+// hold albumsMu.
+func apPublishAlbum(a album) {
+	content := "New in the library: <b>" + html.EscapeString(a.Title) + "</b>"
+	if a.Artist != "" {
+		content += " by " + html.EscapeString(a.Artist)
+	}
+	if a.Year > 0 {
+		content += fmt.Sprintf(" (%d)", a.Year)
+	}
+	apPublish(apLibraryActor, "<p>"+content+"</p>", time.Now())
+}
+
+func apNote(actor string, p apPost) gin.H {
+	id := apActorURL(actor)
+	return gin.H{
+		"id":           id + "/notes/" + p.ID,
+		"type":         "Note",
+		"attributedTo": id,
+		"content":      p.Content,
+		"published":    p.Published.Format(time.RFC3339),
+		"to":           []string{apPublic},
+		"cc":           []string{id + "/followers"},
+	}
+}
+
+func apCreate(actor string, p apPost) gin.H {
+	note := apNote(actor, p)
+	return gin.H{
+		"@context":  "https://www.w3.org/ns/activitystreams",
+		"id":        note["id"].(string) + "/activity",
+		"type":      "Create",
+		"actor":     note["attributedTo"],
+		"published": note["published"],
+		"to":        note["to"],
+		"cc":        note["cc"],
+		"object":    note,
+	}
+}
+
+// apSign adds an HTTP signature, as Mastodon expects, made with the key of
+// actor.
+func apSign(req *http.Request, actor string, body []byte) {
+	headers := "(request-target) host date"
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers += " digest"
+	}
+	hashed := sha256.Sum256([]byte(apSigningString(req, req.URL.Host, headers)))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, apKey, crypto.SHA256, hashed[:])
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		apActorURL(actor), headers, base64.StdEncoding.EncodeToString(sig)))
+}
+
+func apSigningString(req *http.Request, host, headers string) string {
+	var lines []string
+	for _, h := range strings.Fields(headers) {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(req.Method)+" "+req.URL.RequestURI())
+		case "host":
+			lines = append(lines, "host: "+host)
+		default:
+			lines = append(lines, h+": "+req.Header.Get(h))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// apDeliver posts an activity to a remote inbox.
+func apDeliver(ctx context.Context, actor, inbox string, activity any) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", apContentType)
+	apSign(req, actor, body)
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("inbox returned %s", resp.Status)
+	}
+	return nil
+}
+
+// apRemoteActor is the part of a remote actor document we use.
+type apRemoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// apFetchActor fetches a remote actor, signing the request as the library
+// actor for servers that require it.
+func apFetchActor(ctx context.Context, id string) (apRemoteActor, error) {
+	var remote apRemoteActor
+	if !isHTTPURL(id) {
+		return remote, fmt.Errorf("%q is not an HTTP URL", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return remote, err
+	}
+	req.Header.Set("Accept", apContentType)
+	apSign(req, apLibraryActor, nil)
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return remote, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return remote, fmt.Errorf("actor returned %s", resp.Status)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&remote)
+	return remote, err
+}
+
+// apSignedHeaders are the headers an inbox request must sign, so that a
+// signature can't be replayed against another inbox, host or time.
+var apSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// apVerify checks the HTTP signature and digest of an inbox request and
+// returns the actor that signed it.
+func apVerify(req *http.Request, body []byte) (apRemoteActor, error) {
+	params := map[string]string{}
+	for _, part := range strings.Split(req.Header.Get("Signature"), ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	headers := params["headers"]
+	if params["keyId"] == "" || params["signature"] == "" {
+		return apRemoteActor{}, errors.New("missing or incomplete signature")
+	}
+	for _, h := range apSignedHeaders {
+		if !strings.Contains(" "+headers+" ", " "+h+" ") {
+			return apRemoteActor{}, fmt.Errorf("signature does not cover %s", h)
+		}
+	}
+	sum := sha256.Sum256(body)
+	if req.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return apRemoteActor{}, errors.New("digest does not match the body")
+	}
+	if date, err := http.ParseTime(req.Header.Get("Date")); err != nil || time.Since(date).Abs() > 12*time.Hour {
+		return apRemoteActor{}, errors.New("date is missing or out of range")
+	}
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return apRemoteActor{}, err
+	}
+
+	keyActor, _, _ := strings.Cut(params["keyId"], "#")
+	remote, err := apFetchActor(req.Context(), keyActor)
+	if err != nil {
+		return remote, err
+	}
+	if remote.ID != keyActor {
+		return remote, errors.New("actor document is not the key's actor")
+	}
+	block, _ := pem.Decode([]byte(remote.PublicKey.PublicKeyPem))
+	if block == nil || remote.PublicKey.ID != params["keyId"] {
+		return remote, errors.New("actor has no such key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	key, ok := parsed.(*rsa.PublicKey)
+	if err != nil || !ok {
+		return remote, errors.New("actor key is not RSA")
+	}
+	hashed := sha256.Sum256([]byte(apSigningString(req, req.Host, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return remote, errors.New("signature does not verify")
+	}
+	return remote, nil
+}
+
+// getWebFinger resolves acct:name@host to an actor, as Mastodon does when
+// someone searches for one.
+func getWebFinger(c *gin.Context) {
+	host := apHost()
+	name, ok := strings.CutSuffix(strings.TrimPrefix(c.Query("resource"), "acct:"), "@"+host)
+
+	albumsMu.RLock()
+	exists := ok && apActorExists(name)
+	albumsMu.RUnlock()
+
+	if !exists {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "actor not found"})
+		return
+	}
+	c.Header("Content-Type", "application/jrd+json")
+	c.JSON(http.StatusOK, gin.H{
+		"subject": "acct:" + name + "@" + host,
+		"links":   []gin.H{{"rel": "self", "type": apContentType, "href": apActorURL(name)}},
+	})
+}
+
+// apActor looks up the actor named in the request, answering 404 when
+// there is none. It holds albumsMu for reading until done is called.
+func apActor(c *gin.Context) (name string, done func()) {
+	albumsMu.RLock()
+	name = c.Param("name")
+	if !apActorExists(name) {
+		albumsMu.RUnlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "actor not found"})
+		c.Abort()
+		return "", nil
+	}
+	return name, albumsMu.RUnlock
+}
+
+func apJSON(c *gin.Context, v any) {
+	raw, _ := json.Marshal(v)
+	c.Data(http.StatusOK, apContentType+"; charset=utf-8", raw)
+}
+
+func getAPActor(c *gin.Context) {
+	name, done := apActor(c)
+	if done == nil {
+		return
+	}
+	done()
+
+	der, _ := x509.MarshalPKIXPublicKey(&apKey.PublicKey)
+	id := apActorURL(name)
+	actor := gin.H{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                id,
+		"type":              "Person",
+		"preferredUsername": name,
+		"name":              name,
+		"summary":           "What " + name + " is listening to.",
+		"inbox":             id + "/inbox",
+		"outbox":            id + "/outbox",
+		"followers":         id + "/followers",
+		"url":               id,
+		"publicKey": gin.H{
+			"id":           id + "#main-key",
+			"owner":        id,
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	}
+	if name == apLibraryActor {
+		actor["type"], actor["name"], actor["summary"] = "Service", "Library", "Albums added to the library."
+	}
+	apJSON(c, actor)
+}
+
+// getAPOutbox lists an actor's posts, newest first.
+func getAPOutbox(c *gin.Context) {
+	name, done := apActor(c)
+	if done == nil {
+		return
+	}
+	posts := apOutbox[name]
+	items := make([]gin.H, 0, len(posts))
+	for i := len(posts) - 1; i >= 0; i-- {
+		items = append(items, apCreate(name, posts[i]))
+	}
+	done()
+
+	apJSON(c, gin.H{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           apActorURL(name) + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+// getAPFollowers gives only the number of followers; who they are is not
+// published.
+func getAPFollowers(c *gin.Context) {
+	name, done := apActor(c)
+	if done == nil {
+		return
+	}
+	count := len(apFollowers[name])
+	done()
+
+	apJSON(c, gin.H{
+		"@context":   "https://www.w3.org/ns/activitystreams",
+		"id":         apActorURL(name) + "/followers",
+		"type":       "OrderedCollection",
+		"totalItems": count,
+	})
+}
+
+func getAPNote(c *gin.Context) {
+	name, done := apActor(c)
+	if done == nil {
+		return
+	}
+	defer done()
+
+	for _, p := range apOutbox[name] {
+		if p.ID == c.Param("id") {
+			note := apNote(name, p)
+			note["@context"] = "https://www.w3.org/ns/activitystreams"
+			apJSON(c, note)
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "note not found"})
+}
+
+// postAPInbox takes Follow and Undo activities from other servers. Anything
+// else is accepted and ignored.
+func postAPInbox(c *gin.Context) {
+	name, done := apActor(c)
+	if done == nil {
+		return
+	}
+	done()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return
+	}
+	remote, err := apVerify(c.Request, body)
+	if err != nil {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	}
+	var activity struct {
+		Type   string          `json:"type"`
+		Actor  string          `json:"actor"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if activity.Actor != remote.ID {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": "activity is not signed by its actor"})
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		inbox := remote.Endpoints.SharedInbox
+		if inbox == "" {
+			inbox = remote.Inbox
+		}
+		if !isHTTPURL(inbox) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "actor has no inbox"})
+			return
+		}
+		albumsMu.Lock()
+		apRemoveFollower(name, remote.ID)
+		apFollowers[name] = append(apFollowers[name], apFollower{Actor: remote.ID, Inbox: inbox, FollowedAt: time.Now().UTC()})
+		albumsMu.Unlock()
+
+		accept := gin.H{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"id":       apActorURL(name) + "/accepts/" + newID(),
+			"type":     "Accept",
+			"actor":    apActorURL(name),
+			"object":   json.RawMessage(body),
+		}
+		go func() {
+			if err := apDeliver(context.Background(), name, remote.Inbox, accept); err != nil {
+				log.Printf("activitypub: accepting %s: %v", remote.ID, err)
+			}
+		}()
+	case "Undo":
+		var undone struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(activity.Object, &undone)
+		if undone.Type == "Follow" {
+			albumsMu.Lock()
+			apRemoveFollower(name, remote.ID)
+			albumsMu.Unlock()
+		}
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// apRemoveFollower drops a remote actor from an actor's followers.
+// Callers must hold albumsMu.
+func apRemoveFollower(name, actor string) {
+	list := apFollowers[name]
+	for i, f := range list {
+		if f.Actor == actor {
+			apFollowers[name] = append(list[:i:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+func apSettings(user string) gin.H {
+	host := apHost()
+	return gin.H{"enabled": apUsers[user], "handle": "@" + user + "@" + host, "actor": apActorURL(user), "followers": len(apFollowers[user])}
+}
+
+func getMyActivityPub(c *gin.Context) {
+	if apKey == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "ActivityPub is not enabled"})
+		return
+	}
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	c.IndentedJSON(http.StatusOK, apSettings(c.GetString(gin.AuthUserKey)))
+}
+
+// putMyActivityPub opts the user in or out of publishing their listens.
+// Followers are kept while publishing is off.
+func putMyActivityPub(c *gin.Context) {
+	var input struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	if apKey == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "ActivityPub is not enabled"})
+		return
+	}
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Enabled == nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "enabled is required"})
+		return
+	}
+	user := c.GetString(gin.AuthUserKey)
+	if user == apLibraryActor {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": "the name " + apLibraryActor + " is taken by the library actor"})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if *input.Enabled {
+		apUsers[user] = true
+	} else {
+		delete(apUsers, user)
+	}
+	c.IndentedJSON(http.StatusOK, apSettings(user))
+}
+
+// apState is how opt-ins, followers and posts are backed up.
+type apState struct {
+	Users     map[string]bool         `json:"users"`
+	Followers map[string][]apFollower `json:"followers"`
+	Outbox    map[string][]apPost     `json:"outbox"`
+}
+
+func dumpActivityPub() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(apState{Users: apUsers, Followers: apFollowers, Outbox: apOutbox}, "", "    ")
+}
+
+func restoreActivityPub(data []byte) (func(), int, error) {
+	var restored apState
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored.Users == nil {
+		restored.Users = map[string]bool{}
+	}
+	if restored.Followers == nil {
+		restored.Followers = map[string][]apFollower{}
+	}
+	if restored.Outbox == nil {
+		restored.Outbox = map[string][]apPost{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		apUsers, apFollowers, apOutbox = restored.Users, restored.Followers, restored.Outbox
+		albumsMu.Unlock()
+	}
+	return apply, len(restored.Followers), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// remoteActivity is an activity a fake remote server received, with
+// whether its signature verified against our key.
+type remoteActivity struct {
+	Type   string
+	Object json.RawMessage
+	Signed bool
+}
+
+func TestActivityPub(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	tracks = []track{{ID: "7", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane"}}
+
+	// Check ActivityPub is off until a key is loaded
+	if rr := performRequest(router, "PUT", "/me/activitypub", strings.NewReader(`{"enabled": true}`), "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	key, err := loadAPKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedKey, savedPublicURL := apKey, publicURL
+	apKey, publicURL = key, "https://music.example.com"
+	t.Cleanup(func() { apKey, publicURL = savedKey, savedPublicURL })
+
+	// Serve a fake remote server with an actor and an inbox that checks our signatures
+	remoteKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&remoteKey.PublicKey)
+	received := make(chan remoteActivity, 10)
+	var remote *httptest.Server
+	remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/mallory", "/users/eve":
+			// Eve's document claims to be Mallory's actor
+			json.NewEncoder(w).Encode(gin.H{
+				"id":        remote.URL + "/users/mallory",
+				"inbox":     remote.URL + "/users/mallory/inbox",
+				"endpoints": gin.H{"sharedInbox": remote.URL + "/inbox"},
+				"publicKey": gin.H{"id": remote.URL + r.URL.Path + "#main-key", "publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+			})
+		case "/users/mallory/inbox", "/inbox":
+			body, _ := io.ReadAll(r.Body)
+			headers := regexp.MustCompile(`headers="([^"]*)"`).FindStringSubmatch(r.Header.Get("Signature"))[1]
+			sig, _ := base64.StdEncoding.DecodeString(regexp.MustCompile(`signature="([^"]*)"`).FindStringSubmatch(r.Header.Get("Signature"))[1])
+			hashed := sha256.Sum256([]byte(apSigningString(r, r.Host, headers)))
+			var activity remoteActivity
+			json.Unmarshal(body, &activity)
+			activity.Signed = rsa.VerifyPKCS1v15(&apKey.PublicKey, crypto.SHA256, hashed[:], sig) == nil
+			received <- activity
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+	mallory := remote.URL + "/users/mallory"
+	// inbox posts body signed by keyActor over headers, or unsigned when
+	// keyActor is empty
+	inbox := func(body, keyActor, headers string) int {
+		req, _ := http.NewRequest("POST", "https://music.example.com/ap/actors/bob/inbox", strings.NewReader(body))
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		sum := sha256.Sum256([]byte(body))
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		if keyActor != "" {
+			hashed := sha256.Sum256([]byte(apSigningString(req, req.Host, headers)))
+			sig, _ := rsa.SignPKCS1v15(rand.Reader, remoteKey, crypto.SHA256, hashed[:])
+			req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",headers="%s",signature="%s"`, keyActor, headers, base64.StdEncoding.EncodeToString(sig)))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	follow := `{"id": "` + mallory + `#follow", "type": "Follow", "actor": "` + mallory + `", "object": "https://music.example.com/ap/actors/bob"}`
+
+	// Check a user's actor only exists once they opt in
+	if rr := performRequest(router, "GET", "/ap/actors/bob", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/me/activitypub", strings.NewReader(`{"enabled": true}`), "bob"); !strings.Contains(rr.Body.String(), `"handle": "@bob@music.example.com"`) {
+		t.Errorf("Expected bob's handle, but got %s", rr.Body)
+	}
+	rr := performRequest(router, "GET", "/.well-known/webfinger?resource=acct:bob@music.example.com", nil, "")
+	if !strings.Contains(rr.Body.String(), `"href":"https://music.example.com/ap/actors/bob"`) {
+		t.Errorf("Expected webfinger to point at bob's actor, but got %s", rr.Body)
+	}
+	rr = performRequest(router, "GET", "/ap/actors/bob", nil, "")
+	if rr.Header().Get("Content-Type") != apContentType+"; charset=utf-8" || !strings.Contains(rr.Body.String(), "BEGIN PUBLIC KEY") {
+		t.Errorf("Expected an actor with a public key, but got %s", rr.Body)
+	}
+
+	// Check follows must be signed by their actor over the request target,
+	// host, date and digest
+	signed := "(request-target) host date digest"
+	if code := inbox(follow, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for an unsigned follow, but got %d", http.StatusUnauthorized, code)
+	}
+	if code := inbox(follow, mallory, "digest"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a follow signed over its digest only, but got %d", http.StatusUnauthorized, code)
+	}
+	if code := inbox(follow, remote.URL+"/users/eve", signed); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a key whose actor document is another actor's, but got %d", http.StatusUnauthorized, code)
+	}
+	if code := inbox(follow, mallory, signed); code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusAccepted, code)
+	}
+	if got := <-received; got.Type != "Accept" || !got.Signed {
+		t.Errorf("Expected a signed Accept, but got %+v", got)
+	}
+
+	// Check a listen is posted to the follower's shared inbox and the outbox
+	if rr := performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "7"}`), "bob"); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusCreated, rr.Code)
+	}
+	got := <-received
+	var note struct{ Content string }
+	json.Unmarshal(got.Object, &note)
+	if got.Type != "Create" || !got.Signed || note.Content != "<p>Listened to <b>Moment&#39;s Notice</b> by John Coltrane from <i>Blue Train</i></p>" {
+		t.Errorf("Expected a signed Create of the listen, but got %+v", got)
+	}
+	rr = performRequest(router, "GET", "/ap/actors/bob/outbox", nil, "")
+	if !strings.Contains(rr.Body.String(), `"totalItems":1`) {
+		t.Errorf("Expected one post in the outbox, but got %s", rr.Body)
+	}
+
+	// Check the library actor posts new albums
+	performRequest(router, "POST", "/albums", strings.NewReader(`{"title": "Giant Steps", "artist": "John Coltrane", "year": 1960}`), "alice")
+	rr = performRequest(router, "GET", "/ap/actors/library/outbox", nil, "")
+	var outbox struct {
+		OrderedItems []struct{ Object struct{ Content string } }
+	}
+	json.Unmarshal(rr.Body.Bytes(), &outbox)
+	if len(outbox.OrderedItems) != 1 || !strings.Contains(outbox.OrderedItems[0].Object.Content, "Giant Steps</b> by John Coltrane (1960)") {
+		t.Errorf("Expected the new album in the library outbox, but got %s", rr.Body)
+	}
+
+	// Check an Undo removes the follower and opting out hides the actor
+	if code := inbox(`{"type": "Undo", "actor": "`+mallory+`", "object": `+follow+`}`, mallory, signed); code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, but got %d", http.StatusAccepted, code)
+	}
+	if len(apFollowers["bob"]) != 0 {
+		t.Errorf("Expected no followers, but got %+v", apFollowers["bob"])
+	}
+	performRequest(router, "PUT", "/me/activitypub", strings.NewReader(`{"enabled": false}`), "bob")
+	if rr := performRequest(router, "GET", "/ap/actors/bob/outbox", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	select {
+	case got := <-received:
+		t.Errorf("Expected no more deliveries, but got %+v", got)
+	default:
+	}
+}
+
+func TestAPKeyFile(t *testing.T) {
+	path := t.TempDir() + "/key.pem"
+
+	// Check the key is created once and read back after
+	created, err := loadAPKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadAPKey(path)
+	if err != nil || !loaded.Equal(created) {
+		t.Errorf("Expected the same key back, but got %v", err)
+	}
+	if _, err := loadAPKey(t.TempDir()); err == nil {
+		t.Errorf("Expected an error for a directory, but got none")
+	}
+}
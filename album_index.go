@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sortArticles are dropped from the front of names when filing them under a
+// letter, so "The Beatles" is found under B.
+var sortArticles = []string{"the ", "a ", "an "}
+
+type letterCount struct {
+	Letter string `json:"letter"`
+	Count  int    `json:"count"`
+	// Offset is where the letter starts in the list sorted by sortName.
+	Offset int `json:"offset"`
+}
+
+type artistCount struct {
+	Artist string `json:"artist"`
+	Letter string `json:"letter"`
+	Count  int    `json:"count"`
+}
+
+// sortName returns s lower-cased without a leading article.
+func sortName(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, article := range sortArticles {
+		if rest, ok := strings.CutPrefix(s, article); ok && rest != "" {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return s
+}
+
+// indexLetter is the letter a name is filed under; names that do not start
+// with a letter go under "#".
+func indexLetter(s string) string {
+	for _, r := range sortName(s) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+// getAlbumIndex counts albums per starting letter of their title, or of
+// their artist with ?by=artist, and per artist, so clients can draw an A-Z
+// scroll bar without fetching every album.
+func getAlbumIndex(c *gin.Context) {
+	by := c.DefaultQuery("by", "title")
+	if by != "title" && by != "artist" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "by must be title or artist"})
+		return
+	}
+
+	albumsMu.RLock()
+	letters := map[string]int{}
+	byArtist := map[string]*artistCount{}
+	for _, a := range albums {
+		name := a.Title
+		if by == "artist" {
+			name = a.Artist
+		}
+		letters[indexLetter(name)]++
+		key := sortName(a.Artist)
+		if byArtist[key] == nil {
+			byArtist[key] = &artistCount{Artist: a.Artist, Letter: indexLetter(a.Artist)}
+		}
+		byArtist[key].Count++
+	}
+	total := len(albums)
+	albumsMu.RUnlock()
+
+	index := make([]letterCount, 0, len(letters))
+	for letter, count := range letters {
+		index = append(index, letterCount{Letter: letter, Count: count})
+	}
+	// "#" sorts first, as it does in most clients.
+	sort.Slice(index, func(i, j int) bool { return index[i].Letter < index[j].Letter })
+	offset := 0
+	for i := range index {
+		index[i].Offset = offset
+		offset += index[i].Count
+	}
+	artists := make([]artistCount, 0, len(byArtist))
+	for key, a := range byArtist {
+		if key != "" {
+			artists = append(artists, *a)
+		}
+	}
+	sort.Slice(artists, func(i, j int) bool { return sortName(artists[i].Artist) < sortName(artists[j].Artist) })
+
+	c.IndentedJSON(http.StatusOK, gin.H{"by": by, "total": total, "letters": index, "artists": artists})
+}
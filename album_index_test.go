@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetAlbumIndex(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane"},
+		{ID: "2", Title: "The Black Album", Artist: "The Beatles"},
+		{ID: "3", Title: "1989", Artist: "Taylor Swift"},
+		{ID: "4", Title: "Abbey Road", Artist: "The Beatles"},
+	}
+	var index struct {
+		Total   int           `json:"total"`
+		Letters []letterCount `json:"letters"`
+		Artists []artistCount `json:"artists"`
+	}
+
+	// Check titles are filed by letter without leading articles
+	rr := performRequest(router, "GET", "/albums/index", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &index)
+	want := []letterCount{{Letter: "#", Count: 1, Offset: 0}, {Letter: "A", Count: 1, Offset: 1}, {Letter: "B", Count: 2, Offset: 2}}
+	if index.Total != 4 || len(index.Letters) != len(want) {
+		t.Fatalf("Expected %+v, but got %+v", want, index.Letters)
+	}
+	for i := range want {
+		if index.Letters[i] != want[i] {
+			t.Errorf("Expected %+v, but got %+v", want[i], index.Letters[i])
+		}
+	}
+
+	// Check albums are also counted per artist
+	if len(index.Artists) != 3 || index.Artists[0] != (artistCount{Artist: "The Beatles", Letter: "B", Count: 2}) {
+		t.Errorf("Expected The Beatles first with 2 albums, but got %+v", index.Artists)
+	}
+
+	// Check letters can follow the artist instead
+	rr = performRequest(router, "GET", "/albums/index?by=artist", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &index)
+	if len(index.Letters) != 3 || index.Letters[0].Letter != "B" || index.Letters[0].Count != 2 {
+		t.Errorf("Expected B, J and T, but got %+v", index.Letters)
+	}
+	if rr := performRequest(router, "GET", "/albums/index?by=year", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// albumDraft is an album as found by barcode, for the user to check
+// before it is added.
+type albumDraft struct {
+	// Source is "discogs" or "musicbrainz", and SourceID the release there.
+	Source        string       `json:"source,omitempty"`
+	SourceID      string       `json:"sourceId,omitempty"`
+	Barcode       string       `json:"barcode,omitempty"`
+	Title         string       `json:"title"`
+	Artist        string       `json:"artist"`
+	Year          int          `json:"year,omitempty"`
+	Genre         string       `json:"genre,omitempty"`
+	Label         string       `json:"label,omitempty"`
+	CatalogNumber string       `json:"catalogNumber,omitempty"`
+	CoverURL      string       `json:"coverUrl,omitempty"`
+	Tracks        []draftTrack `json:"tracks"`
+}
+
+type draftTrack struct {
+	Disc     int     `json:"disc,omitempty"`
+	Number   int     `json:"number,omitempty"`
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// validBarcode reports whether code is a UPC, EAN or GTIN-14 with a
+// correct check digit.
+func validBarcode(code string) bool {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+	sum := 0
+	for i := range code {
+		digit := int(code[len(code)-1-i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		// Counting from the check digit, every other digit weighs three.
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// releaseByBarcode looks up the release with a barcode, returning false
+// when MusicBrainz has none.
+func (m *musicBrainzClient) releaseByBarcode(ctx context.Context, barcode string) (albumDraft, bool, error) {
+	var search struct {
+		Releases []struct {
+			ID string `json:"id"`
+		} `json:"releases"`
+	}
+	query := url.Values{"query": {"barcode:" + barcode}, "limit": {"1"}, "fmt": {"json"}}
+	if err := m.get(ctx, "/release", query, &search); err != nil {
+		return albumDraft{}, false, err
+	}
+	if len(search.Releases) == 0 {
+		return albumDraft{}, false, nil
+	}
+
+	var release struct {
+		ID           string `json:"id"`
+		Title        string `json:"title"`
+		Date         string `json:"date"`
+		ArtistCredit []struct {
+			Name       string `json:"name"`
+			JoinPhrase string `json:"joinphrase"`
+		} `json:"artist-credit"`
+		LabelInfo []struct {
+			CatalogNumber string `json:"catalog-number"`
+			Label         struct {
+				Name string `json:"name"`
+			} `json:"label"`
+		} `json:"label-info"`
+		Media []struct {
+			Position int `json:"position"`
+			Tracks   []struct {
+				Position int    `json:"position"`
+				Title    string `json:"title"`
+				// Length is in milliseconds.
+				Length int `json:"length"`
+			} `json:"tracks"`
+		} `json:"media"`
+		CoverArtArchive struct {
+			Front bool `json:"front"`
+		} `json:"cover-art-archive"`
+	}
+	lookup := url.Values{"inc": {"recordings artist-credits labels"}, "fmt": {"json"}}
+	if err := m.get(ctx, "/release/"+url.PathEscape(search.Releases[0].ID), lookup, &release); err != nil {
+		return albumDraft{}, false, err
+	}
+
+	d := albumDraft{Source: "musicbrainz", SourceID: release.ID, Barcode: barcode, Title: release.Title, Tracks: []draftTrack{}}
+	var artist strings.Builder
+	for _, credit := range release.ArtistCredit {
+		artist.WriteString(credit.Name + credit.JoinPhrase)
+	}
+	d.Artist = artist.String()
+	if len(release.Date) >= 4 {
+		d.Year, _ = strconv.Atoi(release.Date[:4])
+	}
+	for _, info := range release.LabelInfo {
+		if info.Label.Name != "" {
+			d.Label, d.CatalogNumber = info.Label.Name, info.CatalogNumber
+			break
+		}
+	}
+	if release.CoverArtArchive.Front {
+		d.CoverURL = "https://coverartarchive.org/release/" + release.ID + "/front"
+	}
+	for _, medium := range release.Media {
+		for _, t := range medium.Tracks {
+			d.Tracks = append(d.Tracks, draftTrack{Disc: medium.Position, Number: t.Position, Title: t.Title, Duration: float64(t.Length) / 1000})
+		}
+	}
+	return d, true, nil
+}
+
+// postAlbumLookup looks up ?barcode with the metadata providers, Discogs
+// and MusicBrainz first, and returns the first album draft found, with its
+// tracklist and cover, for the user to correct and confirm with POST
+// /albums/lookup/confirm. Nothing is added yet.
+func postAlbumLookup(c *gin.Context) {
+	barcode := strings.ReplaceAll(c.Query("barcode"), " ", "")
+	if !validBarcode(barcode) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "barcode must be a UPC or EAN with a valid check digit"})
+		return
+	}
+	sources := metadataProviders()
+	if len(sources) == 0 {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "barcode lookups are not configured"})
+		return
+	}
+
+	var errs []error
+	for _, source := range sources {
+		drafts, err := source.Lookup(c.Request.Context(), metadataQuery{Barcode: barcode})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(drafts) > 0 {
+			c.IndentedJSON(http.StatusOK, drafts[0])
+			return
+		}
+	}
+	if len(errs) == len(sources) {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": errors.Join(errs...).Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "no release found with barcode " + barcode})
+}
+
+// postAlbumDraft adds an album, with its tracks, from a draft returned by
+// postAlbumLookup. The tracks have no files until some are imported.
+func postAlbumDraft(c *gin.Context) {
+	var draft albumDraft
+
+	if err := c.BindJSON(&draft); err != nil {
+		return
+	}
+	draft.Title, draft.Artist = strings.TrimSpace(draft.Title), strings.TrimSpace(draft.Artist)
+	if draft.Title == "" || draft.Artist == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "title and artist are required"})
+		return
+	}
+
+	a := album{
+		ID:            newID(),
+		Title:         draft.Title,
+		Artist:        draft.Artist,
+		Year:          draft.Year,
+		Genre:         draft.Genre,
+		Label:         draft.Label,
+		CatalogNumber: draft.CatalogNumber,
+		Barcode:       draft.Barcode,
+		CoverURL:      draft.CoverURL,
+	}
+	if draft.Source == "discogs" {
+		a.DiscogsID, _ = strconv.Atoi(draft.SourceID)
+	}
+	var albumTracks []track
+	for _, t := range draft.Tracks {
+		albumTracks = append(albumTracks, track{Title: t.Title, Artist: draft.Artist, Disc: t.Disc, Number: t.Number, Duration: t.Duration})
+	}
+	created, err := insertAlbum(c.Request.Context(), a, albumTracks, newPriceChange(c, 0))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, created)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidBarcode(t *testing.T) {
+	for code, want := range map[string]bool{
+		"602547924438":   true,  // UPC-A
+		"5099902988016":  true,  // EAN-13
+		"96385074":       true,  // EAN-8
+		"602547924439":   false, // bad check digit
+		"60254792443":    false,
+		"60254792443X":   false,
+		"00602547924438": true, // GTIN-14
+	} {
+		if got := validBarcode(code); got != want {
+			t.Errorf("Expected %s to be valid: %v, but got %v", code, want, got)
+		}
+	}
+}
+
+func TestAlbumLookup(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = nil
+	previousMB, previousDiscogs := musicBrainz, discogs
+	t.Cleanup(func() { musicBrainz, discogs = previousMB, previousDiscogs })
+
+	// Serve a fake Discogs that knows one barcode and a MusicBrainz that
+	// knows another
+	dc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Discogs token=secret" {
+			t.Errorf("Expected the Discogs token, but got %q", r.Header.Get("Authorization"))
+		}
+		switch {
+		case r.URL.Path == "/database/search" && r.URL.Query().Get("barcode") == "602547924438":
+			w.Write([]byte(`{"results": [{"id": 42}]}`))
+		case r.URL.Path == "/database/search":
+			w.Write([]byte(`{"results": []}`))
+		case r.URL.Path == "/releases/42":
+			w.Write([]byte(`{"id": 42, "title": "Kind of Blue", "year": 2015,
+				"artists": [{"name": "Miles Davis (2)", "join": ""}], "genres": ["Jazz"],
+				"labels": [{"name": "Columbia", "catno": "88875110381"}],
+				"images": [{"type": "secondary", "uri": "https://img/back.jpg"}, {"type": "primary", "uri": "https://img/front.jpg"}],
+				"tracklist": [{"position": "", "type_": "heading", "title": "Side A"},
+					{"position": "A1", "type_": "track", "title": "So What", "duration": "9:22"},
+					{"position": "B1", "type_": "track", "title": "All Blues", "duration": "11:33"}]}`))
+		}
+	}))
+	defer dc.Close()
+	mb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release":
+			w.Write([]byte(`{"releases": [{"id": "jeru-mbid"}]}`))
+		case "/release/jeru-mbid":
+			w.Write([]byte(`{"id": "jeru-mbid", "title": "Jeru", "date": "1962-11",
+				"artist-credit": [{"name": "Gerry Mulligan", "joinphrase": ""}],
+				"media": [{"position": 1, "tracks": [{"position": 1, "title": "Capricious", "length": 403000}]}],
+				"cover-art-archive": {"front": true}}`))
+		}
+	}))
+	defer mb.Close()
+	musicBrainz, discogs = newMusicBrainzClient(mb.URL), newDiscogsClient(dc.URL, "secret")
+
+	// Check Discogs fills in the draft
+	rr := performRequest(router, "POST", "/albums/lookup?barcode=602547924438", nil, "bob")
+	var draft albumDraft
+	json.Unmarshal(rr.Body.Bytes(), &draft)
+	want := albumDraft{
+		Source: "discogs", SourceID: "42", Barcode: "602547924438", Title: "Kind of Blue", Artist: "Miles Davis", Year: 2015,
+		Genre: "Jazz", Label: "Columbia", CatalogNumber: "88875110381", CoverURL: "https://img/front.jpg",
+		Tracks: []draftTrack{{Disc: 1, Number: 1, Title: "So What", Duration: 562}, {Disc: 1, Number: 2, Title: "All Blues", Duration: 693}},
+	}
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(draft, want) {
+		t.Errorf("Expected %+v, but got %d %+v", want, rr.Code, draft)
+	}
+	if len(albums) != 0 {
+		t.Errorf("Expected nothing to be added by a lookup, but got %+v", albums)
+	}
+
+	// Check MusicBrainz is asked when Discogs does not know the barcode
+	rr = performRequest(router, "POST", "/albums/lookup?barcode=5099902988016", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &draft)
+	if draft.Source != "musicbrainz" || draft.Year != 1962 || draft.CoverURL != "https://coverartarchive.org/release/jeru-mbid/front" || draft.Tracks[0].Duration != 403 {
+		t.Errorf("Expected the MusicBrainz release, but got %+v", draft)
+	}
+
+	// Check a confirmed draft adds the album and its tracks
+	body, _ := json.Marshal(want)
+	rr = performRequest(router, "POST", "/albums/lookup/confirm", strings.NewReader(string(body)), "bob")
+	var created album
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.Barcode != "602547924438" || created.Label != "Columbia" {
+		t.Errorf("Expected Kind of Blue to be added, but got %d %+v", rr.Code, created)
+	}
+	if len(tracks) != 2 || tracks[1].AlbumID != created.ID || tracks[1].Title != "All Blues" {
+		t.Errorf("Expected its two tracks, but got %+v", tracks)
+	}
+
+	// Check bad lookups
+	if rr = performRequest(router, "POST", "/albums/lookup?barcode=602547924439", nil, "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	musicBrainz = nil
+	if rr = performRequest(router, "POST", "/albums/lookup?barcode=96385074", nil, "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr = performRequest(router, "POST", "/albums/lookup/confirm", strings.NewReader(`{"title": "Untitled"}`), "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
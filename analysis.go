@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type trackAnalysis struct {
+	BPM float64 `json:"bpm,omitempty"`
+	// Key uses Camelot notation (e.g. "8A") or a name such as "A minor".
+	Key string `json:"key,omitempty"`
+	// Loudness is integrated loudness in LUFS.
+	Loudness float64 `json:"loudness"`
+	// Energy and Valence, from 0 to 1, are how intense and how positive a
+	// track sounds, for analyzers that can tell; mood tagging estimates
+	// them otherwise.
+	Energy  float64 `json:"energy,omitempty"`
+	Valence float64 `json:"valence,omitempty"`
+	// SkipIn and SkipOut are offsets in seconds where audible sound starts
+	// and ends; players start at SkipIn and move on at SkipOut, when set.
+	SkipIn     float64   `json:"skipIn,omitempty"`
+	SkipOut    float64   `json:"skipOut,omitempty"`
+	AnalyzedAt time.Time `json:"analyzedAt"`
+	// Peaks is the waveform found by an analyzer. It is moved to waveforms
+	// when the analysis is stored.
+	Peaks []float64 `json:"peaks,omitempty"`
+	// Chapters are the chapter markers found in the file. They are moved to
+	// the track when the analysis is stored.
+	Chapters []chapter `json:"chapters,omitempty"`
+}
+
+// trackAnalyzer computes audio features of the file at path.
+type trackAnalyzer interface {
+	Analyze(ctx context.Context, path string) (trackAnalysis, error)
+}
+
+// analyzer is nil when no analyzer is configured.
+var analyzer trackAnalyzer
+
+// analysisBatchSize caps how many tracks one run of the job analyzes.
+const analysisBatchSize = 100
+
+// commandAnalyzer runs an external program with the file path as its last
+// argument. The program must print a JSON object with bpm, key and loudness.
+type commandAnalyzer struct {
+	command string
+	args    []string
+}
+
+func (a commandAnalyzer) Analyze(ctx context.Context, path string) (trackAnalysis, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.command, append(a.args, path)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return trackAnalysis{}, fmt.Errorf("%s: %w: %s", a.command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var result trackAnalysis
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return trackAnalysis{}, fmt.Errorf("%s: bad output: %w", a.command, err)
+	}
+	return result, nil
+}
+
+// analyzeTracks analyzes tracks that have a file but no analysis yet, and
+// publishes each analysis for the other instances. It is run by the
+// scheduler and does nothing when no analyzer is configured.
+func analyzeTracks(ctx context.Context) error {
+	if analyzer == nil {
+		return nil
+	}
+
+	tagMoods()
+	albumsMu.RLock()
+	pending := make(map[string]string)
+	for _, t := range tracks {
+		if t.Analysis == nil && t.Path != "" && len(pending) < analysisBatchSize {
+			pending[t.ID] = t.Path
+		}
+	}
+	albumsMu.RUnlock()
+
+	failed := 0
+	for id, path := range pending {
+		result, err := analyzer.Analyze(ctx, path)
+		if err != nil {
+			log.Printf("analysis: track %s: %v", id, err)
+			failed++
+			continue
+		}
+		result.AnalyzedAt = time.Now().UTC()
+		if setTrackAnalysis(id, result) {
+			publishEvent(ctx, topicTrackAnalyzed, trackAnalyzed{ID: id, Analysis: result})
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tracks failed to analyze", failed, len(pending))
+	}
+	return nil
+}
+
+func setTrackAnalysis(id string, result trackAnalysis) bool {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrack(id)
+	if i < 0 {
+		return false
+	}
+	if len(result.Peaks) > 0 {
+		waveforms[id] = result.Peaks
+		result.Peaks = nil
+	}
+	if len(result.Chapters) > 0 {
+		tracks[i].Chapters = result.Chapters
+		result.Chapters = nil
+	}
+	tracks[i].Analysis = &result
+	tracks[i].Moods = trackMoods(&result)
+	return true
+}
+
+// putTrackAnalysis stores analysis computed outside the server, for example
+// by a batch job on a more powerful machine.
+func putTrackAnalysis(c *gin.Context) {
+	var result trackAnalysis
+
+	if err := c.BindJSON(&result); err != nil {
+		return
+	}
+	if result.BPM < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bpm must not be negative"})
+		return
+	}
+	if result.Energy < 0 || result.Energy > 1 || result.Valence < 0 || result.Valence > 1 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "energy and valence must be between 0 and 1"})
+		return
+	}
+	for _, p := range result.Peaks {
+		if p < 0 || p > 1 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "peaks must be between 0 and 1"})
+			return
+		}
+	}
+	if err := validateChapters(result.Chapters); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	result.AnalyzedAt = time.Now().UTC()
+	if !setTrackAnalysis(c.Param("id"), result) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	publishEvent(c.Request.Context(), topicTrackAnalyzed, trackAnalyzed{ID: c.Param("id"), Analysis: result})
+	result.Peaks, result.Chapters = nil, nil
+	c.IndentedJSON(http.StatusOK, result)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// fakeAnalyzer returns canned results keyed by path
+type fakeAnalyzer map[string]trackAnalysis
+
+func (f fakeAnalyzer) Analyze(_ context.Context, path string) (trackAnalysis, error) {
+	result, ok := f[path]
+	if !ok {
+		return trackAnalysis{}, errors.New("unreadable file")
+	}
+	return result, nil
+}
+
+// The analysis job fills in tracks, which can then be filtered by BPM and key
+func TestAnalyzeTracksAndFilter(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Slow", Path: "slow.flac"},
+		{ID: "2", AlbumID: "1", Title: "House", Path: "house.flac"},
+		{ID: "3", AlbumID: "1", Title: "Techno", Path: "techno.flac"},
+		{ID: "4", AlbumID: "1", Title: "Broken", Path: "broken.flac"},
+	}
+
+	// Analyze everything with a fake analyzer that cannot read one file
+	previous := analyzer
+	analyzer = fakeAnalyzer{
+		"slow.flac":   {BPM: 85, Key: "5A", Loudness: -12},
+		"house.flac":  {BPM: 124, Key: "8A", Loudness: -8},
+		"techno.flac": {BPM: 132, Key: "8A", Loudness: -7},
+	}
+	t.Cleanup(func() { analyzer = previous })
+	if err := analyzeTracks(context.Background()); err == nil {
+		t.Errorf("Expected the unreadable file to be reported")
+	}
+
+	// Check a BPM range only returns the house track
+	rr := performRequest(router, "GET", "/tracks?bpmMin=120&bpmMax=128", nil, "")
+	var listed []track
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "2" {
+		t.Errorf("Expected only track 2, but got %v", listed)
+	}
+
+	// Check the key filter matches both 8A tracks
+	rr = performRequest(router, "GET", "/tracks?key=8a", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 2 {
+		t.Errorf("Expected 2 tracks in 8A, but got %v", listed)
+	}
+
+	// Check malformed bounds are refused
+	if rr := performRequest(router, "GET", "/tracks?bpmMin=fast", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxArtistImageSize bounds how much of a fetched image is read.
+	maxArtistImageSize = 10 << 20
+	// artistImageRetry is how long an artist no source had an image for is
+	// left alone before asking again.
+	artistImageRetry = 24 * time.Hour
+)
+
+// artistImageSource finds an image of an artist. mbid is the artist's
+// MusicBrainz id, or "" when MusicBrainz is off or does not know them.
+type artistImageSource interface {
+	artistImageURL(ctx context.Context, name, mbid string) (string, error)
+}
+
+var (
+	// artistImageDir is set from configuration at startup; empty disables
+	// artist images. Images are stored by artist id.
+	artistImageDir string
+	// artistImageSources are asked in order until one has an image.
+	artistImageSources []artistImageSource
+	imageClient        = &http.Client{Timeout: 30 * time.Second}
+)
+
+// artistImageMisses remembers when no source had an image for an artist.
+var artistImageMisses = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: map[string]time.Time{}}
+
+var errNoArtistImage = errors.New("no image found for artist")
+
+// fanartSource looks up artist thumbnails on fanart.tv, which knows artists
+// by MusicBrainz id.
+type fanartSource struct {
+	baseURL string
+	key     string
+}
+
+func (f fanartSource) artistImageURL(ctx context.Context, name, mbid string) (string, error) {
+	if mbid == "" {
+		return "", nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.baseURL+"/music/"+url.PathEscape(mbid)+"?api_key="+url.QueryEscape(f.key), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fanart.tv returned %s", resp.Status)
+	}
+	var found struct {
+		ArtistThumb []struct {
+			URL string `json:"url"`
+		} `json:"artistthumb"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return "", err
+	}
+	if len(found.ArtistThumb) == 0 {
+		return "", nil
+	}
+	return found.ArtistThumb[0].URL, nil
+}
+
+// spotifySource searches Spotify for the artist, authenticating with the
+// client credentials flow.
+type spotifySource struct {
+	tokenURL, apiURL string
+	clientID, secret string
+	mu               sync.Mutex
+	token            string
+	tokenExpires     time.Time
+}
+
+func newSpotifySource(clientID, secret string) *spotifySource {
+	return &spotifySource{tokenURL: "https://accounts.spotify.com/api/token", apiURL: "https://api.spotify.com/v1", clientID: clientID, secret: secret}
+}
+
+// accessToken returns a token, fetching a new one shortly before the last
+// one expires.
+func (s *spotifySource) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpires) {
+		return s.token, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(s.clientID, s.secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("spotify token request returned %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.tokenExpires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+func (s *spotifySource) artistImageURL(ctx context.Context, name, mbid string) (string, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{"q": {`artist:"` + name + `"`}, "type": {"artist"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("spotify search returned %s", resp.Status)
+	}
+	var found struct {
+		Artists struct {
+			Items []struct {
+				Images []struct {
+					URL string `json:"url"`
+				} `json:"images"`
+			} `json:"items"`
+		} `json:"artists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return "", err
+	}
+	// Spotify lists the largest image first.
+	if len(found.Artists.Items) == 0 || len(found.Artists.Items[0].Images) == 0 {
+		return "", nil
+	}
+	return found.Artists.Items[0].Images[0].URL, nil
+}
+
+func (m *musicBrainzClient) artistImageURL(ctx context.Context, name, mbid string) (string, error) {
+	if mbid == "" {
+		return "", nil
+	}
+	return m.imageRelation(ctx, mbid)
+}
+
+// artistImageFile returns the cached image of an artist, or "".
+func artistImageFile(id string) string {
+	matches, _ := filepath.Glob(filepath.Join(artistImageDir, id+".*"))
+	for _, path := range matches {
+		if filepath.Ext(path) != ".tmp" {
+			return path
+		}
+	}
+	return ""
+}
+
+// downloadImage fetches an image, returning it with the format it decodes
+// as, so a source handing out HTML or a broken file is caught.
+func downloadImage(ctx context.Context, link string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s returned %s", link, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArtistImageSize))
+	if err != nil {
+		return nil, "", err
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", link, err)
+	}
+	return data, format, nil
+}
+
+// fetchArtistImage returns the image of an artist, asking the sources and
+// caching what they return the first time.
+func fetchArtistImage(ctx context.Context, a artist) (string, error) {
+	if path := artistImageFile(a.ID); path != "" {
+		return path, nil
+	}
+	artistImageMisses.Lock()
+	missed, ok := artistImageMisses.at[a.ID]
+	artistImageMisses.Unlock()
+	if ok && time.Since(missed) < artistImageRetry {
+		return "", errNoArtistImage
+	}
+
+	mbid := ""
+	if musicBrainz != nil {
+		id, err := musicBrainz.artistID(ctx, a.Name)
+		if err != nil && !errors.Is(err, errArtistNotFound) {
+			log.Printf("artist image: %s: %v", a.ID, err)
+		}
+		mbid = id
+	}
+	for _, source := range artistImageSources {
+		link, err := source.artistImageURL(ctx, a.Name, mbid)
+		if err != nil {
+			log.Printf("artist image: %s: %v", a.ID, err)
+			continue
+		}
+		if link == "" {
+			continue
+		}
+		data, format, err := downloadImage(ctx, link)
+		if err != nil {
+			log.Printf("artist image: %s: %v", a.ID, err)
+			continue
+		}
+		if err := os.MkdirAll(artistImageDir, 0o755); err != nil {
+			return "", err
+		}
+		path := filepath.Join(artistImageDir, a.ID+"."+format)
+		if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+			return "", err
+		}
+		return path, os.Rename(path+".tmp", path)
+	}
+
+	artistImageMisses.Lock()
+	artistImageMisses.at[a.ID] = time.Now()
+	artistImageMisses.Unlock()
+	return "", errNoArtistImage
+}
+
+// getArtistImage sends an artist's image, fetching it first when it is not
+// cached. ?size and ?format work as they do for album covers.
+func getArtistImage(c *gin.Context) {
+	albumsMu.RLock()
+	a, _ := artistAlbums(c.Param("id"))
+	albumsMu.RUnlock()
+
+	if a.ID == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "artist not found"})
+		return
+	}
+	if artistImageDir == "" {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "artist images are not configured"})
+		return
+	}
+	path, err := fetchArtistImage(c.Request.Context(), a)
+	if errors.Is(err, errNoArtistImage) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	if c.Query("size") != "" || c.Query("format") != "" || c.Query("blurhash") != "" {
+		serveCoverVariant(c, "", path)
+		return
+	}
+	serveMedia(c, path)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArtistImage(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 400; y++ {
+			img.Set(x, y, color.RGBA{0, 150, 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mb/artist":
+			w.Write([]byte(`{"artists": [{"id": "mbid-1"}]}`))
+		case "/mb/artist/mbid-1":
+			w.Write([]byte(`{"relations": [{"type": "wikipedia", "url": {"resource": "https://en.wikipedia.org/"}}, {"type": "image", "url": {"resource": "http://` + r.Host + `/photo.png"}}]}`))
+		case "/fanart/music/mbid-1":
+			w.Write([]byte(`{"artistthumb": []}`))
+		case "/photo.png":
+			fetches.Add(1)
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	savedMB, savedSources, savedDir, savedThumbs := musicBrainz, artistImageSources, artistImageDir, thumbnailDir
+	t.Cleanup(func() {
+		musicBrainz, artistImageSources, artistImageDir, thumbnailDir = savedMB, savedSources, savedDir, savedThumbs
+	})
+	musicBrainz = newMusicBrainzClient(server.URL + "/mb")
+	artistImageSources = []artistImageSource{fanartSource{baseURL: server.URL + "/fanart", key: "k"}, musicBrainz}
+	artistImageDir = filepath.Join(t.TempDir(), "artists")
+	thumbnailDir = filepath.Join(t.TempDir(), "thumbs")
+
+	// Check sources are asked in order, falling through to MusicBrainz
+	rr := performRequest(router, "GET", "/artists/john-coltrane/image", nil, "")
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), buf.Bytes()) {
+		t.Fatalf("Expected the artist image, but got %d", rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(artistImageDir, "john-coltrane.png")); err != nil {
+		t.Errorf("Expected the image to be cached, but got %v", err)
+	}
+
+	// Check the cached image is served without fetching again, and resizes
+	rr = performRequest(router, "GET", "/artists/john-coltrane/image?size=64", nil, "")
+	if scaled, err := jpeg.Decode(rr.Body); err != nil || scaled.Bounds().Dx() != 64 {
+		t.Errorf("Expected a 64 pixel thumbnail, but got %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("Expected 1 fetch, but got %d", fetches.Load())
+	}
+
+	// Check artists without an image and unknown artists get 404
+	artistImageSources = []artistImageSource{fanartSource{baseURL: server.URL + "/fanart", key: "k"}}
+	if rr := performRequest(router, "GET", "/artists/gerry-mulligan/image", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/artists/nobody/image", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestSpotifyArtistImage(t *testing.T) {
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokens.Add(1)
+			w.Write([]byte(`{"access_token": "abc", "expires_in": 3600}`))
+		case "/v1/search":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"artists": {"items": [{"images": [{"url": "https://i.example/large.jpg"}, {"url": "https://i.example/small.jpg"}]}]}}`))
+		}
+	}))
+	defer server.Close()
+
+	s := newSpotifySource("id", "secret")
+	s.tokenURL, s.apiURL = server.URL+"/token", server.URL+"/v1"
+
+	// Check the largest image is picked and the token is reused
+	for i := 0; i < 2; i++ {
+		link, err := s.artistImageURL(context.Background(), "John Coltrane", "")
+		if err != nil || link != "https://i.example/large.jpg" {
+			t.Errorf("Expected the large image, but got %q %v", link, err)
+		}
+	}
+	if tokens.Load() != 1 {
+		t.Errorf("Expected 1 token request, but got %d", tokens.Load())
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// releaseTypes are the accepted album types; the empty string is a regular
+// album.
+var releaseTypes = map[string]bool{"": true, "ep": true, "single": true, "compilation": true, audiobookType: true}
+
+var errBadReleaseType = errors.New("type must be one of ep, single, compilation or audiobook, or empty for an album")
+
+// slugify lower-cases s and joins its letters and digits with dashes, so
+// "Sarah Vaughan" becomes "sarah-vaughan".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+type artist struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Albums int    `json:"albums"`
+}
+
+// artistAlbums returns the artist whose slug is id and their albums.
+// Callers must hold albumsMu.
+func artistAlbums(id string) (artist, []album) {
+	var a artist
+	var matching []album
+	for _, al := range albums {
+		if slugify(al.Artist) == id {
+			a = artist{ID: id, Name: al.Artist, Albums: a.Albums + 1}
+			matching = append(matching, al)
+		}
+	}
+	return a, matching
+}
+
+func getArtists(c *gin.Context) {
+	albumsMu.RLock()
+	byID := make(map[string]*artist)
+	for _, al := range albums {
+		id := slugify(al.Artist)
+		if id == "" {
+			continue
+		}
+		if byID[id] == nil {
+			byID[id] = &artist{ID: id, Name: al.Artist}
+		}
+		byID[id].Albums++
+	}
+	albumsMu.RUnlock()
+
+	artists := []artist{}
+	for _, a := range byID {
+		artists = append(artists, *a)
+	}
+	sort.Slice(artists, func(i, j int) bool { return artists[i].ID < artists[j].ID })
+	respondList(c, artists)
+}
+
+type discographyRelease struct {
+	AlbumID string `json:"albumId,omitempty"`
+	// GroupID is set when the release is a release group, listed in place
+	// of its albums.
+	GroupID       string `json:"groupId,omitempty"`
+	MusicBrainzID string `json:"musicBrainzId,omitempty"`
+	Title         string `json:"title"`
+	InLibrary     bool   `json:"inLibrary"`
+}
+
+type discographyGroup struct {
+	Year     int                  `json:"year,omitempty"`
+	Type     string               `json:"type"`
+	Releases []discographyRelease `json:"releases"`
+}
+
+// getDiscography groups an artist's albums by release year and type. With
+// MusicBrainz enabled, releases missing from the library are listed too;
+// lookup failures are reported as a warning rather than failing the request.
+func getDiscography(c *gin.Context) {
+	albumsMu.RLock()
+	a, owned := artistAlbums(c.Param("id"))
+	groupOf := map[string]releaseGroup{}
+	for _, al := range owned {
+		if g := albumReleaseGroup(al.ID); g >= 0 {
+			groupOf[al.ID] = releaseGroups[g]
+		}
+	}
+	albumsMu.RUnlock()
+
+	if a.ID == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "artist not found"})
+		return
+	}
+
+	type key struct {
+		year int
+		typ  string
+	}
+	groups := make(map[key]*discographyGroup)
+	add := func(year int, typ string, r discographyRelease) {
+		if typ == "" {
+			typ = "album"
+		}
+		k := key{year, typ}
+		if groups[k] == nil {
+			groups[k] = &discographyGroup{Year: year, Type: typ}
+		}
+		groups[k].Releases = append(groups[k].Releases, r)
+	}
+
+	// A release group is listed once, under the year of its earliest
+	// album, in place of the albums it gathers.
+	titles := make(map[string]bool)
+	listed := make(map[string]bool)
+	for _, al := range owned {
+		titles[strings.ToLower(al.Title)] = true
+		g, grouped := groupOf[al.ID]
+		if !grouped {
+			add(al.Year, al.Type, discographyRelease{AlbumID: al.ID, Title: al.Title, InLibrary: true})
+			continue
+		}
+		if listed[g.ID] {
+			continue
+		}
+		listed[g.ID] = true
+		year := al.Year
+		for _, other := range owned {
+			if groupOf[other.ID].ID == g.ID && other.Year != 0 && (year == 0 || other.Year < year) {
+				year = other.Year
+			}
+		}
+		add(year, g.Type, discographyRelease{AlbumID: al.ID, GroupID: g.ID, Title: g.Title, InLibrary: true})
+		titles[strings.ToLower(g.Title)] = true
+	}
+
+	response := gin.H{"artist": a}
+	if musicBrainz != nil {
+		mbGroups, err := musicBrainz.releaseGroups(c.Request.Context(), a.Name)
+		if err != nil {
+			response["warning"] = "MusicBrainz enrichment unavailable: " + err.Error()
+		}
+		for _, g := range mbGroups {
+			if titles[strings.ToLower(g.Title)] {
+				continue
+			}
+			add(g.Year(), g.Type(), discographyRelease{MusicBrainzID: g.ID, Title: g.Title})
+		}
+	}
+
+	discography := []discographyGroup{}
+	for _, g := range groups {
+		discography = append(discography, *g)
+	}
+	sort.Slice(discography, func(i, j int) bool {
+		yi, yj := discography[i].Year, discography[j].Year
+		if yi != yj {
+			// Releases without a known year go last.
+			return yj == 0 || yi != 0 && yi < yj
+		}
+		return discography[i].Type < discography[j].Type
+	})
+	response["discography"] = discography
+	c.IndentedJSON(http.StatusOK, response)
+}
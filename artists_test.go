@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Discographies group albums by year and type and add MusicBrainz releases
+func TestGetDiscography(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Year: 1957},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Year: 1960},
+		{ID: "3", Title: "Jeru", Artist: "Gerry Mulligan", Year: 1962},
+	}
+
+	// Serve a fake MusicBrainz with one owned and one missing release
+	mb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/artist":
+			w.Write([]byte(`{"artists": [{"id": "coltrane-mbid"}]}`))
+		case "/release-group":
+			w.Write([]byte(`{"release-groups": [
+				{"id": "a", "title": "Blue Train", "primary-type": "Album", "first-release-date": "1957-09"},
+				{"id": "b", "title": "The Best of John Coltrane", "primary-type": "Album", "secondary-types": ["Compilation"], "first-release-date": "1970"}
+			]}`))
+		}
+	}))
+	defer mb.Close()
+	previous := musicBrainz
+	musicBrainz = newMusicBrainzClient(mb.URL)
+	t.Cleanup(func() { musicBrainz = previous })
+
+	// Fetch the discography
+	rr := performRequest(router, "GET", "/artists/john-coltrane/discography", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var response struct {
+		Discography []discographyGroup `json:"discography"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	// Check the groups come out by year, with the missing compilation last
+	groups := response.Discography
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 groups, but got %+v", groups)
+	}
+	if groups[0].Year != 1957 || groups[0].Type != "album" || len(groups[0].Releases) != 1 || !groups[0].Releases[0].InLibrary {
+		t.Errorf("Expected owned 1957 album first, but got %+v", groups[0])
+	}
+	if groups[2].Year != 1970 || groups[2].Type != "compilation" || groups[2].Releases[0].InLibrary {
+		t.Errorf("Expected missing 1970 compilation last, but got %+v", groups[2])
+	}
+
+	// Check unknown artists return 404
+	if rr := performRequest(router, "GET", "/artists/nobody/discography", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// Artist ids are slugs of their names
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"John Coltrane":                    "john-coltrane",
+		"Sarah Vaughan and Clifford Brown": "sarah-vaughan-and-clifford-brown",
+		"  AC/DC ":                         "ac-dc",
+	}
+	for in, want := range cases {
+		if got := slugify(in); got != want {
+			t.Errorf("Expected slugify(%q) = %q, but got %q", in, want, got)
+		}
+	}
+}
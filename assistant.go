@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minMatchScore is how close a spoken name must come to one in the library
+// to be taken for it; see matchScore.
+const minMatchScore = 0.7
+
+// assistantIntents maps the intent names a skill may send, including the
+// built-in ones of Alexa, to what they do.
+var assistantIntents = map[string]string{
+	"play":                  "play",
+	"pause":                 "pause",
+	"resume":                "resume",
+	"stop":                  "stop",
+	"next":                  "next",
+	"skip":                  "next",
+	"previous":              "previous",
+	"volume":                "volume",
+	"now_playing":           "now_playing",
+	"AMAZON.PauseIntent":    "pause",
+	"AMAZON.ResumeIntent":   "resume",
+	"AMAZON.StopIntent":     "stop",
+	"AMAZON.CancelIntent":   "stop",
+	"AMAZON.NextIntent":     "next",
+	"AMAZON.PreviousIntent": "previous",
+}
+
+// assistantIntent is a request from a voice assistant. Slots are the
+// entities the assistant recognised, as spoken.
+type assistantIntent struct {
+	Intent string            `json:"intent"`
+	Slots  map[string]string `json:"slots"`
+}
+
+// assistantReply says what was done, in Speech, for the assistant to read
+// out.
+type assistantReply struct {
+	Speech   string         `json:"speech"`
+	Resolved *resolvedMatch `json:"resolved,omitempty"`
+	Player   playerStatus   `json:"player"`
+}
+
+// resolvedMatch is the library entity a spoken name was taken for.
+type resolvedMatch struct {
+	Kind  string  `json:"kind"`
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	// tracks are what playing the match queues.
+	tracks []string
+}
+
+// matchScore rates how well a spoken query matches a name, from 0 to 1.
+// Case, punctuation and a leading article do not count, a query that is a
+// whole-word part of the name scores high, and otherwise the edit distance
+// decides, which forgives speech recognition misspelling a name.
+func matchScore(query, name string) float64 {
+	q := strings.ReplaceAll(slugify(sortName(query)), "-", " ")
+	best := 0.0
+	for _, n := range []string{name, sortName(name)} {
+		n = strings.ReplaceAll(slugify(n), "-", " ")
+		if q == "" || n == "" {
+			continue
+		}
+		switch {
+		case q == n:
+			return 1
+		case strings.Contains(" "+n+" ", " "+q+" "):
+			best = max(best, 0.8+0.2*float64(len(q))/float64(len(n)))
+		default:
+			best = max(best, 1-float64(editDistance(q, n))/float64(max(len(q), len(n))))
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// resolveEntity finds the best match for a spoken name among the kinds
+// given, earlier kinds winning ties. Callers must hold albumsMu.
+func resolveEntity(user, query string, kinds ...string) *resolvedMatch {
+	var best *resolvedMatch
+	consider := func(kind, id, name string, tracks func() []string) {
+		if score := matchScore(query, name); score >= minMatchScore && (best == nil || score > best.Score) {
+			best = &resolvedMatch{Kind: kind, ID: id, Name: name, Score: score, tracks: tracks()}
+		}
+	}
+	for _, kind := range kinds {
+		switch kind {
+		case "artist":
+			seen := map[string]bool{}
+			for _, a := range albums {
+				if id := slugify(a.Artist); id != "" && !seen[id] {
+					seen[id] = true
+					consider(kind, id, a.Artist, func() []string { _, owned := artistAlbums(id); return albumTrackIDs(owned) })
+				}
+			}
+		case "album":
+			for _, a := range albums {
+				consider(kind, a.ID, a.Title, func() []string { return albumTrackIDs([]album{a}) })
+			}
+		case "track":
+			for _, t := range tracks {
+				consider(kind, t.ID, t.Title, func() []string { return []string{t.ID} })
+			}
+		case "playlist":
+			for _, p := range playlists {
+				if p.Owner == user {
+					consider(kind, p.ID, p.Name, func() []string { return resolvePlaylist(p).TrackIDs })
+				}
+			}
+		case "genre":
+			seen := map[string]bool{}
+			for _, a := range albums {
+				if g := strings.ToLower(a.Genre); g != "" && !seen[g] {
+					seen[g] = true
+					consider(kind, g, a.Genre, func() []string { return genreTrackIDs(g) })
+				}
+			}
+		}
+	}
+	return best
+}
+
+// albumTrackIDs lists the tracks of albums in order: oldest album first,
+// then by disc and number. Callers must hold albumsMu.
+func albumTrackIDs(list []album) []string {
+	list = append([]album(nil), list...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Year < list[j].Year })
+	var ids []string
+	for _, a := range list {
+		var albumTracks []track
+		for _, t := range tracks {
+			if t.AlbumID == a.ID {
+				albumTracks = append(albumTracks, t)
+			}
+		}
+		sortTracks(albumTracks)
+		for _, t := range albumTracks {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// genreTrackIDs lists the tracks of a genre. Callers must hold albumsMu.
+func genreTrackIDs(genre string) []string {
+	var ids []string
+	for _, t := range tracks {
+		if strings.EqualFold(trackGenre(t), genre) {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// postAssistantIntent carries out an intent from a voice assistant skill
+// on the server player. Names are matched loosely against the library,
+// since they come from speech recognition; the reply says what was picked.
+func postAssistantIntent(c *gin.Context) {
+	var input assistantIntent
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	slot := func(name string) string { return strings.TrimSpace(input.Slots[name]) }
+	// Failures carry the speech as the message too, so the skill can read
+	// out why.
+	reply := func(code int, speech string, match *resolvedMatch, player playerStatus) {
+		if code != http.StatusOK {
+			c.IndentedJSON(code, gin.H{"message": speech, "speech": speech})
+			return
+		}
+		c.IndentedJSON(code, assistantReply{Speech: speech, Resolved: match, Player: player})
+	}
+
+	var err error
+	var status playerStatus
+	switch assistantIntents[input.Intent] {
+	case "play":
+		var match *resolvedMatch
+		kinds := []string{"artist", "album", "track", "playlist", "genre"}
+		albumsMu.RLock()
+		for _, kind := range kinds {
+			if q := slot(kind); q != "" {
+				match = resolveEntity(c.GetString(gin.AuthUserKey), q, kind)
+				if match == nil {
+					albumsMu.RUnlock()
+					reply(http.StatusNotFound, fmt.Sprintf("I couldn't find the %s %s.", kind, q), nil, status)
+					return
+				}
+				break
+			}
+		}
+		if q := slot("query"); match == nil && q != "" {
+			match = resolveEntity(c.GetString(gin.AuthUserKey), q, kinds...)
+			if match == nil {
+				albumsMu.RUnlock()
+				reply(http.StatusNotFound, fmt.Sprintf("I couldn't find %s in your library.", q), nil, status)
+				return
+			}
+		}
+		albumsMu.RUnlock()
+
+		if match == nil {
+			if status, err = musicPlayer.Play(); err != nil {
+				reply(http.StatusConflict, "There's nothing to play.", nil, status)
+				return
+			}
+			reply(http.StatusOK, "Playing.", nil, status)
+			return
+		}
+		if len(match.tracks) == 0 {
+			reply(http.StatusNotFound, fmt.Sprintf("There are no tracks for %s.", match.Name), match, status)
+			return
+		}
+		musicPlayer.mu.Lock()
+		speed := musicPlayer.speed
+		musicPlayer.mu.Unlock()
+		status = musicPlayer.Load(match.tracks, 0, 0, speed)
+		if shuffle, _ := strconv.ParseBool(slot("shuffle")); shuffle {
+			status = musicPlayer.SetShuffle(shuffleRandom)
+		}
+		reply(http.StatusOK, "Playing "+match.Name+".", match, status)
+	case "pause":
+		reply(http.StatusOK, "Paused.", nil, musicPlayer.Pause())
+	case "resume":
+		if status, err = musicPlayer.Play(); err != nil {
+			reply(http.StatusConflict, "There's nothing to play.", nil, status)
+			return
+		}
+		reply(http.StatusOK, "Resuming.", nil, status)
+	case "stop":
+		reply(http.StatusOK, "Stopped.", nil, musicPlayer.Stop())
+	case "next", "previous":
+		delta := 1
+		if assistantIntents[input.Intent] == "previous" {
+			delta = -1
+		}
+		if status, err = musicPlayer.Skip(delta); err != nil {
+			reply(http.StatusConflict, "The queue is empty.", nil, status)
+			return
+		}
+		reply(http.StatusOK, "Skipping.", nil, status)
+	case "volume":
+		musicPlayer.mu.Lock()
+		volume := musicPlayer.volume
+		musicPlayer.mu.Unlock()
+		switch level := strings.TrimSpace(strings.TrimSuffix(slot("level"), "%")); level {
+		case "up":
+			volume += 10
+		case "down":
+			volume -= 10
+		default:
+			if volume, err = strconv.Atoi(level); err != nil || volume < 0 || volume > 100 {
+				reply(http.StatusBadRequest, "The volume must be between 0 and 100.", nil, status)
+				return
+			}
+		}
+		volume = min(max(volume, 0), 100)
+		reply(http.StatusOK, fmt.Sprintf("Volume %d.", volume), nil, musicPlayer.SetVolume(volume))
+	case "now_playing":
+		current := currentNowPlaying()
+		musicPlayer.mu.Lock()
+		status = musicPlayer.status()
+		musicPlayer.mu.Unlock()
+		if current.Track == nil || current.State == stateStopped {
+			reply(http.StatusOK, "Nothing is playing.", nil, status)
+			return
+		}
+		speech := "This is " + current.Track.Title
+		if current.Track.Artist != "" {
+			speech += " by " + current.Track.Artist
+		}
+		reply(http.StatusOK, speech+".", nil, status)
+	default:
+		reply(http.StatusBadRequest, "Sorry, I don't know how to do that.", nil, status)
+	}
+}
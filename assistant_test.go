@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssistantIntent(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Year: 1957},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Year: 1960},
+		{ID: "3", Title: "The Beatles", Artist: "The Beatles", Genre: "Rock"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "2", Title: "Giant Steps", Number: 1},
+		{ID: "2", AlbumID: "1", Title: "Moment's Notice", Number: 2},
+		{ID: "3", AlbumID: "1", Title: "Blue Train", Number: 1},
+		{ID: "4", AlbumID: "3", Title: "Helter Skelter", Number: 1},
+	}
+	intent := func(body string) (int, assistantReply, string) {
+		rr := performRequest(router, "POST", "/assistant/intent", strings.NewReader(body), "bob")
+		var reply assistantReply
+		json.Unmarshal(rr.Body.Bytes(), &reply)
+		return rr.Code, reply, rr.Body.String()
+	}
+
+	// Check a misheard artist is resolved and their albums queued oldest first
+	code, reply, _ := intent(`{"intent": "play", "slots": {"artist": "john coltrain"}}`)
+	if code != http.StatusOK || reply.Resolved == nil || reply.Resolved.ID != "john-coltrane" || reply.Speech != "Playing John Coltrane." {
+		t.Fatalf("Expected John Coltrane to play, but got %d %+v", code, reply)
+	}
+	if reply.Player.State != statePlaying || reply.Player.TrackID != "3" || reply.Player.Queue != 3 {
+		t.Errorf("Expected Blue Train first of 3 tracks, but got %+v", reply.Player)
+	}
+
+	// Check a query without a kind finds the closest name of any kind
+	code, reply, _ = intent(`{"intent": "play", "slots": {"query": "helter skelter"}}`)
+	if code != http.StatusOK || reply.Resolved.Kind != "track" || reply.Player.TrackID != "4" {
+		t.Errorf("Expected the track Helter Skelter, but got %d %+v", code, reply)
+	}
+
+	// Check names too far from anything are reported
+	code, _, body := intent(`{"intent": "play", "slots": {"album": "kind of blue"}}`)
+	if code != http.StatusNotFound || !strings.Contains(body, `"speech": "I couldn't find the album kind of blue."`) {
+		t.Errorf("Expected the album not to be found, but got %d %s", code, body)
+	}
+
+	// Check transport and volume intents, including Alexa's built-in ones
+	if _, reply, _ = intent(`{"intent": "AMAZON.PauseIntent"}`); reply.Player.State != statePaused {
+		t.Errorf("Expected the player to pause, but got %+v", reply.Player)
+	}
+	if _, reply, _ = intent(`{"intent": "skip"}`); reply.Player.TrackID != "4" || reply.Speech != "Skipping." {
+		t.Errorf("Expected to stay on the last track, but got %+v", reply)
+	}
+	if _, reply, _ = intent(`{"intent": "volume", "slots": {"level": "40%"}}`); reply.Player.Volume != 40 {
+		t.Errorf("Expected volume 40, but got %+v", reply.Player)
+	}
+	if _, reply, _ = intent(`{"intent": "volume", "slots": {"level": "up"}}`); reply.Player.Volume != 50 || reply.Speech != "Volume 50." {
+		t.Errorf("Expected volume 50, but got %+v", reply)
+	}
+	if code, _, _ := intent(`{"intent": "volume", "slots": {"level": "loud"}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, code)
+	}
+	if code, _, _ := intent(`{"intent": "dance"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	// Check exact, partial and misspelt names rank in that order, ignoring articles and case
+	exact, partial, misspelt, other := matchScore("the beatles", "Beatles"), matchScore("coltrane", "John Coltrane"), matchScore("john coltrain", "John Coltrane"), matchScore("coltrane", "Gerry Mulligan")
+	if exact != 1 || !(partial > misspelt) || misspelt < minMatchScore || other >= minMatchScore {
+		t.Errorf("Expected 1 > partial > misspelt >= %v > other, but got %v, %v, %v, %v", minMatchScore, exact, partial, misspelt, other)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// audiobookType is the album type of audiobooks. The album artist is the
+// author and each track is a part of the book.
+const audiobookType = "audiobook"
+
+// minSpeed and maxSpeed bound the audiobook playback speed.
+const (
+	minSpeed = 0.5
+	maxSpeed = 3.0
+)
+
+// bookInfo holds the metadata only audiobooks have.
+type bookInfo struct {
+	Series string `json:"series,omitempty"`
+	// SeriesIndex orders books in a series; novellas often use halves.
+	SeriesIndex float64 `json:"seriesIndex,omitempty"`
+	Narrator    string  `json:"narrator,omitempty"`
+}
+
+// bookProgress is how far a user got in a book, reported by whichever
+// device they listened on last.
+type bookProgress struct {
+	TrackID   string    `json:"trackId"`
+	Position  float64   `json:"position"`
+	Device    string    `json:"device,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type listenerBooks struct {
+	// Speed is the preferred playback speed for audiobooks; 0 means 1.
+	Speed    float64                 `json:"speed,omitempty"`
+	Progress map[string]bookProgress `json:"progress"`
+}
+
+var (
+	bookMu        sync.Mutex
+	bookListeners = map[string]*listenerBooks{}
+)
+
+// listenerSettings returns the audiobook settings of user, creating them if
+// needed. Callers must hold bookMu.
+func listenerSettings(user string) *listenerBooks {
+	if bookListeners[user] == nil {
+		bookListeners[user] = &listenerBooks{Progress: map[string]bookProgress{}}
+	}
+	return bookListeners[user]
+}
+
+func (l *listenerBooks) speed() float64 {
+	if l.Speed == 0 {
+		return 1
+	}
+	return l.Speed
+}
+
+func isAudiobook(a album) bool {
+	return a.Type == audiobookType
+}
+
+// isAudiobookTrack reports whether a track is part of an audiobook.
+func isAudiobookTrack(id string) bool {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(id)
+	if i < 0 {
+		return false
+	}
+	j := findAlbum(tracks[i].AlbumID)
+	return j >= 0 && isAudiobook(albums[j])
+}
+
+// findBook returns the index of the audiobook with the given id, or -1.
+// Callers must hold albumsMu.
+func findBook(id string) int {
+	if i := findAlbum(id); i >= 0 && isAudiobook(albums[i]) {
+		return i
+	}
+	return -1
+}
+
+// bookTracks returns the parts of a book in playing order. Callers must hold
+// albumsMu.
+func bookTracks(id string) []track {
+	var parts []track
+	for _, t := range tracks {
+		if t.AlbumID == id {
+			parts = append(parts, t)
+		}
+	}
+	sortTracks(parts)
+	return parts
+}
+
+// sortBooks orders books by author, series and position in the series.
+func sortBooks(books []album) {
+	sort.SliceStable(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+		if !strings.EqualFold(a.Artist, b.Artist) {
+			return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+		}
+		var as, bs bookInfo
+		if a.Book != nil {
+			as = *a.Book
+		}
+		if b.Book != nil {
+			bs = *b.Book
+		}
+		if as.Series != bs.Series {
+			return as.Series < bs.Series
+		}
+		return as.SeriesIndex < bs.SeriesIndex
+	})
+}
+
+// getAudiobooks lists audiobooks, optionally only those of an ?author or
+// a ?series.
+func getAudiobooks(c *gin.Context) {
+	author, series := c.Query("author"), c.Query("series")
+
+	albumsMu.RLock()
+	books := []album{}
+	for _, a := range albums {
+		if !isAudiobook(a) || author != "" && !strings.EqualFold(a.Artist, author) {
+			continue
+		}
+		if series != "" && (a.Book == nil || !strings.EqualFold(a.Book.Series, series)) {
+			continue
+		}
+		books = append(books, a)
+	}
+	albumsMu.RUnlock()
+
+	sortBooks(books)
+	respondList(c, books)
+}
+
+type bookSeries struct {
+	Name   string  `json:"name"`
+	Author string  `json:"author"`
+	Books  []album `json:"books"`
+}
+
+// getAudiobookSeries groups audiobooks into series, in reading order.
+func getAudiobookSeries(c *gin.Context) {
+	albumsMu.RLock()
+	var books []album
+	for _, a := range albums {
+		if isAudiobook(a) && a.Book != nil && a.Book.Series != "" {
+			books = append(books, a)
+		}
+	}
+	albumsMu.RUnlock()
+
+	sortBooks(books)
+	series := []bookSeries{}
+	for _, b := range books {
+		if n := len(series); n == 0 || series[n-1].Name != b.Book.Series || !strings.EqualFold(series[n-1].Author, b.Artist) {
+			series = append(series, bookSeries{Name: b.Book.Series, Author: b.Artist})
+		}
+		s := &series[len(series)-1]
+		s.Books = append(s.Books, b)
+	}
+	respondList(c, series)
+}
+
+// getBookProgress returns where the user left off in a book, along with
+// their preferred speed, so any device can resume.
+func getBookProgress(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	found := findBook(id) >= 0
+	albumsMu.RUnlock()
+	if !found {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "audiobook not found"})
+		return
+	}
+
+	bookMu.Lock()
+	defer bookMu.Unlock()
+
+	settings := listenerSettings(c.GetString(gin.AuthUserKey))
+	progress, ok := settings.Progress[id]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "audiobook not started"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"progress": progress, "speed": settings.speed()})
+}
+
+// putBookProgress records the position a device reached in a book.
+func putBookProgress(c *gin.Context) {
+	var input bookProgress
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Position < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "position must not be negative"})
+		return
+	}
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	book := findBook(id)
+	t := findTrack(input.TrackID)
+	partOfBook := t >= 0 && tracks[t].AlbumID == id
+	albumsMu.RUnlock()
+	if book < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "audiobook not found"})
+		return
+	}
+	if !partOfBook {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "trackId must be a part of the audiobook"})
+		return
+	}
+	input.UpdatedAt = time.Now().UTC()
+
+	bookMu.Lock()
+	listenerSettings(c.GetString(gin.AuthUserKey)).Progress[id] = input
+	bookMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, input)
+}
+
+func getBookPreferences(c *gin.Context) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"speed": listenerSettings(c.GetString(gin.AuthUserKey)).speed()})
+}
+
+// putBookPreferences sets the playback speed used automatically whenever
+// an audiobook plays. Music always plays at normal speed.
+func putBookPreferences(c *gin.Context) {
+	var input struct {
+		Speed float64 `json:"speed"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Speed < minSpeed || input.Speed > maxSpeed {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "speed must be between 0.5 and 3"})
+		return
+	}
+
+	bookMu.Lock()
+	listenerSettings(c.GetString(gin.AuthUserKey)).Speed = input.Speed
+	bookMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"speed": input.Speed})
+}
+
+// postBookResume loads a book into the player and starts it where the user
+// left off, at their preferred speed.
+func postBookResume(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	if findBook(id) < 0 {
+		albumsMu.RUnlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "audiobook not found"})
+		return
+	}
+	var ids []string
+	for _, t := range bookTracks(id) {
+		ids = append(ids, t.ID)
+	}
+	albumsMu.RUnlock()
+	if len(ids) == 0 {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": "audiobook has no parts"})
+		return
+	}
+
+	bookMu.Lock()
+	settings := listenerSettings(c.GetString(gin.AuthUserKey))
+	progress, speed := settings.Progress[id], settings.speed()
+	bookMu.Unlock()
+
+	index := 0
+	for i, trackID := range ids {
+		if trackID == progress.TrackID {
+			index = i
+		}
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.Load(ids, index, progress.Position, speed))
+}
+
+func dumpBookListeners() ([]byte, error) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+
+	return json.MarshalIndent(bookListeners, "", "    ")
+}
+
+func restoreBookListeners(data []byte) (func(), int, error) {
+	var restored map[string]*listenerBooks
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]*listenerBooks{}
+	}
+	for user, settings := range restored {
+		if settings == nil {
+			settings = &listenerBooks{}
+			restored[user] = settings
+		}
+		if settings.Progress == nil {
+			settings.Progress = map[string]bookProgress{}
+		}
+	}
+	apply := func() {
+		bookMu.Lock()
+		bookListeners = restored
+		bookMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useBookListeners swaps in empty audiobook progress and preferences
+func useBookListeners(t *testing.T) {
+	saved := bookListeners
+	bookListeners = map[string]*listenerBooks{}
+	t.Cleanup(func() { bookListeners = saved })
+}
+
+func TestAudiobookSeries(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane"},
+		{ID: "2", Title: "The Two Towers", Artist: "J. R. R. Tolkien", Type: audiobookType, Book: &bookInfo{Series: "The Lord of the Rings", SeriesIndex: 2}},
+		{ID: "3", Title: "The Fellowship of the Ring", Artist: "J. R. R. Tolkien", Type: audiobookType, Book: &bookInfo{Series: "The Lord of the Rings", SeriesIndex: 1}},
+		{ID: "4", Title: "The Hobbit", Artist: "J. R. R. Tolkien", Type: audiobookType},
+	}
+
+	// Check only audiobooks are listed, in series order
+	rr := performRequest(router, "GET", "/audiobooks?series=the+lord+of+the+rings", nil, "")
+	var books []album
+	json.Unmarshal(rr.Body.Bytes(), &books)
+	if len(books) != 2 || books[0].ID != "3" || books[1].ID != "2" {
+		t.Errorf("Expected books 3 and 2, but got %+v", books)
+	}
+
+	// Check books are grouped into series
+	rr = performRequest(router, "GET", "/audiobooks/series", nil, "")
+	var series []bookSeries
+	json.Unmarshal(rr.Body.Bytes(), &series)
+	if len(series) != 1 || len(series[0].Books) != 2 || series[0].Author != "J. R. R. Tolkien" {
+		t.Errorf("Expected one series of two books, but got %+v", series)
+	}
+
+	// Check audiobooks are kept out of music recommendations
+	rr = performRequest(router, "GET", "/albums/4/related", nil, "")
+	var related []relatedAlbum
+	json.Unmarshal(rr.Body.Bytes(), &related)
+	for _, r := range related {
+		if !isAudiobook(r.Album) {
+			t.Errorf("Expected only audiobooks to be related, but got %+v", r.Album)
+		}
+	}
+}
+
+func TestAudiobookResume(t *testing.T) {
+	resetCatalog(t)
+	useBookListeners(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "The Hobbit", Artist: "J. R. R. Tolkien", Type: audiobookType}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Number: 1, Title: "Part 1", Duration: 3600},
+		{ID: "2", AlbumID: "1", Number: 2, Title: "Part 2", Duration: 3600},
+		{ID: "3", Title: "Song", Duration: 200},
+	}
+
+	// Check progress reported by one device is returned to another
+	performRequest(router, "PUT", "/audiobooks/preferences", strings.NewReader(`{"speed": 1.5}`), "bob")
+	rr := performRequest(router, "PUT", "/audiobooks/1/progress", strings.NewReader(`{"trackId": "2", "position": 120, "device": "phone"}`), "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/audiobooks/1/progress", nil, "bob")
+	var progress struct {
+		Progress bookProgress `json:"progress"`
+		Speed    float64      `json:"speed"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &progress)
+	if progress.Progress.TrackID != "2" || progress.Progress.Position != 120 || progress.Speed != 1.5 {
+		t.Errorf("Expected part 2 at 120s and speed 1.5, but got %+v", progress)
+	}
+
+	// Check progress is per user
+	if rr := performRequest(router, "GET", "/audiobooks/1/progress", nil, "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check resuming plays from the saved position at the preferred speed
+	rr = performRequest(router, "POST", "/audiobooks/1/resume", nil, "bob")
+	var status playerStatus
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if status.TrackID != "2" || status.Elapsed != 120 || status.Speed != 1.5 {
+		t.Errorf("Expected part 2 at 120s and speed 1.5, but got %+v", status)
+	}
+	*now = now.Add(10 * time.Second)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.Elapsed != 135 {
+		t.Errorf("Expected 135s after 10s at 1.5x, but got %v", status.Elapsed)
+	}
+
+	// Check music plays at normal speed after the book
+	musicPlayer.Enqueue("3")
+	musicPlayer.Skip(1)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.TrackID != "3" || status.Speed != 1 {
+		t.Errorf("Expected track 3 at speed 1, but got %+v", status)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// identify records the user on requests that carry basic credentials, so
+// changes can be attributed, while still allowing anonymous requests.
+func identify(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, _, ok := c.Request.BasicAuth(); ok && !checkCredentials(cfg, c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireUser rejects requests without valid credentials.
+func requireUser(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkCredentials(cfg, c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin authenticates the request and rejects users who are not
+// listed as admins.
+func requireAdmin(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkCredentials(cfg, c) {
+			return
+		}
+		if !cfg.Admins[c.GetString(gin.AuthUserKey)] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkCredentials checks HTTP basic credentials against the configured
+// accounts and stores the user name under gin.AuthUserKey. It aborts with 401
+// and reports false when they do not match.
+func checkCredentials(cfg config, c *gin.Context) bool {
+	name, password, ok := c.Request.BasicAuth()
+	want, known := cfg.Accounts[name]
+	if !ok || !known || subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+		if ok {
+			recordSignIn(name, false, time.Now())
+		}
+		c.Header("WWW-Authenticate", `Basic realm="go-music-player"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "authentication required"})
+		return false
+	}
+	c.Set(gin.AuthUserKey, name)
+	recordSignIn(name, true, time.Now())
+	return true
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const backupFormat = 1
+
+// backupSection is one file in a backup archive. Restore validates data and
+// returns a function that applies it, so a restore can check every section
+// before changing anything.
+type backupSection struct {
+	File    string
+	Dump    func() ([]byte, error)
+	Restore func(data []byte) (apply func(), count int, err error)
+}
+
+var backupSections = []backupSection{
+	{File: "fields.json", Dump: dumpFieldSchema, Restore: restoreFieldSchema},
+	{File: "albums.json", Dump: dumpAlbums, Restore: restoreAlbums},
+	{File: "tracks.json", Dump: dumpTracks, Restore: restoreTracks},
+	{File: "release-groups.json", Dump: dumpReleaseGroups, Restore: restoreReleaseGroups},
+	{File: "price-history.json", Dump: dumpPriceHistory, Restore: restorePriceHistory},
+	{File: "reviews.json", Dump: dumpReviews, Restore: restoreReviews},
+	{File: "eq.json", Dump: dumpEQSettings, Restore: restoreEQSettings},
+	{File: "zones.json", Dump: dumpZones, Restore: restoreZones},
+	{File: "trash.json", Dump: dumpTrash, Restore: restoreTrash},
+	{File: "quarantine.json", Dump: dumpQuarantine, Restore: restoreQuarantine},
+	{File: "objects.json", Dump: dumpObjects, Restore: restoreObjects},
+	{File: "waveforms.json", Dump: dumpWaveforms, Restore: restoreWaveforms},
+	{File: "audiobooks.json", Dump: dumpBookListeners, Restore: restoreBookListeners},
+	{File: "play-counts.json", Dump: dumpPlayCounts, Restore: restorePlayCounts},
+	{File: "listens.json", Dump: dumpListens, Restore: restoreListens},
+	{File: "listen-rollups.json", Dump: dumpListenRollups, Restore: restoreListenRollups},
+	{File: "playlists.json", Dump: dumpPlaylists, Restore: restorePlaylists},
+	{File: "searches.json", Dump: dumpSavedSearches, Restore: restoreSavedSearches},
+	{File: "shares.json", Dump: dumpShares, Restore: restoreShares},
+	{File: "wishlist.json", Dump: dumpWishlist, Restore: restoreWishlist},
+	{File: "collection.json", Dump: dumpCollection, Restore: restoreCollection},
+	{File: "discogs.json", Dump: dumpDiscogsAccounts, Restore: restoreDiscogsAccounts},
+	{File: "notifications.json", Dump: dumpNotifications, Restore: restoreNotifications},
+	{File: "follows.json", Dump: dumpFollows, Restore: restoreFollows},
+	{File: "activitypub.json", Dump: dumpActivityPub, Restore: restoreActivityPub},
+	{File: "price-watch.json", Dump: dumpPriceWatch, Restore: restorePriceWatch},
+	{File: "legacy-ids.json", Dump: dumpLegacyIDs, Restore: restoreLegacyIDs},
+	{File: "slugs.json", Dump: dumpStaleSlugs, Restore: restoreStaleSlugs},
+	{File: "scripts.json", Dump: dumpScripts, Restore: restoreScripts},
+	{File: "activity.json", Dump: dumpActivity, Restore: restoreActivity},
+}
+
+type backupFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type backupManifest struct {
+	Format    int          `json:"format"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Files     []backupFile `json:"files"`
+}
+
+func dumpAlbums() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(albums, "", "    ")
+}
+
+func restoreAlbums(data []byte) (func(), int, error) {
+	var restored []album
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	seen := make(map[string]bool)
+	for _, a := range restored {
+		if a.ID == "" || seen[a.ID] {
+			return nil, 0, fmt.Errorf("missing or duplicate album id %q", a.ID)
+		}
+		seen[a.ID] = true
+	}
+
+	apply := func() {
+		albumsMu.Lock()
+		albums = restored
+		assignSlugs()
+		libStats = computeLibraryStats()
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
+
+// writeBackup writes a gzipped tar archive with a manifest followed by one
+// file per backup section.
+func writeBackup(w io.Writer) error {
+	manifest := backupManifest{Format: backupFormat, CreatedAt: time.Now().UTC()}
+	contents := make([][]byte, len(backupSections))
+	for i, section := range backupSections {
+		data, err := section.Dump()
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.File, err)
+		}
+		sum := sha256.Sum256(data)
+		contents[i] = data
+		manifest.Files = append(manifest.Files, backupFile{
+			Name:   section.File,
+			Size:   len(data),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	rawManifest, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	files := append([]backupFile{{Name: "manifest.json"}}, manifest.Files...)
+	contents = append([][]byte{rawManifest}, contents...)
+	for i, f := range files {
+		hdr := &tar.Header{Name: f.Name, Mode: 0o644, Size: int64(len(contents[i])), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBackup unpacks an archive written by writeBackup and checks every file
+// against the manifest checksums.
+func readBackup(r io.Reader) (map[string][]byte, error) {
+	files, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	var manifest backupManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d", manifest.Format)
+	}
+	if err := checkArchive(files, manifest.Files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// readArchive unpacks a gzipped tar archive into memory, keyed by name.
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+	return files, nil
+}
+
+// checkArchive checks that every listed file is in files with its
+// checksum.
+func checkArchive(files map[string][]byte, listed []backupFile) error {
+	for _, f := range listed {
+		data, ok := files[f.Name]
+		if !ok {
+			return fmt.Errorf("%s: listed in manifest but missing", f.Name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.SHA256 {
+			return fmt.Errorf("%s: checksum mismatch", f.Name)
+		}
+	}
+	return nil
+}
+
+func postBackup(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := writeBackup(&buf); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	name := "go-music-player-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// postRestore loads a backup uploaded as the "backup" form file. With
+// ?dryRun=true it only reports what would be restored.
+func postRestore(c *gin.Context) {
+	header, err := c.FormFile("backup")
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "backup file is required"})
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	files, err := readBackup(f)
+	if err != nil {
+		c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": "invalid backup: " + err.Error()})
+		return
+	}
+
+	restored := make(map[string]int)
+	var applies []func()
+	for _, section := range backupSections {
+		data, ok := files[section.File]
+		if !ok {
+			continue
+		}
+		apply, count, err := section.Restore(data)
+		if err != nil {
+			c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": section.File + ": " + err.Error()})
+			return
+		}
+		applies = append(applies, apply)
+		restored[section.File] = count
+	}
+
+	dryRun := c.Query("dryRun") == "true"
+	if !dryRun {
+		for _, apply := range applies {
+			apply()
+		}
+		publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"dryRun": dryRun, "restored": restored})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// restoreRequest builds a multipart restore request carrying archive
+func restoreRequest(path string, archive []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("backup", "backup.tar.gz")
+	fw.Write(archive)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetBasicAuth("alice", "alice-pw")
+	return req
+}
+
+// A backup can be restored, and a dry run leaves the catalog untouched
+func TestBackupAndRestore(t *testing.T) {
+	saved := albums
+	t.Cleanup(func() { albums = saved })
+	router := setupRouter(testConfig())
+
+	// Take a backup of the current catalog
+	rr := performRequest(router, "POST", "/admin/backup", nil, "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	archive := rr.Body.Bytes()
+
+	// Empty the catalog and check a dry run does not bring it back
+	albums = []album{}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, restoreRequest("/admin/restore?dryRun=true", archive))
+	if rr.Code != http.StatusOK || len(albums) != 0 {
+		t.Fatalf("Expected a dry run to leave the catalog empty, but got status %d and %d albums", rr.Code, len(albums))
+	}
+
+	// Restore for real and check the albums are back
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, restoreRequest("/admin/restore", archive))
+	if rr.Code != http.StatusOK || len(albums) != len(saved) {
+		t.Errorf("Expected %d restored albums, but got status %d and %d albums", len(saved), rr.Code, len(albums))
+	}
+}
+
+// Archives whose files do not match the manifest checksums are rejected
+func TestRestoreRejectsTamperedBackup(t *testing.T) {
+	saved := albums
+	t.Cleanup(func() { albums = saved })
+	router := setupRouter(testConfig())
+
+	// Unpack a real backup and change albums.json behind the manifest's back
+	var good bytes.Buffer
+	writeBackup(&good)
+	files, _ := readBackup(&good)
+	files["albums.json"] = []byte(`[{"id":"9","title":"Tampered"}]`)
+
+	// Repack the files into a new archive
+	var tampered bytes.Buffer
+	gz := gzip.NewWriter(&tampered)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+
+	// Check the restore is refused and the catalog is untouched
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, restoreRequest("/admin/restore", tampered.Bytes()))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if len(albums) != len(saved) {
+		t.Errorf("Expected %d albums, but got %d", len(saved), len(albums))
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// bandcampURL is where the collection of a logged in fan is read.
+	bandcampURL = "https://bandcamp.com"
+	// bandcampArtURL is where album art is fetched when a download has
+	// none, by art id.
+	bandcampArtURL = "https://f4.bcbits.com/img/a%d_10.jpg"
+	// bandcampClient downloads purchases, which can take a while.
+	bandcampClient = &http.Client{Timeout: 30 * time.Minute}
+)
+
+// bandcampFormats are the download formats Bandcamp offers.
+var bandcampFormats = []string{"flac", "mp3-320", "mp3-v0", "aac-hi", "vorbis", "alac", "wav", "aiff-lossless"}
+
+// bandcampFileName matches the names of the files in a Bandcamp download,
+// such as "Artist - Album - 01 Title".
+var bandcampFileName = patternRegexp("{artist} - {album} - {track} {title}")
+
+// bandcampBlob matches the data Bandcamp puts in download pages.
+var bandcampBlob = regexp.MustCompile(`data-blob="([^"]*)"`)
+
+// bandcampPurchase is an item of a collection export: an album or track
+// and the page it can be downloaded from, as linked from the purchase
+// email or the collection page.
+type bandcampPurchase struct {
+	Artist      string `json:"artist"`
+	Title       string `json:"title"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+type bandcampImportResult struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	// Status is "imported", "skipped" when the album is already in the
+	// library, or "failed".
+	Status string `json:"status"`
+	Tracks int    `json:"tracks,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bandcampItem is a download as described by its download page.
+type bandcampItem struct {
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	ArtID       int    `json:"art_id"`
+	ReleaseDate string `json:"package_release_date"`
+	Downloads   map[string]struct {
+		URL string `json:"url"`
+	} `json:"downloads"`
+}
+
+// bandcampCollection reads the purchases of the fan logged in with the
+// identity cookie from the collection pages' own API.
+func bandcampCollection(ctx context.Context, identity string) ([]bandcampPurchase, error) {
+	send := func(method, path string, body any, v any) error {
+		var reader io.Reader
+		if body != nil {
+			raw, err := json.Marshal(body)
+			if err != nil {
+				return err
+			}
+			reader = bytes.NewReader(raw)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, bandcampURL+path, reader)
+		if err != nil {
+			return err
+		}
+		req.AddCookie(&http.Cookie{Name: "identity", Value: identity})
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := bandcampClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("bandcamp: %s returned %s", path, resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	var summary struct {
+		FanID int `json:"fan_id"`
+	}
+	if err := send(http.MethodGet, "/api/fan/2/collection_summary", nil, &summary); err != nil {
+		return nil, err
+	}
+	if summary.FanID == 0 {
+		return nil, errors.New("bandcamp: not logged in; check the identity cookie")
+	}
+
+	var purchases []bandcampPurchase
+	token := strconv.FormatInt(time.Now().Unix(), 10) + "::a::"
+	for {
+		var page struct {
+			Items []struct {
+				BandName string `json:"band_name"`
+				Title    string `json:"album_title"`
+				ItemType string `json:"sale_item_type"`
+				ItemID   int    `json:"sale_item_id"`
+			} `json:"items"`
+			RedownloadURLs map[string]string `json:"redownload_urls"`
+			More           bool              `json:"more_available"`
+			LastToken      string            `json:"last_token"`
+		}
+		body := map[string]any{"fan_id": summary.FanID, "older_than_token": token, "count": 100}
+		if err := send(http.MethodPost, "/api/fancollection/1/collection_items", body, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			link := page.RedownloadURLs[item.ItemType+strconv.Itoa(item.ItemID)]
+			purchases = append(purchases, bandcampPurchase{Artist: item.BandName, Title: item.Title, DownloadURL: link})
+		}
+		if !page.More || page.LastToken == "" || page.LastToken == token {
+			return purchases, nil
+		}
+		token = page.LastToken
+	}
+}
+
+// fetchBandcampItem reads the download page of a purchase.
+func fetchBandcampItem(ctx context.Context, link string) (bandcampItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return bandcampItem{}, err
+	}
+	resp, err := bandcampClient.Do(req)
+	if err != nil {
+		return bandcampItem{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return bandcampItem{}, fmt.Errorf("download page returned %s", resp.Status)
+	}
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return bandcampItem{}, err
+	}
+	m := bandcampBlob.FindSubmatch(page)
+	if m == nil {
+		return bandcampItem{}, errors.New("download page has no download data; it may have expired")
+	}
+	var blob struct {
+		DigitalItems []bandcampItem `json:"digital_items"`
+	}
+	if err := json.Unmarshal([]byte(html.UnescapeString(string(m[1]))), &blob); err != nil {
+		return bandcampItem{}, err
+	}
+	if len(blob.DigitalItems) == 0 {
+		return bandcampItem{}, errors.New("download page lists nothing to download")
+	}
+	return blob.DigitalItems[0], nil
+}
+
+// downloadBandcamp saves a download into dir. Albums come as zip files,
+// which are unpacked; single tracks come as they are.
+func downloadBandcamp(ctx context.Context, link, dir string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bandcampClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned %s", resp.Status)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, ".bandcamp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		// Not a zip: a single track, named by the download.
+		name := "download"
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			name = params["filename"]
+		}
+		path := filepath.Join(dir, filepath.Base(name))
+		tmp.Close()
+		return []string{path}, os.Rename(tmp.Name(), path)
+	}
+	var saved []string
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// Downloads are flat, so only the base name is kept, which also
+		// keeps entries from escaping dir.
+		path := filepath.Join(dir, filepath.Base(f.Name))
+		if err := extractZipFile(f, path); err != nil {
+			return saved, err
+		}
+		saved = append(saved, path)
+	}
+	return saved, nil
+}
+
+func extractZipFile(f *zip.File, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// bandcampTags reads the tags of a downloaded file from its name, which
+// Bandcamp writes as "Artist - Album - 01 Title". The album and its artist
+// come from the download page, since track artists differ on splits and
+// compilations.
+func bandcampTags(item bandcampItem, path string) (trackTags, bool) {
+	ext := filepath.Ext(path)
+	format := strings.ToLower(strings.TrimPrefix(ext, "."))
+	if !slices.Contains(audioExtensions, format) {
+		return trackTags{}, false
+	}
+	tags := trackTags{Path: path, Album: item.Title, AlbumArtist: item.Artist, Artist: item.Artist, Format: format}
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if m := bandcampFileName.FindStringSubmatch(name); m != nil {
+		tags.Artist, tags.Title = m[bandcampFileName.SubexpIndex("artist")], m[bandcampFileName.SubexpIndex("title")]
+		tags.Track, _ = strconv.Atoi(m[bandcampFileName.SubexpIndex("track")])
+	} else {
+		// Singles are named "Artist - Title".
+		tags.Title = name
+		if artist, title, ok := strings.Cut(name, " - "); ok {
+			tags.Artist, tags.Title = artist, title
+		}
+	}
+	if released, err := time.Parse("02 Jan 2006 15:04:05 MST", item.ReleaseDate); err == nil {
+		tags.Year = released.Year()
+	}
+	if info, err := os.Stat(path); err == nil {
+		tags.Size = info.Size()
+	}
+	return tags, true
+}
+
+// importBandcampPurchase downloads a purchase in format into the library
+// under root, with its cover, and adds its tracks.
+func importBandcampPurchase(ctx context.Context, root, format string, p bandcampPurchase) bandcampImportResult {
+	result := bandcampImportResult{Artist: p.Artist, Title: p.Title, Status: "failed"}
+	if p.DownloadURL == "" {
+		result.Error = "no download page"
+		return result
+	}
+	item, err := fetchBandcampItem(ctx, p.DownloadURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Artist, result.Title = item.Artist, item.Title
+
+	albumsMu.RLock()
+	owned := slices.ContainsFunc(albums, func(a album) bool { return albumKey(a.Artist, a.Title) == albumKey(item.Artist, item.Title) })
+	albumsMu.RUnlock()
+	if owned {
+		result.Status = "skipped"
+		return result
+	}
+	download, ok := item.Downloads[format]
+	if !ok {
+		offered := make([]string, 0, len(item.Downloads))
+		for f := range item.Downloads {
+			offered = append(offered, f)
+		}
+		slices.Sort(offered)
+		result.Error = format + " is not offered; try one of " + strings.Join(offered, ", ")
+		return result
+	}
+
+	dir := filepath.Join(root, expandPattern("{albumartist}/{album}", map[string]string{"albumartist": item.Artist, "album": item.Title}, ""))
+	files, err := downloadBandcamp(ctx, download.URL, dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var scanned []trackTags
+	hasCover := false
+	for _, path := range files {
+		if slices.Contains(coverNames, strings.ToLower(filepath.Base(path))) {
+			hasCover = true
+		}
+		if tags, ok := bandcampTags(item, path); ok {
+			scanned = append(scanned, tags)
+		}
+	}
+	if !hasCover && item.ArtID != 0 {
+		// A missing cover does not fail the import.
+		if data, _, err := downloadImage(ctx, fmt.Sprintf(bandcampArtURL, item.ArtID)); err == nil {
+			os.WriteFile(filepath.Join(dir, "cover.jpg"), data, 0o644)
+		}
+	}
+
+	albumsMu.Lock()
+	imported := importTracks(scanned)
+	albumsMu.Unlock()
+	result.Status, result.Tracks = "imported", imported.TracksAdded
+	return result
+}
+
+// importBandcamp returns the handler that imports Bandcamp purchases into
+// the library under root. The purchases come from a collection export, or
+// are read from the collection page of the fan whose Bandcamp identity
+// cookie is given; the cookie is only used for this request.
+func importBandcamp(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Purchases []bandcampPurchase `json:"purchases"`
+			Identity  string             `json:"identity"`
+			Format    string             `json:"format"`
+		}
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		if input.Format == "" {
+			input.Format = "flac"
+		}
+		if !slices.Contains(bandcampFormats, input.Format) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "format must be one of " + strings.Join(bandcampFormats, ", ")})
+			return
+		}
+		if (len(input.Purchases) == 0) == (input.Identity == "") {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "give either purchases or an identity cookie"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		purchases := input.Purchases
+		if input.Identity != "" {
+			var err error
+			if purchases, err = bandcampCollection(ctx, input.Identity); err != nil {
+				c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+				return
+			}
+		}
+
+		results := []bandcampImportResult{}
+		for _, p := range purchases {
+			results = append(results, importBandcampPurchase(ctx, root, input.Format, p))
+		}
+		publishEvent(ctx, topicCacheInvalidation, nil)
+		c.IndentedJSON(http.StatusOK, results)
+	}
+}
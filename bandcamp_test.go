@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeBandcamp serves a fan's collection of one album, its download page
+// and the album as a zip.
+func fakeBandcamp(t *testing.T) *httptest.Server {
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for _, name := range []string{"Khruangbin - Mordechai - 01 First Class.flac", "Khruangbin - Mordechai - 02 Time (You and I).flac", "cover.jpg"} {
+		f, _ := w.Create(name)
+		f.Write([]byte("data"))
+	}
+	w.Close()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fan/2/collection_summary":
+			if cookie, err := r.Cookie("identity"); err != nil || cookie.Value != "secret" {
+				w.Write([]byte(`{}`))
+				return
+			}
+			w.Write([]byte(`{"fan_id": 7}`))
+		case "/api/fancollection/1/collection_items":
+			fmt.Fprintf(w, `{"items": [{"band_name": "Khruangbin", "album_title": "Mordechai", "sale_item_type": "a", "sale_item_id": 1}], "redownload_urls": {"a1": "%s/download"}, "more_available": false}`, server.URL)
+		case "/download":
+			blob := fmt.Sprintf(`{"digital_items": [{"title": "Mordechai", "artist": "Khruangbin", "package_release_date": "26 Jun 2020 00:00:00 GMT", "downloads": {"flac": {"url": "%s/album.zip"}}}]}`, server.URL)
+			fmt.Fprintf(w, `<html><div id="pagedata" data-blob="%s"></div></html>`, html.EscapeString(blob))
+		case "/album.zip":
+			w.Write(archive.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestImportBandcamp(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	server := fakeBandcamp(t)
+	defer func(u string) { bandcampURL = u }(bandcampURL)
+	bandcampURL = server.URL
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	var results []bandcampImportResult
+
+	// Check a format Bandcamp does not offer is rejected
+	rr := performRequest(router, "POST", "/library/bandcamp", strings.NewReader(`{"identity": "secret", "format": "mp4"}`), "alice")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check a bad identity cookie is reported
+	rr = performRequest(router, "POST", "/library/bandcamp", strings.NewReader(`{"identity": "stale"}`), "alice")
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadGateway, rr.Code)
+	}
+
+	// Check an item whose format is missing fails on its own
+	body := fmt.Sprintf(`{"purchases": [{"artist": "Khruangbin", "title": "Mordechai", "downloadUrl": "%s/download"}], "format": "wav"}`, server.URL)
+	rr = performRequest(router, "POST", "/library/bandcamp", strings.NewReader(body), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if rr.Code != http.StatusOK || len(results) != 1 || results[0].Status != "failed" || !strings.Contains(results[0].Error, "flac") {
+		t.Errorf("Expected the item to fail naming the formats offered, but got %d %+v", rr.Code, results)
+	}
+
+	// Check the collection is downloaded, unpacked and imported
+	rr = performRequest(router, "POST", "/library/bandcamp", strings.NewReader(`{"identity": "secret"}`), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Status != "imported" || results[0].Tracks != 2 {
+		t.Fatalf("Expected 2 tracks imported, but got %+v", results)
+	}
+	dir := filepath.Join(root, "Khruangbin", "Mordechai")
+	if _, err := os.Stat(filepath.Join(dir, "cover.jpg")); err != nil {
+		t.Errorf("Expected the cover to be kept, but got %v", err)
+	}
+	if len(albums) != 1 || albums[0].Title != "Mordechai" || albums[0].Year != 2020 {
+		t.Errorf("Expected the album Mordechai from 2020, but got %+v", albums)
+	}
+	if len(tracks) != 2 || tracks[1].Title != "Time (You and I)" || tracks[1].Number != 2 {
+		t.Errorf("Expected the tracks tagged from their file names, but got %+v", tracks)
+	}
+
+	// Check albums already in the library are skipped
+	rr = performRequest(router, "POST", "/library/bandcamp", strings.NewReader(`{"identity": "secret"}`), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Status != "skipped" || len(tracks) != 2 {
+		t.Errorf("Expected the album to be skipped, but got %+v", results)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUndoBatches bounds how many batches can be undone; older undo tokens
+// are forgotten.
+const maxUndoBatches = 100
+
+type batchEditRequest struct {
+	TrackIDs []string `json:"trackIds"`
+	Filter   struct {
+		AlbumID string `json:"albumId"`
+		// Artist matches the track artist, ignoring case.
+		Artist string `json:"artist"`
+	} `json:"filter"`
+	Set struct {
+		trackPatch
+		// AlbumArtist renames the artist of the selected tracks' albums.
+		AlbumArtist *string `json:"albumArtist"`
+	} `json:"set"`
+	DryRun bool `json:"dryRun"`
+}
+
+type trackEdit struct {
+	Before track `json:"before"`
+	After  track `json:"after"`
+}
+
+type albumEdit struct {
+	Before album `json:"before"`
+	After  album `json:"after"`
+}
+
+type batchEditResult struct {
+	Tracks    []trackEdit `json:"tracks"`
+	Albums    []albumEdit `json:"albums"`
+	DryRun    bool        `json:"dryRun"`
+	UndoToken string      `json:"undoToken,omitempty"`
+}
+
+// batchUndo keeps the edits of applied batches by undo token.
+type batchUndo struct {
+	Tracks    []trackEdit
+	Albums    []albumEdit
+	AppliedAt time.Time
+}
+
+var (
+	undoMu      sync.Mutex
+	undoBatches = map[string]batchUndo{}
+	undoOrder   []string
+)
+
+// selectsTrack reports whether the request's selection includes t.
+func (r batchEditRequest) selectsTrack(t track) bool {
+	if len(r.TrackIDs) > 0 {
+		found := false
+		for _, id := range r.TrackIDs {
+			found = found || id == t.ID
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Filter.AlbumID != "" && t.AlbumID != r.Filter.AlbumID {
+		return false
+	}
+	if r.Filter.Artist != "" && !strings.EqualFold(t.Artist, r.Filter.Artist) {
+		return false
+	}
+	return true
+}
+
+// planBatchEdit works out the edits a batch makes without applying them.
+// Callers must hold albumsMu.
+func planBatchEdit(r batchEditRequest) batchEditResult {
+	result := batchEditResult{Tracks: []trackEdit{}, Albums: []albumEdit{}, DryRun: r.DryRun}
+	seenAlbums := map[string]bool{}
+	for _, t := range tracks {
+		if !r.selectsTrack(t) {
+			continue
+		}
+		if after := r.Set.apply(t); !reflect.DeepEqual(after, t) {
+			result.Tracks = append(result.Tracks, trackEdit{Before: t, After: after})
+		}
+		if r.Set.AlbumArtist == nil || seenAlbums[t.AlbumID] {
+			continue
+		}
+		seenAlbums[t.AlbumID] = true
+		if i := findAlbum(t.AlbumID); i >= 0 && albums[i].Artist != *r.Set.AlbumArtist {
+			after := albums[i]
+			after.Artist = *r.Set.AlbumArtist
+			after.Slug = albumSlug(after)
+			result.Albums = append(result.Albums, albumEdit{Before: albums[i], After: after})
+		}
+	}
+	return result
+}
+
+// applyEdits writes the given versions of tracks and albums. Callers must
+// hold albumsMu.
+func applyEdits(trackVersions []track, albumVersions []album) {
+	for _, t := range trackVersions {
+		if i := findTrack(t.ID); i >= 0 {
+			libStats.replaceTrack(tracks[i], t)
+			tracks[i] = t
+		}
+	}
+	for _, a := range albumVersions {
+		if i := findAlbum(a.ID); i >= 0 {
+			setAlbumSlug(&a, albums[i].Slug)
+			libStats.replaceAlbum(albums[i], a)
+			albums[i] = a
+		}
+	}
+}
+
+// postBatchEdit applies one change set to many tracks, selected by id and
+// by filter. With dryRun it only reports the edits it would make; otherwise
+// it returns an undo token for the batch.
+func postBatchEdit(c *gin.Context) {
+	var input batchEditRequest
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if len(input.TrackIDs) == 0 && input.Filter.AlbumID == "" && input.Filter.Artist == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "select tracks with trackIds or a filter"})
+		return
+	}
+	if err := input.Set.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	albumsMu.Lock()
+	result := planBatchEdit(input)
+	if input.DryRun || len(result.Tracks)+len(result.Albums) == 0 {
+		albumsMu.Unlock()
+		c.IndentedJSON(http.StatusOK, result)
+		return
+	}
+	var trackVersions []track
+	for _, e := range result.Tracks {
+		trackVersions = append(trackVersions, e.After)
+	}
+	var albumVersions []album
+	for _, e := range result.Albums {
+		albumVersions = append(albumVersions, e.After)
+	}
+	applyEdits(trackVersions, albumVersions)
+	logBatchEdit(c, result)
+	albumsMu.Unlock()
+
+	result.UndoToken = rememberBatch(batchUndo{Tracks: result.Tracks, Albums: result.Albums, AppliedAt: time.Now().UTC()})
+	publishAlbumEdits(c, albumVersions)
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+// logBatchEdit records an applied batch in the operation log. Callers must
+// hold albumsMu.
+func logBatchEdit(c *gin.Context, result batchEditResult) {
+	var albumEdits []albumVersions
+	for _, e := range result.Albums {
+		before, after := e.Before, albums[findAlbum(e.After.ID)]
+		albumEdits = append(albumEdits, albumVersions{Before: &before, After: &after})
+	}
+	var trackEdits []trackVersions
+	for _, e := range result.Tracks {
+		before, after := e.Before, e.After
+		trackEdits = append(trackEdits, trackVersions{Before: &before, After: &after})
+	}
+	logOperation(c, "batch-edit", albumEdits, trackEdits)
+}
+
+func rememberBatch(b batchUndo) string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	token := hex.EncodeToString(raw)
+
+	undoMu.Lock()
+	defer undoMu.Unlock()
+
+	undoBatches[token] = b
+	undoOrder = append(undoOrder, token)
+	if len(undoOrder) > maxUndoBatches {
+		delete(undoBatches, undoOrder[0])
+		undoOrder = undoOrder[1:]
+	}
+	return token
+}
+
+// postBatchUndo reverts a batch edit. It refuses when any of the tracks or
+// albums were changed again since, rather than overwriting those changes.
+func postBatchUndo(c *gin.Context) {
+	token := c.Param("token")
+
+	undoMu.Lock()
+	defer undoMu.Unlock()
+
+	batch, ok := undoBatches[token]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "unknown or expired undo token"})
+		return
+	}
+
+	albumsMu.Lock()
+	var trackVersions []track
+	for _, e := range batch.Tracks {
+		if i := findTrack(e.After.ID); i >= 0 && !reflect.DeepEqual(tracks[i], e.After) {
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "track " + e.After.ID + " was changed after the batch"})
+			return
+		}
+		trackVersions = append(trackVersions, e.Before)
+	}
+	var albumVersions []album
+	for _, e := range batch.Albums {
+		if i := findAlbum(e.After.ID); i >= 0 && !reflect.DeepEqual(albums[i], e.After) {
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "album " + e.After.ID + " was changed after the batch"})
+			return
+		}
+		albumVersions = append(albumVersions, e.Before)
+	}
+	applyEdits(trackVersions, albumVersions)
+	albumsMu.Unlock()
+
+	delete(undoBatches, token)
+	for i, t := range undoOrder {
+		if t == token {
+			undoOrder = append(undoOrder[:i], undoOrder[i+1:]...)
+			break
+		}
+	}
+	publishAlbumEdits(c, albumVersions)
+	c.IndentedJSON(http.StatusOK, gin.H{"tracks": len(trackVersions), "albums": len(albumVersions)})
+}
+
+// publishAlbumEdits tells other instances about edited albums.
+func publishAlbumEdits(c *gin.Context, edited []album) {
+	ctx := c.Request.Context()
+	for _, a := range edited {
+		publishEvent(ctx, topicAlbumUpdated, albumChange{Album: a})
+	}
+	publishEvent(ctx, topicCacheInvalidation, nil)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBatchEdit(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Pastel Blues", Artist: "Nina Simon"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Be My Husband", Artist: "Nina Simon"},
+		{ID: "2", AlbumID: "1", Title: "Sinnerman", Artist: "nina simon"},
+		{ID: "3", AlbumID: "2", Title: "So What", Artist: "Miles Davis"},
+	}
+	edit := `{"filter": {"artist": "Nina Simon"}, "set": {"artist": "Nina Simone", "albumArtist": "Nina Simone"}%s}`
+
+	// Check a selection is required
+	if rr := performRequest(router, "POST", "/tracks/batch-edit", strings.NewReader(`{"set": {"artist": "x"}}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check a dry run previews the edits without applying them
+	rr := performRequest(router, "POST", "/tracks/batch-edit", strings.NewReader(strings.Replace(edit, "%s", `, "dryRun": true`, 1)), "alice")
+	var result batchEditResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if len(result.Tracks) != 2 || len(result.Albums) != 1 || result.UndoToken != "" || tracks[0].Artist != "Nina Simon" {
+		t.Errorf("Expected a preview of 2 track and 1 album edits, but got %+v", result)
+	}
+
+	// Check the batch is applied and returns an undo token
+	rr = performRequest(router, "POST", "/tracks/batch-edit", strings.NewReader(strings.Replace(edit, "%s", "", 1)), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if result.UndoToken == "" || tracks[1].Artist != "Nina Simone" || albums[0].Artist != "Nina Simone" || tracks[2].Artist != "Miles Davis" {
+		t.Errorf("Expected the edits to be applied, but got %+v and %+v", tracks, albums)
+	}
+
+	// Check undo refuses when a track changed since, then reverts the batch
+	performRequest(router, "PATCH", "/tracks/1", strings.NewReader(`{"number": 1}`), "alice")
+	rr = performRequest(router, "POST", "/tracks/batch-edit/"+result.UndoToken+"/undo", nil, "alice")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+	performRequest(router, "PATCH", "/tracks/1", strings.NewReader(`{"number": 0}`), "alice")
+	rr = performRequest(router, "POST", "/tracks/batch-edit/"+result.UndoToken+"/undo", nil, "alice")
+	if rr.Code != http.StatusOK || tracks[1].Artist != "nina simon" || albums[0].Artist != "Nina Simon" {
+		t.Errorf("Expected the batch to be reverted, but got %d %+v", rr.Code, tracks)
+	}
+
+	// Check a token can only be used once
+	rr = performRequest(router, "POST", "/tracks/batch-edit/"+result.UndoToken+"/undo", nil, "alice")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
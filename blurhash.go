@@ -0,0 +1,139 @@
+package main
+
+import (
+	"image"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// blurhashX and blurhashY are how many components a placeholder has
+	// across and down; 4x3 suits the mostly square covers.
+	blurhashX = 4
+	blurhashY = 3
+	// blurhashSample is the size covers are scaled to before encoding, which
+	// is plenty for a blurred placeholder.
+	blurhashSample = 32
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurhashes caches placeholders by cover checksum.
+var blurhashes = struct {
+	sync.Mutex
+	sums map[string]string
+}{sums: map[string]string{}}
+
+func encode83(n, length int) string {
+	var b strings.Builder
+	for i := length - 1; i >= 0; i-- {
+		digit := n / int(math.Pow(83, float64(i))) % 83
+		b.WriteByte(base83[digit])
+	}
+	return b.String()
+}
+
+func srgbToLinear(v uint32) float64 {
+	f := float64(v>>8) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = min(max(v, 0), 1)
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the magnitude of v to exp, keeping its sign.
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// encodeBlurhash encodes img as a BlurHash with nx by ny components, see
+// https://github.com/woltapp/blurhash for the format.
+func encodeBlurhash(img image.Image, nx, ny int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	factors := make([][3]float64, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+					f[0] += basis * srgbToLinear(r)
+					f[1] += basis * srgbToLinear(g)
+					f[2] += basis * srgbToLinear(bl)
+				}
+			}
+			scale := 1.0
+			if i != 0 || j != 0 {
+				scale = 2
+			}
+			scale /= float64(w * h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	hash := encode83(nx-1+(ny-1)*9, 1)
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantised := min(max(int(math.Floor(actual*166-0.5)), 0), 82)
+		maxValue = float64(quantised+1) / 166
+		hash += encode83(quantised, 1)
+	} else {
+		hash += encode83(0, 1)
+	}
+	hash += encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		q := func(v float64) int {
+			return min(max(int(math.Floor(signPow(v/maxValue, 0.5)*9+9.5)), 0), 18)
+		}
+		hash += encode83(q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return hash
+}
+
+// coverBlurhash returns the placeholder of a cover file, encoding it only
+// the first time the cover is seen.
+func coverBlurhash(coverPath string) (string, error) {
+	sum, _, err := sha256File(coverPath)
+	if err != nil {
+		return "", err
+	}
+	blurhashes.Lock()
+	hash, ok := blurhashes.sums[sum]
+	blurhashes.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	f, err := os.Open(coverPath)
+	if err != nil {
+		return "", err
+	}
+	cover, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	hash = encodeBlurhash(scaleImage(cover, blurhashSample), blurhashX, blurhashY)
+
+	blurhashes.Lock()
+	blurhashes.sums[sum] = hash
+	blurhashes.Unlock()
+	return hash, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeBlurhash(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	// Check a flat red image encodes as the reference encoder does
+	expected := "LfTI:j|cfQ|c|csUfQsUfQfQfQfQ"
+	if hash := encodeBlurhash(img, 4, 3); hash != expected {
+		t.Errorf("Expected %q, but got %q", expected, hash)
+	}
+
+	// Check detail shows up in the components
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+	if hash := encodeBlurhash(img, 4, 3); len(hash) != 28 || hash == expected {
+		t.Errorf("Expected a different 28 character hash, but got %q", hash)
+	}
+}
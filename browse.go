@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type addedAlbum struct {
+	Album album `json:"album"`
+	// AddedAt is when the album's newest track was added.
+	AddedAt time.Time `json:"addedAt"`
+	// Group is set when the album stands for its release group, whose
+	// other albums are not listed.
+	Group *releaseGroupSummary `json:"group,omitempty"`
+}
+
+type playedTrack struct {
+	Track    track     `json:"track"`
+	PlayedAt time.Time `json:"playedAt"`
+}
+
+// getRecentlyAdded lists albums by when tracks were last added to them,
+// newest first. Albums whose tracks predate added dates are left out, and
+// a release group is listed once, at its newest album.
+func getRecentlyAdded(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	added := slices.DeleteFunc(recentlyAdded(), func(a addedAlbum) bool { return !visibleTo(c, a.Album) })
+	added = collapseGroups(added)
+	start, end := pageBounds(c, len(added), limit, offset)
+	respondList(c, added[start:end])
+}
+
+// recentlyAdded returns the albums with added dates, newest first. Callers
+// must hold albumsMu.
+func recentlyAdded() []addedAlbum {
+	latest := map[string]time.Time{}
+	for _, t := range tracks {
+		if t.AddedAt != nil && t.AddedAt.After(latest[t.AlbumID]) {
+			latest[t.AlbumID] = *t.AddedAt
+		}
+	}
+	added := []addedAlbum{}
+	for _, a := range albums {
+		if at, ok := latest[a.ID]; ok {
+			added = append(added, addedAlbum{Album: a, AddedAt: at})
+		}
+	}
+	sort.SliceStable(added, func(i, j int) bool { return added[i].AddedAt.After(added[j].AddedAt) })
+	return added
+}
+
+// collapseGroups keeps the first album listed of each release group, with
+// the group's summary, and drops the others. Callers must hold albumsMu.
+func collapseGroups(added []addedAlbum) []addedAlbum {
+	seen := map[int]bool{}
+	kept := added[:0]
+	for _, a := range added {
+		g := albumReleaseGroup(a.Album.ID)
+		if g >= 0 {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+			a.Group = releaseGroups[g].summary()
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// getRecentlyPlayed lists the tracks the user played, most recent first.
+// Each track appears once, at its latest play.
+func getRecentlyPlayed(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	// Reported plays may arrive out of order, so the latest play is not
+	// necessarily the last one recorded.
+	latest := map[string]time.Time{}
+	for _, l := range listens[c.GetString(gin.AuthUserKey)] {
+		if l.PlayedAt.After(latest[l.TrackID]) {
+			latest[l.TrackID] = l.PlayedAt
+		}
+	}
+	played := []playedTrack{}
+	for id, at := range latest {
+		if j := findTrack(id); j >= 0 {
+			played = append(played, playedTrack{Track: tracks[j], PlayedAt: at})
+		}
+	}
+	sort.Slice(played, func(i, j int) bool {
+		if !played[i].PlayedAt.Equal(played[j].PlayedAt) {
+			return played[i].PlayedAt.After(played[j].PlayedAt)
+		}
+		return played[i].Track.ID < played[j].Track.ID
+	})
+	start, end := pageBounds(c, len(played), limit, offset)
+	respondList(c, played[start:end])
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecentlyAdded(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	day := func(d int) *time.Time {
+		at := time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC)
+		return &at
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", AddedAt: day(1)},
+		{ID: "2", AlbumID: "2", AddedAt: day(2)},
+		{ID: "3", AlbumID: "1", AddedAt: day(3)},
+		{ID: "4", AlbumID: "3"},
+	}
+
+	// Check albums are ordered by their newest track and paginated
+	rr := performRequest(router, "GET", "/browse/recently-added?limit=1", nil, "")
+	var added []addedAlbum
+	json.Unmarshal(rr.Body.Bytes(), &added)
+	if len(added) != 1 || added[0].Album.ID != "1" || !added[0].AddedAt.Equal(*day(3)) {
+		t.Errorf("Expected album 1 added on the 3rd, but got %+v", added)
+	}
+	if total := rr.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected a total of 2, but got %s", total)
+	}
+}
+
+func TestRecentlyPlayed(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1"}, {ID: "2"}}
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	listens["bob"] = []listen{
+		{TrackID: "1", PlayedAt: at},
+		{TrackID: "2", PlayedAt: at.Add(time.Hour)},
+		{TrackID: "1", PlayedAt: at.Add(2 * time.Hour)},
+		{TrackID: "2", PlayedAt: at.Add(-time.Hour)},
+	}
+
+	// Check each track appears once at its latest play
+	rr := performRequest(router, "GET", "/browse/recently-played", nil, "bob")
+	var played []playedTrack
+	json.Unmarshal(rr.Body.Bytes(), &played)
+	if len(played) != 2 || played[0].Track.ID != "1" || played[1].Track.ID != "2" || !played[1].PlayedAt.Equal(at.Add(time.Hour)) {
+		t.Errorf("Expected tracks 1 then 2, but got %+v", played)
+	}
+
+	// Check the history is per user and requires signing in
+	if rr := performRequest(router, "GET", "/browse/recently-played", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/browse/recently-played", nil, "alice")
+	json.Unmarshal(rr.Body.Bytes(), &played)
+	if len(played) != 0 {
+		t.Errorf("Expected no plays for alice, but got %+v", played)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bulkDeleteTTL is how long a bulk delete preview can be confirmed.
+const bulkDeleteTTL = 10 * time.Minute
+
+type bulkDeleteRequest struct {
+	Filter struct {
+		// Artist and Genre match ignoring case.
+		Artist string `json:"artist"`
+		Genre  string `json:"genre"`
+		Tag    string `json:"tag"`
+		// Q is a query in the search grammar, see query.go.
+		Q string `json:"q"`
+	} `json:"filter"`
+	// ConfirmToken is the token of a preview of the same filter; without
+	// it nothing is deleted.
+	ConfirmToken string `json:"confirmToken"`
+}
+
+type bulkDeletePreview struct {
+	Albums       int        `json:"albums"`
+	Tracks       int        `json:"tracks"`
+	AlbumIDs     []string   `json:"albumIds"`
+	ConfirmToken string     `json:"confirmToken,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+}
+
+// pendingBulkDelete is a previewed deletion waiting for confirmation.
+type pendingBulkDelete struct {
+	user      string
+	albumIDs  []string
+	expiresAt time.Time
+}
+
+var errNoBulkFilter = errors.New("filter by artist, genre, tag or q")
+
+var (
+	bulkDeleteMu       sync.Mutex
+	pendingBulkDeletes = map[string]pendingBulkDelete{}
+)
+
+// matcher returns the filter as a function, failing on a bad tag or query
+// or when no filter is given at all.
+func (r bulkDeleteRequest) matcher() (func(album) bool, error) {
+	f := r.Filter
+	if f.Artist == "" && f.Genre == "" && f.Tag == "" && strings.TrimSpace(f.Q) == "" {
+		return nil, errNoBulkFilter
+	}
+	var filters []func(album) bool
+	if f.Artist != "" {
+		filters = append(filters, func(a album) bool { return strings.EqualFold(a.Artist, f.Artist) })
+	}
+	if f.Genre != "" {
+		filters = append(filters, func(a album) bool { return strings.EqualFold(a.Genre, f.Genre) })
+	}
+	if f.Tag != "" {
+		tag, err := normalizeTag(f.Tag)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(a album) bool { return hasTag(a.Tags, tag) })
+	}
+	if q := strings.TrimSpace(f.Q); q != "" {
+		match, err := albumQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, match)
+	}
+	return func(a album) bool { return matchesAll(a, filters) }, nil
+}
+
+// previewBulkDelete lists the albums a filter selects and how many tracks
+// go with them. Callers must hold albumsMu.
+func previewBulkDelete(match func(album) bool) bulkDeletePreview {
+	preview := bulkDeletePreview{AlbumIDs: []string{}}
+	selected := map[string]bool{}
+	for _, a := range albums {
+		if match(a) {
+			preview.AlbumIDs = append(preview.AlbumIDs, a.ID)
+			selected[a.ID] = true
+		}
+	}
+	for _, t := range tracks {
+		if selected[t.AlbumID] {
+			preview.Tracks++
+		}
+	}
+	preview.Albums = len(preview.AlbumIDs)
+	slices.Sort(preview.AlbumIDs)
+	return preview
+}
+
+// bulkDeleteAlbums returns the handler that deletes every album matching a
+// filter, in two steps: a request without a confirmation token only
+// previews the deletion and returns a token, and repeating the request with
+// that token deletes. The token is refused when the filter selects
+// different albums by then. Tracks go to the trash, files into dir, and
+// restoring one brings back its album.
+func bulkDeleteAlbums(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input bulkDeleteRequest
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		match, err := input.matcher()
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		user := c.GetString(gin.AuthUserKey)
+
+		bulkDeleteMu.Lock()
+		defer bulkDeleteMu.Unlock()
+
+		now := time.Now()
+		for token, p := range pendingBulkDeletes {
+			if now.After(p.expiresAt) {
+				delete(pendingBulkDeletes, token)
+			}
+		}
+
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		preview := previewBulkDelete(match)
+		if input.ConfirmToken == "" {
+			if preview.Albums > 0 {
+				raw := make([]byte, 16)
+				rand.Read(raw)
+				preview.ConfirmToken = hex.EncodeToString(raw)
+				expires := now.Add(bulkDeleteTTL).UTC()
+				preview.ExpiresAt = &expires
+				pendingBulkDeletes[preview.ConfirmToken] = pendingBulkDelete{user: user, albumIDs: preview.AlbumIDs, expiresAt: expires}
+			}
+			c.IndentedJSON(http.StatusOK, preview)
+			return
+		}
+
+		pending, ok := pendingBulkDeletes[input.ConfirmToken]
+		if !ok || pending.user != user {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "unknown or expired confirmation token"})
+			return
+		}
+		if !slices.Equal(pending.albumIDs, preview.AlbumIDs) {
+			delete(pendingBulkDeletes, input.ConfirmToken)
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "the filter selects different albums than the preview; preview again"})
+			return
+		}
+		delete(pendingBulkDeletes, input.ConfirmToken)
+
+		var albumEdits []albumVersions
+		var trackEdits []trackVersions
+		var deleted []string
+		ctx := c.Request.Context()
+		// trashTrack removes from tracks, so walk a copy.
+		selected := slices.Clone(tracks)
+		for _, id := range preview.AlbumIDs {
+			i := findAlbum(id)
+			a := albums[i]
+			for _, t := range selected {
+				if t.AlbumID != id {
+					continue
+				}
+				if _, err := trashTrack(t, &a, dir, user); err != nil {
+					logOperation(c, "album.bulk-delete", albumEdits, trackEdits)
+					publishAlbumDeletes(ctx, deleted)
+					publishEvent(ctx, topicCacheInvalidation, nil)
+					c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+					return
+				}
+				before := t
+				trackEdits = append(trackEdits, trackVersions{Before: &before})
+			}
+			albums = append(albums[:i], albums[i+1:]...)
+			libStats.removeAlbum(a)
+			albumEdits = append(albumEdits, albumVersions{Before: &a})
+			deleted = append(deleted, id)
+		}
+		logOperation(c, "album.bulk-delete", albumEdits, trackEdits)
+		publishAlbumDeletes(ctx, deleted)
+		publishEvent(ctx, topicCacheInvalidation, nil)
+		c.IndentedJSON(http.StatusOK, preview)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBulkDelete(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	published := recordAlbumDeletes(t)
+	tracks = []track{{ID: "1", AlbumID: "1"}, {ID: "2", AlbumID: "1"}, {ID: "3", AlbumID: "2"}}
+	bulkDelete := func(body, user string) (int, bulkDeletePreview) {
+		rr := performRequest(router, "POST", "/admin/albums/bulk-delete", strings.NewReader(body), user)
+		var p bulkDeletePreview
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		return rr.Code, p
+	}
+
+	// Check a request without a token only previews
+	code, preview := bulkDelete(`{"filter": {"artist": "john coltrane"}}`, "alice")
+	if code != http.StatusOK || preview.Albums != 1 || preview.Tracks != 2 || preview.ConfirmToken == "" {
+		t.Fatalf("Expected a preview of 1 album and 2 tracks, but got %d %+v", code, preview)
+	}
+	if len(albums) != 3 || len(tracks) != 3 {
+		t.Errorf("Expected nothing deleted by the preview")
+	}
+
+	// Check the token deletes the albums and trashes their tracks
+	confirm := `{"filter": {"artist": "john coltrane"}, "confirmToken": "` + preview.ConfirmToken + `"}`
+	code, deleted := bulkDelete(confirm, "alice")
+	if code != http.StatusOK || deleted.Albums != 1 || findAlbum("1") >= 0 || len(tracks) != 1 || len(trash) != 2 {
+		t.Fatalf("Expected album 1 deleted, but got %d %+v", code, deleted)
+	}
+	if !slices.Equal(*published, []string{"1"}) {
+		t.Errorf("Expected the deletion of album 1 published, but got %v", *published)
+	}
+	if code, _ := bulkDelete(confirm, "alice"); code != http.StatusNotFound {
+		t.Errorf("Expected a used token to be refused, but got %d", code)
+	}
+
+	// Check restoring a track brings its album back
+	rr := performRequest(router, "POST", "/library/trash/1/restore", nil, "alice")
+	if rr.Code != http.StatusOK || findAlbum("1") < 0 {
+		t.Errorf("Expected the album restored with its track, but got %d", rr.Code)
+	}
+
+	// Check a token is refused when the selection changed since the preview
+	_, preview = bulkDelete(`{"filter": {"q": "price > 20"}}`, "alice")
+	albums[findAlbum("3")].Price = 10
+	code, _ = bulkDelete(`{"filter": {"q": "price > 20"}, "confirmToken": "`+preview.ConfirmToken+`"}`, "alice")
+	if code != http.StatusConflict || len(albums) != 3 {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, code)
+	}
+
+	// Check a filter is required and users cannot bulk delete
+	if code, _ := bulkDelete(`{}`, "alice"); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := bulkDelete(`{"filter": {"tag": "jazz"}}`, "bob"); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+}
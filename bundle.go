@@ -0,0 +1,379 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A bundle is the whole server state in one file for moving to another
+// machine: the catalog, as in a backup, under catalog/, and the album covers
+// and artist images under covers/. Audio files are left out; they are
+// copied by other means, and track paths are moved to the new library
+// directory on import.
+const bundleFormat = 1
+
+// maxBundleSize bounds an uploaded bundle, which is read into memory.
+const maxBundleSize = 2 << 30
+
+type bundleManifest struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"createdAt"`
+	// LibraryDir is the library directory of the server that wrote the
+	// bundle, which track paths start with.
+	LibraryDir string       `json:"libraryDir,omitempty"`
+	Files      []backupFile `json:"files"`
+}
+
+// bundleFile is a file going into a bundle, with its content in Data or on
+// disk at Path.
+type bundleFile struct {
+	Name string
+	Data []byte
+	Path string
+}
+
+// writeBundle writes a bundle of the server state as a gzipped tar archive,
+// with a manifest followed by the files it lists.
+func writeBundle(w io.Writer, libraryDir string) error {
+	var files []bundleFile
+	for _, section := range backupSections {
+		data, err := section.Dump()
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.File, err)
+		}
+		files = append(files, bundleFile{Name: "catalog/" + section.File, Data: data})
+	}
+
+	albumsMu.RLock()
+	for _, a := range albums {
+		if path := albumCoverFile(a.ID); path != "" {
+			files = append(files, bundleFile{Name: "covers/albums/" + a.ID + strings.ToLower(filepath.Ext(path)), Path: path})
+		}
+	}
+	albumsMu.RUnlock()
+	if artistImageDir != "" {
+		images, _ := filepath.Glob(filepath.Join(artistImageDir, "*"))
+		for _, path := range images {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && filepath.Ext(path) != ".tmp" {
+				files = append(files, bundleFile{Name: "covers/artists/" + filepath.Base(path), Path: path})
+			}
+		}
+	}
+
+	manifest := bundleManifest{Format: bundleFormat, CreatedAt: time.Now().UTC(), LibraryDir: libraryDir}
+	for _, f := range files {
+		if f.Path == "" {
+			sum := sha256.Sum256(f.Data)
+			manifest.Files = append(manifest.Files, backupFile{Name: f.Name, Size: len(f.Data), SHA256: hex.EncodeToString(sum[:])})
+			continue
+		}
+		sum, size, err := sha256File(f.Path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, backupFile{Name: f.Name, Size: int(size), SHA256: sum})
+	}
+	rawManifest, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	files = append([]bundleFile{{Name: "manifest.json", Data: rawManifest}}, files...)
+	sizes := append([]backupFile{{Size: len(rawManifest)}}, manifest.Files...)
+	for i, f := range files {
+		hdr := &tar.Header{Name: f.Name, Mode: 0o644, Size: int64(sizes[i].Size), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := writeBundleFile(tw, f); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeBundleFile(w io.Writer, f bundleFile) error {
+	if f.Path == "" {
+		_, err := w.Write(f.Data)
+		return err
+	}
+	src, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// readBundle unpacks a bundle and checks every file against the manifest
+// checksums.
+func readBundle(r io.Reader) (bundleManifest, map[string][]byte, error) {
+	var manifest bundleManifest
+	files, err := readArchive(r)
+	if err != nil {
+		return manifest, nil, err
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if manifest.Format != bundleFormat {
+		return manifest, nil, fmt.Errorf("unsupported bundle format %d", manifest.Format)
+	}
+	return manifest, files, checkArchive(files, manifest.Files)
+}
+
+// rebasePath moves path from under the directory from to under to. Other
+// paths are left alone.
+func rebasePath(path, from, to string) string {
+	if path == "" || from == "" || to == "" || from == to {
+		return path
+	}
+	rel, err := filepath.Rel(from, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(to, rel)
+}
+
+// rebaseLibrary moves every stored path from the library directory from to
+// to. Callers must hold albumsMu.
+func rebaseLibrary(from, to string) int {
+	moved := 0
+	rebase := func(path *string) {
+		if p := rebasePath(*path, from, to); p != *path {
+			*path = p
+			moved++
+		}
+	}
+	for i := range tracks {
+		rebase(&tracks[i].Path)
+	}
+	for i := range trash {
+		rebase(&trash[i].Track.Path)
+		rebase(&trash[i].TrashPath)
+	}
+	for i := range quarantine {
+		rebase(&quarantine[i].Path)
+	}
+	for sum, obj := range objects {
+		rebase(&obj.Path)
+		objects[sum] = obj
+	}
+	return moved
+}
+
+// bundleImport says what importing a bundle did, or would do on a dry run.
+type bundleImport struct {
+	DryRun   bool           `json:"dryRun"`
+	Restored map[string]int `json:"restored"`
+	// Paths is how many stored paths were moved from the library
+	// directory of the bundle to this server's.
+	Paths        int    `json:"paths"`
+	LibraryDir   string `json:"libraryDir,omitempty"`
+	AlbumCovers  int    `json:"albumCovers"`
+	ArtistImages int    `json:"artistImages"`
+}
+
+// postBundle returns the handler that imports a bundle sent as the request
+// body onto this server, whose library is under root. A server that
+// already has albums or tracks is only replaced with ?force=true. With
+// ?dryRun=true it only reports what would be imported.
+func postBundle(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		manifest, files, err := readBundle(io.LimitReader(c.Request.Body, maxBundleSize))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": "invalid bundle: " + err.Error()})
+			return
+		}
+
+		albumsMu.RLock()
+		fresh := len(albums) == 0 && len(tracks) == 0
+		albumsMu.RUnlock()
+		if !fresh && c.Query("force") != "true" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "this server already has a library; import with force to replace it"})
+			return
+		}
+
+		result := bundleImport{DryRun: c.Query("dryRun") == "true", Restored: map[string]int{}, LibraryDir: root}
+		var applies []func()
+		for _, section := range backupSections {
+			data, ok := files["catalog/"+section.File]
+			if !ok {
+				continue
+			}
+			apply, count, err := section.Restore(data)
+			if err != nil {
+				c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": section.File + ": " + err.Error()})
+				return
+			}
+			applies = append(applies, apply)
+			result.Restored[section.File] = count
+		}
+		for name := range files {
+			switch {
+			case strings.HasPrefix(name, "covers/albums/"):
+				result.AlbumCovers++
+			case strings.HasPrefix(name, "covers/artists/") && artistImageDir != "":
+				result.ArtistImages++
+			}
+		}
+		if result.DryRun {
+			c.IndentedJSON(http.StatusOK, result)
+			return
+		}
+
+		for _, apply := range applies {
+			apply()
+		}
+		albumsMu.Lock()
+		result.Paths = rebaseLibrary(manifest.LibraryDir, root)
+		albumsMu.Unlock()
+		result.AlbumCovers, result.ArtistImages = importCovers(files)
+		publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+		c.IndentedJSON(http.StatusOK, result)
+	}
+}
+
+// importCovers writes the album covers of a bundle next to the albums'
+// tracks, where they have none yet, and its artist images into
+// artistImageDir. It returns how many of each it wrote.
+func importCovers(files map[string][]byte) (int, int) {
+	albumCovers, artistImages := 0, 0
+	for name, data := range files {
+		var path string
+		switch {
+		case strings.HasPrefix(name, "covers/albums/"):
+			file := strings.TrimPrefix(name, "covers/albums/")
+			id := strings.TrimSuffix(file, filepath.Ext(file))
+			albumsMu.RLock()
+			if albumCoverFile(id) == "" {
+				for _, t := range tracks {
+					if t.AlbumID == id && t.Path != "" {
+						path = filepath.Join(filepath.Dir(t.Path), "cover"+filepath.Ext(file))
+						break
+					}
+				}
+			}
+			albumsMu.RUnlock()
+		case strings.HasPrefix(name, "covers/artists/") && artistImageDir != "":
+			path = filepath.Join(artistImageDir, filepath.Base(name))
+		}
+		if path == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			continue
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			continue
+		}
+		if strings.HasPrefix(name, "covers/albums/") {
+			albumCovers++
+		} else {
+			artistImages++
+		}
+	}
+	return albumCovers, artistImages
+}
+
+// getBundle returns the handler that sends a bundle of the server state,
+// whose library is under root.
+func getBundle(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var buf bytes.Buffer
+		if err := writeBundle(&buf, root); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		name := "go-music-player-" + time.Now().UTC().Format("20060102T150405Z") + ".bundle.tar.gz"
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+	}
+}
+
+// runExport saves a bundle of a running server's state to a file, for the
+// export command.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	client := clientFlags(flags, 0)
+	out := flags.String("o", "go-music-player-"+time.Now().UTC().Format("20060102")+".bundle.tar.gz", "file to write the bundle to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	resp, err := client().send("GET", "/admin/bundle", nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported to %s\n", *out)
+	return nil
+}
+
+// runImport sends a bundle file to a running server, for the import
+// command.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	client := clientFlags(flags, 0)
+	force := flags.Bool("force", false, "replace a server that already has a library")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [flags] <bundle file>")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	path := fmt.Sprintf("/admin/bundle?force=%t&dryRun=%t", *force, *dryRun)
+	resp, err := client().send("POST", path, f, "application/gzip")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result bundleImport
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	verb := "Imported"
+	if result.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d catalog files, %d album covers and %d artist images; %d paths moved to %s\n", verb, len(result.Restored), result.AlbumCovers, result.ArtistImages, result.Paths, result.LibraryDir)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRebasePath(t *testing.T) {
+	// Check only paths under the old directory are moved
+	for path, want := range map[string]string{
+		"/srv/music/A/01.flac":  "/mnt/music/A/01.flac",
+		"/srv/music2/A/01.flac": "/srv/music2/A/01.flac",
+		"/elsewhere/01.flac":    "/elsewhere/01.flac",
+	} {
+		if got := rebasePath(path, "/srv/music", "/mnt/music"); got != want {
+			t.Errorf("Expected %s to become %s, but got %s", path, want, got)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	resetCatalog(t)
+	defer func(dir string) { artistImageDir = dir }(artistImageDir)
+	oldRoot, newRoot := t.TempDir(), t.TempDir()
+	artistImageDir = filepath.Join(oldRoot, ".artists")
+	os.MkdirAll(filepath.Join(oldRoot, "Miles Davis", "Kind of Blue"), 0o755)
+	os.MkdirAll(artistImageDir, 0o755)
+	os.WriteFile(filepath.Join(oldRoot, "Miles Davis", "Kind of Blue", "cover.jpg"), []byte("JPEG cover"), 0o644)
+	os.WriteFile(filepath.Join(artistImageDir, "miles-davis.png"), []byte("PNG portrait"), 0o644)
+	albums = []album{{ID: "kob", Title: "Kind of Blue", Artist: "Miles Davis"}}
+	tracks = []track{{ID: "1", AlbumID: "kob", Title: "So What", Path: filepath.Join(oldRoot, "Miles Davis", "Kind of Blue", "01 So What.flac")}}
+	playlists = []playlist{{ID: "1", Name: "Modal", Owner: "alice", TrackIDs: []string{"1"}}}
+	cfg := testConfig()
+	cfg.LibraryDir = oldRoot
+	old := httptest.NewServer(setupRouter(cfg))
+	defer old.Close()
+	file := filepath.Join(t.TempDir(), "state.bundle.tar.gz")
+
+	// Check the export command saves a bundle with the catalog and covers
+	if err := runExport([]string{"-url", old.URL, "-user", "alice", "-password", "alice-pw", "-o", file}); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	f, _ := os.Open(file)
+	manifest, files, err := readBundle(f)
+	f.Close()
+	if err != nil || manifest.LibraryDir != oldRoot || files["covers/albums/kob.jpg"] == nil || files["covers/artists/miles-davis.png"] == nil || files["catalog/playlists.json"] == nil {
+		t.Fatalf("Expected a bundle with covers and playlists, but got %v %+v", err, manifest)
+	}
+
+	// Check a server with a library is not replaced without force
+	cfg.LibraryDir = newRoot
+	fresh := httptest.NewServer(setupRouter(cfg))
+	defer fresh.Close()
+	importArgs := []string{"-url", fresh.URL, "-user", "alice", "-password", "alice-pw"}
+	if err := runImport(append(importArgs, file)); err == nil || !strings.Contains(err.Error(), "force") {
+		t.Errorf("Expected the import to need force, but got %v", err)
+	}
+
+	// Check the import command restores the state onto a fresh server
+	albums, tracks, playlists = nil, nil, nil
+	artistImageDir = filepath.Join(newRoot, ".artists")
+	if err := runImport(append(importArgs, file)); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	want := filepath.Join(newRoot, "Miles Davis", "Kind of Blue", "01 So What.flac")
+	if len(albums) != 1 || len(tracks) != 1 || tracks[0].Path != want || len(playlists) != 1 {
+		t.Errorf("Expected the library moved to %s, but got %+v and %+v", newRoot, tracks, playlists)
+	}
+	cover, _ := os.ReadFile(filepath.Join(newRoot, "Miles Davis", "Kind of Blue", "cover.jpg"))
+	portrait, _ := os.ReadFile(filepath.Join(artistImageDir, "miles-davis.png"))
+	if string(cover) != "JPEG cover" || string(portrait) != "PNG portrait" {
+		t.Errorf("Expected the covers to be written, but got %q and %q", cover, portrait)
+	}
+
+	// Check a damaged bundle is refused
+	data, _ := os.ReadFile(file)
+	rr := performRequest(setupRouter(cfg), "POST", "/admin/bundle?force=true", strings.NewReader(string(data[:len(data)/2])), "alice")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// event is a message published on the bus. Source identifies the instance
+// that published it so handlers can skip work that instance already did.
+type event struct {
+	Topic   string          `json:"topic"`
+	Source  string          `json:"source"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// eventBus fans events out to every subscribed handler on every instance.
+type eventBus interface {
+	Publish(ctx context.Context, e event) error
+	Subscribe(topic string, handler func(event))
+}
+
+const (
+	topicAlbumCreated      = "album.created"
+	topicAlbumUpdated      = "album.updated"
+	topicAlbumDeleted      = "album.deleted"
+	topicTrackAnalyzed     = "track.analyzed"
+	topicTrackEmbedded     = "track.embedded"
+	topicCacheInvalidation = "cache.invalidate"
+	topicStreamInterrupted = "stream.interrupted"
+)
+
+var (
+	bus        eventBus = newMemoryBus()
+	instanceID          = newInstanceID()
+)
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newEventBus returns a Redis Streams bus when a Redis URL is configured and
+// an in-process one otherwise.
+func newEventBus(cfg config) (eventBus, error) {
+	if cfg.RedisURL == "" {
+		return newMemoryBus(), nil
+	}
+	return newRedisBus(cfg.RedisURL)
+}
+
+// publishEvent encodes payload as JSON and publishes it on topic.
+func publishEvent(ctx context.Context, topic string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(ctx, event{Topic: topic, Source: instanceID, Payload: raw})
+}
+
+// subscribeAlbumEvents keeps this instance's catalog and response cache in
+// step with changes made on other instances.
+func subscribeAlbumEvents(b eventBus) {
+	applyChange := func(e event) {
+		if e.Source == instanceID {
+			return
+		}
+		var change albumChange
+		if err := json.Unmarshal(e.Payload, &change); err != nil {
+			log.Printf("bus: bad %s payload: %v", e.Topic, err)
+			return
+		}
+
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		if i := findAlbum(change.Album.ID); i >= 0 {
+			setAlbumSlug(&change.Album, albums[i].Slug)
+			libStats.replaceAlbum(albums[i], change.Album)
+			albums[i] = change.Album
+		} else {
+			setAlbumSlug(&change.Album, "")
+			albums = append(albums, change.Album)
+			libStats.addAlbum(change.Album)
+		}
+		if change.PriceChange != nil {
+			recordPriceChange(change.Album.ID, *change.PriceChange)
+		}
+	}
+	b.Subscribe(topicAlbumCreated, applyChange)
+	b.Subscribe(topicAlbumUpdated, applyChange)
+	b.Subscribe(topicAlbumDeleted, func(e event) {
+		if e.Source == instanceID {
+			return
+		}
+		var deletion albumDeletion
+		if err := json.Unmarshal(e.Payload, &deletion); err != nil {
+			log.Printf("bus: bad %s payload: %v", e.Topic, err)
+			return
+		}
+
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		if i := findAlbum(deletion.ID); i >= 0 {
+			libStats.removeAlbum(albums[i])
+			albums = append(albums[:i], albums[i+1:]...)
+		}
+	})
+	b.Subscribe(topicTrackAnalyzed, func(e event) {
+		if e.Source == instanceID {
+			return
+		}
+		var analyzed trackAnalyzed
+		if err := json.Unmarshal(e.Payload, &analyzed); err != nil {
+			log.Printf("bus: bad %s payload: %v", e.Topic, err)
+			return
+		}
+		setTrackAnalysis(analyzed.ID, analyzed.Analysis)
+	})
+	b.Subscribe(topicTrackEmbedded, func(e event) {
+		if e.Source == instanceID {
+			return
+		}
+		var embedded trackEmbedded
+		if err := json.Unmarshal(e.Payload, &embedded); err != nil {
+			log.Printf("bus: bad %s payload: %v", e.Topic, err)
+			return
+		}
+		if err := embeddings.Add(embedded.ID, embedded.Vector); err != nil {
+			log.Printf("bus: track %s: %v", embedded.ID, err)
+		}
+	})
+	b.Subscribe(topicCacheInvalidation, func(e event) {
+		invalidateResponses(context.Background())
+	})
+}
+
+// albumChange is the payload of album events.
+type albumChange struct {
+	Album       album        `json:"album"`
+	PriceChange *priceChange `json:"priceChange,omitempty"`
+}
+
+// albumDeletion is the payload of album.deleted events.
+type albumDeletion struct {
+	ID string `json:"id"`
+}
+
+// trackAnalyzed is the payload of track.analyzed events.
+type trackAnalyzed struct {
+	ID       string        `json:"id"`
+	Analysis trackAnalysis `json:"analysis"`
+}
+
+// trackEmbedded is the payload of track.embedded events.
+type trackEmbedded struct {
+	ID     string    `json:"id"`
+	Vector []float32 `json:"vector"`
+}
+
+// publishAlbumDeletes tells other instances about deleted albums.
+func publishAlbumDeletes(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		publishEvent(ctx, topicAlbumDeleted, albumDeletion{ID: id})
+	}
+}
+
+type memoryBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(event)
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{handlers: make(map[string][]func(event))}
+}
+
+func (m *memoryBus) Publish(_ context.Context, e event) error {
+	m.mu.RLock()
+	handlers := m.handlers[e.Topic]
+	m.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+	return nil
+}
+
+func (m *memoryBus) Subscribe(topic string, handler func(event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[topic] = append(m.handlers[topic], handler)
+}
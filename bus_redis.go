@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisEventStream    = "events"
+	redisEventStreamLen = 10000
+)
+
+// redisBus publishes every event to a single Redis stream. Each instance
+// reads the stream from the point it started, so all instances see all
+// events published while they are running.
+type redisBus struct {
+	client *redis.Client
+	local  *memoryBus
+
+	mu     sync.Mutex
+	lastID string
+}
+
+func newRedisBus(url string) (*redisBus, error) {
+	client, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+	// Start after the newest existing entry rather than at "$" so events
+	// published between two polls are not skipped.
+	lastID := "0-0"
+	latest, err := client.XRevRangeN(context.Background(), redisEventStream, "+", "-", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(latest) > 0 {
+		lastID = latest[0].ID
+	}
+	return &redisBus{client: client, local: newMemoryBus(), lastID: lastID}, nil
+}
+
+func (r *redisBus) Publish(ctx context.Context, e event) error {
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisEventStream,
+		MaxLen: redisEventStreamLen,
+		Approx: true,
+		Values: map[string]any{
+			"topic":   e.Topic,
+			"source":  e.Source,
+			"payload": string(e.Payload),
+		},
+	}).Err()
+}
+
+func (r *redisBus) Subscribe(topic string, handler func(event)) {
+	r.local.Subscribe(topic, handler)
+}
+
+// Run delivers events from the stream to local subscribers until ctx is
+// cancelled.
+func (r *redisBus) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := r.poll(ctx, 5*time.Second); err != nil && ctx.Err() == nil {
+			log.Printf("bus: reading %s: %v", redisEventStream, err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// poll blocks for up to block waiting for new events and dispatches them.
+func (r *redisBus) poll(ctx context.Context, block time.Duration) error {
+	r.mu.Lock()
+	lastID := r.lastID
+	r.mu.Unlock()
+
+	streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{redisEventStream, lastID},
+		Block:   block,
+		Count:   100,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			topic, _ := msg.Values["topic"].(string)
+			source, _ := msg.Values["source"].(string)
+			payload, _ := msg.Values["payload"].(string)
+			r.local.Publish(ctx, event{Topic: topic, Source: source, Payload: []byte(payload)})
+
+			r.mu.Lock()
+			r.lastID = msg.ID
+			r.mu.Unlock()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Album events from another instance are applied to the local catalog
+func TestAlbumCreatedFromOtherInstance(t *testing.T) {
+	saved := albums
+	t.Cleanup(func() { albums = saved })
+
+	// Subscribe a fresh bus and publish an event from a foreign source
+	b := newMemoryBus()
+	subscribeAlbumEvents(b)
+	payload, _ := json.Marshal(albumChange{Album: album{ID: "4", Title: "Giant Steps", Artist: "John Coltrane", Price: 24.99}})
+	b.Publish(context.Background(), event{Topic: topicAlbumCreated, Source: "other", Payload: payload})
+
+	// Check that the album was appended
+	if len(albums) != len(saved)+1 || albums[len(albums)-1].ID != "4" {
+		t.Errorf("Expected album 4 to be appended, but got %v", albums)
+	}
+
+	// Check that our own events are not applied twice
+	b.Publish(context.Background(), event{Topic: topicAlbumCreated, Source: instanceID, Payload: payload})
+	if len(albums) != len(saved)+1 {
+		t.Errorf("Expected %d albums, but got %d", len(saved)+1, len(albums))
+	}
+}
+
+// Deletions from another instance remove the album locally
+func TestAlbumDeletedFromOtherInstance(t *testing.T) {
+	resetCatalog(t)
+	b := newMemoryBus()
+	subscribeAlbumEvents(b)
+	payload, _ := json.Marshal(albumDeletion{ID: "2"})
+
+	// Check that our own events are not applied twice
+	b.Publish(context.Background(), event{Topic: topicAlbumDeleted, Source: instanceID, Payload: payload})
+	if len(albums) != 3 {
+		t.Errorf("Expected 3 albums, but got %d", len(albums))
+	}
+
+	// Check that a foreign deletion removes the album
+	b.Publish(context.Background(), event{Topic: topicAlbumDeleted, Source: "other", Payload: payload})
+	if len(albums) != 2 || findAlbum("2") >= 0 {
+		t.Errorf("Expected album 2 to be removed, but got %v", albums)
+	}
+}
+
+// Tracks analyzed by the leader are analyzed on every instance
+func TestTrackAnalyzedFromOtherInstance(t *testing.T) {
+	resetCatalog(t)
+	tracks = []track{{ID: "1", AlbumID: "1", Path: "so-what.flac"}}
+	b := newMemoryBus()
+	subscribeAlbumEvents(b)
+
+	// Check the analysis, waveform included, is stored
+	payload, _ := json.Marshal(trackAnalyzed{ID: "1", Analysis: trackAnalysis{BPM: 136, Key: "8A", Peaks: []float64{0.5, 1}}})
+	b.Publish(context.Background(), event{Topic: topicTrackAnalyzed, Source: "other", Payload: payload})
+	if tracks[0].Analysis == nil || tracks[0].Analysis.BPM != 136 || len(waveforms["1"]) != 2 {
+		t.Errorf("Expected the analysis of track 1, but got %+v", tracks[0].Analysis)
+	}
+}
+
+// Tracks embedded by the leader can be found similar on every instance
+func TestTrackEmbeddedFromOtherInstance(t *testing.T) {
+	useEmbeddings(t)
+	b := newMemoryBus()
+	subscribeAlbumEvents(b)
+
+	// Check the vector is added to the index
+	payload, _ := json.Marshal(trackEmbedded{ID: "1", Vector: []float32{1, 0.5}})
+	b.Publish(context.Background(), event{Topic: topicTrackEmbedded, Source: "other", Payload: payload})
+	if !embeddings.Has("1") {
+		t.Errorf("Expected the embedding of track 1, but got %d embeddings", embeddings.Len())
+	}
+}
+
+// recordAlbumDeletes swaps in a bus that collects the ids of the albums
+// deleted until the test finishes.
+func recordAlbumDeletes(t *testing.T) *[]string {
+	saved := bus
+	t.Cleanup(func() { bus = saved })
+	b := newMemoryBus()
+	var ids []string
+	b.Subscribe(topicAlbumDeleted, func(e event) {
+		var deletion albumDeletion
+		json.Unmarshal(e.Payload, &deletion)
+		ids = append(ids, deletion.ID)
+	})
+	bus = b
+	return &ids
+}
+
+// recordEvents swaps in a bus that collects the events published on topic
+// until the test finishes.
+func recordEvents(t *testing.T, topic string) *[]event {
+	saved := bus
+	t.Cleanup(func() { bus = saved })
+	b := newMemoryBus()
+	var events []event
+	b.Subscribe(topic, func(e event) { events = append(events, e) })
+	bus = b
+	return &events
+}
+
+// Events published through Redis reach subscribers on every instance
+func TestRedisBusDeliversEvents(t *testing.T) {
+	// Start an in-process Redis server shared by two bus instances
+	mr := miniredis.RunT(t)
+	publisher, err := newRedisBus("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create redis bus: %s", err.Error())
+	}
+	subscriber, _ := newRedisBus("redis://" + mr.Addr())
+
+	// Record the events seen by the subscribing instance
+	var got []event
+	subscriber.Subscribe(topicCacheInvalidation, func(e event) { got = append(got, e) })
+
+	// Publish one event and poll for it
+	ctx := context.Background()
+	publisher.Publish(ctx, event{Topic: topicCacheInvalidation, Source: "a", Payload: []byte("null")})
+	if err := subscriber.poll(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Failed to poll: %s", err.Error())
+	}
+
+	// Check the event arrived exactly once
+	if len(got) != 1 || got[0].Source != "a" {
+		t.Errorf("Expected one event from source a, but got %v", got)
+	}
+	subscriber.poll(ctx, 10*time.Millisecond)
+	if len(got) != 1 {
+		t.Errorf("Expected no redelivery, but got %d events", len(got))
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cache is the key/value store shared by every instance of the server. It
+// backs response caching, rate-limit counters and any session or queue state
+// that must look the same no matter which instance serves a request.
+type cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key; a zero ttl keeps it until deleted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Incr atomically increments the counter at key and returns the new
+	// value. The ttl is applied when the counter is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+var sharedCache cache = newMemoryCache()
+
+// newCache returns a Redis-backed cache when a Redis URL is configured and an
+// in-process one otherwise.
+func newCache(cfg config) (cache, error) {
+	if cfg.RedisURL == "" {
+		return newMemoryCache(), nil
+	}
+	return newRedisCache(cfg.RedisURL)
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]memoryEntry)}
+}
+
+func (m *memoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (m *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryEntry{value: value, expires: expiry(ttl)}
+	return nil
+}
+
+func (m *memoryCache) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *memoryCache) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		e = memoryEntry{value: []byte("0"), expires: expiry(ttl)}
+	}
+	n, err := strconv.ParseInt(string(e.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	e.value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = e
+	return n, nil
+}
+
+// prune drops every expired entry.
+func (m *memoryCache) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.entries {
+		m.lookup(key)
+	}
+}
+
+// lookup returns the live entry for key, dropping it if it has expired.
+// Callers must hold m.mu.
+func (m *memoryCache) lookup(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+const responseGenerationKey = "response:generation"
+
+// cachedResponse is a response as the handler wrote it. Header holds the
+// headers the handler set, such as Warning, Link and X-Total-Count, but not
+// those set before it by middleware, which are fresh for every request.
+type cachedResponse struct {
+	Status      int         `json:"status"`
+	ContentType string      `json:"contentType"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body"`
+}
+
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheResponse serves successful GET responses from the shared cache for up
+// to ttl. Entries are keyed by the current response generation so a single
+// invalidateResponses call retires them on every instance at once.
+func cacheResponse(ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ttl <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		generation, _, err := sharedCache.Get(ctx, responseGenerationKey)
+		if err != nil {
+			c.Next()
+			return
+		}
+		key := "response:" + string(generation) + ":" + c.Request.URL.RequestURI()
+		if isGuest(c) {
+			key += "#guest"
+		}
+		if wantsEnvelope(c) {
+			key += "#envelope"
+		}
+
+		if raw, ok, err := sharedCache.Get(ctx, key); err == nil && ok {
+			var cached cachedResponse
+			if json.Unmarshal(raw, &cached) == nil {
+				for name, values := range cached.Header {
+					c.Writer.Header()[name] = values
+				}
+				c.Data(cached.Status, cached.ContentType, cached.Body)
+				c.Abort()
+				return
+			}
+		}
+
+		before := c.Writer.Header().Clone()
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() != http.StatusOK {
+			return
+		}
+		header := http.Header{}
+		for name, values := range recorder.Header() {
+			if name != "Content-Type" && name != "Set-Cookie" && !slices.Equal(values, before[name]) {
+				header[name] = values
+			}
+		}
+		raw, err := json.Marshal(cachedResponse{
+			Status:      recorder.Status(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Header:      header,
+			Body:        recorder.body.Bytes(),
+		})
+		if err == nil {
+			sharedCache.Set(ctx, key, raw, ttl)
+		}
+	}
+}
+
+// invalidateResponses retires every cached response.
+func invalidateResponses(ctx context.Context) error {
+	_, err := sharedCache.Incr(ctx, responseGenerationKey, 0)
+	return err
+}
+
+// rateLimit allows each client IP at most limit requests per window, counted
+// in the shared cache so the budget holds across instances.
+func rateLimit(limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if withinRateLimit(c, "ratelimit:", limit, window) {
+			c.Next()
+		}
+	}
+}
+
+// withinRateLimit counts the request against the client IP's budget under
+// prefix. Once the budget is spent it aborts with 429 and reports false.
+func withinRateLimit(c *gin.Context, prefix string, limit int, window time.Duration) bool {
+	if limit <= 0 || c.Request.Context().Value(headRequestKey{}) != nil {
+		return true
+	}
+
+	bucket := time.Now().UnixNano() / int64(window)
+	key := prefix + c.ClientIP() + ":" + strconv.FormatInt(bucket, 10)
+	n, err := sharedCache.Incr(c.Request.Context(), key, window)
+	if err != nil {
+		return true
+	}
+	if n > int64(limit) {
+		c.Header("Retry-After", strconv.Itoa(int(window.Seconds())))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "rate limit exceeded"})
+		return false
+	}
+	return true
+}
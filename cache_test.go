@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// useCache swaps the shared cache for the duration of a test
+func useCache(t *testing.T, c cache) {
+	previous := sharedCache
+	sharedCache = c
+	t.Cleanup(func() { sharedCache = previous })
+}
+
+// Both cache backends honour Set/Get/Delete and count with Incr
+func TestCacheBackends(t *testing.T) {
+	// Start an in-process Redis server for the Redis adapter
+	mr := miniredis.RunT(t)
+	redisBackend, err := newRedisCache("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create redis cache: %s", err.Error())
+	}
+
+	backends := map[string]cache{"memory": newMemoryCache(), "redis": redisBackend}
+	for name, c := range backends {
+		ctx := context.Background()
+
+		// Check that a stored value can be read back and deleted
+		if err := c.Set(ctx, "key", []byte("value"), time.Minute); err != nil {
+			t.Fatalf("%s: Set failed: %s", name, err.Error())
+		}
+		if value, ok, _ := c.Get(ctx, "key"); !ok || string(value) != "value" {
+			t.Errorf("%s: Expected value %q, but got %q", name, "value", value)
+		}
+		c.Delete(ctx, "key")
+		if _, ok, _ := c.Get(ctx, "key"); ok {
+			t.Errorf("%s: Expected key to be deleted", name)
+		}
+
+		// Check that counters increment from one
+		for want := int64(1); want <= 3; want++ {
+			if n, _ := c.Incr(ctx, "counter", time.Minute); n != want {
+				t.Errorf("%s: Expected counter %d, but got %d", name, want, n)
+			}
+		}
+	}
+}
+
+// Cached GET responses are served until they are invalidated
+func TestCacheResponseInvalidation(t *testing.T) {
+	useCache(t, newMemoryCache())
+	saved := albums
+	t.Cleanup(func() { albums = saved })
+
+	// Create a router that counts how often the handler actually runs
+	calls := 0
+	router := gin.New()
+	router.GET("/albums", cacheResponse(time.Minute), func(c *gin.Context) {
+		calls++
+		getAlbums(c)
+	})
+
+	// Serve the same request twice and check the second one came from the cache
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/albums", nil)
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected handler to run once, but it ran %d times", calls)
+	}
+
+	// Invalidate and check the handler runs again
+	invalidateResponses(context.Background())
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/albums", nil)
+	router.ServeHTTP(rr, req)
+	if calls != 2 {
+		t.Errorf("Expected handler to run twice, but it ran %d times", calls)
+	}
+}
+
+// Cached responses keep the headers their handler set
+func TestCacheResponseHeaders(t *testing.T) {
+	useCache(t, newMemoryCache())
+
+	// Create a router whose middleware tags each request and whose
+	// handler pages its results
+	requests := 0
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		requests++
+		c.Header("X-Request-Id", strconv.Itoa(requests))
+	})
+	calls := 0
+	router.GET("/albums", cacheResponse(time.Minute), func(c *gin.Context) {
+		calls++
+		c.Header("Vary", "Accept")
+		c.Header("Warning", `299 - "Deprecated album id"`)
+		c.Header("Link", `</albums?offset=10>; rel="next"`)
+		c.Header("X-Total-Count", "42")
+		c.IndentedJSON(http.StatusOK, []album{})
+	})
+
+	// Check the second response, from the cache, has the handler's headers
+	// and its own request id
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		rr = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/albums", nil)
+		router.ServeHTTP(rr, req)
+	}
+	if calls != 1 {
+		t.Errorf("Expected handler to run once, but it ran %d times", calls)
+	}
+	want := map[string]string{
+		"Vary":          "Accept",
+		"Warning":       `299 - "Deprecated album id"`,
+		"Link":          `</albums?offset=10>; rel="next"`,
+		"X-Total-Count": "42",
+		"X-Request-Id":  "2",
+		"Content-Type":  "application/json; charset=utf-8",
+	}
+	for name, value := range want {
+		if got := rr.Header().Values(name); len(got) != 1 || got[0] != value {
+			t.Errorf("Expected %s: %s, but got %q", name, value, got)
+		}
+	}
+}
+
+// Requests over the limit are rejected with 429
+func TestRateLimit(t *testing.T) {
+	useCache(t, newMemoryCache())
+
+	// Create a router that allows two requests per minute
+	router := gin.New()
+	router.Use(rateLimit(2, time.Minute))
+	router.GET("/albums", getAlbums)
+
+	// Check the third request is rejected
+	codes := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for _, want := range codes {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/albums", nil)
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Expected status code %d, but got %d", want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "sync"
+
+// idIndex maps ids to positions in a catalog slice so lookups in large
+// libraries do not scan it. The slices are changed in many places, so the
+// index is not kept up to date as they change; instead a position is
+// checked before it is trusted, and the index is rebuilt when it turns out
+// to be stale. A missing id costs a scan, as it did before the index.
+type idIndex struct {
+	mu  sync.Mutex
+	pos map[string]int
+}
+
+var albumIndex, trackIndex idIndex
+
+// find returns the position of id among the n items whose ids idAt
+// returns, or -1.
+func (x *idIndex) find(id string, n int, idAt func(int) string) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if i, ok := x.pos[id]; ok && i < n && idAt(i) == id {
+		return i
+	}
+	found := -1
+	for i := 0; i < n; i++ {
+		if idAt(i) == id {
+			found = i
+			break
+		}
+	}
+	if found >= 0 {
+		x.pos = make(map[string]int, n)
+		for i := n - 1; i >= 0; i-- {
+			x.pos[idAt(i)] = i
+		}
+	}
+	return found
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCatalogIndex(t *testing.T) {
+	resetCatalog(t)
+	tracks = []track{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+
+	// Check lookups survive the slice changing under the index
+	if findTrack("3") != 2 {
+		t.Errorf("Expected track 3 at 2")
+	}
+	tracks = append(tracks[:0], tracks[1:]...)
+	if i := findTrack("3"); i != 1 {
+		t.Errorf("Expected track 3 at 1 after a removal, but got %d", i)
+	}
+	if i := findTrack("1"); i != -1 {
+		t.Errorf("Expected removed track 1 to be missing, but got %d", i)
+	}
+	tracks = append(tracks, track{ID: "4"})
+	tracks[0].ID = "5"
+	if findTrack("4") != 2 || findTrack("5") != 0 || findTrack("2") != -1 {
+		t.Errorf("Expected appended and renamed tracks to be found")
+	}
+}
+
+func TestKeysetPagination(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	for i := 1; i <= 5; i++ {
+		tracks = append(tracks, track{ID: strconv.Itoa(i), AlbumID: "1", Title: "Track " + strconv.Itoa(i)})
+	}
+	page := func(path string) ([]string, string) {
+		rr := performRequest(router, "GET", path, nil, "")
+		var listed []track
+		json.Unmarshal(rr.Body.Bytes(), &listed)
+		var ids []string
+		for _, t := range listed {
+			ids = append(ids, t.ID)
+		}
+		return ids, rr.Header().Get("Link")
+	}
+
+	// Check the Link header leads from page to page
+	ids, link := page("/tracks?limit=2")
+	if strings.Join(ids, ",") != "1,2" || link != `</tracks?after=2&limit=2>; rel="next"` {
+		t.Fatalf("Expected tracks 1 and 2 and a next link, but got %v %q", ids, link)
+	}
+	ids, link = page("/tracks?limit=2&after=2")
+	if strings.Join(ids, ",") != "3,4" {
+		t.Errorf("Expected tracks 3 and 4, but got %v", ids)
+	}
+
+	// Check a page stays put when tracks in front of it go away
+	tracks = tracks[2:]
+	ids, link = page("/tracks?limit=2&after=4")
+	if strings.Join(ids, ",") != "5" || link != "" {
+		t.Errorf("Expected only track 5 and no next link, but got %v %q", ids, link)
+	}
+
+	for _, path := range []string{"/tracks?after=99", "/tracks?after=3&offset=1"} {
+		if rr := performRequest(router, "GET", path, nil, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, path, rr.Code)
+		}
+	}
+}
+
+// largeLibrary fills the catalog with n tracks over n/10 albums.
+func largeLibrary(b *testing.B, n int) {
+	savedAlbums, savedTracks := albums, tracks
+	b.Cleanup(func() { albums, tracks = savedAlbums, savedTracks })
+	albums, tracks = nil, nil
+	for i := 0; i < n/10; i++ {
+		albums = append(albums, album{ID: "a" + strconv.Itoa(i), Title: "Album " + strconv.Itoa(i), Artist: "Artist " + strconv.Itoa(i%500), Genre: []string{"Jazz", "Rock", "Folk"}[i%3], Year: 1950 + i%70})
+	}
+	for i := 0; i < n; i++ {
+		tracks = append(tracks, track{ID: strconv.Itoa(i), AlbumID: "a" + strconv.Itoa(i/10), Title: "Track " + strconv.Itoa(i), Number: i%10 + 1, Duration: 180, Format: "flac"})
+	}
+}
+
+func BenchmarkFindTrack(b *testing.B) {
+	largeLibrary(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findTrack(strconv.Itoa(i % 100000))
+	}
+}
+
+func BenchmarkGetAlbum(b *testing.B) {
+	largeLibrary(b, 100000)
+	router := setupRouter(testConfig())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rr := performRequest(router, "GET", "/albums/a"+strconv.Itoa(i%10000), nil, ""); rr.Code != http.StatusOK {
+			b.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+		}
+	}
+}
+
+func BenchmarkListTracks(b *testing.B) {
+	largeLibrary(b, 100000)
+	router := setupRouter(testConfig())
+	for _, path := range []string{"/tracks?limit=50&offset=99000", "/tracks?limit=50&after=99000", "/tracks?limit=50&q=title:%22track%209999%22"} {
+		b.Run(path, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if rr := performRequest(router, "GET", path, nil, ""); rr.Code != http.StatusOK {
+					b.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	largeLibrary(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := searchLibrary("genre:jazz year>=1990 duration<200"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
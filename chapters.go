@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chapterRestartWindow is how far into a chapter going back restarts it
+// instead of moving to the previous one, like the previous-track button of
+// most players.
+const chapterRestartWindow = 3.0
+
+// chapter is a titled section of a long track such as an audiobook or a DJ
+// mix. Start and End are offsets in seconds.
+type chapter struct {
+	Title string  `json:"title,omitempty"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+var errNoChapters = errors.New("the current track has no chapters")
+
+// chapterAnalyzer reads chapter markers with ffmpeg, which understands both
+// ID3 CHAP frames in MP3 files and the chapter lists of M4B files.
+type chapterAnalyzer struct {
+	ffmpeg string
+}
+
+func (a chapterAnalyzer) Analyze(ctx context.Context, path string) (trackAnalysis, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.ffmpeg, "-hide_banner", "-nostats", "-loglevel", "error",
+		"-i", path, "-f", "ffmetadata", "-")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return trackAnalysis{}, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	chapters, err := parseFFMetadata(&stdout)
+	if err != nil {
+		return trackAnalysis{}, err
+	}
+	return trackAnalysis{Chapters: chapters}, nil
+}
+
+// parseFFMetadata reads the [CHAPTER] sections of ffmpeg's metadata format.
+// Times are given in units of TIMEBASE, which defaults to milliseconds.
+func parseFFMetadata(r io.Reader) ([]chapter, error) {
+	type section struct {
+		timebase   float64
+		start, end int64
+		title      string
+	}
+	var sections []*section
+	var current *section
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Values may continue on the next line after an escaped newline.
+		for strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) && scanner.Scan() {
+			line = line[:len(line)-1] + "\n" + scanner.Text()
+		}
+		switch {
+		case line == "" || line[0] == ';' || line[0] == '#':
+			continue
+		case line == "[CHAPTER]":
+			current = &section{timebase: 0.001}
+			sections = append(sections, current)
+			continue
+		case line[0] == '[':
+			current = nil
+			continue
+		case current == nil:
+			continue
+		}
+		key, value, ok := splitMetadataLine(line)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "timebase":
+			num, den, ok := strings.Cut(value, "/")
+			n, err1 := strconv.ParseFloat(num, 64)
+			d, err2 := strconv.ParseFloat(den, 64)
+			if !ok || err1 != nil || err2 != nil || d == 0 {
+				return nil, fmt.Errorf("bad chapter timebase %q", value)
+			}
+			current.timebase = n / d
+		case "start", "end":
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad chapter %s %q", strings.ToLower(key), value)
+			}
+			if strings.EqualFold(key, "start") {
+				current.start = v
+			} else {
+				current.end = v
+			}
+		case "title":
+			current.title = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	chapters := make([]chapter, 0, len(sections))
+	for _, s := range sections {
+		chapters = append(chapters, chapter{
+			Title: s.title,
+			Start: float64(s.start) * s.timebase,
+			End:   float64(s.end) * s.timebase,
+		})
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	return chapters, nil
+}
+
+// splitMetadataLine splits key=value at the first unescaped '=' and removes
+// the backslash escapes of the metadata format.
+func splitMetadataLine(line string) (key, value string, ok bool) {
+	var b strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '=' && !ok:
+			key, ok = b.String(), true
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return key, b.String(), ok
+}
+
+// validateChapters checks chapters supplied by a client.
+func validateChapters(chapters []chapter) error {
+	for i, ch := range chapters {
+		if ch.Start < 0 || ch.End < ch.Start {
+			return fmt.Errorf("chapter %d must start at 0 or later and not end before it starts", i+1)
+		}
+		if i > 0 && ch.Start < chapters[i-1].Start {
+			return errors.New("chapters must be in order")
+		}
+	}
+	return nil
+}
+
+// chapterAt returns the index of the chapter playing at pos, or -1 before
+// the first chapter.
+func chapterAt(chapters []chapter, pos float64) int {
+	return sort.Search(len(chapters), func(i int) bool { return chapters[i].Start > pos }) - 1
+}
+
+// trackChapters returns the chapters of a track.
+func trackChapters(id string) []chapter {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if i := findTrack(id); i >= 0 {
+		return tracks[i].Chapters
+	}
+	return nil
+}
+
+// getTrackChapters lists the chapters of a track; tracks without chapter
+// markers have an empty list.
+func getTrackChapters(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	chapters := tracks[i].Chapters
+	if chapters == nil {
+		chapters = []chapter{}
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"trackId": tracks[i].ID, "duration": tracks[i].Duration, "chapters": chapters})
+}
+
+// currentChapters returns the chapters of the current track. Callers must
+// hold p.mu.
+func (p *player) currentChapters() ([]chapter, error) {
+	p.sync()
+	if p.index >= len(p.queue) {
+		return nil, errEmptyQueue
+	}
+	chapters := trackChapters(p.queue[p.index])
+	if len(chapters) == 0 {
+		return nil, errNoChapters
+	}
+	return chapters, nil
+}
+
+// jumpToChapter moves to the start of a chapter of the current track and
+// keeps the current play/pause state. Callers must hold p.mu.
+func (p *player) jumpToChapter(ch chapter) playerStatus {
+	p.position = ch.Start
+	p.resumedAt = p.now()
+	if p.state == stateStopped {
+		p.state = statePaused
+	}
+	p.touch()
+	return p.status()
+}
+
+// SkipChapter moves by delta chapters within the current track. Going back
+// more than a few seconds into a chapter restarts it first.
+func (p *player) SkipChapter(delta int) (playerStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	chapters, err := p.currentChapters()
+	if err != nil {
+		return p.status(), err
+	}
+	pos := p.elapsed()
+	current := chapterAt(chapters, pos)
+	target := current + delta
+	if delta < 0 && current >= 0 && pos-chapters[current].Start > chapterRestartWindow {
+		target = current + delta + 1
+	}
+	target = min(max(target, 0), len(chapters)-1)
+	return p.jumpToChapter(chapters[target]), nil
+}
+
+// SeekChapter moves to the start of chapter n of the current track.
+func (p *player) SeekChapter(n int) (playerStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	chapters, err := p.currentChapters()
+	if err != nil {
+		return p.status(), err
+	}
+	if n < 0 || n >= len(chapters) {
+		return p.status(), fmt.Errorf("the current track has %d chapters", len(chapters))
+	}
+	return p.jumpToChapter(chapters[n]), nil
+}
+
+func postNextChapter(c *gin.Context) {
+	status, err := musicPlayer.SkipChapter(1)
+	respondPlayer(c, status, err)
+}
+
+func postPreviousChapter(c *gin.Context) {
+	status, err := musicPlayer.SkipChapter(-1)
+	respondPlayer(c, status, err)
+}
+
+// putChapter jumps to a chapter of the current track by its index.
+func putChapter(c *gin.Context) {
+	var input struct {
+		Index *int `json:"index"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Index == nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "index is required"})
+		return
+	}
+	status, err := musicPlayer.SeekChapter(*input.Index)
+	respondPlayer(c, status, err)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFFMetadata(t *testing.T) {
+	metadata := `;FFMETADATA1
+title=Book
+[CHAPTER]
+TIMEBASE=1/1000
+START=60000
+END=120000
+title=Two\=2
+[CHAPTER]
+TIMEBASE=1/44100
+START=0
+END=2646000
+title=One
+`
+	chapters, err := parseFFMetadata(strings.NewReader(metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check chapters are sorted, converted to seconds and unescaped
+	if len(chapters) != 2 {
+		t.Fatalf("Expected 2 chapters, but got %+v", chapters)
+	}
+	if chapters[0] != (chapter{Title: "One", Start: 0, End: 60}) {
+		t.Errorf("Expected chapter One from 0s to 60s, but got %+v", chapters[0])
+	}
+	if chapters[1] != (chapter{Title: "Two=2", Start: 60, End: 120}) {
+		t.Errorf("Expected chapter Two=2 from 60s to 120s, but got %+v", chapters[1])
+	}
+
+	// Check a bad timebase is reported
+	if _, err := parseFFMetadata(strings.NewReader("[CHAPTER]\nTIMEBASE=1/0\n")); err == nil {
+		t.Errorf("Expected an error for a zero timebase")
+	}
+}
+
+func TestChapterSeeking(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Title: "Mix", Duration: 300}}
+
+	// Check chapters stored with an analysis are served per track
+	performRequest(router, "PUT", "/tracks/1/analysis", strings.NewReader(`{"chapters": [
+		{"title": "Intro", "start": 0, "end": 100},
+		{"title": "Middle", "start": 100, "end": 200},
+		{"title": "Outro", "start": 200, "end": 300}]}`), "alice")
+	rr := performRequest(router, "GET", "/tracks/1/chapters", nil, "")
+	var response struct {
+		Chapters []chapter `json:"chapters"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Chapters) != 3 || response.Chapters[1].Title != "Middle" {
+		t.Errorf("Expected 3 chapters, but got %+v", response.Chapters)
+	}
+
+	// Check the next chapter starts at its beginning
+	musicPlayer.Enqueue("1")
+	musicPlayer.Play()
+	*now = now.Add(10 * time.Second)
+	status, err := musicPlayer.SkipChapter(1)
+	if err != nil || status.Elapsed != 100 || status.Chapter == nil || *status.Chapter != 1 {
+		t.Errorf("Expected chapter 1 at 100s, but got %+v (%v)", status, err)
+	}
+
+	// Check going back well into a chapter restarts it
+	*now = now.Add(20 * time.Second)
+	status, _ = musicPlayer.SkipChapter(-1)
+	if status.Elapsed != 100 {
+		t.Errorf("Expected a restart at 100s, but got %+v", status)
+	}
+
+	// Check going back at the start of a chapter moves to the previous one
+	status, _ = musicPlayer.SkipChapter(-1)
+	if status.Elapsed != 0 {
+		t.Errorf("Expected chapter 0 at 0s, but got %+v", status)
+	}
+
+	// Check jumping by index through the API
+	rr = performRequest(router, "PUT", "/player/chapter", strings.NewReader(`{"index": 2}`), "bob")
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if rr.Code != http.StatusOK || status.Elapsed != 200 {
+		t.Errorf("Expected chapter 2 at 200s, but got %d %+v", rr.Code, status)
+	}
+	rr = performRequest(router, "PUT", "/player/chapter", strings.NewReader(`{"index": 3}`), "bob")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// collectionFormats are the media a copy of an album can be on.
+var collectionFormats = []string{"digital", "vinyl", "cd", "cassette"}
+
+type conditionGrade struct {
+	Grade string
+	Worth float64
+}
+
+// conditionGrades are the Goldmine grades of a physical copy, best first,
+// with the share of a mint copy's value a copy in that condition is worth.
+var conditionGrades = []conditionGrade{
+	{"M", 1}, {"NM", 0.9}, {"VG+", 0.5}, {"VG", 0.25}, {"G+", 0.15}, {"G", 0.1}, {"F", 0.05}, {"P", 0.02},
+}
+
+// ownedCopy is a copy of an album in a user's collection. A user can own
+// several copies of an album, such as an original pressing and a reissue.
+type ownedCopy struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	AlbumID string `json:"albumId"`
+	// Format is one of collectionFormats.
+	Format string `json:"format"`
+	// Condition is one of conditionGrades, for physical copies.
+	Condition     string   `json:"condition,omitempty"`
+	PurchasePrice *float64 `json:"purchasePrice,omitempty"`
+	// PurchasedOn is when the copy was bought, as YYYY-MM-DD.
+	PurchasedOn string `json:"purchasedOn,omitempty"`
+	// Location is where the copy is kept, such as "Shelf 3".
+	Location string    `json:"location,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	AddedAt  time.Time `json:"addedAt"`
+	// DiscogsInstanceID is the copy in the owner's Discogs collection,
+	// once it is synced; see discogs_sync.go.
+	DiscogsInstanceID int `json:"discogsInstanceId,omitempty"`
+}
+
+// copyInput is the body of POST and PUT /collection requests.
+type copyInput struct {
+	AlbumID       string   `json:"albumId"`
+	Format        string   `json:"format"`
+	Condition     string   `json:"condition"`
+	PurchasePrice *float64 `json:"purchasePrice"`
+	PurchasedOn   string   `json:"purchasedOn"`
+	Location      string   `json:"location"`
+	Note          string   `json:"note"`
+}
+
+// collection is guarded by albumsMu.
+var collection []ownedCopy
+
+// findCopy returns the index of the user's copy with the given id, or -1.
+// Callers must hold albumsMu.
+func findCopy(id, owner string) int {
+	for i, o := range collection {
+		if o.ID == id && o.Owner == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// conditionWorth returns the share of a mint copy's value a copy in the
+// given condition is worth. Digital copies and ungraded ones count as
+// mint.
+func conditionWorth(condition string) float64 {
+	for _, g := range conditionGrades {
+		if g.Grade == condition {
+			return g.Worth
+		}
+	}
+	return 1
+}
+
+// bindCopy reads and checks a copy from the request body, answering the
+// request when it is not valid.
+func bindCopy(c *gin.Context) (copyInput, bool) {
+	var input copyInput
+
+	if err := c.BindJSON(&input); err != nil {
+		return input, false
+	}
+	input.Format, input.Condition = strings.ToLower(strings.TrimSpace(input.Format)), strings.ToUpper(strings.TrimSpace(input.Condition))
+	input.Location = strings.TrimSpace(input.Location)
+	if !slices.Contains(collectionFormats, input.Format) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "format must be one of " + strings.Join(collectionFormats, ", ")})
+		return input, false
+	}
+	if input.Condition != "" {
+		if input.Format == "digital" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "digital copies have no condition"})
+			return input, false
+		}
+		if !slices.ContainsFunc(conditionGrades, func(g conditionGrade) bool { return g.Grade == input.Condition }) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "condition must be a Goldmine grade: M, NM, VG+, VG, G+, G, F or P"})
+			return input, false
+		}
+	}
+	if input.PurchasePrice != nil && *input.PurchasePrice < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "purchasePrice must not be negative"})
+		return input, false
+	}
+	if input.PurchasedOn != "" {
+		if _, err := time.Parse(time.DateOnly, input.PurchasedOn); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "purchasedOn must be YYYY-MM-DD"})
+			return input, false
+		}
+	}
+	return input, true
+}
+
+// getCollection lists the copies the user owns, optionally only those in
+// ?format or at ?location.
+func getCollection(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	owned := []ownedCopy{}
+	for _, o := range collection {
+		if o.Owner != user ||
+			c.Query("format") != "" && !strings.EqualFold(o.Format, c.Query("format")) ||
+			c.Query("location") != "" && !strings.EqualFold(o.Location, c.Query("location")) {
+			continue
+		}
+		owned = append(owned, o)
+	}
+	respondList(c, owned)
+}
+
+func postCopy(c *gin.Context) {
+	input, ok := bindCopy(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	j := findAlbum(input.AlbumID)
+	if j < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album not found"})
+		return
+	}
+	o := ownedCopy{
+		ID:            newID(),
+		Owner:         c.GetString(gin.AuthUserKey),
+		AlbumID:       albums[j].ID,
+		Format:        input.Format,
+		Condition:     input.Condition,
+		PurchasePrice: input.PurchasePrice,
+		PurchasedOn:   input.PurchasedOn,
+		Location:      input.Location,
+		Note:          input.Note,
+		AddedAt:       time.Now().UTC(),
+	}
+	collection = append(collection, o)
+	c.IndentedJSON(http.StatusCreated, o)
+}
+
+func putCopy(c *gin.Context) {
+	input, ok := bindCopy(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findCopy(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "copy not found"})
+		return
+	}
+	j := findAlbum(input.AlbumID)
+	if j < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album not found"})
+		return
+	}
+	o := &collection[i]
+	o.AlbumID, o.Format, o.Condition = albums[j].ID, input.Format, input.Condition
+	o.PurchasePrice, o.PurchasedOn, o.Location, o.Note = input.PurchasePrice, input.PurchasedOn, input.Location, input.Note
+	c.IndentedJSON(http.StatusOK, *o)
+}
+
+func deleteCopy(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findCopy(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "copy not found"})
+		return
+	}
+	collection = append(collection[:i], collection[i+1:]...)
+	c.Status(http.StatusNoContent)
+}
+
+// collectionValue sums up copies: what was paid for them and what they
+// are worth now.
+type collectionValue struct {
+	Format string  `json:"format,omitempty"`
+	Copies int     `json:"copies"`
+	Paid   float64 `json:"paid"`
+	Value  float64 `json:"value"`
+	// Unvalued counts the copies of albums that are no longer in the
+	// library, which have no price to value them by.
+	Unvalued int `json:"unvalued,omitempty"`
+}
+
+func (v *collectionValue) add(o ownedCopy) {
+	v.Copies++
+	if o.PurchasePrice != nil {
+		v.Paid += *o.PurchasePrice
+	}
+	i := findAlbum(o.AlbumID)
+	if i < 0 {
+		v.Unvalued++
+		return
+	}
+	v.Value += albums[i].Price * conditionWorth(o.Condition)
+}
+
+// getCollectionValue reports what the user's collection cost and is
+// worth, in total and by format. A copy is valued at the album's current
+// price, scaled down by its condition.
+func getCollectionValue(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	total := collectionValue{}
+	byFormat := map[string]*collectionValue{}
+	for _, o := range collection {
+		if o.Owner != user {
+			continue
+		}
+		if byFormat[o.Format] == nil {
+			byFormat[o.Format] = &collectionValue{Format: o.Format}
+		}
+		byFormat[o.Format].add(o)
+		total.add(o)
+	}
+	albumsMu.RUnlock()
+
+	cents := func(v float64) float64 { return math.Round(v*100) / 100 }
+	formats := []collectionValue{}
+	for _, f := range collectionFormats {
+		if v := byFormat[f]; v != nil {
+			v.Paid, v.Value = cents(v.Paid), cents(v.Value)
+			formats = append(formats, *v)
+		}
+	}
+	total.Paid, total.Value = cents(total.Paid), cents(total.Value)
+	c.IndentedJSON(http.StatusOK, gin.H{"total": total, "formats": formats})
+}
+
+func dumpCollection() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(collection, "", "    ")
+}
+
+func restoreCollection(data []byte) (func(), int, error) {
+	var restored []ownedCopy
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		collection = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCollection(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 40},
+		{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 20},
+	}
+
+	// Check copies are added to the user's collection
+	for _, body := range []string{
+		`{"albumId": "1", "format": "Vinyl", "condition": "nm", "purchasePrice": 25, "purchasedOn": "2023-04-01", "location": "Shelf 3"}`,
+		`{"albumId": "1", "format": "digital", "purchasePrice": 9.99}`,
+		`{"albumId": "2", "format": "vinyl", "condition": "VG", "location": "Shelf 3"}`,
+	} {
+		if rr := performRequest(router, "POST", "/collection", strings.NewReader(body), "bob"); rr.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusCreated, body, rr.Code)
+		}
+	}
+	performRequest(router, "POST", "/collection", strings.NewReader(`{"albumId": "2", "format": "cd"}`), "alice")
+
+	// Check the collection can be filtered by format and location
+	rr := performRequest(router, "GET", "/collection?format=vinyl&location=shelf%203", nil, "bob")
+	var owned []ownedCopy
+	json.Unmarshal(rr.Body.Bytes(), &owned)
+	if len(owned) != 2 || owned[0].Condition != "NM" || owned[1].AlbumID != "2" {
+		t.Errorf("Expected two vinyl copies, but got %+v", owned)
+	}
+
+	// Check bad copies are rejected
+	for _, body := range []string{
+		`{"albumId": "9", "format": "vinyl"}`,
+		`{"albumId": "1", "format": "8-track"}`,
+		`{"albumId": "1", "format": "vinyl", "condition": "great"}`,
+		`{"albumId": "1", "format": "digital", "condition": "M"}`,
+		`{"albumId": "1", "format": "cd", "purchasePrice": -1}`,
+		`{"albumId": "1", "format": "cd", "purchasedOn": "April"}`,
+	} {
+		if rr = performRequest(router, "POST", "/collection", strings.NewReader(body), "bob"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+
+	// Check the value is reported by format and scaled by condition
+	rr = performRequest(router, "GET", "/collection/value", nil, "bob")
+	var value struct {
+		Total   collectionValue   `json:"total"`
+		Formats []collectionValue `json:"formats"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &value)
+	if value.Total != (collectionValue{Copies: 3, Paid: 34.99, Value: 81}) {
+		t.Errorf("Expected 3 copies paid 34.99 worth 81, but got %+v", value.Total)
+	}
+	if len(value.Formats) != 2 || value.Formats[0] != (collectionValue{Format: "digital", Copies: 1, Paid: 9.99, Value: 40}) {
+		t.Errorf("Expected digital and vinyl, but got %+v", value.Formats)
+	}
+
+	// Check copies are only changed by their owner
+	id := owned[1].ID
+	if rr = performRequest(router, "PUT", "/collection/"+id, strings.NewReader(`{"albumId": "2", "format": "vinyl", "condition": "M"}`), "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	performRequest(router, "PUT", "/collection/"+id, strings.NewReader(`{"albumId": "2", "format": "vinyl", "condition": "M"}`), "bob")
+	if collection[2].Condition != "M" || collection[2].Location != "" {
+		t.Errorf("Expected the copy to be replaced, but got %+v", collection[2])
+	}
+	if rr = performRequest(router, "DELETE", "/collection/"+id, nil, "bob"); rr.Code != http.StatusNoContent || len(collection) != 3 {
+		t.Errorf("Expected the copy to be removed, but got %d with %d copies", rr.Code, len(collection))
+	}
+}
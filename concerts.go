@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concertTimeLayout is how Bandsintown gives event times, in the venue's
+// local time.
+const concertTimeLayout = "2006-01-02T15:04:05"
+
+// concert is an upcoming show by a followed artist.
+type concert struct {
+	ID       string `json:"id"`
+	ArtistID string `json:"artistId"`
+	Artist   string `json:"artist"`
+	// Starts is the venue's local time, as YYYY-MM-DDTHH:MM:SS.
+	Starts  string   `json:"starts"`
+	Venue   string   `json:"venue"`
+	City    string   `json:"city"`
+	Region  string   `json:"region,omitempty"`
+	Country string   `json:"country"`
+	Lat     float64  `json:"lat"`
+	Lon     float64  `json:"lon"`
+	Lineup  []string `json:"lineup,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	// DistanceKM is how far the venue is from concertsNear.
+	DistanceKM float64 `json:"distanceKm"`
+}
+
+// concertClient talks to a Bandsintown-compatible events API.
+type concertClient struct {
+	baseURL, appID string
+	httpClient     *http.Client
+}
+
+// geoPoint is a latitude and longitude in degrees.
+type geoPoint struct{ Lat, Lon float64 }
+
+// concerts is nil when no events API is configured. Shows are kept when
+// they are within concertsRadius kilometres of concertsNear.
+var (
+	concerts       *concertClient
+	concertsNear   geoPoint
+	concertsRadius = 100.0
+	// artistEvents holds the shows last found per followed artist id. It
+	// is guarded by albumsMu.
+	artistEvents = map[string][]concert{}
+)
+
+func newConcertClient(baseURL, appID string) *concertClient {
+	return &concertClient{baseURL: strings.TrimSuffix(baseURL, "/"), appID: appID, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// parseGeoPoint reads a "lat,lon" pair.
+func parseGeoPoint(s string) (geoPoint, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return geoPoint{}, fmt.Errorf("%q is not lat,lon", s)
+	}
+	var p geoPoint
+	var err1, err2 error
+	p.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	p.Lon, err2 = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err := errors.Join(err1, err2); err != nil || math.Abs(p.Lat) > 90 || math.Abs(p.Lon) > 180 {
+		return geoPoint{}, fmt.Errorf("%q is not lat,lon", s)
+	}
+	return p, nil
+}
+
+// distanceKM is the great-circle distance between two points.
+func distanceKM(a, b geoPoint) float64 {
+	const earthRadius = 6371.0
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(b.Lat-a.Lat), rad(b.Lon-a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// events returns an artist's upcoming shows.
+func (cc *concertClient) events(ctx context.Context, artist string) ([]concert, error) {
+	query := url.Values{"app_id": {cc.appID}, "date": {"upcoming"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cc.baseURL+"/artists/"+url.PathEscape(artist)+"/events?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := cc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("events API returned %s", resp.Status)
+	}
+
+	var found []struct {
+		ID       string   `json:"id"`
+		Datetime string   `json:"datetime"`
+		URL      string   `json:"url"`
+		Lineup   []string `json:"lineup"`
+		Venue    struct {
+			Name      string `json:"name"`
+			City      string `json:"city"`
+			Region    string `json:"region"`
+			Country   string `json:"country"`
+			Latitude  string `json:"latitude"`
+			Longitude string `json:"longitude"`
+		} `json:"venue"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	var shows []concert
+	for _, e := range found {
+		lat, err1 := strconv.ParseFloat(e.Venue.Latitude, 64)
+		lon, err2 := strconv.ParseFloat(e.Venue.Longitude, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		shows = append(shows, concert{ID: e.ID, Artist: artist, Starts: e.Datetime, Venue: e.Venue.Name, City: e.Venue.City, Region: e.Venue.Region, Country: e.Venue.Country, Lat: lat, Lon: lon, Lineup: e.Lineup, URL: e.URL})
+	}
+	return shows, nil
+}
+
+// refreshConcerts is the concerts task. It fetches the upcoming shows of
+// every followed artist and keeps those within reach.
+func refreshConcerts(ctx context.Context) error {
+	if concerts == nil {
+		return nil
+	}
+
+	albumsMu.RLock()
+	names := map[string]string{}
+	for _, list := range follows {
+		for _, f := range list {
+			names[f.ArtistID] = f.Name
+		}
+	}
+	albumsMu.RUnlock()
+
+	// Artists whose lookup fails keep the shows found before.
+	found := map[string][]concert{}
+	var errs []error
+	for id, name := range names {
+		shows, err := concerts.events(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		found[id] = nil
+		for _, show := range shows {
+			show.ArtistID = id
+			show.DistanceKM = math.Round(distanceKM(concertsNear, geoPoint{show.Lat, show.Lon})*10) / 10
+			if show.DistanceKM <= concertsRadius {
+				found[id] = append(found[id], show)
+			}
+		}
+	}
+
+	albumsMu.Lock()
+	for id := range artistEvents {
+		if _, ok := names[id]; !ok {
+			delete(artistEvents, id)
+		}
+	}
+	for id, shows := range found {
+		artistEvents[id] = shows
+	}
+	albumsMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// getMyEvents lists upcoming shows near the configured location by the
+// artists the user follows, soonest first.
+func getMyEvents(c *gin.Context) {
+	if concerts == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "no events API is configured"})
+		return
+	}
+	// Event times are local to the venue, so a day's grace keeps tonight's
+	// shows listed wherever they are.
+	cutoff := time.Now().UTC().Add(-24 * time.Hour).Format(concertTimeLayout)
+
+	albumsMu.RLock()
+	shows := []concert{}
+	for _, f := range follows[c.GetString(gin.AuthUserKey)] {
+		for _, show := range artistEvents[f.ArtistID] {
+			if show.Starts >= cutoff {
+				shows = append(shows, show)
+			}
+		}
+	}
+	albumsMu.RUnlock()
+
+	sort.SliceStable(shows, func(i, j int) bool { return shows[i].Starts < shows[j].Starts })
+	respondList(c, shows)
+}
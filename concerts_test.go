@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMyEvents(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	soon := time.Now().AddDate(0, 1, 0).Format(concertTimeLayout)
+	later := time.Now().AddDate(0, 2, 0).Format(concertTimeLayout)
+	past := time.Now().AddDate(0, -1, 0).Format(concertTimeLayout)
+
+	// Check the events API is off until configured
+	if rr := performRequest(router, "GET", "/me/events", nil, "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Serve a fake events API with shows in London, Brighton, Tokyo and one already past
+	var appID string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID = r.URL.Query().Get("app_id")
+		if r.URL.Path != "/artists/John Coltrane/events" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[
+			{"id": "3", "datetime": "` + later + `", "venue": {"name": "The Dome", "city": "Brighton", "country": "United Kingdom", "latitude": "50.8235", "longitude": "-0.1392"}},
+			{"id": "1", "datetime": "` + soon + `", "lineup": ["John Coltrane"], "venue": {"name": "Ronnie Scott's", "city": "London", "country": "United Kingdom", "latitude": "51.5134", "longitude": "-0.1318"}},
+			{"id": "2", "datetime": "` + soon + `", "venue": {"name": "Blue Note", "city": "Tokyo", "country": "Japan", "latitude": "35.6617", "longitude": "139.7148"}},
+			{"id": "4", "datetime": "` + past + `", "venue": {"name": "Jazz Cafe", "city": "London", "country": "United Kingdom", "latitude": "51.5394", "longitude": "-0.1426"}}
+		]`))
+	}))
+	defer api.Close()
+	concerts, concertsNear, concertsRadius = newConcertClient(api.URL, "player"), geoPoint{51.5074, -0.1278}, 100
+	t.Cleanup(func() { concerts = nil })
+	performRequest(router, "PUT", "/artists/john-coltrane/follow", nil, "bob")
+	if err := refreshConcerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if appID != "player" {
+		t.Errorf("Expected the app id to be sent, but got %q", appID)
+	}
+
+	// Check followers see upcoming shows within the radius, soonest first
+	rr := performRequest(router, "GET", "/me/events", nil, "bob")
+	var shows []concert
+	json.Unmarshal(rr.Body.Bytes(), &shows)
+	if len(shows) != 2 || shows[0].City != "London" || shows[1].City != "Brighton" {
+		t.Fatalf("Expected shows in London and Brighton, but got %+v", shows)
+	}
+	if shows[0].ArtistID != "john-coltrane" || shows[0].DistanceKM != 0.7 {
+		t.Errorf("Expected a show 0.7 km away by john-coltrane, but got %+v", shows[0])
+	}
+
+	// Check other users see nothing
+	rr = performRequest(router, "GET", "/me/events", nil, "alice")
+	if json.Unmarshal(rr.Body.Bytes(), &shows); len(shows) != 0 {
+		t.Errorf("Expected no shows for alice, but got %+v", shows)
+	}
+
+	// Check shows are dropped once nobody follows the artist
+	performRequest(router, "DELETE", "/artists/john-coltrane/follow", nil, "bob")
+	refreshConcerts(context.Background())
+	if len(artistEvents) != 0 {
+		t.Errorf("Expected no shows kept, but got %+v", artistEvents)
+	}
+}
+
+func TestParseGeoPoint(t *testing.T) {
+	// Check a valid location is read and invalid ones are refused
+	if p, err := parseGeoPoint("51.5, -0.12"); err != nil || p != (geoPoint{51.5, -0.12}) {
+		t.Errorf("Expected 51.5,-0.12, but got %+v, %v", p, err)
+	}
+	for _, s := range []string{"", "51.5", "north,west", "91,0"} {
+		if _, err := parseGeoPoint(s); err == nil {
+			t.Errorf("Expected an error for %q, but got none", s)
+		}
+	}
+}
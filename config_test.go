@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Rate limit windows that would divide by zero or run backwards are refused
+func TestLoadConfigRateLimitWindow(t *testing.T) {
+	for _, window := range []string{"0s", "-1m"} {
+		t.Setenv("MUSIC_PLAYER_RATE_LIMIT_WINDOW", window)
+		if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "MUSIC_PLAYER_RATE_LIMIT_WINDOW") {
+			t.Errorf("Expected %s to be refused, but got %v", window, err)
+		}
+	}
+	t.Setenv("MUSIC_PLAYER_RATE_LIMIT_WINDOW", "30s")
+	if cfg, err := loadConfig(); err != nil || cfg.RateLimitWindow.Seconds() != 30 {
+		t.Errorf("Expected a 30s window, but got %v and %v", cfg.RateLimitWindow, err)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiSpec is the part of openapi.json the contract tests read: the
+// responses of each operation and the schemas they refer to.
+type apiSpec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]*apiSchema  `json:"schemas"`
+		Responses map[string]apiResponse `json:"responses"`
+	} `json:"components"`
+}
+
+type apiOperation struct {
+	Responses map[string]apiResponse `json:"responses"`
+}
+
+type apiResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *apiSchema `json:"schema"`
+	} `json:"content"`
+}
+
+// apiSchema is the subset of JSON Schema the spec uses. Objects may not
+// have properties the schema does not list unless additionalProperties is
+// set, so a field added to a response without documenting it fails.
+type apiSchema struct {
+	Ref                  string                `json:"$ref"`
+	Type                 string                `json:"type"`
+	Format               string                `json:"format"`
+	Nullable             bool                  `json:"nullable"`
+	Enum                 []string              `json:"enum"`
+	Required             []string              `json:"required"`
+	Properties           map[string]*apiSchema `json:"properties"`
+	Items                *apiSchema            `json:"items"`
+	AdditionalProperties any                   `json:"additionalProperties"`
+}
+
+var specMethods = []string{"get", "put", "post", "patch", "delete", "head"}
+
+var (
+	loadSpecOnce sync.Once
+	spec         *apiSpec
+	specErr      error
+)
+
+// loadSpec reads openapi.json once for all tests.
+func loadSpec(t *testing.T) *apiSpec {
+	t.Helper()
+	loadSpecOnce.Do(func() {
+		data, err := os.ReadFile("openapi.json")
+		if err != nil {
+			specErr = err
+			return
+		}
+		spec = &apiSpec{}
+		specErr = json.Unmarshal(data, spec)
+	})
+	if specErr != nil {
+		t.Fatalf("Expected a readable openapi.json, but got %v", specErr)
+	}
+	return spec
+}
+
+// operation returns the documented operation a request is served by, and
+// its path template, such as /albums/{id}. Templates with fewer
+// parameters win, as literal routes do in the router.
+func (s *apiSpec) operation(method, path string) (string, *apiOperation, error) {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	best, bestParams := "", -1
+	for template := range s.Paths {
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		params := 0
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") {
+				params++
+			} else if part != segments[i] {
+				params = -1
+				break
+			}
+		}
+		if params >= 0 && (bestParams < 0 || params < bestParams || params == bestParams && template < best) {
+			best, bestParams = template, params
+		}
+	}
+	if best == "" {
+		return "", nil, nil
+	}
+	raw, ok := s.Paths[best][strings.ToLower(method)]
+	if !ok {
+		return best, nil, nil
+	}
+	var op apiOperation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return best, nil, err
+	}
+	return best, &op, nil
+}
+
+// check reports how a response differs from what the spec documents for
+// its request. Requests the spec does not document are not checked.
+func (s *apiSpec) check(method, path string, code int, header http.Header, body []byte) error {
+	_, op, err := s.operation(method, path)
+	if op == nil {
+		return err
+	}
+	r, ok := op.Responses[strconv.Itoa(code)]
+	if !ok {
+		if r, ok = op.Responses["default"]; !ok {
+			return fmt.Errorf("%s %s: status %d is not documented", method, path, code)
+		}
+	}
+	if r.Ref != "" {
+		r = s.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+	}
+	content, ok := r.Content["application/json"]
+	if !ok || content.Schema == nil || method == http.MethodHead {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType != "application/json" {
+		return fmt.Errorf("%s %s: expected application/json, but got %q", method, path, header.Get("Content-Type"))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Errorf("%s %s: %v", method, path, err)
+	}
+	if err := s.validate(v, content.Schema, "$"); err != nil {
+		return fmt.Errorf("%s %s: %d response: %v", method, path, code, err)
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against schema; at says where the
+// value is, for the error.
+func (s *apiSpec) validate(v any, schema *apiSchema, at string) error {
+	for schema.Ref != "" {
+		ref := schema.Ref
+		if schema = s.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; schema == nil {
+			return fmt.Errorf("%s: unknown schema %s", at, ref)
+		}
+	}
+	if v == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: expected %s, but got null", at, schema.Type)
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, but got %T", at, v)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties == nil || schema.AdditionalProperties == false {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+				continue
+			}
+			if err := s.validate(object[name], property, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, but got %T", at, v)
+		}
+		for i, item := range array {
+			if schema.Items == nil {
+				break
+			}
+			if err := s.validate(item, schema.Items, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, but got %T", at, v)
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %q", at, str, schema.Enum)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return fmt.Errorf("%s: expected an integer, but got %v", at, v)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%s: expected a number, but got %T", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, but got %T", at, v)
+		}
+	}
+	return nil
+}
+
+// Every documented operation is served, and answers as documented
+func TestContract(t *testing.T) {
+	resetCatalog(t)
+	resetActivity(t)
+	s := loadSpec(t)
+	cfg := testConfig()
+	router := setupRouter(cfg)
+	albums = []album{
+		{ID: "kob", Title: "Kind of Blue", Artist: "Miles Davis", Price: 19.99, Year: 1959, Genre: "Jazz", Tags: []string{"modal"}, Slug: "kind-of-blue"},
+		{ID: "ssa", Title: "Sleep", Artist: "Max Richter", Price: 9.99, Type: "compilation", Fields: map[string]any{"mood": "calm"}},
+	}
+	added := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracks = []track{
+		{ID: "1", AlbumID: "kob", Title: "So What", Artist: "Miles Davis", Number: 1, Duration: 562, Path: "/music/so-what.flac", Format: "flac", Size: 1 << 20, AddedAt: &added, Moods: []string{"calm"}},
+		{ID: "2", AlbumID: "kob", Title: "Freddie Freeloader", Artist: "Miles Davis", Disc: 2, DiscSubtitle: "Bonus", Number: 1},
+	}
+
+	// Check each documented operation is a route
+	registered := map[string]bool{}
+	for _, r := range router.Routes() {
+		registered[r.Method+" "+r.Path] = true
+	}
+	documented := map[string]bool{}
+	for template, item := range s.Paths {
+		route := template
+		for strings.Contains(route, "{") {
+			start, end := strings.Index(route, "{"), strings.Index(route, "}")
+			route = route[:start] + ":" + route[start+1:end] + route[end+1:]
+		}
+		for _, method := range specMethods {
+			if _, ok := item[method]; !ok {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+template] = true
+			if !registered[strings.ToUpper(method)+" "+route] {
+				t.Errorf("Expected a route for %s %s, but there is none", strings.ToUpper(method), template)
+			}
+		}
+	}
+
+	// Check successful and failed requests against the spec
+	requests := []struct {
+		method, path, body, user string
+		code                     int
+	}{
+		{"GET", "/albums", "", "", http.StatusOK},
+		{"GET", "/albums?tag=%20", "", "", http.StatusBadRequest},
+		{"POST", "/albums", `{"title": "Milestones", "artist": "Miles Davis", "price": 12.5}`, "", http.StatusCreated},
+		{"POST", "/albums", `{"title": "Milestones", "type": "boxset"}`, "", http.StatusBadRequest},
+		{"GET", "/albums/kob", "", "", http.StatusOK},
+		{"GET", "/albums/ssa", "", "", http.StatusOK},
+		{"GET", "/albums/missing", "", "", http.StatusNotFound},
+		{"PATCH", "/albums/kob", `{"price": 17.5}`, "alice", http.StatusOK},
+		{"PATCH", "/albums/kob", `{"price": -1}`, "alice", http.StatusBadRequest},
+		{"PATCH", "/albums/missing", `{"price": 1}`, "alice", http.StatusNotFound},
+		{"PATCH", "/albums/kob", `{"price": 1}`, "", http.StatusUnauthorized},
+		{"GET", "/albums/kob/tracks", "", "", http.StatusOK},
+		{"GET", "/albums/missing/tracks", "", "", http.StatusNotFound},
+		{"GET", "/tracks", "", "", http.StatusOK},
+		{"GET", "/tracks?bpmMin=fast", "", "", http.StatusBadRequest},
+		{"GET", "/tracks/1", "", "", http.StatusOK},
+		{"GET", "/tracks/missing", "", "", http.StatusNotFound},
+		{"POST", "/library/import", `[{"title": "Milestones", "artist": "Miles Davis", "album": "Milestones", "track": 1}]`, "alice", http.StatusOK},
+		{"POST", "/library/import", `[]`, "bob", http.StatusForbidden},
+		{"POST", "/library/import", `[]`, "", http.StatusUnauthorized},
+		{"POST", "/listens", `{"trackId": "1"}`, "bob", http.StatusCreated},
+		{"POST", "/listens", `{"trackId": "missing"}`, "bob", http.StatusNotFound},
+		{"POST", "/listens", `{"trackId": "1"}`, "", http.StatusUnauthorized},
+		{"GET", "/activity", "", "alice", http.StatusOK},
+		{"GET", "/activity?order=random", "", "alice", http.StatusBadRequest},
+		{"GET", "/activity", "", "", http.StatusUnauthorized},
+	}
+	exercised := map[string]bool{}
+	for _, r := range requests {
+		if r.path == "/activity" {
+			flushHooks()
+		}
+		rr := performRequest(router, r.method, r.path, strings.NewReader(r.body), r.user)
+		if rr.Code != r.code {
+			t.Errorf("Expected status code %d for %s %s, but got %d", r.code, r.method, r.path, rr.Code)
+		}
+		if err := s.check(r.method, r.path, rr.Code, rr.Header(), rr.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+		template, _, _ := s.operation(r.method, r.path)
+		exercised[r.method+" "+template] = true
+	}
+
+	// Check every documented operation was exercised, so none goes unchecked
+	for op := range documented {
+		if !exercised[op] {
+			t.Errorf("Expected a request for %s, but there is none", op)
+		}
+	}
+}
+
+// The validator catches the kinds of drift it is there for
+func TestContractDrift(t *testing.T) {
+	s := loadSpec(t)
+	header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	for _, tc := range []struct {
+		code int
+		body string
+		want string
+	}{
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis", "price": 1}`, ""},
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis"}`, `missing required property "price"`},
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis", "price": 1, "sku": "X"}`, `undocumented property "sku"`},
+		{http.StatusOK, `{"id": 1, "title": "So What", "artist": "Miles Davis", "price": 1}`, "$.id: expected a string"},
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis", "price": 1, "year": 1959.5}`, "$.year: expected an integer"},
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis", "price": 1, "type": "boxset"}`, `"boxset" is not one of`},
+		{http.StatusOK, `{"id": "1", "title": "So What", "artist": "Miles Davis", "price": 1, "tags": null}`, "$.tags: expected array, but got null"},
+		{http.StatusNotFound, `{"error": "album not found"}`, `missing required property "message"`},
+		{http.StatusTeapot, `{}`, "status 418 is not documented"},
+	} {
+		err := s.check("GET", "/albums/1", tc.code, header, []byte(tc.body))
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("Expected %q for %s, but got %v", tc.want, tc.body, err)
+		}
+	}
+
+	// Check only JSON is accepted where JSON is documented
+	err := s.check("GET", "/albums/1", http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "expected application/json") {
+		t.Errorf("Expected a content type error, but got %v", err)
+	}
+}
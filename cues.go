@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os/exec"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultCrossfade is how long a previewed transition lasts, in
+	// seconds, when neither the request nor the incoming track's intro
+	// says.
+	defaultCrossfade = 8.0
+	// maxCrossfade bounds previewed transitions.
+	maxCrossfade = 30.0
+	// mixPreviewLead is how much of each track a preview plays around the
+	// transition, so it is heard in context.
+	mixPreviewLead = 10.0
+)
+
+// cuePoints mark where a DJ mixes a track in and out, in seconds.
+type cuePoints struct {
+	// IntroEnd is where the intro ends; a transition into the track lasts
+	// until then.
+	IntroEnd float64 `json:"introEnd,omitempty"`
+	// OutroStart is where the outro starts; a transition out of the track
+	// begins there.
+	OutroStart float64 `json:"outroStart,omitempty"`
+}
+
+func getTrackCues(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	cues := cuePoints{}
+	if tracks[i].Cues != nil {
+		cues = *tracks[i].Cues
+	}
+	c.IndentedJSON(http.StatusOK, cues)
+}
+
+// putTrackCues sets the cue points of a track. Zero clears a cue point.
+func putTrackCues(c *gin.Context) {
+	var cues cuePoints
+
+	if err := c.BindJSON(&cues); err != nil {
+		return
+	}
+	if cues.IntroEnd < 0 || cues.OutroStart < 0 || cues.IntroEnd != 0 && cues.OutroStart != 0 && cues.OutroStart <= cues.IntroEnd {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "cue points must satisfy 0 <= introEnd < outroStart"})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	if d := tracks[i].Duration; d > 0 && (cues.IntroEnd >= d || cues.OutroStart >= d) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "cue points must fall within the track"})
+		return
+	}
+	tracks[i].Cues = &cues
+	if cues == (cuePoints{}) {
+		tracks[i].Cues = nil
+	}
+	c.IndentedJSON(http.StatusOK, tracks[i])
+}
+
+// mixPoints works out a transition from one track to another: where the
+// outgoing track starts fading, where the incoming one starts, and how
+// long the fade lasts. The fade starts at the outgoing track's outro, or
+// crossfade before its end, and by default lasts through the incoming
+// track's intro.
+func mixPoints(from, to track, crossfade float64) (fadeStart, toStart, fade float64, ok bool) {
+	if to.Analysis != nil {
+		toStart = to.Analysis.SkipIn
+	}
+	fade = crossfade
+	if fade == 0 {
+		fade = defaultCrossfade
+		if to.Cues != nil && to.Cues.IntroEnd > toStart {
+			fade = min(to.Cues.IntroEnd-toStart, maxCrossfade)
+		}
+	}
+
+	end := from.Duration
+	if from.Analysis != nil && from.Analysis.SkipOut > 0 {
+		end = from.Analysis.SkipOut
+	}
+	switch {
+	case from.Cues != nil && from.Cues.OutroStart > 0:
+		fadeStart = from.Cues.OutroStart
+		if end > 0 {
+			fade = min(fade, end-fadeStart)
+		}
+	case end > 0:
+		fadeStart = max(end-fade, 0)
+		fade = end - fadeStart
+	default:
+		return 0, 0, 0, false
+	}
+	return fadeStart, toStart, fade, fade > 0
+}
+
+// getMixPreview renders a short crossfaded transition from track ?from to
+// track ?to as mp3, for auditioning the order of a playlist. ?crossfade
+// sets the length of the fade in seconds. Previews are rendered on the
+// fly by ffmpeg.
+func getMixPreview(c *gin.Context) {
+	crossfade := 0.0
+	if v := c.Query("crossfade"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 1 || n > maxCrossfade {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "crossfade must be between 1 and " + strconv.Itoa(maxCrossfade) + " seconds"})
+			return
+		}
+		crossfade = n
+	}
+
+	albumsMu.RLock()
+	var pair [2]track
+	for k, param := range []string{"from", "to"} {
+		i := findTrack(c.Query(param))
+		if i < 0 {
+			albumsMu.RUnlock()
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": param + " track not found"})
+			return
+		}
+		pair[k] = tracks[i]
+	}
+	albumsMu.RUnlock()
+
+	from, to := pair[0], pair[1]
+	if from.Path == "" || to.Path == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track has no file"})
+		return
+	}
+	fadeStart, toStart, fade, ok := mixPoints(from, to, crossfade)
+	if !ok {
+		c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": "the from track needs a duration or an outro cue point"})
+		return
+	}
+	if transcoder == "" {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "transcoding is not configured"})
+		return
+	}
+
+	seconds := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	fromStart := max(fadeStart-mixPreviewLead, 0)
+	profile := transcodeProfiles["mp3"]
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(c.Request.Context(), transcoder, "-hide_banner", "-nostats", "-loglevel", "error",
+		"-ss", seconds(fromStart), "-t", seconds(fadeStart+fade-fromStart), "-i", from.Path,
+		"-ss", seconds(toStart), "-t", seconds(fade+mixPreviewLead), "-i", to.Path,
+		"-filter_complex", "[0:a:0][1:a:0]acrossfade=d="+seconds(fade),
+		"-c:a", profile.Encoder, "-b:a", strconv.Itoa(profile.Bitrate)+"k", "-f", profile.Format, "pipe:1")
+	cmd.Stdout, cmd.Stderr = c.Writer, &stderr
+	c.Header("Content-Type", profile.MimeType)
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Crossfade-Start", seconds(fadeStart-fromStart))
+	c.Header("X-Crossfade-Duration", seconds(fade))
+	c.Status(http.StatusOK)
+	if err := cmd.Run(); err != nil && !c.Writer.Written() {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "ffmpeg: " + err.Error() + ": " + string(bytes.TrimSpace(stderr.Bytes()))})
+	}
+}
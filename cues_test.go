@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrackCues(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Title: "Blue Train", Duration: 643}}
+
+	// Check cue points are stored and read back
+	rr := performRequest(router, "PUT", "/tracks/1/cues", strings.NewReader(`{"introEnd": 16.5, "outroStart": 610}`), "alice")
+	if rr.Code != http.StatusOK || tracks[0].Cues == nil || tracks[0].Cues.OutroStart != 610 {
+		t.Errorf("Expected the cue points to be stored, but got %d %+v", rr.Code, tracks[0].Cues)
+	}
+	rr = performRequest(router, "GET", "/tracks/1/cues", nil, "")
+	var cues cuePoints
+	json.Unmarshal(rr.Body.Bytes(), &cues)
+	if cues != (cuePoints{IntroEnd: 16.5, OutroStart: 610}) {
+		t.Errorf("Expected the cue points, but got %+v", cues)
+	}
+
+	// Check out-of-order, negative and out-of-range cue points are rejected
+	for _, body := range []string{`{"introEnd": 30, "outroStart": 20}`, `{"introEnd": -1}`, `{"outroStart": 700}`} {
+		if rr = performRequest(router, "PUT", "/tracks/1/cues", strings.NewReader(body), "alice"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+	if rr = performRequest(router, "PUT", "/tracks/1/cues", strings.NewReader(`{}`), "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// Check zeros clear them
+	performRequest(router, "PUT", "/tracks/1/cues", strings.NewReader(`{}`), "alice")
+	if tracks[0].Cues != nil {
+		t.Errorf("Expected no cue points, but got %+v", tracks[0].Cues)
+	}
+}
+
+func TestMixPoints(t *testing.T) {
+	plain := track{Duration: 300}
+	cued := track{Duration: 300, Cues: &cuePoints{IntroEnd: 20, OutroStart: 280}, Analysis: &trackAnalysis{SkipIn: 4}}
+	for _, tc := range []struct {
+		name                   string
+		from, to               track
+		crossfade              float64
+		fadeStart, start, fade float64
+	}{
+		{"plain tracks fade over their last seconds", plain, plain, 0, 292, 0, 8},
+		{"cues fade from the outro through the intro", cued, cued, 0, 280, 4, 16},
+		{"a requested crossfade wins over the intro", cued, cued, 5, 280, 4, 5},
+		{"fades never run past the end", cued, plain, 30, 280, 0, 20},
+	} {
+		fadeStart, start, fade, ok := mixPoints(tc.from, tc.to, tc.crossfade)
+		if !ok || fadeStart != tc.fadeStart || start != tc.start || fade != tc.fade {
+			t.Errorf("Expected %s (%v, %v, %v), but got %v, %v, %v, %v", tc.name, tc.fadeStart, tc.start, tc.fade, fadeStart, start, fade, ok)
+		}
+	}
+	if _, _, _, ok := mixPoints(track{}, plain, 0); ok {
+		t.Errorf("Expected no transition without a duration or outro")
+	}
+}
+
+func TestMixPreview(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	savedTranscoder := transcoder
+	t.Cleanup(func() { transcoder = savedTranscoder })
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	os.WriteFile(script, []byte("#!/bin/sh\nprintf '%s ' \"$@\"\n"), 0o755)
+	transcoder = script
+	tracks = []track{
+		{ID: "1", Title: "Blue Train", Duration: 643, Path: "/music/1.flac", Cues: &cuePoints{OutroStart: 600}},
+		{ID: "2", Title: "Moment's Notice", Duration: 551, Path: "/music/2.flac", Cues: &cuePoints{IntroEnd: 12}},
+		{ID: "3", Title: "No file"},
+	}
+
+	// Check the transition is rendered from the outro cue through the intro
+	rr := performRequest(router, "GET", "/player/mix-preview?from=1&to=2", nil, "bob")
+	want := "-ss 590 -t 22 -i /music/1.flac -ss 0 -t 22 -i /music/2.flac -filter_complex [0:a:0][1:a:0]acrossfade=d=12 "
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "audio/mpeg" || !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("Expected ffmpeg to get %q, but got %d %q", want, rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Crossfade-Start") != "10" || rr.Header().Get("X-Crossfade-Duration") != "12" {
+		t.Errorf("Expected the crossfade at 10s for 12s, but got %v", rr.Header())
+	}
+
+	// Check bad requests
+	for path, code := range map[string]int{
+		"/player/mix-preview?from=1&to=9":             http.StatusNotFound,
+		"/player/mix-preview?from=3&to=1":             http.StatusNotFound,
+		"/player/mix-preview?from=1&to=2&crossfade=0": http.StatusBadRequest,
+	} {
+		if rr = performRequest(router, "GET", path, nil, "bob"); rr.Code != code {
+			t.Errorf("Expected status code %d for %s, but got %d", code, path, rr.Code)
+		}
+	}
+}
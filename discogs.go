@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discogs is nil when neither a Discogs token nor app is configured.
+var discogs *discogsClient
+
+var (
+	// discogsBackoff is how long requests wait when Discogs says the rate
+	// limit of 60 requests a minute is used up, unless it says otherwise.
+	discogsBackoff = time.Minute
+	// discogsRetries is how often a rate limited request is retried.
+	discogsRetries = 3
+)
+
+type discogsClient struct {
+	baseURL string
+	// token is a personal access token; consumerKey and consumerSecret
+	// are those of a Discogs app, which users authorize with OAuth.
+	token          string
+	consumerKey    string
+	consumerSecret string
+	httpClient     *http.Client
+
+	mu sync.Mutex
+	// resumeAt is when requests may go on after the rate limit ran out.
+	resumeAt time.Time
+}
+
+func newDiscogsClient(baseURL, token string) *discogsClient {
+	return &discogsClient{baseURL: baseURL, token: token, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type discogsArtist struct {
+	Name string `json:"name"`
+	Join string `json:"join"`
+}
+
+type discogsRelease struct {
+	ID      int             `json:"id"`
+	Title   string          `json:"title"`
+	Year    int             `json:"year"`
+	Artists []discogsArtist `json:"artists"`
+	Genres  []string        `json:"genres"`
+	Labels  []struct {
+		Name  string `json:"name"`
+		CatNo string `json:"catno"`
+	} `json:"labels"`
+	Images []struct {
+		Type string `json:"type"`
+		URI  string `json:"uri"`
+	} `json:"images"`
+	Tracklist []struct {
+		Position string `json:"position"`
+		Type     string `json:"type_"`
+		Title    string `json:"title"`
+		Duration string `json:"duration"`
+	} `json:"tracklist"`
+}
+
+// discogsNumbered matches the " (2)" Discogs appends to the names of
+// artists and labels that share a name with another.
+var discogsNumbered = regexp.MustCompile(` \(\d+\)$`)
+
+// discogsArtists joins release artists the way Discogs credits them, as
+// in "Miles Davis & John Coltrane".
+func discogsArtists(artists []discogsArtist) string {
+	var b strings.Builder
+	for _, a := range artists {
+		b.WriteString(discogsNumbered.ReplaceAllString(a.Name, ""))
+		switch a.Join {
+		case "":
+		case ",":
+			b.WriteString(", ")
+		default:
+			b.WriteString(" " + a.Join + " ")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// discogsDisc matches the positions of multi-disc releases, such as "2-3"
+// or "CD2-3".
+var discogsDisc = regexp.MustCompile(`^[A-Za-z]*(\d+)[-.](\d+)$`)
+
+// draft turns a Discogs release into an album draft. Headings and index
+// entries in the tracklist are left out, and sides of a record are
+// numbered as one disc.
+func (r discogsRelease) draft() albumDraft {
+	d := albumDraft{
+		Source:   "discogs",
+		SourceID: strconv.Itoa(r.ID),
+		Title:    r.Title,
+		Artist:   discogsArtists(r.Artists),
+		Year:     r.Year,
+		Tracks:   []draftTrack{},
+	}
+	if len(r.Genres) > 0 {
+		d.Genre = r.Genres[0]
+	}
+	if len(r.Labels) > 0 {
+		d.Label, d.CatalogNumber = discogsNumbered.ReplaceAllString(r.Labels[0].Name, ""), r.Labels[0].CatNo
+	}
+	for _, img := range r.Images {
+		if d.CoverURL == "" || img.Type == "primary" {
+			d.CoverURL = img.URI
+		}
+	}
+	numbers := map[int]int{}
+	for _, t := range r.Tracklist {
+		if t.Type != "" && t.Type != "track" {
+			continue
+		}
+		disc := 1
+		if m := discogsDisc.FindStringSubmatch(t.Position); m != nil {
+			disc, _ = strconv.Atoi(m[1])
+		}
+		numbers[disc]++
+		d.Tracks = append(d.Tracks, draftTrack{Disc: disc, Number: numbers[disc], Title: t.Title, Duration: clockSeconds(t.Duration)})
+	}
+	return d
+}
+
+// clockSeconds parses a duration written as "m:ss" or "h:mm:ss", or
+// returns 0.
+func clockSeconds(s string) float64 {
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + float64(n)
+	}
+	return seconds
+}
+
+// releaseByBarcode looks up the release with a barcode, returning false
+// when Discogs has none.
+func (d *discogsClient) releaseByBarcode(ctx context.Context, barcode string) (albumDraft, bool, error) {
+	var search struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	query := url.Values{"barcode": {barcode}, "type": {"release"}, "per_page": {"1"}}
+	if err := d.get(ctx, "/database/search", query, &search); err != nil {
+		return albumDraft{}, false, err
+	}
+	if len(search.Results) == 0 {
+		return albumDraft{}, false, nil
+	}
+
+	var release discogsRelease
+	if err := d.get(ctx, "/releases/"+strconv.Itoa(search.Results[0].ID), nil, &release); err != nil {
+		return albumDraft{}, false, err
+	}
+	draft := release.draft()
+	draft.Barcode = barcode
+	return draft, true, nil
+}
+
+func (d *discogsClient) get(ctx context.Context, path string, query url.Values, v any) error {
+	return d.call(ctx, http.MethodGet, path, query, nil, v)
+}
+
+// call sends a request to the Discogs API and decodes the response into
+// v, if not nil. Requests for a user are signed with their OAuth token;
+// others with the personal token or the app's key.
+func (d *discogsClient) call(ctx context.Context, method, path string, query url.Values, user *discogsAccount, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case user != nil:
+		req.Header.Set("Authorization", d.oauthHeader(user.Token, user.TokenSecret, nil))
+	case d.token != "":
+		req.Header.Set("Authorization", "Discogs token="+d.token)
+	default:
+		req.Header.Set("Authorization", "Discogs key="+d.consumerKey+", secret="+d.consumerSecret)
+	}
+
+	resp, err := d.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("discogs: %s returned %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// send sends a request, keeping within the rate limit: it waits after
+// Discogs reports no requests remaining, and retries requests turned away
+// with 429 Too Many Requests.
+func (d *discogsClient) send(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+	for attempt := 0; ; attempt++ {
+		d.mu.Lock()
+		wait := time.Until(d.resumeAt)
+		d.mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(wait):
+			}
+		}
+
+		resp, err := d.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		limited := resp.StatusCode == http.StatusTooManyRequests
+		if limited || resp.Header.Get("X-Discogs-Ratelimit-Remaining") == "0" {
+			pause := discogsBackoff
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				pause = time.Duration(seconds) * time.Second
+			}
+			d.mu.Lock()
+			d.resumeAt = time.Now().Add(pause)
+			d.mu.Unlock()
+		}
+		if !limited || attempt == discogsRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+// oauthEscape percent-encodes s as OAuth 1.0a asks.
+func oauthEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// oauthHeader returns an OAuth 1.0a Authorization header for the app and
+// token, with the PLAINTEXT signature Discogs accepts over HTTPS.
+func (d *discogsClient) oauthHeader(token, tokenSecret string, extra map[string]string) string {
+	params := map[string]string{
+		"oauth_consumer_key":     d.consumerKey,
+		"oauth_nonce":            newID(),
+		"oauth_signature":        oauthEscape(d.consumerSecret) + "&" + oauthEscape(tokenSecret),
+		"oauth_signature_method": "PLAINTEXT",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if token != "" {
+		params["oauth_token"] = token
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + `="` + oauthEscape(params[k]) + `"`
+	}
+	return "OAuth " + strings.Join(parts, ", ")
+}
+
+// oauthToken requests a request or access token, which Discogs returns
+// form encoded.
+func (d *discogsClient) oauthToken(ctx context.Context, method, path, token, tokenSecret string, extra map[string]string) (url.Values, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", d.oauthHeader(token, tokenSecret, extra))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := d.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discogs: %s returned %s", path, resp.Status)
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if values.Get("oauth_token") == "" || values.Get("oauth_token_secret") == "" {
+		return nil, fmt.Errorf("discogs: %s returned no token", path)
+	}
+	return values, nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// discogsAuthorizeURL is where users authorize the app to use their
+// Discogs account.
+var discogsAuthorizeURL = "https://www.discogs.com/oauth/authorize"
+
+// discogsPendingTTL is how long a user has to authorize the app after
+// asking to connect.
+const discogsPendingTTL = 15 * time.Minute
+
+// discogsAccount is a user's connected Discogs account.
+type discogsAccount struct {
+	Username    string `json:"username"`
+	Token       string `json:"token"`
+	TokenSecret string `json:"tokenSecret"`
+	// Push adds physical copies added here to the Discogs collection.
+	Push      bool       `json:"push"`
+	LastSync  *time.Time `json:"lastSync,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// discogsPending is a request token waiting for its user to authorize
+// the app.
+type discogsPending struct {
+	User      string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// discogsAccounts, by user, and discogsRequests, by request token, are
+// guarded by albumsMu. Pending requests are not backed up.
+var (
+	discogsAccounts = map[string]discogsAccount{}
+	discogsRequests = map[string]discogsPending{}
+)
+
+var errDiscogsNotConnected = errors.New("no Discogs account is connected")
+
+// discogsFormats maps Discogs format names onto collectionFormats.
+var discogsFormats = map[string]string{
+	"Vinyl":    "vinyl",
+	"CD":       "cd",
+	"CDr":      "cd",
+	"Cassette": "cassette",
+	"File":     "digital",
+}
+
+// discogsGrade matches the grade in a Discogs media condition, such as
+// the NM in "Near Mint (NM or M-)".
+var discogsGrade = regexp.MustCompile(`\(([A-Z]+\+?)`)
+
+// discogsItem is a release in a Discogs collection.
+type discogsItem struct {
+	InstanceID int    `json:"instance_id"`
+	DateAdded  string `json:"date_added"`
+	Basic      struct {
+		ID         int             `json:"id"`
+		Title      string          `json:"title"`
+		Year       int             `json:"year"`
+		Artists    []discogsArtist `json:"artists"`
+		Genres     []string        `json:"genres"`
+		CoverImage string          `json:"cover_image"`
+		Labels     []struct {
+			Name  string `json:"name"`
+			CatNo string `json:"catno"`
+		} `json:"labels"`
+		Formats []struct {
+			Name string `json:"name"`
+		} `json:"formats"`
+	} `json:"basic_information"`
+	Notes []struct {
+		FieldID int    `json:"field_id"`
+		Value   string `json:"value"`
+	} `json:"notes"`
+}
+
+// format returns the collection format of the item, or "" for formats
+// the collection does not track, such as box sets of memorabilia.
+func (item discogsItem) format() string {
+	for _, f := range item.Basic.Formats {
+		if format, ok := discogsFormats[f.Name]; ok {
+			return format
+		}
+	}
+	return ""
+}
+
+// condition returns the grade of the item's media condition, which
+// Discogs keeps in note field 1.
+func (item discogsItem) condition() string {
+	for _, n := range item.Notes {
+		if n.FieldID != 1 {
+			continue
+		}
+		if m := discogsGrade.FindStringSubmatch(n.Value); m != nil && slices.ContainsFunc(conditionGrades, func(g conditionGrade) bool { return g.Grade == m[1] }) {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// album returns the album the item is a copy of.
+func (item discogsItem) album() album {
+	a := album{
+		ID:        newID(),
+		Title:     item.Basic.Title,
+		Artist:    discogsArtists(item.Basic.Artists),
+		Year:      item.Basic.Year,
+		CoverURL:  item.Basic.CoverImage,
+		DiscogsID: item.Basic.ID,
+	}
+	if len(item.Basic.Genres) > 0 {
+		a.Genre = item.Basic.Genres[0]
+	}
+	if len(item.Basic.Labels) > 0 {
+		a.Label, a.CatalogNumber = discogsNumbered.ReplaceAllString(item.Basic.Labels[0].Name, ""), item.Basic.Labels[0].CatNo
+	}
+	return a
+}
+
+// findDiscogsAlbum returns the position of the album of a Discogs release,
+// matched by release or else by artist and title, or -1. Callers must
+// hold albumsMu.
+func findDiscogsAlbum(a album) int {
+	key := albumKey(a.Artist, a.Title)
+	if i := slices.IndexFunc(albums, func(b album) bool { return a.DiscogsID != 0 && b.DiscogsID == a.DiscogsID }); i >= 0 {
+		return i
+	}
+	return slices.IndexFunc(albums, func(b album) bool { return b.DiscogsID == 0 && albumKey(b.Artist, b.Title) == key })
+}
+
+type discogsSyncResult struct {
+	Imported int `json:"imported"`
+	Pushed   int `json:"pushed"`
+	// Skipped counts releases in formats the collection does not track.
+	Skipped int `json:"skipped"`
+}
+
+// syncDiscogs imports the user's Discogs collection into theirs, adding
+// albums for releases not in the library, and with Push on, adds their
+// physical copies of albums known to Discogs to the Discogs collection.
+// Copies removed on either side are left alone on the other.
+func syncDiscogs(ctx context.Context, user string) (discogsSyncResult, error) {
+	result, err := syncDiscogsAccount(ctx, user)
+
+	albumsMu.Lock()
+	if account, ok := discogsAccounts[user]; ok {
+		now := time.Now().UTC()
+		account.LastSync, account.LastError = &now, ""
+		if err != nil {
+			account.LastError = err.Error()
+		}
+		discogsAccounts[user] = account
+	}
+	albumsMu.Unlock()
+	return result, err
+}
+
+func syncDiscogsAccount(ctx context.Context, user string) (discogsSyncResult, error) {
+	var result discogsSyncResult
+	albumsMu.RLock()
+	account, ok := discogsAccounts[user]
+	albumsMu.RUnlock()
+	if !ok {
+		return result, errDiscogsNotConnected
+	}
+
+	var items []discogsItem
+	path := "/users/" + url.PathEscape(account.Username) + "/collection/folders/0/releases"
+	for page := 1; ; page++ {
+		var list struct {
+			Pagination struct {
+				Pages int `json:"pages"`
+			} `json:"pagination"`
+			Releases []discogsItem `json:"releases"`
+		}
+		query := url.Values{"page": {strconv.Itoa(page)}, "per_page": {"100"}, "sort": {"added"}}
+		if err := discogs.call(ctx, http.MethodGet, path, query, &account, &list); err != nil {
+			return result, err
+		}
+		items = append(items, list.Releases...)
+		if page >= list.Pagination.Pages {
+			break
+		}
+	}
+
+	albumsMu.RLock()
+	synced := map[int]bool{}
+	for _, o := range collection {
+		if o.Owner == user && o.DiscogsInstanceID != 0 {
+			synced[o.DiscogsInstanceID] = true
+		}
+	}
+	var fresh []discogsItem
+	missing := map[int]album{}
+	for _, item := range items {
+		if synced[item.InstanceID] {
+			continue
+		}
+		if item.format() == "" {
+			result.Skipped++
+			continue
+		}
+		fresh = append(fresh, item)
+		if a := item.album(); findDiscogsAlbum(a) < 0 && missing[a.DiscogsID].ID == "" {
+			missing[a.DiscogsID] = a
+		}
+	}
+	albumsMu.RUnlock()
+
+	ids := make([]int, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		change := &priceChange{ChangedAt: time.Now().UTC(), ChangedBy: user}
+		if _, err := insertAlbum(ctx, missing[id], nil, change); err != nil {
+			return result, err
+		}
+	}
+
+	albumsMu.Lock()
+	for _, item := range fresh {
+		if slices.ContainsFunc(collection, func(o ownedCopy) bool { return o.Owner == user && o.DiscogsInstanceID == item.InstanceID }) {
+			continue
+		}
+		i := findDiscogsAlbum(item.album())
+		if i < 0 {
+			continue
+		}
+		albums[i].DiscogsID = item.Basic.ID
+		added, err := time.Parse(time.RFC3339, item.DateAdded)
+		if err != nil {
+			added = time.Now()
+		}
+		collection = append(collection, ownedCopy{
+			ID:                newID(),
+			Owner:             user,
+			AlbumID:           albums[i].ID,
+			Format:            item.format(),
+			Condition:         item.condition(),
+			AddedAt:           added.UTC(),
+			DiscogsInstanceID: item.InstanceID,
+		})
+		result.Imported++
+	}
+	var unpushed []ownedCopy
+	releases := map[string]int{}
+	for _, o := range collection {
+		if !account.Push || o.Owner != user || o.DiscogsInstanceID != 0 || o.Format == "digital" {
+			continue
+		}
+		if i := findAlbum(o.AlbumID); i >= 0 && albums[i].DiscogsID != 0 {
+			unpushed = append(unpushed, o)
+			releases[o.ID] = albums[i].DiscogsID
+		}
+	}
+	albumsMu.Unlock()
+
+	var errs []error
+	for _, o := range unpushed {
+		var added struct {
+			InstanceID int `json:"instance_id"`
+		}
+		// Folder 1 is "Uncategorized", where Discogs files additions.
+		push := "/users/" + url.PathEscape(account.Username) + "/collection/folders/1/releases/" + strconv.Itoa(releases[o.ID])
+		if err := discogs.call(ctx, http.MethodPost, push, nil, &account, &added); err != nil {
+			errs = append(errs, fmt.Errorf("pushing %s: %w", o.AlbumID, err))
+			continue
+		}
+		albumsMu.Lock()
+		if i := findCopy(o.ID, user); i >= 0 {
+			collection[i].DiscogsInstanceID = added.InstanceID
+			result.Pushed++
+		}
+		albumsMu.Unlock()
+	}
+	return result, errors.Join(errs...)
+}
+
+// syncAllDiscogs is the discogs-sync task. It syncs every connected
+// account.
+func syncAllDiscogs(ctx context.Context) error {
+	if discogs == nil {
+		return nil
+	}
+
+	albumsMu.RLock()
+	users := make([]string, 0, len(discogsAccounts))
+	for user := range discogsAccounts {
+		users = append(users, user)
+	}
+	albumsMu.RUnlock()
+	sort.Strings(users)
+
+	var errs []error
+	for _, user := range users {
+		if _, err := syncDiscogs(ctx, user); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", user, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getMyDiscogs reports whether the user has connected Discogs, and how
+// the last sync went. Tokens are not shown.
+func getMyDiscogs(c *gin.Context) {
+	albumsMu.RLock()
+	account, ok := discogsAccounts[c.GetString(gin.AuthUserKey)]
+	albumsMu.RUnlock()
+
+	if !ok {
+		c.IndentedJSON(http.StatusOK, gin.H{"connected": false})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"connected": true,
+		"username":  account.Username,
+		"push":      account.Push,
+		"lastSync":  account.LastSync,
+		"lastError": account.LastError,
+	})
+}
+
+// postDiscogsConnect starts connecting the user's Discogs account. It
+// returns the address where the user authorizes the app, which sends them
+// back to getDiscogsCallback.
+func postDiscogsConnect(c *gin.Context) {
+	if discogs == nil || discogs.consumerKey == "" {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "Discogs is not configured"})
+		return
+	}
+
+	callback := absoluteURL(c, "/discogs/callback")
+	values, err := discogs.oauthToken(c.Request.Context(), http.MethodGet, "/oauth/request_token", "", "", map[string]string{"oauth_callback": callback})
+	if err != nil {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	albumsMu.Lock()
+	for token, p := range discogsRequests {
+		if now.Sub(p.CreatedAt) > discogsPendingTTL {
+			delete(discogsRequests, token)
+		}
+	}
+	discogsRequests[values.Get("oauth_token")] = discogsPending{User: c.GetString(gin.AuthUserKey), Secret: values.Get("oauth_token_secret"), CreatedAt: now}
+	albumsMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"authorizeUrl": discogsAuthorizeURL + "?oauth_token=" + url.QueryEscape(values.Get("oauth_token"))})
+}
+
+// getDiscogsCallback finishes connecting a Discogs account. Discogs sends
+// the user here after they authorize the app; the request token says who
+// they are.
+func getDiscogsCallback(c *gin.Context) {
+	token := c.Query("oauth_token")
+	albumsMu.Lock()
+	pending, ok := discogsRequests[token]
+	delete(discogsRequests, token)
+	albumsMu.Unlock()
+
+	if !ok || time.Since(pending.CreatedAt) > discogsPendingTTL {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unknown or expired Discogs authorization"})
+		return
+	}
+	values, err := discogs.oauthToken(c.Request.Context(), http.MethodPost, "/oauth/access_token", token, pending.Secret, map[string]string{"oauth_verifier": c.Query("oauth_verifier")})
+	if err != nil {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+	account := discogsAccount{Token: values.Get("oauth_token"), TokenSecret: values.Get("oauth_token_secret")}
+	var identity struct {
+		Username string `json:"username"`
+	}
+	if err := discogs.call(c.Request.Context(), http.MethodGet, "/oauth/identity", nil, &account, &identity); err != nil {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+	account.Username = identity.Username
+
+	albumsMu.Lock()
+	discogsAccounts[pending.User] = account
+	albumsMu.Unlock()
+	c.IndentedJSON(http.StatusOK, gin.H{"connected": true, "username": account.Username})
+}
+
+// putMyDiscogs sets whether copies added here are pushed to Discogs.
+func putMyDiscogs(c *gin.Context) {
+	var input struct {
+		Push bool `json:"push"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	user := c.GetString(gin.AuthUserKey)
+	account, ok := discogsAccounts[user]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": errDiscogsNotConnected.Error()})
+		return
+	}
+	account.Push = input.Push
+	discogsAccounts[user] = account
+	c.IndentedJSON(http.StatusOK, gin.H{"connected": true, "username": account.Username, "push": account.Push})
+}
+
+// deleteMyDiscogs disconnects the user's Discogs account. Imported copies
+// stay in the collection.
+func deleteMyDiscogs(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	user := c.GetString(gin.AuthUserKey)
+	if _, ok := discogsAccounts[user]; !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": errDiscogsNotConnected.Error()})
+		return
+	}
+	delete(discogsAccounts, user)
+	c.Status(http.StatusNoContent)
+}
+
+// postDiscogsSync syncs the user's Discogs collection now rather than at
+// the next discogs-sync run.
+func postDiscogsSync(c *gin.Context) {
+	if discogs == nil {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "Discogs is not configured"})
+		return
+	}
+	result, err := syncDiscogs(c.Request.Context(), c.GetString(gin.AuthUserKey))
+	switch {
+	case errors.Is(err, errDiscogsNotConnected):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case err != nil:
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error(), "result": result})
+	default:
+		c.IndentedJSON(http.StatusOK, result)
+	}
+}
+
+func dumpDiscogsAccounts() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(discogsAccounts, "", "    ")
+}
+
+func restoreDiscogsAccounts(data []byte) (func(), int, error) {
+	var restored map[string]discogsAccount
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]discogsAccount{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		discogsAccounts = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscogsConnect(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	previous := discogs
+	t.Cleanup(func() { discogs = previous })
+
+	// Serve a fake Discogs OAuth flow
+	dc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/oauth/request_token":
+			if !strings.Contains(auth, `oauth_signature="shh%26"`) || !strings.Contains(auth, `%2Fdiscogs%2Fcallback"`) {
+				t.Errorf("Expected a signed request with a callback, but got %s", auth)
+			}
+			w.Write([]byte("oauth_token=req&oauth_token_secret=reqsecret&oauth_callback_confirmed=true"))
+		case "/oauth/access_token":
+			if !strings.Contains(auth, `oauth_signature="shh%26reqsecret"`) || !strings.Contains(auth, `oauth_verifier="v123"`) {
+				t.Errorf("Expected the request token and verifier, but got %s", auth)
+			}
+			w.Write([]byte("oauth_token=acc&oauth_token_secret=accsecret"))
+		case "/oauth/identity":
+			if !strings.Contains(auth, `oauth_token="acc"`) {
+				t.Errorf("Expected the access token, but got %s", auth)
+			}
+			w.Write([]byte(`{"username": "bobsrecords"}`))
+		}
+	}))
+	defer dc.Close()
+	discogs = newDiscogsClient(dc.URL, "")
+	discogs.consumerKey, discogs.consumerSecret = "app", "shh"
+
+	// Check connecting sends the user to Discogs to authorize the app
+	rr := performRequest(router, "POST", "/me/discogs/connect", nil, "bob")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), discogsAuthorizeURL+"?oauth_token=req") {
+		t.Errorf("Expected the authorize address, but got %d %s", rr.Code, rr.Body)
+	}
+
+	// Check the callback stores the account under the user who connected
+	if rr = performRequest(router, "GET", "/discogs/callback?oauth_token=nope&oauth_verifier=v123", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr = performRequest(router, "GET", "/discogs/callback?oauth_token=req&oauth_verifier=v123", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if account := discogsAccounts["bob"]; account.Username != "bobsrecords" || account.Token != "acc" || account.TokenSecret != "accsecret" {
+		t.Errorf("Expected bob's Discogs account, but got %+v", account)
+	}
+	rr = performRequest(router, "GET", "/me/discogs", nil, "bob")
+	if strings.Contains(rr.Body.String(), "accsecret") || !strings.Contains(rr.Body.String(), `"username": "bobsrecords"`) {
+		t.Errorf("Expected the account without its tokens, but got %s", rr.Body)
+	}
+
+	// Check a request token is only good once
+	if rr = performRequest(router, "GET", "/discogs/callback?oauth_token=req&oauth_verifier=v123", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestDiscogsSync(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	previous, backoff := discogs, discogsBackoff
+	t.Cleanup(func() { discogs, discogsBackoff = previous, backoff })
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 40},
+		{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", DiscogsID: 77},
+	}
+
+	// Serve a fake Discogs collection of two pages, which is rate limited
+	// at first
+	var requests, pushes atomic.Int32
+	dc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Query().Get("page") == "1":
+			w.Write([]byte(`{"pagination": {"pages": 2}, "releases": [
+				{"instance_id": 1001, "date_added": "2024-01-02T10:00:00-08:00",
+					"basic_information": {"id": 11, "title": "Blue Train", "year": 1957, "artists": [{"name": "John Coltrane"}], "formats": [{"name": "Vinyl"}]},
+					"notes": [{"field_id": 1, "value": "Very Good Plus (VG+)"}]},
+				{"instance_id": 1002, "basic_information": {"id": 12, "title": "Kind of Blue", "artists": [{"name": "Miles Davis"}],
+					"labels": [{"name": "Columbia", "catno": "CL 1355"}], "formats": [{"name": "CD"}]}}]}`))
+		case r.Method == "GET":
+			w.Write([]byte(`{"pagination": {"pages": 2}, "releases": [
+				{"instance_id": 1003, "basic_information": {"id": 13, "title": "Poster", "formats": [{"name": "All Media"}]}}]}`))
+		case r.Method == "POST" && r.URL.Path == "/users/bobsrecords/collection/folders/1/releases/77":
+			pushes.Add(1)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"instance_id": 2001}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer dc.Close()
+	discogs, discogsBackoff = newDiscogsClient(dc.URL, ""), 0
+	discogsAccounts["bob"] = discogsAccount{Username: "bobsrecords", Token: "acc", TokenSecret: "accsecret", Push: true}
+	performRequest(router, "POST", "/collection", strings.NewReader(`{"albumId": "2", "format": "vinyl"}`), "bob")
+	performRequest(router, "POST", "/collection", strings.NewReader(`{"albumId": "1", "format": "digital"}`), "bob")
+
+	// Check the collection is imported and the new copy pushed
+	rr := performRequest(router, "POST", "/me/discogs/sync", nil, "bob")
+	var result discogsSyncResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if rr.Code != http.StatusOK || result != (discogsSyncResult{Imported: 2, Pushed: 1, Skipped: 1}) {
+		t.Errorf("Expected 2 imported, 1 pushed and 1 skipped, but got %d %s", rr.Code, rr.Body)
+	}
+	if len(albums) != 3 || albums[0].DiscogsID != 11 || albums[2].Title != "Kind of Blue" || albums[2].Label != "Columbia" {
+		t.Errorf("Expected Blue Train to be matched and Kind of Blue added, but got %+v", albums)
+	}
+	if len(collection) != 4 || collection[0].DiscogsInstanceID != 2001 || collection[2].Condition != "VG+" ||
+		!collection[2].AddedAt.Equal(time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the copies to be synced, but got %+v", collection)
+	}
+
+	// Check syncing again changes nothing
+	performRequest(router, "POST", "/me/discogs/sync", nil, "bob")
+	if len(collection) != 4 || pushes.Load() != 1 || discogsAccounts["bob"].LastSync == nil {
+		t.Errorf("Expected nothing new, but got %d copies and %d pushes", len(collection), pushes.Load())
+	}
+
+	// Check disconnecting keeps the copies
+	performRequest(router, "DELETE", "/me/discogs", nil, "bob")
+	if rr = performRequest(router, "POST", "/me/discogs/sync", nil, "bob"); rr.Code != http.StatusNotFound || len(collection) != 4 {
+		t.Errorf("Expected status code %d with the copies kept, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
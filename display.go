@@ -0,0 +1,183 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// displayPing is how often a display's event stream checks for changes the
+// player does not signal, such as the next track starting, and otherwise
+// sends a comment to keep proxies from closing it.
+const displayPing = 5 * time.Second
+
+// displays holds a channel per open event stream, closed when the server
+// shuts down so the streams do not hold up the drain.
+var displays = struct {
+	sync.Mutex
+	m map[chan struct{}]bool
+}{m: map[chan struct{}]bool{}}
+
+// closeDisplays ends every open display event stream.
+func closeDisplays() {
+	displays.Lock()
+	defer displays.Unlock()
+
+	for ch := range displays.m {
+		close(ch)
+		delete(displays.m, ch)
+	}
+}
+
+var displayPage = template.Must(template.New("display").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Now playing</title>
+<style>
+html,body{margin:0;height:100%;background:#111;color:#eee;font-family:sans-serif;overflow:hidden}
+main{display:flex;height:100%;align-items:center;gap:5vw;padding:0 5vw;box-sizing:border-box}
+#cover{width:40vw;max-width:80vh;aspect-ratio:1;object-fit:cover;border-radius:1vw;background:#222}
+#info{flex:1;min-width:0}
+#title{font-size:6vw;font-weight:bold}#artist{font-size:3.5vw;margin-top:1vw}#album{font-size:2.5vw;color:#999}
+#title,#artist,#album{white-space:nowrap;overflow:hidden;text-overflow:ellipsis}
+#bar{height:1vw;background:#333;border-radius:1vw;margin:3vw 0}#progress{height:100%;width:0;background:#eee;border-radius:1vw}
+h2{font-size:2vw;color:#999;font-weight:normal;margin:0 0 1vw}
+ol{font-size:2vw;margin:0;padding-left:1.5em;color:#ccc}
+.idle #bar,.idle h2,.idle ol{visibility:hidden}
+</style>
+</head>
+<body>
+<main{{if not .Track}} class="idle"{{end}}>
+<img id="cover" src="{{with .Track}}{{.CoverURL}}{{end}}" alt="">
+<div id="info">
+<div id="title">{{with .Track}}{{.Title}}{{else}}Nothing playing{{end}}</div>
+<div id="artist">{{with .Track}}{{.Artist}}{{end}}</div>
+<div id="album">{{with .Track}}{{.Album}}{{end}}</div>
+<div id="bar"><div id="progress"></div></div>
+<h2>Up next</h2>
+<ol id="next">{{range .NextUp}}<li>{{.Title}}{{with .Artist}} – {{.}}{{end}}</li>{{end}}</ol>
+</div>
+</main>
+<script>
+var state = {{.}}, received = Date.now();
+function $(id) { return document.getElementById(id); }
+function render() {
+	var t = state.track;
+	document.querySelector("main").className = t ? "" : "idle";
+	$("title").textContent = t ? t.title : "Nothing playing";
+	$("artist").textContent = t ? t.artist : "";
+	$("album").textContent = t && t.album || "";
+	var cover = t && t.coverUrl || "";
+	if ($("cover").getAttribute("src") !== cover) $("cover").setAttribute("src", cover);
+	var next = $("next");
+	next.textContent = "";
+	(state.nextUp || []).forEach(function (n) {
+		var li = document.createElement("li");
+		li.textContent = n.title + (n.artist ? " – " + n.artist : "");
+		next.appendChild(li);
+	});
+}
+setInterval(function () {
+	var t = state.track, elapsed = state.elapsed;
+	if (state.state === "playing") elapsed += (Date.now() - received) / 1000;
+	$("progress").style.width = t && t.duration ? Math.min(100, 100 * elapsed / t.duration) + "%" : "0";
+}, 250);
+new EventSource("/display/events").addEventListener("now-playing", function (e) {
+	state = JSON.parse(e.data);
+	received = Date.now();
+	render();
+});
+</script>
+</body>
+</html>
+`))
+
+// displayNowPlaying is what the player is doing, with covers pointing at
+// the artwork files where the library has them.
+func displayNowPlaying() nowPlaying {
+	current := currentNowPlaying()
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	cover := func(t *nowPlayingTrack) {
+		if t.AlbumID != "" && albumCoverFile(t.AlbumID) != "" {
+			t.CoverURL = "/albums/" + t.AlbumID + "/cover"
+			if thumbnailDir != "" {
+				t.CoverURL += "?size=600"
+			}
+		}
+	}
+	if current.Track != nil {
+		cover(current.Track)
+	}
+	for i := range current.NextUp {
+		cover(&current.NextUp[i])
+	}
+	return current
+}
+
+// getDisplay serves a full-screen now-playing page for a TV or tablet. It
+// is rendered with the current state and then kept up to date from
+// /display/events.
+func getDisplay(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	displayPage.Execute(c.Writer, displayNowPlaying())
+}
+
+// getDisplayEvents streams now-playing events: one straight away, then one
+// each time the player changes.
+func getDisplayEvents(c *gin.Context) {
+	changes := musicPlayer.watch()
+	defer musicPlayer.unwatch(changes)
+	closed := make(chan struct{})
+	displays.Lock()
+	displays.m[closed] = true
+	displays.Unlock()
+	defer func() {
+		displays.Lock()
+		delete(displays.m, closed)
+		displays.Unlock()
+	}()
+	ticker := time.NewTicker(displayPing)
+	defer ticker.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	// Tell nginx not to buffer the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	last := ""
+	send := func(always bool) {
+		current := displayNowPlaying()
+		if key := current.changeKey(); always || key != last {
+			c.SSEvent("now-playing", current)
+			last = key
+		} else {
+			c.Writer.WriteString(": ping\n\n")
+		}
+		c.Writer.Flush()
+	}
+	send(true)
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-changes:
+			send(true)
+		case <-ticker.C:
+			send(false)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisplay(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane", CoverURL: "https://covers.example.com/1.jpg"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Blue Train", Artist: "John Coltrane", Duration: 643},
+		{ID: "2", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane", Duration: 551},
+	}
+
+	// Check the page is rendered with the current state
+	rr := performRequest(router, "GET", "/display", nil, "bob")
+	if !strings.Contains(rr.Body.String(), `<div id="title">Nothing playing</div>`) {
+		t.Errorf("Expected an idle page, but got %s", rr.Body)
+	}
+	musicPlayer.Enqueue("1", "2")
+	musicPlayer.Play()
+	rr = performRequest(router, "GET", "/display", nil, "bob")
+	body := rr.Body.String()
+	if !strings.Contains(body, `<div id="title">Blue Train</div>`) || !strings.Contains(body, `<li>Moment&#39;s Notice – John Coltrane</li>`) || !strings.Contains(body, `src="https://covers.example.com/1.jpg"`) {
+		t.Errorf("Expected the playing track, its cover and what is up next, but got %s", body)
+	}
+
+	// Check the event stream sends the state and then each change
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL+"/display/events", nil)
+	req.SetBasicAuth("bob", "bob-pw")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, but got %q", resp.Header.Get("Content-Type"))
+	}
+	events := bufio.NewScanner(resp.Body)
+	next := func() nowPlaying {
+		var current nowPlaying
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data:"); ok {
+				json.Unmarshal([]byte(data), &current)
+				return current
+			}
+		}
+		t.Fatalf("Expected an event, but the stream ended: %v", events.Err())
+		return current
+	}
+	if current := next(); current.State != statePlaying || current.Track.ID != "1" {
+		t.Errorf("Expected track 1 playing, but got %+v", current)
+	}
+	musicPlayer.Skip(1)
+	if current := next(); current.Track.ID != "2" || len(current.NextUp) != 0 {
+		t.Errorf("Expected track 2 with nothing up next, but got %+v", current)
+	}
+
+	// Check shutting down ends the stream
+	closeDisplays()
+	for events.Scan() {
+	}
+	if len(musicPlayer.watchers) != 0 {
+		t.Errorf("Expected the stream to stop watching the player, but %d watchers are left", len(musicPlayer.watchers))
+	}
+}
+
+// Covers in the library are shown as thumbnails only when those are made
+func TestDisplayCovers(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	savedDir := thumbnailDir
+	t.Cleanup(func() { thumbnailDir = savedDir })
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("jpeg"), 0o644)
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Blue Train", Path: filepath.Join(dir, "1.flac")}}
+	musicPlayer.Enqueue("1")
+	musicPlayer.Play()
+
+	// Check the plain cover is shown without thumbnails, and a thumbnail
+	// with them
+	for dir, want := range map[string]string{"": "/albums/1/cover", t.TempDir(): "/albums/1/cover?size=600"} {
+		thumbnailDir = dir
+		if current := displayNowPlaying(); current.Track == nil || current.Track.CoverURL != want {
+			t.Errorf("Expected cover %s, but got %+v", want, current.Track)
+		}
+	}
+}
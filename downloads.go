@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signingKey signs download URLs and downloadTTL is how long they stay
+// valid; both are set from configuration at startup. Without a configured
+// key, a random one is used, so URLs do not survive a restart.
+var (
+	signingKey  = randomKey()
+	downloadTTL = 24 * time.Hour
+)
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// fileChecksum caches a file's SHA-256 for as long as its size and
+// modification time stay the same.
+type fileChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	checksumMu sync.Mutex
+	checksums  = map[string]fileChecksum{}
+)
+
+// sha256File returns the checksum and size of the file at path.
+func sha256File(path string) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	checksumMu.Lock()
+	cached, ok := checksums[path]
+	checksumMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, cached.size, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", 0, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	checksumMu.Lock()
+	checksums[path] = fileChecksum{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	checksumMu.Unlock()
+	return sum, info.Size(), nil
+}
+
+func downloadSignature(trackID string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(trackID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedDownloadURL returns a URL that downloads a track until expires.
+func signedDownloadURL(trackID string, expires time.Time) string {
+	unix := expires.Unix()
+	return "/downloads/tracks/" + trackID + "?expires=" + strconv.FormatInt(unix, 10) + "&sig=" + downloadSignature(trackID, unix)
+}
+
+type manifestEntry struct {
+	TrackID string `json:"trackId"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	AlbumID string `json:"albumId"`
+	Format  string `json:"format,omitempty"`
+	URL     string `json:"url"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+type downloadManifest struct {
+	ExpiresAt time.Time       `json:"expiresAt"`
+	Tracks    []manifestEntry `json:"tracks"`
+	TotalSize int64           `json:"totalSize"`
+	// Unavailable lists tracks that have no readable file.
+	Unavailable []string `json:"unavailable"`
+}
+
+// buildManifest lists signed downloads for ts, checksumming their files.
+func buildManifest(ts []track) downloadManifest {
+	expires := time.Now().Add(downloadTTL).Truncate(time.Second)
+	m := downloadManifest{ExpiresAt: expires.UTC(), Tracks: []manifestEntry{}, Unavailable: []string{}}
+	for _, t := range ts {
+		if t.Path == "" {
+			m.Unavailable = append(m.Unavailable, t.ID)
+			continue
+		}
+		sum, size, err := sha256File(t.Path)
+		if err != nil {
+			m.Unavailable = append(m.Unavailable, t.ID)
+			continue
+		}
+		m.Tracks = append(m.Tracks, manifestEntry{
+			TrackID: t.ID, Title: t.Title, Artist: t.Artist, AlbumID: t.AlbumID, Format: t.Format,
+			URL: signedDownloadURL(t.ID, expires), Size: size, SHA256: sum,
+		})
+		m.TotalSize += size
+	}
+	return m
+}
+
+// getAlbumManifest lists downloads for an album's tracks in disc order.
+func getAlbumManifest(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	if findAlbum(id) < 0 {
+		albumsMu.RUnlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	var albumTracks []track
+	for _, t := range tracks {
+		if t.AlbumID == id {
+			albumTracks = append(albumTracks, t)
+		}
+	}
+	albumsMu.RUnlock()
+
+	sortTracks(albumTracks)
+	c.IndentedJSON(http.StatusOK, buildManifest(albumTracks))
+}
+
+// getPlaylistManifest lists downloads for one of the user's playlists in
+// playlist order.
+func getPlaylistManifest(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	var listed []track
+	found := false
+	for _, p := range playlists {
+		if p.ID == c.Param("id") && p.Owner == user {
+			found = true
+			for _, id := range resolvePlaylist(p).TrackIDs {
+				if i := findTrack(id); i >= 0 {
+					listed = append(listed, tracks[i])
+				}
+			}
+		}
+	}
+	albumsMu.RUnlock()
+
+	if !found {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "playlist not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, buildManifest(listed))
+}
+
+// getSignedDownload sends a track's file when the URL's signature is valid
+// and has not expired.
+func getSignedDownload(c *gin.Context) {
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(downloadSignature(id, expires))) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": "invalid download signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.IndentedJSON(http.StatusGone, gin.H{"message": "download link has expired"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment")
+	getTrackStream(c)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadManifest(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "01.flac"), []byte("first"), 0o644)
+	os.WriteFile(filepath.Join(dir, "02.flac"), []byte("second"), 0o644)
+	albums = []album{{ID: "1", Title: "Blue Train"}}
+	tracks = []track{
+		{ID: "2", AlbumID: "1", Number: 2, Path: filepath.Join(dir, "02.flac")},
+		{ID: "1", AlbumID: "1", Number: 1, Path: filepath.Join(dir, "01.flac")},
+		{ID: "3", AlbumID: "1", Number: 3},
+	}
+
+	// Check album manifests list sizes, checksums and signed URLs in order
+	rr := performRequest(router, "GET", "/albums/1/download-manifest", nil, "")
+	var m downloadManifest
+	json.Unmarshal(rr.Body.Bytes(), &m)
+	sum := sha256.Sum256([]byte("first"))
+	if len(m.Tracks) != 2 || m.Tracks[0].TrackID != "1" || m.Tracks[0].Size != 5 || m.Tracks[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Expected tracks 1 and 2 with checksums, but got %+v", m.Tracks)
+	}
+	if m.TotalSize != 11 || len(m.Unavailable) != 1 || m.Unavailable[0] != "3" {
+		t.Errorf("Expected 11 bytes and track 3 unavailable, but got %+v", m)
+	}
+
+	// Check the signed URL downloads the file
+	rr = performRequest(router, "GET", m.Tracks[1].URL, nil, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "second" {
+		t.Errorf("Expected the file, but got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Check tampered and expired URLs are refused
+	tampered := strings.Replace(m.Tracks[1].URL, "/tracks/2", "/tracks/1", 1)
+	if rr := performRequest(router, "GET", tampered, nil, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	expired := time.Now().Add(-time.Minute)
+	if rr := performRequest(router, "GET", signedDownloadURL("1", expired), nil, ""); rr.Code != http.StatusGone {
+		t.Errorf("Expected status code %d, but got %d", http.StatusGone, rr.Code)
+	}
+	if !strings.Contains(signedDownloadURL("1", expired), "expires="+strconv.FormatInt(expired.Unix(), 10)) {
+		t.Errorf("Expected the expiry in the URL")
+	}
+
+	// Check playlist manifests follow the playlist and its owner
+	playlists = []playlist{{ID: "p1", Owner: "bob", TrackIDs: []string{"2", "1"}}}
+	rr = performRequest(router, "GET", "/playlists/p1/download-manifest", nil, "bob")
+	m = downloadManifest{}
+	json.Unmarshal(rr.Body.Bytes(), &m)
+	if len(m.Tracks) != 2 || m.Tracks[0].TrackID != "2" {
+		t.Errorf("Expected tracks 2 and 1, but got %+v", m.Tracks)
+	}
+	if rr := performRequest(router, "GET", "/playlists/p1/download-manifest", nil, "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
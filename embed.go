@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// embedStreamTTL is how long the stream URL in a widget stays valid; long
+// enough for a reader to leave a page open before pressing play.
+const embedStreamTTL = 12 * time.Hour
+
+// embedOrigins is set from configuration at startup: the sites allowed to
+// frame the widget, as host names such as blog.example.com, *.example.com
+// for any subdomain, or * for anywhere. Empty only allows this server.
+var embedOrigins []string
+
+// embedAllowed reports whether a page on host may frame the widget.
+func embedAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, origin := range embedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*", origin == host:
+			return true
+		case strings.HasPrefix(origin, "*.") && strings.HasSuffix(host, origin[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+func embedSignature(trackID string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("embed\n" + trackID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedEmbedStreamURL returns a URL that streams a track until expires.
+// It is signed apart from download URLs, so it cannot be used as one.
+func signedEmbedStreamURL(trackID string, expires time.Time) string {
+	unix := expires.Unix()
+	return "/embed/" + trackID + "/stream?expires=" + strconv.FormatInt(unix, 10) + "&sig=" + embedSignature(trackID, unix)
+}
+
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>body{margin:0;font-family:sans-serif;font-size:14px}.w{display:flex;gap:10px;align-items:center;padding:8px;border:1px solid #ddd;border-radius:6px}.w img{width:64px;height:64px;object-fit:cover;border-radius:4px}.m{flex:1;min-width:0}.m div{white-space:nowrap;overflow:hidden;text-overflow:ellipsis}.a{color:#666}audio{width:100%;margin-top:6px}</style>
+</head>
+<body>
+<div class="w">{{with .CoverURL}}<img src="{{.}}" alt="">{{end}}
+<div class="m"><div><b>{{.Title}}</b></div><div class="a">{{.Artist}}</div>
+<audio controls preload="none" src="{{.StreamURL}}"></audio></div>
+</div>
+</body>
+</html>
+`))
+
+// embedData fills in embedPage.
+type embedData struct {
+	Title, Artist, CoverURL, StreamURL string
+}
+
+// getEmbed serves a small player for one track, to be put in an iframe on
+// another site. Browsers keep it to the allowed sites through the
+// Content-Security-Policy; requests from other sites that give a Referer
+// are refused outright.
+func getEmbed(c *gin.Context) {
+	if referer, err := url.Parse(c.GetHeader("Referer")); err == nil && referer.Host != "" && referer.Host != c.Request.Host && !embedAllowed(referer.Hostname()) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": "this site may not embed the player"})
+		return
+	}
+
+	albumsMu.RLock()
+	i := findTrack(c.Param("trackId"))
+	var data embedData
+	if i >= 0 {
+		t := tracks[i]
+		data.Title, data.Artist = t.Title, t.Artist
+		if j := findAlbum(t.AlbumID); j >= 0 {
+			if data.Artist == "" {
+				data.Artist = albums[j].Artist
+			}
+			if albumCoverFile(t.AlbumID) != "" {
+				data.CoverURL = "/albums/" + t.AlbumID + "/cover"
+				if thumbnailDir != "" {
+					data.CoverURL += "?size=150"
+				}
+			}
+		}
+		if t.Path == "" {
+			i = -1
+		}
+	}
+	albumsMu.RUnlock()
+
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	data.StreamURL = signedEmbedStreamURL(c.Param("trackId"), time.Now().Add(embedStreamTTL))
+
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src 'self'; media-src 'self'; frame-ancestors "+strings.Join(append([]string{"'self'"}, embedOrigins...), " "))
+	// The page holds a signed URL, so caches may only keep it for a while.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	embedPage.Execute(c.Writer, data)
+}
+
+// getEmbedStream streams a track for a widget when the URL's signature is
+// valid and has not expired.
+func getEmbedStream(c *gin.Context) {
+	id := c.Param("trackId")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(embedSignature(id, expires))) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": "invalid stream signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.IndentedJSON(http.StatusGone, gin.H{"message": "stream link has expired"})
+		return
+	}
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: id})
+	getTrackStream(c)
+}
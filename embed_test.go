@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmbed(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	data := mediaFile(t, 1000)
+	tracks[0].Title = "Blue Train"
+	embedOrigins = []string{"blog.example.com", "*.example.org"}
+	t.Cleanup(func() { embedOrigins = nil })
+
+	// Check the widget may only be framed by the allowed sites
+	rr := performRequest(router, "GET", "/embed/1", nil, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<b>Blue Train</b>") {
+		t.Fatalf("Expected the widget, but got %d: %s", rr.Code, rr.Body)
+	}
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors 'self' blog.example.com *.example.org") {
+		t.Errorf("Expected the allowed sites in frame-ancestors, but got %q", csp)
+	}
+	for referer, want := range map[string]int{
+		"https://blog.example.com/post":   http.StatusOK,
+		"https://www.example.org/":        http.StatusOK,
+		"https://example.org.evil.com/":   http.StatusForbidden,
+		"https://elsewhere.example.net/x": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/embed/1", nil)
+		req.Header.Set("Referer", referer)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Expected status code %d from %s, but got %d", want, referer, rr.Code)
+		}
+	}
+
+	// Check the widget's signed stream plays, and tampered or expired ones do not
+	src := regexp.MustCompile(`src="([^"]+/stream[^"]*)"`).FindStringSubmatch(rr.Body.String())
+	if src == nil {
+		t.Fatalf("Expected an audio source, but got %s", rr.Body)
+	}
+	streamURL := html.UnescapeString(src[1])
+	rr = performRequest(router, "GET", streamURL, nil, "")
+	if rr.Code != http.StatusOK || rr.Body.Len() != len(data) {
+		t.Errorf("Expected the whole file, but got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+	if rr := performRequest(router, "GET", strings.Replace(streamURL, "/embed/1/", "/embed/2/", 1), nil, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for another track, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/downloads/tracks/1?"+strings.SplitN(streamURL, "?", 2)[1], nil, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d using it as a download, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := performRequest(router, "GET", signedEmbedStreamURL("1", time.Now().Add(-time.Minute)), nil, ""); rr.Code != http.StatusGone {
+		t.Errorf("Expected status code %d, but got %d", http.StatusGone, rr.Code)
+	}
+
+	// Check the widget links the plain cover when thumbnails are not
+	// configured
+	savedDir := thumbnailDir
+	thumbnailDir = ""
+	t.Cleanup(func() { thumbnailDir = savedDir })
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 300)))
+	os.WriteFile(filepath.Join(filepath.Dir(tracks[0].Path), "cover.png"), buf.Bytes(), 0o644)
+	cover := func() string {
+		rr := performRequest(router, "GET", "/embed/1", nil, "")
+		img := regexp.MustCompile(`<img src="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+		if img == nil {
+			t.Fatalf("Expected a cover, but got %s", rr.Body)
+		}
+		return html.UnescapeString(img[1])
+	}
+	if src := cover(); src != "/albums/1/cover" {
+		t.Errorf("Expected the plain cover, but got %s", src)
+	} else if rr := performRequest(router, "GET", src, nil, ""); rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected the cover from %s, but got %d: %s", src, rr.Code, rr.Body)
+	}
+
+	// Check the widget's cover is a size thumbnails are made in
+	thumbnailDir = t.TempDir()
+	thumb := cover()
+	if rr := performRequest(router, "GET", thumb, nil, ""); rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Expected the cover thumbnail from %s, but got %d: %s", thumb, rr.Code, rr.Body)
+	}
+
+	// Check unknown tracks have no widget
+	if rr := performRequest(router, "GET", "/embed/9", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// embeddingBatchSize caps how many tracks one run of the job embeds.
+	embeddingBatchSize = 100
+	// lshTables and lshBits shape the approximate index: each table hashes
+	// a vector to lshBits signs of random hyperplanes. More tables find
+	// more true neighbours; more bits make buckets smaller.
+	lshTables = 8
+	lshBits   = 12
+	// lshSeed fixes the hyperplanes, so the buckets come out the same each
+	// time the index is loaded.
+	lshSeed = 1
+)
+
+// exactSearchMax is the index size up to which searches compare against
+// every vector, which is fast enough and always exact.
+var exactSearchMax = 2000
+
+// embeddingModel computes an embedding of the audio file at path: a vector
+// whose closeness to another track's tells how alike they sound.
+type embeddingModel interface {
+	Embed(ctx context.Context, path string) ([]float32, error)
+}
+
+// embedder is nil when no embedding model is configured.
+var embedder embeddingModel
+
+// commandEmbedder runs an external program, such as a script around an
+// audio model, with the file path as its last argument. The program must
+// print the embedding as a JSON array of numbers.
+type commandEmbedder struct {
+	command string
+	args    []string
+}
+
+func (e commandEmbedder) Embed(ctx context.Context, path string) ([]float32, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.command, append(e.args, path)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", e.command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var vector []float32
+	if err := json.Unmarshal(stdout.Bytes(), &vector); err != nil {
+		return nil, fmt.Errorf("%s: bad output: %w", e.command, err)
+	}
+	return vector, nil
+}
+
+// vectorIndex finds the nearest embeddings by cosine similarity. Small
+// indexes are searched exhaustively; larger ones through random-hyperplane
+// LSH, probing each table's bucket and those one bit away, then ranking
+// the candidates exactly.
+type vectorIndex struct {
+	mu sync.RWMutex
+	// vectors are normalized to unit length, so a dot product is the
+	// cosine similarity.
+	vectors map[string][]float32
+	dim     int
+	planes  [][]float32
+	buckets [lshTables]map[uint32][]string
+}
+
+// embeddings holds the track embeddings. It is saved to embeddingsFile,
+// when set, after each run of the job.
+var (
+	embeddings     = newVectorIndex()
+	embeddingsFile string
+)
+
+func newVectorIndex() *vectorIndex {
+	x := &vectorIndex{vectors: map[string][]float32{}}
+	for t := range x.buckets {
+		x.buckets[t] = map[uint32][]string{}
+	}
+	return x
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// hash returns the bucket of a vector in table t. Callers must hold x.mu.
+func (x *vectorIndex) hash(t int, v []float32) uint32 {
+	var h uint32
+	for b := 0; b < lshBits; b++ {
+		if dot(x.planes[t*lshBits+b], v) >= 0 {
+			h |= 1 << b
+		}
+	}
+	return h
+}
+
+// Add stores the embedding of id, replacing any it had. Every embedding
+// must have as many dimensions as the first.
+func (x *vectorIndex) Add(id string, v []float32) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(v) == 0 {
+		return errors.New("embedding is empty")
+	}
+	if x.dim == 0 {
+		x.dim = len(v)
+		r := rand.New(rand.NewSource(lshSeed))
+		x.planes = make([][]float32, lshTables*lshBits)
+		for i := range x.planes {
+			x.planes[i] = make([]float32, x.dim)
+			for j := range x.planes[i] {
+				x.planes[i][j] = float32(r.NormFloat64())
+			}
+		}
+	}
+	if len(v) != x.dim {
+		return fmt.Errorf("embedding has %d dimensions, but the index has %d", len(v), x.dim)
+	}
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0 {
+		return errors.New("embedding is all zeros")
+	}
+	unit := make([]float32, len(v))
+	for i := range v {
+		unit[i] = float32(float64(v[i]) / norm)
+	}
+	x.remove(id)
+	x.vectors[id] = unit
+	for t := range x.buckets {
+		h := x.hash(t, unit)
+		x.buckets[t][h] = append(x.buckets[t][h], id)
+	}
+	return nil
+}
+
+// Remove drops the embedding of id.
+func (x *vectorIndex) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(id)
+}
+
+func (x *vectorIndex) remove(id string) {
+	v, ok := x.vectors[id]
+	if !ok {
+		return
+	}
+	delete(x.vectors, id)
+	for t := range x.buckets {
+		h := x.hash(t, v)
+		ids := x.buckets[t][h]
+		for i := range ids {
+			if ids[i] == id {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(x.buckets[t], h)
+		} else {
+			x.buckets[t][h] = ids
+		}
+	}
+}
+
+// Has says whether id has an embedding.
+func (x *vectorIndex) Has(id string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	_, ok := x.vectors[id]
+	return ok
+}
+
+// Len is the number of embeddings.
+func (x *vectorIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return len(x.vectors)
+}
+
+type vectorMatch struct {
+	ID    string
+	Score float64
+}
+
+// Nearest returns up to limit of the embeddings closest to that of id,
+// most similar first, for which keep is true. Ids without an embedding
+// have no neighbours.
+func (x *vectorIndex) Nearest(id string, limit int, keep func(string) bool) []vectorMatch {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	v, ok := x.vectors[id]
+	if !ok {
+		return nil
+	}
+	candidates := map[string]bool{}
+	if len(x.vectors) <= exactSearchMax {
+		for other := range x.vectors {
+			candidates[other] = true
+		}
+	} else {
+		for t := range x.buckets {
+			h := x.hash(t, v)
+			for _, other := range x.buckets[t][h] {
+				candidates[other] = true
+			}
+			for b := 0; b < lshBits; b++ {
+				for _, other := range x.buckets[t][h^1<<b] {
+					candidates[other] = true
+				}
+			}
+		}
+	}
+	var matches []vectorMatch
+	for other := range candidates {
+		if other != id && keep(other) {
+			matches = append(matches, vectorMatch{ID: other, Score: dot(v, x.vectors[other])})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches[:min(limit, len(matches))]
+}
+
+// Save writes the embeddings to path. The buckets are not saved, since
+// loading rebuilds them.
+func (x *vectorIndex) Save(path string) error {
+	x.mu.RLock()
+	data, err := json.Marshal(x.vectors)
+	x.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// loadEmbeddings fills the index from path. A missing file is not an
+// error, since the job has not run yet.
+func loadEmbeddings(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var vectors map[string][]float32
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	x := newVectorIndex()
+	for id, v := range vectors {
+		if err := x.Add(id, v); err != nil {
+			return fmt.Errorf("%s: track %s: %w", path, id, err)
+		}
+	}
+	embeddings = x
+	return nil
+}
+
+// embedTracks embeds tracks that have a file but no embedding yet, and
+// drops the embeddings of tracks that are gone. New embeddings are
+// published for the other instances. It is run by the scheduler and does
+// nothing when no model is configured.
+func embedTracks(ctx context.Context) error {
+	if embedder == nil {
+		return nil
+	}
+
+	albumsMu.RLock()
+	pending := make(map[string]string)
+	for _, t := range tracks {
+		if t.Path != "" && !embeddings.Has(t.ID) && len(pending) < embeddingBatchSize {
+			pending[t.ID] = t.Path
+		}
+	}
+	embeddings.mu.RLock()
+	var gone []string
+	for id := range embeddings.vectors {
+		if findTrack(id) < 0 {
+			gone = append(gone, id)
+		}
+	}
+	embeddings.mu.RUnlock()
+	albumsMu.RUnlock()
+
+	for _, id := range gone {
+		embeddings.Remove(id)
+	}
+	failed := 0
+	for id, path := range pending {
+		vector, err := embedder.Embed(ctx, path)
+		if err == nil {
+			err = embeddings.Add(id, vector)
+		}
+		if err != nil {
+			log.Printf("embeddings: track %s: %v", id, err)
+			failed++
+			continue
+		}
+		publishEvent(ctx, topicTrackEmbedded, trackEmbedded{ID: id, Vector: vector})
+	}
+	if embeddingsFile != "" && (len(pending) > failed || len(gone) > 0) {
+		if err := embeddings.Save(embeddingsFile); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tracks failed to embed", failed, len(pending))
+	}
+	return nil
+}
+
+type similarTrack struct {
+	Track track   `json:"track"`
+	Score float64 `json:"score"`
+}
+
+// getSimilarTracks lists the tracks that sound most like a track, by the
+// cosine similarity of their embeddings, up to ?limit.
+func getSimilarTracks(c *gin.Context) {
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	id := c.Param("id")
+	if findTrack(id) < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	if !embeddings.Has(id) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track has no embedding yet"})
+		return
+	}
+	similar := []similarTrack{}
+	for _, m := range embeddings.Nearest(id, limit, func(other string) bool { return findTrack(other) >= 0 }) {
+		similar = append(similar, similarTrack{Track: tracks[findTrack(m.ID)], Score: m.Score})
+	}
+	c.IndentedJSON(http.StatusOK, similar)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// embedderFunc lets a test stand in for an embedding model.
+type embedderFunc func(path string) ([]float32, error)
+
+func (f embedderFunc) Embed(_ context.Context, path string) ([]float32, error) { return f(path) }
+
+// useEmbeddings gives a test an empty index saved in a temporary file.
+func useEmbeddings(t *testing.T) {
+	savedIndex, savedFile, savedEmbedder := embeddings, embeddingsFile, embedder
+	embeddings, embeddingsFile = newVectorIndex(), filepath.Join(t.TempDir(), "embeddings.json")
+	t.Cleanup(func() { embeddings, embeddingsFile, embedder = savedIndex, savedFile, savedEmbedder })
+}
+
+func TestSimilarTracks(t *testing.T) {
+	resetCatalog(t)
+	useEmbeddings(t)
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", Title: "Naima", Path: "naima.flac"},
+		{ID: "2", Title: "Alabama", Path: "alabama.flac"},
+		{ID: "3", Title: "Lithium", Path: "lithium.flac"},
+		{ID: "4", Title: "Breed", Path: "breed.flac"},
+		{ID: "5", Title: "Unreadable", Path: "broken.flac"},
+		{ID: "6", Title: "No file"},
+	}
+	vectors := map[string][]float32{
+		"naima.flac":   {1, 0.1, 0},
+		"alabama.flac": {0.9, 0.2, 0},
+		"lithium.flac": {0, 1, 0.9},
+		"breed.flac":   {0, 0.9, 1},
+	}
+	embedder = embedderFunc(func(path string) ([]float32, error) {
+		if v, ok := vectors[path]; ok {
+			return v, nil
+		}
+		return nil, errors.New("cannot decode")
+	})
+
+	// Check tracks with files are embedded, failures reported and the
+	// embeddings published
+	embedded := recordEvents(t, topicTrackEmbedded)
+	if err := embedTracks(context.Background()); err == nil || embeddings.Len() != 4 {
+		t.Errorf("Expected 4 embeddings and an error for the broken file, but got %d and %v", embeddings.Len(), err)
+	}
+	if len(*embedded) != 4 {
+		t.Errorf("Expected 4 published embeddings, but got %d", len(*embedded))
+	}
+
+	// Check neighbours are ranked by similarity
+	rr := performRequest(router, "GET", "/tracks/1/similar?limit=2", nil, "")
+	var similar []similarTrack
+	json.Unmarshal(rr.Body.Bytes(), &similar)
+	if rr.Code != http.StatusOK || len(similar) != 2 || similar[0].Track.ID != "2" || similar[1].Track.ID != "3" || similar[0].Score < 0.9 {
+		t.Errorf("Expected tracks 2 then 3, but got %d %+v", rr.Code, similar)
+	}
+	for path, code := range map[string]int{"/tracks/6/similar": http.StatusNotFound, "/tracks/9/similar": http.StatusNotFound, "/tracks/1/similar?limit=0": http.StatusBadRequest} {
+		if rr = performRequest(router, "GET", path, nil, ""); rr.Code != code {
+			t.Errorf("Expected status code %d for %s, but got %d", code, path, rr.Code)
+		}
+	}
+
+	// Check deleted tracks are dropped and the index survives a restart
+	tracks = tracks[:3]
+	embedTracks(context.Background())
+	embeddings = newVectorIndex()
+	if err := loadEmbeddings(embeddingsFile); err != nil || embeddings.Len() != 3 {
+		t.Fatalf("Expected 3 saved embeddings, but got %d and %v", embeddings.Len(), err)
+	}
+	rr = performRequest(router, "GET", "/tracks/3/similar", nil, "")
+	similar = nil
+	json.Unmarshal(rr.Body.Bytes(), &similar)
+	if len(similar) != 2 || similar[0].Track.ID != "2" {
+		t.Errorf("Expected tracks 2 then 1, but got %+v", similar)
+	}
+}
+
+func TestVectorIndex(t *testing.T) {
+	saved := exactSearchMax
+	exactSearchMax = 0
+	t.Cleanup(func() { exactSearchMax = saved })
+
+	x := newVectorIndex()
+	r := rand.New(rand.NewSource(42))
+	random := func() []float32 {
+		v := make([]float32, 32)
+		for i := range v {
+			v[i] = float32(r.NormFloat64())
+		}
+		return v
+	}
+	for i := 0; i < 1000; i++ {
+		x.Add(strconv.Itoa(i), random())
+	}
+	target := random()
+	near := append([]float32(nil), target...)
+	near[0] += 0.1
+	x.Add("target", target)
+	x.Add("near", near)
+
+	// Check the approximate search finds a close neighbour
+	matches := x.Nearest("target", 5, func(string) bool { return true })
+	if len(matches) == 0 || matches[0].ID != "near" {
+		t.Errorf("Expected near first, but got %+v", matches)
+	}
+
+	// Check replacing and removing keep the buckets in step
+	x.Add("near", random())
+	x.Remove("target")
+	for table, buckets := range x.buckets {
+		for h, ids := range buckets {
+			for _, id := range ids {
+				if id == "target" || id == "near" && x.hash(table, x.vectors["near"]) != h {
+					t.Errorf("Expected %s only in its current bucket, but found it in bucket %d of table %d", id, h, table)
+				}
+			}
+		}
+	}
+
+	// Check mismatched and empty vectors are rejected
+	if err := x.Add("short", []float32{1, 2}); err == nil {
+		t.Errorf("Expected an error for a 2-dimensional embedding, but got none")
+	}
+	if err := x.Add("zero", make([]float32, 32)); err == nil {
+		t.Errorf("Expected an error for a zero embedding, but got none")
+	}
+}
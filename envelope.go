@@ -0,0 +1,73 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envelopeMediaType is the Accept type that asks for list responses wrapped
+// in an envelope. Clients that do not ask get the bare array as before.
+const envelopeMediaType = "application/vnd.music-player.v2+json"
+
+// Context keys for what pageBounds and addWarning record for the envelope.
+const (
+	pageMetaKey = "pageMeta"
+	warningsKey = "warnings"
+)
+
+type pageMeta struct {
+	Total int `json:"total"`
+	// Limit is left out for lists that are not paged.
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset"`
+	// Next is the cursor of the following page, for lists that support
+	// ?after=.
+	Next string `json:"next,omitempty"`
+}
+
+type envelope struct {
+	Data     any      `json:"data"`
+	Meta     pageMeta `json:"meta"`
+	Warnings []string `json:"warnings"`
+}
+
+// wantsEnvelope reports whether the client accepts enveloped responses.
+func wantsEnvelope(c *gin.Context) bool {
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(part); err == nil && mediaType == envelopeMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// addWarning tells the client about something it should change, such as a
+// deprecated parameter. Enveloped responses list it in warnings; others get
+// a Warning header.
+func addWarning(c *gin.Context, msg string) {
+	c.Header("Warning", `299 - "`+strings.ReplaceAll(msg, `"`, `'`)+`"`)
+	c.Set(warningsKey, append(c.GetStringSlice(warningsKey), msg))
+}
+
+// writeList writes a list of n items, in an envelope when the client asked
+// for one.
+func writeList(c *gin.Context, data any, n int) {
+	c.Header("Vary", "Accept")
+	if !wantsEnvelope(c) {
+		c.IndentedJSON(http.StatusOK, data)
+		return
+	}
+	meta := pageMeta{Total: n}
+	if v, ok := c.Get(pageMetaKey); ok {
+		meta = v.(pageMeta)
+	}
+	warnings := c.GetStringSlice(warningsKey)
+	if warnings == nil {
+		warnings = []string{}
+	}
+	c.Header("Content-Type", envelopeMediaType+"; charset=utf-8")
+	c.IndentedJSON(http.StatusOK, envelope{Data: data, Meta: meta, Warnings: warnings})
+}
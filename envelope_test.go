@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", Title: "Naima", Analysis: &trackAnalysis{BPM: 60}},
+		{ID: "2", Title: "Giant Steps", Analysis: &trackAnalysis{BPM: 290}},
+		{ID: "3", Title: "Countdown", Analysis: &trackAnalysis{BPM: 320}},
+	}
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Check clients that do not ask still get a bare array
+	rr := get("/tracks?limit=1", "application/json")
+	if !strings.HasPrefix(strings.TrimSpace(rr.Body.String()), "[") {
+		t.Errorf("Expected a bare array, but got %s", rr.Body.String())
+	}
+
+	// Check the envelope carries the page
+	rr = get("/tracks?limit=1&offset=1", "application/json, "+envelopeMediaType)
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), envelopeMediaType) {
+		t.Errorf("Expected content type %s, but got %q", envelopeMediaType, rr.Header().Get("Content-Type"))
+	}
+	var env struct {
+		Data     []track
+		Meta     pageMeta
+		Warnings []string
+	}
+	json.Unmarshal(rr.Body.Bytes(), &env)
+	if len(env.Data) != 1 || env.Data[0].ID != "2" {
+		t.Errorf("Expected track 2 as data, but got %+v", env.Data)
+	}
+	if env.Meta != (pageMeta{Total: 3, Limit: 1, Offset: 1, Next: "2"}) {
+		t.Errorf("Expected total 3, limit 1, offset 1 and next 2, but got %+v", env.Meta)
+	}
+	if env.Warnings == nil || len(env.Warnings) != 0 {
+		t.Errorf("Expected an empty list of warnings, but got %v", env.Warnings)
+	}
+
+	// Check deprecated parameters are warned about in both forms
+	rr = get("/tracks?bpmMin=300", envelopeMediaType)
+	env.Warnings = nil
+	json.Unmarshal(rr.Body.Bytes(), &env)
+	if len(env.Warnings) != 1 || env.Warnings[0] != "bpmMin is deprecated, use q=bpm>=300 instead" {
+		t.Errorf("Expected a deprecation warning, but got %v", env.Warnings)
+	}
+	rr = get("/tracks?bpmMin=300", "")
+	if !strings.Contains(rr.Header().Get("Warning"), "bpmMin is deprecated") {
+		t.Errorf("Expected a Warning header, but got %q", rr.Header().Get("Warning"))
+	}
+
+	// Check unpaged lists report their length and no limit
+	rr = get("/artists", envelopeMediaType)
+	var artists struct{ Meta map[string]int }
+	json.Unmarshal(rr.Body.Bytes(), &artists)
+	if _, ok := artists.Meta["limit"]; ok || artists.Meta["total"] != 3 {
+		t.Errorf("Expected a total of 3 and no limit, but got %v", artists.Meta)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eqBands are the centre frequencies in Hz of the ten equalizer bands.
+var eqBands = [10]int{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// maxEQGain bounds every gain and the preamp, in dB.
+const maxEQGain = 12.0
+
+const defaultOutput = "default"
+
+type eqPreset struct {
+	Name    string      `json:"name"`
+	Preamp  float64     `json:"preamp"`
+	Gains   [10]float64 `json:"gains"`
+	BuiltIn bool        `json:"builtIn,omitempty"`
+}
+
+var builtInEQPresets = []eqPreset{
+	{Name: "flat", BuiltIn: true},
+	{Name: "bass-boost", Preamp: -4, Gains: [10]float64{6, 5, 4, 2, 0, 0, 0, 0, 0, 0}, BuiltIn: true},
+	{Name: "vocal", Preamp: -2, Gains: [10]float64{-2, -2, -1, 0, 2, 3, 3, 2, 0, -1}, BuiltIn: true},
+}
+
+type userEQ struct {
+	Presets map[string]eqPreset `json:"presets"`
+	// Active maps output names to the preset applied on that output.
+	Active map[string]string `json:"active"`
+}
+
+var (
+	eqMu       sync.Mutex
+	eqSettings = map[string]*userEQ{}
+)
+
+// userEQSettings returns the settings of user, creating them if needed.
+// Callers must hold eqMu.
+func userEQSettings(user string) *userEQ {
+	if eqSettings[user] == nil {
+		eqSettings[user] = &userEQ{Presets: map[string]eqPreset{}, Active: map[string]string{}}
+	}
+	return eqSettings[user]
+}
+
+// findEQPreset looks a preset up among the user's presets and the built-in
+// ones. Callers must hold eqMu.
+func findEQPreset(settings *userEQ, name string) (eqPreset, bool) {
+	if p, ok := settings.Presets[name]; ok {
+		return p, true
+	}
+	for _, p := range builtInEQPresets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return eqPreset{}, false
+}
+
+// activeEQPreset returns the preset the playback engine should apply for
+// user on output, falling back to the default output and then to flat.
+func activeEQPreset(user, output string) eqPreset {
+	eqMu.Lock()
+	defer eqMu.Unlock()
+
+	settings := userEQSettings(user)
+	for _, o := range []string{output, defaultOutput} {
+		if p, ok := findEQPreset(settings, settings.Active[o]); ok {
+			return p
+		}
+	}
+	return builtInEQPresets[0]
+}
+
+func getEQ(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	eqMu.Lock()
+	defer eqMu.Unlock()
+
+	settings := userEQSettings(user)
+	presets := append([]eqPreset(nil), builtInEQPresets...)
+	var own []eqPreset
+	for _, p := range settings.Presets {
+		own = append(own, p)
+	}
+	sort.Slice(own, func(i, j int) bool { return own[i].Name < own[j].Name })
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"bands":   eqBands,
+		"presets": append(presets, own...),
+		"active":  settings.Active,
+	})
+}
+
+// putEQPreset saves one of the user's presets. Built-in presets cannot be
+// overwritten.
+func putEQPreset(c *gin.Context) {
+	var preset eqPreset
+
+	if err := c.BindJSON(&preset); err != nil {
+		return
+	}
+	preset.Name = c.Param("name")
+	preset.BuiltIn = false
+	for _, p := range builtInEQPresets {
+		if p.Name == preset.Name {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "built-in presets cannot be changed"})
+			return
+		}
+	}
+	if !withinEQRange(preset.Preamp) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "preamp must be between -12 and 12 dB"})
+		return
+	}
+	for _, g := range preset.Gains {
+		if !withinEQRange(g) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "gains must be between -12 and 12 dB"})
+			return
+		}
+	}
+
+	eqMu.Lock()
+	userEQSettings(c.GetString(gin.AuthUserKey)).Presets[preset.Name] = preset
+	eqMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, preset)
+}
+
+// deleteEQPreset removes one of the user's presets and deactivates it on
+// every output.
+func deleteEQPreset(c *gin.Context) {
+	name := c.Param("name")
+
+	eqMu.Lock()
+	defer eqMu.Unlock()
+
+	settings := userEQSettings(c.GetString(gin.AuthUserKey))
+	if _, ok := settings.Presets[name]; !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "preset not found"})
+		return
+	}
+	delete(settings.Presets, name)
+	for output, active := range settings.Active {
+		if active == name {
+			delete(settings.Active, output)
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// putActiveEQ selects the preset applied on an output; the output defaults
+// to "default".
+func putActiveEQ(c *gin.Context) {
+	var input struct {
+		Output string `json:"output"`
+		Preset string `json:"preset"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Output == "" {
+		input.Output = defaultOutput
+	}
+
+	eqMu.Lock()
+	defer eqMu.Unlock()
+
+	settings := userEQSettings(c.GetString(gin.AuthUserKey))
+	preset, ok := findEQPreset(settings, input.Preset)
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "preset not found"})
+		return
+	}
+	settings.Active[input.Output] = preset.Name
+	c.IndentedJSON(http.StatusOK, gin.H{"output": input.Output, "preset": preset})
+}
+
+func withinEQRange(db float64) bool {
+	return db >= -maxEQGain && db <= maxEQGain
+}
+
+func dumpEQSettings() ([]byte, error) {
+	eqMu.Lock()
+	defer eqMu.Unlock()
+
+	return json.MarshalIndent(eqSettings, "", "    ")
+}
+
+func restoreEQSettings(data []byte) (func(), int, error) {
+	var restored map[string]*userEQ
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	for user, settings := range restored {
+		if settings == nil {
+			settings = &userEQ{}
+			restored[user] = settings
+		}
+		if settings.Presets == nil {
+			settings.Presets = map[string]eqPreset{}
+		}
+		if settings.Active == nil {
+			settings.Active = map[string]string{}
+		}
+	}
+	apply := func() {
+		eqMu.Lock()
+		eqSettings = restored
+		eqMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
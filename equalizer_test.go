@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Users save presets and activate them per output
+func TestEQPresets(t *testing.T) {
+	saved := eqSettings
+	eqSettings = map[string]*userEQ{}
+	t.Cleanup(func() { eqSettings = saved })
+	router := setupRouter(testConfig())
+
+	// Check gains outside ±12 dB and built-in names are refused
+	if rr := performRequest(router, "PUT", "/player/eq/presets/loud", strings.NewReader(`{"gains": [20,0,0,0,0,0,0,0,0,0]}`), "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/player/eq/presets/flat", strings.NewReader(`{}`), "bob"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Save a preset and use it in the kitchen, with bass boost everywhere else
+	performRequest(router, "PUT", "/player/eq/presets/kitchen", strings.NewReader(`{"preamp": -3, "gains": [0,0,0,0,0,2,3,3,2,1]}`), "bob")
+	performRequest(router, "PUT", "/player/eq/active", strings.NewReader(`{"output": "kitchen", "preset": "kitchen"}`), "bob")
+	performRequest(router, "PUT", "/player/eq/active", strings.NewReader(`{"preset": "bass-boost"}`), "bob")
+
+	// Check the engine sees the right preset per output and per user
+	if p := activeEQPreset("bob", "kitchen"); p.Name != "kitchen" || p.Preamp != -3 {
+		t.Errorf("Expected the kitchen preset, but got %+v", p)
+	}
+	if p := activeEQPreset("bob", "bedroom"); p.Name != "bass-boost" {
+		t.Errorf("Expected bass-boost on other outputs, but got %+v", p)
+	}
+	if p := activeEQPreset("alice", "kitchen"); p.Name != "flat" {
+		t.Errorf("Expected flat for a user without settings, but got %+v", p)
+	}
+
+	// Delete the preset and check the kitchen falls back to the default output
+	performRequest(router, "DELETE", "/player/eq/presets/kitchen", nil, "bob")
+	if p := activeEQPreset("bob", "kitchen"); p.Name != "bass-boost" {
+		t.Errorf("Expected bass-boost after deleting kitchen, but got %+v", p)
+	}
+}
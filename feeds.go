@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedSize is how many albums a feed lists.
+const feedSize = 50
+
+// feedItem is an album in a feed, with its cover file when it has one.
+type feedItem struct {
+	addedAlbum
+	cover     string
+	coverSize int64
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description,omitempty"`
+	Category    string        `xml:"category,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID       string        `xml:"id"`
+	Title    string        `xml:"title"`
+	Updated  string        `xml:"updated"`
+	Author   *atomAuthor   `xml:"author"`
+	Summary  string        `xml:"summary,omitempty"`
+	Category *atomCategory `xml:"category"`
+	Links    []atomLink    `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// feedAlbums returns the newest albums, of genre when it is not empty,
+// with their cover files.
+func feedAlbums(genre string) []feedItem {
+	albumsMu.RLock()
+	var items []feedItem
+	for _, added := range recentlyAdded() {
+		if len(items) == feedSize {
+			break
+		}
+		if genre == "" || strings.EqualFold(added.Album.Genre, genre) {
+			items = append(items, feedItem{addedAlbum: added, cover: albumCoverFile(added.Album.ID)})
+		}
+	}
+	albumsMu.RUnlock()
+
+	// Enclosures need the size of the cover.
+	for i, item := range items {
+		if info, err := os.Stat(item.cover); item.cover != "" && err == nil {
+			items[i].coverSize = info.Size()
+		} else {
+			items[i].cover = ""
+		}
+	}
+	return items
+}
+
+func (item feedItem) title() string {
+	if item.Album.Artist == "" {
+		return item.Album.Title
+	}
+	return item.Album.Artist + " – " + item.Album.Title
+}
+
+func (item feedItem) summary() string {
+	var parts []string
+	if item.Album.Year != 0 {
+		parts = append(parts, strconv.Itoa(item.Album.Year))
+	}
+	if item.Album.Genre != "" {
+		parts = append(parts, item.Album.Genre)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (item feedItem) coverType() string {
+	if t := mime.TypeByExtension(filepath.Ext(item.cover)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// feedTitle names the feed of genre.
+func feedTitle(genre string) string {
+	if genre == "" {
+		return "New albums"
+	}
+	return "New " + genre + " albums"
+}
+
+// writeFeed sends a feed with caching headers that let readers poll it
+// cheaply.
+func writeFeed(c *gin.Context, contentType string, feed any) {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, contentType, append([]byte(xml.Header), data...))
+}
+
+// getNewAlbumsRSS publishes recently added albums as an RSS feed, with
+// their covers as enclosures; under /feeds/genres/:genre, only those of
+// one genre.
+func getNewAlbumsRSS(c *gin.Context) {
+	genre := c.Param("genre")
+	items := feedAlbums(genre)
+	feed := rssFeed{Version: "2.0", Atom: "http://www.w3.org/2005/Atom", Channel: rssChannel{
+		Title:       feedTitle(genre),
+		Link:        absoluteURL(c, "/browse/recently-added"),
+		Self:        atomLink{Href: absoluteURL(c, c.Request.URL.Path), Rel: "self", Type: "application/rss+xml"},
+		Description: "Albums recently added to the library",
+		Items:       []rssItem{},
+	}}
+	if len(items) > 0 {
+		feed.Channel.LastBuildDate = items[0].AddedAt.UTC().Format(time.RFC1123Z)
+	}
+	for _, item := range items {
+		link := absoluteURL(c, "/albums/"+item.Album.ID)
+		entry := rssItem{Title: item.title(), Link: link, GUID: link, PubDate: item.AddedAt.UTC().Format(time.RFC1123Z), Description: item.summary(), Category: item.Album.Genre}
+		if item.cover != "" {
+			entry.Enclosure = &rssEnclosure{URL: absoluteURL(c, "/albums/"+item.Album.ID+"/cover"), Length: item.coverSize, Type: item.coverType()}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, entry)
+	}
+	writeFeed(c, "application/rss+xml; charset=utf-8", feed)
+}
+
+// getNewAlbumsAtom publishes the same albums as getNewAlbumsRSS as an Atom
+// feed.
+func getNewAlbumsAtom(c *gin.Context) {
+	genre := c.Param("genre")
+	items := feedAlbums(genre)
+	self := absoluteURL(c, c.Request.URL.Path)
+	feed := atomFeed{
+		ID:      self,
+		Title:   feedTitle(genre),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Href: self, Rel: "self", Type: "application/atom+xml"}},
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].AddedAt.UTC().Format(time.RFC3339)
+	}
+	for _, item := range items {
+		link := absoluteURL(c, "/albums/"+item.Album.ID)
+		entry := atomEntry{ID: link, Title: item.title(), Updated: item.AddedAt.UTC().Format(time.RFC3339), Summary: item.summary(), Links: []atomLink{{Href: link, Rel: "alternate"}}}
+		if item.Album.Artist != "" {
+			entry.Author = &atomAuthor{Name: item.Album.Artist}
+		}
+		if item.Album.Genre != "" {
+			entry.Category = &atomCategory{Term: item.Album.Genre}
+		}
+		if item.cover != "" {
+			entry.Links = append(entry.Links, atomLink{Href: absoluteURL(c, "/albums/"+item.Album.ID+"/cover"), Rel: "enclosure", Type: item.coverType(), Length: item.coverSize})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeFeed(c, "application/atom+xml; charset=utf-8", feed)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAlbumFeeds(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("jpeg"), 0o644)
+	older, newer := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz", Year: 1957},
+		{ID: "2", Title: "Nevermind", Artist: "Nirvana", Genre: "Grunge"},
+		{ID: "3", Title: "Undated"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Path: filepath.Join(dir, "01.flac"), AddedAt: &older},
+		{ID: "2", AlbumID: "2", AddedAt: &newer},
+		{ID: "3", AlbumID: "3"},
+	}
+
+	// Check the RSS feed lists added albums newest first, with cover enclosures
+	rr := performRequest(router, "GET", "/feeds/new-albums.xml", nil, "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/rss+xml") {
+		t.Fatalf("Expected an RSS feed, but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Expected valid XML, but got %v: %s", err, rr.Body)
+	}
+	items := rss.Channel.Items
+	if len(items) != 2 || items[0].Title != "Nirvana – Nevermind" || items[1].Title != "John Coltrane – Blue Train" {
+		t.Fatalf("Expected the two added albums newest first, but got %+v", items)
+	}
+	if e := items[1].Enclosure; e == nil || !strings.HasSuffix(e.URL, "/albums/1/cover") || e.Length != 4 || e.Type != "image/jpeg" {
+		t.Errorf("Expected the cover as an enclosure, but got %+v", e)
+	}
+	if items[0].Enclosure != nil {
+		t.Errorf("Expected no enclosure without a cover, but got %+v", items[0].Enclosure)
+	}
+	if !strings.Contains(rr.Body.String(), `<atom:link href="`) {
+		t.Errorf("Expected a self link, but got %s", rr.Body)
+	}
+
+	// Check the per-genre Atom feed only has that genre
+	rr = performRequest(router, "GET", "/feeds/genres/jazz/new-albums.atom", nil, "")
+	var atom atomFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Expected valid XML, but got %v: %s", err, rr.Body)
+	}
+	if atom.Title != "New jazz albums" || len(atom.Entries) != 1 || atom.Entries[0].Updated != "2024-01-01T00:00:00Z" {
+		t.Errorf("Expected the jazz album alone, but got %+v", atom)
+	}
+	if links := atom.Entries[0].Links; len(links) != 2 || links[1].Rel != "enclosure" {
+		t.Errorf("Expected an enclosure link, but got %+v", links)
+	}
+}
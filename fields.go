@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// customField describes a key admins allow in an album's fields map.
+type customField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+var fieldTypes = map[string]bool{"string": true, "number": true, "bool": true, "date": true}
+
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// fieldSchema is keyed by field name and guarded by albumsMu.
+var fieldSchema = map[string]customField{}
+
+// validateFields checks values against the schema. Dates must be written as
+// YYYY-MM-DD. Callers must hold albumsMu.
+func validateFields(fields map[string]any) error {
+	for name, value := range fields {
+		field, ok := fieldSchema[name]
+		if !ok {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		valid := false
+		switch v := value.(type) {
+		case string:
+			if field.Type == "date" {
+				_, err := time.Parse(time.DateOnly, v)
+				valid = err == nil
+			} else {
+				valid = field.Type == "string"
+			}
+		case float64:
+			valid = field.Type == "number"
+		case bool:
+			valid = field.Type == "bool"
+		}
+		if !valid {
+			return fmt.Errorf("field %q must be a %s", name, field.Type)
+		}
+	}
+	return nil
+}
+
+// fieldMatches compares a stored field value with a query string value.
+func fieldMatches(value any, want string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == want
+	case float64:
+		n, err := strconv.ParseFloat(want, 64)
+		return err == nil && n == v
+	case bool:
+		b, err := strconv.ParseBool(want)
+		return err == nil && b == v
+	}
+	return false
+}
+
+func getFields(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	fields := []customField{}
+	for _, f := range fieldSchema {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	c.IndentedJSON(http.StatusOK, fields)
+}
+
+// putField defines or redefines a custom field. Changing the type of a field
+// that albums already use is refused.
+func putField(c *gin.Context) {
+	var field customField
+
+	if err := c.BindJSON(&field); err != nil {
+		return
+	}
+	field.Name = c.Param("name")
+	if !fieldNamePattern.MatchString(field.Name) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "field names must be lower-case snake_case"})
+		return
+	}
+	if !fieldTypes[field.Type] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "type must be one of string, number, bool or date"})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if old, ok := fieldSchema[field.Name]; ok && old.Type != field.Type {
+		for _, a := range albums {
+			if _, used := a.Fields[field.Name]; used {
+				c.IndentedJSON(http.StatusConflict, gin.H{"message": "field is in use; remove its values before changing its type"})
+				return
+			}
+		}
+	}
+	fieldSchema[field.Name] = field
+	c.IndentedJSON(http.StatusOK, field)
+}
+
+// deleteField removes a custom field and its values from every album.
+func deleteField(c *gin.Context) {
+	name := c.Param("name")
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if _, ok := fieldSchema[name]; !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "field not found"})
+		return
+	}
+	delete(fieldSchema, name)
+	for i := range albums {
+		if _, used := albums[i].Fields[name]; used {
+			albums[i].Fields = copyFields(albums[i].Fields)
+			delete(albums[i].Fields, name)
+		}
+	}
+	publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	c.Status(http.StatusNoContent)
+}
+
+func copyFields(fields map[string]any) map[string]any {
+	copied := make(map[string]any, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	return copied
+}
+
+func dumpFieldSchema() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(fieldSchema, "", "    ")
+}
+
+func restoreFieldSchema(data []byte) (func(), int, error) {
+	var restored map[string]customField
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	for name, f := range restored {
+		if f.Name != name || !fieldTypes[f.Type] {
+			return nil, 0, fmt.Errorf("invalid field %q", name)
+		}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		fieldSchema = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Custom fields are validated against the schema and can be filtered on
+func TestCustomFields(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Define two fields as an admin
+	performRequest(router, "PUT", "/admin/fields/catalog_number", strings.NewReader(`{"type": "string"}`), "alice")
+	performRequest(router, "PUT", "/admin/fields/purchased", strings.NewReader(`{"type": "date"}`), "alice")
+
+	// Check bad names, types and unknown or mistyped values are refused
+	if rr := performRequest(router, "PUT", "/admin/fields/Bad-Name", strings.NewReader(`{"type": "string"}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"fields": {"label": "Blue Note"}}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"fields": {"purchased": "last week"}}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Set valid values on album 1 and create album 4 with its own
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"fields": {"catalog_number": "BLP 1577", "purchased": "2021-05-01"}}`), "alice")
+	rr := performRequest(router, "POST", "/albums", strings.NewReader(`{"title": "Moanin'", "fields": {"catalog_number": "BLP 4003"}}`), "")
+	var created album
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	// Check filtering by a field value finds only album 1
+	rr = performRequest(router, "GET", "/albums?field.catalog_number=BLP+1577", nil, "")
+	var listed []album
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "1" {
+		t.Errorf("Expected only album 1, but got %v", listed)
+	}
+
+	// Check a repeated field must match every value, which no album does
+	rr = performRequest(router, "GET", "/albums?field.catalog_number=BLP+1577&field.catalog_number=BLP+4003", nil, "")
+	listed = nil
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 0 {
+		t.Errorf("Expected no albums, but got %v", listed)
+	}
+
+	// Check a null value removes the field
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"fields": {"purchased": null}}`), "alice")
+	if _, ok := albums[findAlbum("1")].Fields["purchased"]; ok {
+		t.Errorf("Expected purchased to be removed from album 1")
+	}
+
+	// Check deleting the field definition strips its values
+	performRequest(router, "DELETE", "/admin/fields/catalog_number", nil, "alice")
+	if len(albums[findAlbum(created.ID)].Fields) != 0 {
+		t.Errorf("Expected album 4 to have no fields, but got %v", albums[findAlbum(created.ID)].Fields)
+	}
+}
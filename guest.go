@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// guestKey marks requests made by guests in the gin context.
+const guestKey = "guest"
+
+// guestRoutes are the routes guests may use, with GET and HEAD only. The
+// catalog routes are limited to the public albums; the others check a
+// signature, token or password of their own.
+var guestRoutes = map[string]bool{
+	"/readyz":                          true,
+	"/albums":                          true,
+	"/albums/:id":                      true,
+	"/albums/slug/:slug":               true,
+	"/albums/:id/tracks":               true,
+	"/albums/:id/cover":                true,
+	"/tracks/:id":                      true,
+	"/tracks/:id/stream":               true,
+	"/browse/recently-added":           true,
+	"/s/:token":                        true,
+	"/s/:token/tracks/:trackId/stream": true,
+	"/s/:token/cover":                  true,
+	"/embed/:trackId":                  true,
+	"/embed/:trackId/stream":           true,
+	"/downloads/tracks/:id":            true,
+	"/feeds/wishlist.ics":              true,
+	"/discogs/callback":                true,
+	"/.well-known/webfinger":           true,
+	"/ap/actors/:name":                 true,
+	"/ap/actors/:name/outbox":          true,
+	"/ap/actors/:name/notes/:id":       true,
+	"/ap/actors/:name/followers":       true,
+}
+
+// guestPosts are routes guests may also POST to: entering a share's
+// password, and deliveries from other ActivityPub servers, which are
+// signed.
+var guestPosts = map[string]bool{
+	"/s/:token":              true,
+	"/ap/actors/:name/inbox": true,
+}
+
+func isGuest(c *gin.Context) bool {
+	return c.GetBool(guestKey)
+}
+
+// visibleTo reports whether the requester may see an album: guests only
+// see albums tagged with the guest tag.
+func visibleTo(c *gin.Context, a album) bool {
+	return !isGuest(c) || hasTag(a.Tags, guestTag)
+}
+
+// guestTag is the tag that puts albums in the public subset guests see;
+// only admins may add or remove it while guest access is on.
+var guestTag string
+
+// guestAccess turns requests without credentials into guests when guest
+// access is configured: they may only browse and stream the public albums,
+// within GuestRateLimit. Guests have no user, so nothing they play is
+// recorded. Without guest access, such requests are let through as before.
+func guestAccess(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.GuestAccess || c.GetString(gin.AuthUserKey) != "" {
+			c.Next()
+			return
+		}
+		c.Set(guestKey, true)
+
+		route := c.FullPath()
+		allowed := (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) && guestRoutes[route] ||
+			c.Request.Method == http.MethodPost && guestPosts[route]
+		if !allowed {
+			c.Header("WWW-Authenticate", `Basic realm="go-music-player"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "authentication required"})
+			return
+		}
+		if !withinRateLimit(c, "ratelimit:guest:", cfg.GuestRateLimit, cfg.RateLimitWindow) {
+			return
+		}
+		if hidden := guestHidden(c); hidden != "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": hidden + " not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// guestHidden checks the album or track a catalog or widget route names
+// is public, and otherwise returns which of the two it is.
+func guestHidden(c *gin.Context) string {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	switch c.FullPath() {
+	case "/albums/:id", "/albums/:id/tracks", "/albums/:id/cover":
+		if i := findAlbum(c.Param("id")); i >= 0 && !visibleTo(c, albums[i]) {
+			return "album"
+		}
+	case "/albums/slug/:slug":
+		// Stale slugs redirect to the current one, which is checked then.
+		for _, a := range albums {
+			if a.Slug == c.Param("slug") && !visibleTo(c, a) {
+				return "album"
+			}
+		}
+	case "/tracks/:id", "/tracks/:id/stream":
+		return trackHidden(c, c.Param("id"))
+	case "/embed/:trackId", "/embed/:trackId/stream":
+		// A widget hands out a signed stream URL, so it is held to the
+		// same subset as the track itself.
+		return trackHidden(c, c.Param("trackId"))
+	}
+	return ""
+}
+
+// trackHidden returns "track" when the track with the given id is on an
+// album the requester may not see. Callers must hold albumsMu.
+func trackHidden(c *gin.Context, id string) string {
+	if i := findTrack(id); i >= 0 {
+		if j := findAlbum(tracks[i].AlbumID); j < 0 || !visibleTo(c, albums[j]) {
+			return "track"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuestAccess(t *testing.T) {
+	resetCatalog(t)
+	cfg := testConfig()
+	cfg.GuestAccess, cfg.GuestRateLimit, cfg.RateLimitWindow, cfg.CacheTTL = true, 12, time.Minute, time.Minute
+	router := setupRouter(cfg)
+	invalidateResponses(context.Background())
+	savedTag, savedAdmins := guestTag, adminUsers
+	guestTag, adminUsers = "public", []string{"alice"}
+	t.Cleanup(func() { guestTag, adminUsers = savedTag, savedAdmins })
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Tags: []string{"public"}},
+		{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan"},
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"1.mp3", "2.mp3"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("ID3 audio"), 0o644)
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Blue Train", Path: filepath.Join(dir, "1.mp3")},
+		{ID: "2", AlbumID: "2", Title: "Godchild", Path: filepath.Join(dir, "2.mp3")},
+	}
+
+	// Check users see the whole catalog and guests only the public albums, even from the cache
+	var listed []album
+	rr := performRequest(router, "GET", "/albums", nil, "bob")
+	if json.Unmarshal(rr.Body.Bytes(), &listed); len(listed) != 2 {
+		t.Errorf("Expected 2 albums for bob, but got %d", len(listed))
+	}
+	rr = performRequest(router, "GET", "/albums", nil, "")
+	if json.Unmarshal(rr.Body.Bytes(), &listed); len(listed) != 1 || listed[0].ID != "1" {
+		t.Errorf("Expected only the public album for guests, but got %+v", listed)
+	}
+
+	// Check guests cannot open private albums or their tracks, not even
+	// through the embeddable player and its signed stream
+	expires := time.Now().Add(time.Hour)
+	for path, want := range map[string]int{
+		"/albums/1":                        http.StatusOK,
+		"/albums/2":                        http.StatusNotFound,
+		"/albums/2/tracks":                 http.StatusNotFound,
+		"/tracks/1":                        http.StatusOK,
+		"/tracks/2":                        http.StatusNotFound,
+		"/tracks/2/stream":                 http.StatusNotFound,
+		"/embed/1":                         http.StatusOK,
+		"/embed/2":                         http.StatusNotFound,
+		signedEmbedStreamURL("1", expires): http.StatusOK,
+		signedEmbedStreamURL("2", expires): http.StatusNotFound,
+	} {
+		if rr := performRequest(router, "GET", path, nil, ""); rr.Code != want {
+			t.Errorf("Expected status code %d for %s, but got %d", want, path, rr.Code)
+		}
+	}
+
+	// Check guests cannot change anything or reach personal routes
+	for _, req := range []struct{ method, path string }{{"POST", "/albums"}, {"PATCH", "/albums/1"}, {"GET", "/playlists"}, {"GET", "/search"}, {"PUT", "/player/play"}} {
+		if rr := performRequest(router, req.method, req.path, strings.NewReader(`{}`), ""); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d for %s %s, but got %d", http.StatusUnauthorized, req.method, req.path, rr.Code)
+		}
+	}
+
+	// Check only admins put albums in the public subset
+	if rr := performRequest(router, "PUT", "/albums/2/tags/public", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/albums/2/tags/public", nil, "alice"); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/albums/2", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d once public, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check guests run out of requests before users do
+	for i := 0; i < cfg.GuestRateLimit; i++ {
+		performRequest(router, "GET", "/albums/1", nil, "")
+	}
+	if rr := performRequest(router, "GET", "/albums/1", nil, ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/albums/1", nil, "bob"); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d for bob, but got %d", http.StatusOK, rr.Code)
+	}
+}
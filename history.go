@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxListens bounds the history kept in full per user; older listens are
+// rolled up into daily aggregates, see listen_retention.go.
+const maxListens = 5000
+
+type listen struct {
+	TrackID  string    `json:"trackId"`
+	PlayedAt time.Time `json:"playedAt"`
+}
+
+// listens holds each user's listening history, oldest first. It is guarded
+// by albumsMu.
+var listens = map[string][]listen{}
+
+// recordListen adds a play to a user's history. Plays by nobody in
+// particular, such as the player running before anyone controlled it, are
+// not recorded. Callers must hold albumsMu.
+func recordListen(user, trackID string, at time.Time) {
+	if user == "" {
+		return
+	}
+	history := append(listens[user], listen{TrackID: trackID, PlayedAt: at.UTC()})
+	if len(history) > maxListens {
+		rollUpListens(user, history[:len(history)-maxListens])
+		history = append([]listen(nil), history[len(history)-maxListens:]...)
+	}
+	listens[user] = history
+	apPublishListen(user, trackID, at)
+}
+
+// claimPlayer makes the requesting user the listener of the server player,
+// so the tracks it plays go into their history. Reads do not claim it. A
+// new listener starts a new session, so private listening ends.
+func claimPlayer(c *gin.Context) {
+	if c.Request.Method != http.MethodGet {
+		user := c.GetString(gin.AuthUserKey)
+		musicPlayer.mu.Lock()
+		if musicPlayer.listener != user {
+			musicPlayer.private = false
+		}
+		musicPlayer.listener = user
+		musicPlayer.mu.Unlock()
+	}
+	c.Next()
+}
+
+// SetPrivate turns private listening on or off. While it is on, tracks
+// played to their end are neither added to the listener's history nor
+// counted.
+func (p *player) SetPrivate(private bool) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	p.private = private
+	p.touch()
+	return p.status()
+}
+
+func getPrivateListening(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"enabled": musicPlayer.private})
+}
+
+func putPrivateListening(c *gin.Context) {
+	var input struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Enabled == nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "enabled is required"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetPrivate(*input.Enabled))
+}
+
+// postListen records a play reported by a client that plays audio itself.
+func postListen(c *gin.Context) {
+	var input listen
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	now := time.Now()
+	if input.PlayedAt.IsZero() || input.PlayedAt.After(now) {
+		input.PlayedAt = now
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if findTrack(input.TrackID) < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	playCounts[input.TrackID]++
+	recordListen(c.GetString(gin.AuthUserKey), input.TrackID, input.PlayedAt)
+	firePlayed(c.GetString(gin.AuthUserKey), input.TrackID, input.PlayedAt)
+	c.IndentedJSON(http.StatusCreated, listen{TrackID: input.TrackID, PlayedAt: input.PlayedAt.UTC()})
+}
+
+func dumpListens() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(listens, "", "    ")
+}
+
+func restoreListens(data []byte) (func(), int, error) {
+	var restored map[string][]listen
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string][]listen{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		listens = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
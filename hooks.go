@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hooks let extensions such as scrobblers or analytics follow what happens
+// on the server without changing it. Compiled-in extensions register
+// handlers from init functions with onTrackPlayed and the like; programs
+// in the plugins directory subscribe to the events by name, see
+// hookPlugin.
+const (
+	hookTrackPlayed  = "track.played"
+	hookAlbumCreated = "album.created"
+	hookScanComplete = "scan.complete"
+	hookRequest      = "request.completed"
+)
+
+var hookEvents = map[string]bool{hookTrackPlayed: true, hookAlbumCreated: true, hookScanComplete: true, hookRequest: true}
+
+const (
+	// maxQueuedHooks bounds the handler calls waiting to run; more are
+	// dropped rather than holding up requests.
+	maxQueuedHooks = 1024
+	// hookTimeout bounds each run of a plugin program.
+	hookTimeout = 30 * time.Second
+)
+
+// trackPlay is the payload of track.played: a track played to its end by
+// the server player, or reported played by a client. User is empty for
+// plays by nobody in particular.
+type trackPlay struct {
+	User     string    `json:"user,omitempty"`
+	Track    track     `json:"track"`
+	PlayedAt time.Time `json:"playedAt"`
+}
+
+// requestInfo is the payload of request.completed.
+type requestInfo struct {
+	Method string `json:"method"`
+	// Route is the pattern the request matched, such as /albums/:id, or
+	// "" when it matched none.
+	Route    string  `json:"route"`
+	Path     string  `json:"path"`
+	Status   int     `json:"status"`
+	User     string  `json:"user,omitempty"`
+	Duration float64 `json:"durationMs"`
+}
+
+var (
+	hooksMu      sync.RWMutex
+	hookHandlers = map[string][]func(payload any){}
+	// extraMiddleware runs on every request after the caller is
+	// identified and before the route's own handlers.
+	extraMiddleware []gin.HandlerFunc
+
+	hookQueue  = make(chan func(), maxQueuedHooks)
+	startHooks sync.Once
+)
+
+func addHook(event string, handler func(payload any)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	hookHandlers[event] = append(hookHandlers[event], handler)
+}
+
+// onTrackPlayed calls fn after every play.
+func onTrackPlayed(fn func(trackPlay)) {
+	addHook(hookTrackPlayed, func(payload any) { fn(payload.(trackPlay)) })
+}
+
+// onAlbumCreated calls fn after an album is added, by hand or by an import.
+func onAlbumCreated(fn func(album)) {
+	addHook(hookAlbumCreated, func(payload any) { fn(payload.(album)) })
+}
+
+// onScanComplete calls fn after a batch of scanned tracks is imported.
+func onScanComplete(fn func(importResult)) {
+	addHook(hookScanComplete, func(payload any) { fn(payload.(importResult)) })
+}
+
+// onRequest calls fn after every request.
+func onRequest(fn func(requestInfo)) {
+	addHook(hookRequest, func(payload any) { fn(payload.(requestInfo)) })
+}
+
+// useMiddleware adds handlers to every route. Unlike hooks they run on
+// the request, so they can reject it or change the response; they must be
+// added before the router is set up.
+func useMiddleware(handlers ...gin.HandlerFunc) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	extraMiddleware = append(extraMiddleware, handlers...)
+}
+
+// fireHook queues the handlers of event. They run one at a time, in the
+// order they were fired, off the caller's goroutine, so it may hold
+// albumsMu.
+func fireHook(event string, payload any) {
+	hooksMu.RLock()
+	handlers := hookHandlers[event]
+	hooksMu.RUnlock()
+
+	for _, h := range handlers {
+		h := h
+		queueHook(event, func() { h(payload) })
+	}
+}
+
+func queueHook(event string, run func()) {
+	startHooks.Do(func() { go runHooks() })
+	select {
+	case hookQueue <- run:
+	default:
+		log.Printf("hooks: queue full, dropping a %s handler", event)
+	}
+}
+
+func runHooks() {
+	for run := range hookQueue {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("hooks: handler panicked: %v", err)
+				}
+			}()
+			run()
+		}()
+	}
+}
+
+// firePlayed fires track.played for the track with the given id, and
+// sends it out through the outbox. Callers must hold albumsMu.
+func firePlayed(user, id string, at time.Time) {
+	if i := findTrack(id); i >= 0 {
+		play := trackPlay{User: user, Track: tracks[i], PlayedAt: at.UTC()}
+		fireHook(hookTrackPlayed, play)
+		outbox.add(hookTrackPlayed, play)
+	}
+}
+
+// requestHooks fires request.completed once the response is written.
+func requestHooks(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	fireHook(hookRequest, requestInfo{
+		Method:   c.Request.Method,
+		Route:    c.FullPath(),
+		Path:     c.Request.URL.Path,
+		Status:   c.Writer.Status(),
+		User:     c.GetString(gin.AuthUserKey),
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+// routeMiddleware returns the middleware every route runs after the
+// caller is identified.
+func routeMiddleware() []gin.HandlerFunc {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	return append([]gin.HandlerFunc{requestHooks}, extraMiddleware...)
+}
+
+// hookPlugin is a program in the plugins directory, written in any
+// language. At startup it is run with the argument "events" and must print
+// a JSON array of the events it wants. It is then run with the event as
+// its argument and the payload as JSON on stdin each time one of them
+// happens. Plugins wanting request.completed are run on every request, so
+// they should be quick.
+type hookPlugin struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	Events []string `json:"events"`
+}
+
+// hookPlugins are the plugins loaded at startup.
+var hookPlugins []hookPlugin
+
+// loadHookPlugins subscribes the executables in dir to the events they ask
+// for. Programs that fail to answer are left out.
+func loadHookPlugins(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	loaded := []hookPlugin{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		p := hookPlugin{Name: e.Name(), Path: filepath.Join(dir, e.Name())}
+		if p.Events, err = p.events(); err != nil {
+			log.Printf("hooks: skipping plugin %s: %v", p.Name, err)
+			continue
+		}
+		for _, event := range p.Events {
+			event := event
+			addHook(event, func(payload any) {
+				if err := p.run(event, payload); err != nil {
+					log.Printf("hooks: plugin %s on %s: %v", p.Name, event, err)
+				}
+			})
+		}
+		loaded = append(loaded, p)
+	}
+	hooksMu.Lock()
+	hookPlugins = loaded
+	hooksMu.Unlock()
+	return nil
+}
+
+func (p hookPlugin) events() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, "events")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var events []string
+	if err := json.Unmarshal(stdout.Bytes(), &events); err != nil {
+		return nil, fmt.Errorf("bad output: %w", err)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		if !hookEvents[event] {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return events, nil
+}
+
+func (p hookPlugin) run(event string, payload any) error {
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, event)
+	cmd.Stdin, cmd.Stderr = bytes.NewReader(input), &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// getPlugins lists the loaded plugins and the events they follow.
+func getPlugins(c *gin.Context) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	c.IndentedJSON(http.StatusOK, append([]hookPlugin{}, hookPlugins...))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resetHooks removes the hooks and plugins a test adds.
+func resetHooks(t *testing.T) {
+	hooksMu.Lock()
+	saved, savedMiddleware, savedPlugins := hookHandlers, extraMiddleware, hookPlugins
+	hookHandlers, extraMiddleware, hookPlugins = map[string][]func(any){}, nil, nil
+	hooksMu.Unlock()
+	t.Cleanup(func() {
+		flushHooks()
+		hooksMu.Lock()
+		hookHandlers, extraMiddleware, hookPlugins = saved, savedMiddleware, savedPlugins
+		hooksMu.Unlock()
+	})
+}
+
+// flushHooks waits for the queued handlers to run.
+func flushHooks() {
+	done := make(chan struct{})
+	queueHook("flush", func() { close(done) })
+	<-done
+}
+
+func TestHooks(t *testing.T) {
+	resetCatalog(t)
+	resetHooks(t)
+	var mu sync.Mutex
+	var seen []string
+	record := func(s string) {
+		mu.Lock()
+		seen = append(seen, s)
+		mu.Unlock()
+	}
+	onAlbumCreated(func(a album) { record("album " + a.Title) })
+	onScanComplete(func(r importResult) { record("scan " + strings.Repeat("+", r.TracksAdded)) })
+	onTrackPlayed(func(p trackPlay) { record(p.User + " played " + p.Track.Title) })
+	onRequest(func(r requestInfo) {
+		if r.Route == "/listens" {
+			record(r.Method + " " + r.Route + " " + r.User)
+		}
+	})
+	useMiddleware(func(c *gin.Context) {
+		c.Header("X-Plugin", "on")
+		if c.GetHeader("X-Block") != "" {
+			c.AbortWithStatusJSON(http.StatusTeapot, gin.H{"message": "blocked"})
+		}
+	})
+	router := setupRouter(testConfig())
+
+	// Check each event reaches its handler, in order
+	performRequest(router, "POST", "/albums", strings.NewReader(`{"title": "Kind of Blue", "artist": "Miles Davis"}`), "alice")
+	performRequest(router, "POST", "/library/import", strings.NewReader(`[{"path": "a.flac", "title": "So What", "artist": "Miles Davis", "album": "Milestones"}]`), "alice")
+	albumsMu.RLock()
+	id := tracks[len(tracks)-1].ID
+	albumsMu.RUnlock()
+	rr := performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "`+id+`"}`), "bob")
+	if rr.Code != http.StatusCreated || rr.Header().Get("X-Plugin") != "on" {
+		t.Errorf("Expected the listen recorded through the middleware, but got %d %v", rr.Code, rr.Header())
+	}
+	flushHooks()
+	want := []string{"album Kind of Blue", "album Milestones", "scan +", "bob played So What", "POST /listens bob"}
+	mu.Lock()
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("Expected %q, but got %q", want, seen)
+	}
+	mu.Unlock()
+
+	// Check middleware can stop a request
+	req, _ := http.NewRequest("GET", "/albums", nil)
+	req.Header.Set("X-Block", "yes")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("Expected status code %d, but got %d", http.StatusTeapot, rr.Code)
+	}
+
+	// Check a panicking handler does not stop the others
+	onScanComplete(func(importResult) { panic("broken plugin") })
+	onScanComplete(func(importResult) { record("after panic") })
+	fireHook(hookScanComplete, importResult{})
+	flushHooks()
+	mu.Lock()
+	if seen[len(seen)-1] != "after panic" {
+		t.Errorf("Expected the handler after the panic to run, but got %q", seen)
+	}
+	mu.Unlock()
+}
+
+func TestHookPlugins(t *testing.T) {
+	resetCatalog(t)
+	resetHooks(t)
+	dir, out := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(dir, "scrobbler"), []byte(`#!/bin/sh
+if [ "$1" = events ]; then echo '["track.played"]'; exit; fi
+cat > "`+out+`/$1.json"
+`), 0o755)
+	os.WriteFile(filepath.Join(dir, "broken"), []byte("#!/bin/sh\necho '[\"track.skipped\"]'\n"), 0o755)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("Not a plugin."), 0o644)
+	tracks = []track{{ID: "1", Title: "So What"}}
+
+	// Check only programs answering with known events are loaded
+	if err := loadHookPlugins(dir); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	router := setupRouter(testConfig())
+	rr := performRequest(router, "GET", "/admin/plugins", nil, "alice")
+	if !strings.Contains(rr.Body.String(), `"scrobbler"`) || strings.Contains(rr.Body.String(), "broken") {
+		t.Errorf("Expected only the scrobbler, but got %s", rr.Body)
+	}
+
+	// Check the plugin gets the play as JSON
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1", "playedAt": "2024-05-01T12:00:00Z"}`), "bob")
+	flushHooks()
+	payload, err := os.ReadFile(filepath.Join(out, "track.played.json"))
+	if err != nil || !strings.Contains(string(payload), `"user":"bob"`) || !strings.Contains(string(payload), `"title":"So What"`) {
+		t.Errorf("Expected bob's play of So What, but got %s %v", payload, err)
+	}
+
+	// Check only admins list plugins
+	rr = performRequest(router, "GET", "/admin/plugins", nil, "bob")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+}
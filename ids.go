@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newID returns a UUIDv7: a millisecond timestamp followed by random bits,
+// so ids sort roughly by creation time.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(err)
+	}
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// legacyIDNamespace is the UUIDv5 namespace migrated album ids are made in.
+var legacyIDNamespace = [16]byte{0xd3, 0xde, 0x6f, 0x0b, 0x94, 0x8f, 0x45, 0xa6, 0x94, 0xa1, 0x10, 0xde, 0x0c, 0xa1, 0x45, 0x2b}
+
+// legacyAlbumUUID returns the UUIDv5 a legacy album id migrates to. It only
+// depends on the legacy id, so every instance, and every restart, migrates
+// an album to the same UUID.
+func legacyAlbumUUID(legacy string) string {
+	h := sha1.New()
+	h.Write(legacyIDNamespace[:])
+	h.Write([]byte(legacy))
+	var b [16]byte
+	copy(b[:], h.Sum(nil))
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// isUUID reports whether id is in the canonical UUID form.
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, r := range id {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if r != '-' {
+				return false
+			}
+		} else if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// legacyID records the UUID an old numeric album id was migrated to.
+type legacyID struct {
+	ID         string    `json:"id"`
+	MigratedAt time.Time `json:"migratedAt"`
+}
+
+// legacyAlbumIDs is guarded by albumsMu. legacyIDPeriod, how long legacy
+// ids are still accepted after migration, is set from configuration at
+// startup.
+var (
+	legacyAlbumIDs = map[string]legacyID{}
+	legacyIDPeriod = 90 * 24 * time.Hour
+)
+
+// migrateAlbumIDs gives every album without a UUID its legacyAlbumUUID,
+// updating everything that refers to it and remembering the old id. It
+// returns how many albums were migrated. Callers must hold albumsMu.
+func migrateAlbumIDs(now time.Time) int {
+	renamed := map[string]string{}
+	for i, a := range albums {
+		if !isUUID(a.ID) {
+			renamed[a.ID] = legacyAlbumUUID(a.ID)
+			albums[i].ID = renamed[a.ID]
+			legacyAlbumIDs[a.ID] = legacyID{ID: renamed[a.ID], MigratedAt: now.UTC()}
+		}
+	}
+	if len(renamed) == 0 {
+		return 0
+	}
+	for i, t := range tracks {
+		if id, ok := renamed[t.AlbumID]; ok {
+			tracks[i].AlbumID = id
+		}
+	}
+	for i, t := range trash {
+		if id, ok := renamed[t.Track.AlbumID]; ok {
+			trash[i].Track.AlbumID = id
+		}
+	}
+	for i, r := range reviews {
+		if id, ok := renamed[r.AlbumID]; ok {
+			reviews[i].AlbumID = id
+		}
+	}
+	for old, id := range renamed {
+		if history, ok := priceHistory[old]; ok {
+			priceHistory[id] = history
+			delete(priceHistory, old)
+		}
+	}
+
+	bookMu.Lock()
+	for _, settings := range bookListeners {
+		for old, id := range renamed {
+			if progress, ok := settings.Progress[old]; ok {
+				settings.Progress[id] = progress
+				delete(settings.Progress, old)
+			}
+		}
+	}
+	bookMu.Unlock()
+
+	suggestions.stale.Store(true)
+	return len(renamed)
+}
+
+// migrateIDs is the scheduled form of migrateAlbumIDs, which picks up
+// albums restored from old backups or created by older instances.
+func migrateIDs(ctx context.Context) error {
+	albumsMu.Lock()
+	n := migrateAlbumIDs(time.Now())
+	albumsMu.Unlock()
+
+	if n > 0 {
+		log.Printf("ids: migrated %d albums to UUIDs", n)
+		return invalidateResponses(ctx)
+	}
+	return nil
+}
+
+// currentAlbumID returns the UUID a legacy album id maps to while legacy
+// ids are still accepted. Callers must hold albumsMu.
+func currentAlbumID(id string, now time.Time) (string, bool) {
+	legacy, ok := legacyAlbumIDs[id]
+	if !ok || now.Sub(legacy.MigratedAt) > legacyIDPeriod {
+		return "", false
+	}
+	return legacy.ID, true
+}
+
+// resolveLegacyIDs rewrites legacy album ids in the :id of album routes
+// and in ?albumId to their UUIDs, warning the client that the old id is
+// deprecated.
+func resolveLegacyIDs(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	now := time.Now()
+	if strings.HasPrefix(c.FullPath(), "/albums/:id") {
+		for i, p := range c.Params {
+			if p.Key == "id" {
+				if id, ok := currentAlbumID(p.Value, now); ok {
+					c.Params[i].Value = id
+					addWarning(c, "album id "+p.Value+" is deprecated, use "+id+" instead")
+				}
+			}
+		}
+	}
+	// Read the query directly, since c.Query would cache the old value.
+	if old := c.Request.URL.Query().Get("albumId"); old != "" {
+		if id, ok := currentAlbumID(old, now); ok {
+			query := c.Request.URL.Query()
+			query.Set("albumId", id)
+			c.Request.URL.RawQuery = query.Encode()
+			addWarning(c, "album id "+old+" is deprecated, use "+id+" instead")
+		}
+	}
+}
+
+func dumpLegacyIDs() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(legacyAlbumIDs, "", "    ")
+}
+
+func restoreLegacyIDs(data []byte) (func(), int, error) {
+	var restored map[string]legacyID
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]legacyID{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		legacyAlbumIDs = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
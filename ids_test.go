@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewID(t *testing.T) {
+	// Check ids are version 7 UUIDs that sort by creation time
+	first := newID()
+	time.Sleep(2 * time.Millisecond)
+	second := newID()
+	if !isUUID(first) || first[14] != '7' || !strings.ContainsRune("89ab", rune(first[19])) {
+		t.Errorf("Expected a UUIDv7, but got %s", first)
+	}
+	if first >= second {
+		t.Errorf("Expected %s to sort before %s", first, second)
+	}
+	if isUUID("1") || isUUID(strings.ToUpper(first)) {
+		t.Errorf("Expected only canonical UUIDs to be accepted")
+	}
+}
+
+func TestAlbumIDMigration(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train"}, {ID: "2", Title: "Jeru"}}
+	tracks = []track{{ID: "1", AlbumID: "1"}, {ID: "2", AlbumID: "2"}}
+	reviews = []review{{ID: "1", AlbumID: "1", Rating: 5}}
+	priceHistory = map[string][]priceChange{"1": {{Price: 56.99}}}
+
+	// Check migration renames albums and what refers to them
+	migrated := migrateAlbumIDs(time.Now())
+	id := albums[0].ID
+	if migrated != 2 || !isUUID(id) || tracks[0].AlbumID != id || reviews[0].AlbumID != id || len(priceHistory[id]) != 1 {
+		t.Errorf("Expected album 1 and its references to move to a UUID, but got %+v %+v %+v", albums, tracks, reviews)
+	}
+	if migrateAlbumIDs(time.Now()) != 0 || albums[0].ID != id {
+		t.Errorf("Expected migrating again to change nothing")
+	}
+
+	// Check every instance migrates an album to the same UUID
+	if id != "1f55925f-4cc6-5753-a6c1-2b268cc387cb" || legacyAlbumUUID("2") != albums[1].ID {
+		t.Errorf("Expected the UUIDv5 of the legacy ids, but got %s and %s", id, albums[1].ID)
+	}
+
+	// Check legacy ids still work, with a warning
+	rr := performRequest(router, "GET", "/albums/1", nil, "")
+	var got album
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || got.ID != id {
+		t.Errorf("Expected album %s, but got %d %s", id, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Header().Get("Warning"), "album id 1 is deprecated") {
+		t.Errorf("Expected a deprecation warning, but got %q", rr.Header().Get("Warning"))
+	}
+	rr = performRequest(router, "GET", "/tracks?albumId=1", nil, "")
+	var listed []track
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "1" {
+		t.Errorf("Expected track 1, but got %+v", listed)
+	}
+
+	// Check legacy ids stop working after the deprecation period
+	legacyAlbumIDs["1"] = legacyID{ID: id, MigratedAt: time.Now().Add(-legacyIDPeriod - time.Hour)}
+	rr = performRequest(router, "GET", "/albums/1", nil, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check new albums get a server-side UUID
+	rr = performRequest(router, "POST", "/albums", strings.NewReader(`{"id": "7", "title": "Moanin'"}`), "")
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if !isUUID(got.ID) {
+		t.Errorf("Expected a UUID, but got %q", got.ID)
+	}
+}
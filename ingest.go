@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// urlIngester is nil unless URL ingestion is turned on.
+var urlIngester mediaDownloader
+
+const (
+	// ingestTimeout bounds how long one download may take.
+	ingestTimeout = 30 * time.Minute
+	// ingestJobRetention is how long finished jobs are kept to be looked
+	// up. Quotas only look back a day, so older jobs are not needed.
+	ingestJobRetention = 7 * 24 * time.Hour
+)
+
+// downloadedMedia is a file fetched from a URL, with what the site knows
+// about it.
+type downloadedMedia struct {
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Track    int
+	Year     int
+	Duration float64
+}
+
+// mediaDownloader fetches the audio at a URL into dir.
+type mediaDownloader interface {
+	Download(ctx context.Context, link, dir string) (downloadedMedia, error)
+}
+
+// ytDlp downloads with yt-dlp, which knows YouTube, SoundCloud and many
+// other sites, keeping only the audio.
+type ytDlp struct {
+	command string
+}
+
+func (y ytDlp) Download(ctx context.Context, link, dir string) (downloadedMedia, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, y.command, "--no-playlist", "--no-progress", "--extract-audio",
+		"--output", filepath.Join(dir, "%(id)s.%(ext)s"), "--print", "after_move:%()j", "--", link)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return downloadedMedia{}, fmt.Errorf("%s: %w: %s", y.command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var info struct {
+		Filepath    string  `json:"filepath"`
+		Title       string  `json:"title"`
+		Track       string  `json:"track"`
+		Artist      string  `json:"artist"`
+		Uploader    string  `json:"uploader"`
+		Album       string  `json:"album"`
+		TrackNumber int     `json:"track_number"`
+		ReleaseYear int     `json:"release_year"`
+		UploadDate  string  `json:"upload_date"`
+		Duration    float64 `json:"duration"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return downloadedMedia{}, fmt.Errorf("%s: bad output: %w", y.command, err)
+	}
+	m := downloadedMedia{Path: info.Filepath, Title: info.Track, Artist: info.Artist, Album: info.Album,
+		Track: info.TrackNumber, Year: info.ReleaseYear, Duration: info.Duration}
+	if m.Title == "" {
+		m.Title = info.Title
+	}
+	if m.Artist == "" {
+		// Auto-generated YouTube channels are named "Artist - Topic".
+		m.Artist = strings.TrimSuffix(info.Uploader, " - Topic")
+	}
+	if m.Year == 0 && len(info.UploadDate) >= 4 {
+		m.Year, _ = strconv.Atoi(info.UploadDate[:4])
+	}
+	return m, nil
+}
+
+// ingestJob downloads a URL into the library in the background.
+type ingestJob struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	URL   string `json:"url"`
+	// Status is "running", "review" while the download waits in
+	// quarantine, "done" or "failed".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// QuarantineID is the download waiting for review, and TrackID the
+	// track it was filed as once approved.
+	QuarantineID string     `json:"quarantineId,omitempty"`
+	TrackID      string     `json:"trackId,omitempty"`
+	AlbumID      string     `json:"albumId,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+}
+
+// ingestTags are tags given with the URL, which win over what the site
+// says.
+type ingestTags struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+}
+
+// ingestJobs is guarded by albumsMu.
+var ingestJobs []ingestJob
+
+// findIngestJob returns the index of the user's job with the given id, or
+// -1. Callers must hold albumsMu.
+func findIngestJob(id, owner string) int {
+	for i, j := range ingestJobs {
+		if j.ID == id && j.Owner == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// ingestURL returns the handler that starts downloading the audio at a URL
+// into quarantine under root, for review, answering with the job and a link to follow
+// it. Each user may start quota downloads a day; zero means no limit.
+func ingestURL(root string, quota int, quotas storageQuotas) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			URL string `json:"url"`
+			ingestTags
+		}
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		if urlIngester == nil {
+			c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "URL ingestion is not enabled"})
+			return
+		}
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		if u, err := url.Parse(input.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "url must be an http or https URL"})
+			return
+		}
+		user := c.GetString(gin.AuthUserKey)
+		if status, msg := quotas.check(user, 0); status != 0 {
+			c.IndentedJSON(status, gin.H{"message": msg})
+			return
+		}
+		now := time.Now().UTC()
+
+		albumsMu.Lock()
+		kept, started := ingestJobs[:0], 0
+		for _, j := range ingestJobs {
+			if now.Sub(j.CreatedAt) > ingestJobRetention {
+				continue
+			}
+			if j.Owner == user && now.Sub(j.CreatedAt) < 24*time.Hour {
+				started++
+			}
+			kept = append(kept, j)
+		}
+		ingestJobs = kept
+		if quota > 0 && started >= quota {
+			albumsMu.Unlock()
+			c.Header("Retry-After", strconv.Itoa(int((24 * time.Hour).Seconds())))
+			c.IndentedJSON(http.StatusTooManyRequests, gin.H{"message": fmt.Sprintf("quota of %d downloads a day reached", quota)})
+			return
+		}
+		job := ingestJob{ID: newID(), Owner: user, URL: input.URL, Status: "running", CreatedAt: now}
+		ingestJobs = append(ingestJobs, job)
+		albumsMu.Unlock()
+
+		go runIngestJob(root, job.ID, user, input.URL, input.ingestTags, quotas)
+		link := "/library/ingest-url/" + job.ID
+		c.Header("Location", link)
+		c.IndentedJSON(http.StatusAccepted, gin.H{"job": job, "statusUrl": link})
+	}
+}
+
+// runIngestJob downloads link into quarantine under root, then records how
+// it went on the job.
+func runIngestJob(root, id, owner, link string, tags ingestTags, quotas storageQuotas) {
+	ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+	defer cancel()
+
+	q, err := ingestMedia(ctx, root, id, owner, link, tags, quotas)
+	if err != nil {
+		log.Printf("ingest %s: %v", link, err)
+	}
+	finished := time.Now().UTC()
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+	for i := range ingestJobs {
+		if ingestJobs[i].ID != id {
+			continue
+		}
+		j := &ingestJobs[i]
+		j.Status, j.QuarantineID, j.FinishedAt = "review", q.ID, &finished
+		if err != nil {
+			j.Status, j.Error = "failed", err.Error()
+		}
+	}
+}
+
+// finishIngestReview records on the job that downloaded a quarantined file
+// that it was approved as t, or rejected for reason.
+func finishIngestReview(quarantineID string, t track, reason string) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	for i := range ingestJobs {
+		j := &ingestJobs[i]
+		if j.QuarantineID != quarantineID {
+			continue
+		}
+		j.Status, j.TrackID, j.AlbumID = "done", t.ID, t.AlbumID
+		if reason != "" {
+			j.Status, j.Error = "failed", "rejected: "+reason
+		}
+	}
+}
+
+func ingestMedia(ctx context.Context, root, id, owner, link string, tags ingestTags, quotas storageQuotas) (quarantinedFile, error) {
+	// Downloads land in a directory of their own, so that what yt-dlp
+	// leaves behind on failure is easy to clean up.
+	work := filepath.Join(root, ".ingest", id)
+	if err := os.MkdirAll(work, 0o755); err != nil {
+		return quarantinedFile{}, err
+	}
+	defer os.RemoveAll(work)
+
+	m, err := urlIngester.Download(ctx, link, work)
+	if err != nil {
+		return quarantinedFile{}, err
+	}
+	// The size of a download is only known once it is done.
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		return quarantinedFile{}, err
+	}
+	if status, msg := quotas.check(owner, info.Size()); status != 0 {
+		return quarantinedFile{}, errors.New(msg)
+	}
+	if v := strings.TrimSpace(tags.Title); v != "" {
+		m.Title = v
+	}
+	if v := strings.TrimSpace(tags.Artist); v != "" {
+		m.Artist = v
+	}
+	if v := strings.TrimSpace(tags.Album); v != "" {
+		m.Album = v
+	}
+	if m.Album == "" {
+		// Most downloads are single tracks.
+		m.Album = m.Title
+	}
+	scanned := trackTags{Title: m.Title, Artist: m.Artist, Album: m.Album, Track: m.Track, Year: m.Year,
+		Duration: m.Duration, Format: strings.ToLower(strings.TrimPrefix(filepath.Ext(m.Path), "."))}
+	return quarantineFile(ctx, root, m.Path, filepath.Base(m.Path), "ingest", owner, scanned)
+}
+
+// getIngestJobs lists the user's downloads of the last week.
+func getIngestJobs(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	jobs := []ingestJob{}
+	for _, j := range ingestJobs {
+		if j.Owner == user {
+			jobs = append(jobs, j)
+		}
+	}
+	respondList(c, jobs)
+}
+
+func getIngestJob(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findIngestJob(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "job not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, ingestJobs[i])
+}
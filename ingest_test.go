@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDownloader writes a file for every URL but /missing.
+type fakeDownloader struct{}
+
+func (fakeDownloader) Download(ctx context.Context, link, dir string) (downloadedMedia, error) {
+	if strings.HasSuffix(link, "/missing") {
+		return downloadedMedia{}, errors.New("video unavailable")
+	}
+	path := filepath.Join(dir, "abc123.opus")
+	err := os.WriteFile(path, []byte("OggS"), 0o644)
+	return downloadedMedia{Path: path, Title: "Nightcall", Artist: "Kavinsky", Year: 2010, Duration: 258}, err
+}
+
+// waitForIngest polls the job until it has finished.
+func waitForIngest(t *testing.T, router http.Handler, link, user string) ingestJob {
+	t.Helper()
+	var job ingestJob
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rr := performRequest(router, "GET", link, nil, user)
+		json.Unmarshal(rr.Body.Bytes(), &job)
+		if job.Status != "running" {
+			return job
+		}
+	}
+	t.Fatalf("Expected the job to finish, but got %+v", job)
+	return job
+}
+
+func TestIngestURL(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	cfg.IngestQuota = 2
+	router := setupRouter(cfg)
+	body := `{"url": "https://www.youtube.com/watch?v=abc123", "album": "OutRun"}`
+
+	// Check ingestion is off unless turned on
+	rr := performRequest(router, "POST", "/library/ingest-url", strings.NewReader(body), "bob")
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotImplemented, rr.Code)
+	}
+	defer func(d mediaDownloader) { urlIngester = d }(urlIngester)
+	urlIngester = fakeDownloader{}
+
+	// Check only web URLs are accepted
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(`{"url": "file:///etc/passwd"}`), "bob")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check the download is tagged and quarantined, and the job links to it
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(body), "bob")
+	link := rr.Header().Get("Location")
+	if rr.Code != http.StatusAccepted || !strings.HasPrefix(link, "/library/ingest-url/") {
+		t.Fatalf("Expected status code %d with a job link, but got %d %q", http.StatusAccepted, rr.Code, link)
+	}
+	job := waitForIngest(t, router, link, "bob")
+	if job.Status != "review" || job.QuarantineID == "" || len(tracks) != 0 {
+		t.Errorf("Expected the download to wait for review, but got %+v and %+v", job, tracks)
+	}
+
+	// Check the approved download is filed, and the job links to the track
+	performRequest(router, "POST", "/quarantine/"+job.QuarantineID+"/approve", nil, "alice")
+	json.Unmarshal(performRequest(router, "GET", link, nil, "bob").Body.Bytes(), &job)
+	want := filepath.Join(root, "Kavinsky", "OutRun", "Nightcall.opus")
+	if job.Status != "done" || job.TrackID == "" || len(tracks) != 1 || tracks[0].Path != want {
+		t.Errorf("Expected the track filed at %s, but got %+v and %+v", want, job, tracks)
+	}
+	if len(albums) != 1 || albums[0].Title != "OutRun" || albums[0].Year != 2010 {
+		t.Errorf("Expected the album OutRun from 2010, but got %+v", albums)
+	}
+
+	// Check jobs are private to their owner
+	rr = performRequest(router, "GET", link, nil, "alice")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check a failed download is reported on the job
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(`{"url": "https://example.com/missing"}`), "bob")
+	job = waitForIngest(t, router, rr.Header().Get("Location"), "bob")
+	if job.Status != "failed" || job.Error != "video unavailable" {
+		t.Errorf("Expected the job to fail, but got %+v", job)
+	}
+
+	// Check the daily quota is enforced per user
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(body), "bob")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(`{"url": "https://example.com/missing"}`), "alice")
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, but got %d", http.StatusAccepted, rr.Code)
+	}
+	waitForIngest(t, router, rr.Header().Get("Location"), "alice")
+
+	// Check the user's jobs are listed
+	rr = performRequest(router, "GET", "/library/ingest-url", nil, "bob")
+	var jobs []ingestJob
+	json.Unmarshal(rr.Body.Bytes(), &jobs)
+	if len(jobs) != 2 {
+		t.Errorf("Expected 2 jobs, but got %d", len(jobs))
+	}
+}
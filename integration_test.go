@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer is the whole server listening on a local port, with an empty
+// catalog and its own library directory, for tests that go over HTTP the
+// way clients do.
+type testServer struct {
+	t   *testing.T
+	URL string
+	cfg config
+}
+
+// testResponse is a response read to the end.
+type testResponse struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+// startServer serves setupRouter(cfg) on a free port until the test
+// finishes. The catalog and the activity feed start empty and are put back
+// afterwards; LibraryDir and TrashDir are fresh temporary directories.
+func startServer(t *testing.T, cfg config) *testServer {
+	resetCatalog(t)
+	resetActivity(t)
+	albums = nil
+	cfg.LibraryDir = t.TempDir()
+	cfg.TrashDir = filepath.Join(cfg.LibraryDir, ".trash")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, listener, setupRouter(cfg), 5*time.Second) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected a clean shutdown, but got %v", err)
+		}
+	})
+	return &testServer{t: t, URL: "http://" + listener.Addr().String(), cfg: cfg}
+}
+
+// do sends a request as user, whose password is user+"-pw" as in
+// testConfig, and reads the response. header pairs are set on the request.
+// Responses to requests openapi.json documents must match it.
+func (s *testServer) do(method, path string, body io.Reader, user string, header ...string) testResponse {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		s.t.Fatalf("Expected no error, but got %v", err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, user+"-pw")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return s.send(req)
+}
+
+func (s *testServer) send(req *http.Request) testResponse {
+	s.t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("Expected no error, but got %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("Expected no error, but got %v", err)
+	}
+	if err := loadSpec(s.t).check(req.Method, req.URL.Path, resp.StatusCode, resp.Header, body); err != nil {
+		s.t.Error(err)
+	}
+	return testResponse{Code: resp.StatusCode, Header: resp.Header, Body: body}
+}
+
+// doJSON sends v as JSON and decodes the response into out, failing the
+// test unless it has the status code want.
+func (s *testServer) doJSON(method, path string, v any, user string, want int, out any) testResponse {
+	s.t.Helper()
+	var body io.Reader
+	if v != nil {
+		data, _ := json.Marshal(v)
+		body = strings.NewReader(string(data))
+	}
+	rr := s.do(method, path, body, user, "Content-Type", "application/json")
+	if rr.Code != want {
+		s.t.Fatalf("Expected status code %d for %s %s, but got %d: %s", want, method, path, rr.Code, rr.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rr.Body, out); err != nil {
+			s.t.Fatalf("Expected JSON from %s %s, but got %v", method, path, err)
+		}
+	}
+	return rr
+}
+
+// testFixtures is the catalog in testdata/catalog.json. Track paths are
+// relative to the library directory.
+type testFixtures struct {
+	Albums []album     `json:"albums"`
+	Tracks []trackTags `json:"tracks"`
+}
+
+// seed adds the fixtures through the API, as a client and a scan would:
+// the albums are posted, then each track gets a file in the library and
+// the files are imported. It returns the albums created, by title.
+func (s *testServer) seed(path string) map[string]album {
+	s.t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.t.Fatalf("Expected no error, but got %v", err)
+	}
+	var fixtures testFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		s.t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	created := map[string]album{}
+	for _, a := range fixtures.Albums {
+		var got album
+		s.doJSON("POST", "/albums", a, "alice", http.StatusCreated, &got)
+		created[got.Title] = got
+	}
+	for i, tags := range fixtures.Tracks {
+		file := filepath.Join(s.cfg.LibraryDir, filepath.FromSlash(tags.Path))
+		os.MkdirAll(filepath.Dir(file), 0o755)
+		if err := os.WriteFile(file, []byte("audio of "+tags.Title), 0o644); err != nil {
+			s.t.Fatalf("Expected no error, but got %v", err)
+		}
+		fixtures.Tracks[i].Path = file
+	}
+	var result importResult
+	s.doJSON("POST", "/library/import", fixtures.Tracks, "alice", http.StatusOK, &result)
+	if result.TracksAdded != len(fixtures.Tracks) || result.AlbumsCreated != 0 {
+		s.t.Fatalf("Expected the fixture tracks to join the fixture albums, but got %+v", result)
+	}
+	return created
+}
+
+// albumTracks lists the tracks of an album, in order, over all its discs.
+func (s *testServer) albumTracks(id string) []track {
+	s.t.Helper()
+	var discs []disc
+	s.doJSON("GET", "/albums/"+id+"/tracks", nil, "", http.StatusOK, &discs)
+	var all []track
+	for _, d := range discs {
+		all = append(all, d.Tracks...)
+	}
+	return all
+}
+
+// An album created by a client gets its tracks from an import, and they
+// can be streamed
+func TestEndToEndCatalog(t *testing.T) {
+	s := startServer(t, testConfig())
+	fixtures := s.seed(filepath.Join("testdata", "catalog.json"))
+
+	// Check the seeded catalog is served
+	var listed []album
+	s.doJSON("GET", "/albums", nil, "", http.StatusOK, &listed)
+	if len(listed) != 3 {
+		t.Errorf("Expected 3 albums, but got %d", len(listed))
+	}
+	blueTrain := s.albumTracks(fixtures["Blue Train"].ID)
+	if len(blueTrain) != 2 || blueTrain[0].Title != "Blue Train" || blueTrain[1].Title != "Moment's Notice" {
+		t.Errorf("Expected the two tracks of Blue Train, but got %+v", blueTrain)
+	}
+
+	// Create an album, then add its tracks with an import
+	var kob album
+	s.doJSON("POST", "/albums", gin.H{"title": "Kind of Blue", "artist": "Miles Davis", "price": 19.99}, "alice", http.StatusCreated, &kob)
+	dir := filepath.Join(s.cfg.LibraryDir, "Miles Davis", "Kind of Blue")
+	os.MkdirAll(dir, 0o755)
+	var scanned []trackTags
+	for i, title := range []string{"So What", "Freddie Freeloader", "Blue in Green"} {
+		path := filepath.Join(dir, title+".flac")
+		os.WriteFile(path, []byte("fLaC "+title), 0o644)
+		scanned = append(scanned, trackTags{Path: path, Title: title, Artist: "Miles Davis", Album: "Kind of Blue", Track: i + 1, Format: "flac"})
+	}
+	var result importResult
+	s.doJSON("POST", "/library/import", scanned, "alice", http.StatusOK, &result)
+	if result.TracksAdded != 3 || result.AlbumsCreated != 0 {
+		t.Errorf("Expected 3 tracks added to the existing album, but got %+v", result)
+	}
+	kobTracks := s.albumTracks(kob.ID)
+	if len(kobTracks) != 3 || kobTracks[0].Title != "So What" {
+		t.Fatalf("Expected the three tracks of Kind of Blue, but got %+v", kobTracks)
+	}
+
+	// Check a track streams whole and in part
+	rr := s.do("GET", "/tracks/"+kobTracks[0].ID+"/stream", nil, "")
+	if rr.Code != http.StatusOK || string(rr.Body) != "fLaC So What" {
+		t.Errorf("Expected the file, but got %d: %q", rr.Code, rr.Body)
+	}
+	rr = s.do("GET", "/tracks/"+kobTracks[0].ID+"/stream", nil, "", "Range", "bytes=5-")
+	if rr.Code != http.StatusPartialContent || string(rr.Body) != "So What" {
+		t.Errorf("Expected the rest of the file from byte 5, but got %d: %q", rr.Code, rr.Body)
+	}
+	if rr.Header.Get("ETag") == "" || rr.Header.Get("Content-Range") != "bytes 5-11/12" {
+		t.Errorf("Expected an ETag and the range sent, but got %v", rr.Header)
+	}
+
+	// Check a play is counted and shows in the feed with the library's changes
+	s.doJSON("POST", "/listens", gin.H{"trackId": kobTracks[0].ID}, "bob", http.StatusCreated, nil)
+	flushHooks()
+	var feed []activity
+	s.doJSON("GET", "/activity?kind=track.played,scan.complete", nil, "bob", http.StatusOK, &feed)
+	if len(feed) != 3 || feed[2].Kind != hookTrackPlayed || feed[2].TrackID != kobTracks[0].ID {
+		t.Errorf("Expected two scans and bob's play, but got %+v", feed)
+	}
+}
+
+// Edits can be undone, and a backup taken over HTTP restores the catalog
+func TestEndToEndEditAndRestore(t *testing.T) {
+	s := startServer(t, testConfig())
+	fixtures := s.seed(filepath.Join("testdata", "catalog.json"))
+	jeru := fixtures["Jeru"]
+
+	// Edit an album, then undo the edit with the id it was logged under
+	rr := s.doJSON("PATCH", "/albums/"+jeru.ID, gin.H{"title": "Jeru (Remastered)"}, "alice", http.StatusOK, nil)
+	opID := rr.Header.Get("X-Operation-Id")
+	if opID == "" {
+		t.Fatalf("Expected the edit to be logged, but got %v", rr.Header)
+	}
+	var got album
+	s.doJSON("GET", "/albums/"+jeru.ID, nil, "", http.StatusOK, &got)
+	if got.Title != "Jeru (Remastered)" {
+		t.Errorf("Expected the new title, but got %q", got.Title)
+	}
+	s.doJSON("POST", "/admin/undo/"+opID, nil, "alice", http.StatusOK, nil)
+	s.doJSON("GET", "/albums/"+jeru.ID, nil, "", http.StatusOK, &got)
+	if got.Title != "Jeru" {
+		t.Errorf("Expected the title back as Jeru, but got %q", got.Title)
+	}
+
+	// Take a backup, change the catalog, and restore it
+	rr = s.do("POST", "/admin/backup", nil, "alice")
+	if rr.Code != http.StatusOK || rr.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Expected a backup archive, but got %d: %s", rr.Code, rr.Body)
+	}
+	backup := rr.Body
+	s.doJSON("PATCH", "/albums/"+jeru.ID, gin.H{"price": 0.99}, "alice", http.StatusOK, nil)
+	if rr := s.send(restoreRequest(s.URL+"/admin/restore", backup)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	s.doJSON("GET", "/albums/"+jeru.ID, nil, "", http.StatusOK, &got)
+	if got.Price != 17.99 {
+		t.Errorf("Expected the price from the backup, but got %v", got.Price)
+	}
+	if tracks := s.albumTracks(jeru.ID); len(tracks) != 1 || tracks[0].Title != "Capricious" {
+		t.Errorf("Expected the restored album's track, but got %+v", tracks)
+	}
+
+	// Check clients without the right account are turned away
+	if rr := s.do("POST", "/admin/backup", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := s.do("POST", "/library/import", strings.NewReader("[]"), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
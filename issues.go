@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+var severityRank = map[string]int{severityError: 0, severityWarning: 1, severityInfo: 2}
+
+// libraryIssue is a problem found in the library, with the endpoint that
+// fixes it when there is one.
+type libraryIssue struct {
+	Type     string    `json:"type"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	TrackID  string    `json:"trackId,omitempty"`
+	AlbumID  string    `json:"albumId,omitempty"`
+	Fix      *issueFix `json:"fix,omitempty"`
+}
+
+type issueFix struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Field is the request field that fixes the issue.
+	Field string `json:"field,omitempty"`
+}
+
+// trackIssues lists the problems with a track's tags.
+func trackIssues(t track) []libraryIssue {
+	var issues []libraryIssue
+	add := func(kind, severity, message, field string) {
+		issues = append(issues, libraryIssue{
+			Type: kind, Severity: severity, Message: message, TrackID: t.ID, AlbumID: t.AlbumID,
+			Fix: &issueFix{Method: "PATCH", Path: "/tracks/" + t.ID, Field: field},
+		})
+	}
+	if t.Title == "" {
+		add("missing-title", severityError, "track has no title", "title")
+	}
+	if t.Artist == "" {
+		add("missing-artist", severityWarning, "track has no artist", "artist")
+	}
+	if t.Number == 0 {
+		add("missing-track-number", severityInfo, "track has no track number", "number")
+	}
+	if t.Duration <= 0 {
+		add("zero-duration", severityWarning, "track has no duration", "duration")
+	}
+	return issues
+}
+
+// fileIssue reports a track whose file cannot be read, or nil.
+func fileIssue(t track) *libraryIssue {
+	if t.Path == "" {
+		return nil
+	}
+	f, err := os.Open(t.Path)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && info.IsDir() {
+			err = os.ErrInvalid
+		}
+		f.Close()
+	}
+	if err == nil {
+		return nil
+	}
+	return &libraryIssue{
+		Type: "unreadable-file", Severity: severityError, Message: err.Error(), TrackID: t.ID, AlbumID: t.AlbumID,
+		Fix: &issueFix{Method: "DELETE", Path: "/tracks/" + t.ID},
+	}
+}
+
+// getLibraryIssues lists problems in the library, most severe first. It can
+// be narrowed with ?severity= and ?type=.
+func getLibraryIssues(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	var issues []libraryIssue
+	for _, a := range albums {
+		if a.CoverURL == "" {
+			issues = append(issues, libraryIssue{
+				Type: "missing-cover", Severity: severityInfo, Message: "album has no cover art", AlbumID: a.ID,
+				Fix: &issueFix{Method: "PATCH", Path: "/albums/" + a.ID, Field: "coverUrl"},
+			})
+		}
+	}
+	files := make([]track, 0, len(tracks))
+	for _, t := range tracks {
+		issues = append(issues, trackIssues(t)...)
+		files = append(files, t)
+	}
+	albumsMu.RUnlock()
+
+	// Files are checked without holding the lock, as disks can be slow.
+	for _, t := range files {
+		if issue := fileIssue(t); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+
+	severity, kind := c.Query("severity"), c.Query("type")
+	matching := []libraryIssue{}
+	for _, issue := range issues {
+		if (severity == "" || issue.Severity == severity) && (kind == "" || issue.Type == kind) {
+			matching = append(matching, issue)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return severityRank[matching[i].Severity] < severityRank[matching[j].Severity]
+	})
+	start, end := pageBounds(c, len(matching), limit, offset)
+	c.IndentedJSON(http.StatusOK, matching[start:end])
+}
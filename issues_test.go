@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetLibraryIssues(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	readable := filepath.Join(t.TempDir(), "ok.flac")
+	os.WriteFile(readable, []byte("fLaC"), 0o644)
+	albums = []album{{ID: "1", Title: "Pastel Blues", Artist: "Nina Simone", CoverURL: "https://example.com/cover.jpg"}, {ID: "2", Title: "Untitled"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Sinnerman", Artist: "Nina Simone", Number: 1, Duration: 622, Path: readable},
+		{ID: "2", AlbumID: "2", Artist: "Unknown", Number: 1, Duration: 0, Path: filepath.Join(t.TempDir(), "gone.flac")},
+	}
+
+	// Check only admins see the report
+	if rr := performRequest(router, "GET", "/library/issues", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	rr := performRequest(router, "GET", "/library/issues", nil, "alice")
+	var issues []libraryIssue
+	json.Unmarshal(rr.Body.Bytes(), &issues)
+	types := map[string]libraryIssue{}
+	for _, issue := range issues {
+		types[issue.Type] = issue
+	}
+
+	// Check each problem is reported once, errors first, with fix links
+	if len(issues) != 4 || issues[0].Severity != severityError || issues[len(issues)-1].Severity != severityInfo {
+		t.Errorf("Expected 4 issues ordered by severity, but got %+v", issues)
+	}
+	if fix := types["missing-title"].Fix; fix == nil || fix.Path != "/tracks/2" || fix.Field != "title" {
+		t.Errorf("Expected a fix link for the missing title, but got %+v", fix)
+	}
+	if types["zero-duration"].TrackID != "2" || types["unreadable-file"].TrackID != "2" || types["missing-cover"].AlbumID != "2" {
+		t.Errorf("Expected duration, file and cover issues, but got %+v", issues)
+	}
+
+	// Check the report can be filtered and fixed
+	rr = performRequest(router, "GET", "/library/issues?severity=error&type=missing-title", nil, "alice")
+	json.Unmarshal(rr.Body.Bytes(), &issues)
+	if len(issues) != 1 {
+		t.Errorf("Expected 1 issue, but got %+v", issues)
+	}
+	performRequest(router, "PATCH", "/tracks/2", strings.NewReader(`{"title": "Found"}`), "alice")
+	rr = performRequest(router, "GET", "/library/issues?type=missing-title", nil, "alice")
+	if rr.Body.String() != "[]" {
+		t.Errorf("Expected no missing titles after the fix, but got %s", rr.Body.String())
+	}
+}
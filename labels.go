@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recordLabel struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Albums int    `json:"albums"`
+}
+
+// labelLookups records the albums already looked up on MusicBrainz, so
+// albums it knows no label for are not asked about on every run. It is
+// guarded by albumsMu and starts over on restart.
+var labelLookups = map[string]bool{}
+
+func getLabels(c *gin.Context) {
+	albumsMu.RLock()
+	byID := make(map[string]*recordLabel)
+	for _, al := range albums {
+		id := slugify(al.Label)
+		if id == "" {
+			continue
+		}
+		if byID[id] == nil {
+			byID[id] = &recordLabel{ID: id, Name: al.Label}
+		}
+		byID[id].Albums++
+	}
+	albumsMu.RUnlock()
+
+	labels := []recordLabel{}
+	for _, l := range byID {
+		labels = append(labels, *l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].ID < labels[j].ID })
+	respondList(c, labels)
+}
+
+// getLabelAlbums lists the albums of the label whose slug is id in
+// catalog number order, which for most labels is release order.
+func getLabelAlbums(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	var released []album
+	for _, al := range albums {
+		if al.Label != "" && slugify(al.Label) == c.Param("id") && visibleTo(c, al) {
+			released = append(released, al)
+		}
+	}
+	albumsMu.RUnlock()
+
+	if len(released) == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "label not found"})
+		return
+	}
+	sort.SliceStable(released, func(i, j int) bool {
+		a, b := released[i], released[j]
+		if a.CatalogNumber != b.CatalogNumber {
+			// Albums without a catalog number go last.
+			return b.CatalogNumber == "" || a.CatalogNumber != "" && naturalLess(a.CatalogNumber, b.CatalogNumber)
+		}
+		return a.Year < b.Year
+	})
+	start, end := pageBounds(c, len(released), limit, offset)
+	respondList(c, released[start:end])
+}
+
+// naturalLess orders catalog numbers with their digits compared as
+// numbers, so "BLP 1577" comes before "BLP 15770" and "ST-9" before
+// "ST-10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			if len(da) != len(db) {
+				return len(da) < len(db)
+			}
+			if da != db {
+				return da < db
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return s[:n]
+}
+
+// lookUpLabels is the labels task. It asks MusicBrainz for the label and
+// catalog number of albums whose tags had none, and tells the other
+// instances about the albums it changes.
+func lookUpLabels(ctx context.Context) error {
+	if musicBrainz == nil {
+		return nil
+	}
+
+	albumsMu.RLock()
+	var pending []album
+	for _, al := range albums {
+		if al.Label == "" && al.Artist != "" && !labelLookups[al.ID] {
+			pending = append(pending, al)
+		}
+	}
+	albumsMu.RUnlock()
+
+	var errs []error
+	for n, al := range pending {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(musicBrainzPause):
+			}
+		}
+		label, catalogNumber, err := musicBrainz.releaseLabel(ctx, al.Artist, al.Title)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", al.Title, err))
+			continue
+		}
+
+		albumsMu.Lock()
+		labelLookups[al.ID] = true
+		// The label may have been set by hand during the lookup.
+		var updated *album
+		if i := findAlbum(al.ID); i >= 0 && albums[i].Label == "" && (label != "" || catalogNumber != albums[i].CatalogNumber) {
+			albums[i].Label, albums[i].CatalogNumber = label, catalogNumber
+			a := albums[i]
+			updated = &a
+		}
+		albumsMu.Unlock()
+
+		if updated != nil {
+			publishEvent(ctx, topicAlbumUpdated, albumChange{Album: *updated})
+			publishEvent(ctx, topicCacheInvalidation, nil)
+		}
+	}
+	return errors.Join(errs...)
+}
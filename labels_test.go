@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Check labels are read from tags, even when only some files have them
+	body := `[
+		{"path": "a.flac", "title": "Blue Train", "artist": "John Coltrane", "album": "Blue Train", "label": "Blue Note", "catalogNumber": "BLP 1577"},
+		{"path": "b.flac", "title": "Moanin'", "artist": "Art Blakey", "album": "Moanin'"},
+		{"path": "c.flac", "title": "Along Came Betty", "artist": "Art Blakey", "album": "Moanin'", "label": "Blue Note", "catalogNumber": "BLP 4003"},
+		{"path": "d.flac", "title": "Kind of Blue", "artist": "Miles Davis", "album": "Kind of Blue", "label": "Columbia", "catalogNumber": "CL 1355"},
+		{"path": "e.flac", "title": "Jeru", "artist": "Gerry Mulligan", "album": "Jeru"}
+	]`
+	performRequest(router, "POST", "/library/import", strings.NewReader(body), "alice")
+	rr := performRequest(router, "GET", "/labels", nil, "")
+	var labels []recordLabel
+	json.Unmarshal(rr.Body.Bytes(), &labels)
+	if len(labels) != 2 || labels[0] != (recordLabel{ID: "blue-note", Name: "Blue Note", Albums: 2}) {
+		t.Errorf("Expected Blue Note and Columbia, but got %+v", labels)
+	}
+
+	// Check a label's albums are listed by catalog number
+	var moanin string
+	for _, a := range albums {
+		if a.Title == "Moanin'" {
+			moanin = a.ID
+		}
+	}
+	performRequest(router, "PATCH", "/albums/"+moanin, strings.NewReader(`{"catalogNumber": "BLP 4003 "}`), "alice")
+	rr = performRequest(router, "GET", "/labels/blue-note/albums", nil, "")
+	var list []album
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 2 || list[0].Title != "Blue Train" || list[1].CatalogNumber != "BLP 4003" {
+		t.Errorf("Expected Blue Train then Moanin', but got %+v", list)
+	}
+	if rr = performRequest(router, "GET", "/labels/impulse/albums", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check albums can be searched by label
+	rr = performRequest(router, "GET", "/albums?q=label:columbia", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Title != "Kind of Blue" {
+		t.Errorf("Expected Kind of Blue, but got %+v", list)
+	}
+}
+
+func TestLabelLookup(t *testing.T) {
+	resetCatalog(t)
+	albums = []album{
+		{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan"},
+		{ID: "2", Title: "Blue Train", Artist: "John Coltrane", Label: "Blue Note"},
+	}
+
+	// Serve a fake MusicBrainz that knows the label of Jeru
+	lookups := 0
+	mb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if r.URL.Path != "/release" || !strings.Contains(r.URL.Query().Get("query"), `release:"Jeru"`) {
+			t.Errorf("Expected a release search for Jeru, but got %s", r.URL)
+		}
+		w.Write([]byte(`{"releases": [{"label-info": [{"catalog-number": "CS 8732", "label": {"name": "Columbia"}}]}]}`))
+	}))
+	defer mb.Close()
+	previous, pause := musicBrainz, musicBrainzPause
+	musicBrainz, musicBrainzPause = newMusicBrainzClient(mb.URL), 0
+	t.Cleanup(func() { musicBrainz, musicBrainzPause = previous, pause })
+
+	// Check only albums without a label are looked up, and only once, and
+	// other instances hear of the label
+	published := recordEvents(t, topicAlbumUpdated)
+	if err := lookUpLabels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if albums[0].Label != "Columbia" || albums[0].CatalogNumber != "CS 8732" {
+		t.Errorf("Expected Columbia CS 8732, but got %+v", albums[0])
+	}
+	var change albumChange
+	if len(*published) != 1 || json.Unmarshal((*published)[0].Payload, &change) != nil || change.Album.Label != "Columbia" {
+		t.Errorf("Expected the label of Jeru published, but got %v", *published)
+	}
+	albums[0].Label = ""
+	lookUpLabels(context.Background())
+	if lookups != 1 {
+		t.Errorf("Expected one lookup, but got %d", lookups)
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"BLP 1577", "BLP 4003", true},
+		{"ST-10", "ST-9", false},
+		{"ST-9", "ST-10", true},
+		{"A 1", "A 1", false},
+		{"A", "A 1", true},
+	} {
+		if got := naturalLess(tc.a, tc.b); got != tc.want {
+			t.Errorf("Expected %q < %q to be %v, but got %v", tc.a, tc.b, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// lease is a named lock held by one instance at a time until it expires.
+type lease interface {
+	// Acquire takes the lease for holder, or extends it if holder already
+	// has it, and reports whether holder owns the lease afterwards.
+	Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key, holder string) error
+}
+
+// newLease returns a Redis lease when a Redis URL is configured. Without
+// Redis there is only one instance, so an in-process lease is enough.
+func newLease(cfg config) (lease, error) {
+	if cfg.RedisURL == "" {
+		return newMemoryLease(), nil
+	}
+	client, err := newRedisClient(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisLease{client: client}, nil
+}
+
+// leaderElector keeps campaigning for a single lease so that work which must
+// run on exactly one node, such as scheduled jobs, can check IsLeader.
+type leaderElector struct {
+	lease  lease
+	key    string
+	holder string
+	ttl    time.Duration
+
+	leader atomic.Bool
+}
+
+var elector = &leaderElector{lease: newMemoryLease(), key: "leader", holder: instanceID, ttl: 15 * time.Second}
+
+func (l *leaderElector) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// Run campaigns for leadership until ctx is cancelled, then steps down.
+func (l *leaderElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			if l.leader.Swap(false) {
+				l.lease.Release(context.Background(), l.key, l.holder)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *leaderElector) campaign(ctx context.Context) {
+	ok, err := l.lease.Acquire(ctx, l.key, l.holder, l.ttl)
+	if err != nil {
+		log.Printf("leader: acquiring %s: %v", l.key, err)
+		ok = false
+	}
+	if was := l.leader.Swap(ok); was != ok {
+		log.Printf("leader: instance %s leader=%t", l.holder, ok)
+	}
+}
+
+type memoryLeaseEntry struct {
+	holder  string
+	expires time.Time
+}
+
+type memoryLease struct {
+	mu     sync.Mutex
+	leases map[string]memoryLeaseEntry
+}
+
+func newMemoryLease() *memoryLease {
+	return &memoryLease{leases: make(map[string]memoryLeaseEntry)}
+}
+
+func (m *memoryLease) Acquire(_ context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := m.leases[key]; ok && e.holder != holder && now.Before(e.expires) {
+		return false, nil
+	}
+	m.leases[key] = memoryLeaseEntry{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLease) Release(_ context.Context, key, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.leases[key]; ok && e.holder == holder {
+		delete(m.leases, key)
+	}
+	return nil
+}
+
+// acquireScript extends the lease if holder owns it, or takes it if free.
+var acquireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if holder still owns it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLease struct {
+	client *redis.Client
+}
+
+func (r *redisLease) Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	n, err := acquireScript.Run(ctx, r.client, []string{"lease:" + key}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *redisLease) Release(ctx context.Context, key, holder string) error {
+	return releaseScript.Run(ctx, r.client, []string{"lease:" + key}, holder).Err()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Only one holder can own a lease until it is released
+func TestLeaseBackends(t *testing.T) {
+	// Start an in-process Redis server for the Redis lease
+	mr := miniredis.RunT(t)
+	redisBackend, err := newLease(config{RedisURL: "redis://" + mr.Addr()})
+	if err != nil {
+		t.Fatalf("Failed to create redis lease: %s", err.Error())
+	}
+
+	backends := map[string]lease{"memory": newMemoryLease(), "redis": redisBackend}
+	for name, l := range backends {
+		ctx := context.Background()
+
+		// Check the first holder wins and can renew
+		if ok, _ := l.Acquire(ctx, "leader", "a", time.Minute); !ok {
+			t.Errorf("%s: Expected a to acquire the lease", name)
+		}
+		if ok, _ := l.Acquire(ctx, "leader", "a", time.Minute); !ok {
+			t.Errorf("%s: Expected a to renew the lease", name)
+		}
+
+		// Check a second holder is refused while the lease is held
+		if ok, _ := l.Acquire(ctx, "leader", "b", time.Minute); ok {
+			t.Errorf("%s: Expected b to be refused", name)
+		}
+
+		// Check that releasing is ignored for other holders and works for the owner
+		l.Release(ctx, "leader", "b")
+		if ok, _ := l.Acquire(ctx, "leader", "b", time.Minute); ok {
+			t.Errorf("%s: Expected b to be refused after its own release", name)
+		}
+		l.Release(ctx, "leader", "a")
+		if ok, _ := l.Acquire(ctx, "leader", "b", time.Minute); !ok {
+			t.Errorf("%s: Expected b to acquire the released lease", name)
+		}
+	}
+}
+
+// Exactly one of two electors sharing a lease becomes leader
+func TestLeaderElection(t *testing.T) {
+	// Create two electors competing for the same lease
+	shared := newMemoryLease()
+	a := &leaderElector{lease: shared, key: "leader", holder: "a", ttl: time.Minute}
+	b := &leaderElector{lease: shared, key: "leader", holder: "b", ttl: time.Minute}
+
+	// Campaign with both and check only the first wins
+	ctx := context.Background()
+	a.campaign(ctx)
+	b.campaign(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Errorf("Expected only a to lead, but got a=%t b=%t", a.IsLeader(), b.IsLeader())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const variousArtists = "Various Artists"
+
+// trackTags is what the scanner reads from an audio file.
+type trackTags struct {
+	Path         string  `json:"path"`
+	Title        string  `json:"title"`
+	Artist       string  `json:"artist"`
+	AlbumArtist  string  `json:"albumArtist"`
+	Album        string  `json:"album"`
+	Compilation  bool    `json:"compilation"`
+	Disc         int     `json:"disc"`
+	DiscSubtitle string  `json:"discSubtitle"`
+	Track        int     `json:"track"`
+	Year         int     `json:"year"`
+	Genre        string  `json:"genre"`
+	Duration     float64 `json:"duration"`
+	Size         int64   `json:"size"`
+	Format       string  `json:"format"`
+	Bitrate      int     `json:"bitrate"`
+
+	// Label and CatalogNumber come from the LABEL and CATALOGNUMBER
+	// tags, or their ID3 equivalents TPUB and TXXX:CATALOGNUMBER.
+	Label         string `json:"label"`
+	CatalogNumber string `json:"catalogNumber"`
+}
+
+// albumArtist decides which artist a file's album belongs to. Compilations
+// are grouped under "Various Artists" rather than under each track artist,
+// so they come out as one album instead of one album per track.
+func (t trackTags) albumArtist() string {
+	switch {
+	case t.AlbumArtist != "":
+		return t.AlbumArtist
+	case t.Compilation:
+		return variousArtists
+	default:
+		return t.Artist
+	}
+}
+
+func (t trackTags) isCompilation() bool {
+	return t.Compilation || strings.EqualFold(t.albumArtist(), variousArtists)
+}
+
+type importResult struct {
+	AlbumsCreated int      `json:"albumsCreated"`
+	TracksAdded   int      `json:"tracksAdded"`
+	TracksSkipped int      `json:"tracksSkipped"`
+	AlbumIDs      []string `json:"albumIds"`
+}
+
+// importTracks files scanned tracks into albums keyed by album artist and
+// title, creating albums as needed. Files already in the library are
+// skipped. Callers must hold albumsMu.
+func importTracks(scanned []trackTags) importResult {
+	result := importResult{AlbumIDs: []string{}}
+	now := time.Now().UTC()
+	known := make(map[string]bool)
+	for _, t := range tracks {
+		if t.Path != "" {
+			known[t.Path] = true
+		}
+	}
+	byKey := make(map[string]int)
+	for i, a := range albums {
+		byKey[albumKey(a.Artist, a.Title)] = i
+	}
+
+	for _, tags := range scanned {
+		if tags.Album == "" || tags.Title == "" || tags.Path != "" && known[tags.Path] {
+			result.TracksSkipped++
+			continue
+		}
+		key := albumKey(tags.albumArtist(), tags.Album)
+		i, ok := byKey[key]
+		if !ok {
+			a := album{ID: newID(), Title: tags.Album, Artist: tags.albumArtist(), Year: tags.Year, Genre: tags.Genre,
+				Label: strings.TrimSpace(tags.Label), CatalogNumber: strings.TrimSpace(tags.CatalogNumber)}
+			if tags.isCompilation() {
+				a.Type = "compilation"
+			}
+			setAlbumSlug(&a, "")
+			albums = append(albums, a)
+			libStats.addAlbum(a)
+			apPublishAlbum(a)
+			fireHook(hookAlbumCreated, a)
+			outbox.add(hookAlbumCreated, a)
+			i = len(albums) - 1
+			byKey[key] = i
+			result.AlbumsCreated++
+			result.AlbumIDs = append(result.AlbumIDs, a.ID)
+		} else if albums[i].Label == "" && tags.Label != "" {
+			// Labels are often tagged on only some files of an album.
+			albums[i].Label = strings.TrimSpace(tags.Label)
+			albums[i].CatalogNumber = strings.TrimSpace(tags.CatalogNumber)
+		}
+
+		t := track{
+			ID:           strconv.Itoa(nextTrackID),
+			AlbumID:      albums[i].ID,
+			Title:        tags.Title,
+			Artist:       tags.Artist,
+			Disc:         tags.Disc,
+			DiscSubtitle: tags.DiscSubtitle,
+			Number:       tags.Track,
+			Duration:     tags.Duration,
+			Path:         tags.Path,
+			Size:         tags.Size,
+			Format:       tags.Format,
+			Bitrate:      tags.Bitrate,
+			AddedAt:      &now,
+		}
+		tracks = append(tracks, t)
+		libStats.addTrack(t)
+		nextTrackID++
+		known[tags.Path] = true
+		result.TracksAdded++
+	}
+	return result
+}
+
+func albumKey(artist, title string) string {
+	return slugify(artist) + "/" + strings.ToLower(strings.TrimSpace(title))
+}
+
+// postLibraryImport files a batch of scanned tracks into the library.
+func postLibraryImport(c *gin.Context) {
+	var scanned []trackTags
+
+	if err := c.BindJSON(&scanned); err != nil {
+		return
+	}
+
+	albumsMu.Lock()
+	result := importTracks(scanned)
+	albumsMu.Unlock()
+
+	publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	fireHook(hookScanComplete, result)
+	if result.TracksAdded > 0 {
+		tasks.runSoon("thumbnails")
+		notifyAdmins(notifyScanFinished, "Library import finished", fmt.Sprintf("%d tracks added, %d new albums, %d skipped.", result.TracksAdded, result.AlbumsCreated, result.TracksSkipped), "/browse/recently-added")
+	}
+	c.IndentedJSON(http.StatusOK, result)
+}
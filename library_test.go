@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Compilations are imported as one album and multi-disc albums keep their discs
+func TestLibraryImportGroupsCompilationsAndDiscs(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Import a compilation with three track artists and a two-disc album
+	body := `[
+		{"path": "a.flac", "title": "So What", "artist": "Miles Davis", "album": "Jazz Classics", "compilation": true, "track": 1},
+		{"path": "b.flac", "title": "Take Five", "artist": "Dave Brubeck", "album": "Jazz Classics", "compilation": true, "track": 2},
+		{"path": "c.flac", "title": "Moanin'", "artist": "Art Blakey", "albumArtist": "Various Artists", "album": "Jazz Classics", "track": 3},
+		{"path": "d.flac", "title": "Part 2", "artist": "Keith Jarrett", "album": "The Köln Concert", "disc": 2, "discSubtitle": "Encore", "track": 1},
+		{"path": "e.flac", "title": "Part 1", "artist": "Keith Jarrett", "album": "The Köln Concert", "disc": 1, "track": 1}
+	]`
+	rr := performRequest(router, "POST", "/library/import", strings.NewReader(body), "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var result importResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+
+	// Check only two albums were created
+	if result.AlbumsCreated != 2 || result.TracksAdded != 5 {
+		t.Fatalf("Expected 2 albums and 5 tracks, but got %+v", result)
+	}
+	compilation := albums[findAlbum(result.AlbumIDs[0])]
+	if compilation.Artist != variousArtists || compilation.Type != "compilation" {
+		t.Errorf("Expected a Various Artists compilation, but got %+v", compilation)
+	}
+
+	// Check the concert lists its discs in order with the subtitle
+	rr = performRequest(router, "GET", "/albums/"+result.AlbumIDs[1]+"/tracks", nil, "")
+	var discs []disc
+	json.Unmarshal(rr.Body.Bytes(), &discs)
+	if len(discs) != 2 || discs[0].Tracks[0].Title != "Part 1" || discs[1].Subtitle != "Encore" {
+		t.Errorf("Expected two ordered discs, but got %+v", discs)
+	}
+
+	// Check importing the same files again adds nothing
+	rr = performRequest(router, "POST", "/library/import", strings.NewReader(body), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if result.TracksAdded != 0 || result.TracksSkipped != 5 {
+		t.Errorf("Expected all tracks to be skipped, but got %+v", result)
+	}
+}
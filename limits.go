@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shedRetryAfter is how long clients turned away by a concurrency limit are
+// asked to wait.
+const shedRetryAfter = 5 * time.Second
+
+// streamRoutes are left out of the overall request limit, so that playback
+// stays responsive when the server is saturated with other work.
+var streamRoutes = map[string]bool{
+	"/tracks/:id/stream":               true,
+	"/downloads/tracks/:id":            true,
+	"/embed/:trackId/stream":           true,
+	"/s/:token/tracks/:trackId/stream": true,
+	"/albums/:id/cover":                true,
+	"/artists/:id/image":               true,
+	"/display/events":                  true,
+}
+
+// concurrencyLimit bounds how many requests of one kind run at once.
+type concurrencyLimit struct {
+	name string
+	// slots holds a token per running request; nil means no limit.
+	slots chan struct{}
+	shed  atomic.Int64
+}
+
+func newConcurrencyLimit(name string, n int) *concurrencyLimit {
+	l := &concurrencyLimit{name: name}
+	if n > 0 {
+		l.slots = make(chan struct{}, n)
+	}
+	return l
+}
+
+// limitConcurrency lets at most l's number of requests for which applies
+// reports true (all when it is nil) run at once, and turns the rest away
+// with 503 and Retry-After rather than queueing them.
+func limitConcurrency(l *concurrencyLimit, applies func(*gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.slots == nil || (applies != nil && !applies(c)) {
+			c.Next()
+			return
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.shed.Add(1)
+			c.Header("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "server busy: too many " + l.name + " in progress"})
+			return
+		}
+		defer func() { <-l.slots }()
+		c.Next()
+	}
+}
+
+// getLimits reports how busy each concurrency limit is and how many
+// requests it turned away.
+func getLimits(limits []*concurrencyLimit) gin.HandlerFunc {
+	type limitStats struct {
+		Name  string `json:"name"`
+		Limit int    `json:"limit"`
+		InUse int    `json:"inUse"`
+		Shed  int64  `json:"shed"`
+	}
+	return func(c *gin.Context) {
+		stats := make([]limitStats, len(limits))
+		for i, l := range limits {
+			stats[i] = limitStats{Name: l.name, Limit: cap(l.slots), InUse: len(l.slots), Shed: l.shed.Load()}
+		}
+		c.IndentedJSON(http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	l := newConcurrencyLimit("scans", 1)
+	release, running := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(limitConcurrency(l, func(c *gin.Context) bool { return !streamRoutes[c.FullPath()] }))
+	router.GET("/scan", func(c *gin.Context) {
+		running <- struct{}{}
+		<-release
+	})
+	router.GET("/tracks/:id/stream", func(c *gin.Context) {})
+
+	// Check a request over the limit is shed while one is running
+	done := make(chan struct{})
+	go func() {
+		performRequest(router, "GET", "/scan", nil, "")
+		close(done)
+	}()
+	<-running
+	rr := performRequest(router, "GET", "/scan", nil, "")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "5" {
+		t.Errorf("Expected 503 with Retry-After, but got %d %v", rr.Code, rr.Header())
+	}
+
+	// Check streams are not held up by the limit
+	if rr := performRequest(router, "GET", "/tracks/1/stream", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check the slot is given back once the request finishes
+	close(release)
+	<-done
+	go func() { <-running }()
+	if rr := performRequest(router, "GET", "/scan", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if l.shed.Load() != 1 {
+		t.Errorf("Expected 1 shed request, but got %d", l.shed.Load())
+	}
+}
+
+func TestGetLimits(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxScans, cfg.MaxExports = 1, 2
+	router := setupRouter(cfg)
+
+	// Check the admin report lists each limit
+	rr := performRequest(router, "GET", "/admin/limits", nil, "alice")
+	var stats []struct {
+		Name  string
+		Limit int
+	}
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if len(stats) != 4 || stats[2].Name != "scans" || stats[2].Limit != 1 || stats[3].Limit != 2 || stats[0].Limit != 0 {
+		t.Errorf("Expected the four limits, but got %s", rr.Body)
+	}
+}
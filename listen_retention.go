@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dailyPlays is how often a user played a track on one day (UTC). Listens
+// older than listenRetention are kept only in this form.
+type dailyPlays struct {
+	Date    string `json:"date"`
+	TrackID string `json:"trackId"`
+	Plays   int    `json:"plays"`
+}
+
+// listenRollups holds each user's daily aggregates, by date and then
+// track. It is guarded by albumsMu. listenRetention and rollupRetention are
+// set from configuration at startup; zero keeps everything.
+var (
+	listenRollups   = map[string][]dailyPlays{}
+	listenRetention = 90 * 24 * time.Hour
+	rollupRetention time.Duration
+)
+
+// rollUpListens adds listens to a user's daily aggregates. Callers must
+// hold albumsMu.
+func rollUpListens(user string, old []listen) {
+	if len(old) == 0 {
+		return
+	}
+	type key struct{ date, trackID string }
+	plays := map[key]int{}
+	for _, d := range listenRollups[user] {
+		plays[key{d.Date, d.TrackID}] += d.Plays
+	}
+	for _, l := range old {
+		plays[key{l.PlayedAt.UTC().Format(time.DateOnly), l.TrackID}]++
+	}
+	merged := make([]dailyPlays, 0, len(plays))
+	for k, n := range plays {
+		merged = append(merged, dailyPlays{Date: k.date, TrackID: k.trackID, Plays: n})
+	}
+	sortDailyPlays(merged)
+	listenRollups[user] = merged
+}
+
+func sortDailyPlays(days []dailyPlays) {
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Date != days[j].Date {
+			return days[i].Date < days[j].Date
+		}
+		return days[i].TrackID < days[j].TrackID
+	})
+}
+
+// pruneListens is the listen-retention task. It rolls listens older than
+// listenRetention up into daily aggregates, and drops aggregates older than
+// rollupRetention.
+func pruneListens(context.Context) error {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	now := time.Now()
+	if listenRetention > 0 {
+		cutoff := now.Add(-listenRetention)
+		for user, history := range listens {
+			// Reported plays may arrive out of order, so old ones can be
+			// anywhere in the history.
+			var old, kept []listen
+			for _, l := range history {
+				if l.PlayedAt.Before(cutoff) {
+					old = append(old, l)
+				} else {
+					kept = append(kept, l)
+				}
+			}
+			if len(old) == 0 {
+				continue
+			}
+			rollUpListens(user, old)
+			listens[user] = kept
+		}
+	}
+	if rollupRetention > 0 {
+		cutoff := now.Add(-rollupRetention).UTC().Format(time.DateOnly)
+		for user, days := range listenRollups {
+			start := sort.Search(len(days), func(i int) bool { return days[i].Date >= cutoff })
+			if start == len(days) {
+				delete(listenRollups, user)
+				continue
+			}
+			listenRollups[user] = append([]dailyPlays(nil), days[start:]...)
+		}
+	}
+	return nil
+}
+
+// getDailyListens lists how often the user played each track per day,
+// counting both the listens still kept in full and those rolled up.
+func getDailyListens(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	type key struct{ date, trackID string }
+	plays := map[key]int{}
+	for _, d := range listenRollups[user] {
+		plays[key{d.Date, d.TrackID}] += d.Plays
+	}
+	for _, l := range listens[user] {
+		plays[key{l.PlayedAt.UTC().Format(time.DateOnly), l.TrackID}]++
+	}
+	days := make([]dailyPlays, 0, len(plays))
+	for k, n := range plays {
+		days = append(days, dailyPlays{Date: k.date, TrackID: k.trackID, Plays: n})
+	}
+	sortDailyPlays(days)
+	respondList(c, days)
+}
+
+func dumpListenRollups() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(listenRollups, "", "    ")
+}
+
+func restoreListenRollups(data []byte) (func(), int, error) {
+	var restored map[string][]dailyPlays
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string][]dailyPlays{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		listenRollups = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPruneListens(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	savedRetention, savedRollup := listenRetention, rollupRetention
+	t.Cleanup(func() { listenRetention, rollupRetention = savedRetention, savedRollup })
+	listenRetention, rollupRetention = 90*24*time.Hour, 0
+
+	now := time.Now().UTC()
+	old := now.Add(-100 * 24 * time.Hour)
+	listens["bob"] = []listen{
+		{TrackID: "1", PlayedAt: old},
+		{TrackID: "1", PlayedAt: now},
+		{TrackID: "1", PlayedAt: old.Add(time.Minute)},
+		{TrackID: "2", PlayedAt: old},
+	}
+
+	// Check old listens are rolled up per day and recent ones kept in full
+	if err := pruneListens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(listens["bob"]) != 1 || !listens["bob"][0].PlayedAt.Equal(now) {
+		t.Errorf("Expected only the recent listen kept, but got %v", listens["bob"])
+	}
+	day := old.Format(time.DateOnly)
+	want := []dailyPlays{{Date: day, TrackID: "1", Plays: 2}, {Date: day, TrackID: "2", Plays: 1}}
+	if got := listenRollups["bob"]; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, but got %v", want, got)
+	}
+
+	// Check the daily view counts rolled up and recent listens together
+	rr := performRequest(router, "GET", "/listens/daily", nil, "bob")
+	var days []dailyPlays
+	json.Unmarshal(rr.Body.Bytes(), &days)
+	if len(days) != 3 || days[2] != (dailyPlays{Date: now.Format(time.DateOnly), TrackID: "1", Plays: 1}) {
+		t.Errorf("Expected three days of plays, but got %s", rr.Body)
+	}
+
+	// Check a second run merges into the existing aggregates
+	listens["bob"] = append(listens["bob"], listen{TrackID: "1", PlayedAt: old.Add(time.Hour)})
+	pruneListens(context.Background())
+	if listenRollups["bob"][0].Plays != 3 {
+		t.Errorf("Expected 3 plays on %s, but got %v", day, listenRollups["bob"])
+	}
+
+	// Check aggregates past their own retention are dropped
+	rollupRetention = 30 * 24 * time.Hour
+	pruneListens(context.Background())
+	if _, ok := listenRollups["bob"]; ok {
+		t.Errorf("Expected the old aggregates dropped, but got %v", listenRollups["bob"])
+	}
+}
+
+func TestListenOverflowRollsUp(t *testing.T) {
+	resetCatalog(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Check listens beyond the cap are rolled up rather than lost
+	for i := 0; i < maxListens+2; i++ {
+		recordListen("bob", "1", at)
+	}
+	if len(listens["bob"]) != maxListens {
+		t.Errorf("Expected %d listens, but got %d", maxListens, len(listens["bob"]))
+	}
+	if got := listenRollups["bob"]; len(got) != 1 || got[0].Plays != 2 || got[0].Date != "2024-05-01" {
+		t.Errorf("Expected 2 plays rolled up, but got %v", got)
+	}
+}
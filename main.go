@@ -1,7 +1,18 @@
 package main
 
 import (
+	"context"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,8 +22,37 @@ type album struct {
 	Title  string  `json:"title"`
 	Artist string  `json:"artist"`
 	Price  float64 `json:"price"`
+	Genre  string  `json:"genre,omitempty"`
+	Year   int     `json:"year,omitempty"`
+	// Type is one of releaseTypes; empty means a regular album.
+	Type string   `json:"type,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	// Fields holds admin-defined custom metadata, see fieldSchema.
+	Fields map[string]any `json:"fields,omitempty"`
+	// Rating is maintained from reviews and ignored on input.
+	Rating *albumRating `json:"rating,omitempty"`
+	// Slug names the album in URLs; it is generated and ignored on input.
+	Slug string `json:"slug,omitempty"`
+	// Label is the record label that released the album, and
+	// CatalogNumber the label's number for the release.
+	Label         string `json:"label,omitempty"`
+	CatalogNumber string `json:"catalogNumber,omitempty"`
+	// Barcode is the UPC or EAN of a physical release, and DiscogsID the
+	// release on Discogs.
+	Barcode   string `json:"barcode,omitempty"`
+	DiscogsID int    `json:"discogsId,omitempty"`
+	// CoverURL points at the album artwork.
+	CoverURL string `json:"coverUrl,omitempty"`
+	// Blurhash is a placeholder for the cover, filled in by the thumbnail
+	// job and ignored on input.
+	Blurhash string `json:"blurhash,omitempty"`
+	// Book is set on audiobooks.
+	Book *bookInfo `json:"book,omitempty"`
 }
 
+// albumsMu guards albums, which is also written by bus subscribers.
+var albumsMu sync.RWMutex
+
 var albums = []album{
 	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
 	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
@@ -20,36 +60,692 @@ var albums = []album{
 }
 
 func getAlbums(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, albums)
+	filters, err := albumFilters(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if len(filters) == 0 && canStream(c) {
+		streamList(c, catalogCursor(&albums, func(a album) string { return a.ID }, nil))
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if len(filters) == 0 {
+		respondList(c, albums)
+		return
+	}
+	matching := []album{}
+	for _, a := range albums {
+		if matchesAll(a, filters) {
+			matching = append(matching, a)
+		}
+	}
+	respondList(c, matching)
+}
+
+// albumFilters builds the filters requested in the query string of an album
+// listing, including a ?q query; see query.go.
+func albumFilters(c *gin.Context) ([]func(album) bool, error) {
+	var filters []func(album) bool
+	if isGuest(c) {
+		filters = append(filters, func(a album) bool { return visibleTo(c, a) })
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		match, err := albumQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, match)
+	}
+	for _, tag := range c.QueryArray("tag") {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(a album) bool { return hasTag(a.Tags, tag) })
+	}
+	for key, values := range c.Request.URL.Query() {
+		name, ok := strings.CutPrefix(key, "field.")
+		if !ok {
+			continue
+		}
+		for _, want := range values {
+			want := want
+			filters = append(filters, func(a album) bool { return fieldMatches(a.Fields[name], want) })
+		}
+	}
+	return filters, nil
+}
+
+func matchesAll(a album, filters []func(album) bool) bool {
+	for _, f := range filters {
+		if !f(a) {
+			return false
+		}
+	}
+	return true
 }
 
 func postAlbums(c *gin.Context) {
 	var newAlbum album
 
-	if err := c.BindJSON(&newAlbum); err != nil {
+	err := c.BindJSON(&newAlbum)
+	if err != nil {
+		return
+	}
+	// Ids are assigned here; any id in the request is ignored.
+	newAlbum.ID, newAlbum.Slug = newID(), ""
+	newAlbum.Rating, newAlbum.Blurhash = nil, ""
+	if !releaseTypes[newAlbum.Type] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": errBadReleaseType.Error()})
+		return
+	}
+	if newAlbum.Tags, err = normalizeTags(newAlbum.Tags); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
+	created, err := insertAlbum(c.Request.Context(), newAlbum, nil, newPriceChange(c, newAlbum.Price))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, created)
+}
+
+// insertAlbum adds a new album, and any tracks of it, and announces it.
+// The album must have its id; the tracks are given theirs. price starts
+// the album's price history.
+func insertAlbum(ctx context.Context, newAlbum album, albumTracks []track, price *priceChange) (album, error) {
+	change := albumChange{Album: newAlbum, PriceChange: price}
+	albumsMu.Lock()
+	if err := validateFields(newAlbum.Fields); err != nil {
+		albumsMu.Unlock()
+		return album{}, err
+	}
+	setAlbumSlug(&newAlbum, "")
 	albums = append(albums, newAlbum)
-	c.IndentedJSON(http.StatusCreated, newAlbum)
+	libStats.addAlbum(newAlbum)
+	recordPriceChange(newAlbum.ID, *change.PriceChange)
+	for _, t := range albumTracks {
+		t.ID, t.AlbumID = strconv.Itoa(nextTrackID), newAlbum.ID
+		nextTrackID++
+		tracks = append(tracks, t)
+		libStats.addTrack(t)
+	}
+	apPublishAlbum(newAlbum)
+	fireHook(hookAlbumCreated, newAlbum)
+	outbox.add(hookAlbumCreated, newAlbum)
+	albumsMu.Unlock()
+
+	publishEvent(ctx, topicAlbumCreated, change)
+	publishEvent(ctx, topicCacheInvalidation, nil)
+	return newAlbum, nil
 }
 
-func getAlbumById(c *gin.Context) {
-	id := c.Param("id")
+// albumPatch holds the fields a PATCH request may change; nil fields are
+// left as they are. Custom fields are merged, and a null value removes one.
+type albumPatch struct {
+	Title    *string        `json:"title"`
+	Artist   *string        `json:"artist"`
+	Price    *float64       `json:"price"`
+	Genre    *string        `json:"genre"`
+	Year     *int           `json:"year"`
+	Type     *string        `json:"type"`
+	Fields   map[string]any `json:"fields"`
+	CoverURL *string        `json:"coverUrl"`
+	Book     *bookInfo      `json:"book"`
 
-	for _, a := range albums {
-		if a.ID == id {
-			c.IndentedJSON(http.StatusOK, a)
+	Label         *string `json:"label"`
+	CatalogNumber *string `json:"catalogNumber"`
+}
+
+func patchAlbum(c *gin.Context) {
+	var patch albumPatch
+
+	if err := c.BindJSON(&patch); err != nil {
+		return
+	}
+	if patch.Price != nil && *patch.Price < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "price must not be negative"})
+		return
+	}
+	if patch.Type != nil && !releaseTypes[*patch.Type] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": errBadReleaseType.Error()})
+		return
+	}
+
+	albumsMu.Lock()
+	i := findAlbum(c.Param("id"))
+	if i < 0 {
+		albumsMu.Unlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	change := albumChange{Album: albums[i]}
+	if patch.Title != nil {
+		change.Album.Title = *patch.Title
+	}
+	if patch.Artist != nil {
+		change.Album.Artist = *patch.Artist
+	}
+	if patch.Genre != nil {
+		change.Album.Genre = *patch.Genre
+	}
+	if patch.Year != nil {
+		change.Album.Year = *patch.Year
+	}
+	if patch.Type != nil {
+		change.Album.Type = *patch.Type
+	}
+	if patch.CoverURL != nil {
+		change.Album.CoverURL = *patch.CoverURL
+	}
+	if patch.Label != nil {
+		change.Album.Label = strings.TrimSpace(*patch.Label)
+	}
+	if patch.CatalogNumber != nil {
+		change.Album.CatalogNumber = strings.TrimSpace(*patch.CatalogNumber)
+	}
+	if patch.Book != nil {
+		change.Album.Book = patch.Book
+	}
+	if patch.Fields != nil {
+		fields := copyFields(change.Album.Fields)
+		for name, value := range patch.Fields {
+			if value == nil {
+				delete(fields, name)
+			} else {
+				fields[name] = value
+			}
+		}
+		if err := validateFields(fields); err != nil {
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
 		}
+		change.Album.Fields = fields
+		if len(fields) == 0 {
+			change.Album.Fields = nil
+		}
+	}
+	if patch.Price != nil && *patch.Price != albums[i].Price {
+		change.Album.Price = *patch.Price
+		change.PriceChange = newPriceChange(c, *patch.Price)
+		recordPriceChange(change.Album.ID, *change.PriceChange)
+	}
+	setAlbumSlug(&change.Album, albums[i].Slug)
+	before := albums[i]
+	libStats.replaceAlbum(albums[i], change.Album)
+	albums[i] = change.Album
+	logOperation(c, "album.update", []albumVersions{{Before: &before, After: &change.Album}}, nil)
+	albumsMu.Unlock()
+
+	ctx := c.Request.Context()
+	publishEvent(ctx, topicAlbumUpdated, change)
+	publishEvent(ctx, topicCacheInvalidation, nil)
+	c.IndentedJSON(http.StatusOK, change.Album)
+}
+
+func getAlbumById(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if i := findAlbum(id); i >= 0 {
+		c.IndentedJSON(http.StatusOK, albums[i])
+		return
 	}
 	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
 }
 
-func main() {
-	router := gin.Default()
-	router.GET("/albums", getAlbums)
-	router.GET("/albums/:id", getAlbumById)
+// findAlbum returns the index of the album with the given id, or -1. Callers
+// must hold albumsMu.
+func findAlbum(id string) int {
+	return albumIndex.find(id, len(albums), func(i int) string { return albums[i].ID })
+}
+
+func setupRouter(cfg config) *gin.Engine {
+	router := gin.New()
+	router.Use(requestLogger(cfg.LogFormat), gin.Recovery())
+	requests := newConcurrencyLimit("requests", cfg.MaxRequests)
+	transcodes := newConcurrencyLimit("transcodes", cfg.MaxTranscodes)
+	scans := newConcurrencyLimit("scans", cfg.MaxScans)
+	exports := newConcurrencyLimit("exports", cfg.MaxExports)
+	quotas := newStorageQuotas(cfg)
+	router.Use(limitConcurrency(requests, func(c *gin.Context) bool { return !streamRoutes[c.FullPath()] }))
+	router.Use(rateLimit(cfg.RateLimit, cfg.RateLimitWindow), identify(cfg), resolveLegacyIDs, guestAccess(cfg))
+	router.Use(routeMiddleware()...)
+	transcode := limitConcurrency(transcodes, wantsTranscode)
+	router.GET("/readyz", getReadyz)
+	router.GET("/albums", cacheResponse(cfg.CacheTTL), getAlbums)
+	router.GET("/albums/index", cacheResponse(cfg.CacheTTL), getAlbumIndex)
+	router.GET("/albums/slug/:slug", cacheResponse(cfg.CacheTTL), getAlbumBySlug)
+	router.GET("/albums/:id", cacheResponse(cfg.CacheTTL), getAlbumById)
 	router.POST("/albums", postAlbums)
-	router.Run("localhost:8080")
+	router.POST("/albums/lookup", requireUser(cfg), postAlbumLookup)
+	router.POST("/albums/lookup/confirm", requireUser(cfg), postAlbumDraft)
+	router.POST("/albums/:id/enrich", requireAdmin(cfg), postAlbumEnrich)
+	router.GET("/metadata/providers", requireUser(cfg), getMetadataProviders)
+	router.GET("/metadata/search", requireUser(cfg), getMetadataSearch)
+	router.PATCH("/albums/:id", requireUser(cfg), patchAlbum)
+	router.GET("/albums/:id/price-history", getPriceHistory)
+	router.GET("/albums/:id/related", getRelatedAlbums)
+	router.GET("/albums/:id/reviews", getAlbumReviews)
+	router.GET("/albums/:id/tracks", getAlbumTracks)
+	router.POST("/albums/:id/reviews", requireUser(cfg), postAlbumReview)
+	router.PUT("/albums/:id/tags/:tag", requireUser(cfg), putAlbumTag)
+	router.DELETE("/albums/:id/tags/:tag", requireUser(cfg), deleteAlbumTag)
+	router.GET("/tags", getTags)
+	router.GET("/fields", getFields)
+	router.GET("/artists", getArtists)
+	router.GET("/artists/:id/discography", getDiscography)
+	router.GET("/artists/:id/image", getArtistImage)
+	router.PUT("/artists/:id/follow", requireUser(cfg), putFollow)
+	router.DELETE("/artists/:id/follow", requireUser(cfg), deleteFollow)
+	router.HEAD("/artists/:id/image", getArtistImage)
+	router.GET("/labels", getLabels)
+	router.GET("/labels/:id/albums", getLabelAlbums)
+	router.GET("/tracks", getTracks)
+	router.GET("/tracks/:id", getTrackById)
+	router.PATCH("/tracks/:id", requireAdmin(cfg), patchTrack)
+	router.POST("/tracks/upload", requireUser(cfg), uploadTrack(cfg.LibraryDir, quotas))
+	router.POST("/tracks/uploads", requireUser(cfg), postUpload(cfg.LibraryDir, quotas))
+	router.GET("/tracks/uploads/:id", requireUser(cfg), getUpload)
+	router.PATCH("/tracks/uploads/:id", requireUser(cfg), patchUpload(cfg.LibraryDir))
+	router.DELETE("/tracks/uploads/:id", requireUser(cfg), deleteUpload(cfg.LibraryDir))
+	router.DELETE("/tracks/:id", requireAdmin(cfg), deleteTrack(cfg.TrashDir))
+	router.POST("/tracks/batch-edit", requireAdmin(cfg), postBatchEdit)
+	router.POST("/tracks/batch-edit/:token/undo", requireAdmin(cfg), postBatchUndo)
+	router.GET("/tracks/:id/waveform", getTrackWaveform)
+	router.GET("/tracks/:id/chapters", getTrackChapters)
+	router.GET("/tracks/:id/similar", getSimilarTracks)
+	// Media routes answer HEAD themselves, so it does not read the file.
+	router.GET("/tracks/:id/stream", transcode, getTrackStream)
+	router.HEAD("/tracks/:id/stream", transcode, getTrackStream)
+	router.POST("/streams/negotiate", postStreamNegotiate)
+	router.GET("/streams/resume", requireUser(cfg), getStreamResume)
+	router.GET("/downloads/tracks/:id", getSignedDownload)
+	router.GET("/embed/:trackId", getEmbed)
+	router.GET("/embed/:trackId/stream", getEmbedStream)
+	router.HEAD("/embed/:trackId/stream", getEmbedStream)
+	router.HEAD("/downloads/tracks/:id", getSignedDownload)
+	router.GET("/albums/:id/download-manifest", getAlbumManifest)
+	router.GET("/albums/:id/cover", getAlbumCover)
+	router.HEAD("/albums/:id/cover", getAlbumCover)
+	router.PUT("/tracks/:id/analysis", requireAdmin(cfg), putTrackAnalysis)
+	router.PUT("/tracks/:id/markers", requireAdmin(cfg), putTrackMarkers)
+	router.GET("/tracks/:id/versions", getTrackVersions)
+	router.POST("/tracks/:id/versions", requireAdmin(cfg), postTrackVersion)
+	router.DELETE("/tracks/:id/versions/:versionId", requireAdmin(cfg), deleteTrackVersion)
+	router.GET("/tracks/:id/cues", getTrackCues)
+	router.PUT("/tracks/:id/cues", requireAdmin(cfg), putTrackCues)
+	router.PUT("/tracks/:id/lyrics", requireAdmin(cfg), putTrackLyrics)
+	router.GET("/library/stats", getLibraryStats)
+	router.GET("/export", requireAdmin(cfg), limitConcurrency(exports, nil), getExport)
+	router.GET("/library/issues", requireAdmin(cfg), getLibraryIssues)
+	router.POST("/library/import", requireAdmin(cfg), limitConcurrency(scans, nil), postLibraryImport)
+	router.POST("/library/organize", requireAdmin(cfg), limitConcurrency(scans, nil), organizeLibrary(cfg.LibraryDir))
+	router.POST("/library/infer-tags", requireAdmin(cfg), limitConcurrency(scans, nil), inferTags(cfg.LibraryDir))
+	router.POST("/library/bandcamp", requireAdmin(cfg), limitConcurrency(scans, nil), importBandcamp(cfg.LibraryDir))
+	router.POST("/library/ingest-url", requireUser(cfg), ingestURL(cfg.LibraryDir, cfg.IngestQuota, quotas))
+	router.GET("/library/ingest-url", requireUser(cfg), getIngestJobs)
+	router.GET("/library/ingest-url/:id", requireUser(cfg), getIngestJob)
+	router.GET("/quarantine", requireUser(cfg), getQuarantine(cfg))
+	router.GET("/quarantine/:id", requireUser(cfg), getQuarantinedById(cfg))
+	router.POST("/quarantine/:id/approve", requireAdmin(cfg), approveQuarantined(cfg.LibraryDir))
+	router.POST("/quarantine/:id/reject", requireAdmin(cfg), rejectQuarantined)
+	router.GET("/library/trash", requireAdmin(cfg), getTrash)
+	router.POST("/library/trash/:id/restore", requireAdmin(cfg), restoreTrashed)
+	router.DELETE("/library/trash/:id", requireAdmin(cfg), purgeTrashed)
+	router.GET("/library/storage", requireAdmin(cfg), getStorageStats)
+	router.GET("/audiobooks", getAudiobooks)
+	router.GET("/audiobooks/series", getAudiobookSeries)
+	router.GET("/audiobooks/preferences", requireUser(cfg), getBookPreferences)
+	router.PUT("/audiobooks/preferences", requireUser(cfg), putBookPreferences)
+	router.GET("/audiobooks/:id/progress", requireUser(cfg), getBookProgress)
+	router.PUT("/audiobooks/:id/progress", requireUser(cfg), putBookProgress)
+	router.POST("/audiobooks/:id/resume", requireUser(cfg), postBookResume)
+	router.GET("/search", getSearch)
+	router.GET("/search/suggest", getSuggestions)
+	router.GET("/search/natural", getNaturalSearch)
+	router.GET("/searches", requireUser(cfg), getSavedSearches)
+	router.POST("/searches", requireUser(cfg), postSavedSearch)
+	router.GET("/searches/:id", requireUser(cfg), getSavedSearchById)
+	router.DELETE("/searches/:id", requireUser(cfg), deleteSavedSearch)
+	router.GET("/searches/:id/results", requireUser(cfg), getSavedSearchResults)
+	router.POST("/searches/:id/playlist", requireUser(cfg), postSearchPlaylist)
+	router.GET("/browse/recently-added", getRecentlyAdded)
+	router.GET("/release-groups", getReleaseGroups)
+	router.GET("/release-groups/:id", getReleaseGroupById)
+	router.POST("/release-groups", requireAdmin(cfg), postReleaseGroup)
+	router.PUT("/release-groups/:id", requireAdmin(cfg), putReleaseGroup)
+	router.DELETE("/release-groups/:id", requireAdmin(cfg), deleteReleaseGroup)
+	router.GET("/browse/moods", getMoods)
+	router.GET("/browse/moods/:mood", getMoodTracks)
+	router.GET("/feeds/new-albums.xml", getNewAlbumsRSS)
+	router.GET("/feeds/new-albums.atom", getNewAlbumsAtom)
+	router.GET("/feeds/genres/:genre/new-albums.xml", getNewAlbumsRSS)
+	router.GET("/feeds/genres/:genre/new-albums.atom", getNewAlbumsAtom)
+	router.GET("/browse/recently-played", requireUser(cfg), getRecentlyPlayed)
+	router.POST("/listens", requireUser(cfg), postListen)
+	router.GET("/activity", requireUser(cfg), getActivity(cfg))
+	router.GET("/listens/daily", requireUser(cfg), getDailyListens)
+	router.GET("/playlists", requireUser(cfg), getPlaylists)
+	router.GET("/playlists/:id", requireUser(cfg), getPlaylistById)
+	router.GET("/playlists/:id/download-manifest", requireUser(cfg), getPlaylistManifest)
+	router.POST("/shares", requireUser(cfg), postShare)
+	router.GET("/shares", requireUser(cfg), getShares)
+	router.DELETE("/shares/:id", requireUser(cfg), deleteShare)
+	router.GET("/s/:token", getSharedItem)
+	router.POST("/s/:token", postSharePassword)
+	router.GET("/s/:token/tracks/:trackId/stream", getSharedTrackStream)
+	router.HEAD("/s/:token/tracks/:trackId/stream", getSharedTrackStream)
+	router.GET("/s/:token/cover", getSharedCover)
+	router.HEAD("/s/:token/cover", getSharedCover)
+	router.GET("/wishlist", requireUser(cfg), getWishlist)
+	router.POST("/wishlist", requireUser(cfg), postWish)
+	router.PUT("/wishlist/:id", requireUser(cfg), putWish)
+	router.DELETE("/wishlist/:id", requireUser(cfg), deleteWish)
+	router.GET("/wishlist/calendar", requireUser(cfg), getWishlistCalendarURL)
+	router.GET("/wishlist/price-alerts", requireUser(cfg), getPriceAlerts)
+	router.GET("/collection", requireUser(cfg), getCollection)
+	router.POST("/collection", requireUser(cfg), postCopy)
+	router.PUT("/collection/:id", requireUser(cfg), putCopy)
+	router.DELETE("/collection/:id", requireUser(cfg), deleteCopy)
+	router.GET("/collection/value", requireUser(cfg), getCollectionValue)
+	router.GET("/me/discogs", requireUser(cfg), getMyDiscogs)
+	router.PUT("/me/discogs", requireUser(cfg), putMyDiscogs)
+	router.DELETE("/me/discogs", requireUser(cfg), deleteMyDiscogs)
+	router.POST("/me/discogs/connect", requireUser(cfg), postDiscogsConnect)
+	router.POST("/me/discogs/sync", requireUser(cfg), postDiscogsSync)
+	router.GET("/discogs/callback", getDiscogsCallback)
+	router.GET("/feeds/wishlist.ics", getWishlistCalendar)
+	router.GET("/me/quota", requireUser(cfg), getMyQuota(quotas))
+	router.GET("/me/follows", requireUser(cfg), getFollows)
+	router.GET("/me/releases", requireUser(cfg), getMyReleases)
+	router.GET("/me/events", requireUser(cfg), getMyEvents)
+	router.GET("/display", requireUser(cfg), getDisplay)
+	router.POST("/assistant/intent", requireUser(cfg), claimPlayer, postAssistantIntent)
+	router.GET("/display/events", requireUser(cfg), getDisplayEvents)
+	router.GET("/me/activitypub", requireUser(cfg), getMyActivityPub)
+	router.PUT("/me/activitypub", requireUser(cfg), putMyActivityPub)
+	router.GET("/.well-known/webfinger", getWebFinger)
+	router.GET("/ap/actors/:name", getAPActor)
+	router.POST("/ap/actors/:name/inbox", postAPInbox)
+	router.GET("/ap/actors/:name/outbox", getAPOutbox)
+	router.GET("/ap/actors/:name/followers", getAPFollowers)
+	router.GET("/ap/actors/:name/notes/:id", getAPNote)
+	router.GET("/me/notifications", requireUser(cfg), getNotifications)
+	router.POST("/me/notifications/read", requireUser(cfg), postNotificationsRead)
+	router.POST("/me/notifications/test", requireUser(cfg), postNotificationTest)
+	router.GET("/me/notifications/preferences", requireUser(cfg), getNotifyPrefs)
+	router.PUT("/me/notifications/preferences", requireUser(cfg), putNotifyPrefs)
+	router.POST("/me/notifications/:id/read", requireUser(cfg), postNotificationRead)
+	router.DELETE("/me/notifications/:id", requireUser(cfg), deleteNotification)
+	router.GET("/zones", requireUser(cfg), getZones)
+	router.GET("/zones/:id/gain", requireUser(cfg), getZoneGain)
+	router.PUT("/zones/:id", requireAdmin(cfg), putZone)
+	router.DELETE("/zones/:id", requireAdmin(cfg), deleteZone)
+
+	player := router.Group("/player", requireUser(cfg), claimPlayer)
+	player.GET("", getPlayer)
+	player.GET("/now-playing", getNowPlaying)
+	player.GET("/mix-preview", limitConcurrency(transcodes, nil), getMixPreview)
+	player.POST("/play", postPlay)
+	player.POST("/pause", postPause)
+	player.POST("/next", postNext)
+	player.POST("/previous", postPrevious)
+	player.PUT("/volume", putVolume)
+	player.GET("/shuffle-mode", getShuffleMode)
+	player.PUT("/shuffle-mode", putShuffleMode)
+	player.GET("/repeat-mode", getRepeatMode)
+	player.PUT("/repeat-mode", putRepeatMode)
+	player.GET("/private-listening", getPrivateListening)
+	player.PUT("/private-listening", putPrivateListening)
+	player.GET("/radio", getRadio)
+	player.PUT("/radio", putRadio)
+	player.DELETE("/radio", deleteRadio)
+	player.POST("/chapters/next", postNextChapter)
+	player.POST("/chapters/previous", postPreviousChapter)
+	player.PUT("/chapter", putChapter)
+	player.GET("/queue", getQueue)
+	player.POST("/queue", postQueue)
+	player.DELETE("/queue", deleteQueue)
+	player.GET("/eq", getEQ)
+	player.PUT("/eq/active", putActiveEQ)
+	player.PUT("/eq/presets/:name", putEQPreset)
+	player.DELETE("/eq/presets/:name", deleteEQPreset)
+
+	admin := router.Group("/admin", requireAdmin(cfg))
+	admin.GET("/schedules", getSchedules)
+	admin.GET("/replication", getReplication)
+	admin.POST("/replication", postReplication)
+	admin.POST("/backup", limitConcurrency(exports, nil), postBackup)
+	admin.POST("/restore", postRestore)
+	admin.GET("/bundle", limitConcurrency(exports, nil), getBundle(cfg.LibraryDir))
+	admin.POST("/bundle", postBundle(cfg.LibraryDir))
+	admin.POST("/cache/purge", postCachePurge)
+	admin.GET("/thumbnails", getThumbnailStats)
+	admin.GET("/reviews", getFlaggedReviews)
+	admin.PUT("/reviews/:id/flag", putReviewFlag)
+	admin.PUT("/fields/:name", putField)
+	admin.DELETE("/fields/:name", deleteField)
+	admin.POST("/albums/bulk-delete", bulkDeleteAlbums(cfg.TrashDir))
+	admin.GET("/operations", getOperations)
+	admin.GET("/limits", getLimits([]*concurrencyLimit{requests, transcodes, scans, exports}))
+	admin.GET("/plugins", getPlugins)
+	admin.GET("/outbox", getOutbox)
+	admin.POST("/outbox/retry", postOutboxRetry)
+	admin.GET("/scripts", getScripts)
+	admin.POST("/scripts", postScript)
+	admin.GET("/scripts/:id", getScript)
+	admin.PUT("/scripts/:id", putScript)
+	admin.DELETE("/scripts/:id", deleteScript)
+	admin.POST("/scripts/:id/test", postScriptTest)
+	admin.POST("/import/remote", limitConcurrency(scans, nil), postRemoteImport)
+	admin.POST("/undo/:id", undoOperation(cfg.TrashDir, false))
+	admin.POST("/redo/:id", undoOperation(cfg.TrashDir, true))
+	router.NoRoute(unroutedMethods(router))
+	return router
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{"tui": runTUI, "export": runExport, "import": runImport}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	setupLogging(cfg.LogFormat)
+	// Listen before dropping privileges so low ports can still be bound.
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyProcessSettings(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// Background workers stop when SIGTERM or SIGINT cancels ctx, which
+	// also starts draining requests.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	var workers sync.WaitGroup
+	background := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	if sharedCache, err = newCache(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if bus, err = newEventBus(cfg); err != nil {
+		log.Fatal(err)
+	}
+	subscribeAlbumEvents(bus)
+	subscribeStreamEvents(bus)
+	if rb, ok := bus.(*redisBus); ok {
+		background(rb.Run)
+	}
+	if cfg.MusicBrainzURL != "off" {
+		musicBrainz = newMusicBrainzClient(cfg.MusicBrainzURL)
+	}
+	var analyzers combinedAnalyzer
+	if len(cfg.Analyzer) > 0 {
+		analyzers = append(analyzers, commandAnalyzer{command: cfg.Analyzer[0], args: cfg.Analyzer[1:]})
+	}
+	if cfg.FFmpeg != "" {
+		analyzers = append(analyzers, silenceAnalyzer{ffmpeg: cfg.FFmpeg}, waveformAnalyzer{ffmpeg: cfg.FFmpeg}, chapterAnalyzer{ffmpeg: cfg.FFmpeg})
+		tagExtractor = ffmpegTagReader{ffmpeg: cfg.FFmpeg}
+	}
+	if len(analyzers) > 0 {
+		analyzer = analyzers
+	}
+	if len(cfg.VirusScanner) > 0 {
+		virusScanner = commandScanner{command: cfg.VirusScanner[0], args: cfg.VirusScanner[1:]}
+	}
+	if cfg.URLIngest {
+		urlIngester = ytDlp{command: cfg.YtDlp}
+	}
+	if cfg.Replica != "" {
+		store, err := newReplicaStore(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replication = newReplicator(store, cfg.LibraryDir)
+	}
+	trashRetention = cfg.TrashRetention
+	listenRetention, rollupRetention = cfg.ListenRetention, cfg.ListenRollupRetention
+	legacyIDPeriod = cfg.LegacyIDPeriod
+	mediaMaxAge, cdnPurgeURL = cfg.MediaMaxAge, cfg.CDNPurgeURL
+	transcoder = cfg.FFmpeg
+	streamDrainGrace = cfg.StreamDrainGrace
+	publicURL = cfg.PublicURL
+	if cfg.GuestAccess {
+		if guestTag, err = normalizeTag(cfg.GuestTag); err != nil {
+			log.Fatalf("MUSIC_PLAYER_GUEST_TAG: %v", err)
+		}
+	}
+	embedOrigins = cfg.EmbedOrigins
+	scriptHosts = cfg.ScriptHosts
+	for name := range cfg.Admins {
+		adminUsers = append(adminUsers, name)
+	}
+	sort.Strings(adminUsers)
+	smtpConfig = smtpSettings{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.SMTPFrom}
+	if cfg.ConcertsAppID != "" {
+		concerts = newConcertClient(cfg.ConcertsURL, cfg.ConcertsAppID)
+		concertsNear, concertsRadius = cfg.ConcertsNear, float64(cfg.ConcertsRadius)
+	}
+	if cfg.DiscogsToken != "" || cfg.DiscogsConsumerKey != "" {
+		discogs = newDiscogsClient(cfg.DiscogsURL, cfg.DiscogsToken)
+		discogs.consumerKey, discogs.consumerSecret = cfg.DiscogsConsumerKey, cfg.DiscogsConsumerSecret
+	}
+	if cfg.NaturalSearchURL != "" {
+		naturalSearch = newHTTPResolver(cfg.NaturalSearchURL, cfg.NaturalSearchToken)
+	}
+	streamReadahead = cfg.StreamReadahead
+	downloadTTL = cfg.DownloadTTL
+	thumbnailDir = cfg.ThumbnailDir
+	artistImageDir = cfg.ArtistImageDir
+	if cfg.FanartKey != "" {
+		artistImageSources = append(artistImageSources, fanartSource{baseURL: "https://webservice.fanart.tv/v3", key: cfg.FanartKey})
+	}
+	if cfg.SpotifyClientID != "" {
+		artistImageSources = append(artistImageSources, newSpotifySource(cfg.SpotifyClientID, cfg.SpotifyClientSecret))
+	}
+	if musicBrainz != nil {
+		artistImageSources = append(artistImageSources, musicBrainz)
+	}
+	setupMetadataProviders(cfg)
+	if cfg.PluginsDir != "" {
+		if err := loadHookPlugins(cfg.PluginsDir); err != nil {
+			log.Fatalf("MUSIC_PLAYER_PLUGINS_DIR: %v", err)
+		}
+	}
+	if cfg.SigningKey != "" {
+		signingKey = []byte(cfg.SigningKey)
+	}
+	albumsMu.Lock()
+	migrateAlbumIDs(time.Now())
+	assignSlugs()
+	albumsMu.Unlock()
+	if cfg.ActivityPub {
+		if apKey, err = loadAPKey(cfg.ActivityPubKeyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(cfg.EmbeddingModel) > 0 {
+		embedder = commandEmbedder{command: cfg.EmbeddingModel[0], args: cfg.EmbeddingModel[1:]}
+	}
+	if embeddingsFile = cfg.EmbeddingsFile; embeddingsFile != "" {
+		if err := loadEmbeddings(embeddingsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if sinks := newOutboxSinks(cfg); len(sinks) > 0 {
+		outbox = newOutbox(sinks)
+		if cfg.OutboxFile != "" {
+			if err := outbox.open(cfg.OutboxFile); err != nil {
+				log.Fatalf("MUSIC_PLAYER_OUTBOX_FILE: %v", err)
+			}
+		}
+		background(outbox.Run)
+	}
+	if cfg.StateFile != "" {
+		if err := loadPlayerState(cfg.StateFile); err != nil {
+			log.Fatal(err)
+		}
+		background(persistPlayerState(cfg.StateFile))
+	}
+	if elector.lease, err = newLease(cfg); err != nil {
+		log.Fatal(err)
+	}
+	background(elector.Run)
+	if err := tasks.configure(cfg.Schedules); err != nil {
+		log.Fatal(err)
+	}
+	background(tasks.Run)
+	if cfg.MQTTURL != "" {
+		mb, err := newMQTTBridge(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		background(mb.Run)
+	}
+	if cfg.MPRIS {
+		ms, err := newMPRISServer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		background(ms.Run)
+	}
+
+	// The port is already bound, so the warm-up runs while /readyz tells
+	// load balancers to wait.
+	warmupSteps = newWarmupSteps()
+	background(runWarmup(warmupSteps))
+
+	if err := serve(ctx, listener, setupRouter(cfg), cfg.ShutdownTimeout); err != nil {
+		log.Print(err)
+	}
+	stop()
+	workers.Wait()
 }
@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -170,7 +172,6 @@ func test_getAlbums_ReturnsResponseWithCorrectFormatAndKeys(t *testing.T) {
 	}
 
 	// Check if the response body has the correct format and keys
-	expectedResponse := `[{"id":"1","title":"Blue Train","artist":"John Coltrane","price":56.99},{"id":"2","title":"Jeru","artist":"Gerry Mulligan","price":17.99},{"id":"3","title":"Sarah Vaughan and Clifford Brown","artist":"Sarah Vaughan","price":39.99}]`
 	var response []album
 	err := json.Unmarshal(rr.Body.Bytes(), &response)
 	if err != nil {
@@ -183,3 +184,84 @@ func test_getAlbums_ReturnsResponseWithCorrectFormatAndKeys(t *testing.T) {
 		}
 	}
 }
+
+// testConfig returns a configuration with an admin (alice) and a regular user (bob)
+func testConfig() config {
+	return config{
+		Accounts: map[string]string{"alice": "alice-pw", "bob": "bob-pw"},
+		Admins:   map[string]bool{"alice": true},
+	}
+}
+
+// performRequest serves a request through router, authenticating as user when one is given
+func performRequest(router http.Handler, method, path string, body io.Reader, user string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, body)
+	if user != "" {
+		req.SetBasicAuth(user, user+"-pw")
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// resetCatalog restores the catalog and everything recorded about it once the test finishes
+func resetCatalog(t *testing.T) {
+	savedAlbums := append([]album(nil), albums...)
+	savedPriceHistory := priceHistory
+	savedReviews, savedNextReviewID := reviews, nextReviewID
+	savedFieldSchema := fieldSchema
+	savedTracks, savedNextTrackID := tracks, nextTrackID
+	priceHistory = map[string][]priceChange{}
+	reviews = nil
+	fieldSchema = map[string]customField{}
+	tracks = nil
+	savedReleaseGroups, savedLabelLookups, savedCollection := releaseGroups, labelLookups, collection
+	releaseGroups, labelLookups, collection = nil, map[string]bool{}, nil
+	savedDiscogsAccounts, savedDiscogsRequests := discogsAccounts, discogsRequests
+	discogsAccounts, discogsRequests = map[string]discogsAccount{}, map[string]discogsPending{}
+	savedIngestJobs, savedUploads, savedQuarantine := ingestJobs, uploads, quarantine
+	ingestJobs, uploads, quarantine = nil, nil, nil
+	savedTrash, savedObjects := trash, objects
+	trash, objects = nil, map[string]contentObject{}
+	savedWaveforms := waveforms
+	waveforms = map[string][]float64{}
+	savedPlayCounts := playCounts
+	playCounts = map[string]int{}
+	savedListens, savedPlaylists, savedSavedSearches := listens, playlists, savedSearches
+	listens, playlists, savedSearches = map[string][]listen{}, nil, nil
+	savedLegacyIDs, savedStaleSlugs := legacyAlbumIDs, staleSlugs
+	legacyAlbumIDs, staleSlugs = map[string]legacyID{}, map[string]string{}
+	savedOperations, savedNextPlaylistID := operations, nextPlaylistID
+	operations = nil
+	savedListenRollups, savedShares, savedWishlist := listenRollups, shares, wishlist
+	listenRollups, shares, wishlist = map[string][]dailyPlays{}, nil, nil
+	savedFollows, savedArtistReleases, savedReleaseChecks := follows, artistReleases, releaseChecks
+	follows, artistReleases, releaseChecks = map[string][]followedArtist{}, map[string][]artistRelease{}, map[string]time.Time{}
+	savedArtistEvents, savedAPUsers, savedAPFollowers, savedAPOutbox := artistEvents, apUsers, apFollowers, apOutbox
+	artistEvents, apUsers, apFollowers, apOutbox = map[string][]concert{}, map[string]bool{}, map[string][]apFollower{}, map[string][]apPost{}
+	savedPriceAlerts, savedInboxes, savedNotifyPrefs := priceAlerts, inboxes, notifyPreferences
+	priceAlerts, inboxes, notifyPreferences = map[string][]priceAlert{}, map[string][]notification{}, map[string]notifyPrefs{}
+	savedStats := libStats
+	libStats = computeLibraryStats()
+	t.Cleanup(func() {
+		libStats = savedStats
+		operations, nextPlaylistID = savedOperations, savedNextPlaylistID
+		listenRollups, shares, wishlist = savedListenRollups, savedShares, savedWishlist
+		priceAlerts, inboxes, notifyPreferences = savedPriceAlerts, savedInboxes, savedNotifyPrefs
+		follows, artistReleases, releaseChecks = savedFollows, savedArtistReleases, savedReleaseChecks
+		artistEvents, apUsers, apFollowers, apOutbox = savedArtistEvents, savedAPUsers, savedAPFollowers, savedAPOutbox
+		releaseGroups, labelLookups, collection = savedReleaseGroups, savedLabelLookups, savedCollection
+		discogsAccounts, discogsRequests = savedDiscogsAccounts, savedDiscogsRequests
+		ingestJobs, uploads, quarantine = savedIngestJobs, savedUploads, savedQuarantine
+		trash, objects = savedTrash, savedObjects
+		waveforms = savedWaveforms
+		playCounts = savedPlayCounts
+		listens, playlists, savedSearches = savedListens, savedPlaylists, savedSavedSearches
+		legacyAlbumIDs, staleSlugs = savedLegacyIDs, savedStaleSlugs
+		tracks, nextTrackID = savedTracks, savedNextTrackID
+		fieldSchema = savedFieldSchema
+		albums = savedAlbums
+		priceHistory = savedPriceHistory
+		reviews, nextReviewID = savedReviews, savedNextReviewID
+	})
+}
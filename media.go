@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// coverNames are the artwork files looked for next to an album's tracks.
+var coverNames = []string{"cover.jpg", "cover.png", "folder.jpg", "folder.png", "front.jpg", "front.png"}
+
+// mediaMaxAge and cdnPurgeURL are set from configuration at startup.
+// mediaGeneration is part of every media ETag, so a purge makes caches
+// that revalidate fetch files again.
+var (
+	mediaMaxAge     = 24 * time.Hour
+	cdnPurgeURL     string
+	mediaGeneration atomic.Int64
+	purgeClient     = &http.Client{Timeout: 10 * time.Second}
+)
+
+// serveMedia sends a file with headers for shared caches: Cache-Control,
+// an ETag from the file's size and modification time, and Last-Modified.
+// Range and conditional requests are handled by http.ServeContent.
+func serveMedia(c *gin.Context, path string) {
+	f, err := openMedia(path)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(mediaMaxAge.Seconds())))
+	c.Header("ETag", fmt.Sprintf(`"%x-%x-%x"`, info.Size(), info.ModTime().UnixNano(), mediaGeneration.Load()))
+	stream, _ := c.Value(activeStreamKey).(*activeStream)
+	http.ServeContent(mediaWriter{c.Writer, stream}, c.Request, filepath.Base(path), info.ModTime(), f)
+}
+
+// getTrackStream sends a track's audio file, or with ?codec or ?karaoke a
+// transcode of it; see postStreamNegotiate and transcodeStream.
+func getTrackStream(c *gin.Context) {
+	albumsMu.RLock()
+	i := findTrack(c.Param("id"))
+	var path string
+	if i >= 0 {
+		path = tracks[i].Path
+	}
+	albumsMu.RUnlock()
+
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	if path == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track has no file"})
+		return
+	}
+	if wantsTranscode(c) {
+		transcodeStream(c, path)
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		defer startStream(c, c.Param("id"))()
+	}
+	serveMedia(c, path)
+}
+
+// albumCoverFile returns the artwork file in the folder of an album's
+// tracks, or "". Callers must hold albumsMu.
+func albumCoverFile(id string) string {
+	for _, t := range tracks {
+		if t.AlbumID != id || t.Path == "" {
+			continue
+		}
+		for _, name := range coverNames {
+			path := filepath.Join(filepath.Dir(t.Path), name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// getAlbumCover sends the artwork file of an album, or with ?size a
+// thumbnail of it.
+func getAlbumCover(c *gin.Context) {
+	albumsMu.RLock()
+	found := findAlbum(c.Param("id")) >= 0
+	path := albumCoverFile(c.Param("id"))
+	albumsMu.RUnlock()
+
+	if !found {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	if path == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album has no cover file"})
+		return
+	}
+	if c.Query("size") != "" || c.Query("format") != "" || c.Query("blurhash") != "" {
+		serveCoverVariant(c, c.Param("id"), path)
+		return
+	}
+	serveMedia(c, path)
+}
+
+// purgeCDN asks the configured CDN purge hook to drop paths, or everything
+// when paths is empty.
+func purgeCDN(ctx context.Context, paths []string) error {
+	body, err := json.Marshal(map[string][]string{"paths": paths})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cdnPurgeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := purgeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("purge hook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// postCachePurge retires cached media and responses. Media ETags change so
+// revalidating caches fetch again, and the CDN purge hook, when
+// configured, is asked to drop the given paths or everything.
+func postCachePurge(c *gin.Context) {
+	var input struct {
+		Paths []string `json:"paths"`
+	}
+
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+	}
+	mediaGeneration.Add(1)
+	ctx := c.Request.Context()
+	if err := invalidateResponses(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	if cdnPurgeURL != "" {
+		if err := purgeCDN(ctx, input.Paths); err != nil {
+			c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"generation": mediaGeneration.Load(), "cdnPurged": cdnPurgeURL != ""})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mediaBufferSize is the size of the pooled buffers media is copied
+// through when the connection cannot use sendfile.
+const mediaBufferSize = 64 << 10
+
+// mediaChunkSize is how much of a file is sent with sendfile at a time
+// when the stream's progress is followed.
+const mediaChunkSize = 256 << 10
+
+var mediaBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, mediaBufferSize)
+	return &buf
+}}
+
+// streamReadahead is set from configuration at startup; it tells the kernel
+// media files are read front to back, so it reads further ahead.
+var streamReadahead bool
+
+// mediaWriter hands the copy http.ServeContent makes to the connection,
+// which sends a file with sendfile where it can, and otherwise copies it
+// through a pooled buffer instead of a fresh one per response. Files are
+// read in pieces either way, never whole. With a stream, its offset is kept
+// current and the copy stops when shutdown cuts it short.
+type mediaWriter struct {
+	gin.ResponseWriter
+	stream *activeStream
+}
+
+func (w mediaWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.WriteHeaderNow()
+	if w.stream != nil {
+		w.stream.begin(w.Status(), w.Header().Get("ETag"))
+	}
+	// The response cache records what is written, so it must see the body.
+	if _, recorded := w.ResponseWriter.(*bodyRecorder); !recorded {
+		if rf, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+			if dst, ok := rf.Unwrap().(io.ReaderFrom); ok {
+				if w.stream == nil {
+					return dst.ReadFrom(r)
+				}
+				return w.sendChunks(dst, r)
+			}
+		}
+	}
+	if w.stream == nil {
+		return copyPooled(w.ResponseWriter, r)
+	}
+	return copyPooled(streamWriter{w.ResponseWriter, w.stream}, r)
+}
+
+// sendChunks sends r to dst mediaChunkSize bytes at a time, moving the
+// stream's offset on after each and stopping when it is cut short. Each
+// chunk still reads straight from the file, so sendfile applies.
+func (w mediaWriter) sendChunks(dst io.ReaderFrom, r io.Reader) (int64, error) {
+	src, remain := r, int64(math.MaxInt64)
+	lr, limited := r.(*io.LimitedReader)
+	if limited {
+		src, remain = lr.R, lr.N
+	}
+	var total int64
+	for remain > 0 {
+		if w.stream.stopped() {
+			return total, errStreamInterrupted
+		}
+		want := min(remain, mediaChunkSize)
+		n, err := dst.ReadFrom(&io.LimitedReader{R: src, N: want})
+		total += n
+		remain -= n
+		w.stream.sent(n)
+		if limited {
+			lr.N = remain
+		}
+		if err != nil || n < want {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// streamWriter moves a stream's offset on as its body is written, failing
+// once the stream is cut short.
+type streamWriter struct {
+	w io.Writer
+	s *activeStream
+}
+
+func (w streamWriter) Write(b []byte) (int, error) {
+	if w.s.stopped() {
+		return 0, errStreamInterrupted
+	}
+	n, err := w.w.Write(b)
+	w.s.sent(int64(n))
+	return n, err
+}
+
+// copyPooled copies r to w through a buffer from mediaBuffers.
+func copyPooled(w io.Writer, r io.Reader) (int64, error) {
+	buf := mediaBuffers.Get().(*[]byte)
+	defer mediaBuffers.Put(buf)
+	// Hide any ReadFrom of w from io.CopyBuffer, which would use it instead.
+	return io.CopyBuffer(struct{ io.Writer }{w}, r, *buf)
+}
+
+// openMedia opens a media file for streaming, advising readahead when it is
+// configured.
+func openMedia(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err == nil && streamReadahead {
+		adviseSequential(f)
+	}
+	return f, err
+}
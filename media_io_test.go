@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mediaFile writes size random bytes to a track file and points track 1 at
+// it, returning the contents.
+func mediaFile(tb testing.TB, size int) []byte {
+	dir := tb.TempDir()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	os.WriteFile(filepath.Join(dir, "01.flac"), data, 0o644)
+	savedAlbums, savedTracks := albums, tracks
+	tb.Cleanup(func() { albums, tracks = savedAlbums, savedTracks })
+	albums = []album{{ID: "1", Title: "Blue Train"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Path: filepath.Join(dir, "01.flac")}}
+	return data
+}
+
+func TestMediaWriter(t *testing.T) {
+	data := mediaFile(t, 3*mediaBufferSize+17)
+	server := httptest.NewServer(setupRouter(testConfig()))
+	defer server.Close()
+
+	// Check a real connection gets the whole file and ranges of it
+	resp, err := http.Get(server.URL + "/tracks/1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, data) {
+		t.Errorf("Expected %d bytes of the file, but got %d", len(data), len(body))
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/tracks/1/stream", nil)
+	req.Header.Set("Range", "bytes=70000-70009")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[70000:70010]) {
+		t.Errorf("Expected bytes 70000-70009, but got %d %v", resp.StatusCode, body)
+	}
+
+	// Check writers without ReadFrom are copied through the pooled buffers,
+	// with readahead advised
+	streamReadahead = true
+	t.Cleanup(func() { streamReadahead = false })
+	rr := performRequest(setupRouter(testConfig()), "GET", "/tracks/1/stream", nil, "")
+	if !bytes.Equal(rr.Body.Bytes(), data) {
+		t.Errorf("Expected %d bytes of the file, but got %d", len(data), rr.Body.Len())
+	}
+}
+
+func BenchmarkStreamTrack(b *testing.B) {
+	data := mediaFile(b, 8<<20)
+	server := httptest.NewServer(setupRouter(testConfig()))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := client.Get(server.URL + "/tracks/1/stream")
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	})
+}
+
+func BenchmarkStreamTrackPooled(b *testing.B) {
+	data := mediaFile(b, 8<<20)
+	router := setupRouter(testConfig())
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		req, _ := http.NewRequest("GET", "/tracks/1/stream", nil)
+		for pb.Next() {
+			router.ServeHTTP(discardWriter{header: http.Header{}}, req)
+		}
+	})
+}
+
+// discardWriter is a ResponseWriter without ReadFrom that drops the body,
+// so benchmarks measure the server side of a copy.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
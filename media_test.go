@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMediaCaching(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "01.flac"), []byte("0123456789"), 0o644)
+	os.WriteFile(filepath.Join(dir, "folder.jpg"), []byte("jpeg"), 0o644)
+	albums = []album{{ID: "1", Title: "Blue Train"}, {ID: "2", Title: "Jeru"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Path: filepath.Join(dir, "01.flac")}, {ID: "2", AlbumID: "2"}}
+
+	// Check streams carry cache headers
+	rr := performRequest(router, "GET", "/tracks/1/stream", nil, "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || rr.Body.String() != "0123456789" {
+		t.Errorf("Expected the file, but got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Cache-Control") != "public, max-age=86400" || etag == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected caching headers, but got %v", rr.Header())
+	}
+
+	// Check ranges and conditional requests
+	req, _ := http.NewRequest("GET", "/tracks/1/stream", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("Expected bytes 2-4, but got %d %q", w.Code, w.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "/tracks/1/stream", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotModified, w.Code)
+	}
+
+	// Check HEAD does not send the file
+	rr = performRequest(router, "HEAD", "/tracks/1/stream", nil, "")
+	if rr.Body.Len() != 0 || rr.Header().Get("Content-Length") != "10" {
+		t.Errorf("Expected only headers, but got %v %q", rr.Header(), rr.Body.String())
+	}
+
+	// Check covers are found next to the tracks
+	rr = performRequest(router, "GET", "/albums/1/cover", nil, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "jpeg" {
+		t.Errorf("Expected the cover, but got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := performRequest(router, "GET", "/albums/2/cover", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check a purge changes ETags and calls the CDN hook
+	var purged []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Paths []string }
+		json.NewDecoder(r.Body).Decode(&body)
+		purged = body.Paths
+	}))
+	defer hook.Close()
+	savedPurgeURL := cdnPurgeURL
+	cdnPurgeURL = hook.URL
+	t.Cleanup(func() { cdnPurgeURL = savedPurgeURL })
+	if rr := performRequest(router, "POST", "/admin/cache/purge", strings.NewReader(`{"paths": ["/tracks/1/stream"]}`), "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	rr = performRequest(router, "POST", "/admin/cache/purge", strings.NewReader(`{"paths": ["/tracks/1/stream"]}`), "alice")
+	if rr.Code != http.StatusOK || len(purged) != 1 || purged[0] != "/tracks/1/stream" {
+		t.Errorf("Expected the hook to be called, but got %d %v", rr.Code, purged)
+	}
+	rr = performRequest(router, "GET", "/tracks/1/stream", nil, "")
+	if rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected a new ETag after the purge")
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metadataQuery asks for releases by barcode, or by artist and title.
+type metadataQuery struct {
+	Barcode string `json:"barcode,omitempty"`
+	Artist  string `json:"artist,omitempty"`
+	Title   string `json:"title,omitempty"`
+}
+
+// metadataProvider is a source of album metadata. Discogs and MusicBrainz
+// are built in. Others are compiled in from a file of their own that calls
+// registerMetadataProvider from an init function, or run as external
+// programs listed in MUSIC_PLAYER_METADATA_PLUGINS; see commandProvider.
+type metadataProvider interface {
+	// Name identifies the provider in responses.
+	Name() string
+	// Lookup returns the releases matching q, best first. Providers that
+	// cannot search the way q asks return none.
+	Lookup(ctx context.Context, q metadataQuery) ([]albumDraft, error)
+	// Enrich returns what the provider knows about an album, leaving
+	// what it does not know empty.
+	Enrich(ctx context.Context, a album) (albumDraft, error)
+	// CoverArt returns the URL of the album's front cover, or "" when the
+	// provider knows none.
+	CoverArt(ctx context.Context, a album) (string, error)
+}
+
+// metadataPlugins are the compiled-in providers by name. Their factories
+// return nil when the provider is not configured.
+var metadataPlugins = map[string]func(cfg config) metadataProvider{}
+
+// registerMetadataProvider adds a compiled-in provider. It is meant to be
+// called from init functions, and panics on a name taken twice.
+func registerMetadataProvider(name string, factory func(cfg config) metadataProvider) {
+	if _, taken := metadataPlugins[name]; taken {
+		panic("metadata provider " + name + " is registered twice")
+	}
+	metadataPlugins[name] = factory
+}
+
+// extraProviders are the compiled-in and external providers set up at
+// startup, asked after the built-in ones.
+var extraProviders []metadataProvider
+
+// setupMetadataProviders sets up the configured compiled-in providers, in
+// order of name, followed by the external ones in the order they are
+// listed.
+func setupMetadataProviders(cfg config) {
+	names := make([]string, 0, len(metadataPlugins))
+	for name := range metadataPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	extraProviders = nil
+	for _, name := range names {
+		if p := metadataPlugins[name](cfg); p != nil {
+			extraProviders = append(extraProviders, p)
+		}
+	}
+	for _, plugin := range cfg.MetadataPlugins {
+		name, command, _ := strings.Cut(plugin, ":")
+		args := strings.Fields(command)
+		extraProviders = append(extraProviders, commandProvider{name: name, command: args[0], args: args[1:]})
+	}
+}
+
+// metadataProviders returns the providers in the order they are asked.
+// Discogs comes first, since it knows more physical pressings.
+func metadataProviders() []metadataProvider {
+	var providers []metadataProvider
+	if discogs != nil {
+		providers = append(providers, discogs)
+	}
+	if musicBrainz != nil {
+		providers = append(providers, musicBrainz)
+	}
+	return append(providers, extraProviders...)
+}
+
+func (d *discogsClient) Name() string {
+	return "discogs"
+}
+
+// Lookup only searches by barcode.
+func (d *discogsClient) Lookup(ctx context.Context, q metadataQuery) ([]albumDraft, error) {
+	if q.Barcode == "" {
+		return nil, nil
+	}
+	draft, found, err := d.releaseByBarcode(ctx, q.Barcode)
+	if !found {
+		return nil, err
+	}
+	return []albumDraft{draft}, nil
+}
+
+// Enrich only knows albums with a barcode.
+func (d *discogsClient) Enrich(ctx context.Context, a album) (albumDraft, error) {
+	if a.Barcode == "" {
+		return albumDraft{}, nil
+	}
+	draft, _, err := d.releaseByBarcode(ctx, a.Barcode)
+	return draft, err
+}
+
+func (d *discogsClient) CoverArt(ctx context.Context, a album) (string, error) {
+	draft, err := d.Enrich(ctx, a)
+	return draft.CoverURL, err
+}
+
+func (m *musicBrainzClient) Name() string {
+	return "musicbrainz"
+}
+
+// Lookup only searches by barcode.
+func (m *musicBrainzClient) Lookup(ctx context.Context, q metadataQuery) ([]albumDraft, error) {
+	if q.Barcode == "" {
+		return nil, nil
+	}
+	draft, found, err := m.releaseByBarcode(ctx, q.Barcode)
+	if !found {
+		return nil, err
+	}
+	return []albumDraft{draft}, nil
+}
+
+// Enrich looks albums up by barcode, and otherwise only finds the label.
+func (m *musicBrainzClient) Enrich(ctx context.Context, a album) (albumDraft, error) {
+	if a.Barcode != "" {
+		draft, _, err := m.releaseByBarcode(ctx, a.Barcode)
+		return draft, err
+	}
+	label, catalogNumber, err := m.releaseLabel(ctx, a.Artist, a.Title)
+	return albumDraft{Label: label, CatalogNumber: catalogNumber}, err
+}
+
+// CoverArt only knows the Cover Art Archive front of albums with a
+// barcode.
+func (m *musicBrainzClient) CoverArt(ctx context.Context, a album) (string, error) {
+	if a.Barcode == "" {
+		return "", nil
+	}
+	draft, _, err := m.releaseByBarcode(ctx, a.Barcode)
+	return draft.CoverURL, err
+}
+
+// commandProvider is an external program serving as a provider, written in
+// any language. It is run with lookup, enrich or cover-art as its last
+// argument and the request as JSON on stdin: a metadataQuery for lookup
+// and an album otherwise. It must print its answer as JSON: an array of
+// album drafts for lookup, one draft for enrich, and {"url": "..."} for
+// cover-art.
+type commandProvider struct {
+	name    string
+	command string
+	args    []string
+}
+
+func (p commandProvider) Name() string {
+	return p.name
+}
+
+func (p commandProvider) call(ctx context.Context, method string, in, out any) error {
+	input, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command, append(p.args, method)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", p.name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return fmt.Errorf("%s: bad output: %w", p.name, err)
+	}
+	return nil
+}
+
+func (p commandProvider) Lookup(ctx context.Context, q metadataQuery) ([]albumDraft, error) {
+	var drafts []albumDraft
+	err := p.call(ctx, "lookup", q, &drafts)
+	return drafts, err
+}
+
+func (p commandProvider) Enrich(ctx context.Context, a album) (albumDraft, error) {
+	var draft albumDraft
+	err := p.call(ctx, "enrich", a, &draft)
+	return draft, err
+}
+
+func (p commandProvider) CoverArt(ctx context.Context, a album) (string, error) {
+	var answer struct {
+		URL string `json:"url"`
+	}
+	err := p.call(ctx, "cover-art", a, &answer)
+	return answer.URL, err
+}
+
+// getMetadataProviders lists the names of the providers, in the order they
+// are asked.
+func getMetadataProviders(c *gin.Context) {
+	names := []string{}
+	for _, p := range metadataProviders() {
+		names = append(names, p.Name())
+	}
+	c.IndentedJSON(http.StatusOK, names)
+}
+
+type providerReleases struct {
+	Provider string       `json:"provider"`
+	Releases []albumDraft `json:"releases"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// getMetadataSearch asks every provider for releases with ?barcode, or
+// with ?artist and ?title.
+func getMetadataSearch(c *gin.Context) {
+	q := metadataQuery{
+		Barcode: strings.ReplaceAll(c.Query("barcode"), " ", ""),
+		Artist:  strings.TrimSpace(c.Query("artist")),
+		Title:   strings.TrimSpace(c.Query("title")),
+	}
+	if q.Barcode == "" && (q.Artist == "" || q.Title == "") {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "barcode, or artist and title, are required"})
+		return
+	}
+	providers := metadataProviders()
+	if len(providers) == 0 {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "no metadata providers are configured"})
+		return
+	}
+
+	results := []providerReleases{}
+	for _, p := range providers {
+		drafts, err := p.Lookup(c.Request.Context(), q)
+		result := providerReleases{Provider: p.Name(), Releases: drafts}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if result.Releases == nil {
+			result.Releases = []albumDraft{}
+		}
+		results = append(results, result)
+	}
+	c.IndentedJSON(http.StatusOK, results)
+}
+
+// enrichAlbum fills in the fields of a that are empty from what the
+// providers know, asking them in order until none is left, and returns
+// which provider filled in each field.
+func enrichAlbum(ctx context.Context, a *album, providers []metadataProvider) (map[string]string, error) {
+	filled := map[string]string{}
+	setString := func(field string, to *string, from, provider string) {
+		if *to == "" && strings.TrimSpace(from) != "" {
+			*to, filled[field] = strings.TrimSpace(from), provider
+		}
+	}
+	missing := func() bool {
+		return a.Year == 0 || a.Genre == "" || a.Label == "" || a.CatalogNumber == "" || a.Barcode == "" || a.CoverURL == ""
+	}
+
+	var errs []error
+	for _, p := range providers {
+		if !missing() {
+			break
+		}
+		draft, err := p.Enrich(ctx, *a)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if a.Year == 0 && draft.Year != 0 {
+			a.Year, filled["year"] = draft.Year, p.Name()
+		}
+		setString("genre", &a.Genre, draft.Genre, p.Name())
+		setString("label", &a.Label, draft.Label, p.Name())
+		setString("catalogNumber", &a.CatalogNumber, draft.CatalogNumber, p.Name())
+		setString("barcode", &a.Barcode, draft.Barcode, p.Name())
+		setString("coverUrl", &a.CoverURL, draft.CoverURL, p.Name())
+	}
+	for _, p := range providers {
+		if a.CoverURL != "" {
+			break
+		}
+		cover, err := p.CoverArt(ctx, *a)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		setString("coverUrl", &a.CoverURL, cover, p.Name())
+	}
+	if len(filled) == 0 && len(errs) == len(providers) && len(errs) > 0 {
+		return filled, errors.Join(errs...)
+	}
+	return filled, nil
+}
+
+// postAlbumEnrich fills in the empty fields of an album from the metadata
+// providers. Fields that are set are left alone.
+func postAlbumEnrich(c *gin.Context) {
+	providers := metadataProviders()
+	if len(providers) == 0 {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "no metadata providers are configured"})
+		return
+	}
+	albumsMu.RLock()
+	i := findAlbum(c.Param("id"))
+	var a album
+	if i >= 0 {
+		a = albums[i]
+	}
+	albumsMu.RUnlock()
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+
+	before := a
+	filled, err := enrichAlbum(c.Request.Context(), &a, providers)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+	if len(filled) == 0 {
+		c.IndentedJSON(http.StatusOK, gin.H{"album": before, "filled": filled})
+		return
+	}
+
+	albumsMu.Lock()
+	if i = findAlbum(a.ID); i < 0 {
+		albumsMu.Unlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	// The album may have been edited during the lookups, so only the
+	// filled fields are applied, and only where they are still empty.
+	before = albums[i]
+	change := albumChange{Album: albums[i]}
+	for field := range filled {
+		switch field {
+		case "year":
+			if change.Album.Year == 0 {
+				change.Album.Year = a.Year
+			}
+		case "genre":
+			change.Album.Genre = firstSet(change.Album.Genre, a.Genre)
+		case "label":
+			change.Album.Label = firstSet(change.Album.Label, a.Label)
+		case "catalogNumber":
+			change.Album.CatalogNumber = firstSet(change.Album.CatalogNumber, a.CatalogNumber)
+		case "barcode":
+			change.Album.Barcode = firstSet(change.Album.Barcode, a.Barcode)
+		case "coverUrl":
+			change.Album.CoverURL = firstSet(change.Album.CoverURL, a.CoverURL)
+		}
+	}
+	libStats.replaceAlbum(albums[i], change.Album)
+	albums[i] = change.Album
+	logOperation(c, "album.update", []albumVersions{{Before: &before, After: &change.Album}}, nil)
+	albumsMu.Unlock()
+
+	ctx := c.Request.Context()
+	publishEvent(ctx, topicAlbumUpdated, change)
+	publishEvent(ctx, topicCacheInvalidation, nil)
+	c.IndentedJSON(http.StatusOK, gin.H{"album": change.Album, "filled": filled})
+}
+
+// firstSet returns the first of the strings that is not empty.
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeProvider knows one album, and fails when broken.
+type fakeProvider struct {
+	name   string
+	draft  albumDraft
+	cover  string
+	broken bool
+}
+
+func (p fakeProvider) Name() string {
+	return p.name
+}
+
+func (p fakeProvider) Lookup(ctx context.Context, q metadataQuery) ([]albumDraft, error) {
+	if p.broken {
+		return nil, errors.New("service unavailable")
+	}
+	return []albumDraft{p.draft}, nil
+}
+
+func (p fakeProvider) Enrich(ctx context.Context, a album) (albumDraft, error) {
+	if p.broken {
+		return albumDraft{}, errors.New("service unavailable")
+	}
+	return p.draft, nil
+}
+
+func (p fakeProvider) CoverArt(ctx context.Context, a album) (string, error) {
+	return p.cover, nil
+}
+
+func TestRegisterMetadataProvider(t *testing.T) {
+	defer func(ps []metadataProvider) { extraProviders = ps }(extraProviders)
+	defer delete(metadataPlugins, "zz-test")
+	defer delete(metadataPlugins, "aa-test")
+	registerMetadataProvider("zz-test", func(cfg config) metadataProvider { return fakeProvider{name: "zz-test"} })
+	registerMetadataProvider("aa-test", func(cfg config) metadataProvider {
+		if cfg.LibraryDir == "" {
+			return nil
+		}
+		return fakeProvider{name: "aa-test"}
+	})
+	cfg := testConfig()
+	cfg.MetadataPlugins = []string{"script:/usr/local/bin/lookup --fast"}
+
+	// Check unconfigured providers are left out, and external ones come last
+	setupMetadataProviders(cfg)
+	var names []string
+	for _, p := range extraProviders {
+		names = append(names, p.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"zz-test", "script"}) {
+		t.Errorf("Expected zz-test and script, but got %v", names)
+	}
+	if p := extraProviders[1].(commandProvider); p.command != "/usr/local/bin/lookup" || !reflect.DeepEqual(p.args, []string{"--fast"}) {
+		t.Errorf("Expected the command and its arguments, but got %+v", p)
+	}
+
+	// Check a name cannot be taken twice
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic, but got none")
+		}
+	}()
+	registerMetadataProvider("zz-test", nil)
+}
+
+func TestCommandProvider(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "provider.sh")
+	os.WriteFile(script, []byte(`#!/bin/sh
+input=$(cat)
+case "$1" in
+lookup) echo '[{"title": "Kind of Blue", "artist": "Miles Davis", "year": 1959}]' ;;
+enrich) echo '{"title": "Kind of Blue", "label": "Columbia"}' ;;
+cover-art) echo '{"url": "https://img/kob.jpg"}' ;;
+*) echo "unknown method $1" >&2; exit 2 ;;
+esac
+`), 0o755)
+	p := commandProvider{name: "script", command: script}
+	ctx := context.Background()
+
+	// Check each method is answered by the program
+	drafts, err := p.Lookup(ctx, metadataQuery{Artist: "Miles Davis", Title: "Kind of Blue"})
+	if err != nil || len(drafts) != 1 || drafts[0].Year != 1959 {
+		t.Errorf("Expected Kind of Blue from 1959, but got %+v %v", drafts, err)
+	}
+	draft, err := p.Enrich(ctx, album{Title: "Kind of Blue"})
+	if err != nil || draft.Label != "Columbia" {
+		t.Errorf("Expected the Columbia label, but got %+v %v", draft, err)
+	}
+	cover, err := p.CoverArt(ctx, album{Title: "Kind of Blue"})
+	if err != nil || cover != "https://img/kob.jpg" {
+		t.Errorf("Expected the cover URL, but got %q %v", cover, err)
+	}
+
+	// Check a failing program reports what it printed
+	p.args = []string{"bogus"}
+	if _, err := p.Enrich(ctx, album{}); err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestAlbumEnrich(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	defer func(mb *musicBrainzClient, dc *discogsClient, ps []metadataProvider) {
+		musicBrainz, discogs, extraProviders = mb, dc, ps
+	}(musicBrainz, discogs, extraProviders)
+	musicBrainz, discogs, extraProviders = nil, nil, nil
+	albums = []album{{ID: "kob", Title: "Kind of Blue", Artist: "Miles Davis", Genre: "Modal jazz"}}
+
+	// Check enriching needs a provider
+	rr := performRequest(router, "POST", "/albums/kob/enrich", nil, "alice")
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotImplemented, rr.Code)
+	}
+
+	// Check empty fields are filled from the providers in order, and set
+	// ones kept
+	extraProviders = []metadataProvider{
+		fakeProvider{name: "broken", broken: true},
+		fakeProvider{name: "labels", draft: albumDraft{Year: 1959, Genre: "Jazz", Label: "Columbia", CatalogNumber: "CL 1355"}},
+		fakeProvider{name: "covers", draft: albumDraft{Label: "Sony"}, cover: "https://img/kob.jpg"},
+	}
+	rr = performRequest(router, "POST", "/albums/kob/enrich", nil, "alice")
+	var result struct {
+		Album  album             `json:"album"`
+		Filled map[string]string `json:"filled"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	want := map[string]string{"year": "labels", "label": "labels", "catalogNumber": "labels", "coverUrl": "covers"}
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(result.Filled, want) {
+		t.Errorf("Expected %v, but got %d %v", want, rr.Code, result.Filled)
+	}
+	if a := albums[0]; a.Genre != "Modal jazz" || a.Label != "Columbia" || a.Year != 1959 || a.CoverURL != "https://img/kob.jpg" {
+		t.Errorf("Expected the album to be enriched, but got %+v", a)
+	}
+
+	// Check every provider is listed and searched, with their errors
+	rr = performRequest(router, "GET", "/metadata/providers", nil, "bob")
+	if rr.Body.String() != "[\n    \"broken\",\n    \"labels\",\n    \"covers\"\n]" {
+		t.Errorf("Expected the three providers, but got %s", rr.Body)
+	}
+	rr = performRequest(router, "GET", "/metadata/search?artist=Miles+Davis&title=Kind+of+Blue", nil, "bob")
+	var releases []providerReleases
+	json.Unmarshal(rr.Body.Bytes(), &releases)
+	if len(releases) != 3 || releases[0].Error == "" || len(releases[1].Releases) != 1 {
+		t.Errorf("Expected 3 providers' answers, but got %+v", releases)
+	}
+
+	// Check only admins enrich albums
+	rr = performRequest(router, "POST", "/albums/kob/enrich", nil, "bob")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headRequestKey marks the GET request a HEAD request is answered with, so
+// it is not rate limited a second time.
+type headRequestKey struct{}
+
+// routeMatches reports whether a gin route pattern such as /albums/:id
+// matches path.
+func routeMatches(pattern, path string) bool {
+	want, got := strings.Split(pattern, "/"), strings.Split(path, "/")
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(got) {
+			return false
+		}
+		if strings.HasPrefix(segment, ":") && got[i] != "" || segment == got[i] {
+			continue
+		}
+		return false
+	}
+	return len(want) == len(got)
+}
+
+// allowedMethods returns the methods path can be requested with, including
+// HEAD wherever GET works and OPTIONS, or nil when no route matches.
+func allowedMethods(routes gin.RoutesInfo, path string) []string {
+	found := map[string]bool{}
+	for _, r := range routes {
+		if routeMatches(r.Path, path) {
+			found[r.Method] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	if found[http.MethodGet] {
+		found[http.MethodHead] = true
+	}
+	found[http.MethodOptions] = true
+	methods := make([]string, 0, len(found))
+	for m := range found {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// headRecorder keeps the headers and status of a response and counts its
+// body, which it discards.
+type headRecorder struct {
+	header http.Header
+	status int
+	length int
+}
+
+func (r *headRecorder) Header() http.Header { return r.header }
+
+func (r *headRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *headRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	r.length += len(b)
+	return len(b), nil
+}
+
+// unroutedMethods handles requests whose method has no route for the
+// path: HEAD is answered like GET without the body, OPTIONS lists the
+// allowed methods, and other methods get 405. Paths no route matches are
+// left to the usual 404.
+func unroutedMethods(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		methods := allowedMethods(router.Routes(), c.Request.URL.Path)
+		if methods == nil {
+			return
+		}
+		allow := strings.Join(methods, ", ")
+
+		switch {
+		case c.Request.Method == http.MethodOptions:
+			c.Header("Allow", allow)
+			if c.GetHeader("Access-Control-Request-Method") != "" {
+				c.Header("Access-Control-Allow-Methods", allow)
+				if headers := c.GetHeader("Access-Control-Request-Headers"); headers != "" {
+					c.Header("Access-Control-Allow-Headers", headers)
+				}
+			}
+			c.Status(http.StatusNoContent)
+		case c.Request.Method == http.MethodHead && slices.Contains(methods, http.MethodGet):
+			req := c.Request.Clone(context.WithValue(c.Request.Context(), headRequestKey{}, true))
+			req.Method = http.MethodGet
+			rec := &headRecorder{header: http.Header{}}
+			router.ServeHTTP(rec, req)
+
+			for key, values := range rec.header {
+				c.Writer.Header()[key] = values
+			}
+			if c.Writer.Header().Get("Content-Length") == "" {
+				c.Header("Content-Length", strconv.Itoa(rec.length))
+			}
+			c.Status(max(rec.status, http.StatusOK))
+		default:
+			c.Header("Allow", allow)
+			c.IndentedJSON(http.StatusMethodNotAllowed, gin.H{"message": "method not allowed"})
+		}
+		c.Writer.WriteHeaderNow()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestHeadAndOptions(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Title: "Naima"}, {ID: "2", Title: "Giant Steps"}}
+
+	// Check HEAD answers like GET without a body
+	get := performRequest(router, "GET", "/tracks?limit=1", nil, "")
+	head := performRequest(router, "HEAD", "/tracks?limit=1", nil, "")
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Errorf("Expected 200 with no body, but got %d with %d bytes", head.Code, head.Body.Len())
+	}
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Errorf("Expected Content-Length %d, but got %q", get.Body.Len(), head.Header().Get("Content-Length"))
+	}
+	if head.Header().Get("X-Total-Count") != "2" || head.Header().Get("Content-Type") != get.Header().Get("Content-Type") {
+		t.Errorf("Expected the GET headers, but got %v", head.Header())
+	}
+	if rr := performRequest(router, "HEAD", "/tracks/9", nil, ""); rr.Code != http.StatusNotFound || rr.Body.Len() != 0 {
+		t.Errorf("Expected 404 with no body, but got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := performRequest(router, "HEAD", "/playlists", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected HEAD to need the same auth as GET, but got %d", rr.Code)
+	}
+
+	// Check OPTIONS lists the methods of the route
+	rr := performRequest(router, "OPTIONS", "/albums/1", nil, "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Allow") != "GET, HEAD, OPTIONS, PATCH" {
+		t.Errorf("Expected the album methods, but got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+	req, _ := http.NewRequest("OPTIONS", "/player/play", nil)
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	w := &headRecorder{header: http.Header{}}
+	router.ServeHTTP(w, req)
+	if w.header.Get("Access-Control-Allow-Methods") != "OPTIONS, POST" || w.header.Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("Expected a preflight answer, but got %v", w.header)
+	}
+
+	// Check other methods get 405 and unknown paths still 404
+	rr = performRequest(router, "PUT", "/tracks", nil, "")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("Expected 405 allowing GET, but got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+	if rr := performRequest(router, "OPTIONS", "/nowhere", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// moodNames are the moods tracks are tagged with, in the order they are
+// browsed.
+var moodNames = []string{"chill", "energetic", "dark", "happy"}
+
+// camelotPattern matches Camelot keys, where A is minor and B is major.
+var camelotPattern = regexp.MustCompile(`^(1[0-2]|[1-9])([AaBb])$`)
+
+// keyMode reads whether a key, in Camelot notation or by name ("A minor",
+// "F#m", "C"), is "major" or "minor"; it is empty when it cannot tell.
+func keyMode(key string) string {
+	key = strings.TrimSpace(key)
+	if m := camelotPattern.FindStringSubmatch(key); m != nil {
+		if strings.EqualFold(m[2], "a") {
+			return "minor"
+		}
+		return "major"
+	}
+	lower := strings.ToLower(key)
+	switch {
+	case key == "":
+		return ""
+	case strings.Contains(lower, "min") || strings.HasSuffix(key, "m"):
+		return "minor"
+	case strings.Contains(lower, "maj") || len(strings.TrimRight(key, "#b♯♭")) == 1:
+		return "major"
+	}
+	return ""
+}
+
+// clamp01 limits v to between 0 and 1.
+func clamp01(v float64) float64 { return min(max(v, 0), 1) }
+
+// moodFeatures returns the energy and valence of an analysis, estimating
+// those the analyzer did not give: energy from tempo (60 to 160 BPM) and
+// loudness (-25 to -5 LUFS), valence from the key's mode nudged by tempo.
+// Either is -1 when nothing it could come from is known.
+func moodFeatures(a *trackAnalysis) (energy, valence float64) {
+	energy, valence = a.Energy, a.Valence
+	tempo := -1.0
+	if a.BPM > 0 {
+		tempo = clamp01((a.BPM - 60) / 100)
+	}
+	if energy == 0 {
+		var parts []float64
+		if tempo >= 0 {
+			parts = append(parts, tempo)
+		}
+		if a.Loudness != 0 {
+			parts = append(parts, clamp01((a.Loudness+25)/20))
+		}
+		energy = -1
+		if len(parts) > 0 {
+			energy = 0
+			for _, p := range parts {
+				energy += p / float64(len(parts))
+			}
+		}
+	}
+	if valence == 0 {
+		switch keyMode(a.Key) {
+		case "major":
+			valence = 0.65
+		case "minor":
+			valence = 0.35
+		default:
+			return energy, -1
+		}
+		if tempo >= 0 {
+			valence = clamp01(valence + 0.3*(tempo-0.5))
+		}
+	}
+	return energy, valence
+}
+
+// trackMoods tags an analysis with moods: chill at low energy, energetic
+// at high energy, dark at low valence and happy at high valence.
+func trackMoods(a *trackAnalysis) []string {
+	if a == nil {
+		return nil
+	}
+	energy, valence := moodFeatures(a)
+	var moods []string
+	if energy >= 0 && energy <= 0.35 {
+		moods = append(moods, "chill")
+	}
+	if energy >= 0.65 {
+		moods = append(moods, "energetic")
+	}
+	if valence >= 0 && valence <= 0.3 {
+		moods = append(moods, "dark")
+	}
+	if valence >= 0.7 {
+		moods = append(moods, "happy")
+	}
+	return moods
+}
+
+// tagMoods tags the analyzed tracks that have no moods yet, such as those
+// analyzed before moods were tagged.
+func tagMoods() {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	for i := range tracks {
+		if tracks[i].Analysis != nil && tracks[i].Moods == nil {
+			tracks[i].Moods = trackMoods(tracks[i].Analysis)
+		}
+	}
+}
+
+type moodCount struct {
+	Mood   string `json:"mood"`
+	Tracks int    `json:"tracks"`
+}
+
+// getMoods lists every mood with how many tracks have it.
+func getMoods(c *gin.Context) {
+	albumsMu.RLock()
+	counts := map[string]int{}
+	for _, t := range tracks {
+		for _, mood := range t.Moods {
+			counts[mood]++
+		}
+	}
+	albumsMu.RUnlock()
+
+	moods := []moodCount{}
+	for _, mood := range moodNames {
+		moods = append(moods, moodCount{Mood: mood, Tracks: counts[mood]})
+	}
+	c.IndentedJSON(http.StatusOK, moods)
+}
+
+// getMoodTracks lists the tracks tagged with a mood, in catalog order.
+func getMoodTracks(c *gin.Context) {
+	mood := strings.ToLower(c.Param("mood"))
+	if !hasTag(moodNames, mood) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "mood not found"})
+		return
+	}
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	matching := []track{}
+	for _, t := range tracks {
+		if hasTag(t.Moods, mood) {
+			matching = append(matching, t)
+		}
+	}
+	start, end := pageBounds(c, len(matching), limit, offset)
+	respondList(c, matching[start:end])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrackMoods(t *testing.T) {
+	for _, tc := range []struct {
+		analysis trackAnalysis
+		want     []string
+	}{
+		{trackAnalysis{BPM: 70, Key: "8A"}, []string{"chill", "dark"}},
+		{trackAnalysis{BPM: 150, Key: "C major", Loudness: -6}, []string{"energetic", "happy"}},
+		{trackAnalysis{BPM: 110, Key: "F#m"}, nil},
+		{trackAnalysis{Loudness: -24}, []string{"chill"}},
+		{trackAnalysis{BPM: 170, Energy: 0.2, Valence: 0.9}, []string{"chill", "happy"}},
+		{trackAnalysis{}, nil},
+	} {
+		if got := trackMoods(&tc.analysis); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Expected %v for %+v, but got %v", tc.want, tc.analysis, got)
+		}
+	}
+}
+
+func TestBrowseMoods(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", Title: "Naima"},
+		{ID: "2", Title: "Lithium"},
+		// Analyzed before moods were tagged
+		{ID: "3", Title: "Alabama", Analysis: &trackAnalysis{BPM: 65, Key: "Am"}},
+	}
+
+	// Check storing an analysis tags the track
+	rr := performRequest(router, "PUT", "/tracks/1/analysis", strings.NewReader(`{"bpm": 62, "key": "4A"}`), "alice")
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(tracks[0].Moods, []string{"chill", "dark"}) {
+		t.Errorf("Expected chill and dark, but got %d %v", rr.Code, tracks[0].Moods)
+	}
+	performRequest(router, "PUT", "/tracks/2/analysis", strings.NewReader(`{"bpm": 120, "energy": 0.9}`), "alice")
+	rr = performRequest(router, "PUT", "/tracks/2/analysis", strings.NewReader(`{"valence": 2}`), "alice")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check older analyses are tagged by the analysis job
+	tagMoods()
+	if !reflect.DeepEqual(tracks[2].Moods, []string{"chill", "dark"}) {
+		t.Errorf("Expected chill and dark, but got %v", tracks[2].Moods)
+	}
+
+	// Check moods are counted and browsed
+	rr = performRequest(router, "GET", "/browse/moods", nil, "")
+	var moods []moodCount
+	json.Unmarshal(rr.Body.Bytes(), &moods)
+	want := []moodCount{{"chill", 2}, {"energetic", 1}, {"dark", 2}, {"happy", 0}}
+	if !reflect.DeepEqual(moods, want) {
+		t.Errorf("Expected %v, but got %v", want, moods)
+	}
+	rr = performRequest(router, "GET", "/browse/moods/Dark?limit=1&offset=1", nil, "")
+	var list []track
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != "3" {
+		t.Errorf("Expected track 3, but got %+v", list)
+	}
+	if rr = performRequest(router, "GET", "/browse/moods/angry", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check smart playlists can select by mood
+	albumsMu.RLock()
+	_, matched, err := searchLibrary("mood:energetic")
+	albumsMu.RUnlock()
+	if err != nil || len(matched) != 1 || matched[0].ID != "2" {
+		t.Errorf("Expected track 2, but got %+v %v", matched, err)
+	}
+}
@@ -0,0 +1,207 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	mprisName       = "org.mpris.MediaPlayer2.music_player"
+	mprisPath       = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	mprisRoot       = "org.mpris.MediaPlayer2"
+	mprisPlayer     = "org.mpris.MediaPlayer2.Player"
+	mprisNoTrack    = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+	mprisTrackPaths = "/org/music_player/track/"
+)
+
+// mprisServer exposes the player on the D-Bus session bus under the MPRIS
+// interfaces, so desktop media keys and panel applets control it.
+type mprisServer struct {
+	conn  *dbus.Conn
+	props *prop.Properties
+}
+
+// mprisRootMethods implements org.mpris.MediaPlayer2. The server cannot be
+// raised or quit from the desktop.
+type mprisRootMethods struct{}
+
+func (mprisRootMethods) Raise() *dbus.Error { return nil }
+func (mprisRootMethods) Quit() *dbus.Error  { return nil }
+
+// mprisPlayerMethods implements org.mpris.MediaPlayer2.Player. Errors such
+// as an empty queue are ignored, as media keys have nowhere to report them.
+type mprisPlayerMethods struct{}
+
+func (mprisPlayerMethods) Play() *dbus.Error {
+	musicPlayer.Play()
+	return nil
+}
+
+func (mprisPlayerMethods) Pause() *dbus.Error {
+	musicPlayer.Pause()
+	return nil
+}
+
+func (mprisPlayerMethods) PlayPause() *dbus.Error {
+	musicPlayer.mu.Lock()
+	playing := musicPlayer.status().State == statePlaying
+	musicPlayer.mu.Unlock()
+	if playing {
+		musicPlayer.Pause()
+	} else {
+		musicPlayer.Play()
+	}
+	return nil
+}
+
+func (mprisPlayerMethods) Stop() *dbus.Error {
+	musicPlayer.Stop()
+	return nil
+}
+
+func (mprisPlayerMethods) Next() *dbus.Error {
+	musicPlayer.Skip(1)
+	return nil
+}
+
+func (mprisPlayerMethods) Previous() *dbus.Error {
+	musicPlayer.Skip(-1)
+	return nil
+}
+
+func newMPRISServer() (*mprisServer, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("mpris: %w", err)
+	}
+	reply, err := conn.RequestName(mprisName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mpris: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("mpris: %s is already taken", mprisName)
+	}
+
+	conn.Export(mprisRootMethods{}, mprisPath, mprisRoot)
+	conn.Export(mprisPlayerMethods{}, mprisPath, mprisPlayer)
+
+	status, metadata, volume, position := mprisState(currentNowPlaying())
+	props, err := prop.Export(conn, mprisPath, prop.Map{
+		mprisRoot: {
+			"CanQuit":             {Value: false},
+			"CanRaise":            {Value: false},
+			"HasTrackList":        {Value: false},
+			"Identity":            {Value: "Music Player"},
+			"SupportedUriSchemes": {Value: []string{}},
+			"SupportedMimeTypes":  {Value: []string{}},
+		},
+		mprisPlayer: {
+			"PlaybackStatus": {Value: status, Emit: prop.EmitTrue},
+			"Metadata":       {Value: metadata, Emit: prop.EmitTrue},
+			"Volume":         {Value: volume, Writable: true, Emit: prop.EmitTrue, Callback: setMPRISVolume},
+			"Position":       {Value: position, Emit: prop.EmitFalse},
+			"Rate":           {Value: 1.0},
+			"MinimumRate":    {Value: 1.0},
+			"MaximumRate":    {Value: 1.0},
+			"CanGoNext":      {Value: true},
+			"CanGoPrevious":  {Value: true},
+			"CanPlay":        {Value: true},
+			"CanPause":       {Value: true},
+			"CanSeek":        {Value: false},
+			"CanControl":     {Value: true},
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mpris: %w", err)
+	}
+
+	node := &introspect.Node{
+		Name: string(mprisPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: mprisRoot, Methods: introspect.Methods(mprisRootMethods{}), Properties: props.Introspection(mprisRoot)},
+			{Name: mprisPlayer, Methods: introspect.Methods(mprisPlayerMethods{}), Properties: props.Introspection(mprisPlayer)},
+		},
+	}
+	conn.Export(introspect.NewIntrospectable(node), mprisPath, "org.freedesktop.DBus.Introspectable")
+	return &mprisServer{conn: conn, props: props}, nil
+}
+
+func setMPRISVolume(c *prop.Change) *dbus.Error {
+	volume, ok := c.Value.(float64)
+	if !ok {
+		return prop.ErrInvalidArg
+	}
+	musicPlayer.SetVolume(int(volume*100 + 0.5))
+	return nil
+}
+
+var mprisInvalidPathChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// mprisState maps the player state to the MPRIS PlaybackStatus, Metadata,
+// Volume and Position properties.
+func mprisState(n nowPlaying) (string, map[string]dbus.Variant, float64, int64) {
+	status := map[string]string{statePlaying: "Playing", statePaused: "Paused"}[n.State]
+	if status == "" {
+		status = "Stopped"
+	}
+	metadata := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(mprisNoTrack)}
+	if t := n.Track; t != nil {
+		path := dbus.ObjectPath(mprisTrackPaths + "t" + mprisInvalidPathChars.ReplaceAllString(t.ID, "_"))
+		metadata["mpris:trackid"] = dbus.MakeVariant(path)
+		metadata["xesam:title"] = dbus.MakeVariant(t.Title)
+		metadata["xesam:artist"] = dbus.MakeVariant([]string{t.Artist})
+		if t.Album != "" {
+			metadata["xesam:album"] = dbus.MakeVariant(t.Album)
+		}
+		if t.Duration > 0 {
+			metadata["mpris:length"] = dbus.MakeVariant(int64(t.Duration * 1e6))
+		}
+		if t.CoverURL != "" {
+			metadata["mpris:artUrl"] = dbus.MakeVariant(t.CoverURL)
+		}
+	}
+	return status, metadata, float64(n.Volume) / 100, int64(n.Elapsed * 1e6)
+}
+
+// Run keeps the exported properties in step with the player until ctx is
+// cancelled. PropertiesChanged is only emitted for values that changed.
+func (m *mprisServer) Run(ctx context.Context) {
+	defer m.conn.Close()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	changes := musicPlayer.watch()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		case <-ticker.C:
+		}
+		status, metadata, volume, position := mprisState(currentNowPlaying())
+		m.update("PlaybackStatus", status)
+		m.update("Metadata", metadata)
+		m.update("Volume", volume)
+		m.props.SetMust(mprisPlayer, "Position", position)
+	}
+}
+
+func (m *mprisServer) update(name string, value any) {
+	if !reflect.DeepEqual(m.props.GetMust(mprisPlayer, name), value) {
+		m.props.SetMust(mprisPlayer, name, value)
+	}
+}
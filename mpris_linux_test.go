@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The player state maps onto MPRIS properties
+func TestMPRISState(t *testing.T) {
+	// Check a stopped player reports no track
+	status, metadata, volume, _ := mprisState(nowPlaying{State: stateStopped, Volume: 50})
+	if status != "Stopped" || metadata["mpris:trackid"].Value() != mprisNoTrack || volume != 0.5 {
+		t.Errorf("Expected Stopped with no track at 0.5, but got %s %v %v", status, metadata, volume)
+	}
+
+	// Check track ids become valid object paths and times are in microseconds
+	playing := nowPlaying{State: statePlaying, Elapsed: 1.5, Track: &nowPlayingTrack{ID: "0190-ab", Title: "So What", Artist: "Miles Davis", Duration: 562}}
+	status, metadata, _, position := mprisState(playing)
+	if status != "Playing" || position != 1500000 {
+		t.Errorf("Expected Playing at 1500000µs, but got %s at %d", status, position)
+	}
+	if path := metadata["mpris:trackid"].Value().(dbus.ObjectPath); !path.IsValid() || path != "/org/music_player/track/t0190_ab" {
+		t.Errorf("Expected a valid track path, but got %q", path)
+	}
+	if metadata["mpris:length"].Value() != int64(562000000) {
+		t.Errorf("Expected length 562000000, but got %v", metadata["mpris:length"].Value())
+	}
+}
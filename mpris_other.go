@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// mprisServer is only available on Linux, where MPRIS lives on D-Bus.
+type mprisServer struct{}
+
+func newMPRISServer() (*mprisServer, error) {
+	return nil, errors.New("mpris: only supported on Linux")
+}
+
+func (*mprisServer) Run(context.Context) {}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttBridge publishes the player state to an MQTT broker and carries out
+// commands sent to it, so home-automation setups get updates pushed to them.
+//
+// The state is published, retained, to the state topic as the JSON served by
+// /player/now-playing. Commands are published to <command topic>/<name>:
+// play, pause, next, previous and clear take no payload, volume takes a
+// number from 0 to 100, and queue takes a JSON array of track ids.
+type mqttBridge struct {
+	client       mqtt.Client
+	stateTopic   string
+	commandTopic string
+	// publish is swapped out in tests.
+	publish func(topic string, payload []byte) error
+}
+
+// mqttStateInterval is how often the bridge checks for tracks that ended on
+// their own.
+const mqttStateInterval = time.Second
+
+func newMQTTBridge(cfg config) (*mqttBridge, error) {
+	u, err := url.Parse(cfg.MQTTURL)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTTURL).
+		SetClientID("music-player-"+instanceID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(cfg.MQTTStateTopic, `{"state":"offline"}`, 1, true)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	}
+
+	b := &mqttBridge{stateTopic: cfg.MQTTStateTopic, commandTopic: cfg.MQTTCommandTopic}
+	// Subscribe on every connect so commands keep working after reconnects.
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		client.Subscribe(b.commandTopic+"/+", 1, func(_ mqtt.Client, m mqtt.Message) {
+			name := strings.TrimPrefix(m.Topic(), b.commandTopic+"/")
+			if err := b.handleCommand(name, m.Payload()); err != nil {
+				log.Printf("mqtt: command %s: %v", name, err)
+			}
+		})
+	})
+	b.client = mqtt.NewClient(opts)
+	b.publish = func(topic string, payload []byte) error {
+		token := b.client.Publish(topic, 1, true, payload)
+		token.Wait()
+		return token.Error()
+	}
+	// With connect retry on, this keeps trying in the background until the
+	// broker is reachable.
+	b.client.Connect()
+	return b, nil
+}
+
+// handleCommand carries out one command received on the command topic.
+func (b *mqttBridge) handleCommand(name string, payload []byte) error {
+	var err error
+	switch name {
+	case "play":
+		_, err = musicPlayer.Play()
+	case "pause":
+		musicPlayer.Pause()
+	case "next":
+		_, err = musicPlayer.Skip(1)
+	case "previous":
+		_, err = musicPlayer.Skip(-1)
+	case "clear":
+		musicPlayer.ClearQueue()
+	case "volume":
+		volume, convErr := strconv.Atoi(strings.TrimSpace(string(payload)))
+		if convErr != nil || volume < 0 || volume > 100 {
+			return fmt.Errorf("volume must be between 0 and 100")
+		}
+		musicPlayer.SetVolume(volume)
+	case "queue":
+		var ids []string
+		if err := json.Unmarshal(payload, &ids); err != nil {
+			return fmt.Errorf("payload must be a JSON array of track ids")
+		}
+		if id := unknownTrack(ids); id != "" {
+			return fmt.Errorf("track %s not found", id)
+		}
+		musicPlayer.Enqueue(ids...)
+	default:
+		return fmt.Errorf("unknown command")
+	}
+	return err
+}
+
+// publishState publishes the current state unless it matches lastKey, and
+// returns the key of what is now published. Progress through a track alone
+// is not a change; subscribers extrapolate it from elapsed.
+func (b *mqttBridge) publishState(lastKey string) string {
+	current := currentNowPlaying()
+	raw, err := json.Marshal(current)
+	if err != nil {
+		log.Printf("mqtt: publish state: %v", err)
+		return lastKey
+	}
+	key := current.changeKey()
+	if key == lastKey {
+		return lastKey
+	}
+	if err := b.publish(b.stateTopic, raw); err != nil {
+		log.Printf("mqtt: publish state: %v", err)
+		return lastKey
+	}
+	return key
+}
+
+// Run publishes state changes until ctx is cancelled.
+func (b *mqttBridge) Run(ctx context.Context) {
+	ticker := time.NewTicker(mqttStateInterval)
+	defer ticker.Stop()
+	changes := musicPlayer.watch()
+
+	last := b.publishState("")
+	for {
+		select {
+		case <-ctx.Done():
+			b.client.Disconnect(250)
+			return
+		case <-changes:
+		case <-ticker.C:
+		}
+		last = b.publishState(last)
+	}
+}
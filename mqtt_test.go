@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Commands drive the player and state is only published when it changes
+func TestMQTTBridge(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	tracks = []track{{ID: "1", Title: "So What", Duration: 562}, {ID: "2", Title: "Freddie Freeloader", Duration: 589}}
+	var published []nowPlaying
+	b := &mqttBridge{stateTopic: "music-player/state", publish: func(topic string, payload []byte) error {
+		var state nowPlaying
+		json.Unmarshal(payload, &state)
+		published = append(published, state)
+		return nil
+	}}
+
+	// Check bad commands are rejected
+	if err := b.handleCommand("queue", []byte(`["1", "9"]`)); err == nil {
+		t.Errorf("Expected an error for an unknown track")
+	}
+	if err := b.handleCommand("volume", []byte("150")); err == nil {
+		t.Errorf("Expected an error for an out-of-range volume")
+	}
+	if err := b.handleCommand("rewind", nil); err == nil {
+		t.Errorf("Expected an error for an unknown command")
+	}
+
+	b.handleCommand("queue", []byte(`["1", "2"]`))
+	b.handleCommand("play", nil)
+	b.handleCommand("volume", []byte("30"))
+	key := b.publishState("")
+
+	// Check the published state reflects the commands
+	if len(published) != 1 || published[0].State != statePlaying || published[0].Track.Title != "So What" || published[0].Volume != 30 {
+		t.Errorf("Expected So What playing at volume 30, but got %+v", published)
+	}
+
+	// Check an unchanged state is not published again
+	b.publishState(key)
+	if len(published) != 1 {
+		t.Errorf("Expected 1 publish, but got %d", len(published))
+	}
+	b.handleCommand("next", nil)
+	b.publishState(key)
+	if len(published) != 2 || published[1].Track.Title != "Freddie Freeloader" {
+		t.Errorf("Expected Freddie Freeloader to be published, but got %+v", published)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const musicBrainzUserAgent = "go-music-player/0.1 ( https://github.com/hmazomba/go-music-player )"
+
+// musicBrainz is nil when MusicBrainz lookups are turned off.
+var musicBrainz *musicBrainzClient
+
+type musicBrainzClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newMusicBrainzClient(baseURL string) *musicBrainzClient {
+	return &musicBrainzClient{baseURL: baseURL, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type mbReleaseGroup struct {
+	ID               string   `json:"id"`
+	Title            string   `json:"title"`
+	PrimaryType      string   `json:"primary-type"`
+	SecondaryTypes   []string `json:"secondary-types"`
+	FirstReleaseDate string   `json:"first-release-date"`
+}
+
+// Year returns the year of the first release, or 0 when unknown.
+func (g mbReleaseGroup) Year() int {
+	if len(g.FirstReleaseDate) < 4 {
+		return 0
+	}
+	year, _ := strconv.Atoi(g.FirstReleaseDate[:4])
+	return year
+}
+
+// Type maps MusicBrainz release group types onto releaseTypes.
+func (g mbReleaseGroup) Type() string {
+	for _, t := range g.SecondaryTypes {
+		if t == "Compilation" {
+			return "compilation"
+		}
+	}
+	switch g.PrimaryType {
+	case "EP":
+		return "ep"
+	case "Single":
+		return "single"
+	}
+	return ""
+}
+
+var errArtistNotFound = errors.New("musicbrainz: artist not found")
+
+// artistID returns the MusicBrainz id of the best match for artist.
+func (m *musicBrainzClient) artistID(ctx context.Context, artist string) (string, error) {
+	var search struct {
+		Artists []struct {
+			ID string `json:"id"`
+		} `json:"artists"`
+	}
+	query := url.Values{"query": {`artist:"` + artist + `"`}, "limit": {"1"}, "fmt": {"json"}}
+	if err := m.get(ctx, "/artist", query, &search); err != nil {
+		return "", err
+	}
+	if len(search.Artists) == 0 {
+		return "", errArtistNotFound
+	}
+	return search.Artists[0].ID, nil
+}
+
+// releaseGroups returns the release groups of the best match for artist.
+func (m *musicBrainzClient) releaseGroups(ctx context.Context, artist string) ([]mbReleaseGroup, error) {
+	id, err := m.artistID(ctx, artist)
+	if err != nil {
+		return nil, err
+	}
+
+	var browse struct {
+		ReleaseGroups []mbReleaseGroup `json:"release-groups"`
+	}
+	query := url.Values{"artist": {id}, "limit": {"100"}, "fmt": {"json"}}
+	if err := m.get(ctx, "/release-group", query, &browse); err != nil {
+		return nil, err
+	}
+	return browse.ReleaseGroups, nil
+}
+
+// releaseLabel returns the label and catalog number of the best match for
+// an artist's release, or "" when MusicBrainz knows none.
+func (m *musicBrainzClient) releaseLabel(ctx context.Context, artist, title string) (label, catalogNumber string, err error) {
+	var search struct {
+		Releases []struct {
+			LabelInfo []struct {
+				CatalogNumber string `json:"catalog-number"`
+				Label         struct {
+					Name string `json:"name"`
+				} `json:"label"`
+			} `json:"label-info"`
+		} `json:"releases"`
+	}
+	query := url.Values{"query": {`release:"` + title + `" AND artist:"` + artist + `"`}, "limit": {"1"}, "fmt": {"json"}}
+	if err := m.get(ctx, "/release", query, &search); err != nil {
+		return "", "", err
+	}
+	for _, r := range search.Releases {
+		for _, info := range r.LabelInfo {
+			if info.Label.Name != "" {
+				return info.Label.Name, info.CatalogNumber, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// imageRelation returns the image linked from an artist's relations, or ""
+// when there is none. Wikimedia Commons file pages are turned into links
+// to the file itself.
+func (m *musicBrainzClient) imageRelation(ctx context.Context, mbid string) (string, error) {
+	var lookup struct {
+		Relations []struct {
+			Type string `json:"type"`
+			URL  struct {
+				Resource string `json:"resource"`
+			} `json:"url"`
+		} `json:"relations"`
+	}
+	if err := m.get(ctx, "/artist/"+url.PathEscape(mbid), url.Values{"inc": {"url-rels"}, "fmt": {"json"}}, &lookup); err != nil {
+		return "", err
+	}
+	for _, r := range lookup.Relations {
+		if r.Type != "image" {
+			continue
+		}
+		if file, ok := strings.CutPrefix(r.URL.Resource, "https://commons.wikimedia.org/wiki/File:"); ok {
+			return "https://commons.wikimedia.org/wiki/Special:FilePath/" + file, nil
+		}
+		return r.URL.Resource, nil
+	}
+	return "", nil
+}
+
+func (m *musicBrainzClient) get(ctx context.Context, path string, query url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("musicbrainz: %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
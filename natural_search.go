@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// naturalFilters are the structured filters a natural-language search is
+// taken to mean. Zero fields do not filter.
+type naturalFilters struct {
+	Artist string `json:"artist,omitempty"`
+	// Genres match any one of them; Tags must all match.
+	Genres      []string `json:"genres,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	YearMin     int      `json:"yearMin,omitempty"`
+	YearMax     int      `json:"yearMax,omitempty"`
+	BPMMin      float64  `json:"bpmMin,omitempty"`
+	BPMMax      float64  `json:"bpmMax,omitempty"`
+	DurationMin float64  `json:"durationMin,omitempty"`
+	DurationMax float64  `json:"durationMax,omitempty"`
+	// Words are looked for in names, as bare words in a query are.
+	Words []string `json:"words,omitempty"`
+}
+
+// query writes the filters in the query language of /search; see query.go.
+func (f naturalFilters) query() string {
+	quote := func(s string) string { return strconv.Quote(strings.ReplaceAll(s, `"`, "")) }
+	var terms []string
+	if f.Artist != "" {
+		terms = append(terms, "artist:"+quote(f.Artist))
+	}
+	var genres []string
+	for _, g := range f.Genres {
+		genres = append(genres, "genre="+quote(g))
+	}
+	if len(genres) == 1 {
+		terms = append(terms, genres[0])
+	} else if len(genres) > 1 {
+		terms = append(terms, "("+strings.Join(genres, " OR ")+")")
+	}
+	for _, tag := range f.Tags {
+		terms = append(terms, "tag="+quote(tag))
+	}
+	bound := func(field, op string, v float64) {
+		if v > 0 {
+			terms = append(terms, field+op+strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	bound("year", ">=", float64(f.YearMin))
+	bound("year", "<=", float64(f.YearMax))
+	bound("bpm", ">=", f.BPMMin)
+	bound("bpm", "<=", f.BPMMax)
+	bound("duration", ">=", f.DurationMin)
+	bound("duration", "<=", f.DurationMax)
+	for _, w := range f.Words {
+		terms = append(terms, quote(w))
+	}
+	return strings.Join(terms, " ")
+}
+
+// naturalResolver turns a natural-language search into filters. The
+// library's genres and tags are given so a resolver can pick from them.
+type naturalResolver interface {
+	Resolve(ctx context.Context, text string, genres, tags []string) (naturalFilters, error)
+}
+
+// naturalSearch resolves natural-language searches. It is the rules-based
+// parser unless an external resolver, such as one backed by an LLM, is
+// configured; the rules are still used when that fails.
+var naturalSearch naturalResolver = rulesResolver{}
+
+// rulesResolver understands decades and years ("60s", "before 1980"),
+// tempo and length words ("upbeat", "mellow", "short"), "by" an artist,
+// and the genres and tags in the library. Other words are searched for in
+// names.
+type rulesResolver struct{}
+
+var (
+	decadePattern = regexp.MustCompile(`^'?(\d\d|1\d\d0|20\d0)s$`)
+	yearPattern   = regexp.MustCompile(`^(1[89]|20)\d\d$`)
+	decadeWords   = map[string]int{
+		"twenties": 1920, "thirties": 1930, "forties": 1940, "fifties": 1950,
+		"sixties": 1960, "seventies": 1970, "eighties": 1980, "nineties": 1990,
+	}
+	// naturalStopWords carry no meaning in a search.
+	naturalStopWords = map[string]bool{
+		"a": true, "an": true, "the": true, "some": true, "any": true, "me": true,
+		"play": true, "find": true, "show": true, "give": true, "i": true, "want": true,
+		"music": true, "songs": true, "song": true, "tracks": true, "track": true,
+		"albums": true, "album": true, "stuff": true, "from": true, "in": true,
+		"of": true, "and": true, "or": true, "with": true, "for": true, "to": true,
+		"era": true, "years": true, "decade": true, "released": true,
+	}
+	// naturalModifiers are words that describe the music rather than name
+	// it.
+	naturalModifiers = map[string]func(*naturalFilters){
+		"upbeat":    func(f *naturalFilters) { f.BPMMin = 120 },
+		"energetic": func(f *naturalFilters) { f.BPMMin = 120 },
+		"fast":      func(f *naturalFilters) { f.BPMMin = 120 },
+		"lively":    func(f *naturalFilters) { f.BPMMin = 120 },
+		"danceable": func(f *naturalFilters) { f.BPMMin = 115 },
+		"slow":      func(f *naturalFilters) { f.BPMMax = 95 },
+		"mellow":    func(f *naturalFilters) { f.BPMMax = 95 },
+		"chill":     func(f *naturalFilters) { f.BPMMax = 95 },
+		"calm":      func(f *naturalFilters) { f.BPMMax = 95 },
+		"relaxing":  func(f *naturalFilters) { f.BPMMax = 95 },
+		"short":     func(f *naturalFilters) { f.DurationMax = 180 },
+		"long":      func(f *naturalFilters) { f.DurationMin = 480 },
+	}
+)
+
+// naturalWords splits text into lower-case words, keeping apostrophes so
+// "'60s" survives.
+func naturalWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r == '\'' || r == '-' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
+}
+
+// decadeOf reads a decade such as "60s", "'60s", "1960s" or "sixties".
+// Two-digit decades before the 30s are taken to be this century's.
+func decadeOf(word string) (int, bool) {
+	if year, ok := decadeWords[word]; ok {
+		return year, true
+	}
+	m := decadePattern.FindStringSubmatch(word)
+	if m == nil {
+		return 0, false
+	}
+	year, _ := strconv.Atoi(m[1])
+	if len(m[1]) == 2 {
+		if year%10 != 0 {
+			return 0, false
+		}
+		year += 1900
+		if year < 1930 {
+			year += 100
+		}
+	}
+	return year, true
+}
+
+func (rulesResolver) Resolve(_ context.Context, text string, genres, tags []string) (naturalFilters, error) {
+	var f naturalFilters
+	words := naturalWords(text)
+	// matchPhrase finds the longest of names, of up to three words, at
+	// words[i:].
+	matchPhrase := func(i int, names []string) (string, int) {
+		for n := min(3, len(words)-i); n > 0; n-- {
+			phrase := strings.Join(words[i:i+n], " ")
+			for _, name := range names {
+				if strings.EqualFold(strings.Join(naturalWords(name), " "), phrase) {
+					return name, n
+				}
+			}
+		}
+		return "", 0
+	}
+	// isKeyword says whether a word starts another filter, which ends an
+	// artist name.
+	isKeyword := func(w string) bool {
+		_, decade := decadeOf(w)
+		return naturalStopWords[w] && w != "the" && w != "and" || naturalModifiers[w] != nil || decade ||
+			yearPattern.MatchString(w) || w == "before" || w == "after" || w == "since" || w == "until"
+	}
+
+	// comparison is "before", "after", "since" or "until" while one is
+	// waiting for its year or decade.
+	comparison := ""
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if decade, ok := decadeOf(w); ok {
+			switch comparison {
+			case "before":
+				f.YearMax = decade - 1
+			case "until":
+				f.YearMax = decade + 9
+			case "after":
+				f.YearMin = decade + 10
+			case "since":
+				f.YearMin = decade
+			default:
+				f.YearMin, f.YearMax = decade, decade+9
+			}
+			comparison = ""
+			continue
+		}
+		if yearPattern.MatchString(w) {
+			year, _ := strconv.Atoi(w)
+			switch comparison {
+			case "before":
+				f.YearMax = year - 1
+			case "until":
+				f.YearMax = year
+			case "after":
+				f.YearMin = year + 1
+			case "since":
+				f.YearMin = year
+			default:
+				f.YearMin, f.YearMax = year, year
+			}
+			comparison = ""
+			continue
+		}
+		switch {
+		case w == "before" || w == "after" || w == "since" || w == "until":
+			comparison = w
+			continue
+		case w == "by":
+			start := i + 1
+			for i+1 < len(words) && (i+1 == start || !isKeyword(words[i+1])) {
+				i++
+			}
+			f.Artist = strings.Join(words[start:i+1], " ")
+			continue
+		case naturalModifiers[w] != nil:
+			naturalModifiers[w](&f)
+			continue
+		}
+		if genre, n := matchPhrase(i, genres); n > 0 {
+			f.Genres = append(f.Genres, genre)
+			i += n - 1
+			continue
+		}
+		if tag, n := matchPhrase(i, tags); n > 0 {
+			f.Tags = append(f.Tags, tag)
+			i += n - 1
+			continue
+		}
+		if !naturalStopWords[w] {
+			f.Words = append(f.Words, w)
+		}
+	}
+	return f, nil
+}
+
+// httpResolver asks an external service, such as one wrapping an LLM, to
+// resolve a search. It posts {"query", "genres", "tags"} and expects the
+// filters back as JSON.
+type httpResolver struct {
+	url, token string
+	httpClient *http.Client
+}
+
+func newHTTPResolver(url, token string) httpResolver {
+	return httpResolver{url: url, token: token, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (r httpResolver) Resolve(ctx context.Context, text string, genres, tags []string) (naturalFilters, error) {
+	body, _ := json.Marshal(gin.H{"query": text, "genres": genres, "tags": tags})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return naturalFilters{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return naturalFilters{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return naturalFilters{}, fmt.Errorf("%s: %s", r.url, resp.Status)
+	}
+	var f naturalFilters
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return naturalFilters{}, fmt.Errorf("%s: bad response: %w", r.url, err)
+	}
+	return f, nil
+}
+
+// libraryVocabulary lists the distinct genres and tags in the library,
+// for resolvers to match against. Callers must hold albumsMu.
+func libraryVocabulary() (genres, tags []string) {
+	seen := map[string]bool{}
+	for _, a := range albums {
+		if g := strings.ToLower(a.Genre); g != "" && !seen["genre:"+g] {
+			seen["genre:"+g] = true
+			genres = append(genres, a.Genre)
+		}
+		for _, tag := range a.Tags {
+			if !seen["tag:"+tag] {
+				seen["tag:"+tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return genres, tags
+}
+
+type naturalSearchResults struct {
+	Filters naturalFilters `json:"filters"`
+	// Query is the filters in the query language of /search.
+	Query string `json:"query"`
+	searchResults
+}
+
+// getNaturalSearch searches for ?q in plain words, such as "upbeat jazz
+// from the 60s", responding with the filters it was taken to mean and
+// their results.
+func getNaturalSearch(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "q is required"})
+		return
+	}
+
+	albumsMu.RLock()
+	genres, tags := libraryVocabulary()
+	albumsMu.RUnlock()
+	filters, err := naturalSearch.Resolve(c.Request.Context(), text, genres, tags)
+	if err != nil {
+		log.Printf("natural search: %v; falling back to rules", err)
+		filters, _ = rulesResolver{}.Resolve(c.Request.Context(), text, genres, tags)
+	}
+	q := filters.query()
+	if q == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "q has nothing to search for"})
+		return
+	}
+	results, ok := searchPage(c, q)
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, naturalSearchResults{Filters: filters, Query: q, searchResults: results})
+}
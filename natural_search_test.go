@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// resolverFunc lets a test stand in for an external resolver.
+type resolverFunc func(text string, genres, tags []string) (naturalFilters, error)
+
+func (f resolverFunc) Resolve(_ context.Context, text string, genres, tags []string) (naturalFilters, error) {
+	return f(text, genres, tags)
+}
+
+func TestNaturalSearch(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz", Year: 1957},
+		{ID: "2", Title: "Speak No Evil", Artist: "Wayne Shorter", Genre: "Jazz", Year: 1966},
+		{ID: "3", Title: "Revolver", Artist: "The Beatles", Genre: "Rock", Year: 1966, Tags: []string{"british"}},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Locomotion", Analysis: &trackAnalysis{BPM: 240}},
+		{ID: "2", AlbumID: "2", Title: "Witch Hunt", Analysis: &trackAnalysis{BPM: 140}},
+		{ID: "3", AlbumID: "2", Title: "Infant Eyes", Analysis: &trackAnalysis{BPM: 60}},
+		{ID: "4", AlbumID: "3", Title: "Taxman", Artist: "The Beatles", Analysis: &trackAnalysis{BPM: 130}},
+	}
+	search := func(q string) (int, naturalSearchResults) {
+		rr := performRequest(router, "GET", "/search/natural?q="+q, nil, "")
+		var results naturalSearchResults
+		json.Unmarshal(rr.Body.Bytes(), &results)
+		return rr.Code, results
+	}
+
+	// Check genre, tempo and decade words become filters
+	code, results := search("upbeat+jazz+from+the+60s")
+	want := naturalFilters{Genres: []string{"Jazz"}, YearMin: 1960, YearMax: 1969, BPMMin: 120}
+	if code != http.StatusOK || !reflect.DeepEqual(results.Filters, want) || results.Query != `genre="Jazz" year>=1960 year<=1969 bpm>=120` {
+		t.Errorf("Expected %+v, but got %d %+v %q", want, code, results.Filters, results.Query)
+	}
+	if results.TotalTracks != 1 || results.Tracks[0].ID != "2" {
+		t.Errorf("Expected track 2, but got %+v", results.Tracks)
+	}
+
+	// Check artists, tags, comparisons and leftover words
+	code, results = search("british+songs+by+the+beatles+before+1970+taxman")
+	want = naturalFilters{Artist: "the beatles", Tags: []string{"british"}, YearMax: 1969, Words: []string{"taxman"}}
+	if !reflect.DeepEqual(results.Filters, want) || results.TotalTracks != 1 || results.Tracks[0].ID != "4" {
+		t.Errorf("Expected %+v and track 4, but got %+v %+v", want, results.Filters, results.Tracks)
+	}
+
+	// Check a search of only filler words is rejected
+	if code, _ = search("play+some+music"); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, code)
+	}
+
+	// Check an external resolver is given the vocabulary and used
+	naturalSearch = resolverFunc(func(text string, genres, tags []string) (naturalFilters, error) {
+		if text != "coltrane" || !reflect.DeepEqual(genres, []string{"Jazz", "Rock"}) || !reflect.DeepEqual(tags, []string{"british"}) {
+			t.Errorf("Expected the query and vocabulary, but got %q %v %v", text, genres, tags)
+		}
+		return naturalFilters{Artist: "John Coltrane"}, nil
+	})
+	t.Cleanup(func() { naturalSearch = rulesResolver{} })
+	if _, results = search("coltrane"); results.Query != `artist:"John Coltrane"` || results.TotalAlbums != 1 {
+		t.Errorf("Expected album 1, but got %+v", results)
+	}
+
+	// Check the rules are used when it fails
+	naturalSearch = resolverFunc(func(string, []string, []string) (naturalFilters, error) {
+		return naturalFilters{}, errors.New("unavailable")
+	})
+	if code, results = search("mellow+jazz"); code != http.StatusOK || results.TotalTracks != 1 || results.Tracks[0].ID != "3" {
+		t.Errorf("Expected track 3, but got %d %+v", code, results)
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Query string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("Authorization") != "Bearer secret" || body.Query != "sad songs" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"bpmMax": 80, "words": ["sad"]}`))
+	}))
+	defer server.Close()
+
+	// Check the filters in the response are returned
+	f, err := newHTTPResolver(server.URL, "secret").Resolve(context.Background(), "sad songs", nil, nil)
+	if err != nil || f.BPMMax != 80 || len(f.Words) != 1 {
+		t.Errorf("Expected the filters, but got %+v %v", f, err)
+	}
+
+	// Check error statuses are errors
+	if _, err = newHTTPResolver(server.URL, "wrong").Resolve(context.Background(), "sad songs", nil, nil); err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestDecadeOf(t *testing.T) {
+	for word, want := range map[string]int{"60s": 1960, "'90s": 1990, "10s": 2010, "1970s": 1970, "eighties": 1980, "65s": 0, "jazz": 0} {
+		if got, _ := decadeOf(word); got != want {
+			t.Errorf("Expected %q to be %d, but got %d", word, want, got)
+		}
+	}
+}
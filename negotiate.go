@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	decisionDirectPlay = "direct-play"
+	decisionTranscode  = "transcode"
+	// minTranscodeBitrate and maxTranscodeBitrate bound requested transcode
+	// bitrates, in kbps.
+	minTranscodeBitrate = 32
+	maxTranscodeBitrate = 320
+)
+
+// transcodeProfile is how ffmpeg produces one codec for streaming.
+type transcodeProfile struct {
+	Encoder  string
+	Format   string
+	MimeType string
+	// Bitrate is the default target in kbps.
+	Bitrate int
+}
+
+// transcodeProfiles are the codecs the server can transcode to.
+// transcodeOrder is the order they are offered in, best quality per bit
+// first.
+var (
+	transcodeProfiles = map[string]transcodeProfile{
+		"opus": {Encoder: "libopus", Format: "ogg", MimeType: "audio/ogg", Bitrate: 128},
+		"aac":  {Encoder: "aac", Format: "adts", MimeType: "audio/aac", Bitrate: 192},
+		"mp3":  {Encoder: "libmp3lame", Format: "mp3", MimeType: "audio/mpeg", Bitrate: 192},
+	}
+	transcodeOrder = []string{"opus", "aac", "mp3"}
+)
+
+// transcoder is the ffmpeg used for transcoding; it is set from
+// configuration at startup, and empty disables transcoding.
+var transcoder string
+
+// karaokeFilter takes the vocals out of a stereo mix by cancelling what is
+// the same in both channels, where vocals are usually mixed, above 150 Hz;
+// the bass below it is kept whole so the backing still carries. Mono files
+// lose everything above the bass.
+const karaokeFilter = "aformat=channel_layouts=stereo,asplit=2[full][sides];" +
+	"[full]lowpass=f=150[bass];" +
+	"[sides]pan=stereo|c0=c0-c1|c1=c1-c0,highpass=f=150[backing];" +
+	"[bass][backing]amix=inputs=2,volume=2"
+
+// karaokeCodec is what karaoke streams are transcoded to when no codec is
+// asked for, since every client plays it.
+const karaokeCodec = "mp3"
+
+type streamDecision struct {
+	TrackID  string `json:"trackId"`
+	Decision string `json:"decision"`
+	Codec    string `json:"codec"`
+	// Bitrate is in kbps, or 0 when the file's bitrate is unknown.
+	Bitrate int    `json:"bitrate,omitempty"`
+	URL     string `json:"url"`
+	Reason  string `json:"reason"`
+}
+
+// negotiateStream picks how a client plays t. The file is played as it is
+// when the client supports its codec and bitrate; otherwise it is
+// transcoded to the best codec both sides support. ok is false when
+// neither works.
+func negotiateStream(t track, codecs []string, maxBitrate int) (streamDecision, bool) {
+	d := streamDecision{TrackID: t.ID, Codec: t.Format, Bitrate: t.Bitrate, URL: "/tracks/" + t.ID + "/stream"}
+	format := strings.ToLower(t.Format)
+	codecOK := format != "" && slices.Contains(codecs, format)
+	bitrateOK := maxBitrate == 0 || t.Bitrate == 0 || t.Bitrate <= maxBitrate
+	if codecOK && bitrateOK {
+		d.Decision, d.Reason = decisionDirectPlay, "the client supports the file as it is"
+		return d, true
+	}
+
+	if transcoder != "" {
+		for _, codec := range transcodeOrder {
+			if !slices.Contains(codecs, codec) {
+				continue
+			}
+			bitrate := transcodeProfiles[codec].Bitrate
+			if maxBitrate > 0 {
+				bitrate = min(bitrate, maxBitrate)
+			}
+			if t.Bitrate > 0 {
+				bitrate = min(bitrate, t.Bitrate)
+			}
+			bitrate = max(bitrate, minTranscodeBitrate)
+			d.Decision, d.Codec, d.Bitrate = decisionTranscode, codec, bitrate
+			d.URL += "?codec=" + codec + "&bitrate=" + strconv.Itoa(bitrate)
+			d.Reason = "the client does not support " + format
+			if codecOK {
+				d.Reason = "the file's bitrate exceeds maxBitrate"
+			}
+			return d, true
+		}
+	}
+	if codecOK {
+		d.Decision, d.Reason = decisionDirectPlay, "the file's bitrate exceeds maxBitrate, but it cannot be transcoded"
+		return d, true
+	}
+	return d, false
+}
+
+// postStreamNegotiate tells a client how to play a track given the codecs
+// and bitrate it can handle.
+func postStreamNegotiate(c *gin.Context) {
+	var input struct {
+		TrackID string   `json:"trackId"`
+		Codecs  []string `json:"codecs"`
+		// MaxBitrate is in kbps; 0 means no limit.
+		MaxBitrate int `json:"maxBitrate"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if len(input.Codecs) == 0 || input.MaxBitrate < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "codecs are required and maxBitrate must not be negative"})
+		return
+	}
+	for i, codec := range input.Codecs {
+		input.Codecs[i] = strings.ToLower(strings.TrimSpace(codec))
+	}
+
+	albumsMu.RLock()
+	i := findTrack(input.TrackID)
+	var t track
+	if i >= 0 {
+		t = tracks[i]
+	}
+	albumsMu.RUnlock()
+
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	d, ok := negotiateStream(t, input.Codecs, input.MaxBitrate)
+	if !ok {
+		c.IndentedJSON(http.StatusNotAcceptable, gin.H{"message": "the client supports none of the codecs this track can be played in"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, d)
+}
+
+// wantsTranscode says whether a stream request needs ffmpeg: for a codec,
+// or for karaoke. A karaoke value that is not a boolean does too, so that
+// transcodeStream rejects it.
+func wantsTranscode(c *gin.Context) bool {
+	karaoke, err := strconv.ParseBool(c.DefaultQuery("karaoke", "false"))
+	return c.Query("codec") != "" || karaoke || err != nil
+}
+
+// transcodeStream sends path transcoded by ffmpeg to the codec and bitrate
+// in the query, with the vocals reduced when ?karaoke=true. Transcodes are
+// produced on the fly, so they support neither ranges nor caching.
+func transcodeStream(c *gin.Context, path string) {
+	karaoke, err := strconv.ParseBool(c.DefaultQuery("karaoke", "false"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "karaoke must be true or false"})
+		return
+	}
+	codec := c.Query("codec")
+	if codec == "" && karaoke {
+		codec = karaokeCodec
+	}
+	profile, ok := transcodeProfiles[codec]
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "codec must be one of " + strings.Join(transcodeOrder, ", ")})
+		return
+	}
+	bitrate := profile.Bitrate
+	if v := c.Query("bitrate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minTranscodeBitrate || n > maxTranscodeBitrate {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bitrate must be between " + strconv.Itoa(minTranscodeBitrate) + " and " + strconv.Itoa(maxTranscodeBitrate)})
+			return
+		}
+		bitrate = n
+	}
+	if transcoder == "" {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "transcoding is not configured"})
+		return
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "error", "-i", path, "-map", "0:a:0"}
+	if karaoke {
+		args = append(args, "-af", karaokeFilter)
+	}
+	args = append(args, "-c:a", profile.Encoder, "-b:a", strconv.Itoa(bitrate)+"k", "-f", profile.Format, "pipe:1")
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(c.Request.Context(), transcoder, args...)
+	cmd.Stdout, cmd.Stderr = c.Writer, &stderr
+	c.Header("Content-Type", profile.MimeType)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Accept-Ranges", "none")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if err := cmd.Run(); err != nil && !c.Writer.Written() {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "ffmpeg: " + err.Error() + ": " + string(bytes.TrimSpace(stderr.Bytes()))})
+	}
+}
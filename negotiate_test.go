@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamNegotiation(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	savedTranscoder := transcoder
+	t.Cleanup(func() { transcoder = savedTranscoder })
+	transcoder = ""
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "01.flac"), []byte("flac"), 0o644)
+	tracks = []track{{ID: "1", Format: "flac", Bitrate: 900, Path: filepath.Join(dir, "01.flac")}}
+
+	negotiate := func(body string) (int, streamDecision) {
+		rr := performRequest(router, "POST", "/streams/negotiate", strings.NewReader(body), "")
+		var d streamDecision
+		json.Unmarshal(rr.Body.Bytes(), &d)
+		return rr.Code, d
+	}
+
+	// Check supported files are played as they are
+	if code, d := negotiate(`{"trackId": "1", "codecs": ["FLAC", "mp3"]}`); code != http.StatusOK || d.Decision != decisionDirectPlay || d.URL != "/tracks/1/stream" {
+		t.Errorf("Expected direct play, but got %d %+v", code, d)
+	}
+
+	// Check without a transcoder only direct play is offered
+	if code, d := negotiate(`{"trackId": "1", "codecs": ["flac"], "maxBitrate": 320}`); code != http.StatusOK || d.Decision != decisionDirectPlay {
+		t.Errorf("Expected direct play despite the bitrate, but got %d %+v", code, d)
+	}
+	if code, _ := negotiate(`{"trackId": "1", "codecs": ["mp3"]}`); code != http.StatusNotAcceptable {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotAcceptable, code)
+	}
+
+	// Check the best common codec is chosen within the bitrate limit
+	script := filepath.Join(dir, "ffmpeg")
+	os.WriteFile(script, []byte("#!/bin/sh\nprintf transcoded\n"), 0o755)
+	transcoder = script
+	code, d := negotiate(`{"trackId": "1", "codecs": ["mp3", "aac"], "maxBitrate": 160}`)
+	if code != http.StatusOK || d.Decision != decisionTranscode || d.Codec != "aac" || d.Bitrate != 160 {
+		t.Errorf("Expected aac at 160 kbps, but got %d %+v", code, d)
+	}
+	if d.URL != "/tracks/1/stream?codec=aac&bitrate=160" {
+		t.Errorf("Expected a transcode URL, but got %q", d.URL)
+	}
+
+	// Check the transcode URL streams ffmpeg's output
+	rr := performRequest(router, "GET", d.URL, nil, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "transcoded" || rr.Header().Get("Content-Type") != "audio/aac" {
+		t.Errorf("Expected the transcode, but got %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+	if rr := performRequest(router, "GET", "/tracks/1/stream?codec=wav", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestKaraokeStream(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	savedTranscoder := transcoder
+	t.Cleanup(func() { transcoder = savedTranscoder })
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "01.flac"), []byte("flac"), 0o644)
+	tracks = []track{{ID: "1", Format: "flac", Path: filepath.Join(dir, "01.flac")}}
+	script := filepath.Join(dir, "ffmpeg")
+	os.WriteFile(script, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0o755)
+	transcoder = script
+
+	// Check karaoke filters the vocals out of an mp3 unless another codec is asked for
+	rr := performRequest(router, "GET", "/tracks/1/stream?karaoke=true", nil, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "audio/mpeg" || !strings.Contains(rr.Body.String(), "-af\n"+karaokeFilter+"\n") {
+		t.Errorf("Expected an mp3 with the karaoke filter, but got %d %v %q", rr.Code, rr.Header(), rr.Body.String())
+	}
+	rr = performRequest(router, "GET", "/tracks/1/stream?karaoke=1&codec=opus", nil, "")
+	if rr.Header().Get("Content-Type") != "audio/ogg" || !strings.Contains(rr.Body.String(), karaokeFilter) {
+		t.Errorf("Expected opus with the karaoke filter, but got %v %q", rr.Header(), rr.Body.String())
+	}
+	rr = performRequest(router, "GET", "/tracks/1/stream?karaoke=false&codec=opus", nil, "")
+	if strings.Contains(rr.Body.String(), "-af") {
+		t.Errorf("Expected no filter, but got %q", rr.Body.String())
+	}
+
+	// Check karaoke=false alone plays the file, and bad values are rejected
+	if rr = performRequest(router, "GET", "/tracks/1/stream?karaoke=false", nil, ""); rr.Body.String() != "flac" {
+		t.Errorf("Expected the file, but got %q", rr.Body.String())
+	}
+	if rr = performRequest(router, "GET", "/tracks/1/stream?karaoke=yes", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
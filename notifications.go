@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The kinds of notification, which users can mute per kind.
+const (
+	notifyScanFinished = "scan.finished"
+	notifyJobFailed    = "job.failed"
+	notifyShareOpened  = "share.opened"
+	notifyPriceDrop    = "price.drop"
+	notifyNewRelease   = "release.new"
+	notifyUploadReview = "upload.review"
+	notifyTest         = "test"
+)
+
+var notifyKinds = map[string]bool{notifyScanFinished: true, notifyJobFailed: true, notifyShareOpened: true, notifyPriceDrop: true, notifyNewRelease: true, notifyUploadReview: true, notifyTest: true}
+
+// maxInbox is how many notifications the inbox keeps per user.
+const maxInbox = 500
+
+// notification is a message for one user. Every notification lands in the
+// user's inbox and goes out on the channels they have set up.
+type notification struct {
+	ID    string `json:"id"`
+	User  string `json:"user"`
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+	// Link is a path on this server the notification is about.
+	Link      string     `json:"link,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+}
+
+// notifyPrefs are a user's channel preferences.
+type notifyPrefs struct {
+	// Webhook receives each notification as a JSON POST.
+	Webhook string `json:"webhook,omitempty"`
+	// Email receives each notification by mail, when SMTP is configured.
+	Email  string      `json:"email,omitempty"`
+	Gotify *pushTarget `json:"gotify,omitempty"`
+	Ntfy   *pushTarget `json:"ntfy,omitempty"`
+	// Muted lists kinds kept to the inbox.
+	Muted []string `json:"muted,omitempty"`
+}
+
+// pushTarget is a push server: for Gotify its root and an application
+// token, for ntfy the topic's URL and an optional access token.
+type pushTarget struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"`
+}
+
+// notifyResult says which channels a notification went out on and why
+// others failed.
+type notifyResult struct {
+	Sent   []string          `json:"sent"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// notifyChannel delivers notifications outside the server.
+type notifyChannel interface {
+	name() string
+	// configured reports whether the user has set the channel up.
+	configured(p notifyPrefs) bool
+	send(ctx context.Context, p notifyPrefs, n notification) error
+}
+
+// smtpSettings is set from configuration at startup; an empty Addr
+// disables email.
+type smtpSettings struct {
+	Addr, Username, Password, From string
+}
+
+// notifyMu guards inboxes and notifyPreferences. adminUsers, who are told
+// about scans and failed jobs, is set from configuration at startup.
+var (
+	notifyMu          sync.Mutex
+	inboxes           = map[string][]notification{}
+	notifyPreferences = map[string]notifyPrefs{}
+	adminUsers        []string
+	notifyChannels    = []notifyChannel{webhookChannel{}, emailChannel{}, gotifyChannel{}, ntfyChannel{}}
+	smtpConfig        smtpSettings
+	notifyClient      = &http.Client{Timeout: 10 * time.Second}
+	// sendMail is smtp.SendMail, replaced in tests.
+	sendMail = smtp.SendMail
+)
+
+// deliver puts n in its user's inbox and returns it with its id set,
+// together with the user's preferences.
+func deliver(n notification) (notification, notifyPrefs) {
+	n.ID, n.CreatedAt = newID(), time.Now().UTC()
+
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	inbox := append(inboxes[n.User], n)
+	inboxes[n.User] = inbox[max(0, len(inbox)-maxInbox):]
+	return n, notifyPreferences[n.User]
+}
+
+// sendChannels sends n on each channel the user has set up, unless they
+// muted its kind.
+func sendChannels(ctx context.Context, p notifyPrefs, n notification) notifyResult {
+	result := notifyResult{Sent: []string{"inbox"}}
+	if slices.Contains(p.Muted, n.Kind) {
+		return result
+	}
+	for _, ch := range notifyChannels {
+		if !ch.configured(p) {
+			continue
+		}
+		if err := ch.send(ctx, p, n); err != nil {
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[ch.name()] = err.Error()
+			continue
+		}
+		result.Sent = append(result.Sent, ch.name())
+	}
+	return result
+}
+
+// notify sends n to its user and waits for the channels.
+func notify(ctx context.Context, n notification) notifyResult {
+	n, p := deliver(n)
+	return sendChannels(ctx, p, n)
+}
+
+// notifyLater puts n in its user's inbox and sends it on their channels in
+// the background, for callers that should not wait on other servers.
+func notifyLater(n notification) {
+	n, p := deliver(n)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		for channel, msg := range sendChannels(ctx, p, n).Errors {
+			log.Printf("notify %s: %s: %s", n.User, channel, msg)
+		}
+	}()
+}
+
+// notifyAdmins sends a notification to every admin.
+func notifyAdmins(kind, title, body, link string) {
+	for _, user := range adminUsers {
+		notifyLater(notification{User: user, Kind: kind, Title: title, Body: body, Link: link})
+	}
+}
+
+// postJSON posts v to url, failing on an error status.
+func postJSON(ctx context.Context, url string, v any, header http.Header) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotify(req)
+}
+
+func doNotify(req *http.Request) error {
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+type webhookChannel struct{}
+
+func (webhookChannel) name() string                  { return "webhook" }
+func (webhookChannel) configured(p notifyPrefs) bool { return p.Webhook != "" }
+func (webhookChannel) send(ctx context.Context, p notifyPrefs, n notification) error {
+	return postJSON(ctx, p.Webhook, n, nil)
+}
+
+type emailChannel struct{}
+
+func (emailChannel) name() string                  { return "email" }
+func (emailChannel) configured(p notifyPrefs) bool { return p.Email != "" && smtpConfig.Addr != "" }
+func (emailChannel) send(_ context.Context, p notifyPrefs, n notification) error {
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		host, _, _ := strings.Cut(smtpConfig.Addr, ":")
+		auth = smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, host)
+	}
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Title)
+	msg := "From: " + smtpConfig.From + "\r\nTo: " + p.Email + "\r\nSubject: " + subject + "\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + n.Body + "\r\n"
+	return sendMail(smtpConfig.Addr, auth, smtpConfig.From, []string{p.Email}, []byte(msg))
+}
+
+type gotifyChannel struct{}
+
+func (gotifyChannel) name() string                  { return "gotify" }
+func (gotifyChannel) configured(p notifyPrefs) bool { return p.Gotify != nil }
+func (gotifyChannel) send(ctx context.Context, p notifyPrefs, n notification) error {
+	header := http.Header{"X-Gotify-Key": {p.Gotify.Token}}
+	return postJSON(ctx, strings.TrimSuffix(p.Gotify.URL, "/")+"/message", gin.H{"title": n.Title, "message": n.Body, "priority": 5}, header)
+}
+
+type ntfyChannel struct{}
+
+func (ntfyChannel) name() string                  { return "ntfy" }
+func (ntfyChannel) configured(p notifyPrefs) bool { return p.Ntfy != nil }
+func (ntfyChannel) send(ctx context.Context, p notifyPrefs, n notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Ntfy.URL, strings.NewReader(n.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", n.Title)
+	req.Header.Set("Tags", n.Kind)
+	if p.Ntfy.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Ntfy.Token)
+	}
+	return doNotify(req)
+}
+
+// getNotifications lists the user's inbox, newest first; with
+// ?unread=true only what they have not read.
+func getNotifications(c *gin.Context) {
+	unread := c.Query("unread") == "true"
+
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	// The inbox is kept in the order notifications arrived.
+	inbox := inboxes[c.GetString(gin.AuthUserKey)]
+	list := []notification{}
+	for i := len(inbox) - 1; i >= 0; i-- {
+		if !unread || inbox[i].ReadAt == nil {
+			list = append(list, inbox[i])
+		}
+	}
+	respondList(c, list)
+}
+
+// postNotificationRead marks one notification as read.
+func postNotificationRead(c *gin.Context) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	inbox := inboxes[c.GetString(gin.AuthUserKey)]
+	for i := range inbox {
+		if inbox[i].ID == c.Param("id") {
+			if inbox[i].ReadAt == nil {
+				now := time.Now().UTC()
+				inbox[i].ReadAt = &now
+			}
+			c.IndentedJSON(http.StatusOK, inbox[i])
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "notification not found"})
+}
+
+// postNotificationsRead marks the whole inbox as read.
+func postNotificationsRead(c *gin.Context) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	now, marked := time.Now().UTC(), 0
+	inbox := inboxes[c.GetString(gin.AuthUserKey)]
+	for i := range inbox {
+		if inbox[i].ReadAt == nil {
+			inbox[i].ReadAt = &now
+			marked++
+		}
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func deleteNotification(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	inbox := inboxes[user]
+	for i, n := range inbox {
+		if n.ID == c.Param("id") {
+			inboxes[user] = append(inbox[:i], inbox[i+1:]...)
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "notification not found"})
+}
+
+func getNotifyPrefs(c *gin.Context) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, notifyPreferences[c.GetString(gin.AuthUserKey)])
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// putNotifyPrefs replaces the user's channel preferences.
+func putNotifyPrefs(c *gin.Context) {
+	var input notifyPrefs
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Webhook != "" && !isHTTPURL(input.Webhook) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "webhook must be an http or https address"})
+		return
+	}
+	if strings.ContainsAny(input.Email, "\r\n") || input.Email != "" && !strings.Contains(input.Email, "@") {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "email is not an address"})
+		return
+	}
+	for name, target := range map[string]*pushTarget{"gotify": input.Gotify, "ntfy": input.Ntfy} {
+		if target != nil && !isHTTPURL(target.URL) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": name + " url must be an http or https address"})
+			return
+		}
+	}
+	if input.Gotify != nil && input.Gotify.Token == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "gotify needs an application token"})
+		return
+	}
+	for _, kind := range input.Muted {
+		if !notifyKinds[kind] {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("unknown notification kind %q", kind)})
+			return
+		}
+	}
+
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	notifyPreferences[c.GetString(gin.AuthUserKey)] = input
+	c.IndentedJSON(http.StatusOK, input)
+}
+
+// postNotificationTest sends the user a test notification on every channel
+// they have set up and reports how each went.
+func postNotificationTest(c *gin.Context) {
+	result := notify(c.Request.Context(), notification{User: c.GetString(gin.AuthUserKey), Kind: notifyTest, Title: "Test notification", Body: "Notifications reach you here."})
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+// notificationState is how inboxes and preferences are backed up.
+type notificationState struct {
+	Preferences map[string]notifyPrefs    `json:"preferences"`
+	Inboxes     map[string][]notification `json:"inboxes"`
+}
+
+func dumpNotifications() ([]byte, error) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+
+	return json.MarshalIndent(notificationState{Preferences: notifyPreferences, Inboxes: inboxes}, "", "    ")
+}
+
+func restoreNotifications(data []byte) (func(), int, error) {
+	var restored notificationState
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored.Preferences == nil {
+		restored.Preferences = map[string]notifyPrefs{}
+	}
+	if restored.Inboxes == nil {
+		restored.Inboxes = map[string][]notification{}
+	}
+	apply := func() {
+		notifyMu.Lock()
+		notifyPreferences, inboxes = restored.Preferences, restored.Inboxes
+		notifyMu.Unlock()
+	}
+	return apply, len(restored.Preferences), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNotificationChannels(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	var mu sync.Mutex
+	got := map[string]string{}
+	push := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/gotify/message":
+			got["gotify"] = r.Header.Get("X-Gotify-Key") + " " + string(body)
+		case "/ntfy/music":
+			got["ntfy"] = r.Header.Get("Authorization") + " " + r.Header.Get("Title") + " " + string(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer push.Close()
+
+	// Check preferences are validated
+	for _, body := range []string{
+		`{"webhook": "ftp://example.com"}`,
+		`{"gotify": {"url": "` + push.URL + `/gotify"}}`,
+		`{"muted": ["nonsense"]}`,
+	} {
+		if rr := performRequest(router, "PUT", "/me/notifications/preferences", strings.NewReader(body), "bob"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+
+	// Check a test notification goes to the inbox, Gotify and ntfy, and reports a broken webhook
+	prefs := `{"webhook": "` + push.URL + `/missing", "gotify": {"url": "` + push.URL + `/gotify/", "token": "app"}, "ntfy": {"url": "` + push.URL + `/ntfy/music", "token": "tk"}}`
+	if rr := performRequest(router, "PUT", "/me/notifications/preferences", strings.NewReader(prefs), "bob"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	rr := performRequest(router, "POST", "/me/notifications/test", nil, "bob")
+	var result notifyResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if strings.Join(result.Sent, ",") != "inbox,gotify,ntfy" || result.Errors["webhook"] == "" {
+		t.Errorf("Expected inbox, gotify and ntfy with a webhook error, but got %+v", result)
+	}
+	if !strings.HasPrefix(got["gotify"], `app {"message":"Notifications reach you here."`) {
+		t.Errorf("Expected a Gotify message with the app token, but got %q", got["gotify"])
+	}
+	if got["ntfy"] != "Bearer tk Test notification Notifications reach you here." {
+		t.Errorf("Expected an ntfy message, but got %q", got["ntfy"])
+	}
+
+	// Check muted kinds stay in the inbox
+	performRequest(router, "PUT", "/me/notifications/preferences", strings.NewReader(`{"ntfy": {"url": "`+push.URL+`/ntfy/music"}, "muted": ["test"]}`), "bob")
+	rr = performRequest(router, "POST", "/me/notifications/test", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if strings.Join(result.Sent, ",") != "inbox" {
+		t.Errorf("Expected only the inbox, but got %+v", result)
+	}
+}
+
+func TestNotificationInbox(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	saved := adminUsers
+	adminUsers = []string{"alice"}
+	t.Cleanup(func() { adminUsers = saved })
+
+	// Check a library import tells the admins
+	rr := performRequest(router, "POST", "/library/import", strings.NewReader(`[{"path": "/m/1.flac", "title": "One", "album": "First"}]`), "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	// Check a task notifies when it starts failing, but not again while it keeps failing
+	s := newScheduler()
+	s.add("flaky", "* * * * *", func(context.Context) error { return errors.New("disk full") })
+	s.execute(context.Background(), s.tasks["flaky"])
+	s.execute(context.Background(), s.tasks["flaky"])
+
+	rr = performRequest(router, "GET", "/me/notifications?unread=true", nil, "alice")
+	var inbox []notification
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox) != 2 || inbox[0].Kind != notifyJobFailed || inbox[1].Kind != notifyScanFinished || !strings.HasPrefix(inbox[1].Body, "1 tracks added") {
+		t.Fatalf("Expected a failed job and a finished scan, but got %+v", inbox)
+	}
+	if rr := performRequest(router, "GET", "/me/notifications", nil, "bob"); strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected nothing for bob, but got %s", rr.Body)
+	}
+
+	// Check notifications can be read one at a time or all at once, and deleted
+	if rr := performRequest(router, "POST", "/me/notifications/"+inbox[0].ID+"/read", nil, "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for another user's notification, but got %d", http.StatusNotFound, rr.Code)
+	}
+	performRequest(router, "POST", "/me/notifications/"+inbox[0].ID+"/read", nil, "alice")
+	rr = performRequest(router, "GET", "/me/notifications?unread=true", nil, "alice")
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox) != 1 {
+		t.Errorf("Expected 1 unread notification, but got %d", len(inbox))
+	}
+	rr = performRequest(router, "POST", "/me/notifications/read", nil, "alice")
+	if !strings.Contains(rr.Body.String(), `"marked": 1`) {
+		t.Errorf("Expected 1 marked, but got %s", rr.Body)
+	}
+	if rr := performRequest(router, "DELETE", "/me/notifications/"+inbox[0].ID, nil, "alice"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/me/notifications", nil, "alice")
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox) != 1 || inbox[0].ReadAt == nil {
+		t.Errorf("Expected 1 read notification left, but got %+v", inbox)
+	}
+
+	// Check the owner hears once when a share is first opened by someone else
+	rr = performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1"}`), "bob")
+	var link shareView
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	performRequest(router, "GET", "/s/"+link.ID, nil, "bob")
+	performRequest(router, "GET", "/s/"+link.ID, nil, "")
+	performRequest(router, "GET", "/s/"+link.ID, nil, "")
+	rr = performRequest(router, "GET", "/me/notifications", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox) != 1 || inbox[0].Kind != notifyShareOpened || inbox[0].Body != "Someone opened your link to Blue Train." {
+		t.Errorf("Expected one share notification, but got %+v", inbox)
+	}
+}
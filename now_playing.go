@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nextUpCount is how many upcoming tracks /player/now-playing lists.
+const nextUpCount = 5
+
+type lyricLine struct {
+	// Time is the offset in seconds where the line starts; nil for lyrics
+	// that are not synced.
+	Time *float64 `json:"time,omitempty"`
+	Text string   `json:"text"`
+}
+
+var lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d+(?:\.\d+)?)\]`)
+
+// parseLRC reads lyrics in LRC format. Lines may carry several timestamps,
+// and metadata tags such as [ar:...] are dropped. Text without timestamps is
+// kept as unsynced lyrics, unless other lines are synced.
+func parseLRC(text string) []lyricLine {
+	var lines, synced []lyricLine
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var times []float64
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			mins, _ := strconv.ParseFloat(m[1], 64)
+			secs, _ := strconv.ParseFloat(m[2], 64)
+			times = append(times, mins*60+secs)
+			line = line[len(m[0]):]
+		}
+		switch {
+		case len(times) > 0:
+			for i := range times {
+				synced = append(synced, lyricLine{Time: &times[i], Text: strings.TrimSpace(line)})
+			}
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+		case line != "":
+			lines = append(lines, lyricLine{Text: line})
+		}
+	}
+	if len(synced) == 0 {
+		return lines
+	}
+	sort.SliceStable(synced, func(i, j int) bool { return *synced[i].Time < *synced[j].Time })
+	return synced
+}
+
+// currentLyric returns the synced line being sung at elapsed, or "" when the
+// lyrics are not synced or the first line has not started yet.
+func currentLyric(lines []lyricLine, elapsed float64) string {
+	current := ""
+	for _, l := range lines {
+		if l.Time == nil {
+			return ""
+		}
+		if *l.Time > elapsed {
+			break
+		}
+		current = l.Text
+	}
+	return current
+}
+
+// putTrackLyrics stores the lyrics of a track, given as LRC or plain text.
+func putTrackLyrics(c *gin.Context) {
+	var input struct {
+		LRC string `json:"lrc"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	tracks[i].Lyrics = parseLRC(input.LRC)
+	c.IndentedJSON(http.StatusOK, tracks[i])
+}
+
+type nowPlayingTrack struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album,omitempty"`
+	AlbumID  string  `json:"albumId,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	CoverURL string  `json:"coverUrl,omitempty"`
+}
+
+type nowPlaying struct {
+	State     string           `json:"state"`
+	Track     *nowPlayingTrack `json:"track,omitempty"`
+	Elapsed   float64          `json:"elapsed"`
+	Remaining *float64         `json:"remaining,omitempty"`
+	Lyric     string           `json:"lyric,omitempty"`
+	// Lyrics are the synced lyrics of the track, for singing along, when
+	// asked for with ?lyrics=true.
+	Lyrics []lyricLine       `json:"lyrics,omitempty"`
+	Volume int               `json:"volume"`
+	NextUp []nowPlayingTrack `json:"nextUp"`
+}
+
+// describeTrack flattens a track and its album into what a dashboard shows.
+// Callers must hold albumsMu.
+func describeTrack(id string) nowPlayingTrack {
+	i := findTrack(id)
+	if i < 0 {
+		return nowPlayingTrack{ID: id}
+	}
+	t := tracks[i]
+	info := nowPlayingTrack{ID: t.ID, Title: t.Title, Artist: t.Artist, AlbumID: t.AlbumID, Duration: t.Duration}
+	if j := findAlbum(t.AlbumID); j >= 0 {
+		info.Album = albums[j].Title
+		info.CoverURL = albums[j].CoverURL
+	}
+	return info
+}
+
+// changeKey identifies what is playing apart from progress through the
+// track, which listeners extrapolate from elapsed.
+func (n nowPlaying) changeKey() string {
+	n.Elapsed, n.Remaining, n.Lyric, n.Lyrics = 0, nil, "", nil
+	key, _ := json.Marshal(n)
+	return string(key)
+}
+
+// getNowPlaying gathers everything a dashboard such as Home Assistant needs
+// to render the player in one response. With ?lyrics=true it includes the
+// track's synced lyrics, so a sing-along screen can scroll through them.
+func getNowPlaying(c *gin.Context) {
+	response := currentNowPlaying()
+	if lyrics, _ := strconv.ParseBool(c.Query("lyrics")); lyrics && response.Track != nil {
+		albumsMu.RLock()
+		response.Lyrics = syncedLyrics(response.Track.ID)
+		albumsMu.RUnlock()
+	}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// syncedLyrics returns the lyrics of a track when they are synced. Callers
+// must hold albumsMu.
+func syncedLyrics(id string) []lyricLine {
+	i := findTrack(id)
+	if i < 0 || len(tracks[i].Lyrics) == 0 || tracks[i].Lyrics[0].Time == nil {
+		return nil
+	}
+	return tracks[i].Lyrics
+}
+
+func currentNowPlaying() nowPlaying {
+	musicPlayer.mu.Lock()
+	status := musicPlayer.status()
+	upcoming := musicPlayer.upcoming(nextUpCount)
+	musicPlayer.mu.Unlock()
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	response := nowPlaying{State: status.State, Elapsed: status.Elapsed, Volume: status.Volume, NextUp: []nowPlayingTrack{}}
+	if status.TrackID != "" {
+		info := describeTrack(status.TrackID)
+		response.Track = &info
+		if i := findTrack(status.TrackID); i >= 0 {
+			end := tracks[i].Duration
+			if a := tracks[i].Analysis; a != nil && a.SkipOut > 0 {
+				end = a.SkipOut
+			}
+			if end > 0 {
+				remaining := max(end-status.Elapsed, 0)
+				response.Remaining = &remaining
+			}
+			response.Lyric = currentLyric(tracks[i].Lyrics, status.Elapsed)
+		}
+	}
+	for _, id := range upcoming {
+		response.NextUp = append(response.NextUp, describeTrack(id))
+	}
+	return response
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usePlayer swaps in a fresh player driven by a fake clock
+func usePlayer(t *testing.T) *time.Time {
+	saved := musicPlayer
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	musicPlayer = newPlayer()
+	musicPlayer.now = func() time.Time { return now }
+	t.Cleanup(func() { musicPlayer = saved })
+	return &now
+}
+
+// The player moves on to the next track once the current one has ended
+func TestPlayerAdvances(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	tracks = []track{
+		{ID: "1", Title: "Intro", Duration: 60, Analysis: &trackAnalysis{SkipIn: 2, SkipOut: 50}},
+		{ID: "2", Title: "Second", Duration: 100},
+	}
+	musicPlayer.Enqueue("1", "2")
+
+	// Check playback starts at the skip-in marker
+	status, err := musicPlayer.Play()
+	if err != nil || status.TrackID != "1" || status.Elapsed != 2 {
+		t.Errorf("Expected track 1 at 2s, but got %+v (%v)", status, err)
+	}
+
+	// Check the skip-out marker ends the track and the overflow carries over
+	*now = now.Add(50 * time.Second)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.TrackID != "2" || status.Elapsed != 2 {
+		t.Errorf("Expected track 2 at 2s, but got %+v", status)
+	}
+
+	// Check pausing freezes the position
+	musicPlayer.Pause()
+	*now = now.Add(time.Hour)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.State != statePaused || status.Elapsed != 2 {
+		t.Errorf("Expected paused at 2s, but got %+v", status)
+	}
+
+	// Check the player stops at the end of the queue
+	musicPlayer.Play()
+	*now = now.Add(200 * time.Second)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.State != stateStopped || status.TrackID != "" {
+		t.Errorf("Expected the player to stop, but got %+v", status)
+	}
+}
+
+func TestGetNowPlaying(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane", CoverURL: "https://example.com/blue-train.jpg"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Blue Train", Artist: "John Coltrane", Duration: 643},
+		{ID: "2", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane", Duration: 551},
+	}
+
+	// Check nothing is playing on an empty queue
+	rr := performRequest(router, "POST", "/player/play", nil, "bob")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Check unknown tracks cannot be queued
+	rr = performRequest(router, "POST", "/player/queue", strings.NewReader(`{"trackIds": ["1", "9"]}`), "bob")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	performRequest(router, "POST", "/player/queue", strings.NewReader(`{"trackIds": ["1", "2"]}`), "bob")
+	performRequest(router, "PUT", "/tracks/1/lyrics", strings.NewReader(`{"lrc": "[ar:John Coltrane]\n[00:05.00]First line\n[00:30.50]Second line"}`), "alice")
+	performRequest(router, "POST", "/player/play", nil, "bob")
+	*now = now.Add(40 * time.Second)
+
+	// Check the response combines track, album, timing, lyrics and next-up
+	rr = performRequest(router, "GET", "/player/now-playing", nil, "bob")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var response nowPlaying
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.State != statePlaying || response.Track == nil || response.Track.Album != "Blue Train" || response.Track.CoverURL == "" {
+		t.Errorf("Expected Blue Train playing with artwork, but got %+v", response)
+	}
+	if response.Elapsed != 40 || response.Remaining == nil || *response.Remaining != 603 {
+		t.Errorf("Expected 40s elapsed and 603s remaining, but got %+v", response)
+	}
+	if response.Lyric != "Second line" {
+		t.Errorf("Expected lyric %q, but got %q", "Second line", response.Lyric)
+	}
+	if len(response.NextUp) != 1 || response.NextUp[0].Title != "Moment's Notice" {
+		t.Errorf("Expected Moment's Notice next, but got %+v", response.NextUp)
+	}
+	if response.Lyrics != nil {
+		t.Errorf("Expected no lyrics unless asked for, but got %+v", response.Lyrics)
+	}
+
+	// Check the synced lyrics are included for singing along
+	rr = performRequest(router, "GET", "/player/now-playing?lyrics=true", nil, "bob")
+	response = nowPlaying{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Lyrics) != 2 || response.Lyrics[1].Text != "Second line" || *response.Lyrics[1].Time != 30.5 {
+		t.Errorf("Expected 2 synced lines, but got %+v", response.Lyrics)
+	}
+}
+
+// Plain-text lyrics are kept but never reported as the current line
+func TestParseLRC(t *testing.T) {
+	lines := parseLRC("[00:10]b\n[00:01][00:20]a\n")
+	if len(lines) != 3 || lines[0].Text != "a" || lines[1].Text != "b" || *lines[2].Time != 20 {
+		t.Errorf("Expected lines a, b, a in time order, but got %+v", lines)
+	}
+	plain := parseLRC("first\nsecond")
+	if len(plain) != 2 || currentLyric(plain, 100) != "" {
+		t.Errorf("Expected two unsynced lines, but got %+v", plain)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentObject is the one stored copy of some file content, under
+// <library>/.objects/<sha256[:2]>/<sha256>. Uploaded and downloaded files
+// are hard links to their object, so identical files kept for several
+// users or tracks take up room once. Refs counts the quarantined files and
+// tracks, trashed ones included, that link to it; the object is deleted
+// when the last of them is.
+type contentObject struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Refs int    `json:"refs"`
+}
+
+// objects maps content checksums to their stored copies. It is guarded by
+// albumsMu.
+var objects = map[string]contentObject{}
+
+// objectPath returns where the object for the checksum is stored under
+// root. Objects have no extension, so library scans pass them by.
+func objectPath(root, sum string) string {
+	return filepath.Join(root, ".objects", sum[:2], sum)
+}
+
+// storeObject makes the file at path, whose content has the checksum sum,
+// share its storage with the object for that content, storing the object
+// first when it is new, and takes a reference to it.
+func storeObject(root, path, sum string, size int64) error {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	obj, ok := objects[sum]
+	if !ok {
+		obj = contentObject{Path: objectPath(root, sum), Size: size}
+	}
+	if _, err := os.Stat(obj.Path); err == nil {
+		// Link to the object beside the file and rename the link over it,
+		// so the file is never missing.
+		tmp := path + ".link"
+		if err := os.Link(obj.Path, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(obj.Path), 0o755); err != nil {
+			return err
+		}
+		if err := os.Link(path, obj.Path); err != nil {
+			return err
+		}
+	}
+	obj.Refs++
+	objects[sum] = obj
+	return nil
+}
+
+// releaseObject drops a reference to the object for the checksum, once the
+// file linking to it has been removed, and deletes the object when nothing
+// links to it any more. Callers must hold albumsMu.
+func releaseObject(sum string) {
+	obj, ok := objects[sum]
+	if !ok {
+		return
+	}
+	if obj.Refs--; obj.Refs > 0 {
+		objects[sum] = obj
+		return
+	}
+	delete(objects, sum)
+	if err := os.Remove(obj.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("objects: %v", err)
+	}
+	// Only succeeds once the directory is empty.
+	os.Remove(filepath.Dir(obj.Path))
+}
+
+// storageStats sums up the stored objects. Saved is the room that would be
+// taken by separate copies of shared content.
+type storageStats struct {
+	Objects    int   `json:"objects"`
+	References int   `json:"references"`
+	Stored     int64 `json:"stored"`
+	Saved      int64 `json:"saved"`
+}
+
+// getStorageStats reports how much room content-addressed storage takes up
+// and saves.
+func getStorageStats(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	var stats storageStats
+	for _, obj := range objects {
+		stats.Objects++
+		stats.References += obj.Refs
+		stats.Stored += obj.Size
+		stats.Saved += obj.Size * int64(obj.Refs-1)
+	}
+	c.IndentedJSON(http.StatusOK, stats)
+}
+
+func dumpObjects() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(objects, "", "    ")
+}
+
+func restoreObjects(data []byte) (func(), int, error) {
+	var restored map[string]contentObject
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]contentObject{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		objects = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentObjects(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	cfg.TrashDir = filepath.Join(root, ".trash")
+	router := setupRouter(cfg)
+	upload := func(owner, album string) (quarantinedFile, error) {
+		path := filepath.Join(root, owner+".flac")
+		os.WriteFile(path, []byte("fLaC shared"), 0o644)
+		return addUpload(context.Background(), root, path, "Nefertiti.flac", owner, ingestTags{Artist: "Miles Davis", Album: album})
+	}
+	sameFile := func(a, b string) bool {
+		ai, err := os.Stat(a)
+		if err != nil {
+			return false
+		}
+		bi, err := os.Stat(b)
+		return err == nil && os.SameFile(ai, bi)
+	}
+
+	// Check the same content from two users is stored once
+	mine, err := upload("bob", "Nefertiti")
+	if err != nil {
+		t.Fatalf("Expected the upload to be quarantined, but got %v", err)
+	}
+	theirs, err := upload("carol", "The Best of Miles Davis")
+	if err != nil {
+		t.Fatalf("Expected the upload to be quarantined, but got %v", err)
+	}
+	obj := objectPath(root, mine.SHA256)
+	if !sameFile(mine.Path, obj) || !sameFile(theirs.Path, obj) || objects[mine.SHA256].Refs != 2 {
+		t.Errorf("Expected both files to share %s, but got %+v", obj, objects)
+	}
+
+	// Check the same user uploading it again is a duplicate
+	if _, err := upload("bob", "Nefertiti"); err == nil || !strings.Contains(err.Error(), mine.ID) {
+		t.Errorf("Expected a duplicate of %s, but got %v", mine.ID, err)
+	}
+
+	// Check rejecting one file keeps the content for the other
+	rr := performRequest(router, "POST", "/quarantine/"+mine.ID+"/reject", nil, "alice")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	if _, err := os.Stat(obj); err != nil || objects[mine.SHA256].Refs != 1 {
+		t.Errorf("Expected the content to be kept, but got %v and %+v", err, objects)
+	}
+
+	// Check the approved track links to the object and records its checksum
+	added := approveUpload(t, router, theirs.ID)
+	if !sameFile(added.Path, obj) || added.SHA256 != mine.SHA256 {
+		t.Errorf("Expected the track to share %s, but got %+v", obj, added)
+	}
+	rr = performRequest(router, "GET", "/library/storage", nil, "alice")
+	var stats storageStats
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if stats.Objects != 1 || stats.References != 1 || stats.Stored != 11 || stats.Saved != 0 {
+		t.Errorf("Expected one object of 11 bytes, but got %+v", stats)
+	}
+
+	// Check the object outlives the trashed track until it is purged
+	rr = performRequest(router, "DELETE", "/tracks/"+added.ID, nil, "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if _, err := os.Stat(obj); err != nil {
+		t.Errorf("Expected the content to be kept in the trash, but got %v", err)
+	}
+	performRequest(router, "DELETE", "/library/trash/"+added.ID, nil, "alice")
+	if _, err := os.Stat(obj); !os.IsNotExist(err) || len(objects) != 0 {
+		t.Errorf("Expected the content to be deleted, but got %v and %+v", err, objects)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// operationTTL is how long an edit can be undone.
+	operationTTL = time.Hour
+	// maxOperations bounds the log; older operations are forgotten sooner.
+	maxOperations = 200
+)
+
+// albumVersions is one album before and after an operation; nil means the
+// album did not exist.
+type albumVersions struct {
+	Before *album `json:"before"`
+	After  *album `json:"after"`
+}
+
+type trackVersions struct {
+	Before *track `json:"before"`
+	After  *track `json:"after"`
+}
+
+// operation is a logged catalog edit or deletion. Undone operations can be
+// redone until they expire.
+type operation struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	User   string          `json:"user,omitempty"`
+	At     time.Time       `json:"at"`
+	Albums []albumVersions `json:"albums,omitempty"`
+	Tracks []trackVersions `json:"tracks,omitempty"`
+	Undone bool            `json:"undone"`
+}
+
+// operations is the log, oldest first. opsMu is taken after albumsMu.
+var (
+	opsMu      sync.Mutex
+	operations []*operation
+)
+
+// logOperation records an edit made by the request and names it in the
+// X-Operation-Id header, so the client can offer to undo it. Callers must
+// hold albumsMu.
+func logOperation(c *gin.Context, kind string, albumEdits []albumVersions, trackEdits []trackVersions) {
+	if len(albumEdits)+len(trackEdits) == 0 {
+		return
+	}
+	op := &operation{ID: newID(), Kind: kind, User: c.GetString(gin.AuthUserKey), At: time.Now().UTC(), Albums: albumEdits, Tracks: trackEdits}
+
+	opsMu.Lock()
+	defer opsMu.Unlock()
+
+	operations = append(operations, op)
+	pruneOperations(time.Now())
+	recordOperation(op)
+	outbox.add(op.Kind, op)
+	c.Header("X-Operation-Id", op.ID)
+}
+
+// pruneOperations forgets expired operations and those past the limit.
+// Callers must hold opsMu.
+func pruneOperations(now time.Time) {
+	start := max(len(operations)-maxOperations, 0)
+	for start < len(operations) && now.Sub(operations[start].At) > operationTTL {
+		start++
+	}
+	operations = append([]*operation(nil), operations[start:]...)
+}
+
+// findOperation returns the logged operation with the given id, or nil.
+// Callers must hold opsMu.
+func findOperation(id string) *operation {
+	for _, op := range operations {
+		if op.ID == id {
+			return op
+		}
+	}
+	return nil
+}
+
+// currentAlbum and currentTrack return the catalog's version, or nil when
+// there is none. Callers must hold albumsMu.
+func currentAlbum(id string) *album {
+	if i := findAlbum(id); i >= 0 {
+		a := albums[i]
+		return &a
+	}
+	return nil
+}
+
+func currentTrack(id string) *track {
+	if i := findTrack(id); i >= 0 {
+		t := tracks[i]
+		return &t
+	}
+	return nil
+}
+
+func (v albumVersions) id() string {
+	if v.Before != nil {
+		return v.Before.ID
+	}
+	return v.After.ID
+}
+
+func (v trackVersions) id() string {
+	if v.Before != nil {
+		return v.Before.ID
+	}
+	return v.After.ID
+}
+
+// sides returns the version the catalog has and the one it gets when the
+// operation is undone, or with undo false, reapplied.
+func (v albumVersions) sides(undo bool) (from, to *album) {
+	if undo {
+		return v.After, v.Before
+	}
+	return v.Before, v.After
+}
+
+func (v trackVersions) sides(undo bool) (from, to *track) {
+	if undo {
+		return v.After, v.Before
+	}
+	return v.Before, v.After
+}
+
+// conflict returns the kind and id of the first album or track that is not
+// as the operation left it (undo) or found it (redo), or "" when it can be
+// reverted or reapplied safely. Callers must hold albumsMu.
+func (op *operation) conflict(undo bool) string {
+	for _, v := range op.Albums {
+		if want, _ := v.sides(undo); !reflect.DeepEqual(currentAlbum(v.id()), want) {
+			return "album " + v.id()
+		}
+	}
+	for _, v := range op.Tracks {
+		if want, _ := v.sides(undo); !reflect.DeepEqual(currentTrack(v.id()), want) {
+			return "track " + v.id()
+		}
+	}
+	return ""
+}
+
+// revert moves the catalog from one side of the operation to the other,
+// putting deleted tracks back from the trash and trashing them again on
+// redo. Files are moved first; when one cannot be, those already moved go
+// back and the catalog is left as it was. It returns the albums that exist
+// afterwards and the ids of those it removed. Callers must hold albumsMu.
+func (op *operation) revert(undo bool, dir, user string) (kept []album, removed []string, err error) {
+	deleted := map[string]*album{}
+	for _, v := range op.Albums {
+		if from, to := v.sides(undo); to == nil {
+			deleted[from.ID] = from
+		}
+	}
+
+	var moved [][2]string
+	move := func(from, to string) error {
+		if err := moveToTrash(from, to); err != nil {
+			for i := len(moved) - 1; i >= 0; i-- {
+				if err := moveToTrash(moved[i][1], moved[i][0]); err != nil {
+					log.Printf("oplog: moving %s back to %s: %v", moved[i][1], moved[i][0], err)
+				}
+			}
+			return err
+		}
+		moved = append(moved, [2]string{from, to})
+		return nil
+	}
+	entries := map[string]trashedTrack{}
+	for _, v := range op.Tracks {
+		from, to := v.sides(undo)
+		switch {
+		case to == nil:
+			entry := newTrashEntry(*from, deleted[from.AlbumID], dir, user)
+			if entry.TrashPath != "" {
+				if err := move(from.Path, entry.TrashPath); err != nil {
+					return nil, nil, err
+				}
+			}
+			entries[from.ID] = entry
+		case from == nil:
+			if j := findTrashed(to.ID); j >= 0 && trash[j].TrashPath != "" {
+				if err := move(trash[j].TrashPath, trash[j].Track.Path); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	for _, v := range op.Albums {
+		from, to := v.sides(undo)
+		i := findAlbum(v.id())
+		switch {
+		case to == nil:
+			albums = append(albums[:i], albums[i+1:]...)
+			libStats.removeAlbum(*from)
+			removed = append(removed, from.ID)
+		case from == nil:
+			albums = append(albums, *to)
+			libStats.addAlbum(*to)
+			kept = append(kept, *to)
+		default:
+			// The slug goes back too, and the one it replaces redirects.
+			if albums[i].Slug != to.Slug {
+				staleSlugs[albums[i].Slug] = to.ID
+			}
+			delete(staleSlugs, to.Slug)
+			libStats.replaceAlbum(albums[i], *to)
+			albums[i] = *to
+			kept = append(kept, *to)
+		}
+	}
+	for _, v := range op.Tracks {
+		from, to := v.sides(undo)
+		switch {
+		case to == nil:
+			addToTrash(entries[from.ID])
+		case from == nil:
+			if j := findTrashed(to.ID); j >= 0 {
+				trash = append(trash[:j], trash[j+1:]...)
+			}
+			tracks = append(tracks, *to)
+			libStats.addTrack(*to)
+		default:
+			i := findTrack(v.id())
+			libStats.replaceTrack(tracks[i], *to)
+			tracks[i] = *to
+		}
+	}
+	return kept, removed, nil
+}
+
+// getOperations lists the operations that can still be undone or redone,
+// most recent first.
+func getOperations(c *gin.Context) {
+	opsMu.Lock()
+	defer opsMu.Unlock()
+
+	pruneOperations(time.Now())
+	newest := make([]operation, len(operations))
+	for i, op := range operations {
+		newest[len(operations)-1-i] = *op
+	}
+	respondList(c, newest)
+}
+
+// undoOperation returns the handler that reverts an operation, or with
+// redo reapplies an undone one. Either is refused when the albums or tracks
+// involved were changed again since, rather than overwriting those
+// changes. Trashed files are moved to and from dir.
+func undoOperation(dir string, redo bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		albumsMu.Lock()
+		opsMu.Lock()
+		pruneOperations(time.Now())
+		op := findOperation(c.Param("id"))
+		if op == nil {
+			opsMu.Unlock()
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "unknown or expired operation"})
+			return
+		}
+		if op.Undone != redo {
+			opsMu.Unlock()
+			albumsMu.Unlock()
+			message := "operation was already undone"
+			if redo {
+				message = "operation was not undone"
+			}
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": message})
+			return
+		}
+		if what := op.conflict(!redo); what != "" {
+			opsMu.Unlock()
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": what + " was changed after the operation"})
+			return
+		}
+		kept, removed, err := op.revert(!redo, dir, c.GetString(gin.AuthUserKey))
+		if err == nil {
+			op.Undone = !redo
+		}
+		result := *op
+		opsMu.Unlock()
+		albumsMu.Unlock()
+
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		publishAlbumDeletes(c.Request.Context(), removed)
+		publishAlbumEdits(c, kept)
+		c.IndentedJSON(http.StatusOK, result)
+	}
+}
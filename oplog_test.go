@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	resetCatalog(t)
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.TrashDir = filepath.Join(root, ".trash")
+	router := setupRouter(cfg)
+	path := filepath.Join(root, "song.flac")
+	os.WriteFile(path, []byte("fLaC"), 0o644)
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Song", Path: path}}
+
+	// Check an edit names its operation and undo reverts it
+	rr := performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"title": "Red Train"}`), "alice")
+	id := rr.Header().Get("X-Operation-Id")
+	if id == "" {
+		t.Fatalf("Expected an operation id header")
+	}
+	rr = performRequest(router, "POST", "/admin/undo/"+id, nil, "alice")
+	if rr.Code != http.StatusOK || albums[findAlbum("1")].Title != "Blue Train" {
+		t.Errorf("Expected the title reverted, but got %d %s", rr.Code, albums[findAlbum("1")].Title)
+	}
+	if rr := performRequest(router, "POST", "/admin/undo/"+id, nil, "alice"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Check redo reapplies it, and neither overwrites later changes
+	rr = performRequest(router, "POST", "/admin/redo/"+id, nil, "alice")
+	if rr.Code != http.StatusOK || albums[findAlbum("1")].Title != "Red Train" {
+		t.Errorf("Expected the title reapplied, but got %d", rr.Code)
+	}
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"title": "Green Train"}`), "alice")
+	if rr := performRequest(router, "POST", "/admin/undo/"+id, nil, "alice"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Check undoing a deletion takes the track and its file out of the trash
+	rr = performRequest(router, "DELETE", "/tracks/1", nil, "alice")
+	id = rr.Header().Get("X-Operation-Id")
+	rr = performRequest(router, "POST", "/admin/undo/"+id, nil, "alice")
+	if rr.Code != http.StatusOK || findTrack("1") < 0 || len(trash) != 0 {
+		t.Fatalf("Expected the track restored, but got %d %s", rr.Code, rr.Body)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file back in place, but got %v", err)
+	}
+	rr = performRequest(router, "POST", "/admin/redo/"+id, nil, "alice")
+	if rr.Code != http.StatusOK || findTrack("1") >= 0 || len(trash) != 1 {
+		t.Errorf("Expected the track deleted again, but got %d", rr.Code)
+	}
+
+	// Check the log lists operations newest first, and only to admins
+	rr = performRequest(router, "GET", "/admin/operations", nil, "alice")
+	var ops []operation
+	json.Unmarshal(rr.Body.Bytes(), &ops)
+	if len(ops) != 3 || ops[0].Kind != "track.delete" || ops[2].Kind != "album.update" || ops[2].Undone {
+		t.Errorf("Expected 3 operations, but got %+v", ops)
+	}
+	if rr := performRequest(router, "POST", "/admin/undo/"+id, nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := performRequest(router, "POST", "/admin/undo/nope", nil, "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestUndoBulkDelete(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", AlbumID: "1"}, {ID: "2", AlbumID: "2"}}
+
+	rr := performRequest(router, "POST", "/admin/albums/bulk-delete", strings.NewReader(`{"filter": {"q": "price > 20"}}`), "alice")
+	var preview bulkDeletePreview
+	json.Unmarshal(rr.Body.Bytes(), &preview)
+	rr = performRequest(router, "POST", "/admin/albums/bulk-delete", strings.NewReader(`{"filter": {"q": "price > 20"}, "confirmToken": "`+preview.ConfirmToken+`"}`), "alice")
+	if len(albums) != 1 || len(tracks) != 1 {
+		t.Fatalf("Expected 2 albums deleted, but got %d left", len(albums))
+	}
+
+	// Check undo brings back the albums and their tracks
+	opID := rr.Header().Get("X-Operation-Id")
+	rr = performRequest(router, "POST", "/admin/undo/"+opID, nil, "alice")
+	if rr.Code != http.StatusOK || len(albums) != 3 || len(tracks) != 2 || len(trash) != 0 {
+		t.Errorf("Expected everything restored, but got %d %d albums %d tracks", rr.Code, len(albums), len(tracks))
+	}
+
+	// Check redo deletes them again and tells other instances
+	published := recordAlbumDeletes(t)
+	rr = performRequest(router, "POST", "/admin/redo/"+opID, nil, "alice")
+	if rr.Code != http.StatusOK || len(albums) != 1 {
+		t.Errorf("Expected the albums deleted again, but got %d with %d albums", rr.Code, len(albums))
+	}
+	if !slices.Equal(*published, []string{"1", "3"}) {
+		t.Errorf("Expected the deletions of albums 1 and 3 published, but got %v", *published)
+	}
+}
+
+// An undo that cannot move every file back changes nothing
+func TestUndoFailsWhole(t *testing.T) {
+	resetCatalog(t)
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.TrashDir = filepath.Join(root, ".trash")
+	router := setupRouter(cfg)
+	tracks = nil
+	for _, id := range []string{"1", "2"} {
+		path := filepath.Join(root, id+".flac")
+		os.WriteFile(path, []byte("fLaC "+id), 0o644)
+		tracks = append(tracks, track{ID: id, AlbumID: "1", Path: path})
+	}
+	rr := performRequest(router, "POST", "/admin/albums/bulk-delete", strings.NewReader(`{"filter": {"artist": "John Coltrane"}}`), "alice")
+	var preview bulkDeletePreview
+	json.Unmarshal(rr.Body.Bytes(), &preview)
+	rr = performRequest(router, "POST", "/admin/albums/bulk-delete", strings.NewReader(`{"filter": {"artist": "John Coltrane"}, "confirmToken": "`+preview.ConfirmToken+`"}`), "alice")
+	opID := rr.Header().Get("X-Operation-Id")
+
+	// Check a file that cannot go back leaves the album, tracks and files in
+	// the trash
+	blocked := filepath.Join(root, "2.flac")
+	os.Mkdir(blocked, 0o755)
+	os.WriteFile(filepath.Join(blocked, "keep"), nil, 0o644)
+	rr = performRequest(router, "POST", "/admin/undo/"+opID, nil, "alice")
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, but got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if findAlbum("1") >= 0 || len(tracks) != 0 || len(trash) != 2 {
+		t.Errorf("Expected nothing restored, but got album %d, %d tracks, %d trashed", findAlbum("1"), len(tracks), len(trash))
+	}
+	for _, entry := range trash {
+		if _, err := os.Stat(entry.TrashPath); err != nil {
+			t.Errorf("Expected the file of track %s in the trash, but got %v", entry.Track.ID, err)
+		}
+	}
+
+	// Check the undo goes through once the file can be put back
+	os.RemoveAll(blocked)
+	rr = performRequest(router, "POST", "/admin/undo/"+opID, nil, "alice")
+	if rr.Code != http.StatusOK || findAlbum("1") < 0 || len(tracks) != 2 || len(trash) != 0 {
+		t.Errorf("Expected everything restored, but got %d: %s", rr.Code, rr.Body)
+	}
+}
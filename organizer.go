@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pathPlaceholder matches the {name} placeholders of organizer patterns.
+var pathPlaceholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// unsafePathChars are replaced in tag values used as path components.
+var unsafePathChars = strings.NewReplacer("/", "_", `\`, "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_")
+
+var errNoLibraryDir = errors.New("no library directory is configured")
+
+// pathValues returns the values a track fills into a pattern. Callers must
+// hold albumsMu.
+func pathValues(t track) map[string]string {
+	values := map[string]string{
+		"artist": t.Artist,
+		"title":  t.Title,
+		"track":  fmt.Sprintf("%02d", t.Number),
+		"disc":   strconv.Itoa(max(t.Disc, 1)),
+	}
+	if i := findAlbum(t.AlbumID); i >= 0 {
+		a := albums[i]
+		values["album"], values["albumartist"], values["genre"] = a.Title, a.Artist, a.Genre
+		if a.Year > 0 {
+			values["year"] = strconv.Itoa(a.Year)
+		}
+	}
+	return values
+}
+
+// validatePattern checks that a pattern only uses known placeholders.
+func validatePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New("pattern must not be empty")
+	}
+	for _, m := range pathPlaceholder.FindAllStringSubmatch(pattern, -1) {
+		switch m[1] {
+		case "artist", "albumartist", "album", "title", "track", "disc", "year", "genre":
+		default:
+			return fmt.Errorf("unknown placeholder {%s}", m[1])
+		}
+	}
+	return nil
+}
+
+// expandPattern builds the path of a track relative to the library root.
+// The file extension is kept from the current path.
+func expandPattern(pattern string, values map[string]string, current string) string {
+	expanded := pathPlaceholder.ReplaceAllStringFunc(pattern, func(p string) string {
+		v := strings.Trim(unsafePathChars.Replace(values[p[1:len(p)-1]]), ". ")
+		if v == "" {
+			return "Unknown"
+		}
+		return v
+	})
+	return filepath.Clean(filepath.FromSlash(expanded)) + strings.ToLower(filepath.Ext(current))
+}
+
+type fileMove struct {
+	TrackID string `json:"trackId"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Error   string `json:"error,omitempty"`
+}
+
+// organizeLibrary returns the handler that moves track files under root
+// according to a pattern such as "{albumartist}/{album}/{track} {title}".
+// With dryRun it only reports the planned moves.
+func organizeLibrary(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Pattern  string   `json:"pattern"`
+			TrackIDs []string `json:"trackIds"`
+			DryRun   bool     `json:"dryRun"`
+		}
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		if err := validatePattern(input.Pattern); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		albumsMu.RLock()
+		moves := planMoves(root, input.Pattern, input.TrackIDs)
+		albumsMu.RUnlock()
+
+		if !input.DryRun {
+			for i := range moves {
+				if moves[i].Error == "" {
+					moves[i].Error = moveFile(moves[i].From, moves[i].To)
+				}
+			}
+			albumsMu.Lock()
+			for _, m := range moves {
+				if i := findTrack(m.TrackID); m.Error == "" && i >= 0 && tracks[i].Path == m.From {
+					tracks[i].Path = m.To
+				}
+			}
+			albumsMu.Unlock()
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"moves": moves, "dryRun": input.DryRun})
+	}
+}
+
+// planMoves works out where each selected track's file belongs, skipping
+// files already in place. Conflicting targets are reported as errors.
+// Callers must hold albumsMu.
+func planMoves(root, pattern string, ids []string) []fileMove {
+	selected := map[string]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+	moves := []fileMove{}
+	targets := map[string]string{}
+	for _, t := range tracks {
+		if t.Path == "" || len(ids) > 0 && !selected[t.ID] {
+			continue
+		}
+		to := filepath.Join(root, expandPattern(pattern, pathValues(t), t.Path))
+		if to == filepath.Clean(t.Path) {
+			continue
+		}
+		m := fileMove{TrackID: t.ID, From: t.Path, To: to}
+		if other, ok := targets[to]; ok {
+			m.Error = "same target as track " + other
+		} else if _, err := os.Stat(to); err == nil {
+			m.Error = "target already exists"
+		}
+		targets[to] = t.ID
+		moves = append(moves, m)
+	}
+	return moves
+}
+
+// moveFile renames from to to, creating directories as needed, and
+// returns an error message or "".
+func moveFile(from, to string) string {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err.Error()
+	}
+	if _, err := os.Stat(to); err == nil {
+		return "target already exists"
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// patternRegexp turns a pattern into a regular expression matching paths
+// relative to the library root, without the extension.
+func patternRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range pathPlaceholder.FindAllStringSubmatchIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		name := pattern[loc[2]:loc[3]]
+		switch name {
+		case "track", "disc", "year":
+			expr.WriteString(`(?P<` + name + `>\d+)`)
+		default:
+			expr.WriteString(`(?P<` + name + `>[^/]+?)`)
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]) + "$")
+	return regexp.MustCompile(expr.String())
+}
+
+// inferTags returns the handler that fills track tags from file paths under
+// root, read with a pattern. Only artist, title, track and disc are taken
+// from the path; other placeholders just have to match. Tags that are
+// already set are kept unless overwrite is true. The result is a batch
+// edit, so it can be previewed and undone like one.
+func inferTags(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Pattern   string   `json:"pattern"`
+			TrackIDs  []string `json:"trackIds"`
+			Overwrite bool     `json:"overwrite"`
+			DryRun    bool     `json:"dryRun"`
+		}
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		if err := validatePattern(input.Pattern); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		re := patternRegexp(filepath.ToSlash(input.Pattern))
+		selection := batchEditRequest{TrackIDs: input.TrackIDs}
+
+		albumsMu.Lock()
+		result := batchEditResult{Tracks: []trackEdit{}, Albums: []albumEdit{}, DryRun: input.DryRun}
+		var versions []track
+		for _, t := range tracks {
+			rel, err := filepath.Rel(root, t.Path)
+			if t.Path == "" || err != nil || strings.HasPrefix(rel, "..") || !selection.selectsTrack(t) {
+				continue
+			}
+			m := re.FindStringSubmatch(filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))))
+			if m == nil {
+				continue
+			}
+			after := t
+			for i, name := range re.SubexpNames() {
+				fillTag(&after, name, m[i], input.Overwrite)
+			}
+			if !reflect.DeepEqual(after, t) {
+				result.Tracks = append(result.Tracks, trackEdit{Before: t, After: after})
+				versions = append(versions, after)
+			}
+		}
+		if !input.DryRun && len(versions) > 0 {
+			applyEdits(versions, nil)
+		}
+		albumsMu.Unlock()
+
+		if !input.DryRun && len(versions) > 0 {
+			result.UndoToken = rememberBatch(batchUndo{Tracks: result.Tracks, AppliedAt: time.Now().UTC()})
+		}
+		c.IndentedJSON(http.StatusOK, result)
+	}
+}
+
+// fillTag sets one tag read from a path.
+func fillTag(t *track, name, value string, overwrite bool) {
+	switch name {
+	case "artist":
+		if overwrite || t.Artist == "" {
+			t.Artist = value
+		}
+	case "title":
+		if overwrite || t.Title == "" {
+			t.Title = value
+		}
+	case "track":
+		if n, err := strconv.Atoi(value); err == nil && (overwrite || t.Number == 0) {
+			t.Number = n
+		}
+	case "disc":
+		if n, err := strconv.Atoi(value); err == nil && (overwrite || t.Disc == 0) {
+			t.Disc = n
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOrganizeLibrary(t *testing.T) {
+	resetCatalog(t)
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	from := filepath.Join(root, "incoming", "01.FLAC")
+	os.MkdirAll(filepath.Dir(from), 0o755)
+	os.WriteFile(from, []byte("fLaC"), 0o644)
+	albums = []album{{ID: "1", Title: "Pastel Blues", Artist: "Nina Simone"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Be My Husband/Live", Artist: "Nina Simone", Number: 1, Path: from}}
+	body := `{"pattern": "{albumartist}/{album}/{track} {title}"%s}`
+	want := filepath.Join(root, "Nina Simone", "Pastel Blues", "01 Be My Husband_Live.flac")
+
+	// Check unknown placeholders are rejected
+	rr := performRequest(router, "POST", "/library/organize", strings.NewReader(`{"pattern": "{composer}/{title}"}`), "alice")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check a dry run plans the move without touching the file
+	rr = performRequest(router, "POST", "/library/organize", strings.NewReader(strings.Replace(body, "%s", `, "dryRun": true`, 1)), "alice")
+	var result struct {
+		Moves []fileMove `json:"moves"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if len(result.Moves) != 1 || result.Moves[0].To != want || tracks[0].Path != from {
+		t.Errorf("Expected a planned move to %s, but got %+v", want, result.Moves)
+	}
+
+	// Check the file is moved and the track follows it
+	performRequest(router, "POST", "/library/organize", strings.NewReader(strings.Replace(body, "%s", "", 1)), "alice")
+	if _, err := os.Stat(want); err != nil || tracks[0].Path != want {
+		t.Errorf("Expected the file at %s, but got %v and path %s", want, err, tracks[0].Path)
+	}
+}
+
+func TestInferTags(t *testing.T) {
+	resetCatalog(t)
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	tracks = []track{
+		{ID: "1", Title: "Track01", Path: filepath.Join(root, "Miles Davis", "Kind of Blue", "02 - Freddie Freeloader.mp3")},
+		{ID: "2", Title: "Unmatched", Path: filepath.Join(root, "loose.mp3")},
+	}
+
+	// Check tags are read from matching paths, keeping tags already set
+	rr := performRequest(router, "POST", "/library/infer-tags", strings.NewReader(`{"pattern": "{artist}/{album}/{track} - {title}"}`), "alice")
+	var result batchEditResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if len(result.Tracks) != 1 || tracks[0].Artist != "Miles Davis" || tracks[0].Number != 2 || tracks[0].Title != "Track01" {
+		t.Errorf("Expected artist and number to be filled in, but got %+v", tracks[0])
+	}
+
+	// Check overwrite replaces existing tags and the batch can be undone
+	rr = performRequest(router, "POST", "/library/infer-tags", strings.NewReader(`{"pattern": "{artist}/{album}/{track} - {title}", "overwrite": true}`), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	if tracks[0].Title != "Freddie Freeloader" || result.UndoToken == "" {
+		t.Errorf("Expected the title to be overwritten, but got %+v", tracks[0])
+	}
+	performRequest(router, "POST", "/tracks/batch-edit/"+result.UndoToken+"/undo", nil, "alice")
+	if tracks[0].Title != "Track01" {
+		t.Errorf("Expected the title to be restored, but got %q", tracks[0].Title)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The outbox delivers catalog changes and plays to webhooks and the event
+// bus. A message is added in the same albumsMu critical section as the
+// change it announces, so no change goes out without its message. The
+// dispatcher writes it to the journal before any sink sees it, outside
+// albumsMu so that requests don't wait on the disk, and from then on it
+// survives a restart until every sink has taken it. Delivery is at least
+// once: a crash between a delivery and its acknowledgement in the journal
+// sends the message again, with the same id, which receivers use to drop
+// the copy. Ids are UUIDv7s, so they are unique across instances and
+// restarts.
+type outboxMessage struct {
+	ID        string          `json:"id"`
+	Topic     string          `json:"topic"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// Pending names the sinks that have yet to take the message.
+	Pending []string `json:"pending"`
+}
+
+// outboxSink is somewhere outbox messages are delivered to. Each sink gets
+// the messages in order; one that fails is retried before the next.
+type outboxSink interface {
+	Name() string
+	Deliver(ctx context.Context, m outboxMessage) error
+}
+
+// outboxRecord is a line of the journal: a message added, or taken by a
+// sink.
+type outboxRecord struct {
+	Add  *outboxMessage `json:"add,omitempty"`
+	Done string         `json:"done,omitempty"`
+	Sink string         `json:"sink,omitempty"`
+}
+
+type sinkStatus struct {
+	Name        string     `json:"name"`
+	Pending     int        `json:"pending"`
+	Delivered   int        `json:"delivered"`
+	Failures    int        `json:"failures"`
+	LastError   string     `json:"lastError,omitempty"`
+	RetryAt     *time.Time `json:"retryAt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+const (
+	maxOutboxBackoff = 5 * time.Minute
+	// outboxCompactEvery is how many acknowledgements the journal collects
+	// before it is rewritten with only the pending messages.
+	outboxCompactEvery = 1000
+)
+
+type outboxQueue struct {
+	mu       sync.Mutex
+	sinks    []outboxSink
+	messages []outboxMessage
+	// unwritten counts the messages at the end of messages that are not
+	// in the journal yet.
+	unwritten int
+	status    map[string]*sinkStatus
+	path      string
+	// journal is only written by open and dispatch, which never run at
+	// the same time, and without holding mu.
+	journal *os.File
+	acks    int
+	wake    chan struct{}
+}
+
+// outbox has no sinks until the server is configured with some, and drops
+// what it is given until then.
+var outbox = newOutbox(nil)
+
+func newOutbox(sinks []outboxSink) *outboxQueue {
+	o := &outboxQueue{sinks: sinks, status: map[string]*sinkStatus{}, wake: make(chan struct{}, 1)}
+	for _, s := range sinks {
+		o.status[s.Name()] = &sinkStatus{Name: s.Name()}
+	}
+	return o
+}
+
+// open loads the journal at path, dropping sinks that are no longer
+// configured from the pending messages, and keeps it for what comes next.
+// A missing journal is not an error.
+func (o *outboxQueue) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if f != nil {
+		o.mu.Lock()
+		err := o.replay(f)
+		o.mu.Unlock()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	o.path = path
+	return o.compact()
+}
+
+// replay applies the journal's records. A torn last line, from a crash
+// while it was written, is ignored. Callers must hold o.mu.
+func (o *outboxQueue) replay(f *os.File) error {
+	configured := map[string]bool{}
+	for _, s := range o.sinks {
+		configured[s.Name()] = true
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var r outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		switch {
+		case r.Add != nil:
+			m := *r.Add
+			var pending []string
+			for _, name := range m.Pending {
+				if configured[name] {
+					pending = append(pending, name)
+				}
+			}
+			m.Pending = pending
+			o.messages = append(o.messages, m)
+		case r.Done != "":
+			o.ack(r.Done, r.Sink)
+		}
+	}
+	o.prune()
+	return scanner.Err()
+}
+
+// compact rewrites the journal with only the pending messages and opens it
+// for appending.
+func (o *outboxQueue) compact() error {
+	o.mu.Lock()
+	var buf bytes.Buffer
+	for i := range o.messages {
+		line, err := json.Marshal(outboxRecord{Add: &o.messages[i]})
+		if err != nil {
+			o.mu.Unlock()
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	o.unwritten, o.acks = 0, 0
+	o.mu.Unlock()
+
+	if o.journal != nil {
+		o.journal.Close()
+	}
+	if err := writeFileAtomic(o.path, buf.Bytes()); err != nil {
+		return err
+	}
+	var err error
+	o.journal, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	return err
+}
+
+// write appends records to the journal and flushes them to disk.
+func (o *outboxQueue) write(records ...outboxRecord) error {
+	if o.journal == nil || len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if _, err := o.journal.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return o.journal.Sync()
+}
+
+// flush writes the messages added since the last flush to the journal. A
+// journal that cannot be written is logged; the messages are still
+// delivered unless the server stops first.
+func (o *outboxQueue) flush() {
+	o.mu.Lock()
+	n := o.unwritten
+	records := make([]outboxRecord, n)
+	for i := range records {
+		m := o.messages[len(o.messages)-n+i]
+		records[i] = outboxRecord{Add: &m}
+	}
+	o.mu.Unlock()
+
+	if err := o.write(records...); err != nil {
+		log.Printf("outbox: journal: %v", err)
+	}
+
+	o.mu.Lock()
+	o.unwritten -= n
+	o.mu.Unlock()
+}
+
+// add queues a message for every sink. Callers must hold albumsMu, so the
+// message goes out with the change that made it. The dispatcher, which it
+// wakes, writes the message to the journal.
+func (o *outboxQueue) add(topic string, payload any) {
+	if len(o.sinks) == 0 {
+		return
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("outbox: %s: %v", topic, err)
+		return
+	}
+
+	m := outboxMessage{ID: newID(), Topic: topic, Payload: raw, CreatedAt: time.Now().UTC()}
+	for _, s := range o.sinks {
+		m.Pending = append(m.Pending, s.Name())
+	}
+	o.mu.Lock()
+	o.messages = append(o.messages, m)
+	o.unwritten++
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ack records that sink took message id. Callers must hold o.mu.
+func (o *outboxQueue) ack(id string, sink string) {
+	for i := range o.messages {
+		if o.messages[i].ID != id {
+			continue
+		}
+		var pending []string
+		for _, name := range o.messages[i].Pending {
+			if name != sink {
+				pending = append(pending, name)
+			}
+		}
+		o.messages[i].Pending = pending
+		return
+	}
+}
+
+// prune forgets the messages every sink took. Callers must hold o.mu.
+func (o *outboxQueue) prune() {
+	kept := o.messages[:0]
+	for _, m := range o.messages {
+		if len(m.Pending) > 0 {
+			kept = append(kept, m)
+		}
+	}
+	o.messages = kept
+}
+
+// pendingFor returns the journaled messages sink has yet to take, oldest
+// first.
+func (o *outboxQueue) pendingFor(sink string) []outboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []outboxMessage
+	for _, m := range o.messages[:len(o.messages)-o.unwritten] {
+		for _, name := range m.Pending {
+			if name == sink {
+				due = append(due, m)
+				break
+			}
+		}
+	}
+	return due
+}
+
+// dispatch journals the messages added since it last ran, delivers what
+// each sink is due, and returns when the earliest failed sink should be
+// retried, or the zero time when none failed.
+func (o *outboxQueue) dispatch(ctx context.Context, now time.Time) time.Time {
+	o.flush()
+
+	var retry time.Time
+	for _, s := range o.sinks {
+		o.mu.Lock()
+		st := o.status[s.Name()]
+		waiting := st.RetryAt != nil && now.Before(*st.RetryAt)
+		if waiting && (retry.IsZero() || st.RetryAt.Before(retry)) {
+			retry = *st.RetryAt
+		}
+		o.mu.Unlock()
+		if waiting {
+			continue
+		}
+
+		for _, m := range o.pendingFor(s.Name()) {
+			err := s.Deliver(ctx, m)
+			o.mu.Lock()
+			if err != nil {
+				st.Failures++
+				st.LastError = err.Error()
+				at := now.Add(min(time.Second<<min(st.Failures-1, 16), maxOutboxBackoff))
+				st.RetryAt = &at
+				if retry.IsZero() || at.Before(retry) {
+					retry = at
+				}
+				o.mu.Unlock()
+				break
+			}
+			at := time.Now().UTC()
+			st.Delivered++
+			st.Failures, st.LastError, st.RetryAt, st.LastSuccess = 0, "", nil, &at
+			o.ack(m.ID, s.Name())
+			o.acks++
+			o.mu.Unlock()
+			if err := o.write(outboxRecord{Done: m.ID, Sink: s.Name()}); err != nil {
+				log.Printf("outbox: journal: %v", err)
+			}
+		}
+	}
+
+	o.mu.Lock()
+	o.prune()
+	due := o.journal != nil && o.acks >= outboxCompactEvery
+	o.mu.Unlock()
+	if due {
+		if err := o.compact(); err != nil {
+			log.Printf("outbox: journal: %v", err)
+		}
+	}
+	return retry
+}
+
+// Run delivers messages as they are added, retrying failed sinks with
+// backoff, until ctx is done.
+func (o *outboxQueue) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+		if retry := o.dispatch(ctx, time.Now()); !retry.IsZero() {
+			timer.Reset(time.Until(retry))
+		}
+	}
+}
+
+// retryNow clears the sinks' backoff and wakes the dispatcher.
+func (o *outboxQueue) retryNow() {
+	o.mu.Lock()
+	for _, st := range o.status {
+		st.RetryAt = nil
+	}
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookSink posts each message as JSON. The message id is in the
+// X-Outbox-Id and Idempotency-Key headers.
+type webhookSink struct {
+	url string
+}
+
+func (w webhookSink) Name() string {
+	return "webhook:" + w.url
+}
+
+func (w webhookSink) Deliver(ctx context.Context, m outboxMessage) error {
+	header := http.Header{"X-Outbox-Id": {m.ID}, "Idempotency-Key": {m.ID}}
+	return postJSON(ctx, w.url, gin.H{"id": m.ID, "topic": m.Topic, "createdAt": m.CreatedAt, "payload": m.Payload}, header)
+}
+
+// busSink publishes each message on the event bus, under its topic
+// prefixed with "outbox.", for subscribers such as other services reading
+// the Redis stream.
+type busSink struct{}
+
+func (busSink) Name() string {
+	return "bus"
+}
+
+func (busSink) Deliver(ctx context.Context, m outboxMessage) error {
+	m.Pending = nil
+	return publishEvent(ctx, "outbox."+m.Topic, m)
+}
+
+// newOutboxSinks returns the sinks the configuration names.
+func newOutboxSinks(cfg config) []outboxSink {
+	var sinks []outboxSink
+	for _, url := range cfg.OutboxWebhooks {
+		sinks = append(sinks, webhookSink{url: url})
+	}
+	if cfg.OutboxBus {
+		sinks = append(sinks, busSink{})
+	}
+	return sinks
+}
+
+// getOutbox reports each sink's progress and the oldest pending messages.
+func getOutbox(c *gin.Context) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+
+	sinks := []sinkStatus{}
+	for _, s := range outbox.sinks {
+		st := *outbox.status[s.Name()]
+		for _, m := range outbox.messages {
+			for _, name := range m.Pending {
+				if name == s.Name() {
+					st.Pending++
+				}
+			}
+		}
+		sinks = append(sinks, st)
+	}
+	pending := append([]outboxMessage{}, outbox.messages[:min(len(outbox.messages), defaultPageLimit)]...)
+	c.IndentedJSON(http.StatusOK, gin.H{"sinks": sinks, "pending": len(outbox.messages), "messages": pending})
+}
+
+// postOutboxRetry retries the failed sinks now rather than after their
+// backoff.
+func postOutboxRetry(c *gin.Context) {
+	outbox.retryNow()
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink remembers the ids it took, and fails while down.
+type fakeSink struct {
+	name string
+	down bool
+	ids  []string
+}
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Deliver(ctx context.Context, m outboxMessage) error {
+	if s.down {
+		return errors.New("connection refused")
+	}
+	s.ids = append(s.ids, m.ID)
+	return nil
+}
+
+func TestOutboxJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	a, b := &fakeSink{name: "a"}, &fakeSink{name: "b", down: true}
+	o := newOutbox([]outboxSink{a, b})
+	if err := o.open(path); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	var ids []string
+	for _, title := range []string{"Kind of Blue", "Milestones", "Sketches of Spain"} {
+		o.add(hookAlbumCreated, album{Title: title})
+		ids = append(ids, o.messages[len(o.messages)-1].ID)
+	}
+	now := time.Now()
+
+	// Check messages are journaled by the dispatcher rather than by add,
+	// which runs under albumsMu
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("Expected nothing journaled before dispatch, but got %q", data)
+	}
+
+	// Check each sink gets the messages in order, and a failing one is
+	// retried later
+	retry := o.dispatch(context.Background(), now)
+	if !reflect.DeepEqual(a.ids, ids) || len(b.ids) != 0 {
+		t.Errorf("Expected only a to take %v, but got %v and %v", ids, a.ids, b.ids)
+	}
+	if retry.Sub(now) != time.Second {
+		t.Errorf("Expected a retry after a second, but got %v", retry.Sub(now))
+	}
+	o.dispatch(context.Background(), now.Add(500*time.Millisecond))
+	if s := o.status["b"]; s.Failures != 1 || s.LastError != "connection refused" {
+		t.Errorf("Expected b not to be retried before its time, but got %+v", s)
+	}
+
+	// Check a restart, even after a torn write, resumes where the sinks
+	// left off without sending anything twice
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	f.WriteString(`{"add": {"id": "0190`)
+	f.Close()
+	a2, b2 := &fakeSink{name: "a"}, &fakeSink{name: "b"}
+	o = newOutbox([]outboxSink{a2, b2})
+	if err := o.open(path); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	o.dispatch(context.Background(), now)
+	if len(a2.ids) != 0 || !reflect.DeepEqual(b2.ids, ids) {
+		t.Errorf("Expected only b to take %v, but got %v and %v", ids, a2.ids, b2.ids)
+	}
+
+	// Check new messages get fresh ids, even though the counter of the
+	// old process is gone
+	o.add(hookAlbumCreated, album{Title: "Porgy and Bess"})
+	if id := o.messages[0].ID; len(id) != 36 || id <= ids[2] {
+		t.Errorf("Expected a UUIDv7 after %s, but got %s", ids[2], id)
+	}
+
+	// Check sinks no longer configured are dropped, and what was taken
+	// by all is gone from the journal
+	o = newOutbox([]outboxSink{&fakeSink{name: "c"}})
+	o.open(path)
+	data, _ := os.ReadFile(path)
+	if len(o.messages) != 0 || len(data) != 0 {
+		t.Errorf("Expected an empty outbox, but got %+v and %q", o.messages, data)
+	}
+}
+
+func TestOutboxWebhook(t *testing.T) {
+	resetCatalog(t)
+	var mu sync.Mutex
+	var received []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var m struct {
+			ID      string          `json:"id"`
+			Topic   string          `json:"topic"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &m)
+		if key := r.Header.Get("Idempotency-Key"); key == m.ID {
+			received = append(received, m.Topic)
+		}
+	}))
+	defer server.Close()
+	defer func(o *outboxQueue) { outbox = o }(outbox)
+	outbox = newOutbox([]outboxSink{webhookSink{url: server.URL}})
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "kob", Title: "Kind of Blue", Artist: "Miles Davis"}}
+	tracks = []track{{ID: "1", AlbumID: "kob", Title: "So What"}}
+
+	// Check edits and plays are queued with the change
+	performRequest(router, "PATCH", "/albums/kob", strings.NewReader(`{"year": 1959}`), "alice")
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1"}`), "bob")
+	outbox.dispatch(context.Background(), time.Now())
+	rr := performRequest(router, "GET", "/admin/outbox", nil, "alice")
+	var status struct {
+		Sinks   []sinkStatus `json:"sinks"`
+		Pending int          `json:"pending"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if status.Pending != 2 || len(status.Sinks) != 1 || status.Sinks[0].Pending != 2 || !strings.Contains(status.Sinks[0].LastError, "503") {
+		t.Errorf("Expected 2 messages held back by a 503, but got %s", rr.Body)
+	}
+
+	// Check a retry delivers them in order, keyed by their ids
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	if rr := performRequest(router, "POST", "/admin/outbox/retry", nil, "alice"); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, but got %d", http.StatusAccepted, rr.Code)
+	}
+	outbox.dispatch(context.Background(), time.Now())
+	mu.Lock()
+	if want := []string{"album.update", "track.played"}; !reflect.DeepEqual(received, want) {
+		t.Errorf("Expected %q, but got %q", want, received)
+	}
+	mu.Unlock()
+
+	// Check only admins see the outbox
+	if rr := performRequest(router, "GET", "/admin/outbox", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// pageParams reads ?limit= and ?offset=, responding with 400 and reporting
+// false when either is malformed.
+func pageParams(c *gin.Context) (limit, offset int, ok bool) {
+	limit, offset = defaultPageLimit, 0
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageLimit {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "limit must be between 1 and " + strconv.Itoa(maxPageLimit)})
+			return 0, 0, false
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "offset must be a non-negative integer"})
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// keysetPage returns a page of the items that match, without copying the
+// others. With ?after= the page starts after the item with that id, so
+// pages stay put when items are added or removed in front of them; the id
+// is found with position, which returns -1 for unknown ids. It responds
+// with 400 and reports false when after is unknown or combined with an
+// offset. The Link header and envelope name the cursor of the next page.
+func keysetPage[T any](c *gin.Context, items []T, id func(T) string, match func(T) bool, position func(string) int, limit, offset int) ([]T, bool) {
+	start := 0
+	if after := c.Query("after"); after != "" {
+		if offset > 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "use either offset or after"})
+			return nil, false
+		}
+		i := position(after)
+		if i < 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "after must be the id of a listed item"})
+			return nil, false
+		}
+		start = i + 1
+	}
+
+	page := []T{}
+	total, skipped, more := 0, 0, false
+	for i, item := range items {
+		if !match(item) {
+			continue
+		}
+		total++
+		if i < start || skipped < offset {
+			skipped++
+			continue
+		}
+		if len(page) < limit {
+			page = append(page, item)
+		} else {
+			more = true
+		}
+	}
+
+	meta := pageMeta{Total: total, Limit: limit, Offset: skipped}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if more {
+		meta.Next = id(page[len(page)-1])
+		next := c.Request.URL.Query()
+		next.Del("offset")
+		next.Set("after", meta.Next)
+		c.Header("Link", "<"+c.Request.URL.Path+"?"+next.Encode()+`>; rel="next"`)
+	}
+	c.Set(pageMetaKey, meta)
+	return page, true
+}
+
+// pageBounds clamps a page to a list of total items, sets X-Total-Count and
+// records the page for the response envelope.
+func pageBounds(c *gin.Context, total, limit, offset int) (start, end int) {
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.Set(pageMetaKey, pageMeta{Total: total, Limit: limit, Offset: offset})
+	start = min(offset, total)
+	end = min(start+limit, total)
+	return start, end
+}
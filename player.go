@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	stateStopped = "stopped"
+	statePlaying = "playing"
+	statePaused  = "paused"
+)
+
+// player tracks what the server is playing: a queue of track ids, the
+// position in it, and how far into the current track playback is. Elapsed
+// time is derived from the clock, and the player moves on to the next track
+// lazily whenever it is read after the current one has ended.
+type player struct {
+	mu     sync.Mutex
+	state  string
+	queue  []string
+	index  int
+	volume int
+	// position is the elapsed time in the current track when playback was
+	// last started, paused or seeked; resumedAt is when that happened.
+	position  float64
+	resumedAt time.Time
+	// rate is how fast the current track plays. Audiobooks play at speed,
+	// music always at 1.
+	rate  float64
+	speed float64
+	// shuffle is one of shuffleModes. While shuffling, unshuffled keeps the
+	// queue in the order tracks were added.
+	shuffle    string
+	unshuffled []string
+	repeat     string
+	// radio is the seed similar tracks are added for, or nil when radio is
+	// off.
+	radio *radioSeed
+	// listener is the user who last controlled the player; finished tracks
+	// go into their listening history, unless they listen privately.
+	listener string
+	private  bool
+	rand     *rand.Rand
+	now      func() time.Time
+	// watchers receive a signal whenever the player is controlled; tracks
+	// ending on their own are not signalled.
+	watchers []chan struct{}
+}
+
+var musicPlayer = newPlayer()
+
+func newPlayer() *player {
+	return &player{
+		state:   stateStopped,
+		volume:  50,
+		rate:    1,
+		speed:   1,
+		shuffle: shuffleOff,
+		repeat:  repeatOff,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:     time.Now,
+	}
+}
+
+// watch returns a channel that is signalled after the player is controlled.
+// Signals are coalesced, so a slow reader sees one for several changes.
+func (p *player) watch() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	p.watchers = append(p.watchers, ch)
+	return ch
+}
+
+// unwatch stops signalling a channel returned by watch.
+func (p *player) unwatch(ch <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.watchers = slices.DeleteFunc(p.watchers, func(w chan struct{}) bool { return w == ch })
+}
+
+// touch signals the watchers without blocking. Callers must hold p.mu.
+func (p *player) touch() {
+	for _, ch := range p.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type playerStatus struct {
+	State   string     `json:"state"`
+	TrackID string     `json:"trackId,omitempty"`
+	Index   int        `json:"index"`
+	Elapsed float64    `json:"elapsed"`
+	Volume  int        `json:"volume"`
+	Queue   int        `json:"queueLength"`
+	Speed   float64    `json:"speed"`
+	Shuffle string     `json:"shuffle"`
+	Repeat  string     `json:"repeat"`
+	Radio   *radioSeed `json:"radio,omitempty"`
+	// Private is set while the listener's plays are not recorded.
+	Private bool `json:"private"`
+	// Chapter is the index of the chapter playing, for tracks with chapters.
+	Chapter *int `json:"chapter,omitempty"`
+}
+
+var errEmptyQueue = errors.New("the queue is empty")
+
+// trackBounds returns where playback of a track starts and ends, honoring
+// skip markers. An end of 0 means the length is unknown.
+func trackBounds(id string) (start, end float64) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(id)
+	if i < 0 {
+		return 0, 0
+	}
+	t := tracks[i]
+	end = t.Duration
+	if t.Analysis != nil {
+		start = t.Analysis.SkipIn
+		if t.Analysis.SkipOut > 0 {
+			end = t.Analysis.SkipOut
+		}
+	}
+	return start, end
+}
+
+// elapsed returns how far into the current track playback is. Callers must
+// hold p.mu.
+func (p *player) elapsed() float64 {
+	if p.state != statePlaying {
+		return p.position
+	}
+	return p.position + p.now().Sub(p.resumedAt).Seconds()*p.rate
+}
+
+// rateOf returns the playback rate of a track.
+func (p *player) rateOf(id string) float64 {
+	if isAudiobookTrack(id) {
+		return p.speed
+	}
+	return 1
+}
+
+// sync moves past tracks that have finished playing, following the repeat
+// mode and otherwise stopping at the end of the queue. Radio tops the queue
+// up as it goes. Callers must hold p.mu.
+func (p *player) sync() {
+	p.extendRadio()
+	for p.state == statePlaying && p.index < len(p.queue) {
+		start, end := trackBounds(p.queue[p.index])
+		elapsed := p.elapsed()
+		if end <= 0 || elapsed < end {
+			return
+		}
+		// The overflow is in the ended track's time; convert it to wall
+		// time before applying the next track's rate.
+		overflow := (elapsed - end) / p.rate
+		if !p.private {
+			countPlay(p.queue[p.index], p.listener)
+		}
+		switch {
+		case p.repeat != repeatOff && end <= start:
+			// Repeating a track with nothing to play would never end.
+			p.state = stateStopped
+			p.position = start
+			return
+		case p.repeat == repeatOne:
+			p.seekTo(p.index)
+		case p.index+1 < len(p.queue):
+			p.seekTo(p.index + 1)
+		case p.repeat == repeatAll:
+			p.seekTo(0)
+		default:
+			p.index = len(p.queue)
+			p.state = stateStopped
+			p.position = 0
+			return
+		}
+		p.position += overflow * p.rate
+		p.extendRadio()
+	}
+}
+
+// seekTo starts the track at index from its skip-in point. Callers must
+// hold p.mu.
+func (p *player) seekTo(index int) {
+	p.index = index
+	p.position, _ = trackBounds(p.queue[index])
+	p.resumedAt = p.now()
+	p.rate = p.rateOf(p.queue[index])
+}
+
+// status returns the current state. Callers must hold p.mu.
+func (p *player) status() playerStatus {
+	p.sync()
+	s := playerStatus{State: p.state, Index: p.index, Elapsed: p.elapsed(), Volume: p.volume, Queue: len(p.queue), Speed: p.rate, Shuffle: p.shuffle, Repeat: p.repeat, Radio: p.radio, Private: p.private}
+	if p.index < len(p.queue) {
+		s.TrackID = p.queue[p.index]
+		if ch := chapterAt(trackChapters(s.TrackID), s.Elapsed); ch >= 0 {
+			s.Chapter = &ch
+		}
+	}
+	return s
+}
+
+func (p *player) Play() (playerStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	switch {
+	case len(p.queue) == 0:
+		return p.status(), errEmptyQueue
+	case p.index >= len(p.queue):
+		p.seekTo(0)
+	case p.state == stateStopped:
+		p.seekTo(p.index)
+	case p.state == statePaused:
+		p.resumedAt = p.now()
+	}
+	p.state = statePlaying
+	p.touch()
+	return p.status(), nil
+}
+
+func (p *player) Pause() playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	if p.state == statePlaying {
+		p.position = p.elapsed()
+		p.state = statePaused
+		p.touch()
+	}
+	return p.status()
+}
+
+// Stop halts playback and rewinds the current track.
+func (p *player) Stop() playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	if p.index < len(p.queue) {
+		p.seekTo(p.index)
+	}
+	p.state = stateStopped
+	p.touch()
+	return p.status()
+}
+
+// Skip moves by delta tracks and keeps the current play/pause state. With
+// repeat-all it wraps around the ends of the queue.
+func (p *player) Skip(delta int) (playerStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	if len(p.queue) == 0 {
+		return p.status(), errEmptyQueue
+	}
+	index := min(max(p.index+delta, 0), len(p.queue)-1)
+	if p.repeat == repeatAll {
+		n := len(p.queue)
+		index = ((min(p.index, n-1)+delta)%n + n) % n
+	}
+	p.seekTo(index)
+	if p.state == stateStopped {
+		p.state = statePaused
+	}
+	p.touch()
+	return p.status(), nil
+}
+
+func (p *player) SetVolume(volume int) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.volume = min(max(volume, 0), 100)
+	p.touch()
+	return p.status()
+}
+
+func (p *player) Enqueue(ids ...string) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	p.queue = append(p.queue, ids...)
+	if p.shuffle != shuffleOff {
+		p.unshuffled = append(p.unshuffled, ids...)
+		p.reshuffle()
+	}
+	p.touch()
+	return p.status()
+}
+
+// Load replaces the queue and starts playing ids[index] at position, with
+// audiobooks at the given speed. Shuffle is turned off, since loaded
+// tracks are meant to play in order.
+func (p *player) Load(ids []string, index int, position, speed float64) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = append([]string(nil), ids...)
+	p.shuffle, p.unshuffled = shuffleOff, nil
+	p.speed = speed
+	p.seekTo(index)
+	p.position = max(p.position, position)
+	p.state = statePlaying
+	p.touch()
+	return p.status()
+}
+
+func (p *player) ClearQueue() playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = nil
+	p.unshuffled = nil
+	p.index = 0
+	p.position = 0
+	p.state = stateStopped
+	p.touch()
+	return p.status()
+}
+
+// upcoming returns the ids of up to n tracks after the current one.
+// Callers must hold p.mu.
+func (p *player) upcoming(n int) []string {
+	start := min(p.index+1, len(p.queue))
+	return append([]string(nil), p.queue[start:min(start+n, len(p.queue))]...)
+}
+
+func getPlayer(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, musicPlayer.status())
+}
+
+func postPlay(c *gin.Context) {
+	status, err := musicPlayer.Play()
+	respondPlayer(c, status, err)
+}
+
+func postPause(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, musicPlayer.Pause())
+}
+
+func postNext(c *gin.Context) {
+	status, err := musicPlayer.Skip(1)
+	respondPlayer(c, status, err)
+}
+
+func postPrevious(c *gin.Context) {
+	status, err := musicPlayer.Skip(-1)
+	respondPlayer(c, status, err)
+}
+
+func putVolume(c *gin.Context) {
+	var input struct {
+		Volume *int `json:"volume"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Volume == nil || *input.Volume < 0 || *input.Volume > 100 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "volume must be between 0 and 100"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetVolume(*input.Volume))
+}
+
+func getQueue(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	musicPlayer.sync()
+	c.IndentedJSON(http.StatusOK, gin.H{"index": musicPlayer.index, "trackIds": append([]string{}, musicPlayer.queue...)})
+}
+
+// postQueue appends tracks to the queue; every id must exist.
+func postQueue(c *gin.Context) {
+	var input struct {
+		TrackIDs []string `json:"trackIds"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if id := unknownTrack(input.TrackIDs); id != "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track " + id + " not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.Enqueue(input.TrackIDs...))
+}
+
+// unknownTrack returns the first of ids that is not a track, or "".
+func unknownTrack(ids []string) string {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	for _, id := range ids {
+		if findTrack(id) < 0 {
+			return id
+		}
+	}
+	return ""
+}
+
+func deleteQueue(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, musicPlayer.ClearQueue())
+}
+
+func respondPlayer(c *gin.Context, status playerStatus, err error) {
+	if err != nil {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, status)
+}
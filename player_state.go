@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	repeatOff = "off"
+	// repeatOne plays the current track over and over.
+	repeatOne = "one"
+	// repeatAll starts the queue again after its last track.
+	repeatAll = "all"
+)
+
+var repeatModes = map[string]bool{repeatOff: true, repeatOne: true, repeatAll: true}
+
+// playerSaveInterval is how often the position is saved while playing, which
+// bounds how much is lost when the server is killed.
+const playerSaveInterval = 10 * time.Second
+
+// playerSnapshot is the player state saved across restarts.
+type playerSnapshot struct {
+	State      string     `json:"state"`
+	Queue      []string   `json:"queue"`
+	Unshuffled []string   `json:"unshuffled,omitempty"`
+	Index      int        `json:"index"`
+	Position   float64    `json:"position"`
+	Volume     int        `json:"volume"`
+	Speed      float64    `json:"speed"`
+	Shuffle    string     `json:"shuffle"`
+	Repeat     string     `json:"repeat"`
+	Radio      *radioSeed `json:"radio,omitempty"`
+	Listener   string     `json:"listener,omitempty"`
+	Private    bool       `json:"private,omitempty"`
+	SavedAt    time.Time  `json:"savedAt"`
+}
+
+func (p *player) SetRepeat(mode string) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	p.repeat = mode
+	p.touch()
+	return p.status()
+}
+
+func getRepeatMode(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"mode": musicPlayer.repeat})
+}
+
+func putRepeatMode(c *gin.Context) {
+	var input struct {
+		Mode string `json:"mode"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if !repeatModes[input.Mode] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "mode must be one of off, one or all"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetRepeat(input.Mode))
+}
+
+// snapshot returns the state to save. The position is frozen at the moment
+// of the snapshot, so a restart resumes from there rather than skipping the
+// time the server was down.
+func (p *player) snapshot() playerSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	return playerSnapshot{
+		State:      p.state,
+		Queue:      append([]string{}, p.queue...),
+		Unshuffled: append([]string(nil), p.unshuffled...),
+		Index:      p.index,
+		Position:   p.elapsed(),
+		Volume:     p.volume,
+		Speed:      p.speed,
+		Shuffle:    p.shuffle,
+		Repeat:     p.repeat,
+		Radio:      p.radio,
+		Listener:   p.listener,
+		Private:    p.private,
+		SavedAt:    p.now().UTC(),
+	}
+}
+
+// restore puts the player back in a saved state. Unknown modes fall back to
+// the defaults so an old or hand-edited file still loads.
+func (p *player) restore(s playerSnapshot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = s.Queue
+	p.unshuffled = s.Unshuffled
+	p.index = min(max(s.Index, 0), len(p.queue))
+	p.volume = min(max(s.Volume, 0), 100)
+	p.speed = 1
+	if s.Speed >= minSpeed && s.Speed <= maxSpeed {
+		p.speed = s.Speed
+	}
+	p.shuffle, p.repeat = shuffleOff, repeatOff
+	if shuffleModes[s.Shuffle] {
+		p.shuffle = s.Shuffle
+	}
+	if repeatModes[s.Repeat] {
+		p.repeat = s.Repeat
+	}
+	if p.shuffle == shuffleOff {
+		p.unshuffled = nil
+	}
+	p.radio, p.listener, p.private = s.Radio, s.Listener, s.Private
+	p.state = stateStopped
+	p.position = 0
+	if p.index < len(p.queue) {
+		p.seekTo(p.index)
+		p.position = max(p.position, s.Position)
+		if s.State == statePlaying || s.State == statePaused {
+			p.state = s.State
+		}
+	}
+	p.touch()
+}
+
+// loadPlayerState restores the player from path. A missing file is not an
+// error, since the first start has nothing to resume.
+func loadPlayerState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s playerSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	musicPlayer.restore(s)
+	return nil
+}
+
+// savePlayerState writes the player state to path, replacing the file
+// atomically so a crash never leaves it half written.
+func savePlayerState(path string) error {
+	data, err := json.MarshalIndent(musicPlayer.snapshot(), "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file, so readers and crashes never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// persistPlayerState returns a worker that saves the player state to path
+// whenever the player is controlled, periodically while it plays, and once
+// more on shutdown.
+func persistPlayerState(path string) func(context.Context) {
+	changes := musicPlayer.watch()
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(playerSaveInterval)
+		defer ticker.Stop()
+
+		save := func() {
+			if err := savePlayerState(path); err != nil {
+				log.Printf("player state: %v", err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				save()
+				return
+			case <-changes:
+				save()
+			case <-ticker.C:
+				musicPlayer.mu.Lock()
+				playing := musicPlayer.state == statePlaying
+				musicPlayer.mu.Unlock()
+				if playing {
+					save()
+				}
+			}
+		}
+	}
+}
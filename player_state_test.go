@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepeatModes(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Duration: 100}, {ID: "2", Duration: 100}}
+	musicPlayer.Enqueue("1", "2")
+	musicPlayer.Play()
+
+	// Check repeat-one plays the same track again
+	rr := performRequest(router, "PUT", "/player/repeat-mode", strings.NewReader(`{"mode": "one"}`), "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	*now = now.Add(250 * time.Second)
+	musicPlayer.mu.Lock()
+	status := musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.TrackID != "1" || status.Elapsed != 50 || playCounts["1"] != 2 {
+		t.Errorf("Expected track 1 at 50s after 2 plays, but got %+v and %d plays", status, playCounts["1"])
+	}
+
+	// Check repeat-all starts the queue again after the last track
+	musicPlayer.SetRepeat(repeatAll)
+	*now = now.Add(200 * time.Second)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.TrackID != "1" || status.Elapsed != 50 || status.State != statePlaying {
+		t.Errorf("Expected track 1 at 50s, but got %+v", status)
+	}
+
+	// Check previous wraps to the last track with repeat-all
+	status, _ = musicPlayer.Skip(-1)
+	if status.TrackID != "2" {
+		t.Errorf("Expected track 2, but got %+v", status)
+	}
+
+	// Check unknown modes are rejected
+	rr = performRequest(router, "PUT", "/player/repeat-mode", strings.NewReader(`{"mode": "twice"}`), "bob")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestPlayerStateSurvivesRestart(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	tracks = []track{{ID: "1", Duration: 100}, {ID: "2", Duration: 100}}
+	path := filepath.Join(t.TempDir(), "player.json")
+
+	// Check a missing state file is not an error
+	if err := loadPlayerState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	musicPlayer.Enqueue("1", "2")
+	musicPlayer.SetVolume(70)
+	musicPlayer.SetRepeat(repeatAll)
+	musicPlayer.Play()
+	*now = now.Add(130 * time.Second)
+	if err := savePlayerState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check a new player resumes exactly where the old one was
+	usePlayer(t)
+	if err := loadPlayerState(path); err != nil {
+		t.Fatal(err)
+	}
+	musicPlayer.mu.Lock()
+	status := musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.TrackID != "2" || status.Elapsed != 30 || status.State != statePlaying || status.Volume != 70 || status.Repeat != repeatAll {
+		t.Errorf("Expected track 2 playing at 30s, but got %+v", status)
+	}
+}
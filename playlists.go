@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// playlistGenerated marks playlists made by the server rather than a user.
+const playlistGenerated = "generated"
+
+const (
+	// dailyMixes is how many mixes each user gets, one per favorite genre.
+	dailyMixes = 3
+	// dailyMixSize is how many tracks each mix holds.
+	dailyMixSize = 30
+	// dailyMixWindow is how far back listening history counts.
+	dailyMixWindow = 30 * 24 * time.Hour
+)
+
+type playlist struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type"`
+	Owner       string   `json:"owner"`
+	TrackIDs    []string `json:"trackIds"`
+	// Query is the search a smart playlist's tracks come from.
+	Query     string    `json:"query,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// playlists is guarded by albumsMu.
+var playlists []playlist
+
+// resolvePlaylist fills in the tracks of a smart playlist; a query that no
+// longer parses finds nothing. Callers must hold albumsMu.
+func resolvePlaylist(p playlist) playlist {
+	if p.Type != playlistSmart {
+		return p
+	}
+	_, matched, _ := searchLibrary(p.Query)
+	p.TrackIDs = make([]string, len(matched))
+	for i, t := range matched {
+		p.TrackIDs[i] = t.ID
+	}
+	return p
+}
+
+// getPlaylists lists the user's playlists, optionally only those of a
+// ?type.
+func getPlaylists(c *gin.Context) {
+	user, kind := c.GetString(gin.AuthUserKey), c.Query("type")
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	owned := []playlist{}
+	for _, p := range playlists {
+		if p.Owner == user && (kind == "" || p.Type == kind) {
+			owned = append(owned, resolvePlaylist(p))
+		}
+	}
+	respondList(c, owned)
+}
+
+func getPlaylistById(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	for _, p := range playlists {
+		if p.ID == c.Param("id") && p.Owner == user {
+			c.IndentedJSON(http.StatusOK, resolvePlaylist(p))
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "playlist not found"})
+}
+
+// trackGenre returns the genre of a track's album, or "" for tracks without
+// one and for audiobooks. Callers must hold albumsMu.
+func trackGenre(t track) string {
+	if i := findAlbum(t.AlbumID); i >= 0 && !isAudiobook(albums[i]) {
+		return albums[i].Genre
+	}
+	return ""
+}
+
+// dailyMixesFor builds a user's mixes from their recent history: one per
+// favorite genre, mixing the tracks they play most with ones they have not
+// heard. The seed makes the picks rotate from day to day. Callers must hold
+// albumsMu.
+func dailyMixesFor(user string, now time.Time, seed int64) []playlist {
+	plays := map[string]int{}
+	genrePlays := map[string]int{}
+	for _, l := range listens[user] {
+		if now.Sub(l.PlayedAt) > dailyMixWindow {
+			continue
+		}
+		if i := findTrack(l.TrackID); i >= 0 {
+			if genre := trackGenre(tracks[i]); genre != "" {
+				plays[l.TrackID]++
+				genrePlays[strings.ToLower(genre)]++
+			}
+		}
+	}
+	genres := make([]string, 0, len(genrePlays))
+	for g := range genrePlays {
+		genres = append(genres, g)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if genrePlays[genres[i]] != genrePlays[genres[j]] {
+			return genrePlays[genres[i]] > genrePlays[genres[j]]
+		}
+		return genres[i] < genres[j]
+	})
+
+	r := rand.New(rand.NewSource(seed))
+	mixes := []playlist{}
+	for n, genre := range genres[:min(dailyMixes, len(genres))] {
+		var favorites, fresh []string
+		name := genre
+		for _, t := range tracks {
+			g := trackGenre(t)
+			if !strings.EqualFold(g, genre) {
+				continue
+			}
+			name = g
+			if plays[t.ID] > 0 {
+				favorites = append(favorites, t.ID)
+			} else {
+				fresh = append(fresh, t.ID)
+			}
+		}
+		r.Shuffle(len(favorites), func(i, j int) { favorites[i], favorites[j] = favorites[j], favorites[i] })
+		sort.SliceStable(favorites, func(i, j int) bool { return plays[favorites[i]] > plays[favorites[j]] })
+		r.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+		// One version of each work, favoring the one played most.
+		favorites = oneVersionEach(favorites, nil)
+		familiar := map[string]bool{}
+		for _, id := range favorites {
+			familiar[id] = true
+		}
+		fresh = oneVersionEach(fresh, familiar)
+
+		// Half familiar tracks and half new ones, topped up from either
+		// when the other runs short.
+		known := min(len(favorites), max(dailyMixSize/2, dailyMixSize-len(fresh)))
+		picked := append(favorites[:known:known], fresh[:min(len(fresh), dailyMixSize-known)]...)
+		r.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		mixes = append(mixes, playlist{
+			ID:          fmt.Sprintf("daily-mix-%s-%d", slugify(user), n+1),
+			Name:        fmt.Sprintf("Daily Mix %d", n+1),
+			Description: name,
+			Type:        playlistGenerated,
+			Owner:       user,
+			TrackIDs:    picked,
+			UpdatedAt:   now.UTC(),
+		})
+	}
+	return mixes
+}
+
+// generateDailyMixes replaces every user's generated playlists with fresh
+// mixes. It is run nightly by the scheduler.
+func generateDailyMixes(context.Context) error {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	now := time.Now()
+	kept := playlists[:0]
+	for _, p := range playlists {
+		if p.Type != playlistGenerated {
+			kept = append(kept, p)
+		}
+	}
+	playlists = kept
+	for user := range listens {
+		h := fnv.New64a()
+		h.Write([]byte(user + now.Format("2006-01-02")))
+		playlists = append(playlists, dailyMixesFor(user, now, int64(h.Sum64()))...)
+	}
+	sort.SliceStable(playlists, func(i, j int) bool { return playlists[i].ID < playlists[j].ID })
+	return nil
+}
+
+func dumpPlaylists() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(playlists, "", "    ")
+}
+
+func restorePlaylists(data []byte) (func(), int, error) {
+	var restored []playlist
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	next := 1
+	for _, p := range restored {
+		if n, err := strconv.Atoi(strings.TrimPrefix(p.ID, "smart-")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		playlists = restored
+		nextPlaylistID = next
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyMixes(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Genre: "Jazz"},
+		{ID: "2", Title: "Nevermind", Genre: "Grunge"},
+		{ID: "3", Title: "The Hobbit", Type: audiobookType, Genre: "Fantasy"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1"}, {ID: "2", AlbumID: "1"}, {ID: "3", AlbumID: "1"},
+		{ID: "4", AlbumID: "2"}, {ID: "5", AlbumID: "3"},
+	}
+
+	// Check clients can report what they played
+	for _, id := range []string{"1", "1", "4", "5"} {
+		rr := performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "`+id+`"}`), "bob")
+		if rr.Code != http.StatusCreated {
+			t.Errorf("Expected status code %d, but got %d", http.StatusCreated, rr.Code)
+		}
+	}
+	listens["bob"] = append(listens["bob"], listen{TrackID: "4", PlayedAt: time.Now().Add(-60 * 24 * time.Hour)})
+
+	// Check one mix is made per genre, favorites first, without audiobooks
+	if err := generateDailyMixes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rr := performRequest(router, "GET", "/playlists?type=generated", nil, "bob")
+	var mixes []playlist
+	json.Unmarshal(rr.Body.Bytes(), &mixes)
+	if len(mixes) != 2 {
+		t.Fatalf("Expected 2 mixes, but got %+v", mixes)
+	}
+	byName := map[string]playlist{}
+	for _, m := range mixes {
+		byName[m.Description] = m
+	}
+	if len(byName["Jazz"].TrackIDs) != 3 || len(byName["Grunge"].TrackIDs) != 1 {
+		t.Errorf("Expected a jazz mix of 3 and a grunge mix of 1, but got %+v", mixes)
+	}
+
+	// Check other users do not see the mixes
+	rr = performRequest(router, "GET", "/playlists", nil, "alice")
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected no playlists for alice, but got %s", rr.Body.String())
+	}
+	rr = performRequest(router, "GET", "/playlists/"+mixes[0].ID, nil, "alice")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestPlayerRecordsListener(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Duration: 10}}
+
+	// Check tracks finished by the player go into the controlling user's history
+	performRequest(router, "POST", "/player/queue", strings.NewReader(`{"trackIds": ["1"]}`), "bob")
+	performRequest(router, "POST", "/player/play", nil, "bob")
+	*now = now.Add(time.Minute)
+	performRequest(router, "GET", "/player", nil, "alice")
+	if len(listens["bob"]) != 1 || len(listens["alice"]) != 0 {
+		t.Errorf("Expected one listen for bob, but got %+v", listens)
+	}
+}
+
+func TestPrivateListening(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Duration: 10}}
+
+	// Check plays in a private session are neither recorded nor counted
+	rr := performRequest(router, "PUT", "/player/private-listening", strings.NewReader(`{"enabled": true}`), "bob")
+	var status playerStatus
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if !status.Private {
+		t.Errorf("Expected the player status to show private listening, but got %s", rr.Body)
+	}
+	performRequest(router, "POST", "/player/queue", strings.NewReader(`{"trackIds": ["1"]}`), "bob")
+	performRequest(router, "POST", "/player/play", nil, "bob")
+	*now = now.Add(time.Minute)
+	performRequest(router, "GET", "/player", nil, "bob")
+	if len(listens["bob"]) != 0 || playCounts["1"] != 0 {
+		t.Errorf("Expected no listens or plays, but got %+v %v", listens, playCounts)
+	}
+
+	// Check another user taking over the player ends the private session
+	performRequest(router, "POST", "/player/play", nil, "alice")
+	rr = performRequest(router, "GET", "/player/private-listening", nil, "alice")
+	if rr.Body.String() != "{\n    \"enabled\": false\n}" {
+		t.Errorf("Expected private listening off, but got %s", rr.Body)
+	}
+	if rr := performRequest(router, "PUT", "/player/private-listening", strings.NewReader(`{}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type priceChange struct {
+	Price     float64   `json:"price"`
+	ChangedAt time.Time `json:"changedAt"`
+	ChangedBy string    `json:"changedBy,omitempty"`
+}
+
+// priceHistory holds every recorded price per album id, oldest first. It is
+// guarded by albumsMu.
+var priceHistory = map[string][]priceChange{}
+
+func newPriceChange(c *gin.Context, price float64) *priceChange {
+	return &priceChange{Price: price, ChangedAt: time.Now().UTC(), ChangedBy: c.GetString(gin.AuthUserKey)}
+}
+
+// recordPriceChange appends change to the album's history. Callers must hold
+// albumsMu.
+func recordPriceChange(id string, change priceChange) {
+	priceHistory[id] = append(priceHistory[id], change)
+}
+
+func getPriceHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if findAlbum(id) < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	history := priceHistory[id]
+	if history == nil {
+		history = []priceChange{}
+	}
+	c.IndentedJSON(http.StatusOK, history)
+}
+
+func dumpPriceHistory() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(priceHistory, "", "    ")
+}
+
+func restorePriceHistory(data []byte) (func(), int, error) {
+	var restored map[string][]priceChange
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		priceHistory = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Price changes made through PATCH are recorded with who made them
+func TestPriceHistoryRecordsChanges(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Change the price twice, once as alice and once as bob
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"price": 49.99}`), "alice")
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"price": 44.99}`), "bob")
+
+	// Check a title-only change does not add an entry
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"title": "Blue Train (Remastered)"}`), "bob")
+
+	// Fetch the history
+	rr := performRequest(router, "GET", "/albums/1/price-history", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var history []priceChange
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to unmarshal response body: %s", err.Error())
+	}
+
+	// Check both changes were recorded in order
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 price changes, but got %d", len(history))
+	}
+	if history[0].Price != 49.99 || history[0].ChangedBy != "alice" {
+		t.Errorf("Expected alice's change to 49.99, but got %+v", history[0])
+	}
+	if history[1].Price != 44.99 || history[1].ChangedBy != "bob" {
+		t.Errorf("Expected bob's change to 44.99, but got %+v", history[1])
+	}
+}
+
+// Unknown albums and bad prices are rejected
+func TestPatchAlbumValidation(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Check a negative price is refused
+	if rr := performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"price": -1}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check anonymous clients cannot change albums
+	if rr := performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"price": 1}`), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// Check unknown albums return 404 for both endpoints
+	if rr := performRequest(router, "PATCH", "/albums/99", strings.NewReader(`{"price": 1}`), "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/albums/99/price-history", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
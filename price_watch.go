@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPriceAlerts is how many alerts the log keeps per user.
+const maxPriceAlerts = 200
+
+// priceAlert is an entry in the notification log: a wishlist album whose
+// price fell below its threshold, and how the owner was told.
+type priceAlert struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	WishID     string    `json:"wishId"`
+	AlbumID    string    `json:"albumId"`
+	Title      string    `json:"title"`
+	Price      float64   `json:"price"`
+	PriceBelow float64   `json:"priceBelow"`
+	At         time.Time `json:"at"`
+	// Sent lists the channels the alert went out on, and Errors those it
+	// could not be sent on.
+	notifyResult
+}
+
+// priceAlerts is guarded by albumsMu.
+var priceAlerts = map[string][]priceAlert{}
+
+func equalPrice(a, b *float64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// watchPrices is the price-watch task. It notifies the owner of each
+// wishlist item whose store album costs less than the item's threshold,
+// once per drop: again only when the price falls further, or after it has
+// risen back to the threshold.
+func watchPrices(ctx context.Context) error {
+	albumsMu.Lock()
+	var due []priceAlert
+	for i := range wishlist {
+		w := &wishlist[i]
+		if w.PriceBelow == nil {
+			continue
+		}
+		j := findAlbum(w.AlbumID)
+		if j < 0 {
+			continue
+		}
+		price := albums[j].Price
+		if price >= *w.PriceBelow {
+			w.AlertedPrice = nil
+			continue
+		}
+		if w.AlertedPrice != nil && price >= *w.AlertedPrice {
+			continue
+		}
+		w.AlertedPrice = &price
+		due = append(due, priceAlert{ID: newID(), Owner: w.Owner, WishID: w.ID, AlbumID: w.AlbumID, Title: albumLabel(albums[j]), Price: price, PriceBelow: *w.PriceBelow})
+	}
+	albumsMu.Unlock()
+
+	// Sending can be slow, so it happens without the lock.
+	var errs []error
+	for i := range due {
+		alert := &due[i]
+		alert.At = time.Now().UTC()
+		alert.notifyResult = notify(ctx, notification{
+			User:  alert.Owner,
+			Kind:  notifyPriceDrop,
+			Title: "Price drop: " + alert.Title,
+			Body:  fmt.Sprintf("%s now costs %.2f, below the %.2f on your wishlist.", alert.Title, alert.Price, alert.PriceBelow),
+			Link:  "/albums/" + alert.AlbumID,
+		})
+		for channel, msg := range alert.Errors {
+			errs = append(errs, fmt.Errorf("price alert %s for %s: %s", channel, alert.Owner, msg))
+		}
+	}
+
+	albumsMu.Lock()
+	for _, alert := range due {
+		history := append(priceAlerts[alert.Owner], alert)
+		priceAlerts[alert.Owner] = history[max(0, len(history)-maxPriceAlerts):]
+	}
+	albumsMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// albumLabel names an album for people.
+func albumLabel(a album) string {
+	if a.Artist == "" {
+		return a.Title
+	}
+	return a.Artist + " – " + a.Title
+}
+
+// getPriceAlerts lists the alerts sent to the user, newest first.
+func getPriceAlerts(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	history := append([]priceAlert{}, priceAlerts[c.GetString(gin.AuthUserKey)]...)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].At.After(history[j].At) })
+	respondList(c, history)
+}
+
+// priceWatchState is how price alerts are backed up. Targets is only read:
+// backups from before notification preferences kept alert channels here.
+type priceWatchState struct {
+	Targets map[string]notifyPrefs  `json:"targets,omitempty"`
+	Alerts  map[string][]priceAlert `json:"alerts"`
+}
+
+func dumpPriceWatch() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(priceWatchState{Alerts: priceAlerts}, "", "    ")
+}
+
+func restorePriceWatch(data []byte) (func(), int, error) {
+	var restored priceWatchState
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored.Alerts == nil {
+		restored.Alerts = map[string][]priceAlert{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		priceAlerts = restored.Alerts
+		albumsMu.Unlock()
+
+		notifyMu.Lock()
+		for user, p := range restored.Targets {
+			if _, ok := notifyPreferences[user]; !ok {
+				notifyPreferences[user] = p
+			}
+		}
+		notifyMu.Unlock()
+	}
+	return apply, len(restored.Alerts), nil
+}
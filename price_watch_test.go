@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestPriceWatch(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	var posted []notification
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		json.NewDecoder(r.Body).Decode(&n)
+		posted = append(posted, n)
+	}))
+	defer hook.Close()
+	var mailed []string
+	savedSMTP, savedSendMail := smtpConfig, sendMail
+	t.Cleanup(func() { smtpConfig, sendMail = savedSMTP, savedSendMail })
+	smtpConfig = smtpSettings{Addr: "mail.example.com:25", From: "player@example.com"}
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mailed = append(mailed, to[0]+"\n"+string(msg))
+		return nil
+	}
+
+	// Check thresholds need a store album
+	if rr := performRequest(router, "POST", "/wishlist", strings.NewReader(`{"title": "Blue Train", "priceBelow": 30}`), "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := performRequest(router, "POST", "/wishlist", strings.NewReader(`{"title": "Blue Train", "albumId": "1", "priceBelow": 30}`), "bob"); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	settings := `{"webhook": "` + hook.URL + `", "email": "bob@example.com"}`
+	if rr := performRequest(router, "PUT", "/me/notifications/preferences", strings.NewReader(settings), "bob"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	// Check nothing is sent above the threshold, and one alert on each channel below it
+	watchPrices(context.Background())
+	if len(posted) != 0 {
+		t.Errorf("Expected no alert at %.2f, but got %+v", albums[0].Price, posted)
+	}
+	albums[0].Price = 25
+	watchPrices(context.Background())
+	watchPrices(context.Background())
+	if len(posted) != 1 || posted[0].Kind != notifyPriceDrop || !strings.Contains(posted[0].Body, "now costs 25.00, below the 30.00") {
+		t.Fatalf("Expected one webhook alert, but got %+v", posted)
+	}
+	if len(mailed) != 1 || !strings.HasPrefix(mailed[0], "bob@example.com\n") || !strings.Contains(mailed[0], "Subject: Price drop: John Coltrane – Blue Train") {
+		t.Errorf("Expected one mail, but got %q", mailed)
+	}
+
+	// Check a further drop alerts again, as does a new drop after the price went back up
+	albums[0].Price = 20
+	watchPrices(context.Background())
+	albums[0].Price = 35
+	watchPrices(context.Background())
+	albums[0].Price = 28
+	watchPrices(context.Background())
+	if len(posted) != 3 {
+		t.Errorf("Expected 3 alerts, but got %d", len(posted))
+	}
+
+	// Check the log lists the alerts newest first, with failures, only for their owner
+	hook.Close()
+	albums[0].Price = 10
+	if err := watchPrices(context.Background()); err == nil {
+		t.Errorf("Expected an error for the failed webhook")
+	}
+	rr := performRequest(router, "GET", "/wishlist/price-alerts", nil, "bob")
+	var history []priceAlert
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	if len(history) != 4 || history[0].Price != 10 || history[0].Errors["webhook"] == "" || strings.Join(history[0].Sent, ",") != "inbox,email" {
+		t.Errorf("Expected 4 alerts with the failure first, but got %+v", history)
+	}
+	rr = performRequest(router, "GET", "/wishlist/price-alerts", nil, "alice")
+	if body, _ := io.ReadAll(rr.Body); strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("Expected no alerts for alice, but got %s", body)
+	}
+}
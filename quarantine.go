@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// virusScanner is nil when no scanner is configured, in which case files
+// are only checked by the admin who reviews them.
+var virusScanner fileScanner
+
+// fileScanner checks a file for malware.
+type fileScanner interface {
+	Scan(ctx context.Context, path string) (scanResult, error)
+}
+
+type scanResult struct {
+	Infected bool `json:"infected"`
+	// Detail is what the scanner said, such as the name of what it found.
+	Detail string `json:"detail,omitempty"`
+}
+
+// commandScanner runs an external scanner with the file path as its last
+// argument. Like clamscan and clamdscan, it must exit with 0 for a clean
+// file and 1 for an infected one.
+type commandScanner struct {
+	command string
+	args    []string
+}
+
+func (s commandScanner) Scan(ctx context.Context, path string) (scanResult, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command, append(s.args, path)...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return scanResult{}, nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return scanResult{Infected: true, Detail: string(bytes.TrimSpace(out.Bytes()))}, nil
+	default:
+		return scanResult{}, fmt.Errorf("%s: %w: %s", s.command, err, bytes.TrimSpace(out.Bytes()))
+	}
+}
+
+// quarantinedFile is an uploaded or downloaded file waiting for an admin to
+// approve it into the library.
+type quarantinedFile struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// Source is "upload" or "ingest".
+	Source   string    `json:"source"`
+	Filename string    `json:"filename"`
+	Path     string    `json:"path"`
+	SHA256   string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	Tags     trackTags `json:"tags"`
+	// Scan is "clean", "failed" when the scanner could not check the file,
+	// or "skipped" when no scanner is configured.
+	Scan       string `json:"scan"`
+	ScanDetail string `json:"scanDetail,omitempty"`
+	// Problems are what looks wrong with the tags.
+	Problems []string  `json:"problems"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// quarantine is guarded by albumsMu.
+var quarantine []quarantinedFile
+
+var errInfected = errors.New("the file was rejected by the virus scanner")
+
+// findQuarantined returns the index of the quarantined file with the given
+// id, or -1. Callers must hold albumsMu.
+func findQuarantined(id string) int {
+	for i, q := range quarantine {
+		if q.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// placeholderTag matches tag values left by rippers and encoders rather
+// than the music.
+var placeholderTag = regexp.MustCompile(`(?i)^(track\s*\d*|untitled|unknown( artist| album)?|audio ?track|new recording( \d+)?)$`)
+
+// tagProblems lists what looks wrong with the tags of a file.
+func tagProblems(tags trackTags) []string {
+	problems := []string{}
+	for _, field := range []struct{ name, value string }{{"title", tags.Title}, {"artist", tags.albumArtist()}, {"album", tags.Album}} {
+		switch {
+		case strings.TrimSpace(field.value) == "":
+			problems = append(problems, "no "+field.name)
+		case placeholderTag.MatchString(strings.TrimSpace(field.value)):
+			problems = append(problems, fmt.Sprintf("%s %q looks like a placeholder", field.name, field.value))
+		case strings.ContainsFunc(field.value, func(r rune) bool { return r == unicode.ReplacementChar || unicode.IsControl(r) }):
+			problems = append(problems, field.name+" has garbled characters, probably from a wrong encoding")
+		}
+	}
+	if tags.Year != 0 && (tags.Year < 1860 || tags.Year > time.Now().Year()+1) {
+		problems = append(problems, fmt.Sprintf("year %d is out of range", tags.Year))
+	}
+	if tags.Track < 0 || tags.Track > 999 {
+		problems = append(problems, fmt.Sprintf("track number %d is out of range", tags.Track))
+	}
+	return problems
+}
+
+// quarantineFile moves the file at path into quarantine under root, after
+// scanning it, and stores its content once; see objects.go. Infected files
+// are deleted.
+func quarantineFile(ctx context.Context, root, path, filename, source, owner string, tags trackTags) (quarantinedFile, error) {
+	sum, size, err := sha256File(path)
+	if err != nil {
+		return quarantinedFile{}, err
+	}
+	q := quarantinedFile{
+		ID:       newID(),
+		Owner:    owner,
+		Source:   source,
+		Filename: filepath.Base(filename),
+		SHA256:   sum,
+		Size:     size,
+		Tags:     tags,
+		Scan:     "skipped",
+		Problems: tagProblems(tags),
+		AddedAt:  time.Now().UTC(),
+	}
+	if virusScanner != nil {
+		result, err := virusScanner.Scan(ctx, path)
+		switch {
+		case err != nil:
+			q.Scan, q.ScanDetail = "failed", err.Error()
+		case result.Infected:
+			os.Remove(path)
+			log.Printf("quarantine: %s from %s is infected: %s", filename, owner, result.Detail)
+			notifyAdmins(notifyUploadReview, "Infected upload deleted", fmt.Sprintf("%s from %s: %s", filename, owner, result.Detail), "")
+			return quarantinedFile{}, errInfected
+		default:
+			q.Scan, q.ScanDetail = "clean", result.Detail
+		}
+	}
+	q.Path = filepath.Join(root, ".quarantine", q.ID+strings.ToLower(filepath.Ext(filename)))
+	if msg := moveFile(path, q.Path); msg != "" {
+		return quarantinedFile{}, errors.New(msg)
+	}
+	if err := storeObject(root, q.Path, sum, size); err != nil {
+		os.Remove(q.Path)
+		return quarantinedFile{}, err
+	}
+
+	albumsMu.Lock()
+	quarantine = append(quarantine, q)
+	albumsMu.Unlock()
+	notifyAdmins(notifyUploadReview, "Upload waiting for review", fmt.Sprintf("%s from %s", q.Filename, owner), "/quarantine/"+q.ID)
+	return q, nil
+}
+
+// fileTrack moves the file at path, whose content has the checksum sum,
+// into the library under root as <album artist>/<album>/<track> <title>,
+// and adds it as a track added by owner. The file is moved back when it
+// cannot be added.
+func fileTrack(ctx context.Context, root, path, sum, owner string, tags trackTags) (track, error) {
+	values := map[string]string{"albumartist": tags.albumArtist(), "album": tags.Album, "title": tags.Title, "track": fmt.Sprintf("%02d", tags.Track)}
+	pattern := "{albumartist}/{album}/{track} {title}"
+	if tags.Track == 0 {
+		pattern = "{albumartist}/{album}/{title}"
+	}
+	tags.Path = filepath.Join(root, expandPattern(pattern, values, path))
+	if msg := moveFile(path, tags.Path); msg != "" {
+		return track{}, fmt.Errorf("%s: %s", tags.Path, msg)
+	}
+	if info, err := os.Stat(tags.Path); err == nil {
+		tags.Size = info.Size()
+	}
+
+	albumsMu.Lock()
+	imported := importTracks([]trackTags{tags})
+	var added track
+	if imported.TracksAdded == 1 {
+		tracks[len(tracks)-1].AddedBy = owner
+		tracks[len(tracks)-1].SHA256 = sum
+		added = tracks[len(tracks)-1]
+	}
+	albumsMu.Unlock()
+	if imported.TracksAdded == 0 {
+		// Put the file back where the caller found it.
+		if msg := moveFile(tags.Path, path); msg != "" {
+			log.Printf("quarantine: moving %s back to %s: %s", tags.Path, path, msg)
+		}
+		return track{}, fmt.Errorf("could not add %s", tags.Path)
+	}
+	publishEvent(ctx, topicCacheInvalidation, nil)
+	return added, nil
+}
+
+// getQuarantine returns the handler that lists the files waiting for
+// review: all of them for admins, and their own for other users.
+func getQuarantine(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.GetString(gin.AuthUserKey)
+
+		albumsMu.RLock()
+		defer albumsMu.RUnlock()
+
+		waiting := []quarantinedFile{}
+		for _, q := range quarantine {
+			if cfg.Admins[user] || q.Owner == user {
+				waiting = append(waiting, q)
+			}
+		}
+		respondList(c, waiting)
+	}
+}
+
+func getQuarantinedById(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.GetString(gin.AuthUserKey)
+
+		albumsMu.RLock()
+		defer albumsMu.RUnlock()
+
+		i := findQuarantined(c.Param("id"))
+		if i < 0 || !cfg.Admins[user] && quarantine[i].Owner != user {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, quarantine[i])
+	}
+}
+
+// takeQuarantined removes the quarantined file with the id from review, so
+// that two admins cannot act on it at once, and answers the request when
+// there is none.
+func takeQuarantined(c *gin.Context) (quarantinedFile, bool) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findQuarantined(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return quarantinedFile{}, false
+	}
+	q := quarantine[i]
+	quarantine = append(quarantine[:i], quarantine[i+1:]...)
+	return q, true
+}
+
+// approveQuarantined returns the handler that moves a quarantined file into
+// the library under root, with any tag corrections in the body. Files the
+// scanner could not check are only approved with force.
+func approveQuarantined(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Title       *string `json:"title"`
+			Artist      *string `json:"artist"`
+			AlbumArtist *string `json:"albumArtist"`
+			Album       *string `json:"album"`
+			Track       *int    `json:"track"`
+			Disc        *int    `json:"disc"`
+			Year        *int    `json:"year"`
+			Genre       *string `json:"genre"`
+			Force       bool    `json:"force"`
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&input); err != nil {
+				return
+			}
+		}
+
+		albumsMu.RLock()
+		i := findQuarantined(c.Param("id"))
+		var q quarantinedFile
+		if i >= 0 {
+			q = quarantine[i]
+		}
+		albumsMu.RUnlock()
+		if i < 0 {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+			return
+		}
+		if q.Scan == "failed" && !input.Force {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "the virus scan failed; approve with force to add the file anyway"})
+			return
+		}
+		tags := q.Tags
+		setString := func(to, from *string) {
+			if from != nil {
+				*to = strings.TrimSpace(*from)
+			}
+		}
+		setInt := func(to, from *int) {
+			if from != nil {
+				*to = *from
+			}
+		}
+		setString(&tags.Title, input.Title)
+		setString(&tags.Artist, input.Artist)
+		setString(&tags.AlbumArtist, input.AlbumArtist)
+		setString(&tags.Album, input.Album)
+		setString(&tags.Genre, input.Genre)
+		setInt(&tags.Track, input.Track)
+		setInt(&tags.Disc, input.Disc)
+		setInt(&tags.Year, input.Year)
+		if tags.Title == "" || tags.albumArtist() == "" || tags.Album == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "title, artist and album are required"})
+			return
+		}
+
+		q, ok := takeQuarantined(c)
+		if !ok {
+			return
+		}
+		t, err := fileTrack(c.Request.Context(), root, q.Path, q.SHA256, q.Owner, tags)
+		if err != nil {
+			albumsMu.Lock()
+			quarantine = append(quarantine, q)
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+			return
+		}
+		finishIngestReview(q.ID, t, "")
+		notifyLater(notification{User: q.Owner, Kind: notifyUploadReview, Title: "Upload approved", Body: t.Title + " was added to the library.", Link: "/tracks/" + t.ID})
+		c.IndentedJSON(http.StatusCreated, t)
+	}
+}
+
+// rejectQuarantined deletes a quarantined file, telling its owner why.
+func rejectQuarantined(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+	}
+	q, ok := takeQuarantined(c)
+	if !ok {
+		return
+	}
+	albumsMu.Lock()
+	os.Remove(q.Path)
+	releaseObject(q.SHA256)
+	albumsMu.Unlock()
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		reason = "no reason given"
+	}
+	finishIngestReview(q.ID, track{}, reason)
+	notifyLater(notification{User: q.Owner, Kind: notifyUploadReview, Title: "Upload rejected", Body: q.Filename + " was not added: " + reason})
+	c.Status(http.StatusNoContent)
+}
+
+func dumpQuarantine() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(quarantine, "", "    ")
+}
+
+func restoreQuarantine(data []byte) (func(), int, error) {
+	var restored []quarantinedFile
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		quarantine = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
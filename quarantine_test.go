@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeScanner finds files with "EICAR" in them infected, and cannot scan
+// files with "broken" in them.
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(ctx context.Context, path string) (scanResult, error) {
+	data, _ := os.ReadFile(path)
+	switch {
+	case strings.Contains(string(data), "EICAR"):
+		return scanResult{Infected: true, Detail: "Eicar-Signature FOUND"}, nil
+	case strings.Contains(string(data), "broken"):
+		return scanResult{}, errors.New("clamd is not running")
+	}
+	return scanResult{}, nil
+}
+
+func TestTagProblems(t *testing.T) {
+	// Check sound tags have no problems
+	if p := tagProblems(trackTags{Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Year: 1959}); len(p) != 0 {
+		t.Errorf("Expected no problems, but got %v", p)
+	}
+
+	// Check missing, placeholder and garbled tags are reported
+	p := tagProblems(trackTags{Title: "Track 01", Artist: "Bj�rk", Year: 3000})
+	if len(p) != 4 {
+		t.Errorf("Expected 4 problems, but got %v", p)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	defer func(s fileScanner) { virusScanner = s }(virusScanner)
+	virusScanner = fakeScanner{}
+	quarantineText := func(name, content string) (quarantinedFile, error) {
+		path := filepath.Join(root, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		return addUpload(context.Background(), root, path, name, "bob", ingestTags{})
+	}
+
+	// Check infected files are deleted rather than quarantined
+	if _, err := quarantineText("virus.mp3", "EICAR"); err != errInfected {
+		t.Errorf("Expected the file to be rejected, but got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "virus.mp3")); !os.IsNotExist(err) || len(quarantine) != 0 {
+		t.Errorf("Expected the infected file to be deleted, but got %v and %+v", err, quarantine)
+	}
+
+	// Check files the scanner could not check need force to be approved
+	q, _ := quarantineText("Track 1.mp3", "broken scan")
+	if q.Scan != "failed" || len(q.Problems) != 3 {
+		t.Errorf("Expected a failed scan and tag problems, but got %+v", q)
+	}
+	body := `{"title": "Freddie Freeloader", "artist": "Miles Davis", "album": "Kind of Blue", "track": 2}`
+	rr := performRequest(router, "POST", "/quarantine/"+q.ID+"/approve", strings.NewReader(body), "alice")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Check only admins approve, and owners see their own files
+	rr = performRequest(router, "POST", "/quarantine/"+q.ID+"/approve", strings.NewReader(body), "bob")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/quarantine/"+q.ID, nil, "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check approving without tags is refused, and corrected tags are used
+	rr = performRequest(router, "POST", "/quarantine/"+q.ID+"/approve", strings.NewReader(`{"force": true}`), "alice")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = performRequest(router, "POST", "/quarantine/"+q.ID+"/approve", strings.NewReader(strings.Replace(body, "{", `{"force": true, `, 1)), "alice")
+	var added track
+	json.Unmarshal(rr.Body.Bytes(), &added)
+	if rr.Code != http.StatusCreated || added.Path != filepath.Join(root, "Miles Davis", "Kind of Blue", "02 Freddie Freeloader.mp3") {
+		t.Errorf("Expected the corrected track to be filed, but got %d %+v", rr.Code, added)
+	}
+
+	// Check a file that cannot be added stays in quarantine where it was
+	q, _ = quarantineText("so-what.flac", "fLaC so what")
+	filed := filepath.Join(root, "Miles Davis", "Kind of Blue", "01 So What.flac")
+	tracks = append(tracks, track{ID: "taken", AlbumID: added.AlbumID, Path: filed})
+	rr = performRequest(router, "POST", "/quarantine/"+q.ID+"/approve", strings.NewReader(`{"title": "So What", "artist": "Miles Davis", "album": "Kind of Blue", "track": 1}`), "alice")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+	if _, err := os.Stat(q.Path); err != nil || findQuarantined(q.ID) < 0 {
+		t.Errorf("Expected the file back in quarantine, but got %v", err)
+	}
+	if _, err := os.Stat(filed); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing left in the library, but got %v", err)
+	}
+	performRequest(router, "POST", "/quarantine/"+q.ID+"/reject", nil, "alice")
+
+	// Check rejected files are deleted and their owner told
+	q, _ = quarantineText("demo.flac", "fLaC demo")
+	rr = performRequest(router, "POST", "/quarantine/"+q.ID+"/reject", strings.NewReader(`{"reason": "not music"}`), "alice")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	if _, err := os.Stat(q.Path); !os.IsNotExist(err) || len(quarantine) != 0 {
+		t.Errorf("Expected the file to be deleted, but got %v and %+v", err, quarantine)
+	}
+	notifyMu.Lock()
+	inbox := inboxes["bob"]
+	notifyMu.Unlock()
+	if len(inbox) == 0 || !strings.Contains(inbox[len(inbox)-1].Body, "not music") {
+		t.Errorf("Expected bob to be told why, but got %+v", inbox)
+	}
+
+	// Check users only list their own files
+	quarantineText("mine.flac", "fLaC mine")
+	for user, want := range map[string]int{"alice": 1, "bob": 1} {
+		rr = performRequest(router, "GET", "/quarantine", nil, user)
+		var listed []quarantinedFile
+		json.Unmarshal(rr.Body.Bytes(), &listed)
+		if len(listed) != want {
+			t.Errorf("Expected %s to see %d files, but got %d", user, want, len(listed))
+		}
+	}
+	quarantine[0].Owner = "carol"
+	rr = performRequest(router, "GET", "/quarantine", nil, "bob")
+	if strings.Contains(rr.Body.String(), "mine.flac") {
+		t.Errorf("Expected bob not to see carol's file, but got %s", rr.Body)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A query combines terms such as artist:"Coltrane", year>=1957 or a bare
+// word with AND, OR, NOT and parentheses. Terms next to each other are
+// ANDed. Text fields match with : (contains), = and !=, ignoring case;
+// number fields also support <, <=, > and >=. A bare word matches any of
+// the name fields.
+//
+//	query := or
+//	or    := and ("OR" and)*
+//	and   := unary (["AND"] unary)*
+//	unary := "NOT" unary | "(" or ")" | term
+//	term  := word [op (word | string)] | string
+
+type queryToken struct {
+	// kind is one of "word", "string", "op", "(", ")" and "end".
+	kind string
+	text string
+	pos  int
+}
+
+// queryError is a parse error, pointing at a 1-based position in the query.
+type queryError struct {
+	pos int
+	msg string
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("q: %s at position %d", e.msg, e.pos)
+}
+
+func isQueryWordByte(b byte) bool {
+	return !strings.ContainsRune(" \t\r\n()\":<>=!", rune(b))
+}
+
+func lexQuery(q string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(q); {
+		switch b := q[i]; {
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
+			i++
+		case b == '(' || b == ')':
+			tokens = append(tokens, queryToken{kind: string(b), text: string(b), pos: i + 1})
+			i++
+		case b == '"':
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return nil, &queryError{i + 1, "unterminated quote"}
+			}
+			tokens = append(tokens, queryToken{kind: "string", text: q[i+1 : i+1+end], pos: i + 1})
+			i += end + 2
+		case b == ':' || b == '=':
+			tokens = append(tokens, queryToken{kind: "op", text: string(b), pos: i + 1})
+			i++
+		case b == '<' || b == '>' || b == '!':
+			op := string(b)
+			if i+1 < len(q) && q[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &queryError{i + 1, `unexpected "!", did you mean "!=" or NOT`}
+			}
+			tokens = append(tokens, queryToken{kind: "op", text: op, pos: i + 1})
+			i += len(op)
+		default:
+			start := i
+			for i < len(q) && isQueryWordByte(q[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{kind: "word", text: q[start:i], pos: start + 1})
+		}
+	}
+	return append(tokens, queryToken{kind: "end", pos: len(q) + 1}), nil
+}
+
+// queryNode is a parsed query. op is "and", "or", "not" or "term"; terms
+// without a field are bare words.
+type queryNode struct {
+	op    string
+	kids  []*queryNode
+	field string
+	cmp   string
+	value string
+}
+
+type queryParser struct {
+	tokens []queryToken
+	i      int
+	// numeric names the fields a query may use, and whether each holds
+	// numbers.
+	numeric map[string]bool
+}
+
+// parseQuery parses q, checking its fields against numeric.
+func parseQuery(q string, numeric map[string]bool) (*queryNode, error) {
+	tokens, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, numeric: numeric}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, &queryError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return n, nil
+}
+
+func (p *queryParser) peek() queryToken { return p.tokens[p.i] }
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.i]
+	if t.kind != "end" {
+		p.i++
+	}
+	return t
+}
+
+func (p *queryParser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == "word" && t.text == word
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryNode{op: "or", kids: []*queryNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t := p.peek(); t.kind == "end" || t.kind == ")" || p.keyword("OR") {
+			return left, nil
+		}
+		if p.keyword("AND") {
+			p.next()
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryNode{op: "and", kids: []*queryNode{left, right}}
+	}
+}
+
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	t := p.peek()
+	switch {
+	case p.keyword("NOT"):
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{op: "not", kids: []*queryNode{n}}, nil
+	case p.keyword("AND") || p.keyword("OR"):
+		return nil, &queryError{t.pos, fmt.Sprintf("expected a term before %s", t.text)}
+	case t.kind == "(":
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != ")" {
+			return nil, &queryError{t.pos, `missing ")" for this "("`}
+		}
+		p.next()
+		return n, nil
+	case t.kind == "word" || t.kind == "string":
+		p.next()
+		if p.peek().kind == "op" {
+			return p.parseTerm(t)
+		}
+		return &queryNode{op: "term", value: t.text}, nil
+	case t.kind == "op":
+		return nil, &queryError{t.pos, fmt.Sprintf("expected a field name before %q", t.text)}
+	case t.kind == ")":
+		return nil, &queryError{t.pos, `unexpected ")"`}
+	default:
+		return nil, &queryError{t.pos, "query ends unexpectedly"}
+	}
+}
+
+// parseTerm parses the rest of a field comparison whose field is name.
+func (p *queryParser) parseTerm(name queryToken) (*queryNode, error) {
+	op := p.next()
+	if name.kind != "word" {
+		return nil, &queryError{op.pos, fmt.Sprintf("expected a field name before %q", op.text)}
+	}
+	field := strings.ToLower(name.text)
+	number, ok := p.numeric[field]
+	if !ok {
+		return nil, &queryError{name.pos, fmt.Sprintf("unknown field %q, known fields are %s", name.text, queryFieldNames(p.numeric))}
+	}
+	value := p.next()
+	if value.kind != "word" && value.kind != "string" {
+		return nil, &queryError{value.pos, fmt.Sprintf("expected a value after %s%s", name.text, op.text)}
+	}
+	if number {
+		if _, err := strconv.ParseFloat(value.text, 64); err != nil {
+			return nil, &queryError{value.pos, fmt.Sprintf("%s needs a number, got %q", field, value.text)}
+		}
+	} else if op.text != ":" && op.text != "=" && op.text != "!=" {
+		return nil, &queryError{op.pos, fmt.Sprintf("%s is text and cannot be compared with %s", field, op.text)}
+	}
+	return &queryNode{op: "term", field: field, cmp: op.text, value: value.text}, nil
+}
+
+func queryFieldNames(numeric map[string]bool) string {
+	names := make([]string, 0, len(numeric))
+	for name := range numeric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// queryField reads one queryable field of a T. Exactly one of text and
+// number is set; number reports false when the value is unknown, and such
+// values match no comparison.
+type queryField[T any] struct {
+	text   func(T) []string
+	number func(T) (float64, bool)
+}
+
+// queryFieldKinds returns the field names of fields for parseQuery.
+func queryFieldKinds[T any](fields map[string]queryField[T]) map[string]bool {
+	numeric := map[string]bool{}
+	for name, f := range fields {
+		numeric[name] = f.number != nil
+	}
+	return numeric
+}
+
+// compileQuery turns a parsed query into a filter over fields. Bare words
+// match any of the defaults, and fields missing from fields match nothing.
+func compileQuery[T any](n *queryNode, fields map[string]queryField[T], defaults []string) func(T) bool {
+	switch n.op {
+	case "and", "or":
+		left := compileQuery(n.kids[0], fields, defaults)
+		right := compileQuery(n.kids[1], fields, defaults)
+		if n.op == "and" {
+			return func(x T) bool { return left(x) && right(x) }
+		}
+		return func(x T) bool { return left(x) || right(x) }
+	case "not":
+		inner := compileQuery(n.kids[0], fields, defaults)
+		return func(x T) bool { return !inner(x) }
+	}
+
+	if n.field == "" {
+		word := strings.ToLower(n.value)
+		return func(x T) bool {
+			for _, name := range defaults {
+				for _, s := range fields[name].text(x) {
+					if strings.Contains(strings.ToLower(s), word) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+	}
+	f, ok := fields[n.field]
+	if !ok {
+		return func(T) bool { return false }
+	}
+	if f.number != nil {
+		want, _ := strconv.ParseFloat(n.value, 64)
+		cmp := n.cmp
+		return func(x T) bool {
+			v, ok := f.number(x)
+			if !ok {
+				return false
+			}
+			switch cmp {
+			case "<":
+				return v < want
+			case "<=":
+				return v <= want
+			case ">":
+				return v > want
+			case ">=":
+				return v >= want
+			case "!=":
+				return v != want
+			}
+			return v == want
+		}
+	}
+	want, cmp := strings.ToLower(n.value), n.cmp
+	return func(x T) bool {
+		found := false
+		for _, s := range f.text(x) {
+			s = strings.ToLower(s)
+			if cmp == ":" && strings.Contains(s, want) || cmp != ":" && s == want {
+				found = true
+				break
+			}
+		}
+		return found == (cmp != "!=")
+	}
+}
+
+func textField[T any](get func(T) string) queryField[T] {
+	return queryField[T]{text: func(x T) []string { return []string{get(x)} }}
+}
+
+var albumQueryFields = map[string]queryField[album]{
+	"title":  textField(func(a album) string { return a.Title }),
+	"artist": textField(func(a album) string { return a.Artist }),
+	"genre":  textField(func(a album) string { return a.Genre }),
+	"type":   textField(func(a album) string { return a.Type }),
+	"tag":    {text: func(a album) []string { return a.Tags }},
+	"label":  textField(func(a album) string { return a.Label }),
+	"catno":  textField(func(a album) string { return a.CatalogNumber }),
+	"year":   {number: func(a album) (float64, bool) { return float64(a.Year), a.Year > 0 }},
+	"price":  {number: func(a album) (float64, bool) { return a.Price, true }},
+	"rating": {number: func(a album) (float64, bool) {
+		if a.Rating == nil {
+			return 0, false
+		}
+		return a.Rating.Average, true
+	}},
+}
+
+// trackAlbum returns the album of a track. Callers must hold albumsMu.
+func trackAlbum(t track) album {
+	if i := findAlbum(t.AlbumID); i >= 0 {
+		return albums[i]
+	}
+	return album{}
+}
+
+// trackAnalysisField reads a number from a track's analysis, which only
+// analyzed tracks have.
+func trackAnalysisField(get func(*trackAnalysis) float64) queryField[track] {
+	return queryField[track]{number: func(t track) (float64, bool) {
+		if t.Analysis == nil {
+			return 0, false
+		}
+		return get(t.Analysis), true
+	}}
+}
+
+// trackQueryFields include fields of each track's album, so filtering
+// tracks needs albumsMu held.
+var trackQueryFields = map[string]queryField[track]{
+	"title":   textField(func(t track) string { return t.Title }),
+	"artist":  textField(func(t track) string { return t.Artist }),
+	"album":   textField(func(t track) string { return trackAlbum(t).Title }),
+	"genre":   textField(func(t track) string { return trackAlbum(t).Genre }),
+	"format":  textField(func(t track) string { return t.Format }),
+	"edition": textField(func(t track) string { return t.Edition }),
+	"tag":     {text: func(t track) []string { return trackAlbum(t).Tags }},
+	"mood":    {text: func(t track) []string { return t.Moods }},
+	"key": {text: func(t track) []string {
+		if t.Analysis == nil {
+			return nil
+		}
+		return []string{t.Analysis.Key}
+	}},
+	"year": {number: func(t track) (float64, bool) {
+		year := trackAlbum(t).Year
+		return float64(year), year > 0
+	}},
+	"duration": {number: func(t track) (float64, bool) { return t.Duration, t.Duration > 0 }},
+	"bitrate":  {number: func(t track) (float64, bool) { return float64(t.Bitrate), t.Bitrate > 0 }},
+	"bpm":      trackAnalysisField(func(a *trackAnalysis) float64 { return a.BPM }),
+	"loudness": trackAnalysisField(func(a *trackAnalysis) float64 { return a.Loudness }),
+}
+
+var (
+	albumQueryDefaults = []string{"title", "artist", "genre", "tag"}
+	trackQueryDefaults = []string{"title", "artist", "album"}
+)
+
+// albumQuery compiles a query over albums.
+func albumQuery(q string) (func(album) bool, error) {
+	n, err := parseQuery(q, queryFieldKinds(albumQueryFields))
+	if err != nil {
+		return nil, err
+	}
+	return compileQuery(n, albumQueryFields, albumQueryDefaults), nil
+}
+
+// trackQuery compiles a query over tracks.
+func trackQuery(q string) (func(track) bool, error) {
+	n, err := parseQuery(q, queryFieldKinds(trackQueryFields))
+	if err != nil {
+		return nil, err
+	}
+	return compileQuery(n, trackQueryFields, trackQueryDefaults), nil
+}
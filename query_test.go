@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQueryFilters(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz", Year: 1957},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Genre: "Jazz", Year: 1960, Tags: []string{"hard-bop"}},
+		{ID: "3", Title: "Kind of Blue", Artist: "Miles Davis", Genre: "Jazz", Year: 1959},
+		{ID: "4", Title: "Nevermind", Artist: "Nirvana", Genre: "Grunge"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane", Analysis: &trackAnalysis{BPM: 240}},
+		{ID: "2", AlbumID: "2", Title: "Naima", Artist: "John Coltrane", Analysis: &trackAnalysis{BPM: 60}},
+		{ID: "3", AlbumID: "4", Title: "Lithium", Artist: "Nirvana"},
+	}
+
+	albumIDs := func(q string) string {
+		rr := performRequest(router, "GET", "/albums?q="+url.QueryEscape(q), nil, "")
+		var got []album
+		json.Unmarshal(rr.Body.Bytes(), &got)
+		var ids []string
+		for _, a := range got {
+			ids = append(ids, a.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// Check field comparisons, implicit AND, OR, NOT and parentheses
+	cases := map[string]string{
+		`artist:"Coltrane" AND year>=1957 AND genre:jazz`: "1,2",
+		`artist:coltrane year<1960`:                       "1",
+		`blue`:                                            "1,3",
+		`year=1959 OR tag:hard-bop`:                       "2,3",
+		`genre=jazz NOT (artist:davis OR year>1959)`:      "1",
+		`genre!=jazz`:                                     "4",
+		`year>0`:                                          "1,2,3",
+	}
+	for q, want := range cases {
+		if got := albumIDs(q); got != want {
+			t.Errorf("Expected albums %q for %s, but got %q", want, q, got)
+		}
+	}
+
+	// Check track queries can use album and analysis fields
+	rr := performRequest(router, "GET", "/tracks?q="+url.QueryEscape(`album:"giant steps" OR bpm>200`), nil, "")
+	var got []track
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if len(got) != 2 || got[0].ID != "1" || got[1].ID != "2" {
+		t.Errorf("Expected tracks 1 and 2, but got %+v", got)
+	}
+
+	// Check parse errors say what is wrong and where
+	errors := map[string]string{
+		`year>=`:               "expected a value after year>= at position 7",
+		`artist:"Coltrane`:     "unterminated quote at position 8",
+		`colour:blue`:          `unknown field "colour"`,
+		`year>=late`:           `year needs a number, got "late" at position 7`,
+		`title>b`:              "title is text and cannot be compared with > at position 6",
+		`(genre:jazz`:          `missing ")" for this "(" at position 1`,
+		`genre:jazz OR`:        "query ends unexpectedly at position 14",
+		`AND genre:jazz`:       "expected a term before AND at position 1",
+		`genre:jazz)`:          `unexpected ")" at position 11`,
+		`:jazz`:                `expected a field name before ":" at position 1`,
+		`genre:jazz ! year>10`: `unexpected "!"`,
+	}
+	for q, want := range errors {
+		rr := performRequest(router, "GET", "/albums?q="+url.QueryEscape(q), nil, "")
+		var body struct{ Message string }
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(body.Message, want) {
+			t.Errorf("Expected a 400 mentioning %q for %s, but got %d %q", want, q, rr.Code, body.Message)
+		}
+	}
+}
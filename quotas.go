@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// storageQuotas bound how much room each user's uploads and downloads may
+// take up. Admins have no quota unless one is set for them by name.
+type storageQuotas struct {
+	// Default applies to users without a quota of their own; zero means
+	// no limit, here and in Users.
+	Default int64
+	Users   map[string]int64
+	Admins  map[string]bool
+}
+
+func newStorageQuotas(cfg config) storageQuotas {
+	return storageQuotas{Default: cfg.StorageQuota, Users: cfg.StorageQuotas, Admins: cfg.Admins}
+}
+
+// limit returns the user's quota in bytes, or 0 when they have none.
+func (q storageQuotas) limit(user string) int64 {
+	if n, ok := q.Users[user]; ok {
+		return n
+	}
+	if q.Admins[user] {
+		return 0
+	}
+	return q.Default
+}
+
+// storageUsage is how much room a user takes up, in bytes.
+type storageUsage struct {
+	// Quota is 0 when the user has no quota, and Available is then left
+	// out.
+	Quota     int64  `json:"quota"`
+	Used      int64  `json:"used"`
+	Available *int64 `json:"available,omitempty"`
+	// Library counts the files of tracks the user added, Pending those
+	// waiting in quarantine and InProgress the full size of unfinished
+	// chunked uploads.
+	Library    int64  `json:"library"`
+	Pending    int64  `json:"pending"`
+	InProgress int64  `json:"inProgress"`
+	Summary    string `json:"summary"`
+}
+
+// usage adds up the room the user takes up. Callers must hold albumsMu.
+func (q storageQuotas) usage(user string) storageUsage {
+	u := storageUsage{Quota: q.limit(user)}
+	for _, t := range tracks {
+		if t.AddedBy == user {
+			u.Library += t.Size
+		}
+	}
+	for _, f := range quarantine {
+		if f.Owner == user {
+			u.Pending += f.Size
+		}
+	}
+	for _, s := range uploads {
+		if s.Owner == user {
+			u.InProgress += s.Size
+		}
+	}
+	u.Used = u.Library + u.Pending + u.InProgress
+	if u.Quota == 0 {
+		u.Summary = formatSize(u.Used) + " used"
+		return u
+	}
+	available := max(u.Quota-u.Used, 0)
+	u.Available = &available
+	u.Summary = fmt.Sprintf("%s of %s used", formatSize(u.Used), formatSize(u.Quota))
+	return u
+}
+
+// check reports whether the user has room for size more bytes. When they
+// do not, it returns 403 if the quota is used up and 413 if only this file
+// is too large, with a message saying so. A size of 0 only checks that
+// some room is left.
+func (q storageQuotas) check(user string, size int64) (int, string) {
+	albumsMu.RLock()
+	u := q.usage(user)
+	albumsMu.RUnlock()
+
+	switch {
+	case u.Quota == 0:
+		return 0, ""
+	case *u.Available == 0:
+		return http.StatusForbidden, fmt.Sprintf("your storage quota of %s is used up; remove some of your tracks or uploads, or ask an admin for more room", formatSize(u.Quota))
+	case size > *u.Available:
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("this file (%s) is larger than the %s left of your %s storage quota", formatSize(size), formatSize(*u.Available), formatSize(u.Quota))
+	}
+	return 0, ""
+}
+
+// formatSize writes a size in bytes the way file managers do, such as
+// "1.5 GB", counting in powers of 1024.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// getMyQuota returns the handler that reports the user's storage quota and
+// what uses it.
+func getMyQuota(quotas storageQuotas) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		albumsMu.RLock()
+		defer albumsMu.RUnlock()
+
+		c.IndentedJSON(http.StatusOK, quotas.usage(c.GetString(gin.AuthUserKey)))
+	}
+}
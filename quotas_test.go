@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	// Check sizes are read with and without units
+	for in, want := range map[string]int64{"512": 512, "1K": 1024, "500MB": 500 << 20, "1.5GiB": 3 << 29, "2 tb": 2 << 40} {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("Expected %s to be %d, but got %d %v", in, want, got, err)
+		}
+	}
+
+	// Check other text is refused
+	if _, err := parseSize("lots"); err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	// Check sizes are written in the largest fitting unit
+	for n, want := range map[int64]string{900: "900 B", 1536: "1.5 KB", 10 << 30: "10.0 GB"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("Expected %d to be %q, but got %q", n, want, got)
+		}
+	}
+}
+
+func TestStorageQuotas(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	cfg.StorageQuota = 100
+	cfg.StorageQuotas = map[string]int64{"carol": 0}
+	router := setupRouter(cfg)
+	quotas := newStorageQuotas(cfg)
+
+	// Check admins and named users have their own limits
+	if quotas.limit("bob") != 100 || quotas.limit("alice") != 0 || quotas.limit("carol") != 0 {
+		t.Errorf("Expected 100, 0 and 0, but got %d, %d and %d", quotas.limit("bob"), quotas.limit("alice"), quotas.limit("carol"))
+	}
+
+	// Check usage counts library tracks, quarantined files and unfinished uploads
+	tracks = []track{{ID: "1", Size: 30, AddedBy: "bob"}, {ID: "2", Size: 500}}
+	quarantine = []quarantinedFile{{ID: "q", Owner: "bob", Size: 20}}
+	rr := performRequest(router, "POST", "/tracks/uploads", strings.NewReader(`{"filename": "a.flac", "size": 40}`), "bob")
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, but got %d", http.StatusCreated, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/me/quota", nil, "bob")
+	var usage storageUsage
+	json.Unmarshal(rr.Body.Bytes(), &usage)
+	if usage.Used != 90 || usage.Available == nil || *usage.Available != 10 || usage.Summary != "90 B of 100 B used" {
+		t.Errorf("Expected 90 of 100 bytes used, but got %+v", usage)
+	}
+
+	// Check a file larger than what is left gets 413
+	rr = performRequest(router, "POST", "/tracks/uploads", strings.NewReader(`{"filename": "b.flac", "size": 11}`), "bob")
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "10 B left") {
+		t.Errorf("Expected status code %d saying what is left, but got %d %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body)
+	}
+
+	// Check a used up quota gets 403, also for URL downloads
+	tracks[0].Size = 40
+	rr = performRequest(router, "GET", "/me/quota", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &usage)
+	if *usage.Available != 0 {
+		t.Fatalf("Expected no room left, but got %+v", usage)
+	}
+	body, contentType := multipartUpload("c.flac", []byte("fLaC"), nil)
+	req, _ := http.NewRequest("POST", "/tracks/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	req.SetBasicAuth("bob", "bob-pw")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "used up") {
+		t.Errorf("Expected status code %d, but got %d %s", http.StatusForbidden, rec.Code, rec.Body)
+	}
+	defer func(d mediaDownloader) { urlIngester = d }(urlIngester)
+	urlIngester = fakeDownloader{}
+	rr = performRequest(router, "POST", "/library/ingest-url", strings.NewReader(`{"url": "https://example.com/a"}`), "bob")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// Check users without a quota are not limited
+	rr = performRequest(router, "GET", "/me/quota", nil, "alice")
+	usage = storageUsage{}
+	json.Unmarshal(rr.Body.Bytes(), &usage)
+	if usage.Quota != 0 || usage.Available != nil {
+		t.Errorf("Expected no quota, but got %+v", usage)
+	}
+}
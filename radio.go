@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// radioLowWater is how few upcoming tracks make radio add more.
+	radioLowWater = 3
+	// radioBatch is how many tracks radio adds at a time.
+	radioBatch = 5
+	// radioHistory is how many recent queue entries radio avoids repeating.
+	radioHistory = 50
+	// radioRelated is how many related albums are considered per seed album.
+	radioRelated = 10
+)
+
+// radioSeed is what radio plays music like: a track id, an artist name or a
+// genre.
+type radioSeed struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// seedAlbums returns the albums radio starts from. Callers must hold
+// albumsMu.
+func (s radioSeed) seedAlbums() []album {
+	var seeds []album
+	switch s.Type {
+	case "track":
+		if i := findTrack(s.Value); i >= 0 {
+			if j := findAlbum(tracks[i].AlbumID); j >= 0 {
+				seeds = append(seeds, albums[j])
+			}
+		}
+	case "artist":
+		for _, a := range albums {
+			if strings.EqualFold(a.Artist, s.Value) {
+				seeds = append(seeds, a)
+			}
+		}
+	case "genre":
+		for _, a := range albums {
+			if strings.EqualFold(a.Genre, s.Value) {
+				seeds = append(seeds, a)
+			}
+		}
+	}
+	return seeds
+}
+
+// radioCandidates returns tracks of the seed albums and the albums the
+// recommendation engine relates to them, leaving out audiobooks, the
+// tracks in skip and other versions of them, and all but one version of
+// each work. Callers must hold albumsMu.
+func radioCandidates(seed radioSeed, skip map[string]bool) []string {
+	pool := map[string]bool{}
+	for _, a := range seed.seedAlbums() {
+		pool[a.ID] = !isAudiobook(a)
+		for _, r := range relatedTo(a, radioRelated) {
+			pool[r.Album.ID] = !isAudiobook(r.Album)
+		}
+	}
+	var candidates []string
+	for _, t := range tracks {
+		if pool[t.AlbumID] && !skip[t.ID] {
+			candidates = append(candidates, t.ID)
+		}
+	}
+	if seed.Type == "track" && !skip[seed.Value] && findTrack(seed.Value) >= 0 {
+		candidates = append([]string{seed.Value}, candidates...)
+	}
+	return oneVersionEach(candidates, skip)
+}
+
+// extendRadio appends similar tracks when radio is on and the queue is
+// running low. Callers must hold p.mu.
+func (p *player) extendRadio() {
+	if p.radio == nil || len(p.queue)-p.index-1 >= radioLowWater {
+		return
+	}
+	skip := map[string]bool{}
+	for _, id := range p.queue[max(len(p.queue)-radioHistory, 0):] {
+		skip[id] = true
+	}
+
+	albumsMu.RLock()
+	candidates := radioCandidates(*p.radio, skip)
+	albumsMu.RUnlock()
+
+	p.rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	added := candidates[:min(radioBatch, len(candidates))]
+	p.queue = append(p.queue, added...)
+	if p.shuffle != shuffleOff {
+		p.unshuffled = append(p.unshuffled, added...)
+	}
+}
+
+// SetRadio turns radio on with a seed, or off with nil.
+func (p *player) SetRadio(seed *radioSeed) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.radio = seed
+	p.sync()
+	p.touch()
+	return p.status()
+}
+
+func getRadio(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"enabled": musicPlayer.radio != nil, "seed": musicPlayer.radio})
+}
+
+// putRadio turns radio on. The seed must match something in the library,
+// so radio does not silently stay empty.
+func putRadio(c *gin.Context) {
+	var seed radioSeed
+
+	if err := c.BindJSON(&seed); err != nil {
+		return
+	}
+	if seed.Type != "track" && seed.Type != "artist" && seed.Type != "genre" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "type must be one of track, artist or genre"})
+		return
+	}
+
+	albumsMu.RLock()
+	found := len(seed.seedAlbums()) > 0 || seed.Type == "track" && findTrack(seed.Value) >= 0
+	albumsMu.RUnlock()
+	if !found {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "nothing in the library matches the seed"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetRadio(&seed))
+}
+
+func deleteRadio(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetRadio(nil))
+}
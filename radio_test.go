@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRadio(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	musicPlayer.rand = rand.New(rand.NewSource(1))
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz"},
+		{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Genre: "Jazz"},
+		{ID: "3", Title: "Nevermind", Artist: "Nirvana", Genre: "Grunge"},
+		{ID: "4", Title: "Jazz for Beginners", Artist: "John Coltrane", Type: audiobookType},
+	}
+	for i := 1; i <= 12; i++ {
+		id := string(rune('a' + i))
+		tracks = append(tracks, track{ID: id, AlbumID: string(rune('0' + (i-1)%4 + 1)), Duration: 100})
+	}
+
+	// Check seeds must match the library
+	rr := performRequest(router, "PUT", "/player/radio", strings.NewReader(`{"type": "artist", "value": "Nobody"}`), "bob")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check radio fills an empty queue with related music only
+	rr = performRequest(router, "PUT", "/player/radio", strings.NewReader(`{"type": "artist", "value": "john coltrane"}`), "bob")
+	var status playerStatus
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if rr.Code != http.StatusOK || status.Radio == nil || status.Queue == 0 {
+		t.Fatalf("Expected radio to fill the queue, but got %d %+v", rr.Code, status)
+	}
+	albumsMu.RLock()
+	for _, id := range musicPlayer.queue {
+		if albumID := tracks[findTrack(id)].AlbumID; albumID != "1" && albumID != "2" {
+			t.Errorf("Expected only jazz albums, but got track %s of album %s", id, albumID)
+		}
+	}
+	albumsMu.RUnlock()
+
+	// Check playback carries on past the original queue
+	musicPlayer.Play()
+	*now = now.Add(time.Duration(status.Queue) * 100 * time.Second)
+	musicPlayer.mu.Lock()
+	status = musicPlayer.status()
+	musicPlayer.mu.Unlock()
+	if status.State != statePlaying {
+		t.Errorf("Expected radio to keep playing, but got %+v", status)
+	}
+
+	// Check turning radio off stops adding tracks
+	rr = performRequest(router, "DELETE", "/player/radio", nil, "bob")
+	var stopped playerStatus
+	json.Unmarshal(rr.Body.Bytes(), &stopped)
+	if stopped.Radio != nil {
+		t.Errorf("Expected radio to be off, but got %+v", stopped.Radio)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !(linux && (amd64 || arm64))
+
+package main
+
+import "os"
+
+// adviseSequential does nothing where fadvise is not available; the
+// kernel's default readahead applies.
+func adviseSequential(f *os.File) {}
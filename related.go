@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// similarity scores how related a candidate album is to a target album. A
+// zero score means unrelated; reason explains a non-zero score to clients.
+type similarity interface {
+	Score(target, candidate album) (score float64, reason string)
+}
+
+// similarities are summed to rank related albums. Replace or extend this
+// list to plug in a better recommendation engine.
+var similarities = []similarity{sameArtist{}, sameGenre{}, sharedTags{}}
+
+type sameArtist struct{}
+
+func (sameArtist) Score(target, candidate album) (float64, string) {
+	if target.Artist != "" && strings.EqualFold(target.Artist, candidate.Artist) {
+		return 3, "same artist"
+	}
+	return 0, ""
+}
+
+type sameGenre struct{}
+
+func (sameGenre) Score(target, candidate album) (float64, string) {
+	if target.Genre != "" && strings.EqualFold(target.Genre, candidate.Genre) {
+		return 2, "same genre"
+	}
+	return 0, ""
+}
+
+type sharedTags struct{}
+
+func (sharedTags) Score(target, candidate album) (float64, string) {
+	shared := 0
+	for _, tag := range target.Tags {
+		if hasTag(candidate.Tags, tag) {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return 0, ""
+	}
+	return 0.5 * float64(shared), "shared tags"
+}
+
+type relatedAlbum struct {
+	Album   album    `json:"album"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// relatedTo ranks every other album by its summed similarity to target.
+// Audiobooks are only related to audiobooks, and music only to music.
+// Callers must hold albumsMu.
+func relatedTo(target album, limit int) []relatedAlbum {
+	related := []relatedAlbum{}
+	for _, candidate := range albums {
+		if candidate.ID == target.ID || isAudiobook(candidate) != isAudiobook(target) {
+			continue
+		}
+		r := relatedAlbum{Album: candidate}
+		for _, s := range similarities {
+			if score, reason := s.Score(target, candidate); score > 0 {
+				r.Score += score
+				r.Reasons = append(r.Reasons, reason)
+			}
+		}
+		if r.Score > 0 {
+			related = append(related, r)
+		}
+	}
+	sort.SliceStable(related, func(i, j int) bool { return related[i].Score > related[j].Score })
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+func getRelatedAlbums(c *gin.Context) {
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findAlbum(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, relatedTo(albums[i], limit))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Related albums are ranked by artist, then genre, then shared tags
+func TestGetRelatedAlbums(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Build a small catalog around Blue Train
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz", Tags: []string{"hard bop"}},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Genre: "Jazz"},
+		{ID: "3", Title: "Moanin'", Artist: "Art Blakey", Genre: "jazz", Tags: []string{"hard bop"}},
+		{ID: "4", Title: "Kind of Blue", Artist: "Miles Davis", Genre: "Jazz"},
+		{ID: "5", Title: "Nevermind", Artist: "Nirvana", Genre: "Rock"},
+	}
+
+	// Ask for the top three related albums
+	rr := performRequest(router, "GET", "/albums/1/related?limit=3", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var related []relatedAlbum
+	json.Unmarshal(rr.Body.Bytes(), &related)
+
+	// Check the order and that unrelated albums are left out
+	var ids []string
+	for _, r := range related {
+		ids = append(ids, r.Album.ID)
+	}
+	if len(ids) != 3 || ids[0] != "2" || ids[1] != "3" || ids[2] != "4" {
+		t.Errorf("Expected albums [2 3 4], but got %v", ids)
+	}
+	if len(related[0].Reasons) != 2 {
+		t.Errorf("Expected two reasons for album 2, but got %v", related[0].Reasons)
+	}
+
+	// Check unknown albums return 404
+	if rr := performRequest(router, "GET", "/albums/99/related", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// releaseGroupTypes are the kinds of release group.
+var releaseGroupTypes = []string{"box-set", "deluxe", "multi-album"}
+
+// releaseGroup gathers albums released together, such as the discs of a
+// box set or the standard and deluxe editions of a record, so browse
+// views list them once.
+type releaseGroup struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+	// Type is one of releaseGroupTypes.
+	Type string `json:"type"`
+	// AlbumIDs lists the albums of the group in order, such as by disc.
+	AlbumIDs []string `json:"albumIds"`
+	// CoverAlbumID picks the album whose artwork stands for the group.
+	CoverAlbumID string `json:"coverAlbumId,omitempty"`
+	// CoverURL overrides the artwork of the albums.
+	CoverURL string `json:"coverUrl,omitempty"`
+}
+
+// releaseGroupSummary is how a group is shown on an album in a browse
+// view.
+type releaseGroupSummary struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Type     string `json:"type"`
+	Albums   int    `json:"albums"`
+	CoverURL string `json:"coverUrl,omitempty"`
+}
+
+// releaseGroups is guarded by albumsMu. An album belongs to at most one
+// group.
+var releaseGroups []releaseGroup
+
+// findReleaseGroup returns the position of the group id, or -1. Callers
+// must hold albumsMu.
+func findReleaseGroup(id string) int {
+	return slices.IndexFunc(releaseGroups, func(g releaseGroup) bool { return g.ID == id })
+}
+
+// albumReleaseGroup returns the position of the group an album belongs
+// to, or -1. Callers must hold albumsMu.
+func albumReleaseGroup(albumID string) int {
+	return slices.IndexFunc(releaseGroups, func(g releaseGroup) bool { return slices.Contains(g.AlbumIDs, albumID) })
+}
+
+// groupAlbums returns the albums of a group still in the library, in
+// order. Callers must hold albumsMu.
+func groupAlbums(g releaseGroup) []album {
+	found := []album{}
+	for _, id := range g.AlbumIDs {
+		if i := findAlbum(id); i >= 0 {
+			found = append(found, albums[i])
+		}
+	}
+	return found
+}
+
+// albumCoverURL returns where an album's artwork can be fetched: its cover
+// URL, or its cover file, or "" when it has neither. Callers must hold
+// albumsMu.
+func albumCoverURL(a album) string {
+	if a.CoverURL != "" {
+		return a.CoverURL
+	}
+	if albumCoverFile(a.ID) != "" {
+		return "/albums/" + a.ID + "/cover"
+	}
+	return ""
+}
+
+// groupCover returns the canonical artwork of a group and the album it
+// comes from: the group's own cover URL, or else the cover of its chosen
+// album, or else that of the first album with one. Callers must hold
+// albumsMu.
+func groupCover(g releaseGroup) (albumID, url string) {
+	if g.CoverURL != "" {
+		return "", g.CoverURL
+	}
+	if i := findAlbum(g.CoverAlbumID); i >= 0 {
+		if url := albumCoverURL(albums[i]); url != "" {
+			return albums[i].ID, url
+		}
+	}
+	for _, a := range groupAlbums(g) {
+		if url := albumCoverURL(a); url != "" {
+			return a.ID, url
+		}
+	}
+	return "", ""
+}
+
+// summary returns how the group is shown in browse views. Callers must
+// hold albumsMu.
+func (g releaseGroup) summary() *releaseGroupSummary {
+	_, cover := groupCover(g)
+	return &releaseGroupSummary{ID: g.ID, Title: g.Title, Type: g.Type, Albums: len(groupAlbums(g)), CoverURL: cover}
+}
+
+func getReleaseGroups(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	list := []*releaseGroupSummary{}
+	for _, g := range releaseGroups {
+		list = append(list, g.summary())
+	}
+	respondList(c, list)
+}
+
+// getReleaseGroupById returns a group with its albums in order and its
+// canonical cover.
+func getReleaseGroupById(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findReleaseGroup(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "release group not found"})
+		return
+	}
+	g := releaseGroups[i]
+	constituents := slices.DeleteFunc(groupAlbums(g), func(a album) bool { return !visibleTo(c, a) })
+	coverAlbumID, coverURL := groupCover(g)
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"group":  g,
+		"albums": constituents,
+		"cover":  gin.H{"albumId": coverAlbumID, "url": coverURL},
+	})
+}
+
+// bindReleaseGroup reads and checks a group from the request, defaulting
+// its title and artist to those of its first album. Callers must hold
+// albumsMu.
+func bindReleaseGroup(c *gin.Context, g *releaseGroup) bool {
+	if err := c.BindJSON(g); err != nil {
+		return false
+	}
+	g.Title, g.Artist = strings.TrimSpace(g.Title), strings.TrimSpace(g.Artist)
+	if g.Type == "" {
+		g.Type = "multi-album"
+	}
+	if !slices.Contains(releaseGroupTypes, g.Type) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "type must be one of " + strings.Join(releaseGroupTypes, ", ")})
+		return false
+	}
+	if len(g.AlbumIDs) == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "albumIds is required"})
+		return false
+	}
+	for k, id := range g.AlbumIDs {
+		if findAlbum(id) < 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album " + id + " not found"})
+			return false
+		}
+		if slices.Contains(g.AlbumIDs[:k], id) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album " + id + " is listed twice"})
+			return false
+		}
+	}
+	if g.CoverAlbumID != "" && !slices.Contains(g.AlbumIDs, g.CoverAlbumID) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "coverAlbumId must be one of albumIds"})
+		return false
+	}
+	first := albums[findAlbum(g.AlbumIDs[0])]
+	if g.Title == "" {
+		g.Title = first.Title
+	}
+	if g.Artist == "" {
+		g.Artist = first.Artist
+	}
+	return true
+}
+
+// claimAlbums takes the albums of group id out of any other group. Callers
+// must hold albumsMu.
+func claimAlbums(id string, albumIDs []string) {
+	for k := range releaseGroups {
+		if releaseGroups[k].ID != id {
+			releaseGroups[k].AlbumIDs = slices.DeleteFunc(releaseGroups[k].AlbumIDs, func(a string) bool { return slices.Contains(albumIDs, a) })
+		}
+	}
+}
+
+// postReleaseGroup creates a group. Albums already in another group are
+// moved to the new one.
+func postReleaseGroup(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	var g releaseGroup
+	if !bindReleaseGroup(c, &g) {
+		return
+	}
+	g.ID = newID()
+	claimAlbums(g.ID, g.AlbumIDs)
+	releaseGroups = append(releaseGroups, g)
+	c.IndentedJSON(http.StatusCreated, g)
+}
+
+// putReleaseGroup replaces a group.
+func putReleaseGroup(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findReleaseGroup(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "release group not found"})
+		return
+	}
+	var g releaseGroup
+	if !bindReleaseGroup(c, &g) {
+		return
+	}
+	g.ID = releaseGroups[i].ID
+	claimAlbums(g.ID, g.AlbumIDs)
+	releaseGroups[i] = g
+	c.IndentedJSON(http.StatusOK, g)
+}
+
+// deleteReleaseGroup ungroups the albums of a group; the albums stay.
+func deleteReleaseGroup(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findReleaseGroup(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "release group not found"})
+		return
+	}
+	releaseGroups = slices.Delete(releaseGroups, i, i+1)
+	c.Status(http.StatusNoContent)
+}
+
+func dumpReleaseGroups() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(releaseGroups, "", "    ")
+}
+
+func restoreReleaseGroups(data []byte) (func(), int, error) {
+	var restored []releaseGroup
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		releaseGroups = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
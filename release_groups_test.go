@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseGroups(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "The Complete Blue Note Sessions, Disc 1", Artist: "John Coltrane", Year: 1957},
+		{ID: "2", Title: "The Complete Blue Note Sessions, Disc 2", Artist: "John Coltrane", Year: 1958, CoverURL: "https://example.com/disc2.jpg"},
+		{ID: "3", Title: "Giant Steps", Artist: "John Coltrane", Year: 1960},
+		{ID: "4", Title: "Giant Steps (Deluxe Edition)", Artist: "John Coltrane", Year: 1960},
+	}
+
+	// Check a group is created with its title and artist
+	rr := performRequest(router, "POST", "/release-groups", strings.NewReader(`{"title": "The Complete Blue Note Sessions", "type": "box-set", "albumIds": ["1", "2"]}`), "alice")
+	var created releaseGroup
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if rr.Code != http.StatusCreated || created.ID == "" || created.Artist != "John Coltrane" {
+		t.Errorf("Expected the box set to be created, but got %d %+v", rr.Code, created)
+	}
+
+	// Check the group lists its albums in order with a canonical cover
+	rr = performRequest(router, "GET", "/release-groups/"+created.ID, nil, "")
+	var response struct {
+		Albums []album `json:"albums"`
+		Cover  struct {
+			AlbumID string `json:"albumId"`
+			URL     string `json:"url"`
+		} `json:"cover"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Albums) != 2 || response.Albums[0].ID != "1" || response.Albums[1].ID != "2" {
+		t.Errorf("Expected albums 1 and 2, but got %+v", response.Albums)
+	}
+	if response.Cover.AlbumID != "2" || response.Cover.URL != "https://example.com/disc2.jpg" {
+		t.Errorf("Expected the cover of album 2, but got %+v", response.Cover)
+	}
+
+	// Check bad groups are rejected
+	for _, tc := range []struct {
+		method, path, body, user string
+		code                     int
+	}{
+		{"POST", "/release-groups", `{"albumIds": []}`, "alice", http.StatusBadRequest},
+		{"POST", "/release-groups", `{"albumIds": ["9"]}`, "alice", http.StatusBadRequest},
+		{"POST", "/release-groups", `{"albumIds": ["3", "3"]}`, "alice", http.StatusBadRequest},
+		{"POST", "/release-groups", `{"albumIds": ["3"], "type": "bundle"}`, "alice", http.StatusBadRequest},
+		{"POST", "/release-groups", `{"albumIds": ["3"], "coverAlbumId": "4"}`, "alice", http.StatusBadRequest},
+		{"POST", "/release-groups", `{"albumIds": ["3", "4"]}`, "bob", http.StatusForbidden},
+		{"PUT", "/release-groups/9", `{"albumIds": ["3"]}`, "alice", http.StatusNotFound},
+		{"GET", "/release-groups/9", "", "", http.StatusNotFound},
+	} {
+		if rr = performRequest(router, tc.method, tc.path, strings.NewReader(tc.body), tc.user); rr.Code != tc.code {
+			t.Errorf("Expected status code %d for %s %s %s, but got %d", tc.code, tc.method, tc.path, tc.body, rr.Code)
+		}
+	}
+
+	// Check an album moves to the last group it was put in
+	rr = performRequest(router, "POST", "/release-groups", strings.NewReader(`{"type": "deluxe", "albumIds": ["3", "4", "2"], "coverUrl": "https://example.com/giant.jpg"}`), "alice")
+	var deluxe releaseGroup
+	json.Unmarshal(rr.Body.Bytes(), &deluxe)
+	if deluxe.Title != "Giant Steps" || !reflect.DeepEqual(releaseGroups[0].AlbumIDs, []string{"1"}) {
+		t.Errorf("Expected album 2 to move to Giant Steps, but got %+v and %+v", deluxe, releaseGroups[0])
+	}
+	performRequest(router, "PUT", "/release-groups/"+deluxe.ID, strings.NewReader(`{"title": "Giant Steps", "type": "deluxe", "albumIds": ["3", "4"]}`), "alice")
+	if !reflect.DeepEqual(releaseGroups[1].AlbumIDs, []string{"3", "4"}) || releaseGroups[1].CoverURL != "" {
+		t.Errorf("Expected the group to be replaced, but got %+v", releaseGroups[1])
+	}
+
+	// Check deleting a group leaves its albums
+	if rr = performRequest(router, "DELETE", "/release-groups/"+created.ID, nil, "alice"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	if len(releaseGroups) != 1 || len(albums) != 4 {
+		t.Errorf("Expected one group and four albums, but got %d and %d", len(releaseGroups), len(albums))
+	}
+}
+
+func TestReleaseGroupsBrowse(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	day := func(d int) *time.Time {
+		at := time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC)
+		return &at
+	}
+	albums = []album{
+		{ID: "1", Title: "Giant Steps", Artist: "John Coltrane", Year: 1960},
+		{ID: "2", Title: "Giant Steps (Deluxe Edition)", Artist: "John Coltrane", Year: 2020},
+		{ID: "3", Title: "Blue Train", Artist: "John Coltrane", Year: 1957},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Giant Steps", AddedAt: day(1)},
+		{ID: "2", AlbumID: "2", Title: "Giant Steps (Alternate Take)", AddedAt: day(3)},
+		{ID: "3", AlbumID: "3", Title: "Blue Train", AddedAt: day(2)},
+	}
+	releaseGroups = []releaseGroup{{ID: "g1", Title: "Giant Steps", Type: "deluxe", AlbumIDs: []string{"1", "2"}}}
+
+	// Check recently added lists the group once, at its newest album
+	rr := performRequest(router, "GET", "/browse/recently-added", nil, "")
+	var added []addedAlbum
+	json.Unmarshal(rr.Body.Bytes(), &added)
+	if len(added) != 2 || added[0].Album.ID != "2" || added[0].Group == nil || added[0].Group.Albums != 2 || added[1].Group != nil {
+		t.Errorf("Expected the group then album 3, but got %+v", added)
+	}
+
+	// Check the discography lists the group under its earliest year
+	rr = performRequest(router, "GET", "/artists/john-coltrane/discography", nil, "")
+	var response struct {
+		Discography []discographyGroup `json:"discography"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	want := []discographyGroup{
+		{Year: 1957, Type: "album", Releases: []discographyRelease{{AlbumID: "3", Title: "Blue Train", InLibrary: true}}},
+		{Year: 1960, Type: "deluxe", Releases: []discographyRelease{{AlbumID: "1", GroupID: "g1", Title: "Giant Steps", InLibrary: true}}},
+	}
+	if !reflect.DeepEqual(response.Discography, want) {
+		t.Errorf("Expected %+v, but got %+v", want, response.Discography)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// releaseWindow is how far back a release still counts as recent, both in
+// /me/releases and for notifications.
+const releaseWindow = 90 * 24 * time.Hour
+
+// followedArtist is an artist a user follows.
+type followedArtist struct {
+	ArtistID   string    `json:"artistId"`
+	Name       string    `json:"name"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
+// artistRelease is a release group MusicBrainz lists for a followed artist.
+type artistRelease struct {
+	ArtistID      string `json:"artistId"`
+	Artist        string `json:"artist"`
+	MusicBrainzID string `json:"musicBrainzId"`
+	Title         string `json:"title"`
+	Type          string `json:"type,omitempty"`
+	// Date is as MusicBrainz gives it: YYYY-MM-DD, YYYY-MM, YYYY or "".
+	Date      string    `json:"date,omitempty"`
+	FoundAt   time.Time `json:"foundAt"`
+	InLibrary bool      `json:"inLibrary"`
+}
+
+// follows, artistReleases and releaseChecks are guarded by albumsMu.
+// artistReleases holds every release group found per artist id, and
+// releaseChecks when each artist was last looked up.
+var (
+	follows        = map[string][]followedArtist{}
+	artistReleases = map[string][]artistRelease{}
+	releaseChecks  = map[string]time.Time{}
+	// musicBrainzPause keeps lookups within MusicBrainz's rate limit of
+	// one request a second.
+	musicBrainzPause = 2 * time.Second
+)
+
+// releaseDay returns the first day a release date can mean, or false when
+// it has none.
+func releaseDay(date string) (time.Time, bool) {
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// current reports whether a release is upcoming or came out within
+// releaseWindow of now. Undated releases count from when they were found.
+func (r artistRelease) current(now time.Time) bool {
+	day, ok := releaseDay(r.Date)
+	if !ok {
+		day = r.FoundAt
+	}
+	return day.After(now.Add(-releaseWindow))
+}
+
+// getFollows lists the artists the user follows.
+func getFollows(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	list := append([]followedArtist{}, follows[c.GetString(gin.AuthUserKey)]...)
+	sort.Slice(list, func(i, j int) bool { return list[i].ArtistID < list[j].ArtistID })
+	respondList(c, list)
+}
+
+// putFollow follows an artist in the library; new releases by them are
+// looked up on the next new-releases run.
+func putFollow(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	a, _ := artistAlbums(c.Param("id"))
+	if a.ID == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "artist not found"})
+		return
+	}
+	for _, f := range follows[user] {
+		if f.ArtistID == a.ID {
+			c.IndentedJSON(http.StatusOK, f)
+			return
+		}
+	}
+	f := followedArtist{ArtistID: a.ID, Name: a.Name, FollowedAt: time.Now().UTC()}
+	follows[user] = append(follows[user], f)
+	c.IndentedJSON(http.StatusOK, f)
+}
+
+func deleteFollow(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	for i, f := range follows[user] {
+		if f.ArtistID == c.Param("id") {
+			follows[user] = append(follows[user][:i], follows[user][i+1:]...)
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "not following this artist"})
+}
+
+// getMyReleases lists releases by the artists the user follows: upcoming
+// ones soonest first, and those out within the last 90 days newest first.
+func getMyReleases(c *gin.Context) {
+	now := time.Now()
+
+	albumsMu.RLock()
+	upcoming, recent := []artistRelease{}, []artistRelease{}
+	for _, f := range follows[c.GetString(gin.AuthUserKey)] {
+		_, owned := artistAlbums(f.ArtistID)
+		for _, r := range artistReleases[f.ArtistID] {
+			if !r.current(now) {
+				continue
+			}
+			r.InLibrary = ownsRelease(owned, r.Title)
+			if day, ok := releaseDay(r.Date); ok && day.After(now) {
+				upcoming = append(upcoming, r)
+			} else {
+				recent = append(recent, r)
+			}
+		}
+	}
+	albumsMu.RUnlock()
+
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].Date < upcoming[j].Date })
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].Date > recent[j].Date })
+	c.IndentedJSON(http.StatusOK, gin.H{"upcoming": upcoming, "recent": recent})
+}
+
+func ownsRelease(owned []album, title string) bool {
+	for _, a := range owned {
+		if strings.EqualFold(a.Title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNewReleases is the new-releases task. It looks up every followed
+// artist on MusicBrainz and notifies their followers of release groups
+// that were not there before and are upcoming or recent. The first lookup
+// of an artist only records what exists, so following someone does not
+// bring a notification for each of their old records.
+func checkNewReleases(ctx context.Context) error {
+	if musicBrainz == nil {
+		return nil
+	}
+
+	albumsMu.RLock()
+	names := map[string]string{}
+	for _, list := range follows {
+		for _, f := range list {
+			names[f.ArtistID] = f.Name
+		}
+	}
+	albumsMu.RUnlock()
+
+	ids := make([]string, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for n, id := range ids {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(musicBrainzPause):
+			}
+		}
+		groups, err := musicBrainz.releaseGroups(ctx, names[id])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", names[id], err))
+			continue
+		}
+		for _, r := range recordReleases(id, names[id], groups) {
+			notifyFollowers(r)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordReleases adds the release groups of an artist that are not known
+// yet, and returns those to notify followers of.
+func recordReleases(id, name string, groups []mbReleaseGroup) []artistRelease {
+	now := time.Now().UTC()
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	_, baseline := releaseChecks[id]
+	baseline = !baseline
+	releaseChecks[id] = now
+	known := map[string]bool{}
+	for _, r := range artistReleases[id] {
+		known[r.MusicBrainzID] = true
+	}
+	var fresh []artistRelease
+	for _, g := range groups {
+		if known[g.ID] {
+			continue
+		}
+		typ := g.Type()
+		if typ == "" {
+			typ = "album"
+		}
+		r := artistRelease{ArtistID: id, Artist: name, MusicBrainzID: g.ID, Title: g.Title, Type: typ, Date: g.FirstReleaseDate, FoundAt: now}
+		artistReleases[id] = append(artistReleases[id], r)
+		if !baseline && r.current(now) {
+			fresh = append(fresh, r)
+		}
+	}
+	return fresh
+}
+
+// notifyFollowers tells everyone following a release's artist about it.
+func notifyFollowers(r artistRelease) {
+	albumsMu.RLock()
+	var users []string
+	for user, list := range follows {
+		for _, f := range list {
+			if f.ArtistID == r.ArtistID {
+				users = append(users, user)
+			}
+		}
+	}
+	albumsMu.RUnlock()
+
+	body := r.Title + " (" + r.Type + ")"
+	if r.Date != "" {
+		body += ", out " + r.Date
+	}
+	for _, user := range users {
+		notifyLater(notification{User: user, Kind: notifyNewRelease, Title: "New release by " + r.Artist, Body: body, Link: "/me/releases"})
+	}
+}
+
+// followState is how follows and the releases found for them are backed
+// up.
+type followState struct {
+	Follows  map[string][]followedArtist `json:"follows"`
+	Releases map[string][]artistRelease  `json:"releases"`
+	Checks   map[string]time.Time        `json:"checks"`
+}
+
+func dumpFollows() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(followState{Follows: follows, Releases: artistReleases, Checks: releaseChecks}, "", "    ")
+}
+
+func restoreFollows(data []byte) (func(), int, error) {
+	var restored followState
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored.Follows == nil {
+		restored.Follows = map[string][]followedArtist{}
+	}
+	if restored.Releases == nil {
+		restored.Releases = map[string][]artistRelease{}
+	}
+	if restored.Checks == nil {
+		restored.Checks = map[string]time.Time{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		follows, artistReleases, releaseChecks = restored.Follows, restored.Releases, restored.Checks
+		albumsMu.Unlock()
+	}
+	return apply, len(restored.Follows), nil
+}
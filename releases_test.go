@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReleases(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	soon := time.Now().AddDate(0, 1, 0).Format(time.DateOnly)
+	lately := time.Now().AddDate(0, 0, -10).Format(time.DateOnly)
+
+	// Serve a fake MusicBrainz whose release groups grow between runs
+	groups := `{"id": "a", "title": "Blue Train", "primary-type": "Album", "first-release-date": "1957-09"},
+		{"id": "b", "title": "Unearthed", "primary-type": "Album", "first-release-date": "` + lately + `"}`
+	mb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/artist":
+			w.Write([]byte(`{"artists": [{"id": "coltrane-mbid"}]}`))
+		case "/release-group":
+			w.Write([]byte(`{"release-groups": [` + groups + `]}`))
+		}
+	}))
+	defer mb.Close()
+	previous, pause := musicBrainz, musicBrainzPause
+	musicBrainz, musicBrainzPause = newMusicBrainzClient(mb.URL), 0
+	t.Cleanup(func() { musicBrainz, musicBrainzPause = previous, pause })
+
+	// Check only artists in the library can be followed
+	if rr := performRequest(router, "PUT", "/artists/nobody/follow", nil, "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/artists/john-coltrane/follow", nil, "bob"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check the first run records what exists without notifying
+	if err := checkNewReleases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rr := performRequest(router, "GET", "/me/notifications", nil, "bob"); strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected no notifications for the back catalog, but got %s", rr.Body)
+	}
+
+	// Check a release announced later notifies the follower
+	groups += `, {"id": "c", "title": "Next Steps", "primary-type": "EP", "first-release-date": "` + soon + `"},
+		{"id": "d", "title": "Lost Tapes", "primary-type": "Album", "first-release-date": "1965"}`
+	checkNewReleases(context.Background())
+	rr := performRequest(router, "GET", "/me/notifications", nil, "bob")
+	var inbox []notification
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox) != 1 || inbox[0].Kind != notifyNewRelease || inbox[0].Body != "Next Steps (ep), out "+soon {
+		t.Errorf("Expected one notification for the upcoming EP, but got %+v", inbox)
+	}
+
+	// Check the feed splits upcoming from recent releases, leaving old ones out
+	rr = performRequest(router, "GET", "/me/releases", nil, "bob")
+	var feed struct{ Upcoming, Recent []artistRelease }
+	json.Unmarshal(rr.Body.Bytes(), &feed)
+	if len(feed.Upcoming) != 1 || feed.Upcoming[0].Title != "Next Steps" || len(feed.Recent) != 1 || feed.Recent[0].Title != "Unearthed" || feed.Recent[0].InLibrary {
+		t.Errorf("Expected one upcoming and one recent release, but got %+v", feed)
+	}
+
+	// Check unfollowing empties the feed
+	if rr := performRequest(router, "DELETE", "/artists/john-coltrane/follow", nil, "bob"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/me/releases", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &feed)
+	if len(feed.Upcoming)+len(feed.Recent) != 0 {
+		t.Errorf("Expected an empty feed, but got %+v", feed)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRemoteBackup bounds how much of another instance's backup is read.
+const maxRemoteBackup = 512 << 20
+
+const (
+	// conflictSkip keeps local albums, tracks and playlists as they are.
+	conflictSkip = "skip"
+	// conflictOverwrite replaces them with the remote versions.
+	conflictOverwrite = "overwrite"
+	// conflictMerge fills in what is missing locally from the remote
+	// versions, and adds up play counts.
+	conflictMerge = "merge"
+)
+
+var remoteClient = &http.Client{Timeout: 5 * time.Minute}
+
+type remoteImportRequest struct {
+	// URL is the root of the other instance, e.g. http://nas:8080.
+	URL string `json:"url"`
+	// Username and Password are an admin account on the other instance.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Conflict string `json:"conflict"`
+	DryRun   bool   `json:"dryRun"`
+}
+
+// importCounts reports what an import did with one kind of item.
+type importCounts struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+type remoteImportResult struct {
+	Albums     importCounts `json:"albums"`
+	Tracks     importCounts `json:"tracks"`
+	Playlists  importCounts `json:"playlists"`
+	PlayCounts importCounts `json:"playCounts"`
+	DryRun     bool         `json:"dryRun"`
+}
+
+// remoteCatalog is the part of another instance's backup that is imported.
+type remoteCatalog struct {
+	albums     []album
+	tracks     []track
+	playlists  []playlist
+	playCounts map[string]int
+}
+
+// fetchRemoteCatalog downloads a backup from another instance.
+func fetchRemoteCatalog(ctx context.Context, r remoteImportRequest) (remoteCatalog, error) {
+	var remote remoteCatalog
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.URL, "/")+"/admin/backup", nil)
+	if err != nil {
+		return remote, err
+	}
+	req.SetBasicAuth(r.Username, r.Password)
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return remote, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return remote, fmt.Errorf("remote backup returned %s", resp.Status)
+	}
+	files, err := readBackup(io.LimitReader(resp.Body, maxRemoteBackup))
+	if err != nil {
+		return remote, err
+	}
+	for name, v := range map[string]any{"albums.json": &remote.albums, "tracks.json": &remote.tracks, "playlists.json": &remote.playlists, "play-counts.json": &remote.playCounts} {
+		if data, ok := files[name]; ok {
+			if err := json.Unmarshal(data, v); err != nil {
+				return remote, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return remote, nil
+}
+
+// fillZero returns local with its zero fields taken from remote.
+func fillZero[T any](local, remote T) T {
+	lv, rv := reflect.ValueOf(&local).Elem(), reflect.ValueOf(remote)
+	for i := 0; i < lv.NumField(); i++ {
+		if lv.Field(i).IsZero() {
+			lv.Field(i).Set(rv.Field(i))
+		}
+	}
+	return local
+}
+
+// resolveConflict returns the version of a matched item to keep, and
+// whether it differs from local.
+func resolveConflict[T any](mode string, local, remote T) (T, bool) {
+	merged := local
+	switch mode {
+	case conflictOverwrite:
+		merged = remote
+	case conflictMerge:
+		merged = fillZero(local, remote)
+	}
+	return merged, !reflect.DeepEqual(merged, local)
+}
+
+func importAlbumKey(a album) string {
+	return strings.ToLower(a.Artist) + "\x00" + strings.ToLower(a.Title)
+}
+
+func importTrackKey(t track) string {
+	return t.AlbumID + "\x00" + strconv.Itoa(t.Disc) + "\x00" + strconv.Itoa(t.Number) + "\x00" + strings.ToLower(t.Title)
+}
+
+// importCatalog merges another instance's catalog into this one. Albums
+// match by id, or else by artist and title; tracks match by album, disc,
+// number and title, since track ids are only unique per instance. Unmatched
+// items are added, tracks and playlists under new ids. Local file paths
+// are always kept. Callers must hold albumsMu.
+func importCatalog(remote remoteCatalog, mode string, dryRun bool) remoteImportResult {
+	result := remoteImportResult{DryRun: dryRun}
+
+	albumIDs := map[string]string{}
+	// reslug maps the ids of added and changed albums to their previous
+	// slugs; slugs are only given out once the albums are in the catalog.
+	reslug := map[string]string{}
+	byKey := map[string]int{}
+	for i, a := range albums {
+		byKey[importAlbumKey(a)] = i
+	}
+	newAlbums := slices.Clone(albums)
+	for _, ra := range remote.albums {
+		i := findAlbum(ra.ID)
+		if i < 0 {
+			if j, ok := byKey[importAlbumKey(ra)]; ok {
+				i = j
+			}
+		}
+		if i < 0 {
+			ra.Slug, ra.Rating, ra.Blurhash = "", nil, ""
+			reslug[ra.ID] = ""
+			newAlbums = append(newAlbums, ra)
+			albumIDs[ra.ID] = ra.ID
+			result.Albums.Added++
+			continue
+		}
+		local := newAlbums[i]
+		albumIDs[ra.ID] = local.ID
+		ra.ID, ra.Slug, ra.Rating = local.ID, local.Slug, local.Rating
+		merged, changed := resolveConflict(mode, local, ra)
+		if mode == conflictMerge {
+			merged.Tags, _ = normalizeTags(append(slices.Clone(local.Tags), ra.Tags...))
+			changed = !reflect.DeepEqual(merged, local)
+		}
+		if !changed {
+			result.Albums.Skipped++
+			continue
+		}
+		reslug[merged.ID] = local.Slug
+		newAlbums[i] = merged
+		result.Albums.Updated++
+	}
+
+	trackIDs := map[string]string{}
+	trackByKey := map[string]int{}
+	for i, t := range tracks {
+		trackByKey[importTrackKey(t)] = i
+	}
+	newTracks := slices.Clone(tracks)
+	next := nextTrackID
+	for _, rt := range remote.tracks {
+		if id, ok := albumIDs[rt.AlbumID]; ok {
+			rt.AlbumID = id
+		}
+		i, ok := trackByKey[importTrackKey(rt)]
+		if !ok {
+			trackIDs[rt.ID] = strconv.Itoa(next)
+			rt.ID = strconv.Itoa(next)
+			next++
+			newTracks = append(newTracks, rt)
+			result.Tracks.Added++
+			continue
+		}
+		local := newTracks[i]
+		trackIDs[rt.ID] = local.ID
+		rt.ID, rt.Path = local.ID, local.Path
+		merged, changed := resolveConflict(mode, local, rt)
+		if !changed {
+			result.Tracks.Skipped++
+			continue
+		}
+		newTracks[i] = merged
+		result.Tracks.Updated++
+	}
+
+	newCounts := map[string]int{}
+	for id, n := range playCounts {
+		newCounts[id] = n
+	}
+	for remoteID, n := range remote.playCounts {
+		id, ok := trackIDs[remoteID]
+		if !ok {
+			continue
+		}
+		switch {
+		case newCounts[id] == 0:
+			newCounts[id] = n
+			result.PlayCounts.Added++
+		case mode == conflictOverwrite && newCounts[id] != n:
+			newCounts[id] = n
+			result.PlayCounts.Updated++
+		case mode == conflictMerge:
+			newCounts[id] += n
+			result.PlayCounts.Updated++
+		default:
+			result.PlayCounts.Skipped++
+		}
+	}
+
+	// Generated playlists are left out; each instance makes its own.
+	newPlaylists := slices.Clone(playlists)
+	nextPlaylist := nextPlaylistID
+	for _, rp := range remote.playlists {
+		if rp.Type == playlistGenerated {
+			continue
+		}
+		var ids []string
+		for _, id := range rp.TrackIDs {
+			if local, ok := trackIDs[id]; ok {
+				ids = append(ids, local)
+			}
+		}
+		rp.TrackIDs = ids
+		i := -1
+		for j, p := range newPlaylists {
+			if p.Owner == rp.Owner && p.Name == rp.Name && p.Type == rp.Type {
+				i = j
+			}
+		}
+		if i < 0 {
+			rp.ID = "smart-" + strconv.Itoa(nextPlaylist)
+			nextPlaylist++
+			newPlaylists = append(newPlaylists, rp)
+			result.Playlists.Added++
+			continue
+		}
+		local := newPlaylists[i]
+		rp.ID = local.ID
+		merged, changed := resolveConflict(mode, local, rp)
+		if !changed {
+			result.Playlists.Skipped++
+			continue
+		}
+		newPlaylists[i] = merged
+		result.Playlists.Updated++
+	}
+
+	if !dryRun {
+		albums, tracks, playCounts, playlists = newAlbums, newTracks, newCounts, newPlaylists
+		nextTrackID, nextPlaylistID = next, nextPlaylist
+		for id, previous := range reslug {
+			setAlbumSlug(&albums[findAlbum(id)], previous)
+		}
+		libStats = computeLibraryStats()
+	}
+	return result
+}
+
+// postRemoteImport copies the catalog, playlists and play counts of
+// another instance into this one, resolving conflicts with local items as
+// the request's conflict mode says. With dryRun it only reports what it
+// would do.
+func postRemoteImport(c *gin.Context) {
+	var input remoteImportRequest
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if !strings.HasPrefix(input.URL, "http://") && !strings.HasPrefix(input.URL, "https://") {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "url must be an http or https address"})
+		return
+	}
+	if input.Conflict == "" {
+		input.Conflict = conflictSkip
+	}
+	if input.Conflict != conflictSkip && input.Conflict != conflictOverwrite && input.Conflict != conflictMerge {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "conflict must be one of skip, overwrite or merge"})
+		return
+	}
+
+	remote, err := fetchRemoteCatalog(c.Request.Context(), input)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"message": "remote import: " + err.Error()})
+		return
+	}
+
+	albumsMu.Lock()
+	result := importCatalog(remote, input.Conflict, input.DryRun)
+	albumsMu.Unlock()
+
+	if !input.DryRun {
+		publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	}
+	c.IndentedJSON(http.StatusOK, result)
+}
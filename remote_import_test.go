@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoteImport(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// The remote instance has a new album, a retitled copy of a local one
+	// and a track of each, with plays and a playlist.
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99, Genre: "Jazz"},
+		{ID: "r-2", Title: "Kind of Blue", Artist: "Miles Davis", Price: 20},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Number: 1, Title: "Blue Train", Path: "/remote/01.flac"},
+		{ID: "2", AlbumID: "r-2", Number: 1, Title: "So What"},
+	}
+	playCounts = map[string]int{"1": 5, "2": 3}
+	playlists = []playlist{{ID: "smart-1", Name: "Jazz", Type: playlistSmart, Owner: "alice", Query: "genre:jazz"}}
+	var backup bytes.Buffer
+	if err := writeBackup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); r.URL.Path != "/admin/backup" || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(backup.Bytes())
+	}))
+	defer remote.Close()
+
+	reset := func() {
+		albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99}}
+		tracks = []track{{ID: "1", AlbumID: "1", Number: 1, Title: "Blue Train", Path: "/local/01.flac"}}
+		playCounts = map[string]int{"1": 2}
+		playlists = nil
+		nextTrackID, nextPlaylistID = 2, 1
+	}
+	importRemote := func(conflict string, dryRun bool) (int, remoteImportResult) {
+		body := `{"url": "` + remote.URL + `", "username": "admin", "password": "secret", "conflict": "` + conflict + `", "dryRun": ` + map[bool]string{true: "true", false: "false"}[dryRun] + `}`
+		rr := performRequest(router, "POST", "/admin/import/remote", strings.NewReader(body), "alice")
+		var result remoteImportResult
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr.Code, result
+	}
+
+	// Check a dry run changes nothing
+	reset()
+	code, result := importRemote("skip", true)
+	if code != http.StatusOK || result.Albums.Added != 1 || result.Albums.Skipped != 1 || len(albums) != 1 {
+		t.Fatalf("Expected a dry run adding 1 album, but got %d %+v", code, result)
+	}
+
+	// Check skip adds only what is new, under local ids
+	reset()
+	_, result = importRemote("skip", false)
+	if len(albums) != 2 || len(tracks) != 2 || albums[0].Genre != "" || playCounts["1"] != 2 {
+		t.Errorf("Expected local items kept, but got %+v", albums)
+	}
+	if i := findTrack("2"); i < 0 || tracks[i].AlbumID != "r-2" || playCounts["2"] != 3 || albums[1].Slug == "" {
+		t.Errorf("Expected the new track as 2 with its plays, but got %+v", tracks)
+	}
+	if len(playlists) != 1 || playlists[0].ID != "smart-1" || nextPlaylistID != 2 {
+		t.Errorf("Expected the playlist imported, but got %+v", playlists)
+	}
+
+	// Check merge fills in missing fields, adds up plays and keeps paths
+	reset()
+	importRemote("merge", false)
+	if albums[0].Genre != "Jazz" || playCounts["1"] != 7 || tracks[0].Path != "/local/01.flac" {
+		t.Errorf("Expected a merge, but got %+v %v", albums[0], playCounts)
+	}
+
+	// Check overwrite takes remote values
+	reset()
+	importRemote("overwrite", false)
+	if albums[0].Genre != "Jazz" || playCounts["1"] != 5 {
+		t.Errorf("Expected remote values, but got %+v %v", albums[0], playCounts)
+	}
+
+	if code, _ := importRemote("replace", false); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, code)
+	}
+	body := `{"url": "` + remote.URL + `", "username": "admin", "password": "wrong"}`
+	if rr := performRequest(router, "POST", "/admin/import/remote", strings.NewReader(body), "alice"); rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadGateway, rr.Code)
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// replicaStore is a secondary location the replication job mirrors the
+// library to.
+type replicaStore interface {
+	// Put stores size bytes read from r under key, and fails unless what
+	// was stored has the SHA-256 sum, given in hex.
+	Put(ctx context.Context, key string, r io.Reader, size int64, sum string) error
+	// Get reads what is stored under key, failing with os.ErrNotExist
+	// when there is nothing.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// String describes the location, without credentials.
+	String() string
+}
+
+// newReplicaStore returns the store for cfg.Replica, which is a directory
+// or s3://bucket/prefix.
+func newReplicaStore(cfg config) (replicaStore, error) {
+	if !strings.HasPrefix(cfg.Replica, "s3://") {
+		return dirReplica{dir: cfg.Replica}, nil
+	}
+	u, err := url.Parse(cfg.Replica)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("MUSIC_PLAYER_REPLICA: %q is not s3://bucket/prefix", cfg.Replica)
+	}
+	endpoint := cfg.ReplicaS3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.ReplicaS3Region + ".amazonaws.com"
+	}
+	if cfg.ReplicaS3AccessKey == "" || cfg.ReplicaS3SecretKey == "" {
+		return nil, errors.New("MUSIC_PLAYER_REPLICA: an S3 bucket needs MUSIC_PLAYER_REPLICA_S3_ACCESS_KEY and MUSIC_PLAYER_REPLICA_S3_SECRET_KEY")
+	}
+	return s3Replica{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		region:    cfg.ReplicaS3Region,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		accessKey: cfg.ReplicaS3AccessKey,
+		secretKey: cfg.ReplicaS3SecretKey,
+		client:    &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+// dirReplica mirrors to a directory, such as a second disk or a network
+// mount.
+type dirReplica struct {
+	dir string
+}
+
+func (d dirReplica) Put(ctx context.Context, key string, r io.Reader, size int64, sum string) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".replica-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); n != size || got != sum {
+		return fmt.Errorf("%s: copied %d bytes with checksum %s, expected %d bytes with %s", key, n, got, size, sum)
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d dirReplica) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, filepath.FromSlash(key)))
+}
+
+func (d dirReplica) String() string {
+	return d.dir
+}
+
+// s3Replica mirrors to an S3 bucket, or a service with the same API such
+// as MinIO, addressing the bucket in the path. S3 checks the SHA-256 sent
+// with each object and refuses it when the content does not match.
+type s3Replica struct {
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s s3Replica) Put(ctx context.Context, key string, r io.Reader, size int64, sum string) error {
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "PUT", key, r, size, map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(raw)})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.error(resp)
+	}
+	return nil
+}
+
+func (s s3Replica) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, "GET", key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	defer resp.Body.Close()
+	return nil, s.error(resp)
+}
+
+func (s s3Replica) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s s3Replica) error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: %s %s: %s", resp.Request.Method, resp.Request.URL.Path, bytes.TrimSpace(body))
+}
+
+// do sends a signed request for the object under key. The payload is left
+// unsigned; PUTs carry a checksum instead.
+func (s s3Replica) do(ctx context.Context, method, key string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	segments := []string{s.bucket}
+	if s.prefix != "" {
+		segments = append(segments, strings.Split(s.prefix, "/")...)
+	}
+	segments = append(segments, strings.Split(key, "/")...)
+	for i, seg := range segments {
+		segments[i] = awsEscape(seg)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+strings.Join(segments, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	s.sign(req, time.Now())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 to the request, covering its host,
+// Range and x-amz-* headers. x-amz-content-sha256 must already be set.
+func (s s3Replica) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); name == "range" || strings.HasPrefix(name, "x-amz-") {
+			signed[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, req.Header.Get("x-amz-content-sha256")}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	secret := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{now.Format("20060102"), s.region, "s3", "aws4_request"} {
+		secret = hmacSHA256(secret, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(secret, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, as AWS
+// signatures expect.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// replicatedFile is what the replica holds under a key.
+type replicatedFile struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type replicationFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+type replicationStatus struct {
+	Target       string     `json:"target"`
+	Running      bool       `json:"running"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastSuccess  *time.Time `json:"lastSuccess,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	// The counts are for the last run. Files are the track files to
+	// mirror, of which Copied were new or changed and Unchanged already
+	// on the replica. Verified were read back from the replica and
+	// matched, and Repaired did not and were copied again.
+	Files     int                  `json:"files"`
+	Copied    int                  `json:"copied"`
+	Unchanged int                  `json:"unchanged"`
+	Verified  int                  `json:"verified"`
+	Repaired  int                  `json:"repaired"`
+	Bytes     int64                `json:"bytes"`
+	Failed    []replicationFailure `json:"failed,omitempty"`
+}
+
+// Keys on the replica besides the track files under library/.
+const (
+	replicaManifestKey = "manifest.json"
+	replicaCatalogKey  = "catalog.tar.gz"
+)
+
+// replicator mirrors the files of tracks under root, and a backup of the
+// catalog, to a replica store. It keeps a manifest of what the replica
+// holds on the replica itself, so unchanged files are not copied again
+// after a restart.
+type replicator struct {
+	store replicaStore
+	root  string
+
+	mu       sync.Mutex
+	status   replicationStatus
+	manifest map[string]replicatedFile
+}
+
+// replication is nil unless MUSIC_PLAYER_REPLICA is set.
+var replication *replicator
+
+var errReplicationRunning = errors.New("replication is already running")
+
+func newReplicator(store replicaStore, root string) *replicator {
+	return &replicator{store: store, root: root, status: replicationStatus{Target: store.String()}}
+}
+
+// replicate is the scheduled replication task.
+func replicate(ctx context.Context) error {
+	if replication == nil {
+		return nil
+	}
+	return replication.run(ctx, false)
+}
+
+// replicaKey returns the key of the file at path on the replica: its path
+// under library/ for files under root, and under external/ for others.
+func (r *replicator) replicaKey(path string) string {
+	if rel, err := filepath.Rel(r.root, path); err == nil && r.root != "" && !strings.HasPrefix(rel, "..") {
+		return "library/" + filepath.ToSlash(rel)
+	}
+	return "external/" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+// run mirrors every track file that changed since the last run, then the
+// catalog and the manifest. With verify, files the manifest says are on
+// the replica are read back and checked, and copied again when they do not
+// match.
+func (r *replicator) run(ctx context.Context, verify bool) error {
+	r.mu.Lock()
+	if r.status.Running {
+		r.mu.Unlock()
+		return errReplicationRunning
+	}
+	r.status.Running = true
+	manifest := r.manifest
+	r.mu.Unlock()
+
+	start := time.Now().UTC()
+	status := replicationStatus{Target: r.store.String()}
+	err := r.mirror(ctx, verify, &manifest, &status)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.manifest = manifest
+	status.LastRun, status.LastSuccess = &start, r.status.LastSuccess
+	status.LastDuration = time.Since(start).String()
+	if err == nil && len(status.Failed) > 0 {
+		err = fmt.Errorf("replication: %d of %d files failed", len(status.Failed), status.Files)
+	}
+	if err == nil {
+		status.LastSuccess = &start
+	}
+	r.status = status
+	return err
+}
+
+func (r *replicator) mirror(ctx context.Context, verify bool, manifest *map[string]replicatedFile, status *replicationStatus) error {
+	if *manifest == nil {
+		m, err := r.loadManifest(ctx)
+		if err != nil {
+			return err
+		}
+		*manifest = m
+	}
+
+	albumsMu.RLock()
+	seen := map[string]bool{}
+	var paths []string
+	for _, t := range tracks {
+		if t.Path != "" && !seen[t.Path] {
+			seen[t.Path] = true
+			paths = append(paths, t.Path)
+		}
+	}
+	albumsMu.RUnlock()
+	sort.Strings(paths)
+
+	status.Files = len(paths)
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := r.replicaKey(path)
+		sum, size, err := sha256File(path)
+		if err != nil {
+			status.Failed = append(status.Failed, replicationFailure{Key: key, Error: err.Error()})
+			continue
+		}
+		stored, ok := (*manifest)[key]
+		if ok && stored.SHA256 == sum {
+			if !verify {
+				status.Unchanged++
+				continue
+			}
+			if err := r.verify(ctx, key, sum); err == nil {
+				status.Verified++
+				continue
+			}
+			status.Repaired++
+		}
+		if err := r.putFile(ctx, key, path, size, sum); err != nil {
+			status.Failed = append(status.Failed, replicationFailure{Key: key, Error: err.Error()})
+			continue
+		}
+		(*manifest)[key] = replicatedFile{SHA256: sum, Size: size}
+		status.Copied++
+		status.Bytes += size
+	}
+
+	var catalog bytes.Buffer
+	if err := writeBackup(&catalog); err != nil {
+		return err
+	}
+	if err := r.putBytes(ctx, replicaCatalogKey, catalog.Bytes()); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(*manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	return r.putBytes(ctx, replicaManifestKey, data)
+}
+
+func (r *replicator) loadManifest(ctx context.Context) (map[string]replicatedFile, error) {
+	manifest := map[string]replicatedFile{}
+	rc, err := r.store.Get(ctx, replicaManifestKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", replicaManifestKey, err)
+	}
+	return manifest, nil
+}
+
+// verify reads what the replica holds under key and checks it has the
+// checksum sum.
+func (r *replicator) verify(ctx context.Context, key, sum string) error {
+	rc, err := r.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("%s: checksum %s, expected %s", key, got, sum)
+	}
+	return nil
+}
+
+func (r *replicator) putFile(ctx context.Context, key, path string, size int64, sum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.store.Put(ctx, key, f, size, sum)
+}
+
+func (r *replicator) putBytes(ctx context.Context, key string, data []byte) error {
+	sum := sha256.Sum256(data)
+	return r.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+}
+
+func (r *replicator) currentStatus() replicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// getReplication reports how the last replication run went.
+func getReplication(c *gin.Context) {
+	if replication == nil {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "replication is not configured"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, replication.currentStatus())
+}
+
+// postReplication starts a replication run now rather than on schedule.
+// With ?verify=true every file on the replica is read back and checked.
+func postReplication(c *gin.Context) {
+	if replication == nil {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "replication is not configured"})
+		return
+	}
+	if replication.currentStatus().Running {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": errReplicationRunning.Error()})
+		return
+	}
+	verify := c.Query("verify") == "true"
+	r := replication
+	go func() {
+		if err := r.run(context.Background(), verify); err != nil {
+			log.Printf("replication: %v", err)
+		}
+	}()
+	c.Header("Location", "/admin/replication")
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": "replication started", "verify": verify})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// resumeTTL is how long a resume point is offered after the stream
+	// it belongs to was cut.
+	resumeTTL = 15 * time.Minute
+	// activeStreamKey holds the request's *activeStream in the gin context.
+	activeStreamKey = "activeStream"
+)
+
+// streamDrainGrace is set from configuration at startup: how long streams
+// may go on once shutdown starts before they are cut short and their
+// listeners told where to resume.
+var streamDrainGrace = 5 * time.Second
+
+var errStreamInterrupted = errors.New("stream interrupted by shutdown")
+
+// resumePoint tells a client where to pick up a stream that was cut short.
+type resumePoint struct {
+	TrackID string `json:"trackId"`
+	User    string `json:"user,omitempty"`
+	Offset  int64  `json:"offset"`
+	// Range and IfRange are the headers to resume with; with If-Range the
+	// whole file is sent again should it have changed in the meantime.
+	Range   string    `json:"range"`
+	IfRange string    `json:"ifRange,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// activeStream is a track being sent to a client.
+type activeStream struct {
+	trackID, user string
+	// start is the first byte of the requested range, or -1 when the
+	// request asked for several ranges, which cannot be resumed as one.
+	start int64
+	// offset is the next byte of the file to send, or -1 until the body
+	// starts. etag is set before offset.
+	offset atomic.Int64
+	etag   string
+	stop   chan struct{}
+}
+
+// stopped reports whether the stream should be cut short.
+func (s *activeStream) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// begin records where in the file the body starts: at the requested range
+// when the response is partial and at the start otherwise.
+func (s *activeStream) begin(status int, etag string) {
+	switch {
+	case status == http.StatusPartialContent && s.start >= 0:
+		s.etag = etag
+		s.offset.Store(s.start)
+	case status == http.StatusOK:
+		s.etag = etag
+		s.offset.Store(0)
+	}
+}
+
+// sent moves the offset on by n bytes once the body has started.
+func (s *activeStream) sent(n int64) {
+	if s.offset.Load() >= 0 {
+		s.offset.Add(n)
+	}
+}
+
+var (
+	activeStreams = struct {
+		sync.Mutex
+		m map[*activeStream]bool
+	}{m: map[*activeStream]bool{}}
+	resumePoints = struct {
+		sync.Mutex
+		byUser map[string][]resumePoint
+	}{byUser: map[string][]resumePoint{}}
+)
+
+// rangeStart returns the first byte a Range header asks for, 0 without one
+// and -1 when it cannot tell, such as for several ranges or a suffix.
+func rangeStart(header string) int64 {
+	if header == "" {
+		return 0
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return -1
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// startStream registers the request as streaming a track, so shutdown can
+// wait for it and tell the client where to resume should it have to cut
+// it short. The returned function unregisters it.
+func startStream(c *gin.Context, trackID string) func() {
+	s := &activeStream{trackID: trackID, user: c.GetString(gin.AuthUserKey), start: rangeStart(c.GetHeader("Range")), stop: make(chan struct{})}
+	s.offset.Store(-1)
+	c.Set(activeStreamKey, s)
+
+	// Picking the track up again uses up its resume point.
+	if s.user != "" {
+		resumePoints.Lock()
+		points := resumePoints.byUser[s.user]
+		for i, p := range points {
+			if p.TrackID == trackID {
+				resumePoints.byUser[s.user] = append(points[:i:i], points[i+1:]...)
+				break
+			}
+		}
+		resumePoints.Unlock()
+	}
+
+	activeStreams.Lock()
+	activeStreams.m[s] = true
+	activeStreams.Unlock()
+	return func() {
+		activeStreams.Lock()
+		delete(activeStreams.m, s)
+		activeStreams.Unlock()
+
+		// The copy has stopped, so the offset is exact, and the response is
+		// not over yet, so the event goes out before the client notices.
+		if offset := s.offset.Load(); s.stopped() && offset >= 0 {
+			point := resumePoint{TrackID: s.trackID, User: s.user, Offset: offset, Range: "bytes=" + strconv.FormatInt(offset, 10) + "-", IfRange: s.etag, At: time.Now().UTC()}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := publishEvent(ctx, topicStreamInterrupted, point); err != nil {
+				log.Printf("stream drain: %v", err)
+			}
+		}
+	}
+}
+
+// drainStreams runs when shutdown starts. It gives open streams up to grace
+// to finish, then cuts the rest short; each announces where it got to on
+// the stream.interrupted topic as it stops, so clients reconnect with a
+// Range request instead of restarting the track.
+func drainStreams(grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	for {
+		activeStreams.Lock()
+		n := len(activeStreams.m)
+		activeStreams.Unlock()
+		if n == 0 {
+			return
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(min(100*time.Millisecond, time.Until(deadline)))
+	}
+
+	activeStreams.Lock()
+	defer activeStreams.Unlock()
+
+	for s := range activeStreams.m {
+		if !s.stopped() {
+			close(s.stop)
+		}
+	}
+}
+
+// subscribeStreamEvents keeps the resume points announced by any instance,
+// so a client that reconnects elsewhere can ask for them.
+func subscribeStreamEvents(b eventBus) {
+	b.Subscribe(topicStreamInterrupted, func(e event) {
+		var point resumePoint
+		if err := json.Unmarshal(e.Payload, &point); err != nil {
+			log.Printf("bus: bad %s payload: %v", e.Topic, err)
+			return
+		}
+		if point.User == "" {
+			return
+		}
+
+		resumePoints.Lock()
+		defer resumePoints.Unlock()
+
+		points := resumePoints.byUser[point.User]
+		for i, p := range points {
+			if p.TrackID == point.TrackID {
+				points = append(points[:i:i], points[i+1:]...)
+				break
+			}
+		}
+		resumePoints.byUser[point.User] = append(points, point)
+	})
+}
+
+// getStreamResume lists where the user's streams that were cut short by a
+// restart can be picked up again, oldest first.
+func getStreamResume(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	resumePoints.Lock()
+	defer resumePoints.Unlock()
+
+	current := []resumePoint{}
+	for _, p := range resumePoints.byUser[user] {
+		if time.Since(p.At) < resumeTTL {
+			current = append(current, p)
+		}
+	}
+	resumePoints.byUser[user] = current
+	c.IndentedJSON(http.StatusOK, current)
+}
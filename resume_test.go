@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter takes a while over every write and reports the first.
+type slowWriter struct {
+	header  http.Header
+	status  int
+	mu      sync.Mutex
+	written int
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *slowWriter) Header() http.Header    { return w.header }
+func (w *slowWriter) WriteHeader(status int) { w.status = status }
+func (w *slowWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	time.Sleep(2 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written += len(b)
+	return len(b), nil
+}
+
+func TestRangeStart(t *testing.T) {
+	for header, want := range map[string]int64{"": 0, "bytes=100-": 100, "bytes=5-9": 5, "bytes=-500": -1, "bytes=0-1,5-6": -1, "items=1-2": -1} {
+		if got := rangeStart(header); got != want {
+			t.Errorf("Expected %q to start at %d, but got %d", header, want, got)
+		}
+	}
+}
+
+func TestDrainStreams(t *testing.T) {
+	data := mediaFile(t, 64*mediaBufferSize)
+	router := setupRouter(testConfig())
+	resumePoints.byUser = map[string][]resumePoint{}
+	b := newMemoryBus()
+	subscribeStreamEvents(b)
+	savedBus := bus
+	bus = b
+	t.Cleanup(func() { bus = savedBus })
+
+	// Check streams that finish within the grace period are left alone
+	drainStreams(time.Second)
+
+	// Check a stream still going is cut short and its resume point announced
+	req, _ := http.NewRequest("GET", "/tracks/1/stream", nil)
+	req.SetBasicAuth("bob", "bob-pw")
+	req.Header.Set("Range", "bytes=1000-")
+	w := &slowWriter{header: http.Header{}, started: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(done)
+	}()
+	<-w.started
+	drainStreams(10 * time.Millisecond)
+	<-done
+	if w.status != http.StatusPartialContent || w.written >= len(data)-1000 {
+		t.Fatalf("Expected a partial stream cut short, but got %d with %d bytes", w.status, w.written)
+	}
+
+	rr := performRequest(router, "GET", "/streams/resume", nil, "bob")
+	var points []resumePoint
+	json.Unmarshal(rr.Body.Bytes(), &points)
+	if len(points) != 1 || points[0].TrackID != "1" || points[0].Offset != int64(1000+w.written) || points[0].IfRange != w.header.Get("ETag") {
+		t.Fatalf("Expected a resume point at %d, but got %s", 1000+w.written, rr.Body)
+	}
+
+	// Check resuming with the hinted headers gets the rest, and uses the point up
+	req, _ = http.NewRequest("GET", "/tracks/1/stream", nil)
+	req.SetBasicAuth("bob", "bob-pw")
+	req.Header.Set("Range", points[0].Range)
+	req.Header.Set("If-Range", points[0].IfRange)
+	rest := &slowWriter{header: http.Header{}, started: make(chan struct{})}
+	router.ServeHTTP(rest, req)
+	if rest.status != http.StatusPartialContent || int64(rest.written) != int64(len(data))-points[0].Offset {
+		t.Errorf("Expected the remaining %d bytes, but got %d with %d", int64(len(data))-points[0].Offset, rest.status, rest.written)
+	}
+	rr = performRequest(router, "GET", "/streams/resume", nil, "bob")
+	if rr.Body.String() != "[]" {
+		t.Errorf("Expected no resume points left, but got %s", rr.Body)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type albumRating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+type review struct {
+	ID         string    `json:"id"`
+	AlbumID    string    `json:"albumId"`
+	User       string    `json:"user"`
+	Rating     int       `json:"rating"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Flagged    bool      `json:"flagged"`
+	FlagReason string    `json:"flagReason,omitempty"`
+}
+
+// reviews and nextReviewID are guarded by albumsMu, since reviews feed the
+// rating stored on each album.
+var (
+	reviews      []review
+	nextReviewID = 1
+)
+
+// findReview returns the index of the review with the given id, or -1.
+// Callers must hold albumsMu.
+func findReview(id string) int {
+	for i, r := range reviews {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateAlbumRating recomputes the rating of an album from its unflagged
+// reviews. Callers must hold albumsMu.
+func updateAlbumRating(albumID string) {
+	i := findAlbum(albumID)
+	if i < 0 {
+		return
+	}
+	sum, count := 0, 0
+	for _, r := range reviews {
+		if r.AlbumID == albumID && !r.Flagged {
+			sum += r.Rating
+			count++
+		}
+	}
+	albums[i].Rating = nil
+	if count > 0 {
+		albums[i].Rating = &albumRating{Average: float64(sum) / float64(count), Count: count}
+	}
+}
+
+// getAlbumReviews lists the visible reviews of an album, newest first.
+func getAlbumReviews(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if findAlbum(id) < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	visible := []review{}
+	for i := len(reviews) - 1; i >= 0; i-- {
+		if reviews[i].AlbumID == id && !reviews[i].Flagged {
+			visible = append(visible, reviews[i])
+		}
+	}
+	start, end := pageBounds(c, len(visible), limit, offset)
+	respondList(c, visible[start:end])
+}
+
+// postAlbumReview creates the caller's review of an album, or replaces it if
+// they already wrote one.
+func postAlbumReview(c *gin.Context) {
+	var input struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if input.Rating < 1 || input.Rating > 5 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "rating must be between 1 and 5"})
+		return
+	}
+	albumID := c.Param("id")
+	user := c.GetString(gin.AuthUserKey)
+	now := time.Now().UTC()
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if findAlbum(albumID) < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	status := http.StatusCreated
+	var r *review
+	for i := range reviews {
+		if reviews[i].AlbumID == albumID && reviews[i].User == user {
+			r = &reviews[i]
+			status = http.StatusOK
+			break
+		}
+	}
+	if r == nil {
+		reviews = append(reviews, review{ID: strconv.Itoa(nextReviewID), AlbumID: albumID, User: user, CreatedAt: now})
+		nextReviewID++
+		r = &reviews[len(reviews)-1]
+	}
+	r.Rating = input.Rating
+	r.Text = strings.TrimSpace(input.Text)
+	r.UpdatedAt = now
+	updateAlbumRating(albumID)
+
+	publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	c.IndentedJSON(status, *r)
+}
+
+// getFlaggedReviews lists reviews for moderation; ?flagged=false lists the
+// unflagged ones instead.
+func getFlaggedReviews(c *gin.Context) {
+	flagged := c.DefaultQuery("flagged", "true") == "true"
+	if canStream(c) {
+		streamList(c, catalogCursor(&reviews, func(r review) string { return r.ID }, func(r review) bool { return r.Flagged == flagged }))
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	matching := []review{}
+	for _, r := range reviews {
+		if r.Flagged == flagged {
+			matching = append(matching, r)
+		}
+	}
+	respondList(c, matching)
+}
+
+// putReviewFlag lets admins hide or restore a review. Flagged reviews are
+// not listed and do not count towards the album rating.
+func putReviewFlag(c *gin.Context) {
+	var input struct {
+		Flagged bool   `json:"flagged"`
+		Reason  string `json:"reason"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findReview(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "review not found"})
+		return
+	}
+	reviews[i].Flagged = input.Flagged
+	reviews[i].FlagReason = ""
+	if input.Flagged {
+		reviews[i].FlagReason = input.Reason
+	}
+	updateAlbumRating(reviews[i].AlbumID)
+
+	publishEvent(c.Request.Context(), topicCacheInvalidation, nil)
+	c.IndentedJSON(http.StatusOK, reviews[i])
+}
+
+func dumpReviews() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(reviews, "", "    ")
+}
+
+func restoreReviews(data []byte) (func(), int, error) {
+	var restored []review
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	next := 1
+	for _, r := range restored {
+		if n, err := strconv.Atoi(r.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		reviews = restored
+		nextReviewID = next
+		for _, a := range albums {
+			updateAlbumRating(a.ID)
+		}
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Reviews require a user and feed the album's average rating
+func TestPostAlbumReview(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Check anonymous reviews are refused
+	if rr := performRequest(router, "POST", "/albums/1/reviews", strings.NewReader(`{"rating": 5}`), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// Check out-of-range ratings are refused
+	if rr := performRequest(router, "POST", "/albums/1/reviews", strings.NewReader(`{"rating": 6}`), "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Review as two users, then have bob change his mind
+	performRequest(router, "POST", "/albums/1/reviews", strings.NewReader(`{"rating": 5, "text": "Essential"}`), "alice")
+	performRequest(router, "POST", "/albums/1/reviews", strings.NewReader(`{"rating": 4}`), "bob")
+	rr := performRequest(router, "POST", "/albums/1/reviews", strings.NewReader(`{"rating": 2}`), "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d for a replaced review, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check the album reports the average of the two current reviews
+	rr = performRequest(router, "GET", "/albums/1", nil, "")
+	var a album
+	json.Unmarshal(rr.Body.Bytes(), &a)
+	if a.Rating == nil || a.Rating.Count != 2 || a.Rating.Average != 3.5 {
+		t.Errorf("Expected average 3.5 over 2 reviews, but got %+v", a.Rating)
+	}
+}
+
+// Flagged reviews are hidden from listings and the average rating
+func TestFlaggedReviewsAreHidden(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Post two reviews
+	performRequest(router, "POST", "/albums/2/reviews", strings.NewReader(`{"rating": 5}`), "alice")
+	rr := performRequest(router, "POST", "/albums/2/reviews", strings.NewReader(`{"rating": 1, "text": "spam"}`), "bob")
+	var spam review
+	json.Unmarshal(rr.Body.Bytes(), &spam)
+
+	// Check only admins can flag
+	path := "/admin/reviews/" + spam.ID + "/flag"
+	if rr := performRequest(router, "PUT", path, strings.NewReader(`{"flagged": true}`), "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	performRequest(router, "PUT", path, strings.NewReader(`{"flagged": true, "reason": "spam"}`), "alice")
+
+	// Check the listing is paginated and excludes the flagged review
+	rr = performRequest(router, "GET", "/albums/2/reviews?limit=10", nil, "")
+	var listed []review
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].User != "alice" || rr.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("Expected only alice's review, but got %+v", listed)
+	}
+
+	// Check the rating only counts the visible review
+	if a := albums[findAlbum("2")]; a.Rating == nil || a.Rating.Count != 1 || a.Rating.Average != 5 {
+		t.Errorf("Expected average 5 over 1 review, but got %+v", a.Rating)
+	}
+}
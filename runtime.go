@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupLogging switches every log line, including request logs, to JSON on
+// stdout when format is "json". Text logs keep their default destinations.
+func setupLogging(format string) {
+	if format != "json" {
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	gin.SetMode(gin.ReleaseMode)
+	gin.DefaultWriter = os.Stdout
+	gin.DefaultErrorWriter = os.Stdout
+}
+
+// requestLogger returns the request logging middleware for format: gin's
+// own for text and one logging through slog for json.
+func requestLogger(format string) gin.HandlerFunc {
+	if format != "json" {
+		return gin.Logger()
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start).String(),
+			"ip", c.ClientIP(),
+		}
+		if user := c.GetString(gin.AuthUserKey); user != "" {
+			attrs = append(attrs, "user", user)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		slog.Info("request", attrs...)
+	}
+}
+
+// serve handles requests on listener until ctx is cancelled, then stops
+// accepting connections and gives in-flight requests, such as streams, up
+// to timeout to finish. Streams still open after streamDrainGrace are cut
+// short with a resume point; see drainStreams. Display event streams end
+// straight away.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler, timeout time.Duration) error {
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(func() { drainStreams(streamDrainGrace) })
+	server.RegisterOnShutdown(closeDisplays)
+	failed := make(chan error, 1)
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("shutting down, draining requests for up to %s", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
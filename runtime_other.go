@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func applyProcessSettings(cfg config) error {
+	if cfg.Umask >= 0 || cfg.UID >= 0 || cfg.GID >= 0 {
+		return errors.New("umask, uid and gid are only supported on Unix")
+	}
+	return nil
+}
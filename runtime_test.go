@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// In-flight requests finish when the server is shut down
+func TestServeDrainsRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, listener, handler, 5*time.Second) }()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- resp
+	}()
+	<-started
+	cancel()
+
+	// Check the request in flight completes and the server stops cleanly
+	if resp := <-responses; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the in-flight request to succeed, but got %v", resp)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected a clean shutdown, but got %v", err)
+	}
+}
+
+func TestRequestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	router := gin.New()
+	router.Use(requestLogger("json"))
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+	performRequest(router, "GET", "/ping", nil, "")
+
+	// Check one structured line is logged per request
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON log line, but got %q", buf.String())
+	}
+	if entry["msg"] != "request" || entry["path"] != "/ping" || entry["status"] != float64(http.StatusTeapot) {
+		t.Errorf("Expected the request to be logged, but got %v", entry)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// applyProcessSettings sets the umask and drops to the configured group and
+// user, so files written into mounted volumes get the host's ownership.
+func applyProcessSettings(cfg config) error {
+	if cfg.Umask >= 0 {
+		syscall.Umask(cfg.Umask)
+	}
+	if cfg.GID >= 0 {
+		if err := syscall.Setgroups([]int{cfg.GID}); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(cfg.GID); err != nil {
+			return fmt.Errorf("setgid %d: %w", cfg.GID, err)
+		}
+	}
+	if cfg.UID >= 0 {
+		if err := syscall.Setuid(cfg.UID); err != nil {
+			return fmt.Errorf("setuid %d: %w", cfg.UID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// playlistSmart marks playlists whose tracks are the results of a saved
+// query, worked out whenever the playlist is read.
+const playlistSmart = "smart"
+
+type savedSearch struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// savedSearches, nextSearchID and nextPlaylistID are guarded by albumsMu.
+var (
+	savedSearches  []savedSearch
+	nextSearchID   = 1
+	nextPlaylistID = 1
+)
+
+// findSavedSearch returns the index of the user's saved search with the
+// given id, or -1. Callers must hold albumsMu.
+func findSavedSearch(id, owner string) int {
+	for i, s := range savedSearches {
+		if s.ID == id && s.Owner == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+func getSavedSearches(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	owned := []savedSearch{}
+	for _, s := range savedSearches {
+		if s.Owner == user {
+			owned = append(owned, s)
+		}
+	}
+	respondList(c, owned)
+}
+
+func postSavedSearch(c *gin.Context) {
+	var input struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	input.Name, input.Query = strings.TrimSpace(input.Name), strings.TrimSpace(input.Query)
+	if input.Name == "" || input.Query == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "name and query are required"})
+		return
+	}
+	if _, err := parseQuery(input.Query, searchQueryKinds); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	s := savedSearch{
+		ID:        strconv.Itoa(nextSearchID),
+		Owner:     c.GetString(gin.AuthUserKey),
+		Name:      input.Name,
+		Query:     input.Query,
+		CreatedAt: time.Now().UTC(),
+	}
+	nextSearchID++
+	savedSearches = append(savedSearches, s)
+	c.IndentedJSON(http.StatusCreated, s)
+}
+
+func getSavedSearchById(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	if i := findSavedSearch(c.Param("id"), c.GetString(gin.AuthUserKey)); i >= 0 {
+		c.IndentedJSON(http.StatusOK, savedSearches[i])
+		return
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "saved search not found"})
+}
+
+func deleteSavedSearch(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findSavedSearch(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "saved search not found"})
+		return
+	}
+	savedSearches = append(savedSearches[:i], savedSearches[i+1:]...)
+	c.Status(http.StatusNoContent)
+}
+
+// getSavedSearchResults runs a saved search against the current library.
+func getSavedSearchResults(c *gin.Context) {
+	albumsMu.RLock()
+	i := findSavedSearch(c.Param("id"), c.GetString(gin.AuthUserKey))
+	var q string
+	if i >= 0 {
+		q = savedSearches[i].Query
+	}
+	albumsMu.RUnlock()
+
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "saved search not found"})
+		return
+	}
+	runSearch(c, q)
+}
+
+// postSearchPlaylist turns a saved search into a smart playlist, which
+// always holds the tracks the search currently finds.
+func postSearchPlaylist(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findSavedSearch(c.Param("id"), user)
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "saved search not found"})
+		return
+	}
+	p := playlist{
+		ID:        "smart-" + strconv.Itoa(nextPlaylistID),
+		Name:      savedSearches[i].Name,
+		Type:      playlistSmart,
+		Owner:     user,
+		Query:     savedSearches[i].Query,
+		UpdatedAt: time.Now().UTC(),
+	}
+	nextPlaylistID++
+	playlists = append(playlists, p)
+	c.IndentedJSON(http.StatusCreated, resolvePlaylist(p))
+}
+
+func dumpSavedSearches() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(savedSearches, "", "    ")
+}
+
+func restoreSavedSearches(data []byte) (func(), int, error) {
+	var restored []savedSearch
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	next := 1
+	for _, s := range restored {
+		if n, err := strconv.Atoi(s.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		savedSearches = restored
+		nextSearchID = next
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
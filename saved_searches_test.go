@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSavedSearches(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz"},
+		{ID: "2", Title: "Nevermind", Artist: "Nirvana", Genre: "Grunge"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane"},
+		{ID: "2", AlbumID: "2", Title: "Lithium", Artist: "Nirvana"},
+	}
+
+	// Check a search can be saved and run
+	rr := performRequest(router, "POST", "/searches", strings.NewReader(`{"name": "Trane", "query": "coltrane"}`), "bob")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusCreated, rr.Code)
+	}
+	var saved savedSearch
+	json.Unmarshal(rr.Body.Bytes(), &saved)
+	rr = performRequest(router, "GET", "/searches/"+saved.ID+"/results", nil, "bob")
+	var results searchResults
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results.Tracks) != 1 || results.Tracks[0].ID != "1" {
+		t.Errorf("Expected track 1, but got %+v", results.Tracks)
+	}
+
+	// Check other users cannot see or run it
+	for _, path := range []string{"/searches/" + saved.ID, "/searches/" + saved.ID + "/results"} {
+		rr = performRequest(router, "GET", path, nil, "alice")
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusNotFound, path, rr.Code)
+		}
+	}
+
+	// Check a smart playlist follows library changes
+	rr = performRequest(router, "POST", "/searches/"+saved.ID+"/playlist", nil, "bob")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusCreated, rr.Code)
+	}
+	var smart playlist
+	json.Unmarshal(rr.Body.Bytes(), &smart)
+	if smart.Type != playlistSmart || len(smart.TrackIDs) != 1 {
+		t.Errorf("Expected a smart playlist of one track, but got %+v", smart)
+	}
+	tracks = append(tracks, track{ID: "3", AlbumID: "1", Title: "Locomotion", Artist: "John Coltrane"})
+	rr = performRequest(router, "GET", "/playlists/"+smart.ID, nil, "bob")
+	smart = playlist{}
+	json.Unmarshal(rr.Body.Bytes(), &smart)
+	if len(smart.TrackIDs) != 2 {
+		t.Errorf("Expected the playlist to pick up the new track, but got %+v", smart)
+	}
+
+	// Check deleting the search keeps the playlist
+	rr = performRequest(router, "DELETE", "/searches/"+saved.ID, nil, "bob")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/searches", nil, "bob")
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected no saved searches, but got %s", rr.Body.String())
+	}
+	rr = performRequest(router, "GET", "/playlists/"+smart.ID, nil, "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check a search needs a name and a query that parses
+	for _, body := range []string{`{"name": "Empty"}`, `{"name": "Broken", "query": "year>="}`} {
+		rr = performRequest(router, "POST", "/searches", strings.NewReader(body), "bob")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// maintenanceTasks are the jobs the scheduler knows how to run, keyed by the
+// name used in configuration and in /admin/schedules.
+var maintenanceTasks = map[string]func(context.Context) error{
+	"cache-prune":      pruneCache,
+	"track-analysis":   analyzeTracks,
+	"trash-purge":      purgeExpiredTrash,
+	"daily-mix":        generateDailyMixes,
+	"id-migration":     migrateIDs,
+	"thumbnails":       generateThumbnails,
+	"listen-retention": pruneListens,
+	"price-watch":      watchPrices,
+	"new-releases":     checkNewReleases,
+	"concerts":         refreshConcerts,
+	"embeddings":       embedTracks,
+	"labels":           lookUpLabels,
+	"discogs-sync":     syncAllDiscogs,
+	"replication":      replicate,
+}
+
+// defaultSchedules holds the cron expression for each task when none is
+// configured. A schedule of "off" disables the task.
+var defaultSchedules = map[string]string{
+	"cache-prune":      "*/5 * * * *",
+	"track-analysis":   "*/15 * * * *",
+	"trash-purge":      "0 * * * *",
+	"daily-mix":        "0 4 * * *",
+	"id-migration":     "30 * * * *",
+	"thumbnails":       "45 * * * *",
+	"listen-retention": "15 3 * * *",
+	"price-watch":      "*/10 * * * *",
+	"new-releases":     "30 5 * * *",
+	"concerts":         "0 */6 * * *",
+	"embeddings":       "*/20 * * * *",
+	"labels":           "0 6 * * *",
+	"discogs-sync":     "30 6 * * *",
+	"replication":      "0 2 * * *",
+}
+
+// instanceTasks keep state each instance holds in its own memory, so they
+// run on every instance rather than on the leader only. Each works from the
+// instance's own catalog, trash and listens; id-migration derives new ids
+// from the old ones, so every instance agrees on them. Of the tasks only
+// the leader runs, track-analysis, embeddings, labels and thumbnails
+// publish the tracks and albums they change on the bus. What the others
+// record, such as price alerts, new releases, concerts and Discogs
+// collections, stays on the leader.
+var instanceTasks = map[string]bool{
+	"cache-prune":      true,
+	"trash-purge":      true,
+	"daily-mix":        true,
+	"id-migration":     true,
+	"listen-retention": true,
+}
+
+type taskStatus struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	NextRun      time.Time  `json:"nextRun"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	Runs         int        `json:"runs"`
+}
+
+type scheduledTask struct {
+	status        taskStatus
+	schedule      cron.Schedule
+	run           func(context.Context) error
+	everyInstance bool
+	running       bool
+}
+
+// scheduler runs maintenance tasks on cron schedules. Tasks only run on the
+// instance that currently holds leadership, except instanceTasks. Each task
+// runs in its own goroutine, so a slow task doesn't hold up the others, and
+// a task that is still running when it falls due again is skipped.
+type scheduler struct {
+	mu      sync.Mutex
+	tasks   map[string]*scheduledTask
+	running sync.WaitGroup
+}
+
+var tasks = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{tasks: make(map[string]*scheduledTask)}
+}
+
+// configure schedules every known task according to specs.
+func (s *scheduler) configure(specs map[string]string) error {
+	for name, spec := range specs {
+		if spec == "off" {
+			continue
+		}
+		run, ok := maintenanceTasks[name]
+		if !ok {
+			return fmt.Errorf("scheduler: unknown task %q", name)
+		}
+		if err := s.add(name, spec, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) add(name, spec string, run func(context.Context) error) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("scheduler: task %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks[name] = &scheduledTask{
+		status:        taskStatus{Name: name, Schedule: spec, NextRun: schedule.Next(time.Now())},
+		schedule:      schedule,
+		run:           run,
+		everyInstance: instanceTasks[name],
+	}
+	return nil
+}
+
+// Run wakes up every second to start due tasks until ctx is cancelled, then
+// waits for the tasks still running.
+func (s *scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.running.Wait()
+			return
+		case now := <-ticker.C:
+			s.runDue(ctx, now)
+		}
+	}
+}
+
+func (s *scheduler) runDue(ctx context.Context, now time.Time) {
+	leader := elector.IsLeader()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if now.Before(t.status.NextRun) {
+			continue
+		}
+		t.status.NextRun = t.schedule.Next(now)
+		if t.running || !(leader || t.everyInstance) {
+			continue
+		}
+		t.running = true
+		s.running.Add(1)
+		go func(t *scheduledTask) {
+			defer s.running.Done()
+			s.execute(ctx, t)
+		}(t)
+	}
+}
+
+func (s *scheduler) execute(ctx context.Context, t *scheduledTask) {
+	start := time.Now()
+	err := t.run(ctx)
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.running = false
+	t.status.LastRun = &start
+	t.status.LastDuration = elapsed.String()
+	t.status.Runs++
+	failing := t.status.LastError != ""
+	t.status.LastError = ""
+	if err != nil {
+		t.status.LastError = err.Error()
+		log.Printf("scheduler: task %s: %v", t.status.Name, err)
+		// Admins hear when a task starts failing, not on every run after.
+		if !failing {
+			notifyAdmins(notifyJobFailed, "Task "+t.status.Name+" failed", err.Error(), "/admin/schedules")
+		}
+	}
+}
+
+// runSoon moves a task's next run to the next tick, for work that should
+// follow an event rather than wait for its schedule. Unscheduled tasks are
+// ignored.
+func (s *scheduler) runSoon(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tasks[name]; ok {
+		t.status.NextRun = time.Now()
+	}
+}
+
+func (s *scheduler) statuses() []taskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]taskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		statuses = append(statuses, t.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func getSchedules(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"leader": elector.IsLeader(), "tasks": tasks.statuses()})
+}
+
+// pruneCache drops expired entries from the in-process cache. Redis expires
+// keys on its own, so there is nothing to do for the shared cache.
+func pruneCache(context.Context) error {
+	if m, ok := sharedCache.(*memoryCache); ok {
+		m.prune()
+	}
+	return nil
+}
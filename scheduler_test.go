@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Unknown tasks and bad cron expressions are rejected
+func TestSchedulerConfigureRejectsBadSchedules(t *testing.T) {
+	if err := newScheduler().configure(map[string]string{"no-such-task": "* * * * *"}); err == nil {
+		t.Errorf("Expected an error for an unknown task")
+	}
+	if err := newScheduler().configure(map[string]string{"cache-prune": "every tuesday"}); err == nil {
+		t.Errorf("Expected an error for a bad cron expression")
+	}
+	if err := newScheduler().configure(map[string]string{"cache-prune": "off"}); err != nil {
+		t.Errorf("Expected disabled tasks to be accepted, but got %s", err.Error())
+	}
+}
+
+// Due tasks run on the leader and record their last status
+func TestSchedulerRunsDueTasksOnLeader(t *testing.T) {
+	// Make this instance the leader
+	elector.campaign(context.Background())
+	if !elector.IsLeader() {
+		t.Fatalf("Expected this instance to be leader")
+	}
+
+	// Schedule a task that fails every minute
+	s := newScheduler()
+	runs := 0
+	s.add("failing", "* * * * *", func(context.Context) error {
+		runs++
+		return errors.New("disk full")
+	})
+
+	// Run the scheduler as if a minute had passed
+	s.runDue(context.Background(), time.Now().Add(time.Minute))
+	s.running.Wait()
+
+	// Check the task ran and the error was recorded
+	statuses := s.statuses()
+	if runs != 1 || statuses[0].Runs != 1 || statuses[0].LastError != "disk full" {
+		t.Errorf("Expected one failed run, but got runs=%d status=%+v", runs, statuses[0])
+	}
+}
+
+// Followers run the tasks that keep their own memory tidy, and leave the rest
+// to the leader
+func TestSchedulerRunsInstanceTasksOnFollowers(t *testing.T) {
+	was := elector.leader.Swap(false)
+	t.Cleanup(func() { elector.leader.Store(was) })
+
+	// Schedule a leader task and two instance tasks every minute
+	s := newScheduler()
+	var mu sync.Mutex
+	ran := map[string]int{}
+	for _, name := range []string{"track-analysis", "daily-mix", "id-migration"} {
+		name := name
+		s.add(name, "* * * * *", func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran[name]++
+			return nil
+		})
+	}
+
+	// Check only the instance tasks ran
+	s.runDue(context.Background(), time.Now().Add(time.Minute))
+	s.running.Wait()
+	if ran["daily-mix"] != 1 || ran["id-migration"] != 1 || ran["track-analysis"] != 0 {
+		t.Errorf("Expected only daily-mix and id-migration to run, but got %v", ran)
+	}
+}
+
+// A slow task neither holds up other tasks nor overlaps with itself
+func TestSchedulerRunsTasksConcurrently(t *testing.T) {
+	elector.campaign(context.Background())
+
+	// Schedule a task that runs until released and a quick one, every minute
+	s := newScheduler()
+	release, quick := make(chan struct{}), make(chan struct{}, 2)
+	var slowRuns atomic.Int32
+	s.add("slow", "* * * * *", func(context.Context) error {
+		slowRuns.Add(1)
+		select {
+		case <-release:
+			return nil
+		case <-time.After(2 * time.Second):
+			return errors.New("not released")
+		}
+	})
+	s.add("quick", "* * * * *", func(context.Context) error {
+		quick <- struct{}{}
+		return nil
+	})
+
+	// Check the quick task runs while the slow one is still running, twice
+	for minutes := 1; minutes <= 2; minutes++ {
+		s.runDue(context.Background(), time.Now().Add(time.Duration(minutes)*time.Minute))
+		select {
+		case <-quick:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the quick task to run in minute %d", minutes)
+		}
+	}
+
+	// Check the slow task was not started again while it was running
+	close(release)
+	s.running.Wait()
+	if n := slowRuns.Load(); n != 1 {
+		t.Errorf("Expected the slow task to run once, but got %d runs", n)
+	}
+	if statuses := s.statuses(); statuses[0].Runs != 2 || statuses[1].LastError != "" {
+		t.Errorf("Expected a released slow task and two quick runs, but got %+v", statuses)
+	}
+}
+
+// /admin/schedules is only available to admins
+func TestGetSchedulesRequiresAdmin(t *testing.T) {
+	router := setupRouter(testConfig())
+
+	// Check anonymous and non-admin users are turned away
+	if rr := performRequest(router, "GET", "/admin/schedules", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/admin/schedules", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// Check admins get the task list
+	rr := performRequest(router, "GET", "/admin/schedules", nil, "alice")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var response struct {
+		Tasks []taskStatus `json:"tasks"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Errorf("Failed to unmarshal response body: %s", err.Error())
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Scripts are small Lua programs admins write to act on hook events, such
+// as dimming the lights when a track by some artist plays. A script gets
+// the event's payload as the global table event and its name as
+// event_name. It runs in a sandbox: only the base, string, table and math
+// libraries are there, without the functions reading files, and it is
+// stopped after scriptTimeout. Besides log and json, it may call
+// http.request, but only for the hosts in MUSIC_PLAYER_SCRIPT_HOSTS.
+type script struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Event     string    `json:"event"`
+	Source    string    `json:"source"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Runs       int        `json:"runs"`
+	Failures   int        `json:"failures"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	LastOutput []string   `json:"lastOutput,omitempty"`
+}
+
+// scriptRun is what one run of a script did.
+type scriptRun struct {
+	Output   []string `json:"output"`
+	Requests int      `json:"requests"`
+	Error    string   `json:"error,omitempty"`
+	Duration float64  `json:"durationMs"`
+}
+
+const (
+	maxScriptSource   = 64 << 10
+	maxScriptOutput   = 100
+	maxScriptRequests = 10
+	// maxScriptString bounds the strings string.rep may build, and the
+	// response bodies http.request reads.
+	maxScriptString = 1 << 20
+)
+
+// scriptTimeout bounds each run of a script.
+const scriptTimeout = 5 * time.Second
+
+// scripts and scriptHosts, the hosts scripts may send HTTP requests to, are
+// guarded by scriptsMu.
+var (
+	scriptsMu    sync.RWMutex
+	scripts      []script
+	scriptHosts  []string
+	scriptClient = &http.Client{Timeout: 10 * time.Second, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if !scriptHostAllowed(req.URL) {
+			return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+		}
+		return nil
+	}}
+)
+
+func init() {
+	for event := range hookEvents {
+		event := event
+		addHook(event, func(payload any) { runScripts(event, payload) })
+	}
+}
+
+// findScript returns the index of the script with the given id, or -1.
+// Callers must hold scriptsMu.
+func findScript(id string) int {
+	for i, s := range scripts {
+		if s.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// runScripts runs the enabled scripts for event, one after the other, and
+// records how each went.
+func runScripts(event string, payload any) {
+	scriptsMu.RLock()
+	var due []script
+	for _, s := range scripts {
+		if s.Enabled && s.Event == event {
+			due = append(due, s)
+		}
+	}
+	scriptsMu.RUnlock()
+
+	for _, s := range due {
+		run := runScript(context.Background(), s.Source, event, payload, scriptTimeout)
+		now := time.Now().UTC()
+
+		scriptsMu.Lock()
+		if i := findScript(s.ID); i >= 0 {
+			scripts[i].Runs++
+			scripts[i].LastRun = &now
+			scripts[i].LastError, scripts[i].LastOutput = run.Error, run.Output
+			if run.Error != "" {
+				scripts[i].Failures++
+			}
+		}
+		scriptsMu.Unlock()
+	}
+}
+
+// compileScript reports whether source is valid Lua.
+func compileScript(source string) error {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	_, err := L.LoadString(source)
+	return err
+}
+
+// runScript runs source in a new sandbox with payload as its event, for
+// at most timeout.
+func runScript(ctx context.Context, source, event string, payload any, timeout time.Duration) scriptRun {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	run := scriptRun{Output: []string{}}
+	L := newSandbox(ctx, &run)
+	defer L.Close()
+
+	var value any
+	if raw, err := json.Marshal(payload); err == nil {
+		json.Unmarshal(raw, &value)
+	}
+	L.SetGlobal("event", toLua(L, value))
+	L.SetGlobal("event_name", lua.LString(event))
+	if err := L.DoString(source); err != nil {
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) && apiErr.Object != nil {
+			run.Error = apiErr.Object.String()
+		} else {
+			run.Error = err.Error()
+		}
+		if ctx.Err() != nil {
+			run.Error = "stopped after " + timeout.String()
+		}
+	}
+	run.Duration = float64(time.Since(start).Microseconds()) / 1000
+	return run
+}
+
+// newSandbox returns a Lua state with only the libraries scripts need.
+func newSandbox(ctx context.Context, run *scriptRun) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 200, RegistrySize: 1024, RegistryMaxSize: 64 << 10})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{{lua.BaseLibName, lua.OpenBase}, {lua.TabLibName, lua.OpenTable}, {lua.StringLibName, lua.OpenString}, {lua.MathLibName, lua.OpenMath}} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "_printregs", "collectgarbage"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetContext(ctx)
+
+	logf := L.NewFunction(func(L *lua.LState) int {
+		parts := make([]string, L.GetTop())
+		for i := range parts {
+			parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+		}
+		if len(run.Output) < maxScriptOutput {
+			run.Output = append(run.Output, strings.Join(parts, " "))
+		}
+		return 0
+	})
+	L.SetGlobal("log", logf)
+	L.SetGlobal("print", logf)
+
+	str := L.GetGlobal("string").(*lua.LTable)
+	rep := str.RawGetString("rep")
+	str.RawSetString("rep", L.NewFunction(func(L *lua.LState) int {
+		if n := L.CheckInt(2); n > 0 && len(L.CheckString(1)) > maxScriptString/n {
+			L.RaiseError("string.rep: result too long")
+		}
+		L.Push(rep)
+		L.Push(L.Get(1))
+		L.Push(L.Get(2))
+		L.Call(2, 1)
+		return 1
+	}))
+
+	L.SetGlobal("json", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			value, err := fromLua(L.CheckAny(1), 0)
+			if err != nil {
+				L.RaiseError("json.encode: %v", err)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				L.RaiseError("json.encode: %v", err)
+			}
+			L.Push(lua.LString(data))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var value any
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &value); err != nil {
+				L.RaiseError("json.decode: %v", err)
+			}
+			L.Push(toLua(L, value))
+			return 1
+		},
+	}))
+
+	L.SetGlobal("http", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"request": func(L *lua.LState) int {
+			status, body, err := scriptRequest(ctx, run, L.CheckTable(1))
+			if err != nil {
+				L.RaiseError("http.request: %v", err)
+			}
+			response := L.NewTable()
+			response.RawSetString("status", lua.LNumber(status))
+			response.RawSetString("body", lua.LString(body))
+			L.Push(response)
+			return 1
+		},
+	}))
+	return L
+}
+
+// scriptRequest sends the HTTP request a script described as {method,
+// url, headers, body}.
+func scriptRequest(ctx context.Context, run *scriptRun, spec *lua.LTable) (int, string, error) {
+	if run.Requests >= maxScriptRequests {
+		return 0, "", fmt.Errorf("no more than %d requests per run", maxScriptRequests)
+	}
+	u, err := url.Parse(lua.LVAsString(spec.RawGetString("url")))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0, "", errors.New("url must be http or https")
+	}
+	if !scriptHostAllowed(u) {
+		return 0, "", fmt.Errorf("host %s is not allowed", u.Host)
+	}
+	method := strings.ToUpper(lua.LVAsString(spec.RawGetString("method")))
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(lua.LVAsString(spec.RawGetString("body"))))
+	if err != nil {
+		return 0, "", err
+	}
+	if headers, ok := spec.RawGetString("headers").(*lua.LTable); ok {
+		headers.ForEach(func(k, v lua.LValue) { req.Header.Set(k.String(), v.String()) })
+	}
+
+	run.Requests++
+	resp, err := scriptClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, io.LimitReader(resp.Body, maxScriptString)); err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, body.String(), nil
+}
+
+// scriptHostAllowed reports whether u is on one of scriptHosts, given as
+// a name, or a name and port.
+func scriptHostAllowed(u *url.URL) bool {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+
+	for _, host := range scriptHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// toLua converts a decoded JSON value to Lua.
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []any:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case map[string]any:
+		t := L.CreateTable(0, len(v))
+		for key, item := range v {
+			t.RawSetString(key, toLua(L, item))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts a Lua value to one JSON can encode. Tables with a
+// sequence become arrays, other tables objects.
+func fromLua(value lua.LValue, depth int) (any, error) {
+	if depth > 32 {
+		return nil, errors.New("nested too deep")
+	}
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			items := make([]any, n)
+			for i := range items {
+				item, err := fromLua(v.RawGetInt(i+1), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				items[i] = item
+			}
+			return items, nil
+		}
+		object := map[string]any{}
+		var err error
+		v.ForEach(func(k, item lua.LValue) {
+			if err == nil {
+				object[k.String()], err = fromLua(item, depth+1)
+			}
+		})
+		return object, err
+	}
+	return nil, nil
+}
+
+// scriptInput is the body of requests creating or replacing a script.
+type scriptInput struct {
+	Name    string `json:"name"`
+	Event   string `json:"event"`
+	Source  string `json:"source"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// check trims the input and reports what is wrong with it.
+func (in *scriptInput) check() error {
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "" || in.Source == "":
+		return errors.New("name and source are required")
+	case !hookEvents[in.Event]:
+		return fmt.Errorf("unknown event %q", in.Event)
+	case len(in.Source) > maxScriptSource:
+		return fmt.Errorf("source is longer than %d bytes", maxScriptSource)
+	}
+	if err := compileScript(in.Source); err != nil {
+		return err
+	}
+	if in.Enabled == nil {
+		enabled := true
+		in.Enabled = &enabled
+	}
+	return nil
+}
+
+func getScripts(c *gin.Context) {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+
+	c.IndentedJSON(http.StatusOK, append([]script{}, scripts...))
+}
+
+func getScript(c *gin.Context) {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+
+	i := findScript(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "script not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, scripts[i])
+}
+
+func postScript(c *gin.Context) {
+	var input scriptInput
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if err := input.check(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+
+	now := time.Now().UTC()
+	s := script{ID: newID(), Name: input.Name, Event: input.Event, Source: input.Source, Enabled: *input.Enabled, CreatedAt: now, UpdatedAt: now}
+	scripts = append(scripts, s)
+	c.IndentedJSON(http.StatusCreated, s)
+}
+
+// putScript replaces a script. Its run counts are kept.
+func putScript(c *gin.Context) {
+	var input scriptInput
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if err := input.check(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+
+	i := findScript(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "script not found"})
+		return
+	}
+	s := &scripts[i]
+	s.Name, s.Event, s.Source, s.Enabled = input.Name, input.Event, input.Source, *input.Enabled
+	s.UpdatedAt = time.Now().UTC()
+	c.IndentedJSON(http.StatusOK, *s)
+}
+
+func deleteScript(c *gin.Context) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+
+	i := findScript(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "script not found"})
+		return
+	}
+	scripts = append(scripts[:i], scripts[i+1:]...)
+	c.Status(http.StatusNoContent)
+}
+
+// postScriptTest runs a script once with the event payload in the body,
+// whether or not it is enabled, without counting the run.
+func postScriptTest(c *gin.Context) {
+	var payload any
+
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&payload); err != nil {
+			return
+		}
+	}
+
+	scriptsMu.RLock()
+	i := findScript(c.Param("id"))
+	var s script
+	if i >= 0 {
+		s = scripts[i]
+	}
+	scriptsMu.RUnlock()
+
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "script not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, runScript(c.Request.Context(), s.Source, s.Event, payload, scriptTimeout))
+}
+
+func dumpScripts() ([]byte, error) {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+
+	return json.MarshalIndent(scripts, "", "    ")
+}
+
+func restoreScripts(data []byte) (func(), int, error) {
+	var restored []script
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		scriptsMu.Lock()
+		scripts = restored
+		scriptsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
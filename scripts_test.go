@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetScripts restores the scripts and their settings once the test
+// finishes. Hooks queued by earlier tests run first, so none sees the swap.
+func resetScripts(t *testing.T) {
+	flushHooks()
+	scriptsMu.Lock()
+	saved, savedHosts := scripts, scriptHosts
+	scripts = nil
+	scriptsMu.Unlock()
+	t.Cleanup(func() {
+		flushHooks()
+		scriptsMu.Lock()
+		scripts, scriptHosts = saved, savedHosts
+		scriptsMu.Unlock()
+	})
+}
+
+func TestScriptSandbox(t *testing.T) {
+	resetScripts(t)
+	ctx := context.Background()
+	timeout := 200 * time.Millisecond
+
+	// Check the payload is there, and json and log work
+	run := runScript(ctx, `log(event_name, event.track.title, json.encode({n = 1}), #json.decode("[1, 2]"))`, hookTrackPlayed, trackPlay{Track: track{Title: "So What"}}, timeout)
+	if run.Error != "" || len(run.Output) != 1 || run.Output[0] != `track.played So What {"n":1} 2` {
+		t.Errorf("Expected one line of output, but got %+v", run)
+	}
+
+	// Check scripts cannot reach files, the system or run forever
+	for source, want := range map[string]string{
+		`io.open("/etc/passwd")`:         "non-table object(nil) with key 'open'",
+		`os.execute("true")`:             "non-table object(nil) with key 'execute'",
+		`dofile("/etc/passwd")`:          "non-function",
+		`while true do end`:              "stopped after 200ms",
+		`local s = ("x"):rep(1e12)`:      "too long",
+		`http.request{url = "file:///"}`: "http or https",
+	} {
+		if run := runScript(ctx, source, hookScanComplete, nil, timeout); !strings.Contains(run.Error, want) {
+			t.Errorf("Expected %s to fail with %q, but got %+v", source, want, run)
+		}
+	}
+}
+
+func TestScriptRequests(t *testing.T) {
+	resetScripts(t)
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type")+" "+string(body))
+		mu.Unlock()
+		if r.URL.Path == "/elsewhere" {
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+	host, _ := url.Parse(server.URL)
+	source := `
+local r = http.request{method = "put", url = "` + server.URL + `/lights", headers = {["Content-Type"] = "application/json"}, body = json.encode({brightness = 20})}
+log(r.status, r.body)`
+
+	// Check requests go only to allowed hosts
+	if run := runScript(context.Background(), source, hookTrackPlayed, nil, scriptTimeout); !strings.Contains(run.Error, "not allowed") || run.Requests != 0 {
+		t.Errorf("Expected the host to be refused, but got %+v", run)
+	}
+	scriptsMu.Lock()
+	scriptHosts = []string{host.Host}
+	scriptsMu.Unlock()
+	run := runScript(context.Background(), source, hookTrackPlayed, nil, scriptTimeout)
+	if run.Error != "" || run.Requests != 1 || run.Output[0] != "200 ok" {
+		t.Errorf("Expected one request answered ok, but got %+v", run)
+	}
+	if len(received) != 1 || received[0] != `PUT /lights application/json {"brightness":20}` {
+		t.Errorf("Expected the lights to be dimmed, but got %q", received)
+	}
+
+	// Check redirects cannot leave the allowed hosts
+	run = runScript(context.Background(), `http.request{url = "`+server.URL+`/elsewhere"}`, hookTrackPlayed, nil, scriptTimeout)
+	if !strings.Contains(run.Error, "redirect to example.com is not allowed") {
+		t.Errorf("Expected the redirect to be refused, but got %+v", run)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	resetCatalog(t)
+	resetScripts(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Title: "So What", Artist: "Miles Davis"}, {ID: "2", Title: "Take Five", Artist: "Dave Brubeck"}}
+
+	// Check scripts must compile and follow a known event
+	for _, body := range []string{
+		`{"name": "Lights", "event": "track.played", "source": "if then"}`,
+		`{"name": "Lights", "event": "track.skipped", "source": "log(1)"}`,
+		`{"name": "", "event": "track.played", "source": "log(1)"}`,
+	} {
+		if rr := performRequest(router, "POST", "/admin/scripts", strings.NewReader(body), "alice"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+
+	// Check a script runs when its event happens
+	body := `{"name": "Lights", "event": "track.played", "source": "if event.track.artist == \"Miles Davis\" then log(\"dim for \" .. event.user) end"}`
+	rr := performRequest(router, "POST", "/admin/scripts", strings.NewReader(body), "alice")
+	var s script
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if rr.Code != http.StatusCreated || !s.Enabled {
+		t.Fatalf("Expected an enabled script, but got %d %s", rr.Code, rr.Body)
+	}
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1"}`), "bob")
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "2"}`), "bob")
+	flushHooks()
+	json.Unmarshal(performRequest(router, "GET", "/admin/scripts/"+s.ID, nil, "alice").Body.Bytes(), &s)
+	if s.Runs != 2 || s.Failures != 0 || s.LastRun == nil || len(s.LastOutput) != 0 {
+		t.Errorf("Expected 2 quiet runs, but got %+v", s)
+	}
+
+	// Check a test run uses the given payload
+	rr = performRequest(router, "POST", "/admin/scripts/"+s.ID+"/test", strings.NewReader(`{"user": "carol", "track": {"artist": "Miles Davis"}}`), "alice")
+	if !strings.Contains(rr.Body.String(), `"dim for carol"`) {
+		t.Errorf("Expected the lights dimmed for carol, but got %s", rr.Body)
+	}
+
+	// Check disabled scripts do not run, and failures are recorded
+	body = `{"name": "Lights", "event": "track.played", "source": "error(\"bulb missing\")", "enabled": false}`
+	performRequest(router, "PUT", "/admin/scripts/"+s.ID, strings.NewReader(body), "alice")
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1"}`), "bob")
+	flushHooks()
+	body = `{"name": "Lights", "event": "track.played", "source": "error(\"bulb missing\")"}`
+	performRequest(router, "PUT", "/admin/scripts/"+s.ID, strings.NewReader(body), "alice")
+	performRequest(router, "POST", "/listens", strings.NewReader(`{"trackId": "1"}`), "bob")
+	flushHooks()
+	json.Unmarshal(performRequest(router, "GET", "/admin/scripts/"+s.ID, nil, "alice").Body.Bytes(), &s)
+	if s.Runs != 3 || s.Failures != 1 || !strings.Contains(s.LastError, "bulb missing") {
+		t.Errorf("Expected 3 runs with 1 failure, but got %+v", s)
+	}
+
+	// Check only admins manage scripts, and scripts can be deleted
+	if rr := performRequest(router, "GET", "/admin/scripts", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	performRequest(router, "DELETE", "/admin/scripts/"+s.ID, nil, "alice")
+	if rr := performRequest(router, "GET", "/admin/scripts", nil, "alice"); rr.Body.String() != "[]" {
+		t.Errorf("Expected no scripts, but got %s", rr.Body)
+	}
+}
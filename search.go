@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type searchResults struct {
+	Albums      []album `json:"albums"`
+	Tracks      []track `json:"tracks"`
+	TotalAlbums int     `json:"totalAlbums"`
+	TotalTracks int     `json:"totalTracks"`
+}
+
+// searchQueryKinds holds the fields of both albums and tracks, so a search
+// may name either; a field only one of them has matches nothing in the
+// other.
+var searchQueryKinds = func() map[string]bool {
+	numeric := queryFieldKinds(albumQueryFields)
+	for name, number := range queryFieldKinds(trackQueryFields) {
+		numeric[name] = number
+	}
+	return numeric
+}()
+
+// searchLibrary returns the albums and tracks matching the query q, in
+// catalog order. Callers must hold albumsMu.
+func searchLibrary(q string) ([]album, []track, error) {
+	n, err := parseQuery(q, searchQueryKinds)
+	if err != nil {
+		return nil, nil, err
+	}
+	albumMatch := compileQuery(n, albumQueryFields, albumQueryDefaults)
+	trackMatch := compileQuery(n, trackQueryFields, trackQueryDefaults)
+
+	matchedAlbums := []album{}
+	for _, a := range albums {
+		if albumMatch(a) {
+			matchedAlbums = append(matchedAlbums, a)
+		}
+	}
+	matchedTracks := []track{}
+	for _, t := range tracks {
+		if trackMatch(t) {
+			matchedTracks = append(matchedTracks, t)
+		}
+	}
+	return matchedAlbums, matchedTracks, nil
+}
+
+// searchPage finds one page of the results of q; ?limit and ?offset apply
+// to albums and tracks separately. It responds itself when it fails.
+func searchPage(c *gin.Context, q string) (searchResults, bool) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return searchResults{}, false
+	}
+
+	albumsMu.RLock()
+	matchedAlbums, matchedTracks, err := searchLibrary(q)
+	albumsMu.RUnlock()
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return searchResults{}, false
+	}
+
+	results := searchResults{TotalAlbums: len(matchedAlbums), TotalTracks: len(matchedTracks)}
+	start, end := min(offset, len(matchedAlbums)), min(offset+limit, len(matchedAlbums))
+	results.Albums = matchedAlbums[start:end]
+	start, end = min(offset, len(matchedTracks)), min(offset+limit, len(matchedTracks))
+	results.Tracks = matchedTracks[start:end]
+	return results, true
+}
+
+// runSearch responds with one page of the results of q.
+func runSearch(c *gin.Context, q string) {
+	if results, ok := searchPage(c, q); ok {
+		c.IndentedJSON(http.StatusOK, results)
+	}
+}
+
+// getSearch finds albums and tracks matching the query ?q; bare words are
+// looked for in their names.
+func getSearch(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "q is required"})
+		return
+	}
+	runSearch(c, q)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Genre: "Jazz"},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane", Genre: "Jazz"},
+		{ID: "3", Title: "Nevermind", Artist: "Nirvana", Genre: "Grunge"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Moment's Notice", Artist: "John Coltrane"},
+		{ID: "2", AlbumID: "2", Title: "Naima", Artist: "John Coltrane"},
+		{ID: "3", AlbumID: "3", Title: "Lithium", Artist: "Nirvana"},
+	}
+
+	// Check every word must match, in any field and any case
+	rr := performRequest(router, "GET", "/search?q=coltrane+TRAIN", nil, "")
+	var results searchResults
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if results.TotalAlbums != 1 || results.Albums[0].ID != "1" {
+		t.Errorf("Expected album 1, but got %+v", results.Albums)
+	}
+	if results.TotalTracks != 1 || results.Tracks[0].ID != "1" {
+		t.Errorf("Expected track 1 through its album title, but got %+v", results.Tracks)
+	}
+
+	// Check limit and offset page albums and tracks separately
+	rr = performRequest(router, "GET", "/search?q=coltrane&limit=1&offset=1", nil, "")
+	results = searchResults{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if results.TotalAlbums != 2 || len(results.Albums) != 1 || results.Albums[0].ID != "2" || len(results.Tracks) != 1 || results.Tracks[0].ID != "2" {
+		t.Errorf("Expected the second album and track, but got %+v", results)
+	}
+
+	// Check an empty query is rejected
+	rr = performRequest(router, "GET", "/search?q=+", nil, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
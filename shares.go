@@ -0,0 +1,606 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	shareAlbum    = "album"
+	sharePlaylist = "playlist"
+)
+
+// share is a public, read-only link to an album or playlist.
+type share struct {
+	// ID is the secret part of the link.
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	TargetID string `json:"targetId"`
+	Owner    string `json:"owner"`
+	// PasswordHash is the bcrypt hash of the share's password, or "" when
+	// it has none. Shares made before bcrypt have a Salt and a salted
+	// SHA-256 instead, replaced the next time the password is given.
+	Salt         string     `json:"salt,omitempty"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	// OpenedAt is when someone other than the owner first opened the link.
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// shareView is a share as its owner sees it.
+type shareView struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	TargetID  string     `json:"targetId"`
+	URL       string     `json:"url"`
+	Protected bool       `json:"protected"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	OpenedAt  *time.Time `json:"openedAt,omitempty"`
+}
+
+// sharedTrack is what a share shows of a track; file paths stay private.
+type sharedTrack struct {
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Artist    string  `json:"artist,omitempty"`
+	Disc      int     `json:"disc,omitempty"`
+	Number    int     `json:"number,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	StreamURL string  `json:"streamUrl"`
+}
+
+// sharedContent is what a share link shows.
+type sharedContent struct {
+	Kind        string        `json:"kind"`
+	Title       string        `json:"title"`
+	Artist      string        `json:"artist,omitempty"`
+	Description string        `json:"description,omitempty"`
+	CoverURL    string        `json:"coverUrl,omitempty"`
+	Tracks      []sharedTrack `json:"tracks"`
+}
+
+// shares is guarded by albumsMu. publicURL is set from configuration at
+// startup; without it, links are made from the request's host.
+var (
+	shares    []share
+	publicURL string
+)
+
+// absoluteURL returns path on this server as an absolute URL, as link
+// previews need.
+func absoluteURL(c *gin.Context, path string) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/") + path
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + path
+}
+
+func (s share) expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+func (s share) view(c *gin.Context) shareView {
+	return shareView{ID: s.ID, Kind: s.Kind, TargetID: s.TargetID, URL: absoluteURL(c, "/s/"+s.ID), Protected: s.PasswordHash != "", ExpiresAt: s.ExpiresAt, CreatedAt: s.CreatedAt, OpenedAt: s.OpenedAt}
+}
+
+const (
+	// Wrong share passwords are counted per share and per client. Once
+	// either has had its fill within sharePasswordWindow, no more are
+	// checked until the window has passed.
+	maxShareFailuresPerShare  = 10
+	maxShareFailuresPerClient = 30
+	sharePasswordWindow       = 15 * time.Minute
+)
+
+var errSharePasswordThrottled = errors.New("too many wrong passwords; try again later")
+
+func hashSharePassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// legacySharePasswordHash is how passwords were hashed before bcrypt.
+func legacySharePasswordHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + "\n" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s share) checkPassword(password string) bool {
+	if s.Salt != "" {
+		return subtle.ConstantTimeCompare([]byte(legacySharePasswordHash(s.Salt, password)), []byte(s.PasswordHash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(s.PasswordHash), []byte(password)) == nil
+}
+
+// checkSharePassword checks a password given for s, unless the share or
+// the client has had too many wrong ones lately. A legacy hash is replaced
+// with a bcrypt one once the right password is given, which updates s.
+func checkSharePassword(c *gin.Context, s *share, password string) (bool, error) {
+	ctx := c.Request.Context()
+	counters := []struct {
+		key   string
+		limit int64
+	}{
+		{"sharepw:share:" + s.ID, maxShareFailuresPerShare},
+		{"sharepw:client:" + c.ClientIP(), maxShareFailuresPerClient},
+	}
+	for _, counter := range counters {
+		if v, ok, err := sharedCache.Get(ctx, counter.key); err == nil && ok {
+			if n, _ := strconv.ParseInt(string(v), 10, 64); n >= counter.limit {
+				return false, errSharePasswordThrottled
+			}
+		}
+	}
+
+	if !s.checkPassword(password) {
+		for _, counter := range counters {
+			sharedCache.Incr(ctx, counter.key, sharePasswordWindow)
+		}
+		return false, nil
+	}
+	if s.Salt != "" {
+		if hash, err := hashSharePassword(password); err == nil {
+			albumsMu.Lock()
+			if i := findShare(s.ID, time.Now()); i >= 0 {
+				shares[i].Salt, shares[i].PasswordHash = "", hash
+				*s = shares[i]
+			}
+			albumsMu.Unlock()
+		}
+	}
+	return true, nil
+}
+
+// respondShareThrottled refuses a password while checkSharePassword is
+// not taking any.
+func respondShareThrottled(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(int(sharePasswordWindow.Seconds())))
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"message": errSharePasswordThrottled.Error()})
+		return
+	}
+	c.Data(http.StatusTooManyRequests, "text/plain; charset=utf-8", []byte("Too many wrong passwords. Try again later.\n"))
+}
+
+// unlockToken is the cookie value that shows the share's password was
+// given. It changes with the password.
+func (s share) unlockToken() string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("share\n" + s.ID + "\n" + s.PasswordHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unlocked reports whether the request may see a share without giving a
+// password: it has none, or the request carries the cookie given for it.
+func (s share) unlocked(c *gin.Context) bool {
+	if s.PasswordHash == "" {
+		return true
+	}
+	cookie, err := c.Cookie("share_" + s.ID)
+	return err == nil && hmac.Equal([]byte(cookie), []byte(s.unlockToken()))
+}
+
+// findShare returns the index of the share with the given id that has not
+// expired, or -1. Callers must hold albumsMu.
+func findShare(id string, now time.Time) int {
+	for i, s := range shares {
+		if s.ID == id && !s.expired(now) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sharedContentOf returns what a share shows, or false when what it shares
+// is gone. Its stream and cover URLs are signed, see signedShareURL.
+// Callers must hold albumsMu.
+func sharedContentOf(c *gin.Context, s share) (sharedContent, bool) {
+	content := sharedContent{Kind: s.Kind, Tracks: []sharedTrack{}}
+	expires := time.Now().Add(shareMediaTTL)
+	var ts []track
+	switch s.Kind {
+	case shareAlbum:
+		i := findAlbum(s.TargetID)
+		if i < 0 {
+			return content, false
+		}
+		a := albums[i]
+		content.Title, content.Artist = a.Title, a.Artist
+		if albumCoverFile(a.ID) != "" {
+			cover := signedShareURL(s.ID, "/cover", expires)
+			if thumbnailDir != "" {
+				cover += "&size=600"
+			}
+			content.CoverURL = absoluteURL(c, cover)
+		}
+		for _, t := range tracks {
+			if t.AlbumID == a.ID {
+				ts = append(ts, t)
+			}
+		}
+		sortTracks(ts)
+	case sharePlaylist:
+		i := findPlaylist(s.TargetID)
+		if i < 0 || playlists[i].Owner != s.Owner {
+			return content, false
+		}
+		p := resolvePlaylist(playlists[i])
+		content.Title, content.Description = p.Name, p.Description
+		for _, id := range p.TrackIDs {
+			if j := findTrack(id); j >= 0 {
+				ts = append(ts, tracks[j])
+			}
+		}
+	}
+	for _, t := range ts {
+		content.Tracks = append(content.Tracks, sharedTrack{ID: t.ID, Title: t.Title, Artist: t.Artist, Disc: t.Disc, Number: t.Number, Duration: t.Duration, StreamURL: absoluteURL(c, signedShareURL(s.ID, "/tracks/"+t.ID+"/stream", expires))})
+	}
+	return content, true
+}
+
+// shareMediaTTL is how long the stream and cover URLs of a share stay
+// valid, as for the embeddable player.
+const shareMediaTTL = embedStreamTTL
+
+func shareMediaSignature(shareID, path string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("share-media\n" + shareID + "\n" + path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedShareURL returns the URL of path under a share, which serves it
+// until expires to whoever was shown the share. Guests get it too, even for
+// albums outside the public subset: sharing them is the point.
+func signedShareURL(shareID, path string, expires time.Time) string {
+	unix := expires.Unix()
+	return "/s/" + shareID + path + "?expires=" + strconv.FormatInt(unix, 10) + "&sig=" + shareMediaSignature(shareID, path, unix)
+}
+
+// checkShareMedia answers the request unless its URL was signed for path
+// under a share that is still there, which it returns.
+func checkShareMedia(c *gin.Context, path string) (share, bool) {
+	id := c.Param("token")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("sig")), []byte(shareMediaSignature(id, path, expires))) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": "invalid signature"})
+		return share{}, false
+	}
+	if time.Now().Unix() > expires {
+		c.IndentedJSON(http.StatusGone, gin.H{"message": "link has expired; open the share again"})
+		return share{}, false
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findShare(id, time.Now())
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "share not found or expired"})
+		return share{}, false
+	}
+	return shares[i], true
+}
+
+// getSharedTrackStream streams a track of a share.
+func getSharedTrackStream(c *gin.Context) {
+	id := c.Param("trackId")
+	if _, ok := checkShareMedia(c, "/tracks/"+id+"/stream"); !ok {
+		return
+	}
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: id})
+	getTrackStream(c)
+}
+
+// getSharedCover serves the cover of a shared album.
+func getSharedCover(c *gin.Context) {
+	s, ok := checkShareMedia(c, "/cover")
+	if !ok {
+		return
+	}
+	if s.Kind != shareAlbum {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "share has no cover"})
+		return
+	}
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: s.TargetID})
+	getAlbumCover(c)
+}
+
+// findPlaylist returns the index of the playlist with the given id, or -1.
+// Callers must hold albumsMu.
+func findPlaylist(id string) int {
+	for i, p := range playlists {
+		if p.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// postShare makes a public link to an album, or to one of the user's
+// playlists, optionally with a password and an expiry.
+func postShare(c *gin.Context) {
+	var input struct {
+		Kind      string     `json:"kind"`
+		ID        string     `json:"id"`
+		Password  string     `json:"password"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	now := time.Now()
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "expiresAt must be in the future"})
+		return
+	}
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	switch input.Kind {
+	case shareAlbum:
+		if findAlbum(input.ID) < 0 {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+			return
+		}
+	case sharePlaylist:
+		if i := findPlaylist(input.ID); i < 0 || playlists[i].Owner != user {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "playlist not found"})
+			return
+		}
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "kind must be album or playlist"})
+		return
+	}
+
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	s := share{ID: hex.EncodeToString(raw), Kind: input.Kind, TargetID: input.ID, Owner: user, CreatedAt: now.UTC()}
+	if input.ExpiresAt != nil {
+		expires := input.ExpiresAt.UTC()
+		s.ExpiresAt = &expires
+	}
+	if input.Password != "" {
+		hash, err := hashSharePassword(input.Password)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "password must be at most 72 bytes"})
+			return
+		}
+		s.PasswordHash = hash
+	}
+	shares = append(shares, s)
+	c.IndentedJSON(http.StatusCreated, s.view(c))
+}
+
+// getShares lists the user's shares that have not expired.
+func getShares(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	now := time.Now()
+	own := []shareView{}
+	for _, s := range shares {
+		if s.Owner == user && !s.expired(now) {
+			own = append(own, s.view(c))
+		}
+	}
+	respondList(c, own)
+}
+
+// deleteShare revokes one of the user's shares.
+func deleteShare(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	for i, s := range shares {
+		if s.ID == c.Param("id") && s.Owner == user {
+			shares = append(shares[:i], shares[i+1:]...)
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "share not found"})
+}
+
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:type" content="{{.Type}}">
+<meta property="og:url" content="{{.URL}}">
+{{with .Description}}<meta property="og:description" content="{{.}}">
+{{end}}{{with .Image}}<meta property="og:image" content="{{.}}">
+{{end}}<meta name="robots" content="noindex">
+<style>body{font-family:sans-serif;max-width:40em;margin:2em auto;padding:0 1em}img{max-width:100%}li{margin:.5em 0}audio{display:block;width:100%}</style>
+</head>
+<body>
+{{if .Locked}}<h1>This link is password protected</h1>
+<form method="post">{{if .WrongPassword}}<p>That password is not right.</p>{{end}}
+<input type="password" name="password" autofocus required> <button>Open</button>
+</form>
+{{else}}{{with .Content}}{{with .CoverURL}}<img src="{{.}}" alt="">
+{{end}}<h1>{{.Title}}</h1>
+{{with .Artist}}<p>{{.}}</p>
+{{end}}{{with .Description}}<p>{{.}}</p>
+{{end}}<ol>
+{{range .Tracks}}<li>{{.Title}}{{with .Artist}} – {{.}}{{end}}<audio controls preload="none" src="{{.StreamURL}}"></audio></li>
+{{end}}</ol>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// sharePageData fills in sharePage.
+type sharePageData struct {
+	Title, Type, URL, Description, Image string
+	Locked, WrongPassword                bool
+	Content                              sharedContent
+}
+
+// renderShare answers a share link: JSON when the client asks for it and
+// an HTML page otherwise. A locked share's page asks for the password and
+// gives link previews nothing but that it is shared.
+func renderShare(c *gin.Context, wrongPassword bool) {
+	now := time.Now()
+
+	albumsMu.RLock()
+	i := findShare(c.Param("token"), now)
+	var s share
+	var content sharedContent
+	found := i >= 0
+	if found {
+		s = shares[i]
+		content, found = sharedContentOf(c, s)
+	}
+	albumsMu.RUnlock()
+
+	wantsJSON := c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+	if !found {
+		if wantsJSON {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "share not found or expired"})
+			return
+		}
+		c.Data(http.StatusNotFound, "text/plain; charset=utf-8", []byte("This link does not exist or has expired.\n"))
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	locked := !s.unlocked(c)
+	if password := c.GetHeader("X-Share-Password"); locked && password != "" {
+		ok, err := checkSharePassword(c, &s, password)
+		if err != nil {
+			respondShareThrottled(c)
+			return
+		}
+		locked = !ok
+	}
+	if !locked && s.OpenedAt == nil && c.GetString(gin.AuthUserKey) != s.Owner {
+		markShareOpened(s, content.Title)
+	}
+	if wantsJSON {
+		if locked {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": "this share needs a password; send it in X-Share-Password"})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, content)
+		return
+	}
+
+	data := sharePageData{Title: "Shared " + s.Kind, Type: "music." + s.Kind, URL: absoluteURL(c, "/s/"+s.ID), Locked: locked, WrongPassword: wrongPassword}
+	status := http.StatusOK
+	if locked {
+		if wrongPassword {
+			status = http.StatusUnauthorized
+		}
+	} else {
+		data.Content = content
+		data.Title, data.Description, data.Image = content.Title, content.Artist, content.CoverURL
+		if content.Description != "" {
+			data.Description = content.Description
+		}
+	}
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	sharePage.Execute(c.Writer, data)
+}
+
+// markShareOpened records that a share was first opened and tells its
+// owner.
+func markShareOpened(s share, title string) {
+	albumsMu.Lock()
+	i := findShare(s.ID, time.Now())
+	first := i >= 0 && shares[i].OpenedAt == nil
+	if first {
+		now := time.Now().UTC()
+		shares[i].OpenedAt = &now
+	}
+	albumsMu.Unlock()
+
+	if first {
+		notifyLater(notification{User: s.Owner, Kind: notifyShareOpened, Title: "Your shared " + s.Kind + " was opened", Body: "Someone opened your link to " + title + ".", Link: "/shares"})
+	}
+}
+
+// getSharedItem shows what a share link points to.
+func getSharedItem(c *gin.Context) {
+	renderShare(c, false)
+}
+
+// postSharePassword unlocks a password protected share for the browser
+// with a cookie scoped to the link, then shows it.
+func postSharePassword(c *gin.Context) {
+	albumsMu.RLock()
+	i := findShare(c.Param("token"), time.Now())
+	var s share
+	if i >= 0 {
+		s = shares[i]
+	}
+	albumsMu.RUnlock()
+
+	if i < 0 || s.PasswordHash == "" {
+		renderShare(c, true)
+		return
+	}
+	ok, err := checkSharePassword(c, &s, c.PostForm("password"))
+	if err != nil {
+		respondShareThrottled(c)
+		return
+	}
+	if !ok {
+		renderShare(c, true)
+		return
+	}
+	maxAge := 0
+	if s.ExpiresAt != nil {
+		maxAge = int(time.Until(*s.ExpiresAt).Seconds()) + 1
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("share_"+s.ID, s.unlockToken(), maxAge, "/s/"+s.ID, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, "/s/"+s.ID)
+}
+
+func dumpShares() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(shares, "", "    ")
+}
+
+func restoreShares(data []byte) (func(), int, error) {
+	var restored []share
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		shares = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
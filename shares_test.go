@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShares(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	tracks = []track{
+		{ID: "2", AlbumID: "1", Title: "Moment's Notice", Number: 2, Path: "/music/2.flac"},
+		{ID: "1", AlbumID: "1", Title: "Blue Train", Number: 1, Path: "/music/1.flac"},
+	}
+	playlists = []playlist{{ID: "p1", Name: "Late <night>", Owner: "bob", TrackIDs: []string{"2"}}}
+
+	// Check an album share renders a page with link preview tags and its tracks in order
+	rr := performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1"}`), "bob")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	var created shareView
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if !strings.HasSuffix(created.URL, "/s/"+created.ID) || created.Protected {
+		t.Errorf("Expected an open link, but got %+v", created)
+	}
+	req := httptest.NewRequest("GET", "/s/"+created.ID, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	page := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(page, `<meta property="og:title" content="Blue Train">`) || !strings.Contains(page, `content="music.album"`) {
+		t.Errorf("Expected a page with Open Graph tags, but got %d: %s", rr.Code, page)
+	}
+	if strings.Index(page, "/tracks/1/stream") > strings.Index(page, "/tracks/2/stream") || strings.Contains(page, "/music/") {
+		t.Errorf("Expected the tracks in order without their paths, but got %s", page)
+	}
+
+	// Check links use the public address when one is configured
+	publicURL = "https://music.example.com/"
+	t.Cleanup(func() { publicURL = "" })
+	rr = performRequest(router, "GET", "/shares", nil, "bob")
+	if !strings.Contains(rr.Body.String(), `"url": "https://music.example.com/s/`+created.ID+`"`) {
+		t.Errorf("Expected the link on the public address, but got %s", rr.Body)
+	}
+
+	// Check a playlist share escapes its name and asks JSON clients for nothing but JSON
+	rr = performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "playlist", "id": "p1"}`), "alice")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d sharing another user's playlist, but got %d", http.StatusNotFound, rr.Code)
+	}
+	rr = performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "playlist", "id": "p1"}`), "bob")
+	var list shareView
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	rr = performRequest(router, "GET", "/s/"+list.ID, nil, "")
+	if !strings.Contains(rr.Body.String(), "Late &lt;night&gt;") {
+		t.Errorf("Expected the playlist name escaped, but got %s", rr.Body)
+	}
+	req = httptest.NewRequest("GET", "/s/"+list.ID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var content sharedContent
+	json.Unmarshal(rr.Body.Bytes(), &content)
+	if content.Title != "Late <night>" || len(content.Tracks) != 1 || content.Tracks[0].ID != "2" {
+		t.Errorf("Expected the playlist as JSON, but got %s", rr.Body)
+	}
+
+	// Check a password protected share only opens with the password
+	rr = performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1", "password": "s3cret"}`), "bob")
+	var locked shareView
+	json.Unmarshal(rr.Body.Bytes(), &locked)
+	rr = performRequest(router, "GET", "/s/"+locked.ID, nil, "")
+	if !strings.Contains(rr.Body.String(), `type="password"`) || strings.Contains(rr.Body.String(), "Blue Train") {
+		t.Errorf("Expected a password form that gives nothing away, but got %s", rr.Body)
+	}
+	form := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/s/"+locked.ID, strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := form("guess"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a wrong password, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	rr = form("s3cret")
+	cookies := rr.Result().Cookies()
+	if rr.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Path != "/s/"+locked.ID {
+		t.Fatalf("Expected a redirect setting a cookie for the link, but got %d %v", rr.Code, cookies)
+	}
+	req = httptest.NewRequest("GET", "/s/"+locked.ID, nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "Moment&#39;s Notice") {
+		t.Errorf("Expected the album once unlocked, but got %s", rr.Body)
+	}
+	req = httptest.NewRequest("GET", "/s/"+locked.ID, nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Share-Password", "s3cret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d with X-Share-Password, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check expired and revoked shares are gone, and only the owner can revoke
+	expires := time.Now().Add(time.Hour)
+	shares[0].ExpiresAt = &expires
+	if rr := performRequest(router, "GET", "/s/"+created.ID, nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d before expiry, but got %d", http.StatusOK, rr.Code)
+	}
+	expires = time.Now().Add(-time.Second)
+	if rr := performRequest(router, "GET", "/s/"+created.ID, nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d after expiry, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "DELETE", "/shares/"+list.ID, nil, "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := performRequest(router, "DELETE", "/shares/"+list.ID, nil, "bob"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/shares", nil, "bob")
+	var own []shareView
+	json.Unmarshal(rr.Body.Bytes(), &own)
+	if len(own) != 1 || own[0].ID != locked.ID || !own[0].Protected {
+		t.Errorf("Expected only the protected share left, but got %+v", own)
+	}
+	if strings.Contains(rr.Body.String(), "passwordHash") {
+		t.Errorf("Expected no password hash in the listing, but got %s", rr.Body)
+	}
+
+	// Check the past cannot be an expiry
+	rr = performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1", "expiresAt": "2001-01-01T00:00:00Z"}`), "bob")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// Shared albums play for guests even outside the public subset, and only
+// through the share
+func TestSharesForGuests(t *testing.T) {
+	resetCatalog(t)
+	cfg := testConfig()
+	cfg.GuestAccess, cfg.GuestRateLimit, cfg.RateLimitWindow = true, 100, time.Minute
+	router := setupRouter(cfg)
+	savedTag, savedDir := guestTag, thumbnailDir
+	guestTag, thumbnailDir = "public", t.TempDir()
+	t.Cleanup(func() { guestTag, thumbnailDir = savedTag, savedDir })
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1.mp3"), []byte("ID3 audio"), 0o644)
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 700, 700)))
+	os.WriteFile(filepath.Join(dir, "cover.png"), buf.Bytes(), 0o644)
+	albums = []album{{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Godchild", Path: filepath.Join(dir, "1.mp3")}}
+	rr := performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1"}`), "bob")
+	var created shareView
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	// Check a guest gets the share with its stream and cover
+	shared := func() sharedContent {
+		req := httptest.NewRequest("GET", "/s/"+created.ID, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var content sharedContent
+		json.Unmarshal(rr.Body.Bytes(), &content)
+		if rr.Code != http.StatusOK || len(content.Tracks) != 1 || content.CoverURL == "" {
+			t.Fatalf("Expected the shared album, but got %d: %s", rr.Code, rr.Body)
+		}
+		return content
+	}
+	requestURI := func(raw string) string {
+		u, _ := url.Parse(raw)
+		return u.RequestURI()
+	}
+	content := shared()
+	stream, cover := requestURI(content.Tracks[0].StreamURL), requestURI(content.CoverURL)
+	if rr := performRequest(router, "GET", stream, nil, ""); rr.Code != http.StatusOK || rr.Body.String() != "ID3 audio" {
+		t.Errorf("Expected the track from %s, but got %d: %s", stream, rr.Code, rr.Body)
+	}
+	if rr := performRequest(router, "GET", cover, nil, ""); rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Expected the cover from %s, but got %d: %s", cover, rr.Code, rr.Body)
+	}
+
+	// Check the plain cover is linked when thumbnails are not configured
+	thumbnailDir = ""
+	plain := requestURI(shared().CoverURL)
+	if rr := performRequest(router, "GET", plain, nil, ""); rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected the plain cover from %s, but got %d: %s", plain, rr.Code, rr.Body)
+	}
+
+	// Check the album stays hidden elsewhere, and the signature is needed
+	for path, want := range map[string]int{
+		"/tracks/1/stream": http.StatusNotFound,
+		"/albums/1/cover":  http.StatusNotFound,
+		strings.Replace(stream, "/tracks/1/", "/tracks/2/", 1): http.StatusForbidden,
+		"/s/" + created.ID + "/tracks/1/stream":                http.StatusForbidden,
+	} {
+		if rr := performRequest(router, "GET", path, nil, ""); rr.Code != want {
+			t.Errorf("Expected status code %d for %s, but got %d", want, path, rr.Code)
+		}
+	}
+
+	// Check revoking the share stops its links
+	performRequest(router, "DELETE", "/shares/"+created.ID, nil, "bob")
+	if rr := performRequest(router, "GET", stream, nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d once revoked, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// Share passwords are kept with bcrypt, and guessing them is throttled
+func TestSharePasswords(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	ctx := context.Background()
+	var counters []string
+	t.Cleanup(func() { sharedCache.Delete(ctx, counters...) })
+	unlock := func(id, password, ip string) *httptest.ResponseRecorder {
+		counters = append(counters, "sharepw:share:"+id, "sharepw:client:"+ip)
+		req := httptest.NewRequest("GET", "/s/"+id, nil)
+		req.RemoteAddr = ip + ":40000"
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Share-Password", password)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	create := func() string {
+		rr := performRequest(router, "POST", "/shares", strings.NewReader(`{"kind": "album", "id": "1", "password": "s3cret"}`), "bob")
+		var created shareView
+		json.Unmarshal(rr.Body.Bytes(), &created)
+		return created.ID
+	}
+
+	// Check new passwords are hashed with bcrypt
+	id := create()
+	if hash := shares[0].PasswordHash; !strings.HasPrefix(hash, "$2a$") || shares[0].Salt != "" {
+		t.Errorf("Expected a bcrypt hash, but got %q", hash)
+	}
+
+	// Check a share takes no more passwords, not even the right one, after
+	// too many wrong ones from anywhere
+	for i := 0; i < maxShareFailuresPerShare; i++ {
+		if rr := unlock(id, "guess", fmt.Sprintf("10.0.0.%d", i)); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+		}
+	}
+	rr := unlock(id, "s3cret", "10.0.1.1")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected status code %d with Retry-After, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	// Check a client that guessed wrong too often is turned away from every
+	// share, and others are not
+	other := create()
+	for i := 0; i < maxShareFailuresPerClient; i++ {
+		sharedCache.Incr(ctx, "sharepw:client:192.0.2.7", sharePasswordWindow)
+	}
+	if rr := unlock(other, "s3cret", "192.0.2.7"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr := unlock(other, "s3cret", "192.0.2.8"); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check a share hashed before bcrypt still opens, and is rehashed
+	shares = append(shares, share{ID: "legacy", Kind: shareAlbum, TargetID: "1", Owner: "bob", Salt: "ab", PasswordHash: legacySharePasswordHash("ab", "old"), CreatedAt: time.Now()})
+	if rr := unlock("legacy", "old", "192.0.2.9"); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if s := shares[len(shares)-1]; s.Salt != "" || !s.checkPassword("old") || s.checkPassword("new") {
+		t.Errorf("Expected the password rehashed with bcrypt, but got %+v", s)
+	}
+}
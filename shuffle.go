@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shuffleOff = "off"
+	// shuffleRandom plays the rest of the queue in random order.
+	shuffleRandom = "random"
+	// shuffleAlbum keeps each album's tracks together and in order, and
+	// shuffles the albums.
+	shuffleAlbum = "album"
+	// shuffleWeighted favors tracks that were played less and albums that
+	// are rated higher.
+	shuffleWeighted = "weighted"
+)
+
+var shuffleModes = map[string]bool{shuffleOff: true, shuffleRandom: true, shuffleAlbum: true, shuffleWeighted: true}
+
+// unratedWeight stands in for the rating of albums nobody reviewed, so they
+// are neither favored nor held back.
+const unratedWeight = 3.0
+
+// playCounts counts how often each track was played to its end. It is
+// guarded by albumsMu.
+var playCounts = map[string]int{}
+
+// countPlay records that a track was played to its end, in the history of
+// user when there is one.
+func countPlay(id, user string) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	now := time.Now()
+	playCounts[id]++
+	recordListen(user, id, now)
+	firePlayed(user, id, now)
+}
+
+// shuffleWeight is how strongly a track is favored by weighted shuffle.
+// Callers must hold albumsMu.
+func shuffleWeight(id string) float64 {
+	rating := unratedWeight
+	if i := findTrack(id); i >= 0 {
+		if j := findAlbum(tracks[i].AlbumID); j >= 0 && albums[j].Rating != nil {
+			rating = albums[j].Rating.Average
+		}
+	}
+	return (1 + rating) / float64(1+playCounts[id])
+}
+
+// shuffled returns ids reordered by mode. Callers must hold p.mu.
+func (p *player) shuffled(ids []string, mode string) []string {
+	order := append([]string(nil), ids...)
+	switch mode {
+	case shuffleRandom:
+		p.rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	case shuffleAlbum:
+		albumsMu.RLock()
+		var groups [][]string
+		index := map[string]int{}
+		for _, id := range ids {
+			albumID := "track:" + id
+			if i := findTrack(id); i >= 0 && tracks[i].AlbumID != "" {
+				albumID = tracks[i].AlbumID
+			}
+			g, ok := index[albumID]
+			if !ok {
+				g = len(groups)
+				index[albumID] = g
+				groups = append(groups, nil)
+			}
+			groups[g] = append(groups[g], id)
+		}
+		albumsMu.RUnlock()
+		p.rand.Shuffle(len(groups), func(i, j int) { groups[i], groups[j] = groups[j], groups[i] })
+		order = order[:0]
+		for _, g := range groups {
+			order = append(order, g...)
+		}
+	case shuffleWeighted:
+		// Weighted sampling without replacement: sorting by u^(1/w) for
+		// uniform u puts heavier tracks first more often.
+		keys := make(map[string]float64, len(order))
+		albumsMu.RLock()
+		for _, id := range order {
+			keys[id] = math.Pow(p.rand.Float64(), 1/shuffleWeight(id))
+		}
+		albumsMu.RUnlock()
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] > keys[order[j]] })
+	}
+	if mode == shuffleRandom || mode == shuffleWeighted {
+		albumsMu.RLock()
+		order = spreadVersions(order)
+		albumsMu.RUnlock()
+	}
+	return order
+}
+
+// reshuffle reorders the tracks after the current one. Callers must hold
+// p.mu.
+func (p *player) reshuffle() {
+	if p.shuffle == shuffleOff {
+		return
+	}
+	start := min(p.index+1, len(p.queue))
+	p.queue = append(p.queue[:start], p.shuffled(p.queue[start:], p.shuffle)...)
+}
+
+// SetShuffle changes the shuffle mode. Turning shuffle on reorders the rest
+// of the queue; turning it off puts the queue back in the order tracks were
+// added, continuing from the current track.
+func (p *player) SetShuffle(mode string) playerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sync()
+	if mode == shuffleOff && p.shuffle != shuffleOff {
+		current := ""
+		if p.index < len(p.queue) {
+			current = p.queue[p.index]
+		}
+		p.queue = p.unshuffled
+		p.unshuffled = nil
+		p.index = len(p.queue)
+		for i, id := range p.queue {
+			if id == current {
+				p.index = i
+				break
+			}
+		}
+	} else if mode != shuffleOff && p.shuffle == shuffleOff {
+		p.unshuffled = append([]string(nil), p.queue...)
+	} else if mode != shuffleOff {
+		// Switching between shuffle modes starts again from the order
+		// tracks were added in.
+		start := min(p.index+1, len(p.queue))
+		played := map[string]int{}
+		for _, id := range p.queue[:start] {
+			played[id]++
+		}
+		p.queue = p.queue[:start]
+		for _, id := range p.unshuffled {
+			if played[id] > 0 {
+				played[id]--
+				continue
+			}
+			p.queue = append(p.queue, id)
+		}
+	}
+	p.shuffle = mode
+	p.reshuffle()
+	p.touch()
+	return p.status()
+}
+
+func getShuffleMode(c *gin.Context) {
+	musicPlayer.mu.Lock()
+	defer musicPlayer.mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, gin.H{"mode": musicPlayer.shuffle})
+}
+
+func putShuffleMode(c *gin.Context) {
+	var input struct {
+		Mode string `json:"mode"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+	if !shuffleModes[input.Mode] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "mode must be one of off, random, album or weighted"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, musicPlayer.SetShuffle(input.Mode))
+}
+
+func dumpPlayCounts() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(playCounts, "", "    ")
+}
+
+func restorePlayCounts(data []byte) (func(), int, error) {
+	var restored map[string]int
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]int{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		playCounts = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
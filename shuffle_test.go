@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShuffleModes(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	musicPlayer.rand = rand.New(rand.NewSource(1))
+	albums = []album{{ID: "1", Title: "A"}, {ID: "2", Title: "B"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Duration: 100}, {ID: "2", AlbumID: "1", Duration: 100}, {ID: "3", AlbumID: "1", Duration: 100},
+		{ID: "4", AlbumID: "2", Duration: 100}, {ID: "5", AlbumID: "2", Duration: 100}, {ID: "6", AlbumID: "2", Duration: 100},
+	}
+	added := []string{"1", "2", "3", "4", "5", "6"}
+	musicPlayer.Enqueue(added...)
+
+	// Check random shuffle keeps the current track and every other track
+	musicPlayer.SetShuffle(shuffleRandom)
+	queue := append([]string(nil), musicPlayer.queue...)
+	sorted := append([]string(nil), queue...)
+	sort.Strings(sorted)
+	if queue[0] != "1" || !reflect.DeepEqual(sorted, added) {
+		t.Errorf("Expected a permutation starting with track 1, but got %v", queue)
+	}
+
+	// Check album shuffle keeps albums together and in order
+	musicPlayer.SetShuffle(shuffleAlbum)
+	rest := strings.Join(musicPlayer.queue[1:], "")
+	if rest != "23456" && rest != "45623" {
+		t.Errorf("Expected albums to stay together, but got %v", musicPlayer.queue)
+	}
+
+	// Check turning shuffle off restores the order tracks were added in
+	musicPlayer.SetShuffle(shuffleOff)
+	if !reflect.DeepEqual(musicPlayer.queue, added) || musicPlayer.index != 0 {
+		t.Errorf("Expected the original order, but got %v at %d", musicPlayer.queue, musicPlayer.index)
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	musicPlayer.rand = rand.New(rand.NewSource(1))
+	albums = []album{{ID: "1", Title: "A", Rating: &albumRating{Average: 5, Count: 1}}, {ID: "2", Title: "B", Rating: &albumRating{Average: 1, Count: 1}}}
+	tracks = []track{{ID: "1", AlbumID: "1"}, {ID: "2", AlbumID: "2"}}
+	playCounts = map[string]int{"2": 20}
+
+	// Check the better rated, less played track usually comes first
+	first := 0
+	for i := 0; i < 100; i++ {
+		if musicPlayer.shuffled([]string{"1", "2"}, shuffleWeighted)[0] == "1" {
+			first++
+		}
+	}
+	if first < 90 {
+		t.Errorf("Expected track 1 first most of the time, but got %d of 100", first)
+	}
+}
+
+func TestPutShuffleMode(t *testing.T) {
+	resetCatalog(t)
+	now := usePlayer(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Duration: 10}}
+
+	// Check unknown modes are rejected
+	rr := performRequest(router, "PUT", "/player/shuffle-mode", strings.NewReader(`{"mode": "sideways"}`), "bob")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = performRequest(router, "PUT", "/player/shuffle-mode", strings.NewReader(`{"mode": "weighted"}`), "bob")
+	if rr.Code != http.StatusOK || musicPlayer.shuffle != shuffleWeighted {
+		t.Errorf("Expected weighted shuffle, but got %d %s", rr.Code, musicPlayer.shuffle)
+	}
+
+	// Check tracks played to the end are counted
+	musicPlayer.Enqueue("1")
+	musicPlayer.Play()
+	*now = now.Add(time.Minute)
+	performRequest(router, "GET", "/player", nil, "bob")
+	if playCounts["1"] != 1 {
+		t.Errorf("Expected 1 play, but got %d", playCounts["1"])
+	}
+}
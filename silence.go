@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// silenceThreshold and minSilence are passed to ffmpeg's silencedetect
+// filter: anything quieter than -50 dB for half a second counts as silence.
+const (
+	silenceThreshold = "-50dB"
+	minSilence       = "0.5"
+)
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: ([0-9.]+)`)
+	durationPattern     = regexp.MustCompile(`Duration: (\d+):(\d+):([0-9.]+)`)
+)
+
+// silenceAnalyzer finds leading and trailing silence with ffmpeg and reports
+// it as skip-in and skip-out markers.
+type silenceAnalyzer struct {
+	ffmpeg string
+}
+
+func (s silenceAnalyzer) Analyze(ctx context.Context, path string) (trackAnalysis, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.ffmpeg, "-hide_banner", "-nostats", "-i", path,
+		"-af", "silencedetect=noise="+silenceThreshold+":d="+minSilence, "-f", "null", "-")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return trackAnalysis{}, fmt.Errorf("ffmpeg: %w", err)
+	}
+	skipIn, skipOut := parseSilence(stderr.Bytes())
+	return trackAnalysis{SkipIn: skipIn, SkipOut: skipOut}, nil
+}
+
+// parseSilence reads silencedetect output. Silence starting at 0 gives the
+// skip-in point; silence still running at the end of the file gives the
+// skip-out point. Either is 0 when there is no such silence.
+func parseSilence(output []byte) (skipIn, skipOut float64) {
+	var duration, start float64
+	open := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := durationPattern.FindStringSubmatch(line); m != nil {
+			h, _ := strconv.ParseFloat(m[1], 64)
+			mins, _ := strconv.ParseFloat(m[2], 64)
+			secs, _ := strconv.ParseFloat(m[3], 64)
+			duration = h*3600 + mins*60 + secs
+		}
+		if m := silenceStartPattern.FindStringSubmatch(line); m != nil {
+			start, _ = strconv.ParseFloat(m[1], 64)
+			open = true
+		}
+		if m := silenceEndPattern.FindStringSubmatch(line); m != nil {
+			end, _ := strconv.ParseFloat(m[1], 64)
+			if start <= 0 {
+				skipIn = end
+			}
+			// A silence that ends at the very end of the file is trailing.
+			if duration > 0 && end >= duration-0.01 && start > 0 {
+				skipOut = start
+			}
+			open = false
+		}
+	}
+	if open && start > 0 {
+		skipOut = start
+	}
+	return skipIn, skipOut
+}
+
+// combinedAnalyzer runs several analyzers and merges their non-zero results,
+// so silence detection can run alongside the BPM/key analyzer.
+type combinedAnalyzer []trackAnalyzer
+
+func (c combinedAnalyzer) Analyze(ctx context.Context, path string) (trackAnalysis, error) {
+	var merged trackAnalysis
+	for _, a := range c {
+		result, err := a.Analyze(ctx, path)
+		if err != nil {
+			return trackAnalysis{}, err
+		}
+		if result.BPM != 0 {
+			merged.BPM = result.BPM
+		}
+		if result.Key != "" {
+			merged.Key = result.Key
+		}
+		if result.Loudness != 0 {
+			merged.Loudness = result.Loudness
+		}
+		if result.Energy != 0 {
+			merged.Energy = result.Energy
+		}
+		if result.Valence != 0 {
+			merged.Valence = result.Valence
+		}
+		if result.SkipIn != 0 {
+			merged.SkipIn = result.SkipIn
+		}
+		if result.SkipOut != 0 {
+			merged.SkipOut = result.SkipOut
+		}
+		if len(result.Peaks) > 0 {
+			merged.Peaks = result.Peaks
+		}
+		if len(result.Chapters) > 0 {
+			merged.Chapters = result.Chapters
+		}
+	}
+	return merged, nil
+}
+
+// putTrackMarkers lets admins correct the detected skip-in and skip-out
+// markers of a track. A skip-out of 0 plays to the end.
+func putTrackMarkers(c *gin.Context) {
+	var markers struct {
+		SkipIn  float64 `json:"skipIn"`
+		SkipOut float64 `json:"skipOut"`
+	}
+
+	if err := c.BindJSON(&markers); err != nil {
+		return
+	}
+	if markers.SkipIn < 0 || markers.SkipOut < 0 || markers.SkipOut != 0 && markers.SkipOut <= markers.SkipIn {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "markers must satisfy 0 <= skipIn < skipOut"})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	if d := tracks[i].Duration; d > 0 && (markers.SkipIn >= d || markers.SkipOut > d) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "markers must fall within the track"})
+		return
+	}
+	analysis := trackAnalysis{}
+	if tracks[i].Analysis != nil {
+		analysis = *tracks[i].Analysis
+	}
+	analysis.SkipIn, analysis.SkipOut = markers.SkipIn, markers.SkipOut
+	tracks[i].Analysis = &analysis
+	c.IndentedJSON(http.StatusOK, tracks[i])
+}
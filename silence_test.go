@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Leading and trailing silence become skip-in and skip-out markers
+func TestParseSilence(t *testing.T) {
+	output := `Input #0, flac, from 'live.flac':
+  Duration: 00:04:10.00, start: 0.000000, bitrate: 900 kb/s
+[silencedetect @ 0x1] silence_start: 0
+[silencedetect @ 0x1] silence_end: 2.35 | silence_duration: 2.35
+[silencedetect @ 0x1] silence_start: 120.5
+[silencedetect @ 0x1] silence_end: 121.2 | silence_duration: 0.7
+[silencedetect @ 0x1] silence_start: 245.8
+[silencedetect @ 0x1] silence_end: 250 | silence_duration: 4.2
+`
+	// Check the mid-track gap is ignored
+	skipIn, skipOut := parseSilence([]byte(output))
+	if skipIn != 2.35 || skipOut != 245.8 {
+		t.Errorf("Expected markers 2.35 and 245.8, but got %v and %v", skipIn, skipOut)
+	}
+
+	// Check a track without silence gets no markers
+	skipIn, skipOut = parseSilence([]byte("  Duration: 00:03:00.00, start: 0.000000\n"))
+	if skipIn != 0 || skipOut != 0 {
+		t.Errorf("Expected no markers, but got %v and %v", skipIn, skipOut)
+	}
+}
+
+// Admins can correct markers, within the bounds of the track
+func TestPutTrackMarkers(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Live Intro", Duration: 200}}
+
+	// Check markers beyond the end or out of order are refused
+	if rr := performRequest(router, "PUT", "/tracks/1/markers", strings.NewReader(`{"skipIn": 5, "skipOut": 300}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/tracks/1/markers", strings.NewReader(`{"skipIn": 50, "skipOut": 40}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Set valid markers and check they are stored
+	if rr := performRequest(router, "PUT", "/tracks/1/markers", strings.NewReader(`{"skipIn": 3.5, "skipOut": 195}`), "alice"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if a := tracks[0].Analysis; a == nil || a.SkipIn != 3.5 || a.SkipOut != 195 {
+		t.Errorf("Expected markers 3.5 and 195, but got %+v", a)
+	}
+}
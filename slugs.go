@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// staleSlugs maps slugs albums no longer use to the album's id, so old
+// links redirect. It is guarded by albumsMu.
+var staleSlugs = map[string]string{}
+
+// albumSlugBase is the slug an album gets unless another album has it, as
+// in "blue-train-john-coltrane".
+func albumSlugBase(a album) string {
+	if base := slugify(a.Title + " " + a.Artist); base != "" {
+		return base
+	}
+	return "album"
+}
+
+// slugHasBase reports whether slug is base or base with a collision
+// suffix such as "-2".
+func slugHasBase(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(slug, base+"-"))
+	return strings.HasPrefix(slug, base+"-") && err == nil && n > 1
+}
+
+// uniqueSlug returns base, or base with the lowest free suffix, skipping
+// slugs held by other albums now or in the past. Callers must hold
+// albumsMu.
+func uniqueSlug(base, id string) string {
+	taken := func(slug string) bool {
+		if owner, ok := staleSlugs[slug]; ok && owner != id {
+			return true
+		}
+		for _, a := range albums {
+			if a.Slug == slug && a.ID != id {
+				return true
+			}
+		}
+		return false
+	}
+	slug := base
+	for n := 2; taken(slug); n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	return slug
+}
+
+// albumSlug returns the slug an album should have: its current one while
+// it matches the title and artist, or else a new one. Callers must hold
+// albumsMu.
+func albumSlug(a album) string {
+	if base := albumSlugBase(a); !slugHasBase(a.Slug, base) {
+		return uniqueSlug(base, a.ID)
+	}
+	return a.Slug
+}
+
+// setAlbumSlug gives a new or changed album its slug. previous is the slug
+// the album had before, which then redirects. Callers must hold albumsMu.
+func setAlbumSlug(a *album, previous string) {
+	a.Slug = albumSlug(*a)
+	if previous != "" && previous != a.Slug {
+		staleSlugs[previous] = a.ID
+	}
+	delete(staleSlugs, a.Slug)
+}
+
+// assignSlugs gives slugs to albums without one, such as those from older
+// backups. Callers must hold albumsMu.
+func assignSlugs() {
+	for i := range albums {
+		if albums[i].Slug == "" {
+			setAlbumSlug(&albums[i], "")
+		}
+	}
+}
+
+// getAlbumBySlug looks an album up by slug. Stale slugs redirect to the
+// album's current one.
+func getAlbumBySlug(c *gin.Context) {
+	slug := c.Param("slug")
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	for _, a := range albums {
+		if a.Slug == slug {
+			c.IndentedJSON(http.StatusOK, a)
+			return
+		}
+	}
+	if id, ok := staleSlugs[slug]; ok {
+		if i := findAlbum(id); i >= 0 {
+			c.Redirect(http.StatusMovedPermanently, "/albums/slug/"+albums[i].Slug)
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+}
+
+func dumpStaleSlugs() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(staleSlugs, "", "    ")
+}
+
+func restoreStaleSlugs(data []byte) (func(), int, error) {
+	var restored map[string]string
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string]string{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		staleSlugs = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
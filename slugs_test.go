@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAlbumSlugs(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{}
+
+	post := func(body string) album {
+		rr := performRequest(router, "POST", "/albums", strings.NewReader(body), "")
+		var a album
+		json.Unmarshal(rr.Body.Bytes(), &a)
+		return a
+	}
+
+	// Check slugs come from title and artist, with collisions numbered
+	first := post(`{"title": "Blue Train", "artist": "John Coltrane", "slug": "mine"}`)
+	second := post(`{"title": "Blue Train!", "artist": "John Coltrane"}`)
+	if first.Slug != "blue-train-john-coltrane" || second.Slug != "blue-train-john-coltrane-2" {
+		t.Errorf("Expected numbered slugs, but got %q and %q", first.Slug, second.Slug)
+	}
+	rr := performRequest(router, "GET", "/albums/slug/blue-train-john-coltrane-2", nil, "")
+	var got album
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || got.ID != second.ID {
+		t.Errorf("Expected album %s, but got %d %s", second.ID, rr.Code, rr.Body.String())
+	}
+
+	// Check a title change regenerates the slug and the old one redirects
+	rr = performRequest(router, "PATCH", "/albums/"+first.ID, strings.NewReader(`{"title": "Blue Train (Remastered)"}`), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Slug != "blue-train-remastered-john-coltrane" {
+		t.Errorf("Expected a new slug, but got %q", got.Slug)
+	}
+	rr = performRequest(router, "GET", "/albums/slug/blue-train-john-coltrane", nil, "")
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/albums/slug/blue-train-remastered-john-coltrane" {
+		t.Errorf("Expected a redirect to the new slug, but got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// Check other albums do not take over a stale slug
+	third := post(`{"title": "Blue Train", "artist": "John Coltrane"}`)
+	if third.Slug != "blue-train-john-coltrane-3" {
+		t.Errorf("Expected blue-train-john-coltrane-3, but got %q", third.Slug)
+	}
+
+	// Check changing other fields keeps the slug
+	rr = performRequest(router, "PATCH", "/albums/"+second.ID, strings.NewReader(`{"price": 9.99}`), "alice")
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Slug != second.Slug {
+		t.Errorf("Expected the slug to stay %q, but got %q", second.Slug, got.Slug)
+	}
+
+	rr = performRequest(router, "GET", "/albums/slug/giant-steps", nil, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonFieldNames returns the JSON names of a struct type's fields.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// sparseFields reads ?fields=id,title as a set of top-level fields of T,
+// or nil when every field is wanted.
+func sparseFields[T any](c *gin.Context) (map[string]bool, error) {
+	v := c.Query("fields")
+	if v == "" {
+		return nil, nil
+	}
+	known := jsonFieldNames(reflect.TypeOf((*T)(nil)).Elem())
+	wanted := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			names := make([]string, 0, len(known))
+			for n := range known {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown field %q in fields, known fields are %s", name, strings.Join(names, ", "))
+		}
+		wanted[name] = true
+	}
+	return wanted, nil
+}
+
+// respondList writes a list of items, keeping only the fields named in
+// ?fields when it is given; see writeList for the envelope.
+func respondList[T any](c *gin.Context, items []T) {
+	wanted, err := sparseFields[T](c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if wanted == nil {
+		writeList(c, items, len(items))
+		return
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	var full []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &full); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	sparse := make([]map[string]json.RawMessage, len(full))
+	for i, item := range full {
+		sparse[i] = map[string]json.RawMessage{}
+		for name, value := range item {
+			if wanted[name] {
+				sparse[i][name] = value
+			}
+		}
+	}
+	writeList(c, sparse, len(sparse))
+}
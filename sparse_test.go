@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSparseFields(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99, Genre: "Jazz"},
+		{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+	}
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Moment's Notice", Duration: 550}}
+
+	// Check only the requested fields are returned
+	rr := performRequest(router, "GET", "/albums?fields=id,+title", nil, "")
+	var got []map[string]any
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if len(got) != 2 || len(got[0]) != 2 || got[0]["id"] != "1" || got[0]["title"] != "Blue Train" {
+		t.Errorf("Expected albums with only id and title, but got %+v", got)
+	}
+
+	// Check selection combines with filters and paging
+	rr = performRequest(router, "GET", "/tracks?albumId=1&fields=duration&limit=1", nil, "")
+	got = nil
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if len(got) != 1 || len(got[0]) != 1 || got[0]["duration"] != 550.0 {
+		t.Errorf("Expected one track with only its duration, but got %+v", got)
+	}
+	if rr.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("Expected X-Total-Count 1, but got %q", rr.Header().Get("X-Total-Count"))
+	}
+
+	// Check optional fields are left out when empty, as without fields
+	rr = performRequest(router, "GET", "/albums?fields=id,genre", nil, "")
+	got = nil
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if len(got) != 2 || got[0]["genre"] != "Jazz" || len(got[1]) != 1 {
+		t.Errorf("Expected a genre only on the first album, but got %+v", got)
+	}
+
+	// Check unknown fields are rejected with the known ones
+	rr = performRequest(router, "GET", "/artists?fields=id,colour", nil, "")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "albums, id, name") {
+		t.Errorf("Expected a 400 listing the artist fields, but got %d %s", rr.Code, rr.Body.String())
+	}
+}
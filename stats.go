@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// libraryStats keeps running totals of the library. It is updated as albums
+// and tracks are added or changed, so /library/stats never scans the
+// catalog. Since every catalog change goes through it, it also marks the
+// search suggestions stale. It is guarded by albumsMu.
+type libraryStats struct {
+	albums   int
+	tracks   int
+	duration float64
+	size     int64
+	// artists counts albums per artist slug.
+	artists  map[string]int
+	formats  map[string]*formatStats
+	bitrates map[string]int
+	// months holds what was added per month, keyed by YYYY-MM.
+	months map[string]*growthPoint
+}
+
+type formatStats struct {
+	Tracks int   `json:"tracks"`
+	Size   int64 `json:"size"`
+}
+
+type growthPoint struct {
+	Month  string `json:"month"`
+	Tracks int    `json:"tracksAdded"`
+	Size   int64  `json:"sizeAdded"`
+	// TotalTracks is the running total at the end of the month.
+	TotalTracks int `json:"totalTracks"`
+}
+
+var libStats = computeLibraryStats()
+
+// computeLibraryStats builds the totals from scratch, for startup and after
+// a restore. Callers must hold albumsMu, except during initialization.
+func computeLibraryStats() *libraryStats {
+	suggestions.stale.Store(true)
+	s := &libraryStats{
+		artists:  map[string]int{},
+		formats:  map[string]*formatStats{},
+		bitrates: map[string]int{},
+		months:   map[string]*growthPoint{},
+	}
+	for _, a := range albums {
+		s.addAlbum(a)
+	}
+	for _, t := range tracks {
+		s.addTrack(t)
+	}
+	return s
+}
+
+func (s *libraryStats) addAlbum(a album) {
+	suggestions.stale.Store(true)
+	s.albums++
+	s.artists[slugify(a.Artist)]++
+}
+
+func (s *libraryStats) removeAlbum(a album) {
+	suggestions.stale.Store(true)
+	s.albums--
+	id := slugify(a.Artist)
+	if s.artists[id]--; s.artists[id] <= 0 {
+		delete(s.artists, id)
+	}
+}
+
+// replaceAlbum accounts for an album being changed from old to updated.
+func (s *libraryStats) replaceAlbum(old, updated album) {
+	s.removeAlbum(old)
+	s.addAlbum(updated)
+}
+
+func (s *libraryStats) addTrack(t track) {
+	s.adjustTrack(t, 1)
+}
+
+func (s *libraryStats) removeTrack(t track) {
+	s.adjustTrack(t, -1)
+}
+
+// replaceTrack accounts for a track being changed from old to updated.
+func (s *libraryStats) replaceTrack(old, updated track) {
+	s.removeTrack(old)
+	s.addTrack(updated)
+}
+
+func (s *libraryStats) adjustTrack(t track, sign int) {
+	suggestions.stale.Store(true)
+	s.tracks += sign
+	s.duration += float64(sign) * t.Duration
+	s.size += int64(sign) * t.Size
+
+	format := trackFormat(t)
+	f := s.formats[format]
+	if f == nil {
+		f = &formatStats{}
+		s.formats[format] = f
+	}
+	f.Tracks += sign
+	f.Size += int64(sign) * t.Size
+	if f.Tracks <= 0 {
+		delete(s.formats, format)
+	}
+
+	bucket := bitrateBucket(t.Bitrate)
+	if s.bitrates[bucket] += sign; s.bitrates[bucket] <= 0 {
+		delete(s.bitrates, bucket)
+	}
+
+	if t.AddedAt == nil {
+		return
+	}
+	month := t.AddedAt.UTC().Format("2006-01")
+	g := s.months[month]
+	if g == nil {
+		g = &growthPoint{Month: month}
+		s.months[month] = g
+	}
+	g.Tracks += sign
+	g.Size += int64(sign) * t.Size
+	if g.Tracks <= 0 {
+		delete(s.months, month)
+	}
+}
+
+// trackFormat is the track's format, or its file extension when the scanner
+// did not report one.
+func trackFormat(t track) string {
+	if t.Format != "" {
+		return strings.ToLower(t.Format)
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(t.Path), "."); ext != "" {
+		return strings.ToLower(ext)
+	}
+	return "unknown"
+}
+
+// bitrateBucket groups bitrates in kbps into the usual encoder settings.
+func bitrateBucket(kbps int) string {
+	switch {
+	case kbps <= 0:
+		return "unknown"
+	case kbps < 128:
+		return "<128"
+	case kbps < 192:
+		return "128-191"
+	case kbps < 256:
+		return "192-255"
+	case kbps < 320:
+		return "256-319"
+	default:
+		return "320+"
+	}
+}
+
+// getLibraryStats reports library totals, format and bitrate breakdowns and
+// month-by-month growth.
+func getLibraryStats(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	s := libStats
+	growth := make([]growthPoint, 0, len(s.months))
+	for _, g := range s.months {
+		growth = append(growth, *g)
+	}
+	sort.Slice(growth, func(i, j int) bool { return growth[i].Month < growth[j].Month })
+	// Tracks without an added date predate growth tracking and are counted
+	// from the start.
+	total := s.tracks
+	for _, g := range growth {
+		total -= g.Tracks
+	}
+	for i := range growth {
+		total += growth[i].Tracks
+		growth[i].TotalTracks = total
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"albums":        s.albums,
+		"tracks":        s.tracks,
+		"artists":       len(s.artists),
+		"totalDuration": s.duration,
+		"totalSize":     s.size,
+		"formats":       s.formats,
+		"bitrates":      s.bitrates,
+		"growth":        growth,
+	})
+}
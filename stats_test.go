@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Stats follow imports and album edits without rescanning
+func TestLibraryStats(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albumCount := len(albums)
+
+	performRequest(router, "POST", "/library/import", strings.NewReader(`[
+		{"path": "/music/a/1.flac", "title": "One", "artist": "Nina Simone", "album": "Pastel Blues", "duration": 120, "size": 30000000, "bitrate": 1000},
+		{"path": "/music/a/2.flac", "title": "Two", "artist": "Nina Simone", "album": "Pastel Blues", "duration": 180, "size": 40000000, "bitrate": 1000},
+		{"path": "/music/b/1.mp3", "title": "Three", "artist": "Miles Davis", "album": "Kind of Blue", "duration": 60, "size": 2000000, "format": "MP3", "bitrate": 192}
+	]`), "alice")
+
+	rr := performRequest(router, "GET", "/library/stats", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	var stats struct {
+		Albums        int                    `json:"albums"`
+		Tracks        int                    `json:"tracks"`
+		TotalDuration float64                `json:"totalDuration"`
+		TotalSize     int64                  `json:"totalSize"`
+		Formats       map[string]formatStats `json:"formats"`
+		Bitrates      map[string]int         `json:"bitrates"`
+		Growth        []growthPoint          `json:"growth"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+
+	// Check totals and breakdowns
+	if stats.Albums != albumCount+2 || stats.Tracks != 3 || stats.TotalDuration != 360 || stats.TotalSize != 72000000 {
+		t.Errorf("Expected %d albums, 3 tracks, 360s and 72000000 bytes, but got %+v", albumCount+2, stats)
+	}
+	if stats.Formats["flac"].Tracks != 2 || stats.Formats["mp3"].Size != 2000000 {
+		t.Errorf("Expected 2 flac tracks and 2000000 bytes of mp3, but got %+v", stats.Formats)
+	}
+	if stats.Bitrates["320+"] != 2 || stats.Bitrates["192-255"] != 1 {
+		t.Errorf("Expected bitrate buckets 320+: 2 and 192-255: 1, but got %v", stats.Bitrates)
+	}
+	if len(stats.Growth) != 1 || stats.Growth[0].Tracks != 3 || stats.Growth[0].TotalTracks != 3 {
+		t.Errorf("Expected 3 tracks added this month, but got %+v", stats.Growth)
+	}
+
+	// Check the running totals match a rebuild after an album edit
+	performRequest(router, "PATCH", "/albums/1", strings.NewReader(`{"artist": "Somebody New"}`), "alice")
+	if fresh := computeLibraryStats(); len(fresh.artists) != len(libStats.artists) || fresh.albums != libStats.albums {
+		t.Errorf("Expected incremental stats to match a rebuild, but got %+v and %+v", libStats, fresh)
+	}
+}
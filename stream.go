@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamBatch is how many items a catalog cursor copies at a time.
+const streamBatch = 500
+
+// catalogCursor returns a function handing out copies of the items of a
+// catalog slice matching match (nil matches all), streamBatch at a time,
+// and nil at the end. albumsMu is only held while a batch is copied, so a
+// slow client does not hold up edits; items added or removed in between
+// may or may not be seen, but none is handed out twice.
+func catalogCursor[T any](items *[]T, id func(T) string, match func(T) bool) func() []T {
+	last, pos := "", 0
+	return func() []T {
+		albumsMu.RLock()
+		defer albumsMu.RUnlock()
+
+		// The last item handed out is usually still where it was; look for
+		// it only when something before it was removed.
+		all := *items
+		if last != "" && (pos > len(all) || id(all[pos-1]) != last) {
+			if i := slices.IndexFunc(all, func(item T) bool { return id(item) == last }); i >= 0 {
+				pos = i + 1
+			}
+		}
+		var batch []T
+		for ; pos < len(all) && len(batch) < streamBatch; pos++ {
+			if match == nil || match(all[pos]) {
+				batch = append(batch, all[pos])
+			}
+		}
+		if pos > 0 && pos <= len(all) {
+			last = id(all[pos-1])
+		}
+		return batch
+	}
+}
+
+// streamJSONArray writes the items next returns as a JSON array, one at a
+// time, laid out as json.MarshalIndent lays out the whole array with the
+// given prefix and four space indent. flush, when not nil, is called after
+// every batch.
+func streamJSONArray[T any](w io.Writer, prefix string, next func() []T, flush func()) error {
+	first := true
+	for batch := next(); len(batch) > 0; batch = next() {
+		for _, item := range batch {
+			data, err := json.MarshalIndent(item, prefix+"    ", "    ")
+			if err != nil {
+				return err
+			}
+			sep := ",\n"
+			if first {
+				sep, first = "[\n", false
+			}
+			if _, err := io.WriteString(w, sep+prefix+"    "); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		if flush != nil {
+			flush()
+		}
+	}
+	end := "\n" + prefix + "]"
+	if first {
+		end = "[]"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+// streamList writes a whole list as respondList would, but streaming it
+// from a cursor instead of building it in memory first. The envelope and
+// ?fields need the full list, so callers only stream plain lists; see
+// canStream.
+func streamList[T any](c *gin.Context, next func() []T) {
+	c.Header("Vary", "Accept")
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	// The status line has gone out; all that is left to do on an error is
+	// cut the response short.
+	streamJSONArray(c.Writer, "", next, c.Writer.Flush)
+}
+
+// canStream reports whether a list can be written with streamList.
+func canStream(c *gin.Context) bool {
+	return c.Query("fields") == "" && !wantsEnvelope(c)
+}
+
+// getExport streams the whole catalog, albums and then tracks, as one JSON
+// document, reading the catalog in batches so that neither it nor the
+// response is held in memory whole.
+func getExport(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="catalog.json"`)
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	at, _ := json.Marshal(time.Now().UTC())
+	if _, err := io.WriteString(w, "{\n    \"exportedAt\": "+string(at)+",\n    \"albums\": "); err != nil {
+		return
+	}
+	if err := streamJSONArray(w, "    ", catalogCursor(&albums, func(a album) string { return a.ID }, nil), w.Flush); err != nil {
+		return
+	}
+	if _, err := io.WriteString(w, ",\n    \"tracks\": "); err != nil {
+		return
+	}
+	if err := streamJSONArray(w, "    ", catalogCursor(&tracks, func(t track) string { return t.ID }, nil), w.Flush); err != nil {
+		return
+	}
+	io.WriteString(w, "\n}")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestStreamJSONArray(t *testing.T) {
+	// Check the stream matches json.MarshalIndent across batch boundaries
+	for _, n := range []int{0, 1, streamBatch + 3} {
+		items := make([]album, n)
+		for i := range items {
+			items[i] = album{ID: strconv.Itoa(i), Title: "<Blue & Train>", Tags: []string{"jazz"}}
+		}
+		var buf bytes.Buffer
+		rest := items
+		next := func() []album {
+			batch := rest[:min(len(rest), streamBatch)]
+			rest = rest[len(batch):]
+			return batch
+		}
+		if err := streamJSONArray(&buf, "", next, nil); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.MarshalIndent(items, "", "    ")
+		if buf.String() != string(want) {
+			t.Errorf("Expected %d items to stream as MarshalIndent lays them out, but got %.200s", n, buf.String())
+		}
+	}
+}
+
+func TestCatalogCursor(t *testing.T) {
+	resetCatalog(t)
+	for i := 0; i < streamBatch+10; i++ {
+		tracks = append(tracks, track{ID: strconv.Itoa(i), AlbumID: strconv.Itoa(i % 2)})
+	}
+	next := catalogCursor(&tracks, func(t track) string { return t.ID }, func(t track) bool { return t.AlbumID == "0" })
+
+	// Check removing an earlier item between batches neither skips nor repeats any
+	seen := map[string]bool{}
+	first := next()
+	tracks = tracks[1:]
+	for batch := first; len(batch) > 0; batch = next() {
+		for _, tr := range batch {
+			if seen[tr.ID] || tr.AlbumID != "0" {
+				t.Fatalf("Expected each matching track once, but got %s again", tr.ID)
+			}
+			seen[tr.ID] = true
+		}
+	}
+	if len(seen) != (streamBatch+10)/2 {
+		t.Errorf("Expected %d tracks, but got %d", (streamBatch+10)/2, len(seen))
+	}
+}
+
+func TestExport(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Moment's Notice"}}
+
+	// Check the export is one document with the whole catalog
+	rr := performRequest(router, "GET", "/export", nil, "alice")
+	var export struct {
+		Albums []album
+		Tracks []track
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &export); err != nil {
+		t.Fatalf("Expected a JSON document, but got %v: %s", err, rr.Body)
+	}
+	if len(export.Albums) != len(albums) || len(export.Tracks) != 1 {
+		t.Errorf("Expected %d albums and 1 track, but got %d and %d", len(albums), len(export.Albums), len(export.Tracks))
+	}
+	if rr := performRequest(router, "GET", "/export", nil, "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// Check the streamed album list is what it was when buffered
+	rr = performRequest(router, "GET", "/albums", nil, "")
+	want, _ := json.MarshalIndent(albums, "", "    ")
+	if rr.Body.String() != string(want) {
+		t.Errorf("Expected %s, but got %s", want, rr.Body)
+	}
+}
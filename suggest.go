@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSuggestions = 5
+	maxSuggestions     = 20
+	// maxSuggestScan bounds how many prefix matches are ranked, so very
+	// short queries stay fast on large libraries.
+	maxSuggestScan = 2000
+)
+
+// suggestEntry files one artist, album or track under one of its keys.
+type suggestEntry struct {
+	key  string
+	kind string
+	id   string
+	// wordStart is true when key is a later word of the name rather than
+	// its beginning; such matches rank lower.
+	wordStart bool
+	weight    int
+}
+
+type suggestion struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Artist  string `json:"artist,omitempty"`
+	AlbumID string `json:"albumId,omitempty"`
+}
+
+// suggestIndex is a sorted list of name prefixes. It is rebuilt lazily
+// after the catalog changes; see libraryStats, whose updates mark it stale.
+type suggestIndex struct {
+	mu      sync.Mutex
+	stale   atomic.Bool
+	entries []suggestEntry
+	names   map[string]suggestion
+}
+
+var suggestions = newSuggestIndex()
+
+func newSuggestIndex() *suggestIndex {
+	s := &suggestIndex{}
+	s.stale.Store(true)
+	return s
+}
+
+// suggestKeys returns the keys a name is found under: the whole name and
+// every later word, lower-cased, with a leading article dropped.
+func suggestKeys(name string) []string {
+	words := strings.Fields(strings.ToLower(name))
+	var keys []string
+	for i := range words {
+		keys = append(keys, strings.Join(words[i:], " "))
+	}
+	if full := sortName(name); len(keys) > 0 && full != keys[0] {
+		keys = append(keys, full)
+	}
+	return keys
+}
+
+// rebuild indexes the catalog. Callers must hold albumsMu and s.mu.
+func (s *suggestIndex) rebuild() {
+	s.entries = s.entries[:0]
+	s.names = map[string]suggestion{}
+	add := func(kind, id string, name string, weight int, info suggestion) {
+		s.names[kind+"/"+id] = info
+		for i, key := range suggestKeys(name) {
+			s.entries = append(s.entries, suggestEntry{key: key, kind: kind, id: id, wordStart: i > 0 && key != sortName(name), weight: weight})
+		}
+	}
+
+	artistAlbums := map[string]int{}
+	artistNames := map[string]string{}
+	for _, a := range albums {
+		if id := slugify(a.Artist); id != "" {
+			artistAlbums[id]++
+			artistNames[id] = a.Artist
+		}
+		add("album", a.ID, a.Title, 0, suggestion{ID: a.ID, Name: a.Title, Artist: a.Artist})
+	}
+	for id, name := range artistNames {
+		add("artist", id, name, artistAlbums[id], suggestion{ID: id, Name: name})
+	}
+	for _, t := range tracks {
+		add("track", t.ID, t.Title, playCounts[t.ID], suggestion{ID: t.ID, Name: t.Title, Artist: t.Artist, AlbumID: t.AlbumID})
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].key < s.entries[j].key })
+	s.stale.Store(false)
+}
+
+// lookup returns up to limit completions of prefix per kind. Callers must
+// hold albumsMu.
+func (s *suggestIndex) lookup(prefix string, limit int) map[string][]suggestion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stale.Load() {
+		s.rebuild()
+	}
+	start := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].key >= prefix })
+	var matches []suggestEntry
+	for _, e := range s.entries[start:] {
+		if !strings.HasPrefix(e.key, prefix) || len(matches) == maxSuggestScan {
+			break
+		}
+		matches = append(matches, e)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.wordStart != b.wordStart {
+			return !a.wordStart
+		}
+		if a.weight != b.weight {
+			return a.weight > b.weight
+		}
+		return a.key < b.key
+	})
+
+	result := map[string][]suggestion{"artists": {}, "albums": {}, "tracks": {}}
+	seen := map[string]bool{}
+	for _, e := range matches {
+		group := e.kind + "s"
+		if seen[e.kind+"/"+e.id] || len(result[group]) == limit {
+			continue
+		}
+		seen[e.kind+"/"+e.id] = true
+		result[group] = append(result[group], s.names[e.kind+"/"+e.id])
+	}
+	return result
+}
+
+// getSuggestions completes a partial query with matching artists, albums
+// and tracks, matching the start of any word of their names.
+func getSuggestions(c *gin.Context) {
+	q := strings.Join(strings.Fields(strings.ToLower(c.Query("q"))), " ")
+	if q == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "q is required"})
+		return
+	}
+	limit := defaultSuggestions
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestions {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "limit must be between 1 and " + strconv.Itoa(maxSuggestions)})
+			return
+		}
+		limit = n
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	c.IndentedJSON(http.StatusOK, suggestions.lookup(q, limit))
+}
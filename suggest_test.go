@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetSuggestions(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	albums = []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane"},
+		{ID: "2", Title: "Giant Steps", Artist: "John Coltrane"},
+		{ID: "3", Title: "Jeru", Artist: "Gerry Mulligan"},
+	}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Blue Train", Artist: "John Coltrane"},
+		{ID: "2", AlbumID: "2", Title: "Giant Steps", Artist: "John Coltrane"},
+	}
+	libStats = computeLibraryStats()
+	var response map[string][]suggestion
+
+	// Check names match from the start of any word
+	rr := performRequest(router, "GET", "/search/suggest?q=Colt", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response["artists"]) != 1 || response["artists"][0].ID != "john-coltrane" {
+		t.Errorf("Expected John Coltrane, but got %+v", response)
+	}
+
+	// Check albums and tracks are completed and limited
+	rr = performRequest(router, "GET", "/search/suggest?q=g&limit=1", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response["albums"]) != 1 || len(response["tracks"]) != 1 || len(response["artists"]) != 1 {
+		t.Errorf("Expected one of each kind, but got %+v", response)
+	}
+
+	// Check the index follows library changes
+	performRequest(router, "POST", "/albums", strings.NewReader(`{"title": "Kind of Blue", "artist": "Miles Davis"}`), "")
+	rr = performRequest(router, "GET", "/search/suggest?q=kind", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response["albums"]) != 1 || response["albums"][0].Artist != "Miles Davis" {
+		t.Errorf("Expected Kind of Blue, but got %+v", response)
+	}
+
+	// Check an empty query is rejected
+	if rr := performRequest(router, "GET", "/search/suggest?q=+", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxTagLength = 40
+
+var errBadTag = errors.New("tags must be 1-40 characters of letters, digits, spaces, '-' or '_'")
+
+// normalizeTag lower-cases and trims a tag so "Vinyl-Rip " and "vinyl-rip"
+// are the same tag.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLength {
+		return "", errBadTag
+	}
+	for _, r := range tag {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == ' ') {
+			return "", errBadTag
+		}
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes every tag and drops duplicates, keeping order.
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !hasTag(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// putAlbumTag adds a tag to an album; adding a tag twice is not an error.
+func putAlbumTag(c *gin.Context) {
+	updateAlbumTags(c, func(tags []string, tag string) []string {
+		if hasTag(tags, tag) {
+			return tags
+		}
+		return append(tags, tag)
+	})
+}
+
+func deleteAlbumTag(c *gin.Context) {
+	updateAlbumTags(c, func(tags []string, tag string) []string {
+		kept := []string{}
+		for _, t := range tags {
+			if t != tag {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+}
+
+func updateAlbumTags(c *gin.Context, update func(tags []string, tag string) []string) {
+	tag, err := normalizeTag(c.Param("tag"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if guestTag != "" && tag == guestTag && !slices.Contains(adminUsers, c.GetString(gin.AuthUserKey)) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": "only admins may change what guests see"})
+		return
+	}
+
+	albumsMu.Lock()
+	i := findAlbum(c.Param("id"))
+	if i < 0 {
+		albumsMu.Unlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	before := albums[i]
+	albums[i].Tags = update(append([]string(nil), albums[i].Tags...), tag)
+	if len(albums[i].Tags) == 0 {
+		albums[i].Tags = nil
+	}
+	updated := albums[i]
+	logOperation(c, "album.tags", []albumVersions{{Before: &before, After: &updated}}, nil)
+	albumsMu.Unlock()
+
+	ctx := c.Request.Context()
+	publishEvent(ctx, topicAlbumUpdated, albumChange{Album: updated})
+	publishEvent(ctx, topicCacheInvalidation, nil)
+	c.IndentedJSON(http.StatusOK, updated)
+}
+
+type tagCount struct {
+	Tag    string `json:"tag"`
+	Albums int    `json:"albums"`
+}
+
+// getTags lists tags in use with how many albums carry them, most used
+// first. ?prefix= narrows the list for autocomplete and ?limit= caps it.
+func getTags(c *gin.Context) {
+	prefix := strings.ToLower(strings.TrimSpace(c.Query("prefix")))
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	albumsMu.RLock()
+	counts := make(map[string]int)
+	for _, a := range albums {
+		for _, tag := range a.Tags {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+	albumsMu.RUnlock()
+
+	tags := []tagCount{}
+	for tag, n := range counts {
+		tags = append(tags, tagCount{Tag: tag, Albums: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Albums != tags[j].Albums {
+			return tags[i].Albums > tags[j].Albums
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	c.IndentedJSON(http.StatusOK, tags)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Tags can be added and removed, and filter the album listing
+func TestAlbumTags(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Check tagging needs a user and a valid tag
+	if rr := performRequest(router, "PUT", "/albums/1/tags/workout", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/albums/1/tags/no%21", nil, "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Tag two albums, using different spellings of the same tag
+	performRequest(router, "PUT", "/albums/1/tags/Workout", nil, "bob")
+	performRequest(router, "PUT", "/albums/1/tags/workout", nil, "bob")
+	performRequest(router, "PUT", "/albums/2/tags/workout", nil, "bob")
+	performRequest(router, "PUT", "/albums/2/tags/vinyl-rip", nil, "bob")
+
+	// Check tags were normalized and not duplicated
+	if tags := albums[findAlbum("1")].Tags; len(tags) != 1 || tags[0] != "workout" {
+		t.Errorf("Expected tags [workout], but got %v", tags)
+	}
+
+	// Check filtering by two tags only returns album 2
+	rr := performRequest(router, "GET", "/albums?tag=workout&tag=vinyl-rip", nil, "")
+	var listed []album
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "2" {
+		t.Errorf("Expected only album 2, but got %v", listed)
+	}
+
+	// Remove a tag and check the filter follows
+	performRequest(router, "DELETE", "/albums/1/tags/workout", nil, "bob")
+	rr = performRequest(router, "GET", "/albums?tag=workout", nil, "")
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "2" {
+		t.Errorf("Expected only album 2, but got %v", listed)
+	}
+}
+
+// Autocomplete returns matching tags, most used first
+func TestGetTagsAutocomplete(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Tag albums so "work" is used twice and "world" once
+	performRequest(router, "PUT", "/albums/1/tags/work", nil, "bob")
+	performRequest(router, "PUT", "/albums/2/tags/work", nil, "bob")
+	performRequest(router, "PUT", "/albums/3/tags/world", nil, "bob")
+	performRequest(router, "PUT", "/albums/3/tags/jazz", nil, "bob")
+
+	// Check the prefix narrows and orders the suggestions
+	rr := performRequest(router, "GET", "/tags?prefix=wor", nil, "")
+	var tags []tagCount
+	json.Unmarshal(rr.Body.Bytes(), &tags)
+	if len(tags) != 2 || tags[0] != (tagCount{Tag: "work", Albums: 2}) || tags[1].Tag != "world" {
+		t.Errorf("Expected work then world, but got %v", tags)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// thumbnailSizes are the cover sizes generated ahead of time, as the length
+// of the longer side in pixels.
+var thumbnailSizes = []int{64, 150, 300, 600}
+
+// thumbnailDir is set from configuration at startup; empty disables
+// thumbnails. Thumbnails are stored by the checksum of their cover, so
+// covers shared by albums are only scaled once and changed covers get new
+// files.
+var thumbnailDir string
+
+var errNoWebP = errors.New("webp covers need ffmpeg, which is not configured")
+
+// thumbnailStats counts thumbnail requests served from disk (hits) and
+// those that had to be generated first (misses).
+var thumbnailStats struct {
+	hits, misses, generated atomic.Int64
+}
+
+// coverFormats maps the ?format values of the cover endpoint to the
+// extension of the files they are stored in.
+var coverFormats = map[string]string{"jpeg": ".jpg", "webp": ".webp"}
+
+// thumbnailPath names the file of a cover in a size and format; size 0 is
+// the cover at its own size.
+func thumbnailPath(sum string, size int, format string) string {
+	name := sum + "-" + strconv.Itoa(size)
+	if size == 0 {
+		name = sum + "-full"
+	}
+	return filepath.Join(thumbnailDir, sum[:2], name+coverFormats[format])
+}
+
+// scaleImage shrinks src so its longer side is at most size, averaging the
+// source pixels each new pixel covers. Smaller images are kept as they are.
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	dw, dh := size, max(1, h*size/w)
+	if h > w {
+		dw, dh = max(1, w*size/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// writeThumbnail scales a cover to size, or keeps its size when size is 0,
+// and stores it at path.
+func writeThumbnail(cover image.Image, size int, path string) error {
+	if size > 0 {
+		cover = scaleImage(cover, size)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, cover, &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	thumbnailStats.generated.Add(1)
+	return os.Rename(tmp, path)
+}
+
+// ensureThumbnails generates the given sizes of a cover that are not on
+// disk yet, returning the cover's checksum.
+func ensureThumbnails(coverPath string, sizes []int) (string, error) {
+	sum, _, err := sha256File(coverPath)
+	if err != nil {
+		return "", err
+	}
+	var cover image.Image
+	for _, size := range sizes {
+		path := thumbnailPath(sum, size, "jpeg")
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if cover == nil {
+			f, err := os.Open(coverPath)
+			if err != nil {
+				return "", err
+			}
+			cover, _, err = image.Decode(f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+		if err := writeThumbnail(cover, size, path); err != nil {
+			return "", err
+		}
+	}
+	return sum, nil
+}
+
+// ensureCoverVariant returns the file of a cover in a size and format,
+// generating it when it is not on disk yet. The standard library cannot
+// encode WebP, so WebP files are converted from the JPEG ones by ffmpeg.
+func ensureCoverVariant(ctx context.Context, coverPath string, size int, format string) (string, error) {
+	sum, err := ensureThumbnails(coverPath, []int{size})
+	if err != nil || format == "jpeg" {
+		return thumbnailPath(sum, size, "jpeg"), err
+	}
+	path := thumbnailPath(sum, size, format)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if transcoder == "" {
+		return "", errNoWebP
+	}
+	var stderr bytes.Buffer
+	tmp := path + ".tmp"
+	cmd := exec.CommandContext(ctx, transcoder, "-hide_banner", "-nostats", "-loglevel", "error", "-y",
+		"-i", thumbnailPath(sum, size, "jpeg"), "-c:v", "libwebp", "-quality", "80", "-f", "webp", tmp)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("ffmpeg: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	thumbnailStats.generated.Add(1)
+	return path, os.Rename(tmp, path)
+}
+
+// generateThumbnails pre-generates every missing thumbnail of every album
+// cover and fills in the albums' placeholders. It is run by the scheduler
+// and after library imports.
+func generateThumbnails(ctx context.Context) error {
+	return warmCovers(ctx, nil)
+}
+
+// warmCovers does the work of generateThumbnails, reporting its progress
+// to step when it is the startup warm-up's.
+func warmCovers(ctx context.Context, step *warmupStep) error {
+	albumsMu.RLock()
+	covers := map[string]string{}
+	for _, a := range albums {
+		if path := albumCoverFile(a.ID); path != "" {
+			covers[a.ID] = path
+		}
+	}
+	albumsMu.RUnlock()
+
+	hashes := map[string]string{}
+	done := 0
+	for id, path := range covers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		step.progress(done, len(covers))
+		done++
+		if thumbnailDir != "" {
+			if _, err := ensureThumbnails(path, thumbnailSizes); err != nil {
+				log.Printf("thumbnails: %s: %v", path, err)
+			}
+		}
+		hash, err := coverBlurhash(path)
+		if err != nil {
+			log.Printf("thumbnails: %s: %v", path, err)
+			continue
+		}
+		hashes[id] = hash
+	}
+	setBlurhashes(hashes)
+	return nil
+}
+
+// setBlurhashes stores the placeholders of albums whose cover changed, and
+// publishes those albums for the other instances.
+func setBlurhashes(hashes map[string]string) {
+	albumsMu.Lock()
+	var changed []album
+	for id, hash := range hashes {
+		if i := findAlbum(id); i >= 0 && albums[i].Blurhash != hash {
+			albums[i].Blurhash = hash
+			changed = append(changed, albums[i])
+		}
+	}
+	albumsMu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	ctx := context.Background()
+	for _, a := range changed {
+		publishEvent(ctx, topicAlbumUpdated, albumChange{Album: a})
+	}
+	publishEvent(ctx, topicCacheInvalidation, nil)
+}
+
+// serveCoverVariant sends a cover scaled to ?size and encoded as ?format,
+// generating it first when the background job has not yet. With
+// ?blurhash=true it sends the cover's placeholder instead, also storing it
+// on the album unless albumID is empty.
+func serveCoverVariant(c *gin.Context, albumID, coverPath string) {
+	if c.Query("blurhash") == "true" {
+		hash, err := coverBlurhash(coverPath)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		if albumID != "" {
+			setBlurhashes(map[string]string{albumID: hash})
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"blurhash": hash})
+		return
+	}
+
+	size := 0
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(thumbnailSizes, n) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "size must be one of 64, 150, 300 or 600"})
+			return
+		}
+		size = n
+	}
+	format := c.DefaultQuery("format", "jpeg")
+	if _, ok := coverFormats[format]; !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "format must be one of jpeg or webp"})
+		return
+	}
+	if thumbnailDir == "" {
+		c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": "thumbnails are not configured"})
+		return
+	}
+	sum, _, err := sha256File(coverPath)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album has no cover file"})
+		return
+	}
+	path := thumbnailPath(sum, size, format)
+	if _, err := os.Stat(path); err == nil {
+		thumbnailStats.hits.Add(1)
+	} else {
+		thumbnailStats.misses.Add(1)
+		if path, err = ensureCoverVariant(c.Request.Context(), coverPath, size, format); errors.Is(err, errNoWebP) {
+			c.IndentedJSON(http.StatusNotImplemented, gin.H{"message": err.Error()})
+			return
+		} else if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	serveMedia(c, path)
+}
+
+// getThumbnailStats reports how often thumbnails were ready when asked for.
+func getThumbnailStats(c *gin.Context) {
+	hits, misses := thumbnailStats.hits.Load(), thumbnailStats.misses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"hits":      hits,
+		"misses":    misses,
+		"hitRate":   hitRate,
+		"generated": thumbnailStats.generated.Load(),
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnails(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	dir := t.TempDir()
+	savedDir := thumbnailDir
+	thumbnailDir = filepath.Join(dir, "thumbs")
+	t.Cleanup(func() { thumbnailDir = savedDir })
+	thumbnailStats.hits.Store(0)
+	thumbnailStats.misses.Store(0)
+
+	cover := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		for y := 0; y < 400; y++ {
+			cover.Set(x, y, color.RGBA{200, 0, 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, cover)
+	os.WriteFile(filepath.Join(dir, "cover.png"), buf.Bytes(), 0o644)
+	albums = []album{{ID: "1", Title: "Blue Train"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Path: filepath.Join(dir, "01.flac")}}
+
+	// Check the job generates every size once, keeping the aspect ratio
+	if err := generateThumbnails(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	generated := thumbnailStats.generated.Load()
+	generateThumbnails(context.Background())
+	if thumbnailStats.generated.Load() != generated {
+		t.Errorf("Expected a second run to generate nothing")
+	}
+	rr := performRequest(router, "GET", "/albums/1/cover?size=150", nil, "")
+	img, err := jpeg.Decode(rr.Body)
+	if err != nil || img.Bounds().Dx() != 150 || img.Bounds().Dy() != 75 {
+		t.Fatalf("Expected a 150x75 thumbnail, but got %v %v", img, err)
+	}
+	if r, _, _, _ := img.At(75, 37).RGBA(); r>>8 < 180 {
+		t.Errorf("Expected a red thumbnail, but got %v", img.At(75, 37))
+	}
+
+	// Check missing thumbnails are generated on demand and counted as misses
+	os.RemoveAll(thumbnailDir)
+	rr = performRequest(router, "GET", "/albums/1/cover?size=64", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	rr = performRequest(router, "GET", "/admin/thumbnails", nil, "alice")
+	var stats struct {
+		Hits, Misses int
+		HitRate      float64
+	}
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 {
+		t.Errorf("Expected one hit and one miss, but got %+v", stats)
+	}
+
+	if rr := performRequest(router, "GET", "/albums/1/cover?size=99", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check ?format=jpeg without a size re-encodes the whole cover
+	rr = performRequest(router, "GET", "/albums/1/cover?format=jpeg", nil, "")
+	if img, err := jpeg.Decode(rr.Body); err != nil || img.Bounds().Dx() != 800 {
+		t.Errorf("Expected an 800 pixel wide JPEG, but got %v", err)
+	}
+
+	// Check webp needs ffmpeg and unknown formats are refused
+	savedTranscoder := transcoder
+	transcoder = ""
+	t.Cleanup(func() { transcoder = savedTranscoder })
+	if rr := performRequest(router, "GET", "/albums/1/cover?size=64&format=webp", nil, ""); rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotImplemented, rr.Code)
+	}
+	if rr := performRequest(router, "GET", "/albums/1/cover?format=gif", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCoverBlurhash(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	dir := t.TempDir()
+
+	cover := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			cover.Set(x, y, color.RGBA{0, 0, 200, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, cover)
+	os.WriteFile(filepath.Join(dir, "cover.png"), buf.Bytes(), 0o644)
+	albums = []album{{ID: "1", Title: "Blue Train"}}
+	tracks = []track{{ID: "1", AlbumID: "1", Path: filepath.Join(dir, "01.flac")}}
+
+	// Check the cover endpoint returns the placeholder
+	rr := performRequest(router, "GET", "/albums/1/cover?blurhash=true", nil, "")
+	var got struct{ Blurhash string }
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Blurhash) != 28 {
+		t.Fatalf("Expected a 28 character blurhash, but got %d %s", rr.Code, rr.Body)
+	}
+
+	// Check the album JSON carries it after the job runs, on every instance
+	albums[0].Blurhash = ""
+	published := recordEvents(t, topicAlbumUpdated)
+	if err := generateThumbnails(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var change albumChange
+	if len(*published) != 1 || json.Unmarshal((*published)[0].Payload, &change) != nil || change.Album.Blurhash != got.Blurhash {
+		t.Errorf("Expected the blurhash published, but got %v", *published)
+	}
+	rr = performRequest(router, "GET", "/albums/1", nil, "")
+	var a album
+	json.Unmarshal(rr.Body.Bytes(), &a)
+	if a.Blurhash != got.Blurhash {
+		t.Errorf("Expected blurhash %q, but got %q", got.Blurhash, a.Blurhash)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type track struct {
+	ID      string `json:"id"`
+	AlbumID string `json:"albumId"`
+	Title   string `json:"title"`
+	// Artist is the track artist, which differs from the album artist on
+	// compilations.
+	Artist       string  `json:"artist"`
+	Disc         int     `json:"disc,omitempty"`
+	DiscSubtitle string  `json:"discSubtitle,omitempty"`
+	Number       int     `json:"number,omitempty"`
+	Duration     float64 `json:"duration,omitempty"`
+	Path         string  `json:"path,omitempty"`
+	// Analysis is filled in by the track-analysis job.
+	Analysis *trackAnalysis `json:"analysis,omitempty"`
+	// Moods are tagged from the analysis; see moods.go.
+	Moods []string `json:"moods,omitempty"`
+	// Lyrics are synced when every line has a time.
+	Lyrics []lyricLine `json:"lyrics,omitempty"`
+	// Size is the file size in bytes and Bitrate is in kbps.
+	Size    int64      `json:"size,omitempty"`
+	Format  string     `json:"format,omitempty"`
+	Bitrate int        `json:"bitrate,omitempty"`
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+	// Chapters divide long-form audio such as audiobooks and DJ mixes.
+	Chapters []chapter `json:"chapters,omitempty"`
+	// Cues are where a DJ mixes the track in and out.
+	Cues *cuePoints `json:"cues,omitempty"`
+	// WorkID groups the versions of a recording, such as a remaster or a
+	// live take, under the id of one of them; see versions.go. Edition
+	// says which version this is.
+	WorkID  string `json:"workId,omitempty"`
+	Edition string `json:"edition,omitempty"`
+	// AddedBy is the user who uploaded or downloaded the track's file, whose
+	// storage quota it counts against.
+	AddedBy string `json:"addedBy,omitempty"`
+	// SHA256 is the checksum of the file's content when it is stored
+	// once for every track and upload with the same content; see
+	// objects.go.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// tracks and nextTrackID are guarded by albumsMu.
+var (
+	tracks      []track
+	nextTrackID = 1
+)
+
+// findTrack returns the index of the track with the given id, or -1.
+// Callers must hold albumsMu.
+func findTrack(id string) int {
+	return trackIndex.find(id, len(tracks), func(i int) string { return tracks[i].ID })
+}
+
+// sortTracks orders tracks by disc and then track number.
+func sortTracks(ts []track) {
+	sort.SliceStable(ts, func(i, j int) bool {
+		if ts[i].Disc != ts[j].Disc {
+			return ts[i].Disc < ts[j].Disc
+		}
+		return ts[i].Number < ts[j].Number
+	})
+}
+
+type disc struct {
+	Number   int     `json:"number"`
+	Subtitle string  `json:"subtitle,omitempty"`
+	Tracks   []track `json:"tracks"`
+}
+
+// getAlbumTracks lists an album's tracks grouped by disc. Tracks without a
+// disc number are treated as disc 1.
+func getAlbumTracks(c *gin.Context) {
+	id := c.Param("id")
+
+	albumsMu.RLock()
+	if findAlbum(id) < 0 {
+		albumsMu.RUnlock()
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+		return
+	}
+	var albumTracks []track
+	for _, t := range tracks {
+		if t.AlbumID == id {
+			albumTracks = append(albumTracks, t)
+		}
+	}
+	albumsMu.RUnlock()
+
+	sortTracks(albumTracks)
+	discs := []disc{}
+	for _, t := range albumTracks {
+		number := max(t.Disc, 1)
+		if len(discs) == 0 || discs[len(discs)-1].Number != number {
+			discs = append(discs, disc{Number: number})
+		}
+		d := &discs[len(discs)-1]
+		if d.Subtitle == "" {
+			d.Subtitle = t.DiscSubtitle
+		}
+		d.Tracks = append(d.Tracks, t)
+	}
+	c.IndentedJSON(http.StatusOK, discs)
+}
+
+// getTracks lists tracks, optionally filtered by album, by analysis ranges
+// such as ?bpmMin=120&bpmMax=128 and by a ?q query.
+func getTracks(c *gin.Context) {
+	filters, err := trackFilters(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	page, ok := keysetPage(c, tracks, func(t track) string { return t.ID },
+		func(t track) bool { return matchesAllTrack(t, filters) }, findTrack, limit, offset)
+	albumsMu.RUnlock()
+
+	if ok {
+		respondList(c, page)
+	}
+}
+
+// trackFilters builds the filters requested in the query string of a track
+// listing. Range filters only match analyzed tracks.
+func trackFilters(c *gin.Context) ([]func(track) bool, error) {
+	var filters []func(track) bool
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		match, err := trackQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, match)
+	}
+	if id := c.Query("albumId"); id != "" {
+		filters = append(filters, func(t track) bool { return t.AlbumID == id })
+	}
+	if key := c.Query("key"); key != "" {
+		filters = append(filters, func(t track) bool { return t.Analysis != nil && strings.EqualFold(t.Analysis.Key, key) })
+	}
+	ranges := []struct {
+		param string
+		value func(*trackAnalysis) float64
+		lower bool
+	}{
+		{"bpmMin", func(a *trackAnalysis) float64 { return a.BPM }, true},
+		{"bpmMax", func(a *trackAnalysis) float64 { return a.BPM }, false},
+		{"loudnessMin", func(a *trackAnalysis) float64 { return a.Loudness }, true},
+		{"loudnessMax", func(a *trackAnalysis) float64 { return a.Loudness }, false},
+	}
+	for _, r := range ranges {
+		v := c.Query(r.param)
+		if v == "" {
+			continue
+		}
+		bound, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", r.param)
+		}
+		value, lower := r.value, r.lower
+		op := map[bool]string{true: ">=", false: "<="}[lower]
+		field := strings.TrimSuffix(strings.TrimSuffix(r.param, "Min"), "Max")
+		addWarning(c, fmt.Sprintf("%s is deprecated, use q=%s%s%s instead", r.param, field, op, v))
+		filters = append(filters, func(t track) bool {
+			if t.Analysis == nil {
+				return false
+			}
+			if lower {
+				return value(t.Analysis) >= bound
+			}
+			return value(t.Analysis) <= bound
+		})
+	}
+	return filters, nil
+}
+
+func matchesAllTrack(t track, filters []func(track) bool) bool {
+	for _, f := range filters {
+		if !f(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func getTrackById(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, tracks[i])
+}
+
+// trackPatch holds the tags a PATCH request may change; nil fields are left
+// as they are.
+type trackPatch struct {
+	Title        *string  `json:"title"`
+	Artist       *string  `json:"artist"`
+	Disc         *int     `json:"disc"`
+	DiscSubtitle *string  `json:"discSubtitle"`
+	Number       *int     `json:"number"`
+	Duration     *float64 `json:"duration"`
+}
+
+// apply returns t with the patch applied.
+func (p trackPatch) apply(t track) track {
+	if p.Title != nil {
+		t.Title = *p.Title
+	}
+	if p.Artist != nil {
+		t.Artist = *p.Artist
+	}
+	if p.Disc != nil {
+		t.Disc = *p.Disc
+	}
+	if p.DiscSubtitle != nil {
+		t.DiscSubtitle = *p.DiscSubtitle
+	}
+	if p.Number != nil {
+		t.Number = *p.Number
+	}
+	if p.Duration != nil {
+		t.Duration = *p.Duration
+	}
+	return t
+}
+
+func (p trackPatch) validate() error {
+	if p.Title != nil && strings.TrimSpace(*p.Title) == "" {
+		return fmt.Errorf("title must not be empty")
+	}
+	if p.Disc != nil && *p.Disc < 0 || p.Number != nil && *p.Number < 0 || p.Duration != nil && *p.Duration < 0 {
+		return fmt.Errorf("disc, number and duration must not be negative")
+	}
+	return nil
+}
+
+func patchTrack(c *gin.Context) {
+	var patch trackPatch
+
+	if err := c.BindJSON(&patch); err != nil {
+		return
+	}
+	if err := patch.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	before, updated := tracks[i], patch.apply(tracks[i])
+	libStats.replaceTrack(tracks[i], updated)
+	tracks[i] = updated
+	logOperation(c, "track.update", nil, []trackVersions{{Before: &before, After: &updated}})
+	c.IndentedJSON(http.StatusOK, updated)
+}
+
+func dumpTracks() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(tracks, "", "    ")
+}
+
+func restoreTracks(data []byte) (func(), int, error) {
+	var restored []track
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	next := 1
+	seen := make(map[string]bool)
+	for _, t := range restored {
+		if t.ID == "" || seen[t.ID] {
+			return nil, 0, fmt.Errorf("missing or duplicate track id %q", t.ID)
+		}
+		seen[t.ID] = true
+		if n, err := strconv.Atoi(t.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		tracks = restored
+		nextTrackID = next
+		libStats = computeLibraryStats()
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
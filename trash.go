@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashedTrack is a deleted track kept for restoring until its retention
+// runs out. TrashPath is where its file was moved, if it had one.
+type trashedTrack struct {
+	Track track `json:"track"`
+	// Album is set when the track's album was deleted with it.
+	Album     *album    `json:"album,omitempty"`
+	TrashPath string    `json:"trashPath,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// trash is guarded by albumsMu. trashRetention is set from configuration at
+// startup.
+var (
+	trash          []trashedTrack
+	trashRetention = 30 * 24 * time.Hour
+)
+
+// findTrashed returns the index of the trashed track with the given id, or
+// -1. Callers must hold albumsMu.
+func findTrashed(id string) int {
+	for i, t := range trash {
+		if t.Track.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// deleteTrack returns the handler that moves a track to the trash. Its file
+// is moved into dir; without a trash directory the file is left in place.
+func deleteTrack(dir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		i := findTrack(id)
+		if i < 0 {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+			return
+		}
+		before := tracks[i]
+		entry, err := trashTrack(before, nil, dir, c.GetString(gin.AuthUserKey))
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		logOperation(c, "track.delete", nil, []trackVersions{{Before: &before}})
+		c.IndentedJSON(http.StatusOK, entry)
+	}
+}
+
+// trashTrack moves a track to the trash, and its file into dir. a is the
+// track's album when it is deleted along with the track, so restoring the
+// track brings it back. Callers must hold albumsMu.
+func trashTrack(t track, a *album, dir, user string) (trashedTrack, error) {
+	entry := newTrashEntry(t, a, dir, user)
+	if entry.TrashPath != "" {
+		if err := moveToTrash(t.Path, entry.TrashPath); err != nil {
+			return entry, err
+		}
+	}
+	addToTrash(entry)
+	return entry, nil
+}
+
+// newTrashEntry describes t deleted by user, with the path in dir its file
+// goes to, if it has one.
+func newTrashEntry(t track, a *album, dir, user string) trashedTrack {
+	now := time.Now().UTC()
+	entry := trashedTrack{Track: t, Album: a, DeletedAt: now, DeletedBy: user, ExpiresAt: now.Add(trashRetention)}
+	if t.Path != "" && dir != "" {
+		entry.TrashPath = filepath.Join(dir, t.ID+"-"+filepath.Base(t.Path))
+	}
+	return entry
+}
+
+// addToTrash moves the entry's track from the catalog to the trash once its
+// file has been moved. Callers must hold albumsMu.
+func addToTrash(entry trashedTrack) {
+	if i := findTrack(entry.Track.ID); i >= 0 {
+		tracks = append(tracks[:i], tracks[i+1:]...)
+	}
+	libStats.removeTrack(entry.Track)
+	trash = append(trash, entry)
+}
+
+// moveToTrash moves a file into the trash directory. A file that is already
+// gone is not an error, so broken tracks can still be deleted.
+func moveToTrash(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// getTrash lists trashed tracks, most recently deleted first.
+func getTrash(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	newest := make([]trashedTrack, len(trash))
+	for i, t := range trash {
+		newest[len(trash)-1-i] = t
+	}
+	start, end := pageBounds(c, len(newest), limit, offset)
+	respondList(c, newest[start:end])
+}
+
+// restoreTrashed puts a trashed track and its file back where they were,
+// along with its album when that was deleted too.
+func restoreTrashed(c *gin.Context) {
+	// Other instances hear of a restored album once albumsMu is released.
+	var restored *album
+	defer func() {
+		if restored != nil {
+			publishEvent(c.Request.Context(), topicAlbumCreated, albumChange{Album: *restored})
+		}
+	}()
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrashed(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not in trash"})
+		return
+	}
+	entry := trash[i]
+	if findTrack(entry.Track.ID) >= 0 {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": "a track with this id exists"})
+		return
+	}
+	if entry.TrashPath != "" {
+		if _, err := os.Stat(entry.Track.Path); err == nil {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "a file exists at " + entry.Track.Path})
+			return
+		}
+		if err := moveToTrash(entry.TrashPath, entry.Track.Path); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	if entry.Album != nil && findAlbum(entry.Album.ID) < 0 {
+		albums = append(albums, *entry.Album)
+		libStats.addAlbum(*entry.Album)
+		restored = entry.Album
+	}
+	tracks = append(tracks, entry.Track)
+	libStats.addTrack(entry.Track)
+	trash = append(trash[:i], trash[i+1:]...)
+	c.IndentedJSON(http.StatusOK, entry.Track)
+}
+
+// purgeTrashed deletes a trashed track and its file for good.
+func purgeTrashed(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findTrashed(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not in trash"})
+		return
+	}
+	if err := removeTrashFile(trash[i]); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	delete(waveforms, trash[i].Track.ID)
+	trash = append(trash[:i], trash[i+1:]...)
+	c.Status(http.StatusNoContent)
+}
+
+// removeTrashFile deletes a trashed track's file, and lets go of its stored
+// content. Callers must hold albumsMu.
+func removeTrashFile(t trashedTrack) error {
+	if t.TrashPath == "" {
+		return nil
+	}
+	if err := os.Remove(t.TrashPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	releaseObject(t.Track.SHA256)
+	return nil
+}
+
+// purgeExpiredTrash deletes trashed tracks past their retention. It is run
+// by the scheduler.
+func purgeExpiredTrash(context.Context) error {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	now := time.Now()
+	kept := trash[:0]
+	failed := 0
+	for _, t := range trash {
+		if now.Before(t.ExpiresAt) {
+			kept = append(kept, t)
+			continue
+		}
+		if err := removeTrashFile(t); err != nil {
+			log.Printf("trash: track %s: %v", t.Track.ID, err)
+			kept = append(kept, t)
+			failed++
+			continue
+		}
+		delete(waveforms, t.Track.ID)
+	}
+	trash = kept
+	if failed > 0 {
+		return fmt.Errorf("%d trashed files could not be removed", failed)
+	}
+	return nil
+}
+
+func dumpTrash() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(trash, "", "    ")
+}
+
+func restoreTrash(data []byte) (func(), int, error) {
+	var restored []trashedTrack
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		trash = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	resetCatalog(t)
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.TrashDir = filepath.Join(root, ".trash")
+	router := setupRouter(cfg)
+	path := filepath.Join(root, "song.flac")
+	os.WriteFile(path, []byte("fLaC"), 0o644)
+	tracks = []track{{ID: "7", Title: "Song", Path: path}}
+
+	// Check deleting moves the file to the trash and hides the track
+	rr := performRequest(router, "DELETE", "/tracks/7", nil, "alice")
+	var entry trashedTrack
+	json.Unmarshal(rr.Body.Bytes(), &entry)
+	if rr.Code != http.StatusOK || len(tracks) != 0 || entry.DeletedBy != "alice" {
+		t.Fatalf("Expected the track to be trashed, but got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be moved away, but got %v", err)
+	}
+	if _, err := os.Stat(entry.TrashPath); err != nil {
+		t.Errorf("Expected the file in the trash, but got %v", err)
+	}
+
+	// Check the trash lists it and restoring puts everything back
+	rr = performRequest(router, "GET", "/library/trash", nil, "alice")
+	var listed []trashedTrack
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].Track.ID != "7" {
+		t.Errorf("Expected track 7 in the trash, but got %+v", listed)
+	}
+	performRequest(router, "POST", "/library/trash/7/restore", nil, "alice")
+	if _, err := os.Stat(path); err != nil || len(tracks) != 1 || len(trash) != 0 {
+		t.Errorf("Expected the track and file to be restored, but got %v %+v", err, tracks)
+	}
+
+	// Check expired entries are purged with their files
+	performRequest(router, "DELETE", "/tracks/7", nil, "alice")
+	trash[0].ExpiresAt = time.Now().Add(-time.Minute)
+	if err := purgeExpiredTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(entry.TrashPath); !os.IsNotExist(err) || len(trash) != 0 {
+		t.Errorf("Expected the trashed file to be removed, but got %v", err)
+	}
+}
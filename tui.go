@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// apiClient talks to a running server on behalf of the terminal UI and the
+// export and import commands.
+type apiClient struct {
+	base     string
+	user     string
+	password string
+	http     *http.Client
+}
+
+func (a *apiClient) do(method, path string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(raw)
+	}
+	resp, err := a.send(method, path, payload, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send makes a request with a body of the given type, and returns the
+// response for the caller to read and close when it succeeds.
+func (a *apiClient) send(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if a.user != "" {
+		req.SetBasicAuth(a.user, a.password)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, failure.Message)
+	}
+	return resp, nil
+}
+
+const (
+	viewAlbums = iota
+	viewTracks
+	viewQueue
+)
+
+// tuiRefresh is how often the UI refreshes the now-playing line.
+const tuiRefresh = time.Second
+
+type (
+	albumsMsg     []album
+	tracksMsg     []track
+	nowPlayingMsg nowPlaying
+	errMsg        struct{ error }
+	tickMsg       struct{}
+)
+
+// tuiModel is the bubbletea model of the terminal UI: a library browser and
+// the play queue, switched with tab, with the current track at the bottom.
+type tuiModel struct {
+	client *apiClient
+	view   int
+	cursor int
+	albums []album
+	album  album
+	tracks []track
+	now    nowPlaying
+	err    error
+	height int
+}
+
+func newTUIModel(client *apiClient) tuiModel {
+	return tuiModel{client: client, height: 24}
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return tea.Batch(m.loadAlbums, m.loadNowPlaying, tick())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(tuiRefresh, func(time.Time) tea.Msg { return tickMsg{} })
+}
+
+func (m tuiModel) loadAlbums() tea.Msg {
+	var albums []album
+	if err := m.client.do("GET", "/albums", nil, &albums); err != nil {
+		return errMsg{err}
+	}
+	return albumsMsg(albums)
+}
+
+func (m tuiModel) loadTracks(id string) tea.Cmd {
+	return func() tea.Msg {
+		var discs []disc
+		if err := m.client.do("GET", "/albums/"+id+"/tracks", nil, &discs); err != nil {
+			return errMsg{err}
+		}
+		var ts []track
+		for _, d := range discs {
+			ts = append(ts, d.Tracks...)
+		}
+		return tracksMsg(ts)
+	}
+}
+
+func (m tuiModel) loadNowPlaying() tea.Msg {
+	var now nowPlaying
+	if err := m.client.do("GET", "/player/now-playing", nil, &now); err != nil {
+		return errMsg{err}
+	}
+	return nowPlayingMsg(now)
+}
+
+// control sends a player request and then refreshes the now-playing line.
+func (m tuiModel) control(method, path string, body any) tea.Cmd {
+	return func() tea.Msg {
+		if err := m.client.do(method, path, body, nil); err != nil {
+			return errMsg{err}
+		}
+		return m.loadNowPlaying()
+	}
+}
+
+func (m tuiModel) enqueue(ts ...track) tea.Cmd {
+	ids := make([]string, len(ts))
+	for i, t := range ts {
+		ids[i] = t.ID
+	}
+	return m.control("POST", "/player/queue", map[string]any{"trackIds": ids})
+}
+
+// rows returns the number of entries in the current view.
+func (m tuiModel) rows() int {
+	switch m.view {
+	case viewAlbums:
+		return len(m.albums)
+	case viewTracks:
+		return len(m.tracks)
+	}
+	return len(m.now.NextUp)
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case albumsMsg:
+		m.albums = msg
+	case tracksMsg:
+		m.tracks, m.view, m.cursor = msg, viewTracks, 0
+	case nowPlayingMsg:
+		m.now, m.err = nowPlaying(msg), nil
+	case errMsg:
+		m.err = msg.error
+	case tickMsg:
+		return m, tea.Batch(m.loadNowPlaying, tick())
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+	return m, nil
+}
+
+func (m tuiModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		m.cursor = max(m.cursor-1, 0)
+	case "down", "j":
+		m.cursor = min(m.cursor+1, max(m.rows()-1, 0))
+	case "tab":
+		if m.view == viewQueue {
+			m.view = viewAlbums
+		} else {
+			m.view = viewQueue
+		}
+		m.cursor = 0
+	case "backspace", "esc":
+		m.view, m.cursor = viewAlbums, 0
+	case "enter":
+		switch {
+		case m.view == viewAlbums && m.cursor < len(m.albums):
+			m.album = m.albums[m.cursor]
+			return m, m.loadTracks(m.album.ID)
+		case m.view == viewTracks && m.cursor < len(m.tracks):
+			return m, m.enqueue(m.tracks[m.cursor])
+		}
+	case "a":
+		if m.view == viewTracks && len(m.tracks) > 0 {
+			return m, m.enqueue(m.tracks...)
+		}
+	case " ":
+		if m.now.State == statePlaying {
+			return m, m.control("POST", "/player/pause", nil)
+		}
+		return m, m.control("POST", "/player/play", nil)
+	case "n":
+		return m, m.control("POST", "/player/next", nil)
+	case "p":
+		return m, m.control("POST", "/player/previous", nil)
+	case "+", "=":
+		return m, m.control("PUT", "/player/volume", map[string]any{"volume": min(m.now.Volume+5, 100)})
+	case "-":
+		return m, m.control("PUT", "/player/volume", map[string]any{"volume": max(m.now.Volume-5, 0)})
+	case "c":
+		return m, m.control("DELETE", "/player/queue", nil)
+	}
+	return m, nil
+}
+
+func (m tuiModel) View() string {
+	var b strings.Builder
+	var lines []string
+	switch m.view {
+	case viewAlbums:
+		b.WriteString("Albums\n\n")
+		for _, a := range m.albums {
+			lines = append(lines, fmt.Sprintf("%s — %s", a.Title, a.Artist))
+		}
+	case viewTracks:
+		b.WriteString(m.album.Title + " — " + m.album.Artist + "\n\n")
+		for _, t := range m.tracks {
+			lines = append(lines, fmt.Sprintf("%2d. %s %s", t.Number, t.Title, formatDuration(t.Duration)))
+		}
+	case viewQueue:
+		b.WriteString("Up next\n\n")
+		for _, t := range m.now.NextUp {
+			lines = append(lines, fmt.Sprintf("%s — %s", t.Title, t.Artist))
+		}
+	}
+
+	// Scroll so the cursor stays within the space left by the header and
+	// the status lines.
+	visible := max(m.height-8, 1)
+	first := max(m.cursor-visible+1, 0)
+	for i := first; i < len(lines) && i < first+visible; i++ {
+		cursor := "  "
+		if i == m.cursor && m.view != viewQueue {
+			cursor = "> "
+		}
+		b.WriteString(cursor + lines[i] + "\n")
+	}
+
+	b.WriteString("\n" + m.statusLine() + "\n")
+	if m.err != nil {
+		b.WriteString("error: " + m.err.Error() + "\n")
+	}
+	b.WriteString("enter open/queue · a queue album · space play/pause · n/p next/prev · +/- volume · c clear · tab queue · q quit\n")
+	return b.String()
+}
+
+func (m tuiModel) statusLine() string {
+	if m.now.Track == nil {
+		return fmt.Sprintf("[%s] vol %d", m.now.State, m.now.Volume)
+	}
+	t := m.now.Track
+	return fmt.Sprintf("[%s] %s — %s %s/%s vol %d", m.now.State, t.Title, t.Artist,
+		formatDuration(m.now.Elapsed), formatDuration(t.Duration), m.now.Volume)
+}
+
+// formatDuration renders seconds as m:ss.
+func formatDuration(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// clientFlags adds the flags that say which server to talk to, and as whom,
+// and returns a function making the client once they are parsed.
+func clientFlags(flags *flag.FlagSet, timeout time.Duration) func() *apiClient {
+	base := flags.String("url", envString("MUSIC_PLAYER_URL", "http://localhost:8080"), "server URL")
+	user := flags.String("user", envString("MUSIC_PLAYER_USER", ""), "user name")
+	password := flags.String("password", envString("MUSIC_PLAYER_PASSWORD", ""), "password")
+	return func() *apiClient {
+		return &apiClient{
+			base:     strings.TrimSuffix(*base, "/"),
+			user:     *user,
+			password: *password,
+			http:     &http.Client{Timeout: timeout},
+		}
+	}
+}
+
+// runTUI starts the terminal UI against a running server. The server URL
+// and credentials come from flags, falling back to the environment.
+func runTUI(args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	client := clientFlags(flags, 10*time.Second)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	_, err := tea.NewProgram(newTUIModel(client()), tea.WithAltScreen()).Run()
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// press feeds a key to the model and runs the command it returns
+func press(t *testing.T, m tuiModel, key string) tuiModel {
+	msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+	switch key {
+	case "enter":
+		msg = tea.KeyMsg{Type: tea.KeyEnter}
+	case "down":
+		msg = tea.KeyMsg{Type: tea.KeyDown}
+	case " ":
+		msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+	}
+	model, cmd := m.Update(msg)
+	m = model.(tuiModel)
+	if cmd != nil {
+		model, _ = m.Update(cmd())
+		m = model.(tuiModel)
+	}
+	if m.err != nil {
+		t.Fatalf("Expected no error after %q, but got %v", key, m.err)
+	}
+	return m
+}
+
+// The TUI browses the library and controls the player through the API
+func TestTUI(t *testing.T) {
+	resetCatalog(t)
+	usePlayer(t)
+	server := httptest.NewServer(setupRouter(testConfig()))
+	defer server.Close()
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}, {ID: "2", Title: "Kind of Blue", Artist: "Miles Davis"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "2", Title: "So What", Number: 1, Duration: 562},
+		{ID: "2", AlbumID: "2", Title: "Freddie Freeloader", Number: 2, Duration: 589},
+	}
+
+	m := newTUIModel(&apiClient{base: server.URL, user: "bob", password: "bob-pw", http: http.DefaultClient})
+	model, _ := m.Update(m.loadAlbums())
+	m = model.(tuiModel)
+
+	// Check the album list is shown
+	if view := m.View(); !strings.Contains(view, "> Blue Train — John Coltrane") {
+		t.Errorf("Expected the album list, but got:\n%s", view)
+	}
+
+	// Check opening an album lists its tracks
+	m = press(t, m, "down")
+	m = press(t, m, "enter")
+	if m.view != viewTracks || len(m.tracks) != 2 {
+		t.Fatalf("Expected 2 tracks, but got %+v", m.tracks)
+	}
+
+	// Check the whole album can be queued and played
+	m = press(t, m, "a")
+	m = press(t, m, " ")
+	if m.now.State != statePlaying || m.now.Track == nil || m.now.Track.Title != "So What" {
+		t.Errorf("Expected So What to be playing, but got %+v", m.now)
+	}
+	if status := m.statusLine(); !strings.Contains(status, "So What") || !strings.Contains(status, "9:22") {
+		t.Errorf("Expected the status line to show the track, but got %q", status)
+	}
+	m = press(t, m, "n")
+	if m.now.Track == nil || m.now.Track.Title != "Freddie Freeloader" {
+		t.Errorf("Expected Freddie Freeloader after next, but got %+v", m.now.Track)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxUploadSize bounds the size of an uploaded file.
+	maxUploadSize = 2 << 30
+	// uploadRetention is how long an unfinished upload can be resumed.
+	uploadRetention = 24 * time.Hour
+)
+
+// audioExtensions are the kinds of audio files the library takes in.
+var audioExtensions = []string{"flac", "mp3", "m4a", "m4b", "aac", "ogg", "opus", "wav", "aiff"}
+
+// tagReader reads the tags of an audio file.
+type tagReader interface {
+	ReadTags(ctx context.Context, path string) (trackTags, error)
+}
+
+// tagExtractor is nil when there is nothing to read tags with, in which case
+// uploads are tagged from the request alone.
+var tagExtractor tagReader
+
+// ffmpegTagReader reads tags with ffmpeg, which understands ID3, Vorbis
+// comments and MP4 atoms alike.
+type ffmpegTagReader struct {
+	ffmpeg string
+}
+
+func (r ffmpegTagReader) ReadTags(ctx context.Context, path string) (trackTags, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.ffmpeg, "-hide_banner", "-nostats", "-loglevel", "error",
+		"-i", path, "-f", "ffmetadata", "-")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return trackTags{}, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseFFMetadataTags(&stdout), nil
+}
+
+// parseFFMetadataTags reads the global tags of ffmpeg's metadata format,
+// which come before the first section such as [CHAPTER].
+func parseFFMetadataTags(r io.Reader) trackTags {
+	var tags trackTags
+	// number reads "3" and "3/12" alike.
+	number := func(v string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(strings.Split(v, "/")[0]))
+		return n
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		key, value, ok := splitMetadataLine(line)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "title":
+			tags.Title = value
+		case "artist":
+			tags.Artist = value
+		case "album_artist", "albumartist":
+			tags.AlbumArtist = value
+		case "album":
+			tags.Album = value
+		case "track":
+			tags.Track = number(value)
+		case "disc":
+			tags.Disc = number(value)
+		case "date", "year":
+			tags.Year = number(strings.SplitN(value, "-", 2)[0])
+		case "genre":
+			tags.Genre = value
+		case "compilation":
+			tags.Compilation = value == "1"
+		case "publisher", "label":
+			tags.Label = value
+		case "catalognumber":
+			tags.CatalogNumber = value
+		}
+	}
+	return tags
+}
+
+// uploadSession is a chunked upload in progress, which can be resumed from
+// Offset after a dropped connection.
+type uploadSession struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+	// Tags win over those read from the file.
+	Tags      ingestTags `json:"tags"`
+	CreatedAt time.Time  `json:"createdAt"`
+	// busy is set while a chunk is written.
+	busy bool
+}
+
+// uploads is guarded by albumsMu.
+var uploads []uploadSession
+
+// findUpload returns the index of the user's upload with the given id, or
+// -1. Callers must hold albumsMu.
+func findUpload(id, owner string) int {
+	for i, u := range uploads {
+		if u.ID == id && u.Owner == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+func uploadPath(root, id string) string {
+	return filepath.Join(root, ".uploads", id)
+}
+
+// uploadFormat returns the format of an uploaded file from its name, or
+// false when it is not audio the library takes.
+func uploadFormat(filename string) (string, bool) {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	return format, slices.Contains(audioExtensions, format)
+}
+
+// errDuplicateUpload is returned with the track an upload duplicates, or
+// the quarantined file when the same file is already waiting for review.
+type errDuplicateUpload struct {
+	trackID      string
+	quarantineID string
+	reason       string
+}
+
+func (e errDuplicateUpload) Error() string {
+	if e.quarantineID != "" {
+		return "duplicate of quarantined file " + e.quarantineID + ": " + e.reason
+	}
+	return "duplicate of track " + e.trackID + ": " + e.reason
+}
+
+// findDuplicate returns the track that has the same file content as the
+// upload at path, or the same album, title and number as tags, or the
+// owner's quarantined file with the same content. The same content waiting
+// for review for other users is not a duplicate; it is stored once.
+func findDuplicate(path, owner string, tags trackTags) error {
+	sum, size, err := sha256File(path)
+	if err != nil {
+		return err
+	}
+	key := albumKey(tags.albumArtist(), tags.Album)
+
+	albumsMu.RLock()
+	for _, q := range quarantine {
+		if q.SHA256 == sum && q.Owner == owner {
+			albumsMu.RUnlock()
+			return errDuplicateUpload{quarantineID: q.ID, reason: "same file content"}
+		}
+	}
+	var sameSize []track
+	for _, t := range tracks {
+		if t.SHA256 == sum {
+			albumsMu.RUnlock()
+			return errDuplicateUpload{trackID: t.ID, reason: "same file content"}
+		}
+		if t.Path != "" && t.SHA256 == "" && t.Size == size {
+			sameSize = append(sameSize, t)
+		}
+		if !strings.EqualFold(t.Title, tags.Title) || t.Number != tags.Track {
+			continue
+		}
+		if i := findAlbum(t.AlbumID); i >= 0 && albumKey(albums[i].Artist, albums[i].Title) == key {
+			albumsMu.RUnlock()
+			return errDuplicateUpload{trackID: t.ID, reason: "same album, title and number"}
+		}
+	}
+	albumsMu.RUnlock()
+
+	// Only files of the same size can have the same content, so few are
+	// checksummed.
+	for _, t := range sameSize {
+		if other, _, err := sha256File(t.Path); err == nil && other == sum {
+			return errDuplicateUpload{trackID: t.ID, reason: "same file content"}
+		}
+	}
+	return nil
+}
+
+// addUpload tags the uploaded file at path, named filename by the client,
+// and puts it in quarantine under root until an admin approves it.
+func addUpload(ctx context.Context, root, path, filename, owner string, overrides ingestTags) (quarantinedFile, error) {
+	var tags trackTags
+	if tagExtractor != nil {
+		var err error
+		if tags, err = tagExtractor.ReadTags(ctx, path); err != nil {
+			return quarantinedFile{}, err
+		}
+	}
+	if v := strings.TrimSpace(overrides.Title); v != "" {
+		tags.Title = v
+	}
+	if v := strings.TrimSpace(overrides.Artist); v != "" {
+		tags.Artist = v
+	}
+	if v := strings.TrimSpace(overrides.Album); v != "" {
+		tags.Album = v
+	}
+	if tags.Title == "" {
+		tags.Title = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	tags.Format, _ = uploadFormat(filename)
+	if err := findDuplicate(path, owner, tags); err != nil {
+		return quarantinedFile{}, err
+	}
+	return quarantineFile(ctx, root, path, filename, "upload", owner, tags)
+}
+
+// respondUpload answers with the quarantined file an upload is kept as
+// until it is reviewed.
+func respondUpload(c *gin.Context, q quarantinedFile, err error) {
+	var dup errDuplicateUpload
+	switch {
+	case errors.As(err, &dup):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error(), "trackId": dup.trackID, "quarantineId": dup.quarantineID})
+	case err != nil:
+		c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{"message": err.Error()})
+	default:
+		c.Header("Location", "/quarantine/"+q.ID)
+		c.IndentedJSON(http.StatusAccepted, q)
+	}
+}
+
+// uploadTrack returns the handler that takes in the audio file uploaded as
+// the "file" form field, for review before it is added to the library
+// under root. The title, artist and album fields override the file's tags.
+func uploadTrack(root string, quotas storageQuotas) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		// Users whose quota is used up are turned away before the body is
+		// read.
+		user := c.GetString(gin.AuthUserKey)
+		if status, msg := quotas.check(user, 0); status != 0 {
+			c.IndentedJSON(status, gin.H{"message": msg})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize)
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "file is required"})
+			return
+		}
+		if status, msg := quotas.check(user, header.Size); status != 0 {
+			c.IndentedJSON(status, gin.H{"message": msg})
+			return
+		}
+		if _, ok := uploadFormat(header.Filename); !ok {
+			c.IndentedJSON(http.StatusUnsupportedMediaType, gin.H{"message": "file must be one of " + strings.Join(audioExtensions, ", ")})
+			return
+		}
+
+		path := uploadPath(root, newID())
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		if err := c.SaveUploadedFile(header, path); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		defer os.Remove(path)
+		overrides := ingestTags{Title: c.PostForm("title"), Artist: c.PostForm("artist"), Album: c.PostForm("album")}
+		q, err := addUpload(c.Request.Context(), root, path, header.Filename, c.GetString(gin.AuthUserKey), overrides)
+		respondUpload(c, q, err)
+	}
+}
+
+// postUpload starts a chunked upload of a file of the given size. Chunks
+// are sent with PATCH /tracks/uploads/:id and an Upload-Offset header, as
+// in the tus protocol.
+func postUpload(root string, quotas storageQuotas) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Filename string `json:"filename"`
+			Size     int64  `json:"size"`
+			ingestTags
+		}
+
+		if err := c.BindJSON(&input); err != nil {
+			return
+		}
+		if root == "" {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": errNoLibraryDir.Error()})
+			return
+		}
+		if _, ok := uploadFormat(input.Filename); !ok {
+			c.IndentedJSON(http.StatusUnsupportedMediaType, gin.H{"message": "filename must end in one of " + strings.Join(audioExtensions, ", ")})
+			return
+		}
+		if input.Size <= 0 || input.Size > maxUploadSize {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("size must be between 1 and %d bytes", int64(maxUploadSize))})
+			return
+		}
+		if status, msg := quotas.check(c.GetString(gin.AuthUserKey), input.Size); status != 0 {
+			c.IndentedJSON(status, gin.H{"message": msg})
+			return
+		}
+		u := uploadSession{
+			ID:        newID(),
+			Owner:     c.GetString(gin.AuthUserKey),
+			Filename:  filepath.Base(input.Filename),
+			Size:      input.Size,
+			Tags:      input.ingestTags,
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := os.MkdirAll(filepath.Dir(uploadPath(root, u.ID)), 0o755); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		if err := os.WriteFile(uploadPath(root, u.ID), nil, 0o644); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		albumsMu.Lock()
+		kept := uploads[:0]
+		for _, old := range uploads {
+			if time.Since(old.CreatedAt) > uploadRetention && !old.busy {
+				os.Remove(uploadPath(root, old.ID))
+				continue
+			}
+			kept = append(kept, old)
+		}
+		uploads = append(kept, u)
+		albumsMu.Unlock()
+
+		c.Header("Location", "/tracks/uploads/"+u.ID)
+		c.Header("Upload-Offset", "0")
+		c.IndentedJSON(http.StatusCreated, u)
+	}
+}
+
+// getUpload reports how much of an upload has arrived, for the client to
+// resume from.
+func getUpload(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findUpload(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "upload not found"})
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(uploads[i].Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(uploads[i].Size, 10))
+	c.IndentedJSON(http.StatusOK, uploads[i])
+}
+
+// patchUpload returns the handler that appends a chunk to an upload. The
+// Upload-Offset header must match what has arrived so far. Once the whole
+// file is there, it is quarantined for review like a single upload.
+func patchUpload(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Upload-Offset header is required"})
+			return
+		}
+
+		albumsMu.Lock()
+		i := findUpload(c.Param("id"), c.GetString(gin.AuthUserKey))
+		if i < 0 {
+			albumsMu.Unlock()
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "upload not found"})
+			return
+		}
+		u := uploads[i]
+		if u.busy || offset != u.Offset {
+			albumsMu.Unlock()
+			c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "upload is at offset " + strconv.FormatInt(u.Offset, 10)})
+			return
+		}
+		uploads[i].busy = true
+		albumsMu.Unlock()
+
+		// What was written of a chunk before the connection dropped counts,
+		// so the client resumes from there.
+		path := uploadPath(root, u.ID)
+		written, err := appendChunk(path, io.LimitReader(c.Request.Body, u.Size-u.Offset))
+		albumsMu.Lock()
+		if i = findUpload(u.ID, u.Owner); i >= 0 {
+			uploads[i].Offset += written
+			uploads[i].busy = false
+			u = uploads[i]
+			if u.Offset == u.Size {
+				uploads = append(uploads[:i], uploads[i+1:]...)
+			}
+		}
+		albumsMu.Unlock()
+		c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		if u.Offset < u.Size {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		defer os.Remove(path)
+		q, err := addUpload(c.Request.Context(), root, path, u.Filename, u.Owner, u.Tags)
+		respondUpload(c, q, err)
+	}
+}
+
+func appendChunk(path string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// deleteUpload returns the handler that abandons an upload.
+func deleteUpload(root string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		albumsMu.Lock()
+		defer albumsMu.Unlock()
+
+		i := findUpload(c.Param("id"), c.GetString(gin.AuthUserKey))
+		if i < 0 {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": "upload not found"})
+			return
+		}
+		if uploads[i].busy {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": "a chunk is being written"})
+			return
+		}
+		os.Remove(uploadPath(root, uploads[i].ID))
+		uploads = append(uploads[:i], uploads[i+1:]...)
+		c.Status(http.StatusNoContent)
+	}
+}
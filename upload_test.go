@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTagReader tags every file as the first track of an album.
+type fakeTagReader struct{}
+
+func (fakeTagReader) ReadTags(ctx context.Context, path string) (trackTags, error) {
+	return trackTags{Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Track: 1, Year: 1959}, nil
+}
+
+func TestParseFFMetadataTags(t *testing.T) {
+	metadata := ";FFMETADATA1\ntitle=Blue in Green\nartist=Miles Davis\nalbum_artist=Miles Davis\nalbum=Kind of Blue\ntrack=3/5\ndate=1959-08-17\npublisher=Columbia\n[CHAPTER]\ntitle=Intro\n"
+	tags := parseFFMetadataTags(strings.NewReader(metadata))
+
+	// Check global tags are read and chapters ignored
+	if tags.Title != "Blue in Green" || tags.Album != "Kind of Blue" || tags.Track != 3 || tags.Year != 1959 || tags.Label != "Columbia" {
+		t.Errorf("Expected the tags of Blue in Green, but got %+v", tags)
+	}
+}
+
+// multipartUpload builds a form uploading content as name, with fields.
+func multipartUpload(name string, content []byte, fields map[string]string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write(content)
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+// approveUpload approves a quarantined file as an admin.
+func approveUpload(t *testing.T, router http.Handler, id string) track {
+	t.Helper()
+	rr := performRequest(router, "POST", "/quarantine/"+id+"/approve", nil, "alice")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	var added track
+	json.Unmarshal(rr.Body.Bytes(), &added)
+	return added
+}
+
+func TestUploadTrack(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	upload := func(name string, content []byte, fields map[string]string) *httptest.ResponseRecorder {
+		body, contentType := multipartUpload(name, content, fields)
+		req, _ := http.NewRequest("POST", "/tracks/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		req.SetBasicAuth("bob", "bob-pw")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Check files that are not audio are refused
+	rr := upload("notes.txt", []byte("hello"), nil)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnsupportedMediaType, rr.Code)
+	}
+
+	// Check the file is tagged from the form and quarantined, then filed
+	rr = upload("take1.flac", []byte("fLaC one"), map[string]string{"artist": "Bill Evans", "album": "Sunday at the Village Vanguard"})
+	var q quarantinedFile
+	json.Unmarshal(rr.Body.Bytes(), &q)
+	if rr.Code != http.StatusAccepted || q.Tags.Title != "take1" || len(tracks) != 0 {
+		t.Errorf("Expected the upload to wait for review, but got %d %+v", rr.Code, q)
+	}
+	added := approveUpload(t, router, q.ID)
+	want := filepath.Join(root, "Bill Evans", "Sunday at the Village Vanguard", "take1.flac")
+	if added.Path != want || added.Title != "take1" {
+		t.Errorf("Expected the track filed at %s, but got %+v", want, added)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("Expected the file to exist, but got %v", err)
+	}
+
+	// Check the same content is detected as a duplicate
+	rr = upload("copy.flac", []byte("fLaC one"), map[string]string{"artist": "Someone", "album": "Else"})
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), added.ID) {
+		t.Errorf("Expected status code %d naming track %s, but got %d %s", http.StatusConflict, added.ID, rr.Code, rr.Body)
+	}
+
+	// Check tags are read from the file, and the same track is a duplicate
+	defer func(r tagReader) { tagExtractor = r }(tagExtractor)
+	tagExtractor = fakeTagReader{}
+	rr = upload("01.mp3", []byte("ID3 two"), nil)
+	json.Unmarshal(rr.Body.Bytes(), &q)
+	added = approveUpload(t, router, q.ID)
+	if added.Path != filepath.Join(root, "Miles Davis", "Kind of Blue", "01 So What.mp3") {
+		t.Errorf("Expected So What to be filed by its tags, but got %+v", added)
+	}
+	rr = upload("01.mp3", []byte("ID3 other rip"), nil)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	resetCatalog(t)
+	albums, tracks = nil, nil
+	root := t.TempDir()
+	cfg := testConfig()
+	cfg.LibraryDir = root
+	router := setupRouter(cfg)
+	content := []byte("fLaC the whole file")
+	chunk := func(link, offset string, data []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", link, bytes.NewReader(data))
+		req.Header.Set("Upload-Offset", offset)
+		req.SetBasicAuth("bob", "bob-pw")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Check an upload is started
+	rr := performRequest(router, "POST", "/tracks/uploads", strings.NewReader(`{"filename": "peace.flac", "size": 19, "artist": "Ornette Coleman", "album": "The Shape of Jazz to Come"}`), "bob")
+	link := rr.Header().Get("Location")
+	if rr.Code != http.StatusCreated || link == "" {
+		t.Fatalf("Expected status code %d with a location, but got %d", http.StatusCreated, rr.Code)
+	}
+
+	// Check the first chunk is stored and the offset reported
+	rr = chunk(link, "0", content[:8])
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("Expected offset 8, but got %d %q", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+
+	// Check a chunk at the wrong offset is refused, and the offset can be looked up
+	rr = chunk(link, "0", content)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, rr.Code)
+	}
+	rr = performRequest(router, "GET", link, nil, "bob")
+	if rr.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("Expected offset 8, but got %q", rr.Header().Get("Upload-Offset"))
+	}
+
+	// Check uploads are private to their owner
+	rr = performRequest(router, "GET", link, nil, "alice")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check the last chunk quarantines the file for review
+	rr = chunk(link, "8", content[8:])
+	var q quarantinedFile
+	json.Unmarshal(rr.Body.Bytes(), &q)
+	if rr.Code != http.StatusAccepted || q.Tags.Title != "peace" || q.Owner != "bob" {
+		t.Errorf("Expected the upload to wait for review, but got %d %+v", rr.Code, q)
+	}
+	if added := approveUpload(t, router, q.ID); added.Size != int64(len(content)) {
+		t.Errorf("Expected a track of %d bytes, but got %+v", len(content), added)
+	}
+	if len(uploads) != 0 {
+		t.Errorf("Expected the upload to be done, but got %+v", uploads)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trackWork returns the key of the work a track is a version of: its
+// WorkID when it is linked to other versions, or else its own id.
+func trackWork(t track) string {
+	if t.WorkID != "" {
+		return t.WorkID
+	}
+	return t.ID
+}
+
+// workOf returns the work of the track id, or the id itself for unknown
+// tracks. Callers must hold albumsMu.
+func workOf(id string) string {
+	if i := findTrack(id); i >= 0 {
+		return trackWork(tracks[i])
+	}
+	return id
+}
+
+// workVersions returns the positions of the tracks of a work. Callers must
+// hold albumsMu.
+func workVersions(work string) []int {
+	var found []int
+	for i, t := range tracks {
+		if trackWork(t) == work {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// oneVersionEach drops the ids that are versions of a work already listed,
+// or of one in skip. Callers must hold albumsMu.
+func oneVersionEach(ids []string, skip map[string]bool) []string {
+	seen := map[string]bool{}
+	for id := range skip {
+		seen[workOf(id)] = true
+	}
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if work := workOf(id); !seen[work] {
+			seen[work] = true
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// spreadVersions reorders ids so that versions of the same work are not
+// played back to back, moving each one that follows its own work to after
+// the next track of another. Callers must hold albumsMu.
+func spreadVersions(ids []string) []string {
+	order := append([]string(nil), ids...)
+	for i := 1; i < len(order); i++ {
+		if workOf(order[i]) != workOf(order[i-1]) {
+			continue
+		}
+		for j := i + 1; j < len(order); j++ {
+			if workOf(order[j]) != workOf(order[i-1]) {
+				order[i], order[j] = order[j], order[i]
+				break
+			}
+		}
+	}
+	return order
+}
+
+// getTrackVersions lists the versions of a track's work, the track first
+// and the others in catalog order.
+func getTrackVersions(c *gin.Context) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	i := findTrack(c.Param("id"))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	versions := []track{tracks[i]}
+	for _, j := range workVersions(trackWork(tracks[i])) {
+		if j != i {
+			versions = append(versions, tracks[j])
+		}
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"workId": trackWork(tracks[i]), "versions": versions})
+}
+
+// postTrackVersion links another track, with any versions it is already
+// linked to, to the work of a track, optionally naming its edition such
+// as "live" or "remaster".
+func postTrackVersion(c *gin.Context) {
+	var input struct {
+		TrackID string  `json:"trackId"`
+		Edition *string `json:"edition"`
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i, j := findTrack(c.Param("id")), findTrack(input.TrackID)
+	if i < 0 || j < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	if i == j {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "a track cannot be a version of itself"})
+		return
+	}
+	work := trackWork(tracks[i])
+	for _, k := range workVersions(trackWork(tracks[j])) {
+		tracks[k].WorkID = work
+	}
+	tracks[i].WorkID = work
+	if input.Edition != nil {
+		tracks[j].Edition = strings.TrimSpace(*input.Edition)
+	}
+	c.IndentedJSON(http.StatusOK, tracks[j])
+}
+
+// deleteTrackVersion unlinks a version from the work of a track. A work
+// left with one version is no longer a group.
+func deleteTrackVersion(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i, j := findTrack(c.Param("id")), findTrack(c.Param("versionId"))
+	if i < 0 || j < 0 || trackWork(tracks[i]) != trackWork(tracks[j]) || tracks[j].WorkID == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "version not found"})
+		return
+	}
+	work := tracks[j].WorkID
+	tracks[j].WorkID = ""
+	var left []int
+	for k, t := range tracks {
+		if t.WorkID == work {
+			left = append(left, k)
+		}
+	}
+	// The work is keyed by the id of a version, so when that version
+	// leaves, the rest take the id of another.
+	if tracks[j].ID == work && len(left) > 0 {
+		for _, k := range left {
+			tracks[k].WorkID = tracks[left[0]].ID
+		}
+	}
+	if len(left) == 1 {
+		tracks[left[0]].WorkID = ""
+	}
+	c.IndentedJSON(http.StatusOK, tracks[j])
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrackVersions(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", Title: "Blue Train"},
+		{ID: "2", Title: "Blue Train (Remastered)"},
+		{ID: "3", Title: "Blue Train (Live)"},
+		{ID: "4", Title: "Naima"},
+	}
+	versions := func(id string) (string, []string) {
+		rr := performRequest(router, "GET", "/tracks/"+id+"/versions", nil, "")
+		var response struct {
+			WorkID   string  `json:"workId"`
+			Versions []track `json:"versions"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		var ids []string
+		for _, v := range response.Versions {
+			ids = append(ids, v.ID)
+		}
+		return response.WorkID, ids
+	}
+
+	// Check versions are linked under one work, with their editions
+	rr := performRequest(router, "POST", "/tracks/1/versions", strings.NewReader(`{"trackId": "2", "edition": "remaster"}`), "alice")
+	if rr.Code != http.StatusOK || tracks[1].Edition != "remaster" {
+		t.Errorf("Expected track 2 to be linked as a remaster, but got %d %+v", rr.Code, tracks[1])
+	}
+	performRequest(router, "POST", "/tracks/3/versions", strings.NewReader(`{"trackId": "2"}`), "alice")
+	if work, ids := versions("2"); work != "3" || !reflect.DeepEqual(ids, []string{"2", "1", "3"}) {
+		t.Errorf("Expected work 3 with tracks 2, 1 and 3, but got %q %v", work, ids)
+	}
+	if work, ids := versions("4"); work != "4" || !reflect.DeepEqual(ids, []string{"4"}) {
+		t.Errorf("Expected track 4 on its own, but got %q %v", work, ids)
+	}
+
+	// Check bad links are rejected
+	for _, tc := range []struct {
+		method, path, body, user string
+		code                     int
+	}{
+		{"POST", "/tracks/1/versions", `{"trackId": "1"}`, "alice", http.StatusBadRequest},
+		{"POST", "/tracks/1/versions", `{"trackId": "9"}`, "alice", http.StatusNotFound},
+		{"POST", "/tracks/1/versions", `{"trackId": "4"}`, "bob", http.StatusForbidden},
+		{"DELETE", "/tracks/1/versions/4", "", "alice", http.StatusNotFound},
+	} {
+		if rr = performRequest(router, tc.method, tc.path, strings.NewReader(tc.body), tc.user); rr.Code != tc.code {
+			t.Errorf("Expected status code %d for %s %s, but got %d", tc.code, tc.method, tc.path, rr.Code)
+		}
+	}
+
+	// Check unlinking the version the work is keyed by keeps the rest together
+	performRequest(router, "DELETE", "/tracks/2/versions/3", nil, "alice")
+	if work, ids := versions("2"); work != "1" || !reflect.DeepEqual(ids, []string{"2", "1"}) {
+		t.Errorf("Expected work 1 with tracks 2 and 1, but got %q %v", work, ids)
+	}
+	if _, ids := versions("3"); !reflect.DeepEqual(ids, []string{"3"}) {
+		t.Errorf("Expected track 3 on its own, but got %v", ids)
+	}
+
+	// Check the last two versions come apart together
+	performRequest(router, "DELETE", "/tracks/2/versions/1", nil, "alice")
+	if tracks[0].WorkID != "" || tracks[1].WorkID != "" {
+		t.Errorf("Expected no links left, but got %q and %q", tracks[0].WorkID, tracks[1].WorkID)
+	}
+}
+
+func TestVersionsPlayedApart(t *testing.T) {
+	resetCatalog(t)
+	albums = []album{{ID: "1", Title: "Blue Train", Artist: "John Coltrane"}}
+	tracks = []track{
+		{ID: "1", AlbumID: "1", Title: "Blue Train", WorkID: "1"},
+		{ID: "2", AlbumID: "1", Title: "Blue Train (Alternate Take)", WorkID: "1"},
+		{ID: "3", AlbumID: "1", Title: "Moment's Notice"},
+		{ID: "4", AlbumID: "1", Title: "Lazy Bird"},
+	}
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	// Check shuffled versions are moved apart
+	if got := spreadVersions([]string{"1", "2", "3", "4"}); !reflect.DeepEqual(got, []string{"1", "3", "2", "4"}) {
+		t.Errorf("Expected 1, 3, 2, 4, but got %v", got)
+	}
+
+	// Check radio queues one version, and none of a work played recently
+	candidates := radioCandidates(radioSeed{Type: "track", Value: "2"}, nil)
+	if !reflect.DeepEqual(candidates, []string{"2", "3", "4"}) {
+		t.Errorf("Expected the seed and the other works, but got %v", candidates)
+	}
+	candidates = radioCandidates(radioSeed{Type: "artist", Value: "John Coltrane"}, map[string]bool{"1": true})
+	if !reflect.DeepEqual(candidates, []string{"3", "4"}) {
+		t.Errorf("Expected tracks 3 and 4, but got %v", candidates)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warmupStep fills one cache in the background at startup, so the first
+// requests after a restart do not pay for building it.
+type warmupStep struct {
+	name string
+	// required steps hold up readiness; the rest only report progress.
+	required    bool
+	run         func(ctx context.Context, step *warmupStep) error
+	done, total atomic.Int64
+	finished    atomic.Bool
+}
+
+// warmupStatus is how far a step has got, as /readyz reports it.
+type warmupStatus struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Done     int64  `json:"done"`
+	Total    int64  `json:"total"`
+	Finished bool   `json:"finished"`
+}
+
+// warmupSteps are set before the server starts and not changed after.
+// Without any, as in tests, the server is ready at once.
+var warmupSteps []*warmupStep
+
+// newWarmupSteps returns the caches warmed at startup: the id indexes and
+// search suggestions, which readiness waits for, then cover thumbnails and
+// placeholders, which are also made on demand and so are not waited for.
+func newWarmupSteps() []*warmupStep {
+	return []*warmupStep{
+		{name: "catalog", required: true, run: warmCatalogIndexes},
+		{name: "suggestions", required: true, run: warmSuggestions},
+		{name: "covers", run: warmCovers},
+	}
+}
+
+// progress records that done of total items are done. It does nothing on a
+// nil step, so jobs that are also run outside the warm-up can report
+// unconditionally.
+func (s *warmupStep) progress(done, total int) {
+	if s == nil {
+		return
+	}
+	s.done.Store(int64(done))
+	s.total.Store(int64(total))
+}
+
+// runWarmup runs the steps one after another. A failed step is logged and
+// counted as finished, so that it cannot keep the server unready.
+func runWarmup(steps []*warmupStep) func(context.Context) {
+	return func(ctx context.Context) {
+		for _, s := range steps {
+			if err := s.run(ctx, s); err != nil && ctx.Err() == nil {
+				log.Printf("warm-up: %s: %v", s.name, err)
+			}
+			s.done.Store(s.total.Load())
+			s.finished.Store(true)
+		}
+	}
+}
+
+// warmCatalogIndexes builds the album and track id indexes, which the
+// first lookup of each would otherwise build.
+func warmCatalogIndexes(ctx context.Context, step *warmupStep) error {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	step.progress(0, 2)
+	if len(albums) > 0 {
+		findAlbum(albums[len(albums)-1].ID)
+	}
+	step.progress(1, 2)
+	if len(tracks) > 0 {
+		findTrack(tracks[len(tracks)-1].ID)
+	}
+	step.progress(2, 2)
+	return nil
+}
+
+// warmSuggestions builds the search suggestion index.
+func warmSuggestions(ctx context.Context, step *warmupStep) error {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	step.progress(0, 1)
+	suggestions.mu.Lock()
+	if suggestions.stale.Load() {
+		suggestions.rebuild()
+	}
+	suggestions.mu.Unlock()
+	step.progress(1, 1)
+	return nil
+}
+
+// getReadyz answers 200 once the required warm-up steps have finished and
+// 503 until then, reporting every step's progress either way.
+func getReadyz(c *gin.Context) {
+	ready := true
+	status := make([]warmupStatus, len(warmupSteps))
+	for i, s := range warmupSteps {
+		status[i] = warmupStatus{Name: s.name, Required: s.required, Done: s.done.Load(), Total: s.total.Load(), Finished: s.finished.Load()}
+		if s.required && !status[i].Finished {
+			ready = false
+		}
+	}
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.IndentedJSON(code, gin.H{"ready": ready, "warmup": status})
+}
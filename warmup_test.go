@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	t.Cleanup(func() { warmupSteps = nil })
+
+	// Check the server is ready at once without a warm-up
+	if rr := performRequest(router, "GET", "/readyz", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check a required step holds up readiness and reports its progress
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	warmupSteps = []*warmupStep{
+		{name: "slow", required: true, run: func(ctx context.Context, step *warmupStep) error {
+			step.progress(3, 10)
+			close(blocked)
+			<-release
+			return errors.New("failed")
+		}},
+		{name: "optional", run: func(ctx context.Context, step *warmupStep) error { <-ctx.Done(); return nil }},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runWarmup(warmupSteps)(ctx)
+		close(done)
+	}()
+	<-blocked
+	rr := performRequest(router, "GET", "/readyz", nil, "")
+	var got struct {
+		Ready  bool
+		Warmup []warmupStatus
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusServiceUnavailable || got.Ready || got.Warmup[0].Done != 3 || got.Warmup[0].Total != 10 {
+		t.Errorf("Expected 503 with step progress, but got %d %s", rr.Code, rr.Body)
+	}
+
+	// Check a failed required step still lets the server become ready,
+	// without waiting for optional ones
+	close(release)
+	for rr.Code != http.StatusOK {
+		rr = performRequest(router, "GET", "/readyz", nil, "")
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if !got.Warmup[0].Finished || got.Warmup[1].Finished {
+		t.Errorf("Expected only the first step finished, but got %s", rr.Body)
+	}
+	cancel()
+	<-done
+}
+
+func TestWarmupSteps(t *testing.T) {
+	resetCatalog(t)
+	tracks = []track{{ID: "1", AlbumID: "1", Title: "Moment's Notice"}}
+	suggestions.stale.Store(true)
+
+	// Check the real steps build the indexes
+	steps := newWarmupSteps()
+	runWarmup(steps)(context.Background())
+	if suggestions.stale.Load() {
+		t.Errorf("Expected the suggestion index to be built")
+	}
+	if steps[0].done.Load() != 2 || !steps[2].finished.Load() {
+		t.Errorf("Expected every step finished")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os/exec"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// waveformPoints is how many peaks are stored per track.
+	waveformPoints = 1000
+	// waveformRate is the sample rate audio is decoded at for peaks; peaks
+	// do not need more detail than this.
+	waveformRate = 8000
+	// waveformBlock is how many decoded samples are reduced to one peak
+	// while reading, before the peaks are resampled to waveformPoints.
+	waveformBlock = 100
+)
+
+// waveforms holds peak data by track id, kept apart from the tracks so
+// listings stay small. It is guarded by albumsMu.
+var waveforms = map[string][]float64{}
+
+// waveformAnalyzer decodes a file to mono PCM with ffmpeg and reduces it to
+// peaks between 0 and 1.
+type waveformAnalyzer struct {
+	ffmpeg string
+}
+
+func (w waveformAnalyzer) Analyze(ctx context.Context, path string) (trackAnalysis, error) {
+	cmd := exec.CommandContext(ctx, w.ffmpeg, "-hide_banner", "-nostats", "-loglevel", "error",
+		"-i", path, "-ac", "1", "-ar", strconv.Itoa(waveformRate), "-f", "s16le", "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return trackAnalysis{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return trackAnalysis{}, fmt.Errorf("ffmpeg: %w", err)
+	}
+	peaks, readErr := readPeaks(bufio.NewReader(stdout))
+	if err := cmd.Wait(); err != nil {
+		return trackAnalysis{}, fmt.Errorf("ffmpeg: %w", err)
+	}
+	if readErr != nil {
+		return trackAnalysis{}, readErr
+	}
+	return trackAnalysis{Peaks: resamplePeaks(peaks, waveformPoints)}, nil
+}
+
+// readPeaks reads signed 16-bit little-endian samples and returns the
+// largest magnitude of every waveformBlock samples.
+func readPeaks(r io.Reader) ([]float64, error) {
+	var peaks []float64
+	var sample int16
+	peak, n := 0.0, 0
+	for {
+		if err := binary.Read(r, binary.LittleEndian, &sample); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		peak = math.Max(peak, math.Abs(float64(sample))/math.MaxInt16)
+		if n++; n == waveformBlock {
+			peaks = append(peaks, math.Min(peak, 1))
+			peak, n = 0, 0
+		}
+	}
+	if n > 0 {
+		peaks = append(peaks, math.Min(peak, 1))
+	}
+	return peaks, nil
+}
+
+// resamplePeaks reduces peaks to at most points values, keeping the largest
+// peak of each span so short transients stay visible.
+func resamplePeaks(peaks []float64, points int) []float64 {
+	if len(peaks) <= points {
+		return peaks
+	}
+	resampled := make([]float64, points)
+	for i := range resampled {
+		start, end := i*len(peaks)/points, (i+1)*len(peaks)/points
+		for _, p := range peaks[start:end] {
+			resampled[i] = math.Max(resampled[i], p)
+		}
+	}
+	return resampled
+}
+
+// getTrackWaveform serves the peaks of a track, optionally reduced to
+// ?points=N for narrow displays.
+func getTrackWaveform(c *gin.Context) {
+	points := waveformPoints
+	if v := c.Query("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "points must be a positive integer"})
+			return
+		}
+		points = n
+	}
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	id := c.Param("id")
+	i := findTrack(id)
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	peaks, ok := waveforms[id]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track has no waveform yet"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"trackId":  id,
+		"duration": tracks[i].Duration,
+		"peaks":    resamplePeaks(peaks, points),
+	})
+}
+
+func dumpWaveforms() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.Marshal(waveforms)
+}
+
+func restoreWaveforms(data []byte) (func(), int, error) {
+	var restored map[string][]float64
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	if restored == nil {
+		restored = map[string][]float64{}
+	}
+	apply := func() {
+		albumsMu.Lock()
+		waveforms = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
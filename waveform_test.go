@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Peaks keep the loudest sample of each block and survive resampling
+func TestReadPeaks(t *testing.T) {
+	samples := make([]int16, 3*waveformBlock)
+	samples[10] = 16384
+	samples[waveformBlock+5] = -32767
+	var pcm bytes.Buffer
+	binary.Write(&pcm, binary.LittleEndian, samples)
+
+	peaks, err := readPeaks(&pcm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peaks) != 3 || peaks[0] < 0.49 || peaks[0] > 0.51 || peaks[1] != 1 || peaks[2] != 0 {
+		t.Errorf("Expected peaks [0.5 1 0], but got %v", peaks)
+	}
+	if got := resamplePeaks(peaks, 1); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Expected [1], but got %v", got)
+	}
+}
+
+func TestGetTrackWaveform(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+	tracks = []track{{ID: "1", Title: "Song", Duration: 200}}
+
+	// Check tracks without peaks have no waveform
+	if rr := performRequest(router, "GET", "/tracks/1/waveform", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Check stored peaks are kept off the track and served downsampled
+	performRequest(router, "PUT", "/tracks/1/analysis", strings.NewReader(`{"bpm": 120, "peaks": [0.1, 0.9, 0.3, 0.2]}`), "alice")
+	if tracks[0].Analysis == nil || tracks[0].Analysis.Peaks != nil {
+		t.Errorf("Expected peaks to be stored apart from the track, but got %+v", tracks[0].Analysis)
+	}
+	rr := performRequest(router, "GET", "/tracks/1/waveform?points=2", nil, "")
+	var response struct {
+		Peaks []float64 `json:"peaks"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Peaks) != 2 || response.Peaks[0] != 0.9 || response.Peaks[1] != 0.3 {
+		t.Errorf("Expected peaks [0.9 0.3], but got %v", response.Peaks)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wishItem is an album a user wants.
+type wishItem struct {
+	ID     string `json:"id"`
+	Owner  string `json:"owner"`
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	// ReleaseDate is when the album comes out, as YYYY-MM-DD, if known.
+	ReleaseDate string `json:"releaseDate,omitempty"`
+	Note        string `json:"note,omitempty"`
+	// AlbumID is the store album whose price is watched, and PriceBelow the
+	// price under which the owner is told; see watchPrices. AlertedPrice is
+	// the price last alerted, until it rises to PriceBelow again.
+	AlbumID      string    `json:"albumId,omitempty"`
+	PriceBelow   *float64  `json:"priceBelow,omitempty"`
+	AlertedPrice *float64  `json:"alertedPrice,omitempty"`
+	AddedAt      time.Time `json:"addedAt"`
+}
+
+// wishInput is the body of POST and PUT /wishlist requests.
+type wishInput struct {
+	Artist      string   `json:"artist"`
+	Title       string   `json:"title"`
+	ReleaseDate string   `json:"releaseDate"`
+	Note        string   `json:"note"`
+	AlbumID     string   `json:"albumId"`
+	PriceBelow  *float64 `json:"priceBelow"`
+}
+
+// wishlist is guarded by albumsMu.
+var wishlist []wishItem
+
+// findWish returns the index of the user's wishlist item with the given
+// id, or -1. Callers must hold albumsMu.
+func findWish(id, owner string) int {
+	for i, w := range wishlist {
+		if w.ID == id && w.Owner == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// bindWish reads and checks a wishlist item from the request body,
+// answering the request when it is not valid.
+func bindWish(c *gin.Context) (wishInput, bool) {
+	var input wishInput
+
+	if err := c.BindJSON(&input); err != nil {
+		return input, false
+	}
+	input.Artist, input.Title = strings.TrimSpace(input.Artist), strings.TrimSpace(input.Title)
+	if input.Title == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "title is required"})
+		return input, false
+	}
+	if input.ReleaseDate != "" {
+		if _, err := time.Parse(time.DateOnly, input.ReleaseDate); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "releaseDate must be YYYY-MM-DD"})
+			return input, false
+		}
+	}
+	if input.PriceBelow != nil && (input.AlbumID == "" || *input.PriceBelow <= 0) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "priceBelow must be positive and needs an albumId"})
+		return input, false
+	}
+	return input, true
+}
+
+// getWishlist lists the user's wishlist, soonest release first and items
+// without a date last.
+func getWishlist(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	owned := []wishItem{}
+	for _, w := range wishlist {
+		if w.Owner == user {
+			owned = append(owned, w)
+		}
+	}
+	sort.SliceStable(owned, func(i, j int) bool {
+		a, b := owned[i].ReleaseDate, owned[j].ReleaseDate
+		return a != "" && (b == "" || a < b)
+	})
+	respondList(c, owned)
+}
+
+func postWish(c *gin.Context) {
+	input, ok := bindWish(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	if input.AlbumID != "" && findAlbum(input.AlbumID) < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album not found"})
+		return
+	}
+	w := wishItem{
+		ID:          newID(),
+		Owner:       c.GetString(gin.AuthUserKey),
+		Artist:      input.Artist,
+		Title:       input.Title,
+		ReleaseDate: input.ReleaseDate,
+		Note:        input.Note,
+		AlbumID:     input.AlbumID,
+		PriceBelow:  input.PriceBelow,
+		AddedAt:     time.Now().UTC(),
+	}
+	wishlist = append(wishlist, w)
+	c.IndentedJSON(http.StatusCreated, w)
+}
+
+func putWish(c *gin.Context) {
+	input, ok := bindWish(c)
+	if !ok {
+		return
+	}
+
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findWish(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "wishlist item not found"})
+		return
+	}
+	if input.AlbumID != "" && findAlbum(input.AlbumID) < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "album not found"})
+		return
+	}
+	w := &wishlist[i]
+	if w.AlbumID != input.AlbumID || !equalPrice(w.PriceBelow, input.PriceBelow) {
+		w.AlertedPrice = nil
+	}
+	w.Artist, w.Title, w.ReleaseDate, w.Note = input.Artist, input.Title, input.ReleaseDate, input.Note
+	w.AlbumID, w.PriceBelow = input.AlbumID, input.PriceBelow
+	c.IndentedJSON(http.StatusOK, *w)
+}
+
+func deleteWish(c *gin.Context) {
+	albumsMu.Lock()
+	defer albumsMu.Unlock()
+
+	i := findWish(c.Param("id"), c.GetString(gin.AuthUserKey))
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "wishlist item not found"})
+		return
+	}
+	wishlist = append(wishlist[:i], wishlist[i+1:]...)
+	c.Status(http.StatusNoContent)
+}
+
+func wishlistSignature(user string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("wishlist\n" + user))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getWishlistCalendarURL returns the address of the user's release
+// calendar. Calendar apps cannot log in, so the address carries a
+// signature instead; it lasts as long as the signing key.
+func getWishlistCalendarURL(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+	query := url.Values{"user": {user}, "sig": {wishlistSignature(user)}}
+	c.IndentedJSON(http.StatusOK, gin.H{"url": absoluteURL(c, "/feeds/wishlist.ics?"+query.Encode())})
+}
+
+// icalEscape escapes text for an iCalendar property value.
+var icalEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icalLine folds a content line at 75 octets, as RFC 5545 asks, without
+// splitting a UTF-8 sequence.
+func icalLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// getWishlistCalendar publishes the release dates on a user's wishlist as
+// an iCalendar feed of all-day events. It takes the signed address from
+// getWishlistCalendarURL, or the user's own login.
+func getWishlistCalendar(c *gin.Context) {
+	user := c.GetString(gin.AuthUserKey)
+	if user == "" {
+		user = c.Query("user")
+		if user == "" || !hmac.Equal([]byte(c.Query("sig")), []byte(wishlistSignature(user))) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"message": "invalid calendar signature"})
+			return
+		}
+	}
+
+	albumsMu.RLock()
+	var dated []wishItem
+	for _, w := range wishlist {
+		if w.Owner == user && w.ReleaseDate != "" {
+			dated = append(dated, w)
+		}
+	}
+	albumsMu.RUnlock()
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	var b strings.Builder
+	for _, line := range []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//go-music-player//wishlist//EN", "CALSCALE:GREGORIAN", "X-WR-CALNAME:Album releases"} {
+		icalLine(&b, line)
+	}
+	for _, w := range dated {
+		day, _ := time.Parse(time.DateOnly, w.ReleaseDate)
+		summary := w.Title
+		if w.Artist != "" {
+			summary = w.Artist + " – " + w.Title
+		}
+		lines := []string{
+			"BEGIN:VEVENT",
+			"UID:" + w.ID + "@wishlist",
+			"DTSTAMP:" + stamp,
+			"DTSTART;VALUE=DATE:" + day.Format("20060102"),
+			"DTEND;VALUE=DATE:" + day.AddDate(0, 0, 1).Format("20060102"),
+			"SUMMARY:" + icalEscape.Replace("Release: "+summary),
+			"TRANSP:TRANSPARENT",
+		}
+		if w.Note != "" {
+			lines = append(lines, "DESCRIPTION:"+icalEscape.Replace(w.Note))
+		}
+		for _, line := range append(lines, "END:VEVENT") {
+			icalLine(&b, line)
+		}
+	}
+	icalLine(&b, "END:VCALENDAR")
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(b.String()))
+}
+
+func dumpWishlist() ([]byte, error) {
+	albumsMu.RLock()
+	defer albumsMu.RUnlock()
+
+	return json.MarshalIndent(wishlist, "", "    ")
+}
+
+func restoreWishlist(data []byte) (func(), int, error) {
+	var restored []wishItem
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		albumsMu.Lock()
+		wishlist = restored
+		albumsMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWishlist(t *testing.T) {
+	resetCatalog(t)
+	router := setupRouter(testConfig())
+
+	// Check items are listed soonest release first, undated last
+	for _, body := range []string{
+		`{"title": "Someday"}`,
+		`{"artist": "Nirvana", "title": "In Utero", "releaseDate": "2024-09-21", "note": "Deluxe, vinyl; with poster"}`,
+		`{"artist": "Pixies", "title": "Doolittle", "releaseDate": "2024-04-17"}`,
+	} {
+		if rr := performRequest(router, "POST", "/wishlist", strings.NewReader(body), "bob"); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, but got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+	}
+	rr := performRequest(router, "GET", "/wishlist", nil, "bob")
+	var items []wishItem
+	json.Unmarshal(rr.Body.Bytes(), &items)
+	if len(items) != 3 || items[0].Title != "Doolittle" || items[2].Title != "Someday" {
+		t.Fatalf("Expected 3 items in release order, but got %+v", items)
+	}
+	if rr := performRequest(router, "POST", "/wishlist", strings.NewReader(`{"title": "X", "releaseDate": "next week"}`), "bob"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Check other users can neither see nor change the items
+	rr = performRequest(router, "GET", "/wishlist", nil, "alice")
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected an empty wishlist, but got %s", rr.Body)
+	}
+	if rr := performRequest(router, "DELETE", "/wishlist/"+items[2].ID, nil, "alice"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	rr = performRequest(router, "PUT", "/wishlist/"+items[2].ID, strings.NewReader(`{"title": "Someday", "releaseDate": "2025-01-01"}`), "bob")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Check the signed calendar feed has an escaped all-day event per dated item
+	rr = performRequest(router, "GET", "/wishlist/calendar", nil, "bob")
+	var link struct{ URL string }
+	json.Unmarshal(rr.Body.Bytes(), &link)
+	u, err := url.Parse(link.URL)
+	if err != nil || u.Path != "/feeds/wishlist.ics" {
+		t.Fatalf("Expected a calendar address, but got %q", link.URL)
+	}
+	rr = performRequest(router, "GET", u.RequestURI(), nil, "")
+	cal := rr.Body.String()
+	if rr.Code != http.StatusOK || strings.Count(cal, "BEGIN:VEVENT") != 3 {
+		t.Fatalf("Expected 3 events, but got %d: %s", rr.Code, cal)
+	}
+	for _, want := range []string{"DTSTART;VALUE=DATE:20240921\r\n", "DTEND;VALUE=DATE:20240922\r\n", `DESCRIPTION:Deluxe\, vinyl\; with poster`, "SUMMARY:Release: Pixies – Doolittle\r\n"} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("Expected %q in the calendar, but got %s", want, cal)
+		}
+	}
+	query := u.Query()
+	query.Set("user", "alice")
+	if rr := performRequest(router, "GET", "/feeds/wishlist.ics?"+query.Encode(), nil, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for another user, but got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestICalLine(t *testing.T) {
+	// Check long lines fold at 75 octets without splitting characters
+	var b strings.Builder
+	icalLine(&b, "SUMMARY:"+strings.Repeat("é", 60))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n ")
+	if len(lines) != 2 || len(lines[0]) > 75 || strings.Join(lines, "") != "SUMMARY:"+strings.Repeat("é", 60) {
+		t.Errorf("Expected the line folded once, but got %q", b.String())
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// referenceLoudness is the level, in LUFS, that analyzed tracks are
+// normalized to before a zone's calibration offset is added.
+const referenceLoudness = -14.0
+
+// maxCalibration bounds zone calibration offsets, in dB.
+const maxCalibration = 20.0
+
+var zoneIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// zone is an output device or room. Its ID doubles as the output name used
+// for equalizer presets.
+type zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// CalibrationOffset is added to every track's gain in this zone so
+	// speakers in different rooms play at the same perceived loudness.
+	CalibrationOffset float64 `json:"calibrationOffset"`
+}
+
+var (
+	zonesMu sync.RWMutex
+	zones   = map[string]zone{}
+)
+
+// playbackGain returns the gain in dB to apply to t in zone z: the
+// loudness normalization from analysis, if any, plus the zone offset.
+func playbackGain(z zone, t track) float64 {
+	gain := z.CalibrationOffset
+	if t.Analysis != nil && t.Analysis.Loudness != 0 {
+		gain += referenceLoudness - t.Analysis.Loudness
+	}
+	return math.Round(gain*100) / 100
+}
+
+func getZones(c *gin.Context) {
+	zonesMu.RLock()
+	list := []zone{}
+	for _, z := range zones {
+		list = append(list, z)
+	}
+	zonesMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	c.IndentedJSON(http.StatusOK, list)
+}
+
+func putZone(c *gin.Context) {
+	var z zone
+
+	if err := c.BindJSON(&z); err != nil {
+		return
+	}
+	z.ID = c.Param("id")
+	if !zoneIDPattern.MatchString(z.ID) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "zone ids must be lower-case letters, digits and dashes"})
+		return
+	}
+	if math.Abs(z.CalibrationOffset) > maxCalibration {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "calibrationOffset must be between -20 and 20 dB"})
+		return
+	}
+	if z.Name == "" {
+		z.Name = z.ID
+	}
+
+	zonesMu.Lock()
+	zones[z.ID] = z
+	zonesMu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, z)
+}
+
+func deleteZone(c *gin.Context) {
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+
+	if _, ok := zones[c.Param("id")]; !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "zone not found"})
+		return
+	}
+	delete(zones, c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
+
+// getZoneGain reports the gain the playback engine applies to ?trackId= in
+// the zone.
+func getZoneGain(c *gin.Context) {
+	zonesMu.RLock()
+	z, ok := zones[c.Param("id")]
+	zonesMu.RUnlock()
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "zone not found"})
+		return
+	}
+
+	albumsMu.RLock()
+	i := findTrack(c.Query("trackId"))
+	var t track
+	if i >= 0 {
+		t = tracks[i]
+	}
+	albumsMu.RUnlock()
+	if i < 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "track not found"})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"zone": z.ID, "trackId": t.ID, "gain": playbackGain(z, t)})
+}
+
+func dumpZones() ([]byte, error) {
+	zonesMu.RLock()
+	defer zonesMu.RUnlock()
+
+	return json.MarshalIndent(zones, "", "    ")
+}
+
+func restoreZones(data []byte) (func(), int, error) {
+	var restored map[string]zone
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, 0, err
+	}
+	apply := func() {
+		zonesMu.Lock()
+		zones = restored
+		zonesMu.Unlock()
+	}
+	return apply, len(restored), nil
+}
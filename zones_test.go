@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Zone offsets are added on top of loudness normalization
+func TestZoneGain(t *testing.T) {
+	resetCatalog(t)
+	saved := zones
+	zones = map[string]zone{}
+	t.Cleanup(func() { zones = saved })
+	router := setupRouter(testConfig())
+	tracks = []track{
+		{ID: "1", Title: "Loud", Analysis: &trackAnalysis{Loudness: -8}},
+		{ID: "2", Title: "Unanalyzed"},
+	}
+
+	// Check only admins configure zones, within bounds
+	if rr := performRequest(router, "PUT", "/zones/kitchen", strings.NewReader(`{"calibrationOffset": -3}`), "bob"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := performRequest(router, "PUT", "/zones/kitchen", strings.NewReader(`{"calibrationOffset": -30}`), "alice"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	performRequest(router, "PUT", "/zones/kitchen", strings.NewReader(`{"name": "Kitchen", "calibrationOffset": -3}`), "alice")
+
+	// Check a -8 LUFS track is pulled down to the reference and then by the offset
+	rr := performRequest(router, "GET", "/zones/kitchen/gain?trackId=1", nil, "bob")
+	var response struct {
+		Gain float64 `json:"gain"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Gain != -9 {
+		t.Errorf("Expected gain -9, but got %v", response.Gain)
+	}
+
+	// Check unanalyzed tracks only get the offset
+	rr = performRequest(router, "GET", "/zones/kitchen/gain?trackId=2", nil, "bob")
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Gain != -3 {
+		t.Errorf("Expected gain -3, but got %v", response.Gain)
+	}
+}